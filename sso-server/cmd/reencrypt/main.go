@@ -0,0 +1,79 @@
+// Command reencrypt seals every encrypted column again with the current master
+// key. Run it after adding a new master key version; older versions can be
+// removed from the configuration once it has completed.
+//
+//	go run ./cmd/reencrypt [-dry-run] [-decrypt]
+//	go run ./cmd/reencrypt -generate-key
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+
+	"github.com/sso-project/sso-server/internal/config"
+	"github.com/sso-project/sso-server/internal/database"
+	"github.com/sso-project/sso-server/internal/encryption"
+	"github.com/sso-project/sso-server/internal/models"
+)
+
+// encryptedModels lists the models with `serializer:encrypted` columns
+var encryptedModels = []interface{}{
+	&models.TwoFactorAuth{},
+	&models.OAuthClient{},
+	&models.OIDCConnection{},
+	&models.ProvisioningConnector{},
+}
+
+func main() {
+	dryRun := flag.Bool("dry-run", false, "count the values to re-encrypt without writing them")
+	decrypt := flag.Bool("decrypt", false, "write plaintext back, before downgrading to a version without encryption")
+	generateKey := flag.Bool("generate-key", false, "print a new random master key and exit")
+	flag.Parse()
+
+	if *generateKey {
+		key, err := encryption.GenerateMasterKey()
+		if err != nil {
+			log.Fatalf("Failed to generate master key: %v", err)
+		}
+		fmt.Println(key)
+		return
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+
+	keyring, err := encryption.Load(cfg.Encryption.MasterKeyFile, cfg.Encryption.MasterKeys, cfg.JWT.Secret)
+	if err != nil {
+		log.Fatalf("Failed to load encryption master keys: %v", err)
+	}
+
+	db, err := database.New(cfg)
+	if err != nil {
+		log.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer db.Close()
+
+	results, err := encryption.RotateColumns(context.Background(), db.DB, keyring, encryptedModels, encryption.RotateOptions{
+		DryRun:  *dryRun,
+		Decrypt: *decrypt,
+	})
+	for _, result := range results {
+		fmt.Printf("%s.%s: %d of %d values updated\n", result.Table, result.Column, result.Updated, result.Scanned)
+	}
+	if err != nil {
+		log.Fatalf("Re-encryption stopped: %v", err)
+	}
+
+	switch {
+	case *dryRun:
+		fmt.Println("Dry run: nothing was written")
+	case *decrypt:
+		fmt.Println("All values are stored in plaintext")
+	default:
+		fmt.Printf("All values are encrypted with master key version %d\n", keyring.CurrentVersion())
+	}
+}
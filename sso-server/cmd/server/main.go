@@ -18,6 +18,7 @@ import (
 	"github.com/gofiber/fiber/v2/middleware/recover"
 	"github.com/sso-project/sso-server/internal/config"
 	"github.com/sso-project/sso-server/internal/database"
+	"github.com/sso-project/sso-server/internal/encryption"
 	"github.com/sso-project/sso-server/internal/handler"
 	appLogger "github.com/sso-project/sso-server/internal/logger"
 	"github.com/sso-project/sso-server/internal/middleware"
@@ -40,6 +41,16 @@ func main() {
 
 	appLog.Info("Starting SSO Server...")
 
+	// Sensitive columns are encrypted with the master keys
+	keyring, err := encryption.Load(cfg.Encryption.MasterKeyFile, cfg.Encryption.MasterKeys, cfg.JWT.Secret)
+	if err != nil {
+		appLog.Fatal("Failed to load encryption master keys", "error", err)
+	}
+	encryption.SetKeyring(keyring)
+	if keyring.CurrentVersion() == 0 {
+		appLog.Warn("No encryption master key configured; encrypting with a key derived from JWT_SECRET")
+	}
+
 	// Initialize database
 	db, err := database.New(cfg)
 	if err != nil {
@@ -56,6 +67,11 @@ func main() {
 		&models.Permission{},
 		&models.Session{},
 		&models.TwoFactorAuth{},
+		&models.WebAuthnCredential{},
+		&models.WebAuthnChallenge{},
+		&models.OTPFactor{},
+		&models.OTPCode{},
+		&models.SMSDelivery{},
 		&models.OAuthClient{},
 		&models.PasswordResetToken{},
 		&models.PasswordHistory{},
@@ -66,7 +82,23 @@ func main() {
 		&models.OAuth2AccessToken{},
 		&models.OAuth2RefreshToken{},
 		&models.OAuth2Consent{},
+		&models.OAuth2ConsentHistory{},
 		&models.OAuth2Scope{},
+		&models.OAuth2PairwiseSubject{},
+		&models.ServiceAccount{},
+		&models.OAuth2TrustedIssuer{},
+		&models.OIDCConnection{},
+		&models.UserIdentity{},
+		&models.SAMLServiceProvider{},
+		&models.SAMLConnection{},
+		&models.SAMLAssertionID{},
+		&models.CASService{},
+		&models.CASTicket{},
+		&models.SCIMClient{},
+		&models.SCIMExternalID{},
+		&models.ProvisioningConnector{},
+		&models.ProvisioningJob{},
+		&models.ProvisioningState{},
 		// &models.OAuth2Scope{}, // Ensure this model exists if used
 	); err != nil {
 		appLog.Fatal("Failed to auto-migrate schema", "error", err)
@@ -118,6 +150,10 @@ func main() {
 	roleRepo := repository.NewRoleRepository(db)
 	permissionRepo := repository.NewPermissionRepository(db)
 	configRepo := repository.NewConfigRepository(db)
+	twoFactorRepo := repository.NewTwoFactorRepository(db.DB)
+	webauthnRepo := repository.NewWebAuthnRepository(db.DB)
+	otpRepo := repository.NewOTPRepository(db.DB)
+	smsRepo := repository.NewSMSRepository(db.DB)
 
 	appLog.Info("Repositories initialized")
 
@@ -158,7 +194,38 @@ func main() {
 		cfg.Security.AccountLockoutDuration,
 	)
 
-	totpService := service.NewTOTPService(userRepo, "SSO Server")
+	totpIssuer := cfg.TwoFA.Issuer
+	if totpIssuer == "" {
+		totpIssuer = "SSO Server"
+	}
+	totpPeriod := cfg.TwoFA.Period
+	if totpPeriod == 0 {
+		totpPeriod = 30
+	}
+	totpDigits := cfg.TwoFA.Digits
+	if totpDigits == 0 {
+		totpDigits = 6
+	}
+	totpAlgorithm := cfg.TwoFA.Algorithm
+	if totpAlgorithm == "" {
+		totpAlgorithm = "SHA1"
+	}
+	totpLockoutDuration := cfg.TwoFA.LockoutDuration
+	if totpLockoutDuration == 0 {
+		totpLockoutDuration = 15 * time.Minute
+	}
+	totpService, err := service.NewTOTPService(userRepo, twoFactorRepo, service.TOTPOptions{
+		Issuer:          totpIssuer,
+		Period:          totpPeriod,
+		Digits:          totpDigits,
+		Algorithm:       totpAlgorithm,
+		Skew:            cfg.TwoFA.Skew,
+		MaxAttempts:     cfg.TwoFA.MaxAttempts,
+		LockoutDuration: totpLockoutDuration,
+	})
+	if err != nil {
+		appLog.Fatal("Failed to initialize TOTP", "error", err)
+	}
 
 	passwordPolicy := utils.PasswordPolicy{
 		MinLength:      cfg.Security.PasswordMinLength,
@@ -184,6 +251,20 @@ func main() {
 	oauth2CodeRepo := repository.NewOAuth2CodeRepository(db.DB)
 	oauth2TokenRepo := repository.NewOAuth2TokenRepository(db.DB)
 	oauth2ConsentRepo := repository.NewOAuth2ConsentRepository(db.DB)
+	oauth2SubjectRepo := repository.NewOAuth2SubjectRepository(db.DB)
+	serviceAccountRepo := repository.NewServiceAccountRepository(db.DB)
+	oauth2TrustedIssuerRepo := repository.NewOAuth2TrustedIssuerRepository(db.DB)
+
+	// Initialize federation repositories
+	oidcConnectionRepo := repository.NewOIDCConnectionRepository(db.DB)
+	userIdentityRepo := repository.NewUserIdentityRepository(db.DB)
+	samlServiceProviderRepo := repository.NewSAMLServiceProviderRepository(db.DB)
+	samlConnectionRepo := repository.NewSAMLConnectionRepository(db.DB)
+	casServiceRepo := repository.NewCASServiceRepository(db.DB)
+
+	// Initialize provisioning repositories
+	scimClientRepo := repository.NewSCIMClientRepository(db.DB)
+	provisioningRepo := repository.NewProvisioningRepository(db.DB)
 
 	// Initialize Role, Permission & Config services
 	permissionService := service.NewPermissionService(permissionRepo)
@@ -192,29 +273,274 @@ func main() {
 
 	// Initialize OAuth2 services
 	oauth2ClientService := service.NewOAuth2ClientService(oauth2ClientRepo, oauth2ScopeRepo)
+
+	// Pairwise subjects need their own secret: changing it changes every pairwise subject
+	if cfg.OAuth2.PairwiseSecret != "" {
+		oauth2ClientService.EnablePairwiseSubjects()
+	} else {
+		pairwiseClients, err := oauth2ClientRepo.CountBySubjectType(context.Background(), service.SubjectTypePairwise)
+		if err != nil {
+			appLog.Fatal("Failed to count pairwise clients", "error", err)
+		}
+		if pairwiseClients > 0 {
+			appLog.Fatal("OAUTH2_PAIRWISE_SECRET is required while clients use pairwise subjects", "clients", pairwiseClients)
+		}
+	}
+	oauth2SubjectService := service.NewOAuth2SubjectService(oauth2SubjectRepo, oauth2ClientRepo, cfg.OAuth2.PairwiseSecret)
+
 	oauth2TokenService := service.NewOAuth2TokenService(
 		oauth2TokenRepo,
+		oauth2ClientRepo,
 		jwtService,
+		oauth2SubjectService,
 		cfg.OAuth2.AccessTokenExpiry,
 		cfg.OAuth2.RefreshTokenExpiry,
 	)
+	oauth2TokenService.AllowIntrospection(strings.Split(cfg.OAuth2.ResourceServers, ",")...)
 	oauth2AuthzService := service.NewOAuth2AuthorizationService(
 		oauth2CodeRepo,
 		oauth2ClientRepo,
 		oauth2ConsentRepo,
+		serviceAccountRepo,
 		oauth2TokenService,
 		cfg.OAuth2.AuthCodeExpiry,
 		cfg.OAuth2.EnforcePKCE,
 	)
 	oauth2ConsentService := service.NewOAuth2ConsentService(oauth2ConsentRepo, oauth2ClientRepo, oauth2ScopeRepo)
+	oauth2ScopeService := service.NewOAuth2ScopeService(oauth2ScopeRepo, oauth2ClientRepo)
+	serviceAccountService := service.NewServiceAccountService(serviceAccountRepo, oauth2ClientRepo, roleRepo)
+
+	// Fetched JWK sets of trusted issuers are reused for this long
+	jwksCacheTTL := cfg.OAuth2.JWKSCacheTTL
+	if jwksCacheTTL == 0 {
+		jwksCacheTTL = 10 * time.Minute
+	}
+	oauth2TrustedIssuerService := service.NewOAuth2TrustedIssuerService(oauth2TrustedIssuerRepo, oauth2ClientRepo, serviceAccountRepo, jwksCacheTTL)
+
+	// Hosted OAuth2 pages (login, 2FA, consent, errors) unless an external UI is configured
+	publicBaseURL := cfg.OAuth2.PublicBaseURL
+	if publicBaseURL == "" {
+		publicBaseURL = cfg.Server.BaseURL
+	}
+
+	// Upstream OIDC sign-in; provider metadata and keys share the JWKS cache TTL
+	upstreamOIDCService := service.NewUpstreamOIDCService(
+		oidcConnectionRepo,
+		userIdentityRepo,
+		userRepo,
+		roleRepo,
+		sessionService,
+		auditRepo,
+		publicBaseURL,
+		jwksCacheTTL,
+	)
+
+	// SAML identity provider; without a configured key pair assertions are signed
+	// with a throwaway certificate that changes on every restart
+	samlKey, samlCert, err := service.LoadSAMLKeyPair(cfg.SAML.CertFile, cfg.SAML.KeyFile)
+	if err != nil {
+		appLog.Fatal("Failed to load SAML signing key pair", "error", err)
+	}
+	if cfg.SAML.CertFile == "" {
+		appLog.Warn("SAML_IDP_CERT_FILE not set - using a temporary SAML signing certificate; service providers must re-import metadata after restarts")
+	}
+
+	// Security keys and passkeys, as second factor and for passwordless sign-in;
+	// they only work on the origins listed here
+	webauthnRPName := cfg.WebAuthn.RPName
+	if webauthnRPName == "" {
+		webauthnRPName = "SSO Server"
+	}
+	webauthnChallengeTTL := cfg.WebAuthn.ChallengeTTL
+	if webauthnChallengeTTL == 0 {
+		webauthnChallengeTTL = 5 * time.Minute
+	}
+	webauthnOrigins := append([]string{publicBaseURL, cfg.OAuth2.ExternalUIURL}, strings.Split(cfg.WebAuthn.Origins, ",")...)
+	webauthnService, err := service.NewWebAuthnService(webauthnRepo, userRepo, service.WebAuthnOptions{
+		RPID:         cfg.WebAuthn.RPID,
+		RPName:       webauthnRPName,
+		Origins:      webauthnOrigins,
+		ChallengeTTL: webauthnChallengeTTL,
+	})
+	if err != nil {
+		appLog.Fatal("Failed to initialize WebAuthn", "error", err)
+	}
+	authService.EnableWebAuthn(webauthnService)
+
+	// Second factors that send one-time codes; email codes need the email service
+	otpCodeTTL := cfg.OTP.CodeTTL
+	if otpCodeTTL == 0 {
+		otpCodeTTL = 10 * time.Minute
+	}
+	otpMaxAttempts := cfg.OTP.MaxAttempts
+	if otpMaxAttempts == 0 {
+		otpMaxAttempts = 5
+	}
+	otpResendInterval := cfg.OTP.ResendInterval
+	if otpResendInterval == 0 {
+		otpResendInterval = time.Minute
+	}
+	otpService := service.NewOTPService(otpRepo, userRepo, service.OTPOptions{
+		CodeTTL:        otpCodeTTL,
+		MaxAttempts:    otpMaxAttempts,
+		ResendInterval: otpResendInterval,
+	})
+	if emailService != nil {
+		otpService.AddSender(service.TwoFactorMethodEmail, service.NewEmailOTPSender(emailService))
+	}
+
+	// Codes by text message, and optionally by call, through the configured gateway
+	var smsProvider service.SMSProvider
+	switch cfg.SMS.Provider {
+	case "":
+		// SMS and voice codes are not offered
+	case "log":
+		smsProvider = service.NewLogSMSProvider(cfg.SMS.LogFile)
+		appLog.Warn("SMS_PROVIDER=log - text messages are logged, not sent")
+	case "webhook":
+		if cfg.SMS.WebhookURL == "" {
+			appLog.Fatal("SMS_WEBHOOK_URL is required with SMS_PROVIDER=webhook")
+		}
+		smsTimeout := cfg.SMS.WebhookTimeout
+		if smsTimeout == 0 {
+			smsTimeout = 10 * time.Second
+		}
+		smsProvider = service.NewWebhookSMSProvider(cfg.SMS.WebhookURL, cfg.SMS.WebhookSecret, smsTimeout)
+	default:
+		appLog.Fatal("Unknown SMS_PROVIDER", "provider", cfg.SMS.Provider)
+	}
+	if smsProvider != nil {
+		smsRateLimit := cfg.SMS.RateLimit
+		if smsRateLimit == 0 {
+			smsRateLimit = 5
+		}
+		smsRateWindow := cfg.SMS.RateWindow
+		if smsRateWindow == 0 {
+			smsRateWindow = time.Hour
+		}
+		smsService := service.NewSMSService(smsProvider, smsRepo, service.SMSOptions{
+			AllowedCountryCodes: strings.Split(cfg.SMS.AllowedCountryCodes, ","),
+			RateLimit:           smsRateLimit,
+			RateWindow:          smsRateWindow,
+		})
+		otpService.AddSender(service.TwoFactorMethodSMS, service.NewSMSOTPSender(smsService, false))
+		if cfg.SMS.Voice {
+			otpService.AddSender(service.TwoFactorMethodVoice, service.NewSMSOTPSender(smsService, true))
+		}
+	}
+	authService.EnableOTP(otpService)
+
+	samlIdPService := service.NewSAMLIdPService(samlServiceProviderRepo, userRepo, publicBaseURL, samlKey, samlCert)
+
+	// SAML sign-in through upstream identity providers, with the same key pair
+	samlClockSkew := cfg.SAML.ClockSkew
+	if samlClockSkew == 0 {
+		samlClockSkew = 3 * time.Minute
+	}
+	samlSPService := service.NewSAMLSPService(
+		samlConnectionRepo,
+		userIdentityRepo,
+		userRepo,
+		roleRepo,
+		sessionService,
+		auditRepo,
+		publicBaseURL,
+		samlKey,
+		samlCert,
+		samlClockSkew,
+	)
+
+	// CAS server for legacy applications; tickets are short-lived and single-use
+	casTicketTTL := cfg.CAS.TicketTTL
+	if casTicketTTL == 0 {
+		casTicketTTL = 10 * time.Second
+	}
+	casServerService := service.NewCASServerService(casServiceRepo, userRepo, sessionService, publicBaseURL, casTicketTTL)
+
+	// SCIM 2.0 provisioning of users and groups (roles) by HR and IGA tools
+	scimService := service.NewSCIMService(scimClientRepo, userRepo, roleRepo, sessionService, publicBaseURL)
+
+	// Outbound SCIM provisioning of users to downstream applications
+	provisioningService := service.NewProvisioningService(provisioningRepo, oauth2ClientRepo, userRepo, roleRepo, service.ProvisioningOptions{
+		PollInterval: cfg.Provisioning.PollInterval,
+		MaxAttempts:  cfg.Provisioning.MaxAttempts,
+		RetryBackoff: cfg.Provisioning.RetryBackoff,
+		Timeout:      cfg.Provisioning.Timeout,
+	})
+	go provisioningService.Run(context.Background())
+
+	// LDAP directory as authentication backend for the users it knows
+	var directoryService *service.DirectoryService
+	if cfg.LDAP.URL != "" {
+		ldapBackend, err := service.NewLDAPBackend(service.LDAPOptions{
+			URL:          cfg.LDAP.URL,
+			StartTLS:     cfg.LDAP.StartTLS,
+			CACertFile:   cfg.LDAP.CACertFile,
+			BindDN:       cfg.LDAP.BindDN,
+			BindPassword: cfg.LDAP.BindPassword,
+			BaseDN:       cfg.LDAP.BaseDN,
+			UserFilter:   cfg.LDAP.UserFilter,
+			SyncFilter:   cfg.LDAP.SyncFilter,
+			IDAttribute:  cfg.LDAP.IDAttribute,
+			EmailAttr:    cfg.LDAP.EmailAttribute,
+			NameAttr:     cfg.LDAP.NameAttribute,
+			GroupAttr:    cfg.LDAP.GroupAttribute,
+			Timeout:      cfg.LDAP.Timeout,
+		})
+		if err != nil {
+			appLog.Fatal("Failed to configure LDAP directory", "error", err)
+		}
+		directoryService = service.NewDirectoryService(ldapBackend, userIdentityRepo, userRepo, roleRepo, sessionService, auditRepo, cfg.LDAP.RoleMappings)
+		authService.AddDirectory(directoryService)
+
+		if cfg.LDAP.SyncInterval > 0 {
+			go directoryService.Schedule(context.Background(), cfg.LDAP.SyncInterval)
+		}
+		appLog.Info("LDAP directory enabled", "url", cfg.LDAP.URL, "sync_interval", cfg.LDAP.SyncInterval.String())
+	}
+
+	// Linking of external identities; a sign-in this recent counts as re-authentication
+	reauthMaxAge := cfg.Identity.ReauthMaxAge
+	if reauthMaxAge == 0 {
+		reauthMaxAge = 5 * time.Minute
+	}
+	identityService := service.NewIdentityService(userIdentityRepo, userRepo, authService, totpService, sessionService, cfg.JWT.Secret, service.IdentityOptions{
+		LinkByEmail:  cfg.Identity.EmailLinking != "never",
+		ReauthMaxAge: reauthMaxAge,
+	})
+	upstreamOIDCService.EnableLinking(identityService)
+	samlSPService.EnableLinking(identityService)
 
 	appLog.Info("Services initialized")
 
+	oauth2Pages, err := handler.NewOAuth2Pages("templates/oauth2", publicBaseURL, cfg.OAuth2.ExternalUIURL, cfg.Session.CookieSecure)
+	if err != nil {
+		appLog.Fatal("Failed to load OAuth2 page templates", "error", err)
+	}
+
 	// Initialize handlers
-	authHandler := handler.NewAuthHandler(authService, jwtService, totpService)
+	authHandler := handler.NewAuthHandler(authService, jwtService, totpService, otpService)
 	passwordHandler := handler.NewPasswordHandler(passwordService, emailService)
-	oauth2Handler := handler.NewOAuth2Handler(oauth2AuthzService, oauth2TokenService, oauth2ClientService, oauth2ConsentService, userRepo)
-	oauth2AdminHandler := handler.NewOAuth2AdminHandler(oauth2ClientService, oauth2ConsentService)
+	oauth2Handler := handler.NewOAuth2Handler(oauth2AuthzService, oauth2TokenService, oauth2ClientService, oauth2ConsentService, oauth2ScopeService, oauth2SubjectService, oauth2TrustedIssuerService, userRepo, oauth2Pages)
+	oauth2LoginHandler := handler.NewOAuth2LoginHandler(authService, totpService, otpService, oauth2ClientService, upstreamOIDCService, samlSPService, oauth2Pages)
+	oauth2AdminHandler := handler.NewOAuth2AdminHandler(oauth2ClientService, oauth2ConsentService, oauth2TokenService, oauth2SubjectService, auditRepo, oauth2Pages)
+	oauth2ScopeHandler := handler.NewOAuth2ScopeHandler(oauth2ScopeService)
+	serviceAccountHandler := handler.NewServiceAccountHandler(serviceAccountService, auditRepo)
+	oauth2TrustedIssuerHandler := handler.NewOAuth2TrustedIssuerHandler(oauth2TrustedIssuerService, auditRepo)
+	oidcConnectionHandler := handler.NewOIDCConnectionHandler(upstreamOIDCService, auditRepo)
+	samlHandler := handler.NewSAMLHandler(samlIdPService, sessionService, auditRepo, oauth2Pages)
+	samlServiceProviderHandler := handler.NewSAMLServiceProviderHandler(samlIdPService, auditRepo)
+	samlConnectionHandler := handler.NewSAMLConnectionHandler(samlSPService, auditRepo)
+	casHandler := handler.NewCASHandler(casServerService, sessionService, auditRepo, oauth2Pages)
+	casServiceHandler := handler.NewCASServiceHandler(casServerService, auditRepo)
+	directoryHandler := handler.NewDirectoryHandler(directoryService)
+	scimHandler := handler.NewSCIMHandler(scimService, auditRepo)
+	scimClientHandler := handler.NewSCIMClientHandler(scimService, auditRepo)
+	provisioningHandler := handler.NewProvisioningHandler(provisioningService, auditRepo)
+	identityHandler := handler.NewIdentityHandler(identityService, provisioningService, auditRepo, cfg.Session.CookieSecure)
+	twoFactorService := service.NewTwoFactorService(totpService, otpService, webauthnService)
+	twoFactorHandler := handler.NewTwoFactorHandler(totpService, otpService, twoFactorService, identityService, auditRepo)
+	webauthnHandler := handler.NewWebAuthnHandler(webauthnService, authService, identityService, jwtService, auditRepo, cfg.Session.CookieSecure)
 	roleHandler := handler.NewRoleHandler(roleService)
 	permissionHandler := handler.NewPermissionHandler(permissionService)
 	configHandler := handler.NewConfigHandler(configService)
@@ -225,6 +551,7 @@ func main() {
 		oauth2ClientRepo,
 		passwordService,
 		oauth2ClientService,
+		provisioningService,
 	)
 
 	appLog.Info("Handlers initialized")
@@ -297,6 +624,20 @@ func main() {
 	auth.Post("/logout", authHandler.Logout)
 	auth.Post("/refresh", authHandler.RefreshSession)
 	auth.Post("/verify-2fa", authHandler.Verify2FA)
+	auth.Post("/2fa/send", authHandler.SendTwoFactorCode)
+
+	// Security keys and passkeys: passwordless sign-in, second factor after
+	// /auth/login, and the signed-in user's registered keys
+	auth.Post("/webauthn/login/begin", webauthnHandler.BeginLogin)
+	auth.Post("/webauthn/login/finish", webauthnHandler.FinishLogin)
+	auth.Post("/webauthn/2fa/begin", webauthnHandler.BeginSecondFactor)
+	auth.Post("/webauthn/2fa/finish", webauthnHandler.FinishSecondFactor)
+	requireSession := middleware.AuthMiddleware(sessionService)
+	auth.Post("/webauthn/register/begin", requireSession, webauthnHandler.BeginRegistration)
+	auth.Post("/webauthn/register/finish", requireSession, webauthnHandler.FinishRegistration)
+	auth.Get("/webauthn/credentials", requireSession, webauthnHandler.GetCredentials)
+	auth.Patch("/webauthn/credentials/:id", requireSession, webauthnHandler.RenameCredential)
+	auth.Delete("/webauthn/credentials/:id", requireSession, webauthnHandler.DeleteCredential)
 
 	// Password management routes
 	password := app.Group("/password")
@@ -308,12 +649,65 @@ func main() {
 
 	// OAuth2 routes
 	oauth2 := app.Group("/oauth2")
-	oauth2.Get("/authorize", oauth2Handler.Authorize)                                                            // Handler has its own auth check with redirect logic
+	oauth2.Get("/authorize", middleware.OptionalAuthMiddleware(sessionService), oauth2Handler.Authorize)         // Redirects to login when there is no session
+	oauth2.Get("/login", oauth2LoginHandler.LoginPage)                                                           // Public - hosted login page
+	oauth2.Post("/login", oauth2LoginHandler.Login)                                                              // Public - hosted login form
+	oauth2.Post("/login/2fa", oauth2LoginHandler.Verify2FA)                                                      // Public - hosted 2FA form
+	oauth2.Post("/login/2fa/method", oauth2LoginHandler.ChooseTwoFactor)                                         // Public - switch the 2FA form to another method
+	oauth2.Get("/login/connections", oauth2LoginHandler.Connections)                                             // Public - upstream providers for "Sign in with" buttons
+	oauth2.Get("/login/oidc/:slug", oauth2LoginHandler.UpstreamLogin)                                            // Public - redirect to an upstream OIDC provider
+	oauth2.Get("/login/oidc/:slug/callback", oauth2LoginHandler.UpstreamCallback)                                // Public - upstream OIDC callback
+	oauth2.Get("/login/saml/:slug", oauth2LoginHandler.SAMLLogin)                                                // Public - AuthnRequest to an upstream SAML IdP
 	oauth2.Post("/authorize/consent", middleware.AuthMiddleware(sessionService), oauth2Handler.AuthorizeConsent) // Protected - consent submission
+	oauth2.Get("/consent/details", oauth2Handler.ConsentDetails)                                                 // Public - client name & scope descriptions for consent UI
 	oauth2.Post("/token", oauth2Handler.Token)                                                                   // Public - token exchange
 	oauth2.Post("/revoke", oauth2Handler.Revoke)                                                                 // Public - token revocation
+	oauth2.Post("/introspect", oauth2Handler.Introspect)                                                         // Public (Client Auth) - token introspection
 	oauth2.Get("/userinfo", oauth2Handler.UserInfo)                                                              // Public (Bearer Auth) - user info
 
+	// SAML routes: identity provider sign-in shares the session with OAuth2, and /sp/
+	// serves this server as a service provider to upstream identity providers
+	samlRoutes := app.Group("/saml")
+	samlRoutes.Get("/metadata", samlHandler.Metadata)                                                       // Public - IdP metadata for service providers
+	samlRoutes.Get("/sso", middleware.OptionalAuthMiddleware(sessionService), samlHandler.SSO)              // HTTP-Redirect binding; redirects to login when there is no session
+	samlRoutes.Post("/sso", samlHandler.SSO)                                                                // HTTP-POST binding, rebound to a redirect
+	samlRoutes.Get("/init/:id", middleware.OptionalAuthMiddleware(sessionService), samlHandler.InitiateSSO) // IdP-initiated sign-in
+	samlRoutes.Get("/slo", middleware.OptionalAuthMiddleware(sessionService), samlHandler.SLO)              // Single Logout, HTTP-Redirect binding
+	samlRoutes.Post("/slo", samlHandler.SLO)                                                                // Single Logout, HTTP-POST binding, rebound to a redirect
+	samlRoutes.Get("/sp/:slug/metadata", oauth2LoginHandler.SAMLMetadata)                                   // Public - SP metadata for an upstream IdP
+	samlRoutes.Post("/sp/:slug/acs", oauth2LoginHandler.SAMLAssertionConsumer)                              // Public - responses from an upstream IdP
+
+	// CAS routes: sign-in shares the session with OAuth2 and SAML; validation is
+	// called by the services themselves
+	casRoutes := app.Group("/cas")
+	casRoutes.Get("/login", middleware.OptionalAuthMiddleware(sessionService), casHandler.Login)   // Redirects to login when there is no session
+	casRoutes.Get("/logout", middleware.OptionalAuthMiddleware(sessionService), casHandler.Logout) // Ends the session, with back-channel single logout
+	casRoutes.Get("/serviceValidate", casHandler.ServiceValidate)                                  // CAS 2.0
+	casRoutes.Get("/proxyValidate", casHandler.ProxyValidate)                                      // CAS 2.0, also accepts proxy tickets
+	casRoutes.Get("/p3/serviceValidate", casHandler.P3ServiceValidate)                             // CAS 3.0, with attributes
+	casRoutes.Get("/p3/proxyValidate", casHandler.P3ProxyValidate)                                 // CAS 3.0, with attributes
+	casRoutes.Get("/proxy", casHandler.Proxy)                                                      // Proxy tickets for proxy-granting tickets
+
+	// SCIM 2.0 routes, authenticated with the bearer token of a SCIM client
+	scim := app.Group("/scim/v2", middleware.SCIMAuthMiddleware(scimService))
+	scim.Get("/ServiceProviderConfig", scimHandler.GetServiceProviderConfig)
+	scim.Get("/ResourceTypes", scimHandler.GetResourceTypes)
+	scim.Get("/ResourceTypes/:id", scimHandler.GetResourceType)
+	scim.Get("/Schemas", scimHandler.GetSchemas)
+	scim.Get("/Schemas/:id", scimHandler.GetSchema)
+	scim.Get("/Users", scimHandler.ListUsers)
+	scim.Post("/Users", scimHandler.CreateUser)
+	scim.Get("/Users/:id", scimHandler.GetUser)
+	scim.Put("/Users/:id", scimHandler.ReplaceUser)
+	scim.Patch("/Users/:id", scimHandler.PatchUser)
+	scim.Delete("/Users/:id", scimHandler.DeleteUser)
+	scim.Get("/Groups", scimHandler.ListGroups)
+	scim.Post("/Groups", scimHandler.CreateGroup)
+	scim.Get("/Groups/:id", scimHandler.GetGroup)
+	scim.Put("/Groups/:id", scimHandler.ReplaceGroup)
+	scim.Patch("/Groups/:id", scimHandler.PatchGroup)
+	scim.Delete("/Groups/:id", scimHandler.DeleteGroup)
+
 	// OAuth2 admin routes (require authentication)
 	admin := app.Group("/admin")
 	admin.Use(middleware.AuthMiddleware(sessionService))
@@ -340,6 +734,12 @@ func main() {
 	adminAPI.Post("/users/:id/unlock", adminHandler.UnlockUser)
 	adminAPI.Post("/users/:id/roles/:role_id", adminHandler.AssignRole)
 	adminAPI.Delete("/users/:id/roles/:role_id", adminHandler.RemoveRole)
+	adminAPI.Get("/users/:id/provisioning", provisioningHandler.GetUserStatus)
+	adminAPI.Get("/users/:id/identities", identityHandler.GetUserIdentities)
+	adminAPI.Delete("/users/:id/identities/:identity_id", identityHandler.RemoveUserIdentity)
+	adminAPI.Post("/users/:id/merge", identityHandler.MergeUsers)
+	adminAPI.Get("/users/:id/2fa", twoFactorHandler.GetUserFactors)
+	adminAPI.Delete("/users/:id/2fa", twoFactorHandler.ResetUserFactors)
 
 	// Audit logs
 	adminAPI.Get("/audit-logs", adminHandler.GetAuditLogs)
@@ -375,13 +775,125 @@ func main() {
 	// OAuth2 clients (alternative endpoints)
 	// OAuth2 clients (alternative endpoints)
 	adminAPI.Get("/oauth2-clients", oauth2AdminHandler.GetClients)
+	adminAPI.Put("/oauth2-clients/:client_id/branding", oauth2AdminHandler.UpdateBranding)
+	adminAPI.Put("/oauth2-clients/:client_id/consent-policy", oauth2AdminHandler.UpdateConsentPolicy)
+	adminAPI.Put("/oauth2-clients/:client_id/token-settings", oauth2AdminHandler.UpdateTokenSettings)
+
+	// SAML service providers (registered next to OAuth2 clients)
+	adminAPI.Get("/saml-service-providers", samlServiceProviderHandler.GetServiceProviders)
+	adminAPI.Post("/saml-service-providers", samlServiceProviderHandler.CreateServiceProvider)
+	adminAPI.Get("/saml-service-providers/:id", samlServiceProviderHandler.GetServiceProvider)
+	adminAPI.Put("/saml-service-providers/:id", samlServiceProviderHandler.UpdateServiceProvider)
+	adminAPI.Delete("/saml-service-providers/:id", samlServiceProviderHandler.DeleteServiceProvider)
+
+	// CAS services (registered next to OAuth2 clients and SAML service providers)
+	adminAPI.Get("/cas-services", casServiceHandler.GetServices)
+	adminAPI.Post("/cas-services", casServiceHandler.CreateService)
+	adminAPI.Get("/cas-services/:id", casServiceHandler.GetService)
+	adminAPI.Put("/cas-services/:id", casServiceHandler.UpdateService)
+	adminAPI.Delete("/cas-services/:id", casServiceHandler.DeleteService)
+
+	// OAuth2 token administration
+	adminAPI.Get("/oauth2-tokens", oauth2AdminHandler.GetActiveTokens)
+	adminAPI.Post("/oauth2-tokens/revoke", oauth2AdminHandler.RevokeTokens)
+	adminAPI.Get("/oauth2-subjects/:subject", oauth2AdminHandler.LookupSubject)
+	adminAPI.Get("/oauth2-themes", oauth2AdminHandler.GetThemes)
+	adminAPI.Get("/oauth2-consent-history", oauth2AdminHandler.GetAllConsentHistory)
+
+	// Service accounts (roles carried by client_credentials tokens)
+	adminAPI.Get("/service-accounts", serviceAccountHandler.GetServiceAccounts)
+	adminAPI.Post("/service-accounts", serviceAccountHandler.CreateServiceAccount)
+	adminAPI.Get("/service-accounts/:id", serviceAccountHandler.GetServiceAccount)
+	adminAPI.Put("/service-accounts/:id", serviceAccountHandler.UpdateServiceAccount)
+	adminAPI.Delete("/service-accounts/:id", serviceAccountHandler.DeleteServiceAccount)
+	adminAPI.Post("/service-accounts/:id/roles/:role_id", serviceAccountHandler.AssignRole)
+	adminAPI.Delete("/service-accounts/:id/roles/:role_id", serviceAccountHandler.RemoveRole)
+
+	// Trusted issuers for the JWT bearer grant (workload identity federation)
+	adminAPI.Get("/oauth2-trusted-issuers", oauth2TrustedIssuerHandler.GetIssuers)
+	adminAPI.Post("/oauth2-trusted-issuers", oauth2TrustedIssuerHandler.CreateIssuer)
+	adminAPI.Get("/oauth2-trusted-issuers/:id", oauth2TrustedIssuerHandler.GetIssuer)
+	adminAPI.Put("/oauth2-trusted-issuers/:id", oauth2TrustedIssuerHandler.UpdateIssuer)
+	adminAPI.Delete("/oauth2-trusted-issuers/:id", oauth2TrustedIssuerHandler.DeleteIssuer)
+
+	// Upstream OIDC connections ("Sign in with" providers)
+	adminAPI.Get("/oidc-connections", oidcConnectionHandler.GetConnections)
+	adminAPI.Post("/oidc-connections", oidcConnectionHandler.CreateConnection)
+	adminAPI.Get("/oidc-connections/:id", oidcConnectionHandler.GetConnection)
+	adminAPI.Put("/oidc-connections/:id", oidcConnectionHandler.UpdateConnection)
+	adminAPI.Delete("/oidc-connections/:id", oidcConnectionHandler.DeleteConnection)
+
+	// Upstream SAML connections ("Sign in with" providers that only speak SAML)
+	adminAPI.Get("/saml-connections", samlConnectionHandler.GetConnections)
+	adminAPI.Post("/saml-connections", samlConnectionHandler.CreateConnection)
+	adminAPI.Get("/saml-connections/:id", samlConnectionHandler.GetConnection)
+	adminAPI.Put("/saml-connections/:id", samlConnectionHandler.UpdateConnection)
+	adminAPI.Delete("/saml-connections/:id", samlConnectionHandler.DeleteConnection)
+
+	// LDAP directory sync
+	adminAPI.Get("/directory", directoryHandler.GetStatus)
+	adminAPI.Post("/directory/sync", directoryHandler.Sync)
+
+	// SCIM client management
+	adminAPI.Get("/scim-clients", scimClientHandler.GetClients)
+	adminAPI.Post("/scim-clients", scimClientHandler.CreateClient)
+	adminAPI.Get("/scim-clients/:id", scimClientHandler.GetClient)
+	adminAPI.Put("/scim-clients/:id", scimClientHandler.UpdateClient)
+	adminAPI.Delete("/scim-clients/:id", scimClientHandler.DeleteClient)
+	adminAPI.Post("/scim-clients/:id/rotate-token", scimClientHandler.RotateToken)
+
+	// Outbound provisioning to downstream applications
+	adminAPI.Get("/provisioning-connectors", provisioningHandler.GetConnectors)
+	adminAPI.Post("/provisioning-connectors", provisioningHandler.CreateConnector)
+	adminAPI.Get("/provisioning-connectors/:id", provisioningHandler.GetConnector)
+	adminAPI.Put("/provisioning-connectors/:id", provisioningHandler.UpdateConnector)
+	adminAPI.Delete("/provisioning-connectors/:id", provisioningHandler.DeleteConnector)
+	adminAPI.Get("/provisioning-connectors/:id/status", provisioningHandler.GetStatus)
+	adminAPI.Get("/provisioning-connectors/:id/jobs", provisioningHandler.GetJobs)
+	adminAPI.Post("/provisioning-connectors/:id/sync", provisioningHandler.Sync)
+	adminAPI.Post("/provisioning-jobs/:id/retry", provisioningHandler.RetryJob)
+
+	// OAuth2 scope management
+	adminAPI.Get("/oauth2-scopes", oauth2ScopeHandler.GetScopes)
+	adminAPI.Get("/oauth2-scopes/:id", oauth2ScopeHandler.GetScope)
+	adminAPI.Post("/oauth2-scopes", oauth2ScopeHandler.CreateScope)
+	adminAPI.Put("/oauth2-scopes/:id", oauth2ScopeHandler.UpdateScope)
+	adminAPI.Delete("/oauth2-scopes/:id", oauth2ScopeHandler.DeleteScope)
 
 	// User OAuth2 consent management
 	user := app.Group("/user")
 	user.Use(middleware.AuthMiddleware(sessionService))
 	user.Get("/oauth2/consents", oauth2AdminHandler.GetUserConsents)
+	user.Get("/oauth2/consents/history", oauth2AdminHandler.GetConsentHistory)
 	user.Delete("/oauth2/consents/:client_id", oauth2AdminHandler.RevokeConsent)
 
+	// Linked identities (Google, corporate SAML, ...) of the signed-in user
+	user.Get("/identities", identityHandler.GetIdentities)
+	user.Post("/identities/link", identityHandler.BeginLink)
+	user.Delete("/identities/:id", identityHandler.Unlink)
+
+	// Second factors of the signed-in user; changes other than confirming need re-authentication
+	user.Get("/2fa", twoFactorHandler.GetFactors)
+
+	// Authenticator app (TOTP) as second factor
+	user.Post("/2fa/totp", twoFactorHandler.BeginTOTP)
+	user.Post("/2fa/totp/confirm", twoFactorHandler.ConfirmTOTP)
+	user.Delete("/2fa/totp", twoFactorHandler.DisableTOTP)
+
+	// 2FA backup codes of the signed-in user
+	user.Get("/2fa/backup-codes", twoFactorHandler.GetBackupCodes)
+	user.Post("/2fa/backup-codes", twoFactorHandler.RegenerateBackupCodes)
+
+	// Email verification codes as second factor
+	user.Post("/2fa/email", twoFactorHandler.BeginEmailFactor)
+	user.Post("/2fa/email/confirm", twoFactorHandler.ConfirmEmailFactor)
+	user.Delete("/2fa/email", twoFactorHandler.DisableEmailFactor)
+
+	// Text message or voice call codes as second factor; confirming one verifies the phone number
+	user.Post("/2fa/phone", twoFactorHandler.BeginPhoneFactor)
+	user.Post("/2fa/phone/confirm", twoFactorHandler.ConfirmPhoneFactor)
+	user.Delete("/2fa/phone", twoFactorHandler.DisablePhoneFactor)
+
 	// Protected API routes (require authentication)
 
 	api := app.Group("/api")
@@ -409,6 +921,9 @@ func main() {
 			if strings.HasPrefix(path, "/api") ||
 				strings.HasPrefix(path, "/auth") ||
 				strings.HasPrefix(path, "/oauth2") ||
+				strings.HasPrefix(path, "/saml") ||
+				strings.HasPrefix(path, "/cas") ||
+				strings.HasPrefix(path, "/scim") ||
 				strings.HasPrefix(path, "/admin") ||
 				strings.HasPrefix(path, "/user") {
 				return c.Next()
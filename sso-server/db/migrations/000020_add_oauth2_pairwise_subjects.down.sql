@@ -0,0 +1,6 @@
+-- Drop pairwise subject lookup and client subject settings
+DROP TABLE IF EXISTS oauth2_pairwise_subjects;
+
+ALTER TABLE oauth2_clients
+    DROP COLUMN sector_identifier,
+    DROP COLUMN subject_type;
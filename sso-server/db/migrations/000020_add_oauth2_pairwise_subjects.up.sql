@@ -0,0 +1,17 @@
+-- Per-client subject identifier type
+ALTER TABLE oauth2_clients
+    ADD COLUMN subject_type VARCHAR(20) NOT NULL DEFAULT 'public' AFTER owner_user_id,
+    ADD COLUMN sector_identifier VARCHAR(255) NULL AFTER subject_type;
+
+-- Reverse lookup of pairwise subject identifiers
+CREATE TABLE IF NOT EXISTS oauth2_pairwise_subjects (
+    id CHAR(36) PRIMARY KEY,
+    sector VARCHAR(255) NOT NULL,
+    subject VARCHAR(255) NOT NULL,
+    user_id CHAR(36) NOT NULL,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    UNIQUE KEY idx_sector_subject (sector, subject),
+    INDEX idx_user (user_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
@@ -0,0 +1,7 @@
+-- Drop per-client branding
+ALTER TABLE oauth2_clients
+    DROP COLUMN theme,
+    DROP COLUMN support_uri,
+    DROP COLUMN tos_uri,
+    DROP COLUMN policy_uri,
+    DROP COLUMN logo_uri;
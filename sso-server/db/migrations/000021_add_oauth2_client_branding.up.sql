@@ -0,0 +1,7 @@
+-- Per-client branding for hosted login and consent pages
+ALTER TABLE oauth2_clients
+    ADD COLUMN logo_uri VARCHAR(512) NULL AFTER sector_identifier,
+    ADD COLUMN policy_uri VARCHAR(512) NULL AFTER logo_uri,
+    ADD COLUMN tos_uri VARCHAR(512) NULL AFTER policy_uri,
+    ADD COLUMN support_uri VARCHAR(512) NULL AFTER tos_uri,
+    ADD COLUMN theme VARCHAR(100) NULL AFTER support_uri;
@@ -0,0 +1,7 @@
+-- Drop per-client consent policy
+ALTER TABLE oauth2_consents
+    DROP COLUMN implied;
+
+ALTER TABLE oauth2_clients
+    DROP COLUMN consent_policy,
+    DROP COLUMN is_first_party;
@@ -0,0 +1,7 @@
+-- Per-client consent policy; implied consents are still recorded
+ALTER TABLE oauth2_clients
+    ADD COLUMN is_first_party BOOLEAN NOT NULL DEFAULT FALSE AFTER theme,
+    ADD COLUMN consent_policy VARCHAR(20) NOT NULL DEFAULT 'once' AFTER is_first_party;
+
+ALTER TABLE oauth2_consents
+    ADD COLUMN implied BOOLEAN NOT NULL DEFAULT FALSE AFTER scopes;
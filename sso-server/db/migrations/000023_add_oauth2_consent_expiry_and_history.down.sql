@@ -0,0 +1,11 @@
+-- Drop consent history, expiry and versioning
+DROP TABLE IF EXISTS oauth2_consent_history;
+
+ALTER TABLE oauth2_consents
+    DROP COLUMN expires_at,
+    DROP COLUMN policy_version;
+
+ALTER TABLE oauth2_clients
+    DROP COLUMN required_scopes,
+    DROP COLUMN policy_version,
+    DROP COLUMN consent_lifetime;
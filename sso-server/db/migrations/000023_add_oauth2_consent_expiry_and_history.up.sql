@@ -0,0 +1,24 @@
+-- Consent lifetime, privacy policy version and required scopes per client
+ALTER TABLE oauth2_clients
+    ADD COLUMN consent_lifetime INT NOT NULL DEFAULT 0 AFTER consent_policy,
+    ADD COLUMN policy_version VARCHAR(50) NULL AFTER consent_lifetime,
+    ADD COLUMN required_scopes JSON NULL AFTER policy_version;
+
+ALTER TABLE oauth2_consents
+    ADD COLUMN policy_version VARCHAR(50) NULL AFTER implied,
+    ADD COLUMN expires_at DATETIME NULL AFTER policy_version;
+
+-- Every consent grant and revocation, kept for compliance
+CREATE TABLE IF NOT EXISTS oauth2_consent_history (
+    id CHAR(36) PRIMARY KEY,
+    user_id CHAR(36) NOT NULL,
+    client_id VARCHAR(255) NOT NULL,
+    action VARCHAR(20) NOT NULL,
+    scopes JSON NOT NULL,
+    policy_version VARCHAR(50) NULL,
+    expires_at DATETIME NULL,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+    INDEX idx_consent_history_user_client (user_id, client_id),
+    INDEX idx_created_at (created_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
@@ -0,0 +1,3 @@
+-- Drop service accounts
+DROP TABLE IF EXISTS service_account_roles;
+DROP TABLE IF EXISTS service_accounts;
@@ -0,0 +1,2 @@
+-- Drop trusted issuers
+DROP TABLE IF EXISTS oauth2_trusted_issuers;
@@ -0,0 +1,20 @@
+-- External issuers trusted for the JWT bearer grant (RFC 7523)
+CREATE TABLE IF NOT EXISTS oauth2_trusted_issuers (
+    id CHAR(36) PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    issuer VARCHAR(255) NOT NULL,
+    jwks_url VARCHAR(512) NULL,
+    jwks TEXT NULL,
+    allowed_subjects JSON NOT NULL,
+    audiences JSON NOT NULL,
+    client_id VARCHAR(255) NOT NULL,
+    service_account_id CHAR(36) NULL,
+    is_active BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+
+    FOREIGN KEY (client_id) REFERENCES oauth2_clients(client_id) ON DELETE CASCADE,
+    FOREIGN KEY (service_account_id) REFERENCES service_accounts(id) ON DELETE CASCADE,
+    UNIQUE KEY idx_issuer (issuer),
+    INDEX idx_client_id (client_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
@@ -0,0 +1,11 @@
+-- Drop per-client token settings
+ALTER TABLE oauth2_refresh_tokens
+    DROP COLUMN absolute_expires_at;
+
+ALTER TABLE oauth2_clients
+    DROP COLUMN access_token_format,
+    DROP COLUMN refresh_idle_ttl,
+    DROP COLUMN refresh_absolute_ttl,
+    DROP COLUMN refresh_token_ttl,
+    DROP COLUMN id_token_ttl,
+    DROP COLUMN access_token_ttl;
@@ -0,0 +1,12 @@
+-- Per-client token lifetimes and access token format
+ALTER TABLE oauth2_clients
+    ADD COLUMN access_token_ttl INT NOT NULL DEFAULT 0 AFTER required_scopes,
+    ADD COLUMN id_token_ttl INT NOT NULL DEFAULT 0 AFTER access_token_ttl,
+    ADD COLUMN refresh_token_ttl INT NOT NULL DEFAULT 0 AFTER id_token_ttl,
+    ADD COLUMN refresh_absolute_ttl INT NOT NULL DEFAULT 0 AFTER refresh_token_ttl,
+    ADD COLUMN refresh_idle_ttl INT NOT NULL DEFAULT 0 AFTER refresh_absolute_ttl,
+    ADD COLUMN access_token_format VARCHAR(20) NOT NULL DEFAULT 'jwt' AFTER refresh_idle_ttl;
+
+-- Rotated refresh tokens keep the expiry of the grant they started from
+ALTER TABLE oauth2_refresh_tokens
+    ADD COLUMN absolute_expires_at DATETIME NULL AFTER expires_at;
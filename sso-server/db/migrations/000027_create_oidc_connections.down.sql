@@ -0,0 +1,3 @@
+-- Drop upstream OIDC connections and linked identities
+DROP TABLE IF EXISTS user_identities;
+DROP TABLE IF EXISTS oidc_connections;
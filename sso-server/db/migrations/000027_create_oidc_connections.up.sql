@@ -0,0 +1,35 @@
+-- Upstream OpenID Connect providers users can sign in with
+CREATE TABLE IF NOT EXISTS oidc_connections (
+    id CHAR(36) PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    slug VARCHAR(100) NOT NULL,
+    discovery_url VARCHAR(512) NOT NULL,
+    client_id VARCHAR(255) NOT NULL,
+    client_secret VARCHAR(512) NULL,
+    scopes JSON NOT NULL,
+    email_claim VARCHAR(100) NOT NULL DEFAULT 'email',
+    name_claim VARCHAR(100) NOT NULL DEFAULT 'name',
+    groups_claim VARCHAR(100) NULL,
+    role_mappings JSON NOT NULL,
+    jit_provisioning BOOLEAN NOT NULL DEFAULT TRUE,
+    is_active BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+
+    UNIQUE KEY idx_slug (slug)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+-- Accounts at external identity providers linked to local users
+CREATE TABLE IF NOT EXISTS user_identities (
+    id CHAR(36) PRIMARY KEY,
+    user_id CHAR(36) NOT NULL,
+    provider VARCHAR(100) NOT NULL,
+    subject VARCHAR(255) NOT NULL,
+    email VARCHAR(255) NULL,
+    last_login_at DATETIME NULL,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    UNIQUE KEY idx_provider_subject (provider, subject),
+    INDEX idx_user_id (user_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
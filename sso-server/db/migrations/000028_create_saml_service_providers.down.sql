@@ -0,0 +1,2 @@
+-- Drop SAML service providers
+DROP TABLE IF EXISTS saml_service_providers;
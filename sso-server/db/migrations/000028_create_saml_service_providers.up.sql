@@ -0,0 +1,14 @@
+-- Applications that sign users in with SAML 2.0 through this server
+CREATE TABLE IF NOT EXISTS saml_service_providers (
+    id CHAR(36) PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    entity_id VARCHAR(512) NOT NULL,
+    metadata TEXT NOT NULL,
+    name_id_format VARCHAR(20) NOT NULL DEFAULT 'email',
+    attribute_mappings JSON NOT NULL,
+    is_active BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+
+    UNIQUE KEY idx_entity_id (entity_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
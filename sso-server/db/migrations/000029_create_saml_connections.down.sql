@@ -0,0 +1,3 @@
+-- Drop upstream SAML connections and the assertion replay cache
+DROP TABLE IF EXISTS saml_assertion_ids;
+DROP TABLE IF EXISTS saml_connections;
@@ -0,0 +1,28 @@
+-- Upstream SAML 2.0 identity providers users can sign in with
+CREATE TABLE IF NOT EXISTS saml_connections (
+    id CHAR(36) PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    slug VARCHAR(100) NOT NULL,
+    idp_entity_id VARCHAR(512) NOT NULL,
+    idp_metadata TEXT NOT NULL,
+    idp_metadata_url VARCHAR(512) NULL,
+    email_attribute VARCHAR(255) NOT NULL DEFAULT 'email',
+    name_attribute VARCHAR(255) NOT NULL DEFAULT 'name',
+    groups_attribute VARCHAR(255) NULL,
+    role_mappings JSON NOT NULL,
+    trust_email BOOLEAN NOT NULL DEFAULT FALSE,
+    jit_provisioning BOOLEAN NOT NULL DEFAULT TRUE,
+    is_active BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+
+    UNIQUE KEY idx_slug (slug)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+-- IDs of accepted assertions, kept until they expire to reject replays
+CREATE TABLE IF NOT EXISTS saml_assertion_ids (
+    id VARCHAR(255) PRIMARY KEY,
+    expires_at DATETIME NOT NULL,
+
+    INDEX idx_expires_at (expires_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
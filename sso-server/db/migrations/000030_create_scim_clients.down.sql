@@ -0,0 +1,3 @@
+-- Drop SCIM clients and their external IDs
+DROP TABLE IF EXISTS scim_external_ids;
+DROP TABLE IF EXISTS scim_clients;
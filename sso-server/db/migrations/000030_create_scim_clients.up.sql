@@ -0,0 +1,30 @@
+-- SCIM 2.0 clients (HR and IGA systems) and the external IDs they keep for our resources
+CREATE TABLE IF NOT EXISTS scim_clients (
+    id CHAR(36) PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    description TEXT NULL,
+    token_hash VARCHAR(64) NOT NULL,
+    token_prefix VARCHAR(16) NOT NULL,
+    is_active BOOLEAN NOT NULL DEFAULT TRUE,
+    expires_at DATETIME NULL,
+    last_used_at DATETIME NULL,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+
+    UNIQUE KEY idx_token_hash (token_hash)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+-- externalId of a user or group (role), per SCIM client
+CREATE TABLE IF NOT EXISTS scim_external_ids (
+    id CHAR(36) PRIMARY KEY,
+    client_id CHAR(36) NOT NULL,
+    resource_type VARCHAR(10) NOT NULL,
+    resource_id CHAR(36) NOT NULL,
+    external_id VARCHAR(255) NOT NULL,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+    UNIQUE KEY idx_scim_external (client_id, resource_type, external_id),
+    UNIQUE KEY idx_scim_resource (client_id, resource_type, resource_id),
+    INDEX idx_resource_id (resource_id),
+    FOREIGN KEY (client_id) REFERENCES scim_clients(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
@@ -0,0 +1,4 @@
+-- Drop outbound provisioning connectors, jobs and states
+DROP TABLE IF EXISTS provisioning_states;
+DROP TABLE IF EXISTS provisioning_jobs;
+DROP TABLE IF EXISTS provisioning_connectors;
@@ -0,0 +1,53 @@
+-- Outbound SCIM provisioning of users to downstream applications
+CREATE TABLE IF NOT EXISTS provisioning_connectors (
+    id CHAR(36) PRIMARY KEY,
+    client_id VARCHAR(255) NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    endpoint_url VARCHAR(512) NOT NULL,
+    auth_type VARCHAR(20) NOT NULL DEFAULT 'bearer',
+    username VARCHAR(255) NULL,
+    secret VARCHAR(1024) NULL,
+    attribute_mapping JSON NULL,
+    role_ids JSON NULL,
+    deprovision VARCHAR(20) NOT NULL DEFAULT 'deactivate',
+    is_active BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+
+    FOREIGN KEY (client_id) REFERENCES oauth2_clients(client_id) ON DELETE CASCADE,
+    UNIQUE KEY idx_client_id (client_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+-- Durable queue of user updates to push; user_id has no foreign key so that
+-- deleted users can still be deprovisioned
+CREATE TABLE IF NOT EXISTS provisioning_jobs (
+    id CHAR(36) PRIMARY KEY,
+    connector_id CHAR(36) NOT NULL,
+    user_id CHAR(36) NOT NULL,
+    reason VARCHAR(50) NULL,
+    status VARCHAR(20) NOT NULL,
+    attempts INT NOT NULL DEFAULT 0,
+    next_attempt_at DATETIME NOT NULL,
+    last_error TEXT NULL,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+
+    INDEX idx_provisioning_job_due (status, next_attempt_at),
+    INDEX idx_provisioning_job_user (connector_id, user_id),
+    FOREIGN KEY (connector_id) REFERENCES provisioning_connectors(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+-- Last known state of each user in each application
+CREATE TABLE IF NOT EXISTS provisioning_states (
+    connector_id CHAR(36) NOT NULL,
+    user_id CHAR(36) NOT NULL,
+    remote_id VARCHAR(255) NULL,
+    status VARCHAR(20) NOT NULL,
+    last_error TEXT NULL,
+    last_synced_at DATETIME NULL,
+    updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (connector_id, user_id),
+    INDEX idx_user_id (user_id),
+    FOREIGN KEY (connector_id) REFERENCES provisioning_connectors(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
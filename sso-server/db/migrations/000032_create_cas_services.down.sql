@@ -0,0 +1,3 @@
+-- Drop CAS tickets and services
+DROP TABLE IF EXISTS cas_tickets;
+DROP TABLE IF EXISTS cas_services;
@@ -0,0 +1,33 @@
+-- Applications that sign users in with the CAS protocol through this server
+CREATE TABLE IF NOT EXISTS cas_services (
+    id CHAR(36) PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    service_patterns JSON NOT NULL,
+    username_attribute VARCHAR(20) NOT NULL DEFAULT 'email',
+    attribute_mappings JSON NOT NULL,
+    allow_proxy BOOLEAN NOT NULL DEFAULT FALSE,
+    single_logout BOOLEAN NOT NULL DEFAULT FALSE,
+    is_active BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+-- Service, proxy and proxy-granting tickets; they end with the SSO session
+CREATE TABLE IF NOT EXISTS cas_tickets (
+    id VARCHAR(255) PRIMARY KEY,
+    type VARCHAR(3) NOT NULL,
+    service_id CHAR(36) NOT NULL,
+    service TEXT NOT NULL,
+    user_id CHAR(36) NOT NULL,
+    session_id CHAR(36) NOT NULL,
+    renewed BOOLEAN NOT NULL DEFAULT FALSE,
+    proxies JSON NULL,
+    consumed_at DATETIME NULL,
+    expires_at DATETIME NOT NULL,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+    INDEX idx_cas_ticket_session (session_id),
+    INDEX idx_cas_ticket_expires (expires_at),
+    FOREIGN KEY (service_id) REFERENCES cas_services(id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
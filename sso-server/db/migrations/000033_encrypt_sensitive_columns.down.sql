@@ -0,0 +1,9 @@
+-- Restore secret column sizes; run the reencrypt command with -decrypt first
+ALTER TABLE provisioning_connectors
+    MODIFY COLUMN secret VARCHAR(1024) NULL;
+
+ALTER TABLE oidc_connections
+    MODIFY COLUMN client_secret VARCHAR(512) NULL;
+
+ALTER TABLE oauth_clients
+    MODIFY COLUMN client_secret VARCHAR(255) NOT NULL;
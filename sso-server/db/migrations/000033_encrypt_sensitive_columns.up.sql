@@ -0,0 +1,12 @@
+-- Encrypted secrets are longer than the plaintext they replace
+ALTER TABLE oauth_clients
+    MODIFY COLUMN client_secret TEXT NOT NULL;
+
+ALTER TABLE oidc_connections
+    MODIFY COLUMN client_secret TEXT NULL;
+
+ALTER TABLE provisioning_connectors
+    MODIFY COLUMN secret TEXT NULL;
+
+-- Reset tokens are now stored as SHA-256 hashes; links sent before cannot be used
+DELETE FROM password_reset_tokens WHERE used = FALSE;
@@ -0,0 +1,3 @@
+-- Drop WebAuthn challenges and credentials
+DROP TABLE IF EXISTS webauthn_challenges;
+DROP TABLE IF EXISTS webauthn_credentials;
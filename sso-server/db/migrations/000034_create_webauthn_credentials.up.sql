@@ -0,0 +1,34 @@
+-- Security keys and passkeys of users
+CREATE TABLE IF NOT EXISTS webauthn_credentials (
+    id CHAR(36) PRIMARY KEY,
+    user_id CHAR(36) NOT NULL,
+    name VARCHAR(100) NOT NULL DEFAULT '',
+    credential_id VARCHAR(512) NOT NULL,
+    public_key BLOB NOT NULL,
+    attestation_type VARCHAR(32) NOT NULL DEFAULT '',
+    aaguid CHAR(36) NOT NULL DEFAULT '',
+    sign_count INT UNSIGNED NOT NULL DEFAULT 0,
+    clone_warning BOOLEAN NOT NULL DEFAULT FALSE,
+    transports JSON NULL,
+    backup_eligible BOOLEAN NOT NULL DEFAULT FALSE,
+    backup_state BOOLEAN NOT NULL DEFAULT FALSE,
+    last_used_at DATETIME NULL,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+
+    UNIQUE KEY idx_webauthn_credential_id (credential_id),
+    INDEX idx_webauthn_credential_user (user_id),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+-- Pending registration and sign-in ceremonies
+CREATE TABLE IF NOT EXISTS webauthn_challenges (
+    id VARCHAR(64) PRIMARY KEY,
+    ceremony VARCHAR(20) NOT NULL,
+    user_id CHAR(36) NOT NULL DEFAULT '',
+    data TEXT NOT NULL,
+    expires_at DATETIME NOT NULL,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+    INDEX idx_webauthn_challenge_expires (expires_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
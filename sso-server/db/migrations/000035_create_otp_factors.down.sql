@@ -0,0 +1,3 @@
+-- Drop OTP codes and factors
+DROP TABLE IF EXISTS otp_codes;
+DROP TABLE IF EXISTS otp_factors;
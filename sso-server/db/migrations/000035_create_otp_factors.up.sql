@@ -0,0 +1,30 @@
+-- Second factors whose one-time codes are sent to the user, such as by email
+CREATE TABLE IF NOT EXISTS otp_factors (
+    id CHAR(36) PRIMARY KEY,
+    user_id CHAR(36) NOT NULL,
+    method VARCHAR(20) NOT NULL,
+    destination VARCHAR(255) NOT NULL,
+    enabled BOOLEAN NOT NULL DEFAULT FALSE,
+    enabled_at DATETIME NULL,
+    last_used_at DATETIME NULL,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+
+    UNIQUE KEY idx_otp_factor_user_method (user_id, method),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+-- Codes sent for a factor, stored hashed
+CREATE TABLE IF NOT EXISTS otp_codes (
+    id CHAR(36) PRIMARY KEY,
+    factor_id CHAR(36) NOT NULL,
+    purpose VARCHAR(20) NOT NULL,
+    code_hash VARCHAR(255) NOT NULL,
+    attempts INT NOT NULL DEFAULT 0,
+    expires_at DATETIME NOT NULL,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+    INDEX idx_otp_codes_factor_id (factor_id),
+    INDEX idx_otp_codes_expires_at (expires_at),
+    FOREIGN KEY (factor_id) REFERENCES otp_factors(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
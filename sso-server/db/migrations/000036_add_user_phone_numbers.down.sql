@@ -0,0 +1,6 @@
+-- Drop SMS deliveries and user phone numbers
+DROP TABLE IF EXISTS sms_deliveries;
+
+ALTER TABLE users
+    DROP COLUMN phone_verified,
+    DROP COLUMN phone_number;
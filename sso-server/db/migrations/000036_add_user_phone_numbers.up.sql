@@ -0,0 +1,14 @@
+-- Phone numbers of users, verified by a code sent to them
+ALTER TABLE users
+    ADD COLUMN phone_number VARCHAR(20) NULL AFTER email_verified,
+    ADD COLUMN phone_verified BOOLEAN NOT NULL DEFAULT FALSE AFTER phone_number;
+
+-- Text messages and calls sent, for per-number rate limits
+CREATE TABLE IF NOT EXISTS sms_deliveries (
+    id CHAR(36) PRIMARY KEY,
+    phone_number VARCHAR(20) NOT NULL,
+    channel VARCHAR(10) NOT NULL,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+    INDEX idx_sms_deliveries_number_created (phone_number, created_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
@@ -0,0 +1,8 @@
+-- Drop TOTP parameters, replay protection and lockout
+ALTER TABLE two_factor_auth
+    DROP COLUMN locked_until,
+    DROP COLUMN failed_attempts,
+    DROP COLUMN last_used_step,
+    DROP COLUMN period,
+    DROP COLUMN digits,
+    DROP COLUMN algorithm;
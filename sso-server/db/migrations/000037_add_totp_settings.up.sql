@@ -0,0 +1,8 @@
+-- TOTP parameters as enrolled, replay protection and lockout after wrong codes
+ALTER TABLE two_factor_auth
+    ADD COLUMN algorithm VARCHAR(10) NOT NULL DEFAULT 'SHA1' AFTER backup_codes_encrypted,
+    ADD COLUMN digits INT NOT NULL DEFAULT 6 AFTER algorithm,
+    ADD COLUMN period INT NOT NULL DEFAULT 30 AFTER digits,
+    ADD COLUMN last_used_step BIGINT NOT NULL DEFAULT 0 AFTER period,
+    ADD COLUMN failed_attempts INT NOT NULL DEFAULT 0 AFTER last_used_step,
+    ADD COLUMN locked_until DATETIME NULL AFTER failed_attempts;
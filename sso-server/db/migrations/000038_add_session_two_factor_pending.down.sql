@@ -0,0 +1,3 @@
+-- Drop the pending second factor flag of sessions
+ALTER TABLE sessions
+    DROP COLUMN two_factor_pending;
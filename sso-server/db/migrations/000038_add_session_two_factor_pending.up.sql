@@ -0,0 +1,3 @@
+-- Temporary sessions of users who still have to verify a second factor
+ALTER TABLE sessions
+    ADD COLUMN two_factor_pending BOOLEAN NOT NULL DEFAULT FALSE AFTER last_activity_at;
@@ -0,0 +1,3 @@
+-- Drop the directory deactivation time of users
+ALTER TABLE users
+    DROP COLUMN directory_removed_at;
@@ -0,0 +1,4 @@
+-- When directory sync deactivated a user whose account was gone; only such users
+-- are reactivated by the directory
+ALTER TABLE users
+    ADD COLUMN directory_removed_at DATETIME NULL AFTER is_locked;
@@ -9,17 +9,26 @@ import (
 
 // Config holds all configuration for the application
 type Config struct {
-	Server   ServerConfig
-	Database DatabaseConfig
-	Redis    RedisConfig
-	JWT      JWTConfig
-	Session  SessionConfig
-	Email    EmailConfig
-	Security SecurityConfig
-	TwoFA    TwoFAConfig
-	OAuth2   OAuth2Config
-	Log      LogConfig
-	Env      string
+	Server       ServerConfig
+	Database     DatabaseConfig
+	Redis        RedisConfig
+	JWT          JWTConfig
+	Session      SessionConfig
+	Email        EmailConfig
+	Security     SecurityConfig
+	TwoFA        TwoFAConfig
+	WebAuthn     WebAuthnConfig
+	OTP          OTPConfig
+	SMS          SMSConfig
+	OAuth2       OAuth2Config
+	SAML         SAMLConfig
+	CAS          CASConfig
+	LDAP         LDAPConfig
+	Provisioning ProvisioningConfig
+	Identity     IdentityConfig
+	Encryption   EncryptionConfig
+	Log          LogConfig
+	Env          string
 }
 
 type ServerConfig struct {
@@ -81,10 +90,45 @@ type SecurityConfig struct {
 	AccountLockoutDuration time.Duration
 }
 
+// TwoFAConfig configures authenticator app (TOTP) codes
 type TwoFAConfig struct {
-	Issuer string
-	Period int
-	Digits int
+	Issuer          string
+	Period          int
+	Digits          int
+	Algorithm       string        // SHA1, SHA256 or SHA512
+	Skew            int           // Periods before and after the current one whose codes are accepted too; 0 for the current one only
+	MaxAttempts     int           // Wrong codes in a row before 2FA is locked; 0 for no limit
+	LockoutDuration time.Duration // How long 2FA stays locked
+}
+
+// WebAuthnConfig configures security keys and passkeys. Credentials are bound to
+// RPID, which defaults to the host of the public base URL.
+type WebAuthnConfig struct {
+	RPID         string
+	RPName       string        // Shown by browsers while registering a key
+	Origins      string        // Comma separated origins of pages running WebAuthn, besides the public base URL and external UI
+	ChallengeTTL time.Duration // How long users have to touch their key
+}
+
+// OTPConfig configures second factors whose one-time codes are sent to users, such as by email
+type OTPConfig struct {
+	CodeTTL        time.Duration // How long a code can be used
+	MaxAttempts    int           // Wrong guesses allowed per code
+	ResendInterval time.Duration // Least time between two codes to the same user
+}
+
+// SMSConfig configures text messages and calls carrying one-time codes. With no
+// provider, the SMS and voice second factors are not offered.
+type SMSConfig struct {
+	Provider            string        // log or webhook
+	LogFile             string        // File the log provider appends messages to; empty logs them
+	WebhookURL          string        // Gateway the webhook provider posts messages to
+	WebhookSecret       string        // Sent to the gateway as bearer token
+	WebhookTimeout      time.Duration // Per request to the gateway
+	Voice               bool          // Also offer codes read out in a call
+	AllowedCountryCodes string        // Comma separated calling codes, like +1,+49; empty allows all
+	RateLimit           int           // Messages per phone number in RateWindow
+	RateWindow          time.Duration
 }
 
 type OAuth2Config struct {
@@ -93,6 +137,65 @@ type OAuth2Config struct {
 	RefreshTokenExpiry time.Duration
 	EnforcePKCE        bool
 	Issuer             string
+	PairwiseSecret     string
+	PublicBaseURL      string
+	ExternalUIURL      string
+	JWKSCacheTTL       time.Duration
+	ResourceServers    string // Comma separated client IDs that may introspect tokens issued to other clients
+}
+
+// SAMLConfig holds the SAML signing key pair (PEM files), used both as identity
+// provider and as service provider toward upstream identity providers
+type SAMLConfig struct {
+	CertFile  string
+	KeyFile   string
+	ClockSkew time.Duration // Allowed clock difference with upstream identity providers
+}
+
+// CASConfig configures the CAS protocol server
+type CASConfig struct {
+	TicketTTL time.Duration // How long service and proxy tickets can be validated
+}
+
+// LDAPConfig configures the LDAP directory used as authentication backend; it is
+// disabled while URL is empty
+type LDAPConfig struct {
+	URL            string
+	StartTLS       bool
+	CACertFile     string
+	BindDN         string
+	BindPassword   string
+	BaseDN         string
+	UserFilter     string            // {login} is replaced by the escaped email
+	SyncFilter     string            // Entries kept by sync; users missing from it are deactivated
+	IDAttribute    string            // Stable entry identifier, e.g. entryUUID or objectGUID
+	EmailAttribute string            // Defaults to mail
+	NameAttribute  string            // Defaults to cn
+	GroupAttribute string            // Defaults to memberOf
+	RoleMappings   map[string]string // Group DN -> role name, set as a JSON object
+	SyncInterval   time.Duration     // 0 disables scheduled sync
+	Timeout        time.Duration
+}
+
+// ProvisioningConfig tunes the queue that pushes user changes to downstream applications
+type ProvisioningConfig struct {
+	PollInterval time.Duration
+	MaxAttempts  int
+	RetryBackoff time.Duration // Delay before the first retry; doubled on each attempt
+	Timeout      time.Duration // Per request to an application
+}
+
+// IdentityConfig controls how external identities are linked to users
+type IdentityConfig struct {
+	EmailLinking string        // "verified" (default) links sign-ins to the user with the same verified email; "never" only links from the account
+	ReauthMaxAge time.Duration // How recent a sign-in stands in for the password before linking or unlinking
+}
+
+// EncryptionConfig locates the master keys that encrypt sensitive columns. Keys
+// are version:key pairs with 32-byte base64 keys; the highest version encrypts.
+type EncryptionConfig struct {
+	MasterKeyFile string // One version:key pair per line
+	MasterKeys    string // Comma separated version:key pairs
 }
 
 type LogConfig struct {
@@ -109,6 +212,10 @@ func Load() (*Config, error) {
 	// Don't error if .env file doesn't exist, just use env vars
 	_ = viper.ReadInConfig()
 
+	// Defaults for settings where 0 is a meaningful value
+	viper.SetDefault("TOTP_SKEW", 1)
+	viper.SetDefault("TOTP_MAX_ATTEMPTS", 5)
+
 	cfg := &Config{
 		Server: ServerConfig{
 			Port:    viper.GetString("SERVER_PORT"),
@@ -163,9 +270,35 @@ func Load() (*Config, error) {
 			AccountLockoutDuration: viper.GetDuration("ACCOUNT_LOCKOUT_DURATION"),
 		},
 		TwoFA: TwoFAConfig{
-			Issuer: viper.GetString("TOTP_ISSUER"),
-			Period: viper.GetInt("TOTP_PERIOD"),
-			Digits: viper.GetInt("TOTP_DIGITS"),
+			Issuer:          viper.GetString("TOTP_ISSUER"),
+			Period:          viper.GetInt("TOTP_PERIOD"),
+			Digits:          viper.GetInt("TOTP_DIGITS"),
+			Algorithm:       viper.GetString("TOTP_ALGORITHM"),
+			Skew:            viper.GetInt("TOTP_SKEW"),
+			MaxAttempts:     viper.GetInt("TOTP_MAX_ATTEMPTS"),
+			LockoutDuration: viper.GetDuration("TOTP_LOCKOUT_DURATION"),
+		},
+		WebAuthn: WebAuthnConfig{
+			RPID:         viper.GetString("WEBAUTHN_RP_ID"),
+			RPName:       viper.GetString("WEBAUTHN_RP_NAME"),
+			Origins:      viper.GetString("WEBAUTHN_ORIGINS"),
+			ChallengeTTL: viper.GetDuration("WEBAUTHN_CHALLENGE_TTL"),
+		},
+		OTP: OTPConfig{
+			CodeTTL:        viper.GetDuration("OTP_CODE_TTL"),
+			MaxAttempts:    viper.GetInt("OTP_MAX_ATTEMPTS"),
+			ResendInterval: viper.GetDuration("OTP_RESEND_INTERVAL"),
+		},
+		SMS: SMSConfig{
+			Provider:            viper.GetString("SMS_PROVIDER"),
+			LogFile:             viper.GetString("SMS_LOG_FILE"),
+			WebhookURL:          viper.GetString("SMS_WEBHOOK_URL"),
+			WebhookSecret:       viper.GetString("SMS_WEBHOOK_SECRET"),
+			WebhookTimeout:      viper.GetDuration("SMS_WEBHOOK_TIMEOUT"),
+			Voice:               viper.GetBool("SMS_VOICE_ENABLED"),
+			AllowedCountryCodes: viper.GetString("SMS_ALLOWED_COUNTRY_CODES"),
+			RateLimit:           viper.GetInt("SMS_RATE_LIMIT"),
+			RateWindow:          viper.GetDuration("SMS_RATE_WINDOW"),
 		},
 		OAuth2: OAuth2Config{
 			AuthCodeExpiry:     viper.GetDuration("OAUTH2_AUTH_CODE_EXPIRY"),
@@ -173,6 +306,50 @@ func Load() (*Config, error) {
 			RefreshTokenExpiry: viper.GetDuration("OAUTH2_REFRESH_TOKEN_EXPIRY"),
 			EnforcePKCE:        viper.GetBool("OAUTH2_ENFORCE_PKCE"),
 			Issuer:             viper.GetString("OAUTH2_ISSUER"),
+			PairwiseSecret:     viper.GetString("OAUTH2_PAIRWISE_SECRET"),
+			PublicBaseURL:      viper.GetString("OAUTH2_PUBLIC_BASE_URL"),
+			ExternalUIURL:      viper.GetString("OAUTH2_EXTERNAL_UI_URL"),
+			JWKSCacheTTL:       viper.GetDuration("OAUTH2_JWKS_CACHE_TTL"),
+			ResourceServers:    viper.GetString("OAUTH2_RESOURCE_SERVERS"),
+		},
+		SAML: SAMLConfig{
+			CertFile:  viper.GetString("SAML_IDP_CERT_FILE"),
+			KeyFile:   viper.GetString("SAML_IDP_KEY_FILE"),
+			ClockSkew: viper.GetDuration("SAML_CLOCK_SKEW"),
+		},
+		CAS: CASConfig{
+			TicketTTL: viper.GetDuration("CAS_TICKET_TTL"),
+		},
+		LDAP: LDAPConfig{
+			URL:            viper.GetString("LDAP_URL"),
+			StartTLS:       viper.GetBool("LDAP_START_TLS"),
+			CACertFile:     viper.GetString("LDAP_CA_CERT_FILE"),
+			BindDN:         viper.GetString("LDAP_BIND_DN"),
+			BindPassword:   viper.GetString("LDAP_BIND_PASSWORD"),
+			BaseDN:         viper.GetString("LDAP_BASE_DN"),
+			UserFilter:     viper.GetString("LDAP_USER_FILTER"),
+			SyncFilter:     viper.GetString("LDAP_SYNC_FILTER"),
+			IDAttribute:    viper.GetString("LDAP_ID_ATTRIBUTE"),
+			EmailAttribute: viper.GetString("LDAP_EMAIL_ATTRIBUTE"),
+			NameAttribute:  viper.GetString("LDAP_NAME_ATTRIBUTE"),
+			GroupAttribute: viper.GetString("LDAP_GROUP_ATTRIBUTE"),
+			RoleMappings:   viper.GetStringMapString("LDAP_ROLE_MAPPINGS"),
+			SyncInterval:   viper.GetDuration("LDAP_SYNC_INTERVAL"),
+			Timeout:        viper.GetDuration("LDAP_TIMEOUT"),
+		},
+		Provisioning: ProvisioningConfig{
+			PollInterval: viper.GetDuration("PROVISIONING_POLL_INTERVAL"),
+			MaxAttempts:  viper.GetInt("PROVISIONING_MAX_ATTEMPTS"),
+			RetryBackoff: viper.GetDuration("PROVISIONING_RETRY_BACKOFF"),
+			Timeout:      viper.GetDuration("PROVISIONING_TIMEOUT"),
+		},
+		Identity: IdentityConfig{
+			EmailLinking: viper.GetString("IDENTITY_EMAIL_LINKING"),
+			ReauthMaxAge: viper.GetDuration("IDENTITY_REAUTH_MAX_AGE"),
+		},
+		Encryption: EncryptionConfig{
+			MasterKeyFile: viper.GetString("ENCRYPTION_MASTER_KEY_FILE"),
+			MasterKeys:    viper.GetString("ENCRYPTION_MASTER_KEYS"),
 		},
 		Log: LogConfig{
 			Level:  viper.GetString("LOG_LEVEL"),
@@ -204,5 +381,8 @@ func (c *Config) Validate() error {
 	if c.JWT.Secret == "" {
 		return fmt.Errorf("JWT_SECRET is required")
 	}
+	if c.OAuth2.PairwiseSecret != "" && c.OAuth2.PairwiseSecret == c.JWT.Secret {
+		return fmt.Errorf("OAUTH2_PAIRWISE_SECRET must differ from JWT_SECRET")
+	}
 	return nil
 }
@@ -0,0 +1,338 @@
+// Package encryption protects sensitive columns at rest with envelope
+// encryption: every value is sealed with its own AES-256-GCM data key, which is
+// in turn sealed with a versioned master key.
+package encryption
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrNoMasterKey       = errors.New("no master key configured")
+	ErrInvalidMasterKey  = errors.New("master keys must be 32 bytes, base64 encoded, as version:key")
+	ErrUnknownKeyVersion = errors.New("value was encrypted with an unknown master key version")
+	ErrMalformedValue    = errors.New("malformed encrypted value")
+	ErrDecryptionFailed  = errors.New("encrypted value could not be decrypted")
+)
+
+// prefix marks encrypted values; anything else is plaintext written before
+// encryption was enabled
+const prefix = "enc:v1:"
+
+// keySize is the size of master and data keys (AES-256)
+const keySize = 32
+
+// KeyProvider supplies the master keys by version
+type KeyProvider interface {
+	MasterKeys() (map[uint32][]byte, error)
+}
+
+// EnvKeyProvider reads master keys from a variable's value, as comma separated
+// version:key pairs with base64 keys, e.g. "2:...,1:..."
+type EnvKeyProvider struct {
+	Value string
+}
+
+// MasterKeys implements KeyProvider
+func (p EnvKeyProvider) MasterKeys() (map[uint32][]byte, error) {
+	return ParseMasterKeys(p.Value)
+}
+
+// FileKeyProvider reads master keys from a file with one version:key pair per
+// line. Lines starting with # are ignored.
+type FileKeyProvider struct {
+	Path string
+}
+
+// MasterKeys implements KeyProvider
+func (p FileKeyProvider) MasterKeys() (map[uint32][]byte, error) {
+	data, err := os.ReadFile(p.Path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read master key file: %w", err)
+	}
+
+	var lines []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+			lines = append(lines, line)
+		}
+	}
+	return ParseMasterKeys(strings.Join(lines, ","))
+}
+
+// DerivedKeyProvider derives a single master key, version 0, from a secret. It
+// keeps installations without configured master keys working.
+type DerivedKeyProvider struct {
+	Secret string
+}
+
+// MasterKeys implements KeyProvider
+func (p DerivedKeyProvider) MasterKeys() (map[uint32][]byte, error) {
+	if p.Secret == "" {
+		return nil, ErrNoMasterKey
+	}
+
+	key, err := hkdf.Key(sha256.New, []byte(p.Secret), nil, "sso-server master key", keySize)
+	if err != nil {
+		return nil, err
+	}
+	return map[uint32][]byte{0: key}, nil
+}
+
+// ParseMasterKeys parses comma separated version:key pairs. A single key
+// without a version is version 1.
+func ParseMasterKeys(value string) (map[uint32][]byte, error) {
+	keys := make(map[uint32][]byte)
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		version, encoded := uint64(1), entry
+		if i := strings.IndexByte(entry, ':'); i >= 0 {
+			v, err := strconv.ParseUint(entry[:i], 10, 32)
+			if err != nil {
+				return nil, ErrInvalidMasterKey
+			}
+			version, encoded = v, entry[i+1:]
+		}
+
+		key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+		if err != nil || len(key) != keySize {
+			return nil, ErrInvalidMasterKey
+		}
+		if _, ok := keys[uint32(version)]; ok {
+			return nil, fmt.Errorf("master key version %d is listed twice", version)
+		}
+		keys[uint32(version)] = key
+	}
+
+	if len(keys) == 0 {
+		return nil, ErrNoMasterKey
+	}
+	return keys, nil
+}
+
+// GenerateMasterKey returns a new random master key, base64 encoded
+func GenerateMasterKey() (string, error) {
+	key := make([]byte, keySize)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(key), nil
+}
+
+// Keyring encrypts with the newest master key and decrypts with any of them
+type Keyring struct {
+	keys    map[uint32]cipher.AEAD
+	current uint32
+}
+
+// NewKeyring creates a keyring from the master keys of one or more providers.
+// The highest version encrypts new values.
+func NewKeyring(providers ...KeyProvider) (*Keyring, error) {
+	k := &Keyring{keys: make(map[uint32]cipher.AEAD)}
+	for _, provider := range providers {
+		keys, err := provider.MasterKeys()
+		if err != nil {
+			return nil, err
+		}
+		for version, key := range keys {
+			if _, ok := k.keys[version]; ok {
+				return nil, fmt.Errorf("master key version %d is configured twice", version)
+			}
+			aead, err := newGCM(key)
+			if err != nil {
+				return nil, err
+			}
+			k.keys[version] = aead
+			if version >= k.current {
+				k.current = version
+			}
+		}
+	}
+
+	if len(k.keys) == 0 {
+		return nil, ErrNoMasterKey
+	}
+	return k, nil
+}
+
+// CurrentVersion returns the version of the master key new values are encrypted with
+func (k *Keyring) CurrentVersion() uint32 {
+	return k.current
+}
+
+// Encrypt seals plaintext under a new data key. aad binds the value to where it
+// is stored, so that it cannot be moved to another column. Empty values stay empty.
+func (k *Keyring) Encrypt(plaintext, aad string) (string, error) {
+	if plaintext == "" {
+		return "", nil
+	}
+
+	dataKey := make([]byte, keySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return "", err
+	}
+	dataAEAD, err := newGCM(dataKey)
+	if err != nil {
+		return "", err
+	}
+
+	wrappedKey, err := seal(k.keys[k.current], dataKey, wrapAAD(k.current, aad))
+	if err != nil {
+		return "", err
+	}
+	payload, err := seal(dataAEAD, []byte(plaintext), []byte(aad))
+	if err != nil {
+		return "", err
+	}
+
+	return prefix + strconv.FormatUint(uint64(k.current), 10) + ":" +
+		base64.RawStdEncoding.EncodeToString(wrappedKey) + ":" +
+		base64.RawStdEncoding.EncodeToString(payload), nil
+}
+
+// Decrypt opens a value sealed by Encrypt. Plaintext values from before
+// encryption was enabled are returned unchanged.
+func (k *Keyring) Decrypt(value, aad string) (string, error) {
+	if !IsEncrypted(value) {
+		return value, nil
+	}
+
+	version, wrappedKey, payload, err := parse(value)
+	if err != nil {
+		return "", err
+	}
+	masterAEAD, ok := k.keys[version]
+	if !ok {
+		return "", fmt.Errorf("%w: %d", ErrUnknownKeyVersion, version)
+	}
+
+	dataKey, err := open(masterAEAD, wrappedKey, wrapAAD(version, aad))
+	if err != nil {
+		return "", err
+	}
+	dataAEAD, err := newGCM(dataKey)
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := open(dataAEAD, payload, []byte(aad))
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+// Reencrypt seals a value again with the current master key. It reports
+// whether the value changed; values already under the current key are kept.
+func (k *Keyring) Reencrypt(value, aad string) (string, bool, error) {
+	if value == "" {
+		return value, false, nil
+	}
+	if IsEncrypted(value) {
+		version, _, _, err := parse(value)
+		if err != nil {
+			return "", false, err
+		}
+		if version == k.current {
+			return value, false, nil
+		}
+	}
+
+	plaintext, err := k.Decrypt(value, aad)
+	if err != nil {
+		return "", false, err
+	}
+	encrypted, err := k.Encrypt(plaintext, aad)
+	if err != nil {
+		return "", false, err
+	}
+	return encrypted, true, nil
+}
+
+// IsEncrypted reports whether a stored value was sealed by a Keyring
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, prefix)
+}
+
+// parse splits an encrypted value into its master key version, wrapped data key and payload
+func parse(value string) (uint32, []byte, []byte, error) {
+	parts := strings.Split(strings.TrimPrefix(value, prefix), ":")
+	if len(parts) != 3 {
+		return 0, nil, nil, ErrMalformedValue
+	}
+
+	version, err := strconv.ParseUint(parts[0], 10, 32)
+	if err != nil {
+		return 0, nil, nil, ErrMalformedValue
+	}
+	wrappedKey, err := base64.RawStdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return 0, nil, nil, ErrMalformedValue
+	}
+	payload, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return 0, nil, nil, ErrMalformedValue
+	}
+	return uint32(version), wrappedKey, payload, nil
+}
+
+// wrapAAD binds a wrapped data key to its master key version and to the value's location
+func wrapAAD(version uint32, aad string) []byte {
+	return []byte("key:" + strconv.FormatUint(uint64(version), 10) + ":" + aad)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts with a random nonce, which is prepended to the ciphertext
+func seal(aead cipher.AEAD, plaintext, aad []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, aad), nil
+}
+
+func open(aead cipher.AEAD, ciphertext, aad []byte) ([]byte, error) {
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, ErrMalformedValue
+	}
+	plaintext, err := aead.Open(nil, ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():], aad)
+	if err != nil {
+		return nil, ErrDecryptionFailed
+	}
+	return plaintext, nil
+}
+
+// Load builds the keyring from a master key file and/or a list of keys, as read
+// by FileKeyProvider and EnvKeyProvider. The key derived from fallbackSecret is
+// always included as version 0, so values written before master keys were
+// configured stay readable until they are re-encrypted.
+func Load(keyFile, keys, fallbackSecret string) (*Keyring, error) {
+	providers := []KeyProvider{DerivedKeyProvider{Secret: fallbackSecret}}
+	if keyFile != "" {
+		providers = append(providers, FileKeyProvider{Path: keyFile})
+	}
+	if keys != "" {
+		providers = append(providers, EnvKeyProvider{Value: keys})
+	}
+	return NewKeyring(providers...)
+}
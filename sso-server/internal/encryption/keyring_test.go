@@ -0,0 +1,164 @@
+package encryption_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sso-project/sso-server/internal/encryption"
+	"github.com/sso-project/sso-server/internal/models"
+	"github.com/sso-project/sso-server/internal/testutil"
+)
+
+func newTestKey(t *testing.T) string {
+	key, err := encryption.GenerateMasterKey()
+	require.NoError(t, err)
+	return key
+}
+
+func TestKeyring_EncryptDecrypt(t *testing.T) {
+	keyring, err := encryption.NewKeyring(encryption.EnvKeyProvider{Value: "1:" + newTestKey(t)})
+	require.NoError(t, err)
+	assert.Equal(t, uint32(1), keyring.CurrentVersion())
+
+	sealed, err := keyring.Encrypt("JBSWY3DPEHPK3PXP", "two_factor_auth.secret_encrypted")
+	require.NoError(t, err)
+	assert.True(t, encryption.IsEncrypted(sealed))
+	assert.NotContains(t, sealed, "JBSWY3DPEHPK3PXP")
+
+	again, err := keyring.Encrypt("JBSWY3DPEHPK3PXP", "two_factor_auth.secret_encrypted")
+	require.NoError(t, err)
+	assert.NotEqual(t, sealed, again, "each value has its own data key and nonce")
+
+	plaintext, err := keyring.Decrypt(sealed, "two_factor_auth.secret_encrypted")
+	require.NoError(t, err)
+	assert.Equal(t, "JBSWY3DPEHPK3PXP", plaintext)
+
+	// A value copied to another column does not decrypt
+	_, err = keyring.Decrypt(sealed, "oidc_connections.client_secret")
+	assert.ErrorIs(t, err, encryption.ErrDecryptionFailed)
+
+	// Values written before encryption are read as they are
+	plaintext, err = keyring.Decrypt("legacy-secret", "oidc_connections.client_secret")
+	require.NoError(t, err)
+	assert.Equal(t, "legacy-secret", plaintext)
+
+	empty, err := keyring.Encrypt("", "oidc_connections.client_secret")
+	require.NoError(t, err)
+	assert.Empty(t, empty)
+
+	other, err := encryption.NewKeyring(encryption.EnvKeyProvider{Value: "2:" + newTestKey(t)})
+	require.NoError(t, err)
+	_, err = other.Decrypt(sealed, "two_factor_auth.secret_encrypted")
+	assert.ErrorIs(t, err, encryption.ErrUnknownKeyVersion)
+}
+
+func TestKeyring_Providers(t *testing.T) {
+	key1, key2 := newTestKey(t), newTestKey(t)
+
+	keys, err := encryption.ParseMasterKeys(key1)
+	require.NoError(t, err)
+	assert.Contains(t, keys, uint32(1))
+
+	_, err = encryption.ParseMasterKeys("1:c2hvcnQ=")
+	assert.ErrorIs(t, err, encryption.ErrInvalidMasterKey)
+	_, err = encryption.ParseMasterKeys("one:" + key1)
+	assert.ErrorIs(t, err, encryption.ErrInvalidMasterKey)
+	_, err = encryption.ParseMasterKeys(" , ")
+	assert.ErrorIs(t, err, encryption.ErrNoMasterKey)
+	_, err = encryption.ParseMasterKeys("1:" + key1 + ",1:" + key2)
+	assert.Error(t, err)
+
+	path := filepath.Join(t.TempDir(), "master.keys")
+	require.NoError(t, os.WriteFile(path, []byte("# rotated 2026-10\n2:"+key2+"\n\n1:"+key1+"\n"), 0o600))
+	keyring, err := encryption.Load(path, "", "jwt-secret")
+	require.NoError(t, err)
+	assert.Equal(t, uint32(2), keyring.CurrentVersion())
+
+	_, err = encryption.Load(filepath.Join(t.TempDir(), "missing"), "", "jwt-secret")
+	assert.Error(t, err)
+	_, err = encryption.Load("", "0:"+key1, "jwt-secret")
+	assert.Error(t, err, "version 0 is taken by the derived key")
+
+	// Without configured keys, the derived key encrypts
+	derived, err := encryption.Load("", "", "jwt-secret")
+	require.NoError(t, err)
+	assert.Equal(t, uint32(0), derived.CurrentVersion())
+	sealed, err := derived.Encrypt("secret", "aad")
+	require.NoError(t, err)
+	plaintext, err := keyring.Decrypt(sealed, "aad")
+	require.NoError(t, err)
+	assert.Equal(t, "secret", plaintext)
+}
+
+func TestRotateColumns(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+	defer testutil.TeardownTestDB(t, db)
+	ctx := context.Background()
+
+	key1 := newTestKey(t)
+	oldKeyring, err := encryption.NewKeyring(encryption.EnvKeyProvider{Value: "1:" + key1})
+	require.NoError(t, err)
+	encryption.SetKeyring(oldKeyring)
+
+	user := testutil.CreateTestUser(t, db, "judy@example.com")
+	twoFA := &models.TwoFactorAuth{ID: uuid.New().String(), UserID: user.ID, SecretEncrypted: "JBSWY3DPEHPK3PXP", Enabled: true}
+	require.NoError(t, db.DB.Create(twoFA).Error)
+
+	conn := &models.OIDCConnection{ID: uuid.New().String(), Name: "Legacy", Slug: "legacy", ClientSecret: "placeholder"}
+	require.NoError(t, db.DB.Create(conn).Error)
+	// Written before encryption was enabled
+	require.NoError(t, db.DB.Table("oidc_connections").Where("id = ?", conn.ID).Update("client_secret", "legacy-secret").Error)
+
+	var stored string
+	require.NoError(t, db.DB.Table("two_factor_auth").Select("secret_encrypted").Where("id = ?", twoFA.ID).Scan(&stored).Error)
+	assert.True(t, strings.HasPrefix(stored, "enc:v1:1:"), "stored encrypted")
+
+	// Add version 2 and re-encrypt
+	key2 := newTestKey(t)
+	newKeyring, err := encryption.NewKeyring(encryption.EnvKeyProvider{Value: "2:" + key2 + ",1:" + key1})
+	require.NoError(t, err)
+	encryption.SetKeyring(newKeyring)
+
+	encryptedModels := []interface{}{&models.TwoFactorAuth{}, &models.OIDCConnection{}, &models.ProvisioningConnector{}}
+	results, err := encryption.RotateColumns(ctx, db.DB, newKeyring, encryptedModels, encryption.RotateOptions{DryRun: true})
+	require.NoError(t, err)
+	require.Len(t, results, 3)
+	assert.Equal(t, encryption.ColumnResult{Table: "two_factor_auth", Column: "secret_encrypted", Scanned: 1, Updated: 1}, results[0])
+	assert.Equal(t, encryption.ColumnResult{Table: "oidc_connections", Column: "client_secret", Scanned: 1, Updated: 1}, results[1])
+
+	results, err = encryption.RotateColumns(ctx, db.DB, newKeyring, encryptedModels, encryption.RotateOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, 1, results[0].Updated)
+	assert.Equal(t, 1, results[1].Updated)
+
+	require.NoError(t, db.DB.Table("two_factor_auth").Select("secret_encrypted").Where("id = ?", twoFA.ID).Scan(&stored).Error)
+	assert.True(t, strings.HasPrefix(stored, "enc:v1:2:"))
+	require.NoError(t, db.DB.Table("oidc_connections").Select("client_secret").Where("id = ?", conn.ID).Scan(&stored).Error)
+	assert.True(t, strings.HasPrefix(stored, "enc:v1:2:"))
+
+	// Version 1 is no longer needed
+	onlyNew, err := encryption.NewKeyring(encryption.EnvKeyProvider{Value: "2:" + key2})
+	require.NoError(t, err)
+	encryption.SetKeyring(onlyNew)
+
+	var loaded models.OIDCConnection
+	require.NoError(t, db.DB.First(&loaded, "id = ?", conn.ID).Error)
+	assert.Equal(t, "legacy-secret", loaded.ClientSecret)
+
+	results, err = encryption.RotateColumns(ctx, db.DB, newKeyring, encryptedModels, encryption.RotateOptions{})
+	require.NoError(t, err)
+	assert.Zero(t, results[0].Updated+results[1].Updated, "nothing left to re-encrypt")
+
+	// Decrypting writes plaintext back
+	_, err = encryption.RotateColumns(ctx, db.DB, newKeyring, encryptedModels, encryption.RotateOptions{Decrypt: true})
+	require.NoError(t, err)
+	require.NoError(t, db.DB.Table("two_factor_auth").Select("secret_encrypted").Where("id = ?", twoFA.ID).Scan(&stored).Error)
+	assert.Equal(t, "JBSWY3DPEHPK3PXP", stored)
+}
@@ -0,0 +1,127 @@
+package encryption
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+// rotateBatchSize is how many rows are read at a time while re-encrypting
+const rotateBatchSize = 500
+
+// ColumnResult counts the values re-encrypted in one column
+type ColumnResult struct {
+	Table   string
+	Column  string
+	Scanned int
+	Updated int
+}
+
+// RotateOptions controls RotateColumns
+type RotateOptions struct {
+	DryRun  bool // Count the values to update without writing them
+	Decrypt bool // Write plaintext back, before downgrading to a version without encryption
+}
+
+// RotateColumns re-encrypts, with the keyring's current master key, every
+// encrypted column of the given models: plaintext values and values under older
+// master keys are sealed again. Rows are updated one by one, only if unchanged
+// since they were read, so the server can keep running.
+func RotateColumns(ctx context.Context, db *gorm.DB, k *Keyring, models []interface{}, opts RotateOptions) ([]ColumnResult, error) {
+	var results []ColumnResult
+	for _, model := range models {
+		stmt := &gorm.Statement{DB: db}
+		if err := stmt.Parse(model); err != nil {
+			return results, err
+		}
+		primaryKey := stmt.Schema.PrioritizedPrimaryField
+		if primaryKey == nil {
+			return results, fmt.Errorf("%s has no primary key", stmt.Schema.Table)
+		}
+
+		for _, field := range encryptedFields(stmt.Schema) {
+			result, err := rotateColumn(ctx, db, k, stmt.Schema.Table, primaryKey.DBName, field.DBName, opts)
+			results = append(results, result)
+			if err != nil {
+				return results, err
+			}
+		}
+	}
+	return results, nil
+}
+
+// encryptedFields returns the fields using the encrypted serializer
+func encryptedFields(s *schema.Schema) []*schema.Field {
+	var fields []*schema.Field
+	for _, field := range s.Fields {
+		if field.TagSettings["SERIALIZER"] == SerializerName {
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
+
+func rotateColumn(ctx context.Context, db *gorm.DB, k *Keyring, table, primaryKey, column string, opts RotateOptions) (ColumnResult, error) {
+	result := ColumnResult{Table: table, Column: column}
+	aad := ColumnAAD(table, column)
+
+	// The table is addressed by name, without the model, so values are read
+	// and written as stored rather than through the serializer
+	var lastID string
+	for {
+		var rows []struct {
+			ID    string
+			Value sql.NullString
+		}
+		err := db.WithContext(ctx).Table(table).
+			Select(primaryKey+" AS id, "+column+" AS value").
+			Where(primaryKey+" > ?", lastID).
+			Order(primaryKey).
+			Limit(rotateBatchSize).
+			Scan(&rows).Error
+		if err != nil {
+			return result, err
+		}
+		if len(rows) == 0 {
+			return result, nil
+		}
+
+		for _, row := range rows {
+			lastID = row.ID
+			result.Scanned++
+
+			stored := row.Value.String
+			var value string
+			var changed bool
+			if opts.Decrypt {
+				value, err = k.Decrypt(stored, aad)
+				changed = value != stored
+			} else {
+				value, changed, err = k.Reencrypt(stored, aad)
+			}
+			if err != nil {
+				return result, fmt.Errorf("%s.%s of %s: %w", table, column, row.ID, err)
+			}
+			if !changed {
+				continue
+			}
+
+			if !opts.DryRun {
+				update := db.WithContext(ctx).Table(table).
+					Where(primaryKey+" = ? AND "+column+" = ?", row.ID, stored).
+					Update(column, value)
+				if update.Error != nil {
+					return result, update.Error
+				}
+				if update.RowsAffected == 0 {
+					// Written by the server meanwhile, with the current key
+					continue
+				}
+			}
+			result.Updated++
+		}
+	}
+}
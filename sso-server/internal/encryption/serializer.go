@@ -0,0 +1,84 @@
+package encryption
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync/atomic"
+
+	"gorm.io/gorm/schema"
+)
+
+// ErrNoKeyring is returned when an encrypted column is written before SetKeyring
+var ErrNoKeyring = errors.New("encryption keyring is not configured")
+
+// SerializerName is the GORM serializer that encrypts a string column:
+// `gorm:"serializer:encrypted"`
+const SerializerName = "encrypted"
+
+var keyring atomic.Pointer[Keyring]
+
+func init() {
+	schema.RegisterSerializer(SerializerName, Serializer{})
+}
+
+// SetKeyring sets the keyring used by the GORM serializer
+func SetKeyring(k *Keyring) {
+	keyring.Store(k)
+}
+
+// ColumnAAD returns the additional data that binds a value to its column
+func ColumnAAD(table, column string) string {
+	return table + "." + column
+}
+
+// Serializer encrypts string fields on write and decrypts them on read
+type Serializer struct{}
+
+// Scan implements schema.SerializerInterface
+func (Serializer) Scan(ctx context.Context, field *schema.Field, dst reflect.Value, dbValue interface{}) error {
+	var stored string
+	switch v := dbValue.(type) {
+	case nil:
+	case string:
+		stored = v
+	case []byte:
+		stored = string(v)
+	default:
+		return fmt.Errorf("unsupported data %#v for encrypted column %s", dbValue, field.DBName)
+	}
+
+	value := stored
+	if IsEncrypted(stored) {
+		k := keyring.Load()
+		if k == nil {
+			return ErrNoKeyring
+		}
+		plaintext, err := k.Decrypt(stored, ColumnAAD(field.Schema.Table, field.DBName))
+		if err != nil {
+			return fmt.Errorf("%s.%s: %w", field.Schema.Table, field.DBName, err)
+		}
+		value = plaintext
+	}
+
+	field.ReflectValueOf(ctx, dst).SetString(value)
+	return nil
+}
+
+// Value implements schema.SerializerInterface
+func (Serializer) Value(ctx context.Context, field *schema.Field, dst reflect.Value, fieldValue interface{}) (interface{}, error) {
+	plaintext, ok := fieldValue.(string)
+	if !ok {
+		return nil, fmt.Errorf("encrypted column %s must be a string", field.DBName)
+	}
+	if plaintext == "" {
+		return "", nil
+	}
+
+	k := keyring.Load()
+	if k == nil {
+		return nil, ErrNoKeyring
+	}
+	return k.Encrypt(plaintext, ColumnAAD(field.Schema.Table, field.DBName))
+}
@@ -21,6 +21,7 @@ type AdminHandler struct {
 	oauth2ClientRepo    *repository.OAuth2ClientRepository
 	passwordService     *service.PasswordService
 	oauth2ClientService *service.OAuth2ClientService
+	provisioningService *service.ProvisioningService
 }
 
 // NewAdminHandler creates a new admin handler
@@ -31,6 +32,7 @@ func NewAdminHandler(
 	oauth2ClientRepo *repository.OAuth2ClientRepository,
 	passwordService *service.PasswordService,
 	oauth2ClientService *service.OAuth2ClientService,
+	provisioningService *service.ProvisioningService,
 ) *AdminHandler {
 	return &AdminHandler{
 		userRepo:            userRepo,
@@ -39,6 +41,7 @@ func NewAdminHandler(
 		oauth2ClientRepo:    oauth2ClientRepo,
 		passwordService:     passwordService,
 		oauth2ClientService: oauth2ClientService,
+		provisioningService: provisioningService,
 	}
 }
 
@@ -239,6 +242,7 @@ func (h *AdminHandler) CreateUser(c *fiber.Ctx) error {
 		h.userRepo.AssignRole(c.Context(), user.ID, roleID)
 	}
 
+	h.provisioningService.UserChanged(c.Context(), user.ID, "user_created")
 	return c.Status(fiber.StatusCreated).JSON(user)
 }
 
@@ -247,9 +251,10 @@ func (h *AdminHandler) UpdateUser(c *fiber.Ctx) error {
 	userID := c.Params("id")
 
 	var req struct {
-		Name     *string `json:"name"`
-		Email    *string `json:"email"`
-		IsActive *bool   `json:"is_active"`
+		Name        *string `json:"name"`
+		Email       *string `json:"email"`
+		PhoneNumber *string `json:"phone_number"` // Unverified until the user confirms a code sent to it; "" removes it
+		IsActive    *bool   `json:"is_active"`
 	}
 
 	if err := c.BodyParser(&req); err != nil {
@@ -277,8 +282,25 @@ func (h *AdminHandler) UpdateUser(c *fiber.Ctx) error {
 	if req.Email != nil {
 		user.Email = *req.Email
 	}
+	if req.PhoneNumber != nil {
+		phoneNumber := *req.PhoneNumber
+		if phoneNumber != "" {
+			normalized, err := service.NormalizePhoneNumber(phoneNumber)
+			if err != nil {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error": err.Error(),
+				})
+			}
+			phoneNumber = normalized
+		}
+		if phoneNumber != user.PhoneNumber {
+			user.PhoneNumber = phoneNumber
+			user.PhoneVerified = false
+		}
+	}
 	if req.IsActive != nil {
 		user.IsActive = *req.IsActive
+		user.DirectoryRemovedAt = nil
 	}
 
 	if err := h.userRepo.Update(c.Context(), user); err != nil {
@@ -287,6 +309,7 @@ func (h *AdminHandler) UpdateUser(c *fiber.Ctx) error {
 		})
 	}
 
+	h.provisioningService.UserChanged(c.Context(), user.ID, "user_updated")
 	return c.JSON(user)
 }
 
@@ -300,6 +323,7 @@ func (h *AdminHandler) DeleteUser(c *fiber.Ctx) error {
 		})
 	}
 
+	h.provisioningService.UserChanged(c.Context(), userID, "user_deactivated")
 	return c.JSON(fiber.Map{
 		"message": "User deactivated successfully",
 	})
@@ -366,6 +390,7 @@ func (h *AdminHandler) AssignRole(c *fiber.Ctx) error {
 		})
 	}
 
+	h.provisioningService.UserChanged(c.Context(), userID, "role_assigned")
 	return c.JSON(fiber.Map{
 		"message": "Role assigned successfully",
 	})
@@ -382,6 +407,7 @@ func (h *AdminHandler) RemoveRole(c *fiber.Ctx) error {
 		})
 	}
 
+	h.provisioningService.UserChanged(c.Context(), userID, "role_removed")
 	return c.JSON(fiber.Map{
 		"message": "Role removed successfully",
 	})
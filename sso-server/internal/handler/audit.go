@@ -0,0 +1,29 @@
+package handler
+
+import (
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/sso-project/sso-server/internal/models"
+	"github.com/sso-project/sso-server/internal/repository"
+)
+
+// recordAudit writes an audit log entry for the request, attributed to the
+// signed-in user when there is one
+func recordAudit(c *fiber.Ctx, auditRepo *repository.AuditLogRepository, action, resource, details string) {
+	var userID *string
+	if id, ok := c.Locals("user_id").(string); ok {
+		userID = &id
+	}
+	auditRepo.Create(c.Context(), &models.AuditLog{
+		ID:        uuid.New().String(),
+		UserID:    userID,
+		Action:    action,
+		Resource:  resource,
+		IPAddress: c.IP(),
+		UserAgent: c.Get("User-Agent"),
+		Details:   details,
+		CreatedAt: time.Now(),
+	})
+}
@@ -1,10 +1,15 @@
 package handler
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log"
+	"slices"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/sso-project/sso-server/internal/models"
 	"github.com/sso-project/sso-server/internal/service"
 )
 
@@ -13,6 +18,7 @@ type AuthHandler struct {
 	authService *service.AuthService
 	jwtService  *service.JWTService
 	totpService *service.TOTPService
+	otpService  *service.OTPService
 }
 
 // NewAuthHandler creates a new auth handler
@@ -20,11 +26,13 @@ func NewAuthHandler(
 	authService *service.AuthService,
 	jwtService *service.JWTService,
 	totpService *service.TOTPService,
+	otpService *service.OTPService,
 ) *AuthHandler {
 	return &AuthHandler{
 		authService: authService,
 		jwtService:  jwtService,
 		totpService: totpService,
+		otpService:  otpService,
 	}
 }
 
@@ -38,6 +46,7 @@ type LoginRequest struct {
 type LoginResponse struct {
 	Success           bool        `json:"success"`
 	RequiresTwoFactor bool        `json:"requires_two_factor,omitempty"`
+	TwoFactorMethods  []string    `json:"two_factor_methods,omitempty"`
 	TempToken         string      `json:"temp_token,omitempty"`
 	AccessToken       string      `json:"access_token,omitempty"`
 	RefreshToken      string      `json:"refresh_token,omitempty"`
@@ -96,6 +105,7 @@ func (h *AuthHandler) Login(c *fiber.Ctx) error {
 		return c.JSON(LoginResponse{
 			Success:           true,
 			RequiresTwoFactor: true,
+			TwoFactorMethods:  result.TwoFactorMethods,
 			TempToken:         result.TempToken,
 		})
 	}
@@ -142,6 +152,63 @@ func (h *AuthHandler) Login(c *fiber.Ctx) error {
 type Verify2FARequest struct {
 	TempToken string `json:"temp_token" validate:"required"`
 	Code      string `json:"code" validate:"required"`
+	Method    string `json:"method"` // One of the two_factor_methods from login; totp if empty
+}
+
+// SendTwoFactorCodeRequest asks for a code to be sent at the 2FA step
+type SendTwoFactorCodeRequest struct {
+	TempToken string `json:"temp_token" validate:"required"`
+	Method    string `json:"method" validate:"required"`
+}
+
+// SendTwoFactorCode handles POST /auth/2fa/send
+func (h *AuthHandler) SendTwoFactorCode(c *fiber.Ctx) error {
+	var req SendTwoFactorCodeRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	session, err := h.authService.GetSessionByToken(c.Context(), req.TempToken)
+	if err != nil || time.Now().After(session.ExpiresAt) {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Invalid or expired temporary token",
+		})
+	}
+
+	user := &session.User
+	methods := h.authService.TwoFactorMethods(c.Context(), user)
+	factor, err := sendTwoFactorCode(c.Context(), methods, h.otpService, user.ID, req.Method)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrOTPMethodUnavailable), errors.Is(err, service.ErrOTPFactorNotEnabled):
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "This verification method is not available for this account",
+			})
+		case errors.Is(err, service.ErrOTPResendTooSoon), errors.Is(err, service.ErrSMSRateLimited):
+			return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		case errors.Is(err, service.ErrPhoneCountryNotAllowed):
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		log.Printf("Failed to send %s code to %s: %v", req.Method, user.ID, err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to send verification code",
+		})
+	}
+
+	h.authService.LogAudit(c.Context(), &user.ID, "2fa_code_sent", "authentication", c.IP(), c.Get("User-Agent"), "method="+factor.Method)
+
+	return c.JSON(fiber.Map{
+		"success":     true,
+		"method":      factor.Method,
+		"destination": service.MaskDestination(factor.Destination),
+		"expires_in":  int(h.otpService.CodeTTL().Seconds()),
+	})
 }
 
 // Verify2FA handles POST /auth/verify-2fa
@@ -154,7 +221,7 @@ func (h *AuthHandler) Verify2FA(c *fiber.Ctx) error {
 	}
 
 	// Get user ID and temp session from temp token
-	// The temp token is stored as a regular session but with short expiry
+	// The temp token is stored as a short-lived session waiting for 2FA
 	session, err := h.authService.GetSessionByToken(c.Context(), req.TempToken)
 	if err != nil {
 		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
@@ -171,16 +238,32 @@ func (h *AuthHandler) Verify2FA(c *fiber.Ctx) error {
 
 	// Get user
 	user := &session.User
-	if user.TwoFactorAuth == nil || !user.TwoFactorAuth.Enabled {
+	methods := h.authService.TwoFactorMethods(c.Context(), user)
+	if len(methods) == 0 {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"error": "2FA is not enabled for this account",
 		})
 	}
 
-	// Verify TOTP code
-	isValid, err := h.totpService.VerifyCode(c.Context(), user.ID, req.Code)
-	if err != nil || !isValid {
-		// TODO: Increment failed 2FA attempts and lock after max attempts
+	// Verify the code of the chosen method: TOTP or a backup code, or a code sent to the user
+	verified, err := verifyTwoFactorCode(c.Context(), methods, h.totpService, h.otpService, user.ID, req.Method, req.Code)
+	if err != nil {
+		// Wrong TOTP codes count towards locking 2FA; sent codes are limited per code
+		switch {
+		case errors.Is(err, service.ErrOTPMethodUnavailable):
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "This verification method is not available for this account",
+			})
+		case errors.Is(err, service.ErrTwoFactorLocked):
+			h.authService.LogAudit(c.Context(), &user.ID, "2fa_locked", "authentication", c.IP(), c.Get("User-Agent"), "method="+service.TwoFactorMethodTOTP)
+			return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		case errors.Is(err, service.ErrOTPCodeExpired), errors.Is(err, service.ErrOTPTooManyAttempts):
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
 		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
 			"error": "Invalid verification code",
 		})
@@ -227,13 +310,18 @@ func (h *AuthHandler) Verify2FA(c *fiber.Ctx) error {
 	})
 
 	// Log successful 2FA verification
-	h.authService.LogAudit(c.Context(), &user.ID, "2fa_verified", "authentication", ipAddress, userAgent, "")
+	h.authService.LogAudit(c.Context(), &user.ID, "2fa_verified", "authentication", ipAddress, userAgent, "method="+verified.Method)
+	if verified.BackupCodeUsed {
+		h.authService.LogAudit(c.Context(), &user.ID, "2fa_backup_code_used", "authentication", ipAddress, userAgent, fmt.Sprintf("remaining=%d", verified.BackupCodesRemaining))
+	}
 
 	return c.JSON(fiber.Map{
-		"success":       true,
-		"access_token":  accessToken,
-		"refresh_token": refreshToken,
-		"session_token": realSession.SessionToken,
+		"success":                true,
+		"access_token":           accessToken,
+		"refresh_token":          refreshToken,
+		"session_token":          realSession.SessionToken,
+		"backup_code_used":       verified.BackupCodeUsed,
+		"backup_codes_remaining": verified.BackupCodesRemaining,
 		"user": fiber.Map{
 			"id":    user.ID,
 			"email": user.Email,
@@ -242,6 +330,35 @@ func (h *AuthHandler) Verify2FA(c *fiber.Ctx) error {
 	})
 }
 
+// sendTwoFactorCode sends a code at the 2FA step over method, which must be one
+// of the user's methods that works by sending codes
+func sendTwoFactorCode(ctx context.Context, methods []string, otpService *service.OTPService, userID, method string) (*models.OTPFactor, error) {
+	if !slices.Contains(methods, method) || !slices.Contains(otpService.AvailableMethods(), method) {
+		return nil, service.ErrOTPMethodUnavailable
+	}
+	return otpService.SendCode(ctx, userID, method)
+}
+
+// verifyTwoFactorCode checks a code entered at the 2FA step with the method the
+// user chose among theirs: a TOTP or backup code, or a code sent to them. No
+// method means TOTP, as before users could choose.
+func verifyTwoFactorCode(ctx context.Context, methods []string, totpService *service.TOTPService, otpService *service.OTPService, userID, method, code string) (*service.TwoFactorResult, error) {
+	if method == "" {
+		method = service.TwoFactorMethodTOTP
+	}
+	if !slices.Contains(methods, method) || method == service.TwoFactorMethodWebAuthn {
+		return nil, service.ErrOTPMethodUnavailable
+	}
+
+	if method == service.TwoFactorMethodTOTP {
+		return totpService.Verify(ctx, userID, code)
+	}
+	if err := otpService.Verify(ctx, userID, method, code); err != nil {
+		return nil, err
+	}
+	return &service.TwoFactorResult{Method: method}, nil
+}
+
 // Logout handles POST /auth/logout
 func (h *AuthHandler) Logout(c *fiber.Ctx) error {
 	// Get session token from cookie or header
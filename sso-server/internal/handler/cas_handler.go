@@ -0,0 +1,333 @@
+package handler
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/sso-project/sso-server/internal/models"
+	"github.com/sso-project/sso-server/internal/repository"
+	"github.com/sso-project/sso-server/internal/service"
+)
+
+const casNamespace = "http://www.yale.edu/tp/cas"
+
+// casServiceResponse is the XML body of the CAS validation and proxy endpoints
+type casServiceResponse struct {
+	XMLName      xml.Name                  `xml:"cas:serviceResponse"`
+	Namespace    string                    `xml:"xmlns:cas,attr"`
+	Success      *casAuthenticationSuccess `xml:"cas:authenticationSuccess,omitempty"`
+	Failure      *casFailure               `xml:"cas:authenticationFailure,omitempty"`
+	ProxySuccess *casProxySuccess          `xml:"cas:proxySuccess,omitempty"`
+	ProxyFailure *casFailure               `xml:"cas:proxyFailure,omitempty"`
+}
+
+type casAuthenticationSuccess struct {
+	User                string         `xml:"cas:user"`
+	Attributes          *casAttributes `xml:"cas:attributes,omitempty"`
+	ProxyGrantingTicket string         `xml:"cas:proxyGrantingTicket,omitempty"`
+	Proxies             *casProxies    `xml:"cas:proxies,omitempty"`
+}
+
+type casAttributes struct {
+	Values []casAttribute
+}
+
+// casAttribute is one value of an attribute, named after it (cas:<name>)
+type casAttribute struct {
+	XMLName xml.Name
+	Value   string `xml:",chardata"`
+}
+
+type casProxies struct {
+	Proxy []string `xml:"cas:proxy"`
+}
+
+type casProxySuccess struct {
+	ProxyTicket string `xml:"cas:proxyTicket"`
+}
+
+type casFailure struct {
+	Code        string `xml:"code,attr"`
+	Description string `xml:",chardata"`
+}
+
+// CASHandler serves the CAS 2.0/3.0 protocol endpoints. Sign-in uses the same
+// session cookie as the OAuth2 and SAML flows.
+type CASHandler struct {
+	casService     *service.CASServerService
+	sessionService *service.SessionService
+	auditRepo      *repository.AuditLogRepository
+	pages          *OAuth2Pages
+}
+
+// NewCASHandler creates a new CASHandler
+func NewCASHandler(
+	casService *service.CASServerService,
+	sessionService *service.SessionService,
+	auditRepo *repository.AuditLogRepository,
+	pages *OAuth2Pages,
+) *CASHandler {
+	return &CASHandler{
+		casService:     casService,
+		sessionService: sessionService,
+		auditRepo:      auditRepo,
+		pages:          pages,
+	}
+}
+
+// Login handles GET /cas/login
+func (h *CASHandler) Login(c *fiber.Ctx) error {
+	serviceURL := c.Query("service")
+	session, signedIn := c.Locals("session").(*models.Session)
+
+	if serviceURL == "" {
+		if !signedIn {
+			return c.Redirect(h.pages.LoginURL(c.OriginalURL()))
+		}
+		return h.pages.Render(c, fiber.StatusOK, nil, "message", fiber.Map{
+			"title":   "Signed In",
+			"heading": "You Are Signed In",
+			"message": "You can now open applications that use this sign-in service.",
+		})
+	}
+
+	svc, err := h.casService.MatchService(c.Context(), serviceURL)
+	if err != nil {
+		return h.casLoginError(c, err)
+	}
+
+	// renew asks for credentials even with a session, and takes precedence over gateway
+	renew := c.QueryBool("renew")
+	if !signedIn || (renew && !h.casService.IsNewLogin(session)) {
+		if c.QueryBool("gateway") && !renew {
+			return c.Redirect(serviceURL)
+		}
+		return c.Redirect(h.pages.LoginURL(c.OriginalURL()))
+	}
+
+	ticket, err := h.casService.IssueServiceTicket(c.Context(), svc, serviceURL, session)
+	if err != nil {
+		return h.casLoginError(c, err)
+	}
+
+	h.audit(c, "cas_login", fmt.Sprintf("service=%s", serviceURL))
+	if strings.EqualFold(c.Query("method"), fiber.MethodPost) {
+		return h.pages.Render(c, fiber.StatusOK, nil, "saml_post", fiber.Map{
+			"title":            "Signing In",
+			"service_provider": svc.Name,
+			"url":              serviceURL,
+			"field":            "ticket",
+			"value":            ticket,
+		})
+	}
+	return c.Redirect(casTicketURL(serviceURL, ticket))
+}
+
+// Logout handles GET /cas/logout
+func (h *CASHandler) Logout(c *fiber.Ctx) error {
+	if session, ok := c.Locals("session").(*models.Session); ok {
+		if err := h.casService.Logout(c.Context(), session); err != nil {
+			return h.pages.RenderError(c, fiber.StatusInternalServerError, nil, "server_error", "The request could not be processed.")
+		}
+		if err := h.sessionService.TerminateSession(c.Context(), session.SessionToken); err != nil {
+			return h.pages.RenderError(c, fiber.StatusInternalServerError, nil, "server_error", "The request could not be processed.")
+		}
+		c.ClearCookie("session_token")
+		h.audit(c, "cas_logout", "")
+	}
+
+	// Only registered services can be returned to, so logout is not an open redirect
+	if serviceURL := c.Query("service"); serviceURL != "" {
+		if _, err := h.casService.MatchService(c.Context(), serviceURL); err == nil {
+			return c.Redirect(serviceURL)
+		}
+	}
+
+	return h.pages.Render(c, fiber.StatusOK, nil, "message", fiber.Map{
+		"title":   "Signed Out",
+		"heading": "You Are Signed Out",
+		"message": "Close your browser to make sure you are signed out of every application.",
+	})
+}
+
+// ServiceValidate handles GET /cas/serviceValidate (CAS 2.0)
+func (h *CASHandler) ServiceValidate(c *fiber.Ctx) error {
+	return h.validate(c, false, false)
+}
+
+// ProxyValidate handles GET /cas/proxyValidate (CAS 2.0)
+func (h *CASHandler) ProxyValidate(c *fiber.Ctx) error {
+	return h.validate(c, true, false)
+}
+
+// P3ServiceValidate handles GET /cas/p3/serviceValidate (CAS 3.0, with attributes)
+func (h *CASHandler) P3ServiceValidate(c *fiber.Ctx) error {
+	return h.validate(c, false, true)
+}
+
+// P3ProxyValidate handles GET /cas/p3/proxyValidate (CAS 3.0, with attributes)
+func (h *CASHandler) P3ProxyValidate(c *fiber.Ctx) error {
+	return h.validate(c, true, true)
+}
+
+func (h *CASHandler) validate(c *fiber.Ctx, proxyTickets, withAttributes bool) error {
+	auth, err := h.casService.Validate(c.Context(), service.CASValidation{
+		Service:           c.Query("service"),
+		Ticket:            c.Query("ticket"),
+		PgtURL:            c.Query("pgtUrl"),
+		Renew:             c.QueryBool("renew"),
+		AllowProxyTickets: proxyTickets,
+	})
+	if err != nil {
+		failure := casFailureFor(err)
+		if casJSON(c) {
+			return c.JSON(fiber.Map{"serviceResponse": fiber.Map{"authenticationFailure": fiber.Map{
+				"code":        failure.Code,
+				"description": failure.Description,
+			}}})
+		}
+		return h.sendXML(c, &casServiceResponse{Failure: failure})
+	}
+
+	if !withAttributes {
+		auth.Attributes = nil
+	}
+
+	if casJSON(c) {
+		success := fiber.Map{"user": auth.User}
+		if len(auth.Attributes) > 0 {
+			success["attributes"] = auth.Attributes
+		}
+		if auth.ProxyGrantingTicketIOU != "" {
+			success["proxyGrantingTicket"] = auth.ProxyGrantingTicketIOU
+		}
+		if len(auth.Proxies) > 0 {
+			success["proxies"] = auth.Proxies
+		}
+		return c.JSON(fiber.Map{"serviceResponse": fiber.Map{"authenticationSuccess": success}})
+	}
+
+	success := &casAuthenticationSuccess{
+		User:                auth.User,
+		ProxyGrantingTicket: auth.ProxyGrantingTicketIOU,
+	}
+	if len(auth.Attributes) > 0 {
+		names := make([]string, 0, len(auth.Attributes))
+		for name := range auth.Attributes {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		success.Attributes = &casAttributes{}
+		for _, name := range names {
+			for _, value := range auth.Attributes[name] {
+				success.Attributes.Values = append(success.Attributes.Values, casAttribute{
+					XMLName: xml.Name{Local: "cas:" + name},
+					Value:   value,
+				})
+			}
+		}
+	}
+	if len(auth.Proxies) > 0 {
+		success.Proxies = &casProxies{Proxy: auth.Proxies}
+	}
+	return h.sendXML(c, &casServiceResponse{Success: success})
+}
+
+// Proxy handles GET /cas/proxy
+func (h *CASHandler) Proxy(c *fiber.Ctx) error {
+	ticket, err := h.casService.Proxy(c.Context(), c.Query("pgt"), c.Query("targetService"))
+	if err != nil {
+		failure := casFailureFor(err)
+		if errors.Is(err, service.ErrCASServiceNotAuthorized) {
+			failure.Code = "UNAUTHORIZED_SERVICE"
+		}
+		if casJSON(c) {
+			return c.JSON(fiber.Map{"serviceResponse": fiber.Map{"proxyFailure": fiber.Map{
+				"code":        failure.Code,
+				"description": failure.Description,
+			}}})
+		}
+		return h.sendXML(c, &casServiceResponse{ProxyFailure: failure})
+	}
+
+	if casJSON(c) {
+		return c.JSON(fiber.Map{"serviceResponse": fiber.Map{"proxySuccess": fiber.Map{
+			"proxyTicket": ticket,
+		}}})
+	}
+	return h.sendXML(c, &casServiceResponse{ProxySuccess: &casProxySuccess{ProxyTicket: ticket}})
+}
+
+func (h *CASHandler) sendXML(c *fiber.Ctx, response *casServiceResponse) error {
+	response.Namespace = casNamespace
+	body, err := xml.MarshalIndent(response, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	c.Set(fiber.HeaderCacheControl, "no-store")
+	c.Type("xml", "utf-8")
+	return c.Send(body)
+}
+
+// casJSON reports whether the service asked for the CAS 3.0 JSON format
+func casJSON(c *fiber.Ctx) bool {
+	return strings.EqualFold(c.Query("format"), "JSON")
+}
+
+// casFailureFor maps a validation error to its CAS error code
+func casFailureFor(err error) *casFailure {
+	switch {
+	case errors.Is(err, service.ErrCASInvalidRequest):
+		return &casFailure{Code: "INVALID_REQUEST", Description: err.Error()}
+	case errors.Is(err, service.ErrCASInvalidTicketSpec):
+		return &casFailure{Code: "INVALID_TICKET_SPEC", Description: err.Error()}
+	case errors.Is(err, service.ErrCASInvalidTicket):
+		return &casFailure{Code: "INVALID_TICKET", Description: err.Error()}
+	case errors.Is(err, service.ErrAccountInactive), errors.Is(err, service.ErrAccountLocked):
+		return &casFailure{Code: "INVALID_TICKET", Description: err.Error()}
+	case errors.Is(err, service.ErrCASServiceMismatch), errors.Is(err, service.ErrCASServiceNotAuthorized):
+		return &casFailure{Code: "INVALID_SERVICE", Description: err.Error()}
+	case errors.Is(err, service.ErrCASProxyNotAllowed):
+		return &casFailure{Code: "UNAUTHORIZED_SERVICE_PROXY", Description: err.Error()}
+	case errors.Is(err, service.ErrCASInvalidProxyCallback):
+		return &casFailure{Code: "INVALID_PROXY_CALLBACK", Description: err.Error()}
+	}
+	return &casFailure{Code: "INTERNAL_ERROR", Description: "the request could not be processed"}
+}
+
+// casTicketURL appends the ticket to the service URL without reordering its query,
+// so the service can validate with the URL it was called with
+func casTicketURL(serviceURL, ticket string) string {
+	fragment := ""
+	if i := strings.IndexByte(serviceURL, '#'); i >= 0 {
+		serviceURL, fragment = serviceURL[:i], serviceURL[i:]
+	}
+
+	separator := "?"
+	if strings.Contains(serviceURL, "?") {
+		separator = "&"
+	}
+	return serviceURL + separator + "ticket=" + url.QueryEscape(ticket) + fragment
+}
+
+func (h *CASHandler) casLoginError(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, service.ErrCASServiceNotAuthorized):
+		return h.pages.RenderError(c, fiber.StatusForbidden, nil, "unauthorized_service", "The application is not registered with this sign-in service.")
+	case errors.Is(err, service.ErrAccountInactive), errors.Is(err, service.ErrAccountLocked):
+		return h.pages.RenderError(c, fiber.StatusForbidden, nil, "access_denied", err.Error())
+	}
+	return h.pages.RenderError(c, fiber.StatusInternalServerError, nil, "server_error", "The request could not be processed.")
+}
+
+// audit records CAS sign-ins and logouts, since the service only sees the ticket
+func (h *CASHandler) audit(c *fiber.Ctx, action, details string) {
+	recordAudit(c, h.auditRepo, action, "cas_service", details)
+}
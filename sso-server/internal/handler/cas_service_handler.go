@@ -0,0 +1,129 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/sso-project/sso-server/internal/models"
+	"github.com/sso-project/sso-server/internal/repository"
+	"github.com/sso-project/sso-server/internal/service"
+)
+
+// CASServiceHandler handles CAS service management endpoints
+type CASServiceHandler struct {
+	casService *service.CASServerService
+	auditRepo  *repository.AuditLogRepository
+}
+
+// NewCASServiceHandler creates a new CASServiceHandler
+func NewCASServiceHandler(casService *service.CASServerService, auditRepo *repository.AuditLogRepository) *CASServiceHandler {
+	return &CASServiceHandler{
+		casService: casService,
+		auditRepo:  auditRepo,
+	}
+}
+
+// GetServices handles GET /admin/api/cas-services
+func (h *CASServiceHandler) GetServices(c *fiber.Ctx) error {
+	services, err := h.casService.ListServices(c.Context())
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "failed to fetch services",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"services":       services,
+		"cas_server_url": h.casService.ServerURL(),
+	})
+}
+
+// GetService handles GET /admin/api/cas-services/:id
+func (h *CASServiceHandler) GetService(c *fiber.Ctx) error {
+	svc, err := h.casService.GetService(c.Context(), c.Params("id"))
+	if err != nil {
+		return h.serviceError(c, err)
+	}
+
+	return c.JSON(svc)
+}
+
+// CreateService handles POST /admin/api/cas-services
+func (h *CASServiceHandler) CreateService(c *fiber.Ctx) error {
+	var req service.CASServiceRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "invalid request body",
+		})
+	}
+
+	svc, err := h.casService.CreateService(c.Context(), req)
+	if err != nil {
+		return h.serviceError(c, err)
+	}
+
+	h.audit(c, "cas_service_created", svc)
+	return c.Status(fiber.StatusCreated).JSON(svc)
+}
+
+// UpdateService handles PUT /admin/api/cas-services/:id
+func (h *CASServiceHandler) UpdateService(c *fiber.Ctx) error {
+	var req service.CASServiceRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "invalid request body",
+		})
+	}
+
+	svc, err := h.casService.UpdateService(c.Context(), c.Params("id"), req)
+	if err != nil {
+		return h.serviceError(c, err)
+	}
+
+	h.audit(c, "cas_service_updated", svc)
+	return c.JSON(svc)
+}
+
+// DeleteService handles DELETE /admin/api/cas-services/:id
+func (h *CASServiceHandler) DeleteService(c *fiber.Ctx) error {
+	svc, err := h.casService.GetService(c.Context(), c.Params("id"))
+	if err != nil {
+		return h.serviceError(c, err)
+	}
+
+	if err := h.casService.DeleteService(c.Context(), svc.ID); err != nil {
+		return h.serviceError(c, err)
+	}
+
+	h.audit(c, "cas_service_deleted", svc)
+	return c.JSON(fiber.Map{
+		"message": "Service deleted successfully",
+	})
+}
+
+func (h *CASServiceHandler) serviceError(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, repository.ErrCASServiceNotFound):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "service not found",
+		})
+	case errors.Is(err, service.ErrCASServicePatternRequired),
+		errors.Is(err, service.ErrInvalidCASServicePattern),
+		errors.Is(err, service.ErrInsecureCASServicePattern),
+		errors.Is(err, service.ErrInvalidCASUsername),
+		errors.Is(err, service.ErrInvalidAttributeMapping):
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		"error": "failed to save service",
+	})
+}
+
+// audit records service changes, since each one decides where tickets go
+func (h *CASServiceHandler) audit(c *fiber.Ctx, action string, svc *models.CASService) {
+	recordAudit(c, h.auditRepo, action, "cas_service", fmt.Sprintf("name=%s service_patterns=%s", svc.Name, strings.Join(svc.ServicePatterns, ",")))
+}
@@ -0,0 +1,64 @@
+package handler
+
+import (
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/sso-project/sso-server/internal/service"
+)
+
+// DirectoryHandler handles directory (LDAP) sync endpoints
+type DirectoryHandler struct {
+	directoryService *service.DirectoryService // nil when no directory is configured
+}
+
+// NewDirectoryHandler creates a new DirectoryHandler
+func NewDirectoryHandler(directoryService *service.DirectoryService) *DirectoryHandler {
+	return &DirectoryHandler{
+		directoryService: directoryService,
+	}
+}
+
+// GetStatus handles GET /admin/api/directory
+func (h *DirectoryHandler) GetStatus(c *fiber.Ctx) error {
+	if h.directoryService == nil {
+		return c.JSON(fiber.Map{
+			"enabled": false,
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"enabled":   true,
+		"directory": h.directoryService.Name(),
+		"last_sync": h.directoryService.LastSync(),
+	})
+}
+
+// Sync handles POST /admin/api/directory/sync
+func (h *DirectoryHandler) Sync(c *fiber.Ctx) error {
+	if h.directoryService == nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "no directory is configured",
+		})
+	}
+
+	result, err := h.directoryService.Sync(c.Context())
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrDirectorySyncRunning):
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		case errors.Is(err, service.ErrDirectoryUnavailable), errors.Is(err, service.ErrDirectoryEmpty):
+			return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{
+				"error":  err.Error(),
+				"result": result,
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "directory sync failed",
+		})
+	}
+
+	return c.JSON(result)
+}
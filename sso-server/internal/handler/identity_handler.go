@@ -0,0 +1,221 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/sso-project/sso-server/internal/models"
+	"github.com/sso-project/sso-server/internal/repository"
+	"github.com/sso-project/sso-server/internal/service"
+)
+
+// identityLinkCookie carries a confirmed link request to the upstream sign-in
+// that completes it; both OIDC and SAML sign-ins start under its path
+const (
+	identityLinkCookie     = "identity_link"
+	identityLinkCookiePath = "/oauth2/login"
+)
+
+// reauthRequest is the proof of identity asked before linking or unlinking
+type reauthRequest struct {
+	Password string `json:"password"`
+	Code     string `json:"code"` // 2FA code, when 2FA is enabled
+}
+
+// IdentityHandler handles linked identity endpoints for users and admins
+type IdentityHandler struct {
+	identityService     *service.IdentityService
+	provisioningService *service.ProvisioningService
+	auditRepo           *repository.AuditLogRepository
+	secureCookies       bool
+}
+
+// NewIdentityHandler creates a new IdentityHandler
+func NewIdentityHandler(
+	identityService *service.IdentityService,
+	provisioningService *service.ProvisioningService,
+	auditRepo *repository.AuditLogRepository,
+	secureCookies bool,
+) *IdentityHandler {
+	return &IdentityHandler{
+		identityService:     identityService,
+		provisioningService: provisioningService,
+		auditRepo:           auditRepo,
+		secureCookies:       secureCookies,
+	}
+}
+
+// GetIdentities handles GET /user/identities
+func (h *IdentityHandler) GetIdentities(c *fiber.Ctx) error {
+	identities, err := h.identityService.ListIdentities(c.Context(), c.Locals("user_id").(string))
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "failed to fetch identities",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"identities": identities,
+	})
+}
+
+// BeginLink handles POST /user/identities/link
+func (h *IdentityHandler) BeginLink(c *fiber.Ctx) error {
+	req, err := parseReauth(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "invalid request body",
+		})
+	}
+
+	session := c.Locals("session").(*models.Session)
+	request, expiresAt, err := h.identityService.BeginLink(c.Context(), session, req.Password, req.Code)
+	if err != nil {
+		return h.identityError(c, err)
+	}
+
+	c.Cookie(&fiber.Cookie{
+		Name:     identityLinkCookie,
+		Value:    request,
+		Path:     identityLinkCookiePath,
+		Expires:  expiresAt,
+		HTTPOnly: true,
+		Secure:   h.secureCookies,
+		SameSite: "Lax",
+	})
+
+	return c.JSON(fiber.Map{
+		"message":    "Sign in with the provider to link, adding link=true to its login URL",
+		"expires_at": expiresAt,
+	})
+}
+
+// Unlink handles DELETE /user/identities/:id
+func (h *IdentityHandler) Unlink(c *fiber.Ctx) error {
+	req, err := parseReauth(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "invalid request body",
+		})
+	}
+
+	session := c.Locals("session").(*models.Session)
+	identity, err := h.identityService.Unlink(c.Context(), session, c.Params("id"), req.Password, req.Code)
+	if err != nil {
+		return h.identityError(c, err)
+	}
+
+	h.audit(c, "identity_unlinked", fmt.Sprintf("user_id=%s provider=%s subject=%s", identity.UserID, identity.Provider, identity.Subject))
+	return c.JSON(fiber.Map{
+		"message": "Identity unlinked successfully",
+	})
+}
+
+// GetUserIdentities handles GET /admin/api/users/:id/identities
+func (h *IdentityHandler) GetUserIdentities(c *fiber.Ctx) error {
+	identities, err := h.identityService.ListIdentities(c.Context(), c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "failed to fetch identities",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"identities": identities,
+	})
+}
+
+// RemoveUserIdentity handles DELETE /admin/api/users/:id/identities/:identity_id
+func (h *IdentityHandler) RemoveUserIdentity(c *fiber.Ctx) error {
+	identity, err := h.identityService.RemoveIdentity(c.Context(), c.Params("id"), c.Params("identity_id"))
+	if err != nil {
+		return h.identityError(c, err)
+	}
+
+	h.audit(c, "identity_removed", fmt.Sprintf("user_id=%s provider=%s subject=%s", identity.UserID, identity.Provider, identity.Subject))
+	return c.JSON(fiber.Map{
+		"message": "Identity removed successfully",
+	})
+}
+
+// MergeUsers handles POST /admin/api/users/:id/merge
+func (h *IdentityHandler) MergeUsers(c *fiber.Ctx) error {
+	var req struct {
+		SourceUserID string `json:"source_user_id"`
+	}
+	if err := c.BodyParser(&req); err != nil || req.SourceUserID == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "source_user_id is required",
+		})
+	}
+
+	user, err := h.identityService.MergeUsers(c.Context(), c.Params("id"), req.SourceUserID)
+	if err != nil {
+		return h.identityError(c, err)
+	}
+
+	// The source is gone downstream too; the target may have gained roles
+	h.provisioningService.UserChanged(c.Context(), req.SourceUserID, "user_merged")
+	h.provisioningService.UserChanged(c.Context(), user.ID, "user_merged")
+
+	h.audit(c, "users_merged", fmt.Sprintf("target_user_id=%s source_user_id=%s", user.ID, req.SourceUserID))
+	return c.JSON(user)
+}
+
+// parseReauth reads the optional re-authentication body
+func parseReauth(c *fiber.Ctx) (*reauthRequest, error) {
+	var req reauthRequest
+	if len(c.Body()) == 0 {
+		return &req, nil
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return nil, err
+	}
+	return &req, nil
+}
+
+func (h *IdentityHandler) identityError(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, service.ErrReauthenticationRequired):
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	case errors.Is(err, service.ErrInvalidCredentials):
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "invalid password",
+		})
+	case errors.Is(err, service.ErrInvalidTOTPCode):
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "invalid verification code",
+		})
+	case errors.Is(err, service.ErrTwoFactorLocked):
+		return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	case errors.Is(err, service.ErrDirectoryUnavailable):
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{
+			"error": "directory is unavailable",
+		})
+	case errors.Is(err, repository.ErrIdentityNotFound):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "identity not found",
+		})
+	case errors.Is(err, repository.ErrNotFound):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "user not found",
+		})
+	case errors.Is(err, service.ErrMergeSameUser):
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		"error": "failed to process identity request",
+	})
+}
+
+// audit records changes to how users sign in
+func (h *IdentityHandler) audit(c *fiber.Ctx, action, details string) {
+	recordAudit(c, h.auditRepo, action, "identity", details)
+}
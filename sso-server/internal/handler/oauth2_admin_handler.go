@@ -1,7 +1,12 @@
 package handler
 
 import (
+	"errors"
+	"fmt"
+	"time"
+
 	"github.com/gofiber/fiber/v2"
+	"github.com/sso-project/sso-server/internal/repository"
 	"github.com/sso-project/sso-server/internal/service"
 )
 
@@ -9,16 +14,28 @@ import (
 type OAuth2AdminHandler struct {
 	clientService  *service.OAuth2ClientService
 	consentService *service.OAuth2ConsentService
+	tokenService   *service.OAuth2TokenService
+	subjectService *service.OAuth2SubjectService
+	auditRepo      *repository.AuditLogRepository
+	pages          *OAuth2Pages
 }
 
 // NewOAuth2AdminHandler creates a new OAuth2AdminHandler
 func NewOAuth2AdminHandler(
 	clientService *service.OAuth2ClientService,
 	consentService *service.OAuth2ConsentService,
+	tokenService *service.OAuth2TokenService,
+	subjectService *service.OAuth2SubjectService,
+	auditRepo *repository.AuditLogRepository,
+	pages *OAuth2Pages,
 ) *OAuth2AdminHandler {
 	return &OAuth2AdminHandler{
 		clientService:  clientService,
 		consentService: consentService,
+		tokenService:   tokenService,
+		subjectService: subjectService,
+		auditRepo:      auditRepo,
+		pages:          pages,
 	}
 }
 
@@ -43,6 +60,12 @@ func (h *OAuth2AdminHandler) RegisterClient(c *fiber.Ctx) error {
 	// Set owner
 	req.OwnerUserID = &userIDStr
 
+	if !h.pages.HasTheme(req.Theme) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "unknown theme: " + req.Theme,
+		})
+	}
+
 	// Register client
 	client, clientSecret, err := h.clientService.RegisterClient(c.Context(), req)
 	if err != nil {
@@ -109,6 +132,103 @@ func (h *OAuth2AdminHandler) RegenerateSecret(c *fiber.Ctx) error {
 	})
 }
 
+// UpdateBranding handles PUT /admin/api/oauth2-clients/:client_id/branding
+func (h *OAuth2AdminHandler) UpdateBranding(c *fiber.Ctx) error {
+	var req service.ClientBranding
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "invalid request body",
+		})
+	}
+
+	if !h.pages.HasTheme(req.Theme) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "unknown theme: " + req.Theme,
+		})
+	}
+
+	client, err := h.clientService.UpdateBranding(c.Context(), c.Params("client_id"), req)
+	if err != nil {
+		if errors.Is(err, repository.ErrClientNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "client not found",
+			})
+		}
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return c.JSON(client)
+}
+
+// UpdateConsentPolicy handles PUT /admin/api/oauth2-clients/:client_id/consent-policy
+func (h *OAuth2AdminHandler) UpdateConsentPolicy(c *fiber.Ctx) error {
+	var req service.ConsentSettings
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "invalid request body",
+		})
+	}
+
+	clientID := c.Params("client_id")
+	client, err := h.clientService.UpdateConsentPolicy(c.Context(), clientID, req)
+	if err != nil {
+		if errors.Is(err, repository.ErrClientNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "client not found",
+			})
+		}
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	// Skipping consent is a trust decision, so keep a record of it
+	recordAudit(c, h.auditRepo, "oauth2_consent_policy_updated", "oauth2_client",
+		fmt.Sprintf("client_id=%s consent_policy=%s first_party=%t consent_lifetime=%d policy_version=%s",
+			clientID, client.ConsentPolicy, client.IsFirstParty, client.ConsentLifetime, client.PolicyVersion))
+
+	return c.JSON(client)
+}
+
+// UpdateTokenSettings handles PUT /admin/api/oauth2-clients/:client_id/token-settings
+func (h *OAuth2AdminHandler) UpdateTokenSettings(c *fiber.Ctx) error {
+	var req service.TokenSettings
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "invalid request body",
+		})
+	}
+
+	clientID := c.Params("client_id")
+	client, err := h.clientService.UpdateTokenSettings(c.Context(), clientID, req)
+	if err != nil {
+		if errors.Is(err, repository.ErrClientNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "client not found",
+			})
+		}
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	// Longer lifetimes widen the window a leaked token can be used in
+	recordAudit(c, h.auditRepo, "oauth2_token_settings_updated", "oauth2_client",
+		fmt.Sprintf("client_id=%s format=%s access_ttl=%d id_ttl=%d refresh_ttl=%d refresh_absolute_ttl=%d refresh_idle_ttl=%d",
+			clientID, client.AccessTokenFormat, client.AccessTokenTTL, client.IDTokenTTL, client.RefreshTokenTTL, client.RefreshAbsoluteTTL, client.RefreshIdleTTL))
+
+	return c.JSON(client)
+}
+
+// GetThemes handles GET /admin/api/oauth2-themes
+func (h *OAuth2AdminHandler) GetThemes(c *fiber.Ctx) error {
+	return c.JSON(fiber.Map{
+		"themes": h.pages.Themes(),
+	})
+}
+
 // RevokeClient handles DELETE /admin/oauth2/clients/:client_id
 func (h *OAuth2AdminHandler) RevokeClient(c *fiber.Ctx) error {
 	clientID := c.Params("client_id")
@@ -124,6 +244,37 @@ func (h *OAuth2AdminHandler) RevokeClient(c *fiber.Ctx) error {
 	})
 }
 
+// LookupSubject handles GET /admin/api/oauth2-subjects/:subject?client_id=...
+// It resolves the "sub" a client sees (public or pairwise) back to the local user.
+func (h *OAuth2AdminHandler) LookupSubject(c *fiber.Ctx) error {
+	clientID := c.Query("client_id")
+	if clientID == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "client_id is required",
+		})
+	}
+
+	lookup, err := h.subjectService.ResolveSubject(c.Context(), clientID, c.Params("subject"))
+	if err != nil {
+		switch {
+		case errors.Is(err, repository.ErrClientNotFound):
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "client not found",
+			})
+		case errors.Is(err, repository.ErrSubjectNotFound):
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "subject not found",
+			})
+		default:
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "failed to resolve subject",
+			})
+		}
+	}
+
+	return c.JSON(lookup)
+}
+
 // GetUserConsents handles GET /user/oauth2/consents
 func (h *OAuth2AdminHandler) GetUserConsents(c *fiber.Ctx) error {
 	userID := c.Locals("user_id")
@@ -165,3 +316,153 @@ func (h *OAuth2AdminHandler) RevokeConsent(c *fiber.Ctx) error {
 		"message": "Consent revoked successfully",
 	})
 }
+
+// GetConsentHistory handles GET /user/oauth2/consents/history
+func (h *OAuth2AdminHandler) GetConsentHistory(c *fiber.Ctx) error {
+	userID := c.Locals("user_id")
+	if userID == nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "unauthorized",
+		})
+	}
+
+	return h.consentHistory(c, userID.(string), c.Query("client_id"))
+}
+
+// GetAllConsentHistory handles GET /admin/api/oauth2-consent-history
+// Supports user_id and client_id filters.
+func (h *OAuth2AdminHandler) GetAllConsentHistory(c *fiber.Ctx) error {
+	return h.consentHistory(c, c.Query("user_id"), c.Query("client_id"))
+}
+
+func (h *OAuth2AdminHandler) consentHistory(c *fiber.Ctx, userID, clientID string) error {
+	page := c.QueryInt("page", 1)
+	limit := c.QueryInt("limit", 20)
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+
+	entries, total, err := h.consentService.GetConsentHistory(c.Context(), userID, clientID, page, limit)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "failed to retrieve consent history",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"history": entries,
+		"total":   total,
+		"page":    page,
+		"limit":   limit,
+	})
+}
+
+// GetActiveTokens handles GET /admin/api/oauth2-tokens
+// Supports user_id, client_id, issued_after and issued_before (RFC 3339) filters.
+func (h *OAuth2AdminHandler) GetActiveTokens(c *fiber.Ctx) error {
+	page := c.QueryInt("page", 1)
+	limit := c.QueryInt("limit", 20)
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+
+	filter, err := parseTokenFilter(c.Query("user_id"), c.Query("client_id"), c.Query("issued_after"), c.Query("issued_before"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	tokens, err := h.tokenService.ListActiveTokens(c.Context(), filter, page, limit)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "failed to fetch tokens",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"access_tokens":        tokens.AccessTokens,
+		"refresh_tokens":       tokens.RefreshTokens,
+		"total_access_tokens":  tokens.TotalAccessTokens,
+		"total_refresh_tokens": tokens.TotalRefreshTokens,
+		"page":                 page,
+		"limit":                limit,
+	})
+}
+
+// RevokeTokens handles POST /admin/api/oauth2-tokens/revoke
+func (h *OAuth2AdminHandler) RevokeTokens(c *fiber.Ctx) error {
+	var req struct {
+		UserID       string `json:"user_id"`
+		ClientID     string `json:"client_id"`
+		IssuedAfter  string `json:"issued_after"`
+		IssuedBefore string `json:"issued_before"`
+	}
+
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "invalid request body",
+		})
+	}
+
+	filter, err := parseTokenFilter(req.UserID, req.ClientID, req.IssuedAfter, req.IssuedBefore)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	revoked, err := h.tokenService.BulkRevokeTokens(c.Context(), filter)
+	if err != nil {
+		if errors.Is(err, service.ErrTokenFilterRequired) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "failed to revoke tokens",
+		})
+	}
+
+	// Record who revoked what
+	recordAudit(c, h.auditRepo, "oauth2_tokens_revoked", "oauth2_token",
+		fmt.Sprintf("user_id=%s client_id=%s issued_after=%s issued_before=%s access=%d refresh=%d",
+			req.UserID, req.ClientID, req.IssuedAfter, req.IssuedBefore, revoked.AccessTokens, revoked.RefreshTokens))
+
+	return c.JSON(fiber.Map{
+		"message": "Tokens revoked successfully",
+		"revoked": revoked,
+	})
+}
+
+// parseTokenFilter builds a token filter from request values
+func parseTokenFilter(userID, clientID, issuedAfter, issuedBefore string) (repository.TokenFilter, error) {
+	filter := repository.TokenFilter{
+		UserID:   userID,
+		ClientID: clientID,
+	}
+
+	if issuedAfter != "" {
+		t, err := time.Parse(time.RFC3339, issuedAfter)
+		if err != nil {
+			return filter, fmt.Errorf("issued_after must be an RFC 3339 timestamp")
+		}
+		filter.IssuedAfter = &t
+	}
+
+	if issuedBefore != "" {
+		t, err := time.Parse(time.RFC3339, issuedBefore)
+		if err != nil {
+			return filter, fmt.Errorf("issued_before must be an RFC 3339 timestamp")
+		}
+		filter.IssuedBefore = &t
+	}
+
+	return filter, nil
+}
@@ -1,9 +1,11 @@
 package handler
 
 import (
+	"net/url"
 	"strings"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/sso-project/sso-server/internal/models"
 	"github.com/sso-project/sso-server/internal/repository"
 	"github.com/sso-project/sso-server/internal/service"
 )
@@ -14,7 +16,11 @@ type OAuth2Handler struct {
 	tokenService   *service.OAuth2TokenService
 	clientService  *service.OAuth2ClientService
 	consentService *service.OAuth2ConsentService
+	scopeService   *service.OAuth2ScopeService
+	subjectService *service.OAuth2SubjectService
+	issuerService  *service.OAuth2TrustedIssuerService
 	userRepo       *repository.UserRepository
+	pages          *OAuth2Pages
 }
 
 // NewOAuth2Handler creates a new OAuth2Handler
@@ -23,14 +29,22 @@ func NewOAuth2Handler(
 	tokenService *service.OAuth2TokenService,
 	clientService *service.OAuth2ClientService,
 	consentService *service.OAuth2ConsentService,
+	scopeService *service.OAuth2ScopeService,
+	subjectService *service.OAuth2SubjectService,
+	issuerService *service.OAuth2TrustedIssuerService,
 	userRepo *repository.UserRepository,
+	pages *OAuth2Pages,
 ) *OAuth2Handler {
 	return &OAuth2Handler{
 		authzService:   authzService,
 		tokenService:   tokenService,
 		clientService:  clientService,
 		consentService: consentService,
+		scopeService:   scopeService,
+		subjectService: subjectService,
+		issuerService:  issuerService,
 		userRepo:       userRepo,
+		pages:          pages,
 	}
 }
 
@@ -46,61 +60,98 @@ func (h *OAuth2Handler) Authorize(c *fiber.Ctx) error {
 	codeChallengeMethod := c.Query("code_challenge_method")
 
 	// Validate required parameters
-	if responseType != "code" {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error":             "unsupported_response_type",
-			"error_description": "Only authorization code flow is supported",
-		})
-	}
-
 	if clientID == "" || redirectURI == "" {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error":             "invalid_request",
-			"error_description": "Missing required parameters",
-		})
+		return h.pages.RenderError(c, fiber.StatusBadRequest, nil, "invalid_request", "Missing required parameters")
 	}
 
-	// Validate client and redirect URI
+	// Validate client and redirect URI before anything is sent back to it
 	valid, err := h.clientService.ValidateRedirectURI(c.Context(), clientID, redirectURI)
 	if err != nil || !valid {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error":             "invalid_client",
-			"error_description": "Invalid client_id or redirect_uri",
-		})
+		return h.pages.RenderError(c, fiber.StatusBadRequest, nil, "invalid_client", "Invalid client_id or redirect_uri")
+	}
+
+	// Loaded for branding of the hosted pages
+	client, err := h.clientService.GetClient(c.Context(), clientID)
+	if err != nil {
+		return h.pages.RenderError(c, fiber.StatusBadRequest, nil, "invalid_client", "Invalid client_id")
+	}
+
+	if responseType != "code" {
+		return c.Redirect(buildRedirectURL(redirectURI, map[string]string{
+			"error":             "unsupported_response_type",
+			"error_description": "Only authorization code flow is supported",
+			"state":             state,
+		}))
 	}
 
 	// Check if user is authenticated
 	userID := c.Locals("user_id")
 	if userID == nil {
-		// Redirect to login with return URL
-		returnURL := c.OriginalURL()
-		return c.Redirect("http://localhost:3000/login?return_url=" + returnURL)
+		// Send to login, coming back to this request afterwards
+		return c.Redirect(h.pages.LoginURL(c.OriginalURL()))
 	}
 
 	userIDStr := userID.(string)
 
-	// Parse scopes
-	scopes := parseScopes(scope)
+	// Parse scopes (falling back to default scopes when omitted)
+	scopes, err := h.clientService.ResolveScopes(c.Context(), clientID, parseScopes(scope))
+	if err != nil {
+		return h.pages.RenderError(c, fiber.StatusInternalServerError, client, "server_error", "Failed to resolve scopes")
+	}
+	scope = strings.Join(scopes, " ")
+
+	// Never show or record consent for scopes the client may not request
+	if len(scopes) > 0 {
+		valid, err := h.clientService.ValidateScopes(c.Context(), clientID, scopes)
+		if err != nil {
+			return h.pages.RenderError(c, fiber.StatusInternalServerError, client, "server_error", "Failed to validate scopes")
+		}
+		if !valid {
+			return c.Redirect(buildRedirectURL(redirectURI, map[string]string{
+				"error":             "invalid_scope",
+				"error_description": "Invalid scopes for this client",
+				"state":             state,
+			}))
+		}
+	}
 
-	// Check if user has already consented
-	hasConsent, missingScopes, err := h.consentService.CheckConsent(c.Context(), userIDStr, clientID, scopes)
+	// Apply the client's consent policy (always, once, or implied for first-party clients)
+	needsConsent, err := h.consentService.RequiresConsent(c.Context(), client, userIDStr, scopes)
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "server_error",
-		})
+		return h.pages.RenderError(c, fiber.StatusInternalServerError, client, "server_error", "Failed to check consent")
 	}
 
 	// If consent is missing, show consent screen
-	if !hasConsent || len(missingScopes) > 0 {
-		// Redirect to Nuxt UI consent page
-		consentURL := "http://localhost:3000/oauth2-consent?" +
-			"client_id=" + clientID +
-			"&redirect_uri=" + redirectURI +
-			"&scope=" + scope +
-			"&state=" + state +
-			"&code_challenge=" + codeChallenge +
-			"&code_challenge_method=" + codeChallengeMethod
-		return c.Redirect(consentURL)
+	if needsConsent {
+		if !h.pages.Hosted() {
+			return c.Redirect(h.pages.ExternalConsentURL(url.Values{
+				"client_id":             {clientID},
+				"redirect_uri":          {redirectURI},
+				"scope":                 {scope},
+				"state":                 {state},
+				"code_challenge":        {codeChallenge},
+				"code_challenge_method": {codeChallengeMethod},
+			}))
+		}
+
+		scopeDetails, err := h.scopeService.GetScopesByNames(c.Context(), scopes)
+		if err != nil {
+			return h.pages.RenderError(c, fiber.StatusInternalServerError, client, "server_error", "Failed to load scopes")
+		}
+
+		return h.pages.Render(c, fiber.StatusOK, client, "consent", fiber.Map{
+			"title":                 "Authorization Required",
+			"required_scopes":       requiredScopeSet(client),
+			"csrf_token":            h.pages.CSRFToken(c),
+			"client_id":             clientID,
+			"client_name":           client.Name,
+			"redirect_uri":          redirectURI,
+			"scope":                 scope,
+			"scopes":                scopeDetails,
+			"state":                 state,
+			"code_challenge":        codeChallenge,
+			"code_challenge_method": codeChallengeMethod,
+		})
 	}
 
 	// User has consented, generate authorization code
@@ -125,18 +176,18 @@ func (h *OAuth2Handler) Authorize(c *fiber.Ctx) error {
 		methodPtr,
 	)
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "server_error",
-		})
+		return c.Redirect(buildRedirectURL(redirectURI, map[string]string{
+			"error":             "invalid_request",
+			"error_description": err.Error(),
+			"state":             state,
+		}))
 	}
 
 	// Redirect back to client with code
-	redirectURL := redirectURI + "?code=" + code
-	if state != "" {
-		redirectURL += "&state=" + state
-	}
-
-	return c.Redirect(redirectURL)
+	return c.Redirect(buildRedirectURL(redirectURI, map[string]string{
+		"code":  code,
+		"state": state,
+	}))
 }
 
 // AuthorizeConsent handles POST /oauth2/authorize/consent
@@ -152,6 +203,7 @@ func (h *OAuth2Handler) AuthorizeConsent(c *fiber.Ctx) error {
 
 	// Parse form data
 	type ConsentRequest struct {
+		CSRFToken           string `form:"csrf_token"`
 		ClientID            string `form:"client_id"`
 		RedirectURI         string `form:"redirect_uri"`
 		Scope               string `form:"scope"`
@@ -159,6 +211,9 @@ func (h *OAuth2Handler) AuthorizeConsent(c *fiber.Ctx) error {
 		CodeChallenge       string `form:"code_challenge"`
 		CodeChallengeMethod string `form:"code_challenge_method"`
 		Approve             string `form:"approve"`
+		// ScopeSelection is set by consent screens that let the user untick optional
+		// scopes; the ticked scopes are then sent as repeated granted_scope fields.
+		ScopeSelection string `form:"scope_selection"`
 	}
 
 	var req ConsentRequest
@@ -168,17 +223,59 @@ func (h *OAuth2Handler) AuthorizeConsent(c *fiber.Ctx) error {
 		})
 	}
 
+	// Consent must come from a form we rendered (or the details endpoint issued a token for)
+	if !h.pages.ValidCSRFToken(c, req.CSRFToken) {
+		return h.pages.RenderError(c, fiber.StatusForbidden, nil, "invalid_request", "Invalid or missing CSRF token")
+	}
+
+	// Never redirect to a URI the client has not registered
+	valid, err := h.clientService.ValidateRedirectURI(c.Context(), req.ClientID, req.RedirectURI)
+	if err != nil || !valid {
+		return h.pages.RenderError(c, fiber.StatusBadRequest, nil, "invalid_client", "Invalid client_id or redirect_uri")
+	}
+
 	// If user denied consent
 	if req.Approve != "true" {
-		redirectURL := req.RedirectURI + "?error=access_denied"
-		if req.State != "" {
-			redirectURL += "&state=" + req.State
+		return c.Redirect(buildRedirectURL(req.RedirectURI, map[string]string{
+			"error": "access_denied",
+			"state": req.State,
+		}))
+	}
+
+	// Parse scopes (falling back to default scopes when omitted)
+	scopes, err := h.clientService.ResolveScopes(c.Context(), req.ClientID, parseScopes(req.Scope))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "invalid_client",
+		})
+	}
+
+	// Only the scopes the user left ticked are granted and issued
+	if req.ScopeSelection == "true" {
+		client, err := h.clientService.GetClient(c.Context(), req.ClientID)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "invalid_client",
+			})
+		}
+
+		var selected []string
+		for _, value := range c.Request().PostArgs().PeekMulti("granted_scope") {
+			selected = append(selected, string(value))
 		}
-		return c.Redirect(redirectURL)
+		scopes = service.ApproveScopes(client, scopes, selected)
 	}
 
-	// Parse scopes
-	scopes := parseScopes(req.Scope)
+	if len(scopes) > 0 {
+		valid, err := h.clientService.ValidateScopes(c.Context(), req.ClientID, scopes)
+		if err != nil || !valid {
+			return c.Redirect(buildRedirectURL(req.RedirectURI, map[string]string{
+				"error":             "invalid_scope",
+				"error_description": "Invalid scopes for this client",
+				"state":             req.State,
+			}))
+		}
+	}
 
 	// Save consent
 	if err := h.consentService.GrantConsent(c.Context(), userIDStr, req.ClientID, scopes); err != nil {
@@ -215,12 +312,54 @@ func (h *OAuth2Handler) AuthorizeConsent(c *fiber.Ctx) error {
 	}
 
 	// Redirect back to client with code
-	redirectURL := req.RedirectURI + "?code=" + code
-	if req.State != "" {
-		redirectURL += "&state=" + req.State
+	return c.Redirect(buildRedirectURL(req.RedirectURI, map[string]string{
+		"code":  code,
+		"state": req.State,
+	}))
+}
+
+// ConsentDetails handles GET /oauth2/consent/details
+// It gives the consent screen the client name and the description of each requested scope.
+func (h *OAuth2Handler) ConsentDetails(c *fiber.Ctx) error {
+	clientID := c.Query("client_id")
+	if clientID == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":             "invalid_request",
+			"error_description": "Missing required parameters",
+		})
 	}
 
-	return c.Redirect(redirectURL)
+	client, err := h.clientService.GetClient(c.Context(), clientID)
+	if err != nil || !client.IsActive {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":             "invalid_client",
+			"error_description": "Invalid client_id",
+		})
+	}
+
+	scopes, err := h.clientService.ResolveScopes(c.Context(), clientID, parseScopes(c.Query("scope")))
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "server_error",
+		})
+	}
+
+	scopeDetails, err := h.scopeService.GetScopesByNames(c.Context(), scopes)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "server_error",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"client_id":       client.ClientID,
+		"client_name":     client.Name,
+		"scopes":          scopeDetails,
+		"required_scopes": client.RequiredScopes,
+		"policy_uri":      client.PolicyURI,
+		"policy_version":  client.PolicyVersion,
+		"csrf_token":      h.pages.CSRFToken(c),
+	})
 }
 
 // Token handles POST /oauth2/token
@@ -230,6 +369,11 @@ func (h *OAuth2Handler) Token(c *fiber.Ctx) error {
 	clientID := c.FormValue("client_id")
 	clientSecret := c.FormValue("client_secret")
 
+	// The assertion authenticates the workload, so no client secret is needed
+	if grantType == service.GrantTypeJWTBearer {
+		return h.handleJWTBearerGrant(c, clientID)
+	}
+
 	// Validate client credentials
 	_, err := h.clientService.ValidateClient(c.Context(), clientID, clientSecret)
 	if err != nil {
@@ -308,8 +452,12 @@ func (h *OAuth2Handler) handleRefreshTokenGrant(c *fiber.Ctx, clientID string) e
 }
 
 func (h *OAuth2Handler) handleClientCredentialsGrant(c *fiber.Ctx, clientID string) error {
-	scope := c.FormValue("scope")
-	scopes := parseScopes(scope)
+	scopes, err := h.clientService.ResolveScopes(c.Context(), clientID, parseScopes(c.FormValue("scope")))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "invalid_client",
+		})
+	}
 
 	tokenResp, err := h.authzService.ClientCredentialsGrant(c.Context(), clientID, scopes)
 	if err != nil {
@@ -322,6 +470,51 @@ func (h *OAuth2Handler) handleClientCredentialsGrant(c *fiber.Ctx, clientID stri
 	return c.JSON(tokenResp)
 }
 
+// handleJWTBearerGrant exchanges a JWT from a trusted external issuer for an
+// access token of the client the issuer is mapped to (RFC 7523)
+func (h *OAuth2Handler) handleJWTBearerGrant(c *fiber.Ctx, clientID string) error {
+	assertion := c.FormValue("assertion")
+	if assertion == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":             "invalid_request",
+			"error_description": "Missing assertion",
+		})
+	}
+
+	verified, err := h.issuerService.VerifyAssertion(c.Context(), assertion)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":             "invalid_grant",
+			"error_description": err.Error(),
+		})
+	}
+
+	// A client_id, when sent, must be the client the issuer maps to
+	if clientID != "" && clientID != verified.ClientID {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":             "invalid_grant",
+			"error_description": "Assertion is not valid for this client",
+		})
+	}
+
+	scopes, err := h.clientService.ResolveScopes(c.Context(), verified.ClientID, parseScopes(c.FormValue("scope")))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "invalid_client",
+		})
+	}
+
+	tokenResp, err := h.authzService.IssueClientToken(c.Context(), verified.ClientID, scopes, verified.Claims())
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":             "invalid_scope",
+			"error_description": err.Error(),
+		})
+	}
+
+	return c.JSON(tokenResp)
+}
+
 // Revoke handles POST /oauth2/revoke
 func (h *OAuth2Handler) Revoke(c *fiber.Ctx) error {
 	token := c.FormValue("token")
@@ -341,6 +534,37 @@ func (h *OAuth2Handler) Revoke(c *fiber.Ctx) error {
 	return c.SendStatus(fiber.StatusOK)
 }
 
+// Introspect handles POST /oauth2/introspect
+func (h *OAuth2Handler) Introspect(c *fiber.Ctx) error {
+	clientID := c.FormValue("client_id")
+	clientSecret := c.FormValue("client_secret")
+
+	// Only authenticated clients may introspect tokens
+	caller, err := h.clientService.ValidateClient(c.Context(), clientID, clientSecret)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error":             "invalid_client",
+			"error_description": "Invalid client credentials",
+		})
+	}
+
+	token := c.FormValue("token")
+	if token == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "invalid_request",
+		})
+	}
+
+	resp, err := h.tokenService.IntrospectToken(c.Context(), token, c.FormValue("token_type_hint"), caller.ClientID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "server_error",
+		})
+	}
+
+	return c.JSON(resp)
+}
+
 // UserInfo handles GET /oauth2/userinfo
 func (h *OAuth2Handler) UserInfo(c *fiber.Ctx) error {
 	authHeader := c.Get("Authorization")
@@ -377,9 +601,17 @@ func (h *OAuth2Handler) UserInfo(c *fiber.Ctx) error {
 		})
 	}
 
+	// Pairwise clients get their own view of the subject
+	sub, err := h.subjectService.SubjectFor(c.Context(), accessToken.ClientID, user.ID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "server_error",
+		})
+	}
+
 	// OpenID Connect Standard Claims
 	userInfo := fiber.Map{
-		"sub":            user.ID,
+		"sub":            sub,
 		"name":           user.Name,
 		"email":          user.Email,
 		"email_verified": user.EmailVerified,
@@ -397,3 +629,12 @@ func parseScopes(scopeStr string) []string {
 	}
 	return strings.Fields(scopeStr)
 }
+
+// requiredScopeSet lets the consent template tell required scopes from optional ones
+func requiredScopeSet(client *models.OAuth2Client) map[string]bool {
+	required := make(map[string]bool, len(client.RequiredScopes))
+	for _, scope := range client.RequiredScopes {
+		required[scope] = true
+	}
+	return required
+}
@@ -0,0 +1,579 @@
+package handler
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"time"
+
+	"github.com/crewjam/saml"
+	"github.com/gofiber/fiber/v2"
+	"github.com/sso-project/sso-server/internal/models"
+	"github.com/sso-project/sso-server/internal/repository"
+	"github.com/sso-project/sso-server/internal/service"
+)
+
+// upstreamStateCookie carries the pending upstream sign-in to its callback
+const upstreamStateCookie = "oauth2_upstream"
+
+// samlStateCookie carries the pending SAML sign-in to the assertion consumer service
+const samlStateCookie = "oauth2_saml"
+
+const linkExpiredMessage = "Your request to link an account expired. Please start again from your account."
+
+// OAuth2LoginHandler serves the hosted login and 2FA pages used by the authorization flow
+type OAuth2LoginHandler struct {
+	authService     *service.AuthService
+	totpService     *service.TOTPService
+	otpService      *service.OTPService
+	clientService   *service.OAuth2ClientService
+	upstreamService *service.UpstreamOIDCService
+	samlService     *service.SAMLSPService
+	pages           *OAuth2Pages
+}
+
+// NewOAuth2LoginHandler creates a new OAuth2LoginHandler
+func NewOAuth2LoginHandler(
+	authService *service.AuthService,
+	totpService *service.TOTPService,
+	otpService *service.OTPService,
+	clientService *service.OAuth2ClientService,
+	upstreamService *service.UpstreamOIDCService,
+	samlService *service.SAMLSPService,
+	pages *OAuth2Pages,
+) *OAuth2LoginHandler {
+	return &OAuth2LoginHandler{
+		authService:     authService,
+		totpService:     totpService,
+		otpService:      otpService,
+		clientService:   clientService,
+		upstreamService: upstreamService,
+		samlService:     samlService,
+		pages:           pages,
+	}
+}
+
+// LoginPage handles GET /oauth2/login
+func (h *OAuth2LoginHandler) LoginPage(c *fiber.Ctx) error {
+	return h.renderLogin(c, fiber.StatusOK, safeReturnPath(c.Query("return_to")), "", "")
+}
+
+// Login handles POST /oauth2/login
+func (h *OAuth2LoginHandler) Login(c *fiber.Ctx) error {
+	email := c.FormValue("email")
+	returnTo := safeReturnPath(c.FormValue("return_to"))
+
+	if !h.pages.ValidCSRFToken(c, c.FormValue("csrf_token")) {
+		return h.renderLogin(c, fiber.StatusForbidden, returnTo, email, "Your session expired. Please try again.")
+	}
+
+	result, err := h.authService.Login(c.Context(), email, c.FormValue("password"), c.IP(), c.Get("User-Agent"))
+	if err != nil {
+		switch err {
+		case service.ErrInvalidCredentials:
+			return h.renderLogin(c, fiber.StatusUnauthorized, returnTo, email, "Invalid email or password")
+		case service.ErrAccountLocked:
+			return h.renderLogin(c, fiber.StatusForbidden, returnTo, email, "Account is locked. Please try again later.")
+		case service.ErrAccountInactive:
+			return h.renderLogin(c, fiber.StatusForbidden, returnTo, email, "Account is inactive")
+		default:
+			return h.renderLogin(c, fiber.StatusInternalServerError, returnTo, email, "Login failed")
+		}
+	}
+
+	if result.RequiresTwoFactor {
+		return h.renderTwoFactor(c, fiber.StatusOK, returnTo, result.TempToken, result.User.ID, result.TwoFactorMethods, defaultTwoFactorMethod(result.TwoFactorMethods), "")
+	}
+
+	h.setSessionCookie(c, result.Session)
+	return c.Redirect(returnTo)
+}
+
+// Verify2FA handles POST /oauth2/login/2fa
+func (h *OAuth2LoginHandler) Verify2FA(c *fiber.Ctx) error {
+	returnTo := safeReturnPath(c.FormValue("return_to"))
+	tempToken := c.FormValue("temp_token")
+
+	if !h.pages.ValidCSRFToken(c, c.FormValue("csrf_token")) {
+		return h.renderLogin(c, fiber.StatusForbidden, returnTo, "", "Your session expired. Please sign in again.")
+	}
+
+	// The temp token is stored as a short-lived session
+	session, err := h.authService.GetSessionByToken(c.Context(), tempToken)
+	if err != nil || time.Now().After(session.ExpiresAt) {
+		return h.renderLogin(c, fiber.StatusUnauthorized, returnTo, "", "Verification timed out. Please sign in again.")
+	}
+
+	user := &session.User
+	methods := h.authService.TwoFactorMethods(c.Context(), user)
+	if len(methods) == 0 {
+		return h.renderLogin(c, fiber.StatusBadRequest, returnTo, "", "2FA is not enabled for this account")
+	}
+
+	method := c.FormValue("method")
+	verified, err := verifyTwoFactorCode(c.Context(), methods, h.totpService, h.otpService, user.ID, method, c.FormValue("code"))
+	if err != nil {
+		errMsg := "Invalid verification code"
+		switch {
+		case errors.Is(err, service.ErrOTPMethodUnavailable):
+			method, errMsg = defaultTwoFactorMethod(methods), "This verification method is not available for this account"
+		case errors.Is(err, service.ErrOTPCodeExpired):
+			errMsg = "The code has expired or was already used. Please request a new one."
+		case errors.Is(err, service.ErrOTPTooManyAttempts):
+			errMsg = "Too many wrong codes. Please request a new one."
+		case errors.Is(err, service.ErrTwoFactorLocked):
+			h.authService.LogAudit(c.Context(), &user.ID, "2fa_locked", "authentication", c.IP(), c.Get("User-Agent"), "method="+service.TwoFactorMethodTOTP)
+			return h.renderTwoFactor(c, fiber.StatusTooManyRequests, returnTo, tempToken, user.ID, methods, method, "Too many wrong codes. Please try again later, or use another method.")
+		}
+		return h.renderTwoFactor(c, fiber.StatusUnauthorized, returnTo, tempToken, user.ID, methods, method, errMsg)
+	}
+
+	// Swap the temporary session for a real one
+	h.authService.DeleteSessionByToken(c.Context(), tempToken)
+
+	ipAddress := c.IP()
+	userAgent := c.Get("User-Agent")
+
+	realSession, err := h.authService.CreateSessionAfter2FA(c.Context(), user.ID, ipAddress, userAgent)
+	if err != nil {
+		return h.pages.RenderError(c, fiber.StatusInternalServerError, h.clientFor(c.Context(), returnTo), "server_error", "Failed to create session")
+	}
+
+	h.authService.LogAudit(c.Context(), &user.ID, "2fa_verified", "authentication", ipAddress, userAgent, "method="+verified.Method)
+	if verified.BackupCodeUsed {
+		h.authService.LogAudit(c.Context(), &user.ID, "2fa_backup_code_used", "authentication", ipAddress, userAgent, fmt.Sprintf("remaining=%d", verified.BackupCodesRemaining))
+	}
+
+	h.setSessionCookie(c, realSession)
+	return c.Redirect(returnTo)
+}
+
+// ChooseTwoFactor handles POST /oauth2/login/2fa/method, switching the 2FA page
+// to another method of the user. Methods that work by sending a code send one.
+func (h *OAuth2LoginHandler) ChooseTwoFactor(c *fiber.Ctx) error {
+	returnTo := safeReturnPath(c.FormValue("return_to"))
+	tempToken := c.FormValue("temp_token")
+	method := c.FormValue("method")
+
+	if !h.pages.ValidCSRFToken(c, c.FormValue("csrf_token")) {
+		return h.renderLogin(c, fiber.StatusForbidden, returnTo, "", "Your session expired. Please sign in again.")
+	}
+
+	session, err := h.authService.GetSessionByToken(c.Context(), tempToken)
+	if err != nil || time.Now().After(session.ExpiresAt) {
+		return h.renderLogin(c, fiber.StatusUnauthorized, returnTo, "", "Verification timed out. Please sign in again.")
+	}
+
+	user := &session.User
+	methods := h.authService.TwoFactorMethods(c.Context(), user)
+	if method == service.TwoFactorMethodTOTP && slices.Contains(methods, method) {
+		return h.renderTwoFactor(c, fiber.StatusOK, returnTo, tempToken, user.ID, methods, method, "")
+	}
+
+	factor, err := sendTwoFactorCode(c.Context(), methods, h.otpService, user.ID, method)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrOTPResendTooSoon):
+			return h.renderTwoFactor(c, fiber.StatusTooManyRequests, returnTo, tempToken, user.ID, methods, method, "A code was sent recently. Please wait a moment before requesting another.")
+		case errors.Is(err, service.ErrSMSRateLimited):
+			return h.renderTwoFactor(c, fiber.StatusTooManyRequests, returnTo, tempToken, user.ID, methods, defaultTwoFactorMethod(methods), "Too many codes were sent to your phone. Please try again later or use another way.")
+		case errors.Is(err, service.ErrOTPMethodUnavailable), errors.Is(err, service.ErrOTPFactorNotEnabled):
+			return h.renderTwoFactor(c, fiber.StatusBadRequest, returnTo, tempToken, user.ID, methods, defaultTwoFactorMethod(methods), "This verification method is not available for this account")
+		}
+		return h.renderTwoFactor(c, fiber.StatusInternalServerError, returnTo, tempToken, user.ID, methods, defaultTwoFactorMethod(methods), "We could not send you a code. Please try another way.")
+	}
+
+	h.authService.LogAudit(c.Context(), &user.ID, "2fa_code_sent", "authentication", c.IP(), c.Get("User-Agent"), "method="+factor.Method)
+	return h.renderTwoFactor(c, fiber.StatusOK, returnTo, tempToken, user.ID, methods, method, "")
+}
+
+// Connections handles GET /oauth2/login/connections
+func (h *OAuth2LoginHandler) Connections(c *fiber.Ctx) error {
+	conns, err := h.upstreamService.ListActiveConnections(c.Context())
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "failed to fetch connections",
+		})
+	}
+
+	samlConns, err := h.samlService.ListActiveConnections(c.Context())
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "failed to fetch connections",
+		})
+	}
+
+	result := make([]fiber.Map, 0, len(conns)+len(samlConns))
+	for _, conn := range conns {
+		result = append(result, fiber.Map{
+			"name":      conn.Name,
+			"slug":      conn.Slug,
+			"protocol":  "oidc",
+			"login_url": h.pages.publicBaseURL + "/oauth2/login/oidc/" + conn.Slug,
+		})
+	}
+	for _, conn := range samlConns {
+		result = append(result, fiber.Map{
+			"name":      conn.Name,
+			"slug":      conn.Slug,
+			"protocol":  "saml",
+			"login_url": h.pages.publicBaseURL + "/oauth2/login/saml/" + conn.Slug,
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"connections": result,
+	})
+}
+
+// UpstreamLogin handles GET /oauth2/login/oidc/:slug
+func (h *OAuth2LoginHandler) UpstreamLogin(c *fiber.Ctx) error {
+	returnTo := safeReturnPath(c.Query("return_to"))
+
+	authURL, pending, err := h.upstreamService.BeginLogin(c.Context(), c.Params("slug"), returnTo)
+	if err != nil {
+		if errors.Is(err, repository.ErrOIDCConnectionNotFound) || errors.Is(err, service.ErrOIDCConnectionInactive) {
+			return h.renderLogin(c, fiber.StatusNotFound, returnTo, "", "This sign-in option is not available")
+		}
+		return h.renderLogin(c, fiber.StatusBadGateway, returnTo, "", "The identity provider could not be reached. Please try again.")
+	}
+	if c.QueryBool("link") {
+		if pending.Link = h.takeLinkRequest(c); pending.Link == "" {
+			return h.renderLogin(c, fiber.StatusBadRequest, returnTo, "", linkExpiredMessage)
+		}
+	}
+
+	value, err := json.Marshal(pending)
+	if err != nil {
+		return h.renderLogin(c, fiber.StatusInternalServerError, returnTo, "", "Login failed")
+	}
+
+	c.Cookie(&fiber.Cookie{
+		Name:     upstreamStateCookie,
+		Value:    base64.RawURLEncoding.EncodeToString(value),
+		Path:     "/oauth2/login/oidc",
+		MaxAge:   int((10 * time.Minute).Seconds()),
+		HTTPOnly: true,
+		Secure:   h.pages.secureCookies,
+		SameSite: "Lax",
+	})
+
+	return c.Redirect(authURL)
+}
+
+// UpstreamCallback handles GET /oauth2/login/oidc/:slug/callback
+func (h *OAuth2LoginHandler) UpstreamCallback(c *fiber.Ctx) error {
+	pending := h.takeUpstreamState(c)
+	returnTo := "/"
+	if pending != nil {
+		returnTo = safeReturnPath(pending.ReturnTo)
+	}
+
+	if c.Query("error") != "" {
+		return h.renderLogin(c, fiber.StatusUnauthorized, returnTo, "", "Sign-in was cancelled or denied by the identity provider")
+	}
+
+	result, err := h.upstreamService.CompleteLogin(c.Context(), c.Params("slug"), pending, c.Query("state"), c.Query("code"), c.IP(), c.Get("User-Agent"))
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrUpstreamState):
+			return h.renderLogin(c, fiber.StatusBadRequest, returnTo, "", "Your sign-in request expired. Please try again.")
+		case errors.Is(err, service.ErrUpstreamEmailMissing):
+			return h.renderLogin(c, fiber.StatusForbidden, returnTo, "", "The identity provider did not share your email address")
+		case errors.Is(err, service.ErrUpstreamEmailUnverified):
+			return h.renderLogin(c, fiber.StatusForbidden, returnTo, "", "Your email address is not verified with the identity provider")
+		case errors.Is(err, service.ErrUpstreamAccountExists):
+			return h.renderLogin(c, fiber.StatusForbidden, returnTo, "", "An account with this email already exists. Sign in, then link this identity from your account.")
+		case errors.Is(err, service.ErrIdentityInUse):
+			return h.renderLogin(c, fiber.StatusConflict, returnTo, "", "This identity is already linked to another account")
+		case errors.Is(err, service.ErrIdentityLinkExpired):
+			return h.renderLogin(c, fiber.StatusBadRequest, returnTo, "", linkExpiredMessage)
+		case errors.Is(err, service.ErrUpstreamNotProvisioned):
+			return h.renderLogin(c, fiber.StatusForbidden, returnTo, "", "No account exists for this identity. Please contact your administrator.")
+		case errors.Is(err, service.ErrAccountLocked):
+			return h.renderLogin(c, fiber.StatusForbidden, returnTo, "", "Account is locked. Please try again later.")
+		case errors.Is(err, service.ErrAccountInactive):
+			return h.renderLogin(c, fiber.StatusForbidden, returnTo, "", "Account is inactive")
+		case errors.Is(err, repository.ErrOIDCConnectionNotFound), errors.Is(err, service.ErrOIDCConnectionInactive):
+			return h.renderLogin(c, fiber.StatusNotFound, returnTo, "", "This sign-in option is not available")
+		default:
+			return h.renderLogin(c, fiber.StatusBadGateway, returnTo, "", "Sign-in with the identity provider failed")
+		}
+	}
+
+	if result.Linked {
+		return c.Redirect(returnTo)
+	}
+
+	h.setSessionCookie(c, result.Session)
+	return c.Redirect(returnTo)
+}
+
+// SAMLLogin handles GET /oauth2/login/saml/:slug
+func (h *OAuth2LoginHandler) SAMLLogin(c *fiber.Ctx) error {
+	returnTo := safeReturnPath(c.Query("return_to"))
+
+	message, pending, err := h.samlService.BeginLogin(c.Context(), c.Params("slug"), returnTo)
+	if err != nil {
+		if errors.Is(err, repository.ErrSAMLConnectionNotFound) || errors.Is(err, service.ErrSAMLConnectionInactive) {
+			return h.renderLogin(c, fiber.StatusNotFound, returnTo, "", "This sign-in option is not available")
+		}
+		return h.renderLogin(c, fiber.StatusInternalServerError, returnTo, "", "Login failed")
+	}
+	if c.QueryBool("link") {
+		if pending.Link = h.takeLinkRequest(c); pending.Link == "" {
+			return h.renderLogin(c, fiber.StatusBadRequest, returnTo, "", linkExpiredMessage)
+		}
+	}
+
+	value, err := json.Marshal(pending)
+	if err != nil {
+		return h.renderLogin(c, fiber.StatusInternalServerError, returnTo, "", "Login failed")
+	}
+
+	// The identity provider posts the response back cross-site, which only carries
+	// SameSite=None cookies; those require Secure, so plain HTTP setups fall back to Lax
+	sameSite := "Lax"
+	if h.pages.secureCookies {
+		sameSite = "None"
+	}
+	c.Cookie(&fiber.Cookie{
+		Name:     samlStateCookie,
+		Value:    base64.RawURLEncoding.EncodeToString(value),
+		Path:     "/saml/sp",
+		MaxAge:   int((10 * time.Minute).Seconds()),
+		HTTPOnly: true,
+		Secure:   h.pages.secureCookies,
+		SameSite: sameSite,
+	})
+
+	if message.Binding == saml.HTTPRedirectBinding {
+		return c.Redirect(message.URL)
+	}
+	return h.pages.Render(c, fiber.StatusOK, nil, "saml_post", fiber.Map{
+		"title":            "Signing In",
+		"service_provider": c.Params("slug"),
+		"url":              message.URL,
+		"field":            message.Field,
+		"value":            message.Value,
+	})
+}
+
+// SAMLAssertionConsumer handles POST /saml/sp/:slug/acs
+func (h *OAuth2LoginHandler) SAMLAssertionConsumer(c *fiber.Ctx) error {
+	pending := h.takeSAMLState(c)
+	returnTo := "/"
+	if pending != nil {
+		returnTo = safeReturnPath(pending.ReturnTo)
+	}
+
+	result, err := h.samlService.CompleteLogin(c.Context(), c.Params("slug"), pending, c.FormValue("SAMLResponse"), c.IP(), c.Get("User-Agent"))
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrUpstreamState):
+			return h.renderLogin(c, fiber.StatusBadRequest, returnTo, "", "Your sign-in request expired. Please try again.")
+		case errors.Is(err, service.ErrUpstreamEmailMissing):
+			return h.renderLogin(c, fiber.StatusForbidden, returnTo, "", "The identity provider did not share your email address")
+		case errors.Is(err, service.ErrUpstreamEmailUnverified):
+			return h.renderLogin(c, fiber.StatusForbidden, returnTo, "", "Your email address is not verified with the identity provider")
+		case errors.Is(err, service.ErrUpstreamAccountExists):
+			return h.renderLogin(c, fiber.StatusForbidden, returnTo, "", "An account with this email already exists. Sign in, then link this identity from your account.")
+		case errors.Is(err, service.ErrIdentityInUse):
+			return h.renderLogin(c, fiber.StatusConflict, returnTo, "", "This identity is already linked to another account")
+		case errors.Is(err, service.ErrIdentityLinkExpired):
+			return h.renderLogin(c, fiber.StatusBadRequest, returnTo, "", linkExpiredMessage)
+		case errors.Is(err, service.ErrUpstreamNotProvisioned):
+			return h.renderLogin(c, fiber.StatusForbidden, returnTo, "", "No account exists for this identity. Please contact your administrator.")
+		case errors.Is(err, service.ErrAccountLocked):
+			return h.renderLogin(c, fiber.StatusForbidden, returnTo, "", "Account is locked. Please try again later.")
+		case errors.Is(err, service.ErrAccountInactive):
+			return h.renderLogin(c, fiber.StatusForbidden, returnTo, "", "Account is inactive")
+		case errors.Is(err, repository.ErrSAMLConnectionNotFound), errors.Is(err, service.ErrSAMLConnectionInactive):
+			return h.renderLogin(c, fiber.StatusNotFound, returnTo, "", "This sign-in option is not available")
+		case errors.Is(err, service.ErrSAMLResponse), errors.Is(err, service.ErrSAMLTransientNameID):
+			return h.renderLogin(c, fiber.StatusUnauthorized, returnTo, "", "The identity provider's response could not be accepted")
+		default:
+			return h.renderLogin(c, fiber.StatusInternalServerError, returnTo, "", "Sign-in with the identity provider failed")
+		}
+	}
+
+	if result.Linked {
+		return c.Redirect(returnTo, fiber.StatusSeeOther)
+	}
+
+	h.setSessionCookie(c, result.Session)
+	return c.Redirect(returnTo, fiber.StatusSeeOther)
+}
+
+// SAMLMetadata handles GET /saml/sp/:slug/metadata
+func (h *OAuth2LoginHandler) SAMLMetadata(c *fiber.Ctx) error {
+	metadata, err := h.samlService.Metadata(c.Context(), c.Params("slug"))
+	if err != nil {
+		if errors.Is(err, repository.ErrSAMLConnectionNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "connection not found",
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "failed to build metadata",
+		})
+	}
+
+	c.Set(fiber.HeaderContentType, "application/samlmetadata+xml")
+	return c.Send(metadata)
+}
+
+// takeSAMLState reads and clears the pending SAML sign-in, so a response can only
+// be consumed once per request
+func (h *OAuth2LoginHandler) takeSAMLState(c *fiber.Ctx) *service.SAMLLoginState {
+	value := c.Cookies(samlStateCookie)
+	c.Cookie(&fiber.Cookie{
+		Name:     samlStateCookie,
+		Path:     "/saml/sp",
+		Expires:  time.Unix(0, 0),
+		HTTPOnly: true,
+		Secure:   h.pages.secureCookies,
+		SameSite: "Lax",
+	})
+
+	raw, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil || len(raw) == 0 {
+		return nil
+	}
+
+	var pending service.SAMLLoginState
+	if err := json.Unmarshal(raw, &pending); err != nil {
+		return nil
+	}
+	return &pending
+}
+
+// takeLinkRequest reads and clears the link request set by IdentityHandler.BeginLink,
+// which turns the sign-in it starts into linking the account
+func (h *OAuth2LoginHandler) takeLinkRequest(c *fiber.Ctx) string {
+	value := c.Cookies(identityLinkCookie)
+	c.Cookie(&fiber.Cookie{
+		Name:     identityLinkCookie,
+		Path:     identityLinkCookiePath,
+		Expires:  time.Unix(0, 0),
+		HTTPOnly: true,
+		Secure:   h.pages.secureCookies,
+		SameSite: "Lax",
+	})
+	return value
+}
+
+// takeUpstreamState reads and clears the pending upstream sign-in, so a callback
+// can only be completed once
+func (h *OAuth2LoginHandler) takeUpstreamState(c *fiber.Ctx) *service.UpstreamLoginState {
+	value := c.Cookies(upstreamStateCookie)
+	c.Cookie(&fiber.Cookie{
+		Name:     upstreamStateCookie,
+		Path:     "/oauth2/login/oidc",
+		Expires:  time.Unix(0, 0),
+		HTTPOnly: true,
+		Secure:   h.pages.secureCookies,
+		SameSite: "Lax",
+	})
+
+	raw, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil || len(raw) == 0 {
+		return nil
+	}
+
+	var pending service.UpstreamLoginState
+	if err := json.Unmarshal(raw, &pending); err != nil {
+		return nil
+	}
+	return &pending
+}
+
+func (h *OAuth2LoginHandler) renderLogin(c *fiber.Ctx, status int, returnTo, email, errMsg string) error {
+	// Upstream providers are offered as "Sign in with" buttons below the form
+	connections, _ := h.upstreamService.ListActiveConnections(c.Context())
+	samlConnections, _ := h.samlService.ListActiveConnections(c.Context())
+
+	return h.pages.Render(c, status, h.clientFor(c.Context(), returnTo), "login", fiber.Map{
+		"title":            "Sign In",
+		"csrf_token":       h.pages.CSRFToken(c),
+		"return_to":        returnTo,
+		"email":            email,
+		"error":            errMsg,
+		"connections":      connections,
+		"saml_connections": samlConnections,
+	})
+}
+
+// renderTwoFactor shows the 2FA page asking for a code of method, if any, and
+// offering the user's other methods
+func (h *OAuth2LoginHandler) renderTwoFactor(c *fiber.Ctx, status int, returnTo, tempToken, userID string, methods []string, method, errMsg string) error {
+	// Tell users of a code sent to them where to look for it
+	var destination string
+	if method != "" && method != service.TwoFactorMethodTOTP {
+		factors, _ := h.otpService.ListFactors(c.Context(), userID)
+		for _, factor := range factors {
+			if factor.Method == method {
+				destination = service.MaskDestination(factor.Destination)
+			}
+		}
+	}
+
+	return h.pages.Render(c, status, h.clientFor(c.Context(), returnTo), "two_factor", fiber.Map{
+		"title":       "Two-Factor Authentication",
+		"csrf_token":  h.pages.CSRFToken(c),
+		"return_to":   returnTo,
+		"temp_token":  tempToken,
+		"method":      method,
+		"destination": destination,
+		"totp":        slices.Contains(methods, service.TwoFactorMethodTOTP),
+		"webauthn":    slices.Contains(methods, service.TwoFactorMethodWebAuthn),
+		"email":       slices.Contains(methods, service.TwoFactorMethodEmail),
+		"sms":         slices.Contains(methods, service.TwoFactorMethodSMS),
+		"voice":       slices.Contains(methods, service.TwoFactorMethodVoice),
+		"error":       errMsg,
+	})
+}
+
+// defaultTwoFactorMethod is the method the 2FA page first asks a code for. Codes
+// are not sent unasked, so only TOTP is; otherwise users pick a method.
+func defaultTwoFactorMethod(methods []string) string {
+	if slices.Contains(methods, service.TwoFactorMethodTOTP) {
+		return service.TwoFactorMethodTOTP
+	}
+	return ""
+}
+
+// clientFor finds the client of the authorization request being returned to, for branding
+func (h *OAuth2LoginHandler) clientFor(ctx context.Context, returnTo string) *models.OAuth2Client {
+	u, err := url.Parse(returnTo)
+	if err != nil {
+		return nil
+	}
+
+	clientID := u.Query().Get("client_id")
+	if clientID == "" {
+		return nil
+	}
+
+	client, err := h.clientService.GetClient(ctx, clientID)
+	if err != nil || !client.IsActive {
+		return nil
+	}
+
+	return client
+}
+
+func (h *OAuth2LoginHandler) setSessionCookie(c *fiber.Ctx, session *models.Session) {
+	c.Cookie(&fiber.Cookie{
+		Name:     "session_token",
+		Value:    session.SessionToken,
+		Expires:  session.ExpiresAt,
+		HTTPOnly: true,
+		Secure:   h.pages.secureCookies,
+		SameSite: "Lax",
+	})
+}
@@ -0,0 +1,274 @@
+package handler
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/sso-project/sso-server/internal/models"
+)
+
+const (
+	csrfCookieName   = "oauth2_csrf"
+	defaultThemeName = "default"
+)
+
+var colorPattern = regexp.MustCompile(`^#[0-9a-fA-F]{3,8}$`)
+
+// OAuth2Theme describes the colors of a hosted page theme
+type OAuth2Theme struct {
+	Name           string `json:"name"`
+	PrimaryColor   string `json:"primary_color"`
+	SecondaryColor string `json:"secondary_color"`
+	// Overrides lists the page templates this theme replaces
+	Overrides []string `json:"overrides"`
+
+	templates *template.Template
+}
+
+// OAuth2Pages renders the hosted login, 2FA, consent and error pages.
+// When an external UI URL is configured, login and consent are delegated to it instead.
+type OAuth2Pages struct {
+	themes        map[string]*OAuth2Theme
+	publicBaseURL string
+	externalUIURL string
+	secureCookies bool
+}
+
+// NewOAuth2Pages loads the page templates from templateDir and every theme under
+// templateDir/themes/<name>. A theme may provide theme.json (colors) and any of the
+// page templates to override them.
+func NewOAuth2Pages(templateDir, publicBaseURL, externalUIURL string, secureCookies bool) (*OAuth2Pages, error) {
+	base, err := template.ParseGlob(filepath.Join(templateDir, "*.html"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse oauth2 templates: %w", err)
+	}
+
+	pages := &OAuth2Pages{
+		themes: map[string]*OAuth2Theme{
+			defaultThemeName: {
+				Name:           defaultThemeName,
+				PrimaryColor:   "#667eea",
+				SecondaryColor: "#764ba2",
+				Overrides:      []string{},
+				templates:      base,
+			},
+		},
+		publicBaseURL: strings.TrimRight(publicBaseURL, "/"),
+		externalUIURL: strings.TrimRight(externalUIURL, "/"),
+		secureCookies: secureCookies,
+	}
+
+	entries, err := os.ReadDir(filepath.Join(templateDir, "themes"))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read oauth2 themes: %w", err)
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		theme, err := loadTheme(base, filepath.Join(templateDir, "themes", entry.Name()), pages.themes[defaultThemeName])
+		if err != nil {
+			return nil, fmt.Errorf("failed to load oauth2 theme %s: %w", entry.Name(), err)
+		}
+		pages.themes[theme.Name] = theme
+	}
+
+	return pages, nil
+}
+
+// loadTheme builds a theme from its directory, inheriting anything it does not set
+func loadTheme(base *template.Template, dir string, fallback *OAuth2Theme) (*OAuth2Theme, error) {
+	theme := &OAuth2Theme{
+		Name:           filepath.Base(dir),
+		PrimaryColor:   fallback.PrimaryColor,
+		SecondaryColor: fallback.SecondaryColor,
+		Overrides:      []string{},
+	}
+
+	if data, err := os.ReadFile(filepath.Join(dir, "theme.json")); err == nil {
+		var colors OAuth2Theme
+		if err := json.Unmarshal(data, &colors); err != nil {
+			return nil, err
+		}
+		if colors.PrimaryColor != "" {
+			theme.PrimaryColor = colors.PrimaryColor
+		}
+		if colors.SecondaryColor != "" {
+			theme.SecondaryColor = colors.SecondaryColor
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	if !colorPattern.MatchString(theme.PrimaryColor) || !colorPattern.MatchString(theme.SecondaryColor) {
+		return nil, errors.New("colors must be hex values like #667eea")
+	}
+
+	tmpl, err := base.Clone()
+	if err != nil {
+		return nil, err
+	}
+
+	overrides, err := filepath.Glob(filepath.Join(dir, "*.html"))
+	if err != nil {
+		return nil, err
+	}
+	if len(overrides) > 0 {
+		if tmpl, err = tmpl.ParseFiles(overrides...); err != nil {
+			return nil, err
+		}
+		for _, file := range overrides {
+			theme.Overrides = append(theme.Overrides, strings.TrimSuffix(filepath.Base(file), ".html"))
+		}
+	}
+	theme.templates = tmpl
+
+	return theme, nil
+}
+
+// HasTheme reports whether a theme name is registered (empty means the default theme)
+func (p *OAuth2Pages) HasTheme(name string) bool {
+	if name == "" {
+		return true
+	}
+	_, ok := p.themes[name]
+	return ok
+}
+
+// Themes lists the registered themes
+func (p *OAuth2Pages) Themes() []*OAuth2Theme {
+	themes := make([]*OAuth2Theme, 0, len(p.themes))
+	for _, theme := range p.themes {
+		themes = append(themes, theme)
+	}
+	sort.Slice(themes, func(i, j int) bool { return themes[i].Name < themes[j].Name })
+	return themes
+}
+
+// Hosted reports whether login and consent are served by the SSO server itself
+func (p *OAuth2Pages) Hosted() bool {
+	return p.externalUIURL == ""
+}
+
+// LoginURL returns where to send an unauthenticated user, coming back to returnTo
+// (a path on this server) afterwards
+func (p *OAuth2Pages) LoginURL(returnTo string) string {
+	if p.Hosted() {
+		return p.publicBaseURL + "/oauth2/login?" + url.Values{"return_to": {returnTo}}.Encode()
+	}
+	return p.externalUIURL + "/login?" + url.Values{"return_url": {p.publicBaseURL + returnTo}}.Encode()
+}
+
+// ExternalConsentURL returns the external UI consent page URL for the given parameters
+func (p *OAuth2Pages) ExternalConsentURL(params url.Values) string {
+	return p.externalUIURL + "/oauth2-consent?" + params.Encode()
+}
+
+// Render writes the named page with the given data, branded for the client when one is known
+func (p *OAuth2Pages) Render(c *fiber.Ctx, status int, client *models.OAuth2Client, name string, data fiber.Map) error {
+	theme := p.themes[defaultThemeName]
+	if client != nil {
+		if clientTheme, ok := p.themes[client.Theme]; ok {
+			theme = clientTheme
+		}
+		data["branding"] = fiber.Map{
+			"client_name": client.Name,
+			"logo_uri":    client.LogoURI,
+			"policy_uri":  client.PolicyURI,
+			"tos_uri":     client.TosURI,
+			"support_uri": client.SupportURI,
+		}
+	}
+	data["theme"] = theme
+	data["base_url"] = p.publicBaseURL
+
+	var buf bytes.Buffer
+	if err := theme.templates.ExecuteTemplate(&buf, name+".html", data); err != nil {
+		return err
+	}
+
+	c.Set(fiber.HeaderXFrameOptions, "DENY")
+	c.Set(fiber.HeaderCacheControl, "no-store")
+	c.Type("html", "utf-8")
+	return c.Status(status).Send(buf.Bytes())
+}
+
+// RenderError writes the error page for failures that cannot be redirected to the client
+func (p *OAuth2Pages) RenderError(c *fiber.Ctx, status int, client *models.OAuth2Client, code, description string) error {
+	return p.Render(c, status, client, "error", fiber.Map{
+		"title":             "Authorization Error",
+		"error":             code,
+		"error_description": description,
+	})
+}
+
+// CSRFToken returns the caller's CSRF token, issuing a new one if needed
+func (p *OAuth2Pages) CSRFToken(c *fiber.Ctx) string {
+	if token := c.Cookies(csrfCookieName); token != "" {
+		return token
+	}
+
+	b := make([]byte, 32)
+	rand.Read(b)
+	token := base64.RawURLEncoding.EncodeToString(b)
+
+	c.Cookie(&fiber.Cookie{
+		Name:     csrfCookieName,
+		Value:    token,
+		Path:     "/",
+		HTTPOnly: true,
+		Secure:   p.secureCookies,
+		SameSite: "Lax",
+	})
+
+	return token
+}
+
+// ValidCSRFToken checks a submitted token against the caller's CSRF cookie
+func (p *OAuth2Pages) ValidCSRFToken(c *fiber.Ctx, submitted string) bool {
+	cookie := c.Cookies(csrfCookieName)
+	if cookie == "" || submitted == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(cookie), []byte(submitted)) == 1
+}
+
+// safeReturnPath only allows local paths so the login page cannot be used as an open redirect
+func safeReturnPath(returnTo string) string {
+	if !strings.HasPrefix(returnTo, "/") || strings.HasPrefix(returnTo, "//") || strings.Contains(returnTo, "\\") {
+		return "/"
+	}
+	return returnTo
+}
+
+// buildRedirectURL appends query parameters to a client redirect URI, keeping any it already has
+func buildRedirectURL(redirectURI string, params map[string]string) string {
+	u, err := url.Parse(redirectURI)
+	if err != nil {
+		return redirectURI
+	}
+
+	query := u.Query()
+	for key, value := range params {
+		if value != "" {
+			query.Set(key, value)
+		}
+	}
+	u.RawQuery = query.Encode()
+
+	return u.String()
+}
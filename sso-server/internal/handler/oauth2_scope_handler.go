@@ -0,0 +1,118 @@
+package handler
+
+import (
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/sso-project/sso-server/internal/repository"
+	"github.com/sso-project/sso-server/internal/service"
+)
+
+// OAuth2ScopeHandler handles OAuth2 scope administration endpoints
+type OAuth2ScopeHandler struct {
+	scopeService *service.OAuth2ScopeService
+}
+
+// NewOAuth2ScopeHandler creates a new OAuth2ScopeHandler
+func NewOAuth2ScopeHandler(scopeService *service.OAuth2ScopeService) *OAuth2ScopeHandler {
+	return &OAuth2ScopeHandler{scopeService: scopeService}
+}
+
+// GetScopes handles GET /admin/api/oauth2-scopes
+func (h *OAuth2ScopeHandler) GetScopes(c *fiber.Ctx) error {
+	scopes, err := h.scopeService.ListScopes(c.Context())
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "failed to fetch scopes",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"scopes": scopes,
+		"total":  len(scopes),
+	})
+}
+
+// GetScope handles GET /admin/api/oauth2-scopes/:id
+func (h *OAuth2ScopeHandler) GetScope(c *fiber.Ctx) error {
+	scope, err := h.scopeService.GetScope(c.Context(), c.Params("id"))
+	if err != nil {
+		return h.handleError(c, err, "failed to fetch scope")
+	}
+
+	return c.JSON(scope)
+}
+
+// CreateScope handles POST /admin/api/oauth2-scopes
+func (h *OAuth2ScopeHandler) CreateScope(c *fiber.Ctx) error {
+	var req service.ScopeRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "invalid request body",
+		})
+	}
+
+	scope, err := h.scopeService.CreateScope(c.Context(), req)
+	if err != nil {
+		return h.handleError(c, err, "failed to create scope")
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(scope)
+}
+
+// UpdateScope handles PUT /admin/api/oauth2-scopes/:id
+func (h *OAuth2ScopeHandler) UpdateScope(c *fiber.Ctx) error {
+	var req service.ScopeRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "invalid request body",
+		})
+	}
+
+	scope, err := h.scopeService.UpdateScope(c.Context(), c.Params("id"), req)
+	if err != nil {
+		return h.handleError(c, err, "failed to update scope")
+	}
+
+	return c.JSON(scope)
+}
+
+// DeleteScope handles DELETE /admin/api/oauth2-scopes/:id
+func (h *OAuth2ScopeHandler) DeleteScope(c *fiber.Ctx) error {
+	if err := h.scopeService.DeleteScope(c.Context(), c.Params("id")); err != nil {
+		return h.handleError(c, err, "failed to delete scope")
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Scope deleted successfully",
+	})
+}
+
+// handleError maps scope service errors to HTTP responses
+func (h *OAuth2ScopeHandler) handleError(c *fiber.Ctx, err error, fallback string) error {
+	var inUse *service.ScopeInUseError
+
+	switch {
+	case errors.As(err, &inUse):
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error":      err.Error(),
+			"client_ids": inUse.ClientIDs,
+		})
+	case errors.Is(err, repository.ErrScopeNotFound):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "scope not found",
+		})
+	case errors.Is(err, service.ErrScopeExists):
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	case errors.Is(err, service.ErrScopeNameRequired), errors.Is(err, service.ErrScopeNameInvalid):
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	default:
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": fallback,
+		})
+	}
+}
@@ -0,0 +1,141 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/sso-project/sso-server/internal/models"
+	"github.com/sso-project/sso-server/internal/repository"
+	"github.com/sso-project/sso-server/internal/service"
+)
+
+// OAuth2TrustedIssuerHandler handles trusted issuer management endpoints
+type OAuth2TrustedIssuerHandler struct {
+	issuerService *service.OAuth2TrustedIssuerService
+	auditRepo     *repository.AuditLogRepository
+}
+
+// NewOAuth2TrustedIssuerHandler creates a new OAuth2TrustedIssuerHandler
+func NewOAuth2TrustedIssuerHandler(issuerService *service.OAuth2TrustedIssuerService, auditRepo *repository.AuditLogRepository) *OAuth2TrustedIssuerHandler {
+	return &OAuth2TrustedIssuerHandler{
+		issuerService: issuerService,
+		auditRepo:     auditRepo,
+	}
+}
+
+// GetIssuers handles GET /admin/api/oauth2-trusted-issuers
+func (h *OAuth2TrustedIssuerHandler) GetIssuers(c *fiber.Ctx) error {
+	issuers, err := h.issuerService.ListIssuers(c.Context())
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "failed to fetch trusted issuers",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"trusted_issuers": issuers,
+	})
+}
+
+// GetIssuer handles GET /admin/api/oauth2-trusted-issuers/:id
+func (h *OAuth2TrustedIssuerHandler) GetIssuer(c *fiber.Ctx) error {
+	issuer, err := h.issuerService.GetIssuer(c.Context(), c.Params("id"))
+	if err != nil {
+		return h.issuerError(c, err)
+	}
+
+	return c.JSON(issuer)
+}
+
+// CreateIssuer handles POST /admin/api/oauth2-trusted-issuers
+func (h *OAuth2TrustedIssuerHandler) CreateIssuer(c *fiber.Ctx) error {
+	var req service.TrustedIssuerRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "invalid request body",
+		})
+	}
+
+	issuer, err := h.issuerService.CreateIssuer(c.Context(), req)
+	if err != nil {
+		return h.issuerError(c, err)
+	}
+
+	h.audit(c, "oauth2_trusted_issuer_created", issuer)
+	return c.Status(fiber.StatusCreated).JSON(issuer)
+}
+
+// UpdateIssuer handles PUT /admin/api/oauth2-trusted-issuers/:id
+func (h *OAuth2TrustedIssuerHandler) UpdateIssuer(c *fiber.Ctx) error {
+	var req service.TrustedIssuerRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "invalid request body",
+		})
+	}
+
+	issuer, err := h.issuerService.UpdateIssuer(c.Context(), c.Params("id"), req)
+	if err != nil {
+		return h.issuerError(c, err)
+	}
+
+	h.audit(c, "oauth2_trusted_issuer_updated", issuer)
+	return c.JSON(issuer)
+}
+
+// DeleteIssuer handles DELETE /admin/api/oauth2-trusted-issuers/:id
+func (h *OAuth2TrustedIssuerHandler) DeleteIssuer(c *fiber.Ctx) error {
+	issuer, err := h.issuerService.GetIssuer(c.Context(), c.Params("id"))
+	if err != nil {
+		return h.issuerError(c, err)
+	}
+
+	if err := h.issuerService.DeleteIssuer(c.Context(), issuer.ID); err != nil {
+		return h.issuerError(c, err)
+	}
+
+	h.audit(c, "oauth2_trusted_issuer_deleted", issuer)
+	return c.JSON(fiber.Map{
+		"message": "Trusted issuer deleted successfully",
+	})
+}
+
+func (h *OAuth2TrustedIssuerHandler) issuerError(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, repository.ErrTrustedIssuerNotFound):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "trusted issuer not found",
+		})
+	case errors.Is(err, repository.ErrClientNotFound):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "client not found",
+		})
+	case errors.Is(err, repository.ErrServiceAccountNotFound):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "service account not found",
+		})
+	case errors.Is(err, service.ErrTrustedIssuerExists):
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	case errors.Is(err, service.ErrIssuerRequired),
+		errors.Is(err, service.ErrIssuerKeysRequired),
+		errors.Is(err, service.ErrInvalidJWKSURL),
+		errors.Is(err, service.ErrInsecureJWKSURL),
+		errors.Is(err, service.ErrInvalidJWKS),
+		errors.Is(err, service.ErrIssuerRulesRequired),
+		errors.Is(err, service.ErrIssuerTargetRequired):
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		"error": "failed to save trusted issuer",
+	})
+}
+
+// audit records trust changes, since each one decides who can obtain tokens
+func (h *OAuth2TrustedIssuerHandler) audit(c *fiber.Ctx, action string, issuer *models.OAuth2TrustedIssuer) {
+	recordAudit(c, h.auditRepo, action, "oauth2_trusted_issuer", fmt.Sprintf("issuer=%s client_id=%s", issuer.Issuer, issuer.ClientID))
+}
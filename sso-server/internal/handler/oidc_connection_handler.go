@@ -0,0 +1,131 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/sso-project/sso-server/internal/models"
+	"github.com/sso-project/sso-server/internal/repository"
+	"github.com/sso-project/sso-server/internal/service"
+)
+
+// OIDCConnectionHandler handles upstream OIDC connection management endpoints
+type OIDCConnectionHandler struct {
+	upstreamService *service.UpstreamOIDCService
+	auditRepo       *repository.AuditLogRepository
+}
+
+// NewOIDCConnectionHandler creates a new OIDCConnectionHandler
+func NewOIDCConnectionHandler(upstreamService *service.UpstreamOIDCService, auditRepo *repository.AuditLogRepository) *OIDCConnectionHandler {
+	return &OIDCConnectionHandler{
+		upstreamService: upstreamService,
+		auditRepo:       auditRepo,
+	}
+}
+
+// GetConnections handles GET /admin/api/oidc-connections
+func (h *OIDCConnectionHandler) GetConnections(c *fiber.Ctx) error {
+	conns, err := h.upstreamService.ListConnections(c.Context())
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "failed to fetch connections",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"connections": conns,
+	})
+}
+
+// GetConnection handles GET /admin/api/oidc-connections/:id
+func (h *OIDCConnectionHandler) GetConnection(c *fiber.Ctx) error {
+	conn, err := h.upstreamService.GetConnection(c.Context(), c.Params("id"))
+	if err != nil {
+		return h.connectionError(c, err)
+	}
+
+	return c.JSON(conn)
+}
+
+// CreateConnection handles POST /admin/api/oidc-connections
+func (h *OIDCConnectionHandler) CreateConnection(c *fiber.Ctx) error {
+	var req service.OIDCConnectionRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "invalid request body",
+		})
+	}
+
+	conn, err := h.upstreamService.CreateConnection(c.Context(), req)
+	if err != nil {
+		return h.connectionError(c, err)
+	}
+
+	h.audit(c, "oidc_connection_created", conn)
+	return c.Status(fiber.StatusCreated).JSON(conn)
+}
+
+// UpdateConnection handles PUT /admin/api/oidc-connections/:id
+func (h *OIDCConnectionHandler) UpdateConnection(c *fiber.Ctx) error {
+	var req service.OIDCConnectionRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "invalid request body",
+		})
+	}
+
+	conn, err := h.upstreamService.UpdateConnection(c.Context(), c.Params("id"), req)
+	if err != nil {
+		return h.connectionError(c, err)
+	}
+
+	h.audit(c, "oidc_connection_updated", conn)
+	return c.JSON(conn)
+}
+
+// DeleteConnection handles DELETE /admin/api/oidc-connections/:id
+func (h *OIDCConnectionHandler) DeleteConnection(c *fiber.Ctx) error {
+	conn, err := h.upstreamService.GetConnection(c.Context(), c.Params("id"))
+	if err != nil {
+		return h.connectionError(c, err)
+	}
+
+	if err := h.upstreamService.DeleteConnection(c.Context(), conn.ID); err != nil {
+		return h.connectionError(c, err)
+	}
+
+	h.audit(c, "oidc_connection_deleted", conn)
+	return c.JSON(fiber.Map{
+		"message": "Connection deleted successfully",
+	})
+}
+
+func (h *OIDCConnectionHandler) connectionError(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, repository.ErrOIDCConnectionNotFound):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "connection not found",
+		})
+	case errors.Is(err, service.ErrOIDCConnectionExists):
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	case errors.Is(err, service.ErrOIDCConnectionRequired),
+		errors.Is(err, service.ErrInvalidConnectionSlug),
+		errors.Is(err, service.ErrConnectionSlugFixed),
+		errors.Is(err, service.ErrInvalidDiscoveryURL),
+		errors.Is(err, service.ErrInsecureDiscoveryURL):
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		"error": "failed to save connection",
+	})
+}
+
+// audit records connection changes, since each one decides who can sign in
+func (h *OIDCConnectionHandler) audit(c *fiber.Ctx, action string, conn *models.OIDCConnection) {
+	recordAudit(c, h.auditRepo, action, "oidc_connection", fmt.Sprintf("slug=%s discovery_url=%s", conn.Slug, conn.DiscoveryURL))
+}
@@ -0,0 +1,216 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/sso-project/sso-server/internal/models"
+	"github.com/sso-project/sso-server/internal/repository"
+	"github.com/sso-project/sso-server/internal/service"
+)
+
+// ProvisioningHandler handles outbound provisioning connector endpoints
+type ProvisioningHandler struct {
+	provisioningService *service.ProvisioningService
+	auditRepo           *repository.AuditLogRepository
+}
+
+// NewProvisioningHandler creates a new ProvisioningHandler
+func NewProvisioningHandler(provisioningService *service.ProvisioningService, auditRepo *repository.AuditLogRepository) *ProvisioningHandler {
+	return &ProvisioningHandler{
+		provisioningService: provisioningService,
+		auditRepo:           auditRepo,
+	}
+}
+
+// GetConnectors handles GET /admin/api/provisioning-connectors
+func (h *ProvisioningHandler) GetConnectors(c *fiber.Ctx) error {
+	connectors, err := h.provisioningService.ListConnectors(c.Context())
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "failed to fetch provisioning connectors",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"connectors": connectors,
+	})
+}
+
+// GetConnector handles GET /admin/api/provisioning-connectors/:id
+func (h *ProvisioningHandler) GetConnector(c *fiber.Ctx) error {
+	connector, err := h.provisioningService.GetConnector(c.Context(), c.Params("id"))
+	if err != nil {
+		return h.connectorError(c, err)
+	}
+
+	return c.JSON(connector)
+}
+
+// CreateConnector handles POST /admin/api/provisioning-connectors
+func (h *ProvisioningHandler) CreateConnector(c *fiber.Ctx) error {
+	var req service.ProvisioningConnectorRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "invalid request body",
+		})
+	}
+
+	connector, err := h.provisioningService.CreateConnector(c.Context(), req)
+	if err != nil {
+		return h.connectorError(c, err)
+	}
+
+	h.audit(c, "provisioning_connector_created", connector)
+	return c.Status(fiber.StatusCreated).JSON(connector)
+}
+
+// UpdateConnector handles PUT /admin/api/provisioning-connectors/:id
+func (h *ProvisioningHandler) UpdateConnector(c *fiber.Ctx) error {
+	var req service.ProvisioningConnectorRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "invalid request body",
+		})
+	}
+
+	connector, err := h.provisioningService.UpdateConnector(c.Context(), c.Params("id"), req)
+	if err != nil {
+		return h.connectorError(c, err)
+	}
+
+	h.audit(c, "provisioning_connector_updated", connector)
+	return c.JSON(connector)
+}
+
+// DeleteConnector handles DELETE /admin/api/provisioning-connectors/:id
+func (h *ProvisioningHandler) DeleteConnector(c *fiber.Ctx) error {
+	connector, err := h.provisioningService.GetConnector(c.Context(), c.Params("id"))
+	if err != nil {
+		return h.connectorError(c, err)
+	}
+	if err := h.provisioningService.DeleteConnector(c.Context(), connector.ID); err != nil {
+		return h.connectorError(c, err)
+	}
+
+	h.audit(c, "provisioning_connector_deleted", connector)
+	return c.JSON(fiber.Map{
+		"message": "Provisioning connector deleted successfully",
+	})
+}
+
+// GetStatus handles GET /admin/api/provisioning-connectors/:id/status
+func (h *ProvisioningHandler) GetStatus(c *fiber.Ctx) error {
+	status, err := h.provisioningService.Status(c.Context(), c.Params("id"))
+	if err != nil {
+		return h.connectorError(c, err)
+	}
+
+	return c.JSON(status)
+}
+
+// GetJobs handles GET /admin/api/provisioning-connectors/:id/jobs
+func (h *ProvisioningHandler) GetJobs(c *fiber.Ctx) error {
+	page := c.QueryInt("page", 1)
+	limit := c.QueryInt("limit", 20)
+
+	jobs, total, err := h.provisioningService.ListJobs(c.Context(), c.Params("id"), c.Query("status"), page, limit)
+	if err != nil {
+		return h.connectorError(c, err)
+	}
+
+	return c.JSON(fiber.Map{
+		"jobs":  jobs,
+		"total": total,
+		"page":  page,
+		"limit": limit,
+	})
+}
+
+// Sync handles POST /admin/api/provisioning-connectors/:id/sync
+func (h *ProvisioningHandler) Sync(c *fiber.Ctx) error {
+	connector, err := h.provisioningService.GetConnector(c.Context(), c.Params("id"))
+	if err != nil {
+		return h.connectorError(c, err)
+	}
+
+	queued, err := h.provisioningService.SyncAll(c.Context(), connector.ID)
+	if err != nil {
+		return h.connectorError(c, err)
+	}
+
+	h.audit(c, "provisioning_full_sync", connector)
+	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{
+		"queued": queued,
+	})
+}
+
+// RetryJob handles POST /admin/api/provisioning-jobs/:id/retry
+func (h *ProvisioningHandler) RetryJob(c *fiber.Ctx) error {
+	job, err := h.provisioningService.RetryJob(c.Context(), c.Params("id"))
+	if err != nil {
+		if errors.Is(err, repository.ErrProvisioningJobNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "provisioning job not found",
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "failed to retry provisioning job",
+		})
+	}
+
+	return c.JSON(job)
+}
+
+// GetUserStatus handles GET /admin/api/users/:id/provisioning
+func (h *ProvisioningHandler) GetUserStatus(c *fiber.Ctx) error {
+	states, err := h.provisioningService.UserStatus(c.Context(), c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "failed to fetch provisioning status",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"applications": states,
+	})
+}
+
+func (h *ProvisioningHandler) connectorError(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, repository.ErrProvisioningConnectorNotFound):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "provisioning connector not found",
+		})
+	case errors.Is(err, repository.ErrClientNotFound):
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "client not found",
+		})
+	case errors.Is(err, repository.ErrNotFound):
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "role not found",
+		})
+	case errors.Is(err, service.ErrProvisioningConnectorExists):
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	case errors.Is(err, service.ErrProvisioningConnectorRequired),
+		errors.Is(err, service.ErrInvalidProvisioningURL),
+		errors.Is(err, service.ErrInsecureProvisioningURL),
+		errors.Is(err, service.ErrInvalidProvisioningAuth),
+		errors.Is(err, service.ErrInvalidProvisioningMapping),
+		errors.Is(err, service.ErrInvalidDeprovision):
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		"error": "failed to process provisioning connector",
+	})
+}
+
+// audit records connector changes, since a connector sends user data to another application
+func (h *ProvisioningHandler) audit(c *fiber.Ctx, action string, connector *models.ProvisioningConnector) {
+	recordAudit(c, h.auditRepo, action, "provisioning_connector", fmt.Sprintf("connector_id=%s client_id=%s endpoint_url=%s", connector.ID, connector.ClientID, connector.EndpointURL))
+}
@@ -0,0 +1,137 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/sso-project/sso-server/internal/models"
+	"github.com/sso-project/sso-server/internal/repository"
+	"github.com/sso-project/sso-server/internal/service"
+)
+
+// SAMLConnectionHandler handles upstream SAML connection management endpoints
+type SAMLConnectionHandler struct {
+	samlService *service.SAMLSPService
+	auditRepo   *repository.AuditLogRepository
+}
+
+// NewSAMLConnectionHandler creates a new SAMLConnectionHandler
+func NewSAMLConnectionHandler(samlService *service.SAMLSPService, auditRepo *repository.AuditLogRepository) *SAMLConnectionHandler {
+	return &SAMLConnectionHandler{
+		samlService: samlService,
+		auditRepo:   auditRepo,
+	}
+}
+
+// GetConnections handles GET /admin/api/saml-connections
+func (h *SAMLConnectionHandler) GetConnections(c *fiber.Ctx) error {
+	conns, err := h.samlService.ListConnections(c.Context())
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "failed to fetch connections",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"connections": conns,
+	})
+}
+
+// GetConnection handles GET /admin/api/saml-connections/:id
+func (h *SAMLConnectionHandler) GetConnection(c *fiber.Ctx) error {
+	conn, err := h.samlService.GetConnection(c.Context(), c.Params("id"))
+	if err != nil {
+		return h.connectionError(c, err)
+	}
+
+	return c.JSON(conn)
+}
+
+// CreateConnection handles POST /admin/api/saml-connections
+func (h *SAMLConnectionHandler) CreateConnection(c *fiber.Ctx) error {
+	var req service.SAMLConnectionRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "invalid request body",
+		})
+	}
+
+	conn, err := h.samlService.CreateConnection(c.Context(), req)
+	if err != nil {
+		return h.connectionError(c, err)
+	}
+
+	h.audit(c, "saml_connection_created", conn)
+	return c.Status(fiber.StatusCreated).JSON(conn)
+}
+
+// UpdateConnection handles PUT /admin/api/saml-connections/:id
+func (h *SAMLConnectionHandler) UpdateConnection(c *fiber.Ctx) error {
+	var req service.SAMLConnectionRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "invalid request body",
+		})
+	}
+
+	conn, err := h.samlService.UpdateConnection(c.Context(), c.Params("id"), req)
+	if err != nil {
+		return h.connectionError(c, err)
+	}
+
+	h.audit(c, "saml_connection_updated", conn)
+	return c.JSON(conn)
+}
+
+// DeleteConnection handles DELETE /admin/api/saml-connections/:id
+func (h *SAMLConnectionHandler) DeleteConnection(c *fiber.Ctx) error {
+	conn, err := h.samlService.GetConnection(c.Context(), c.Params("id"))
+	if err != nil {
+		return h.connectionError(c, err)
+	}
+
+	if err := h.samlService.DeleteConnection(c.Context(), conn.ID); err != nil {
+		return h.connectionError(c, err)
+	}
+
+	h.audit(c, "saml_connection_deleted", conn)
+	return c.JSON(fiber.Map{
+		"message": "Connection deleted successfully",
+	})
+}
+
+func (h *SAMLConnectionHandler) connectionError(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, repository.ErrSAMLConnectionNotFound):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "connection not found",
+		})
+	case errors.Is(err, service.ErrSAMLConnectionExists):
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	case errors.Is(err, service.ErrSAMLConnectionRequired),
+		errors.Is(err, service.ErrInvalidConnectionSlug),
+		errors.Is(err, service.ErrConnectionSlugFixed),
+		errors.Is(err, service.ErrInvalidIdPMetadata),
+		errors.Is(err, service.ErrIdPSigningCertRequired),
+		errors.Is(err, service.ErrInvalidIdPMetadataURL),
+		errors.Is(err, service.ErrInsecureIdPMetadataURL):
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	case errors.Is(err, service.ErrIdPMetadataFetch):
+		return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		"error": "failed to save connection",
+	})
+}
+
+// audit records connection changes, since each one decides who can sign in
+func (h *SAMLConnectionHandler) audit(c *fiber.Ctx, action string, conn *models.SAMLConnection) {
+	recordAudit(c, h.auditRepo, action, "saml_connection", fmt.Sprintf("slug=%s idp_entity_id=%s", conn.Slug, conn.IdPEntityID))
+}
@@ -0,0 +1,184 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/crewjam/saml"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/adaptor"
+	"github.com/sso-project/sso-server/internal/models"
+	"github.com/sso-project/sso-server/internal/repository"
+	"github.com/sso-project/sso-server/internal/service"
+)
+
+// SAMLHandler serves the SAML 2.0 identity provider endpoints. Sign-in uses the same
+// session cookie as the OAuth2 flow, so users signed in once are not asked again.
+type SAMLHandler struct {
+	samlService    *service.SAMLIdPService
+	sessionService *service.SessionService
+	auditRepo      *repository.AuditLogRepository
+	pages          *OAuth2Pages
+}
+
+// NewSAMLHandler creates a new SAMLHandler
+func NewSAMLHandler(
+	samlService *service.SAMLIdPService,
+	sessionService *service.SessionService,
+	auditRepo *repository.AuditLogRepository,
+	pages *OAuth2Pages,
+) *SAMLHandler {
+	return &SAMLHandler{
+		samlService:    samlService,
+		sessionService: sessionService,
+		auditRepo:      auditRepo,
+		pages:          pages,
+	}
+}
+
+// Metadata handles GET /saml/metadata
+func (h *SAMLHandler) Metadata(c *fiber.Ctx) error {
+	metadata, err := h.samlService.Metadata()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "failed to build metadata",
+		})
+	}
+
+	c.Set(fiber.HeaderContentType, "application/samlmetadata+xml")
+	return c.Send(metadata)
+}
+
+// SSO handles GET and POST /saml/sso (SP-initiated sign-in)
+func (h *SAMLHandler) SSO(c *fiber.Ctx) error {
+	if c.Method() == fiber.MethodPost {
+		return h.rebind(c, "/saml/sso")
+	}
+
+	r, err := adaptor.ConvertRequest(c, false)
+	if err != nil {
+		return h.samlError(c, err)
+	}
+
+	authn, err := h.samlService.ParseAuthnRequest(c.Context(), r)
+	if err != nil {
+		return h.samlError(c, err)
+	}
+
+	session, ok := c.Locals("session").(*models.Session)
+	if !ok {
+		return c.Redirect(h.pages.LoginURL(c.OriginalURL()))
+	}
+
+	message, err := h.samlService.Respond(c.Context(), authn, session)
+	if err != nil {
+		return h.samlError(c, err)
+	}
+
+	h.audit(c, "saml_sso", message.ServiceProvider)
+	return h.send(c, message)
+}
+
+// InitiateSSO handles GET /saml/init/:id (IdP-initiated sign-in)
+func (h *SAMLHandler) InitiateSSO(c *fiber.Ctx) error {
+	session, ok := c.Locals("session").(*models.Session)
+	if !ok {
+		return c.Redirect(h.pages.LoginURL(c.OriginalURL()))
+	}
+
+	r, err := adaptor.ConvertRequest(c, false)
+	if err != nil {
+		return h.samlError(c, err)
+	}
+
+	message, err := h.samlService.InitiateLogin(c.Context(), r, c.Params("id"), c.Query("RelayState"), session)
+	if err != nil {
+		return h.samlError(c, err)
+	}
+
+	h.audit(c, "saml_sso", message.ServiceProvider)
+	return h.send(c, message)
+}
+
+// SLO handles GET and POST /saml/slo (SP-initiated Single Logout)
+func (h *SAMLHandler) SLO(c *fiber.Ctx) error {
+	if c.Method() == fiber.MethodPost {
+		return h.rebind(c, "/saml/slo")
+	}
+
+	r, err := adaptor.ConvertRequest(c, false)
+	if err != nil {
+		return h.samlError(c, err)
+	}
+
+	logout, err := h.samlService.ParseLogoutRequest(c.Context(), r)
+	if err != nil {
+		return h.samlError(c, err)
+	}
+
+	// End the browser's session unless the SP names a different (already gone) one
+	if session, ok := c.Locals("session").(*models.Session); ok {
+		if index := logout.SessionIndex(); index == "" || index == session.ID {
+			if err := h.sessionService.TerminateSession(c.Context(), session.SessionToken); err != nil {
+				return h.samlError(c, err)
+			}
+			c.ClearCookie("session_token")
+			h.audit(c, "saml_logout", logout.ServiceProvider)
+		}
+	}
+
+	message, err := h.samlService.LogoutResponse(logout)
+	if err != nil {
+		return h.samlError(c, err)
+	}
+
+	return h.send(c, message)
+}
+
+// rebind turns an HTTP-POST binding message into a redirect to the same endpoint,
+// so the browser sends the Lax session cookie along
+func (h *SAMLHandler) rebind(c *fiber.Ctx, path string) error {
+	target, err := service.RebindPost(path, "SAMLRequest", c.FormValue("SAMLRequest"), c.FormValue("RelayState"))
+	if err != nil {
+		return h.samlError(c, err)
+	}
+	return c.Redirect(target, fiber.StatusSeeOther)
+}
+
+// send delivers a message to the service provider with its binding
+func (h *SAMLHandler) send(c *fiber.Ctx, message *service.SAMLMessage) error {
+	if message.Binding == saml.HTTPRedirectBinding {
+		return c.Redirect(message.URL)
+	}
+
+	return h.pages.Render(c, fiber.StatusOK, nil, "saml_post", fiber.Map{
+		"title":            "Signing In",
+		"service_provider": message.ServiceProvider.Name,
+		"url":              message.URL,
+		"field":            message.Field,
+		"value":            message.Value,
+		"relay_state":      message.RelayState,
+	})
+}
+
+func (h *SAMLHandler) samlError(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, repository.ErrSAMLServiceProviderNotFound):
+		return h.pages.RenderError(c, fiber.StatusNotFound, nil, "unknown_service_provider", "The application is not registered with this sign-in service.")
+	case errors.Is(err, service.ErrSAMLServiceProviderInactive):
+		return h.pages.RenderError(c, fiber.StatusForbidden, nil, "access_denied", err.Error())
+	case errors.Is(err, service.ErrAccountInactive), errors.Is(err, service.ErrAccountLocked):
+		return h.pages.RenderError(c, fiber.StatusForbidden, nil, "access_denied", err.Error())
+	case errors.Is(err, service.ErrInvalidSAMLRequest),
+		errors.Is(err, service.ErrSAMLSignature),
+		errors.Is(err, service.ErrSAMLPostACSRequired),
+		errors.Is(err, service.ErrSAMLLogoutUnsupported):
+		return h.pages.RenderError(c, fiber.StatusBadRequest, nil, "invalid_request", err.Error())
+	}
+	return h.pages.RenderError(c, fiber.StatusInternalServerError, nil, "server_error", "The request could not be processed.")
+}
+
+// audit records SAML sign-ins and logouts, since the SP only sees the assertion
+func (h *SAMLHandler) audit(c *fiber.Ctx, action string, sp *models.SAMLServiceProvider) {
+	recordAudit(c, h.auditRepo, action, "saml_service_provider", fmt.Sprintf("entity_id=%s", sp.EntityID))
+}
@@ -0,0 +1,135 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/sso-project/sso-server/internal/models"
+	"github.com/sso-project/sso-server/internal/repository"
+	"github.com/sso-project/sso-server/internal/service"
+)
+
+// SAMLServiceProviderHandler handles SAML service provider management endpoints
+type SAMLServiceProviderHandler struct {
+	samlService *service.SAMLIdPService
+	auditRepo   *repository.AuditLogRepository
+}
+
+// NewSAMLServiceProviderHandler creates a new SAMLServiceProviderHandler
+func NewSAMLServiceProviderHandler(samlService *service.SAMLIdPService, auditRepo *repository.AuditLogRepository) *SAMLServiceProviderHandler {
+	return &SAMLServiceProviderHandler{
+		samlService: samlService,
+		auditRepo:   auditRepo,
+	}
+}
+
+// GetServiceProviders handles GET /admin/api/saml-service-providers
+func (h *SAMLServiceProviderHandler) GetServiceProviders(c *fiber.Ctx) error {
+	sps, err := h.samlService.ListServiceProviders(c.Context())
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "failed to fetch service providers",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"service_providers": sps,
+		"idp_entity_id":     h.samlService.EntityID(),
+	})
+}
+
+// GetServiceProvider handles GET /admin/api/saml-service-providers/:id
+func (h *SAMLServiceProviderHandler) GetServiceProvider(c *fiber.Ctx) error {
+	sp, err := h.samlService.GetServiceProvider(c.Context(), c.Params("id"))
+	if err != nil {
+		return h.serviceProviderError(c, err)
+	}
+
+	return c.JSON(sp)
+}
+
+// CreateServiceProvider handles POST /admin/api/saml-service-providers
+func (h *SAMLServiceProviderHandler) CreateServiceProvider(c *fiber.Ctx) error {
+	var req service.SAMLServiceProviderRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "invalid request body",
+		})
+	}
+
+	sp, err := h.samlService.CreateServiceProvider(c.Context(), req)
+	if err != nil {
+		return h.serviceProviderError(c, err)
+	}
+
+	h.audit(c, "saml_service_provider_created", sp)
+	return c.Status(fiber.StatusCreated).JSON(sp)
+}
+
+// UpdateServiceProvider handles PUT /admin/api/saml-service-providers/:id
+func (h *SAMLServiceProviderHandler) UpdateServiceProvider(c *fiber.Ctx) error {
+	var req service.SAMLServiceProviderRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "invalid request body",
+		})
+	}
+
+	sp, err := h.samlService.UpdateServiceProvider(c.Context(), c.Params("id"), req)
+	if err != nil {
+		return h.serviceProviderError(c, err)
+	}
+
+	h.audit(c, "saml_service_provider_updated", sp)
+	return c.JSON(sp)
+}
+
+// DeleteServiceProvider handles DELETE /admin/api/saml-service-providers/:id
+func (h *SAMLServiceProviderHandler) DeleteServiceProvider(c *fiber.Ctx) error {
+	sp, err := h.samlService.GetServiceProvider(c.Context(), c.Params("id"))
+	if err != nil {
+		return h.serviceProviderError(c, err)
+	}
+
+	if err := h.samlService.DeleteServiceProvider(c.Context(), sp.ID); err != nil {
+		return h.serviceProviderError(c, err)
+	}
+
+	h.audit(c, "saml_service_provider_deleted", sp)
+	return c.JSON(fiber.Map{
+		"message": "Service provider deleted successfully",
+	})
+}
+
+func (h *SAMLServiceProviderHandler) serviceProviderError(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, repository.ErrSAMLServiceProviderNotFound):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "service provider not found",
+		})
+	case errors.Is(err, service.ErrSAMLServiceProviderExists):
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	case errors.Is(err, service.ErrSAMLMetadataRequired),
+		errors.Is(err, service.ErrInvalidSAMLMetadata),
+		errors.Is(err, service.ErrSAMLPostACSRequired),
+		errors.Is(err, service.ErrInvalidSAMLEndpoint),
+		errors.Is(err, service.ErrInsecureSAMLEndpoint),
+		errors.Is(err, service.ErrInvalidSAMLCertificate),
+		errors.Is(err, service.ErrInvalidNameIDFormat),
+		errors.Is(err, service.ErrInvalidAttributeMapping):
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		"error": "failed to save service provider",
+	})
+}
+
+// audit records service provider changes, since each one decides where assertions go
+func (h *SAMLServiceProviderHandler) audit(c *fiber.Ctx, action string, sp *models.SAMLServiceProvider) {
+	recordAudit(c, h.auditRepo, action, "saml_service_provider", fmt.Sprintf("entity_id=%s", sp.EntityID))
+}
@@ -0,0 +1,143 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/sso-project/sso-server/internal/models"
+	"github.com/sso-project/sso-server/internal/repository"
+	"github.com/sso-project/sso-server/internal/service"
+)
+
+// SCIMClientHandler handles SCIM client management endpoints
+type SCIMClientHandler struct {
+	scimService *service.SCIMService
+	auditRepo   *repository.AuditLogRepository
+}
+
+// NewSCIMClientHandler creates a new SCIMClientHandler
+func NewSCIMClientHandler(scimService *service.SCIMService, auditRepo *repository.AuditLogRepository) *SCIMClientHandler {
+	return &SCIMClientHandler{
+		scimService: scimService,
+		auditRepo:   auditRepo,
+	}
+}
+
+// GetClients handles GET /admin/api/scim-clients
+func (h *SCIMClientHandler) GetClients(c *fiber.Ctx) error {
+	clients, err := h.scimService.ListClients(c.Context())
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "failed to fetch SCIM clients",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"scim_clients":  clients,
+		"scim_base_url": h.scimService.BaseURL(),
+	})
+}
+
+// GetClient handles GET /admin/api/scim-clients/:id
+func (h *SCIMClientHandler) GetClient(c *fiber.Ctx) error {
+	client, err := h.scimService.GetClient(c.Context(), c.Params("id"))
+	if err != nil {
+		return h.clientError(c, err)
+	}
+
+	return c.JSON(client)
+}
+
+// CreateClient handles POST /admin/api/scim-clients
+func (h *SCIMClientHandler) CreateClient(c *fiber.Ctx) error {
+	var req service.SCIMClientRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "invalid request body",
+		})
+	}
+
+	client, token, err := h.scimService.CreateClient(c.Context(), req)
+	if err != nil {
+		return h.clientError(c, err)
+	}
+
+	h.audit(c, "scim_client_created", client)
+	return c.Status(fiber.StatusCreated).JSON(h.tokenResponse(client, token))
+}
+
+// UpdateClient handles PUT /admin/api/scim-clients/:id
+func (h *SCIMClientHandler) UpdateClient(c *fiber.Ctx) error {
+	var req service.SCIMClientRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "invalid request body",
+		})
+	}
+
+	client, err := h.scimService.UpdateClient(c.Context(), c.Params("id"), req)
+	if err != nil {
+		return h.clientError(c, err)
+	}
+
+	h.audit(c, "scim_client_updated", client)
+	return c.JSON(client)
+}
+
+// RotateToken handles POST /admin/api/scim-clients/:id/rotate-token
+func (h *SCIMClientHandler) RotateToken(c *fiber.Ctx) error {
+	client, token, err := h.scimService.RotateToken(c.Context(), c.Params("id"))
+	if err != nil {
+		return h.clientError(c, err)
+	}
+
+	h.audit(c, "scim_client_token_rotated", client)
+	return c.JSON(h.tokenResponse(client, token))
+}
+
+// DeleteClient handles DELETE /admin/api/scim-clients/:id
+func (h *SCIMClientHandler) DeleteClient(c *fiber.Ctx) error {
+	client, err := h.scimService.GetClient(c.Context(), c.Params("id"))
+	if err != nil {
+		return h.clientError(c, err)
+	}
+	if err := h.scimService.DeleteClient(c.Context(), client.ID); err != nil {
+		return h.clientError(c, err)
+	}
+
+	h.audit(c, "scim_client_deleted", client)
+	return c.JSON(fiber.Map{
+		"message": "SCIM client deleted successfully",
+	})
+}
+
+// tokenResponse shows the bearer token, which cannot be retrieved again
+func (h *SCIMClientHandler) tokenResponse(client *models.SCIMClient, token string) fiber.Map {
+	return fiber.Map{
+		"scim_client":   client,
+		"token":         token,
+		"scim_base_url": h.scimService.BaseURL(),
+	}
+}
+
+func (h *SCIMClientHandler) clientError(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, repository.ErrSCIMClientNotFound):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "SCIM client not found",
+		})
+	case errors.Is(err, service.ErrSCIMClientRequired):
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		"error": "failed to save SCIM client",
+	})
+}
+
+// audit records SCIM client changes, since a client token can manage every user
+func (h *SCIMClientHandler) audit(c *fiber.Ctx, action string, client *models.SCIMClient) {
+	recordAudit(c, h.auditRepo, action, "scim_client", fmt.Sprintf("scim_client_id=%s name=%s", client.ID, client.Name))
+}
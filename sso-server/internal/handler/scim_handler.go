@@ -0,0 +1,425 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/sso-project/sso-server/internal/models"
+	"github.com/sso-project/sso-server/internal/repository"
+	"github.com/sso-project/sso-server/internal/service"
+)
+
+const scimContentType = "application/scim+json"
+
+// SCIMHandler handles the SCIM 2.0 provisioning endpoints under /scim/v2
+type SCIMHandler struct {
+	scimService *service.SCIMService
+	auditRepo   *repository.AuditLogRepository
+}
+
+// NewSCIMHandler creates a new SCIMHandler
+func NewSCIMHandler(scimService *service.SCIMService, auditRepo *repository.AuditLogRepository) *SCIMHandler {
+	return &SCIMHandler{
+		scimService: scimService,
+		auditRepo:   auditRepo,
+	}
+}
+
+// ==================== Users ====================
+
+// ListUsers handles GET /scim/v2/Users
+func (h *SCIMHandler) ListUsers(c *fiber.Ctx) error {
+	list, err := h.scimService.ListUsers(c.Context(), h.client(c), h.query(c))
+	if err != nil {
+		return h.scimError(c, err)
+	}
+	return c.JSON(list, scimContentType)
+}
+
+// GetUser handles GET /scim/v2/Users/:id
+func (h *SCIMHandler) GetUser(c *fiber.Ctx) error {
+	user, err := h.scimService.GetUser(c.Context(), h.client(c), c.Params("id"))
+	if err != nil {
+		return h.scimError(c, err)
+	}
+	return h.resource(c, fiber.StatusOK, user.Meta, user)
+}
+
+// CreateUser handles POST /scim/v2/Users
+func (h *SCIMHandler) CreateUser(c *fiber.Ctx) error {
+	var in service.SCIMUser
+	if err := json.Unmarshal(c.Body(), &in); err != nil {
+		return h.invalidBody(c)
+	}
+
+	user, err := h.scimService.CreateUser(c.Context(), h.client(c), &in)
+	if err != nil {
+		return h.scimError(c, err)
+	}
+
+	h.audit(c, "scim_user_created", "user_id="+user.ID)
+	return h.resource(c, fiber.StatusCreated, user.Meta, user)
+}
+
+// ReplaceUser handles PUT /scim/v2/Users/:id
+func (h *SCIMHandler) ReplaceUser(c *fiber.Ctx) error {
+	var in service.SCIMUser
+	if err := json.Unmarshal(c.Body(), &in); err != nil {
+		return h.invalidBody(c)
+	}
+
+	user, err := h.scimService.ReplaceUser(c.Context(), h.client(c), c.Params("id"), &in, c.Get(fiber.HeaderIfMatch))
+	if err != nil {
+		return h.scimError(c, err)
+	}
+
+	h.audit(c, "scim_user_updated", "user_id="+user.ID)
+	return h.resource(c, fiber.StatusOK, user.Meta, user)
+}
+
+// PatchUser handles PATCH /scim/v2/Users/:id
+func (h *SCIMHandler) PatchUser(c *fiber.Ctx) error {
+	var patch service.SCIMPatchRequest
+	if err := json.Unmarshal(c.Body(), &patch); err != nil {
+		return h.invalidBody(c)
+	}
+
+	user, err := h.scimService.PatchUser(c.Context(), h.client(c), c.Params("id"), &patch, c.Get(fiber.HeaderIfMatch))
+	if err != nil {
+		return h.scimError(c, err)
+	}
+
+	h.audit(c, "scim_user_updated", "user_id="+user.ID)
+	return h.resource(c, fiber.StatusOK, user.Meta, user)
+}
+
+// DeleteUser handles DELETE /scim/v2/Users/:id
+func (h *SCIMHandler) DeleteUser(c *fiber.Ctx) error {
+	if err := h.scimService.DeleteUser(c.Context(), h.client(c), c.Params("id"), c.Get(fiber.HeaderIfMatch)); err != nil {
+		return h.scimError(c, err)
+	}
+
+	h.audit(c, "scim_user_deleted", "user_id="+c.Params("id"))
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// ==================== Groups ====================
+
+// ListGroups handles GET /scim/v2/Groups
+func (h *SCIMHandler) ListGroups(c *fiber.Ctx) error {
+	list, err := h.scimService.ListGroups(c.Context(), h.client(c), h.query(c))
+	if err != nil {
+		return h.scimError(c, err)
+	}
+	return c.JSON(list, scimContentType)
+}
+
+// GetGroup handles GET /scim/v2/Groups/:id
+func (h *SCIMHandler) GetGroup(c *fiber.Ctx) error {
+	group, err := h.scimService.GetGroup(c.Context(), h.client(c), c.Params("id"))
+	if err != nil {
+		return h.scimError(c, err)
+	}
+	return h.resource(c, fiber.StatusOK, group.Meta, group)
+}
+
+// CreateGroup handles POST /scim/v2/Groups
+func (h *SCIMHandler) CreateGroup(c *fiber.Ctx) error {
+	var in service.SCIMGroup
+	if err := json.Unmarshal(c.Body(), &in); err != nil {
+		return h.invalidBody(c)
+	}
+
+	group, err := h.scimService.CreateGroup(c.Context(), h.client(c), &in)
+	if err != nil {
+		return h.scimError(c, err)
+	}
+
+	h.audit(c, "scim_group_created", "role_id="+group.ID)
+	return h.resource(c, fiber.StatusCreated, group.Meta, group)
+}
+
+// ReplaceGroup handles PUT /scim/v2/Groups/:id
+func (h *SCIMHandler) ReplaceGroup(c *fiber.Ctx) error {
+	var in service.SCIMGroup
+	if err := json.Unmarshal(c.Body(), &in); err != nil {
+		return h.invalidBody(c)
+	}
+
+	group, err := h.scimService.ReplaceGroup(c.Context(), h.client(c), c.Params("id"), &in, c.Get(fiber.HeaderIfMatch))
+	if err != nil {
+		return h.scimError(c, err)
+	}
+
+	h.audit(c, "scim_group_updated", "role_id="+group.ID)
+	return h.resource(c, fiber.StatusOK, group.Meta, group)
+}
+
+// PatchGroup handles PATCH /scim/v2/Groups/:id
+func (h *SCIMHandler) PatchGroup(c *fiber.Ctx) error {
+	var patch service.SCIMPatchRequest
+	if err := json.Unmarshal(c.Body(), &patch); err != nil {
+		return h.invalidBody(c)
+	}
+
+	group, err := h.scimService.PatchGroup(c.Context(), h.client(c), c.Params("id"), &patch, c.Get(fiber.HeaderIfMatch))
+	if err != nil {
+		return h.scimError(c, err)
+	}
+
+	h.audit(c, "scim_group_updated", "role_id="+group.ID)
+	return h.resource(c, fiber.StatusOK, group.Meta, group)
+}
+
+// DeleteGroup handles DELETE /scim/v2/Groups/:id
+func (h *SCIMHandler) DeleteGroup(c *fiber.Ctx) error {
+	if err := h.scimService.DeleteGroup(c.Context(), h.client(c), c.Params("id"), c.Get(fiber.HeaderIfMatch)); err != nil {
+		return h.scimError(c, err)
+	}
+
+	h.audit(c, "scim_group_deleted", "role_id="+c.Params("id"))
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// ==================== Discovery ====================
+
+// GetServiceProviderConfig handles GET /scim/v2/ServiceProviderConfig
+func (h *SCIMHandler) GetServiceProviderConfig(c *fiber.Ctx) error {
+	return c.JSON(fiber.Map{
+		"schemas":          []string{service.SCIMSchemaServiceProviderConfig},
+		"documentationUri": "",
+		"patch":            fiber.Map{"supported": true},
+		"bulk":             fiber.Map{"supported": false, "maxOperations": 0, "maxPayloadSize": 0},
+		"filter":           fiber.Map{"supported": true, "maxResults": service.SCIMMaxResults},
+		"changePassword":   fiber.Map{"supported": true},
+		"sort":             fiber.Map{"supported": false},
+		"etag":             fiber.Map{"supported": true},
+		"authenticationSchemes": []fiber.Map{{
+			"type":        "oauthbearertoken",
+			"name":        "Bearer Token",
+			"description": "Authentication with the bearer token issued to a SCIM client",
+			"primary":     true,
+		}},
+		"meta": fiber.Map{
+			"resourceType": "ServiceProviderConfig",
+			"location":     h.scimService.BaseURL() + "/ServiceProviderConfig",
+		},
+	}, scimContentType)
+}
+
+// GetResourceTypes handles GET /scim/v2/ResourceTypes
+func (h *SCIMHandler) GetResourceTypes(c *fiber.Ctx) error {
+	resourceTypes := h.resourceTypes()
+	return c.JSON(fiber.Map{
+		"schemas":      []string{service.SCIMSchemaListResponse},
+		"totalResults": len(resourceTypes),
+		"startIndex":   1,
+		"itemsPerPage": len(resourceTypes),
+		"Resources":    resourceTypes,
+	}, scimContentType)
+}
+
+// GetResourceType handles GET /scim/v2/ResourceTypes/:id
+func (h *SCIMHandler) GetResourceType(c *fiber.Ctx) error {
+	for _, resourceType := range h.resourceTypes() {
+		if resourceType["id"] == c.Params("id") {
+			return c.JSON(resourceType, scimContentType)
+		}
+	}
+	return h.scimError(c, service.ErrSCIMNotFound)
+}
+
+// GetSchemas handles GET /scim/v2/Schemas
+func (h *SCIMHandler) GetSchemas(c *fiber.Ctx) error {
+	schemas := h.schemas()
+	return c.JSON(fiber.Map{
+		"schemas":      []string{service.SCIMSchemaListResponse},
+		"totalResults": len(schemas),
+		"startIndex":   1,
+		"itemsPerPage": len(schemas),
+		"Resources":    schemas,
+	}, scimContentType)
+}
+
+// GetSchema handles GET /scim/v2/Schemas/:id
+func (h *SCIMHandler) GetSchema(c *fiber.Ctx) error {
+	for _, schema := range h.schemas() {
+		if schema["id"] == c.Params("id") {
+			return c.JSON(schema, scimContentType)
+		}
+	}
+	return h.scimError(c, service.ErrSCIMNotFound)
+}
+
+func (h *SCIMHandler) resourceTypes() []fiber.Map {
+	resourceType := func(name, endpoint, schema string) fiber.Map {
+		return fiber.Map{
+			"schemas":  []string{service.SCIMSchemaResourceType},
+			"id":       name,
+			"name":     name,
+			"endpoint": endpoint,
+			"schema":   schema,
+			"meta": fiber.Map{
+				"resourceType": "ResourceType",
+				"location":     h.scimService.BaseURL() + "/ResourceTypes/" + name,
+			},
+		}
+	}
+	return []fiber.Map{
+		resourceType("User", "/Users", service.SCIMSchemaUser),
+		resourceType("Group", "/Groups", service.SCIMSchemaGroup),
+	}
+}
+
+// schemas describes the attributes this server stores; others are accepted and ignored
+func (h *SCIMHandler) schemas() []fiber.Map {
+	attribute := func(name, typ string, required bool, mutability, uniqueness string, sub ...fiber.Map) fiber.Map {
+		attr := fiber.Map{
+			"name":        name,
+			"type":        typ,
+			"multiValued": false,
+			"required":    required,
+			"caseExact":   false,
+			"mutability":  mutability,
+			"returned":    "default",
+			"uniqueness":  uniqueness,
+		}
+		if mutability == "writeOnly" {
+			attr["returned"] = "never"
+		}
+		if len(sub) > 0 {
+			attr["subAttributes"] = sub
+		}
+		return attr
+	}
+	multiValued := func(attr fiber.Map) fiber.Map {
+		attr["multiValued"] = true
+		return attr
+	}
+	value := func(mutability string) []fiber.Map {
+		return []fiber.Map{
+			attribute("value", "string", true, mutability, "none"),
+			attribute("display", "string", false, "readOnly", "none"),
+			attribute("type", "string", false, mutability, "none"),
+			attribute("$ref", "reference", false, "readOnly", "none"),
+		}
+	}
+	schema := func(id, name string, attributes ...fiber.Map) fiber.Map {
+		return fiber.Map{
+			"schemas":    []string{service.SCIMSchemaSchema},
+			"id":         id,
+			"name":       name,
+			"attributes": attributes,
+			"meta": fiber.Map{
+				"resourceType": "Schema",
+				"location":     h.scimService.BaseURL() + "/Schemas/" + id,
+			},
+		}
+	}
+
+	return []fiber.Map{
+		schema(service.SCIMSchemaUser, "User",
+			attribute("userName", "string", true, "readWrite", "server"),
+			attribute("name", "complex", false, "readWrite", "none",
+				attribute("formatted", "string", false, "readWrite", "none"),
+				attribute("givenName", "string", false, "readWrite", "none"),
+				attribute("familyName", "string", false, "readWrite", "none"),
+			),
+			attribute("displayName", "string", false, "readWrite", "none"),
+			multiValued(attribute("emails", "complex", false, "readWrite", "none", value("readWrite")...)),
+			attribute("active", "boolean", false, "readWrite", "none"),
+			attribute("password", "string", false, "writeOnly", "none"),
+			multiValued(attribute("groups", "complex", false, "readOnly", "none", value("readOnly")...)),
+		),
+		schema(service.SCIMSchemaGroup, "Group",
+			attribute("displayName", "string", true, "readWrite", "server"),
+			multiValued(attribute("members", "complex", false, "readWrite", "none", value("readWrite")...)),
+		),
+	}
+}
+
+// ==================== Helpers ====================
+
+func (h *SCIMHandler) client(c *fiber.Ctx) *models.SCIMClient {
+	return c.Locals("scim_client").(*models.SCIMClient)
+}
+
+func (h *SCIMHandler) query(c *fiber.Ctx) service.SCIMQuery {
+	query := service.SCIMQuery{
+		Filter:     c.Query("filter"),
+		StartIndex: c.QueryInt("startIndex", 1),
+		Count:      -1,
+	}
+	if count, err := strconv.Atoi(c.Query("count")); err == nil && count >= 0 {
+		query.Count = count
+	}
+	if excluded := c.Query("excludedAttributes"); excluded != "" {
+		query.ExcludedAttributes = strings.Split(excluded, ",")
+	}
+	return query
+}
+
+// resource writes a single resource with its ETag, answering 304 when the
+// client already has this version
+func (h *SCIMHandler) resource(c *fiber.Ctx, status int, meta *service.SCIMMeta, resource interface{}) error {
+	c.Set(fiber.HeaderETag, meta.Version)
+	if status == fiber.StatusCreated {
+		c.Set(fiber.HeaderLocation, meta.Location)
+	}
+	if status == fiber.StatusOK && c.Method() == fiber.MethodGet && c.Get(fiber.HeaderIfNoneMatch) == meta.Version {
+		return c.SendStatus(fiber.StatusNotModified)
+	}
+	return c.Status(status).JSON(resource, scimContentType)
+}
+
+func (h *SCIMHandler) invalidBody(c *fiber.Ctx) error {
+	return h.errorResponse(c, fiber.StatusBadRequest, "invalidSyntax", "request body is not valid JSON")
+}
+
+// scimError maps service errors to SCIM error responses (RFC 7644 section 3.12)
+func (h *SCIMHandler) scimError(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, service.ErrSCIMNotFound):
+		return h.errorResponse(c, fiber.StatusNotFound, "", err.Error())
+	case errors.Is(err, service.ErrSCIMUniqueness):
+		return h.errorResponse(c, fiber.StatusConflict, "uniqueness", err.Error())
+	case errors.Is(err, service.ErrSCIMPreconditionFailed):
+		return h.errorResponse(c, fiber.StatusPreconditionFailed, "", err.Error())
+	case errors.Is(err, service.ErrSCIMInvalidFilter):
+		return h.errorResponse(c, fiber.StatusBadRequest, "invalidFilter", err.Error())
+	case errors.Is(err, service.ErrSCIMInvalidPath):
+		return h.errorResponse(c, fiber.StatusBadRequest, "invalidPath", err.Error())
+	case errors.Is(err, service.ErrSCIMNoTarget):
+		return h.errorResponse(c, fiber.StatusBadRequest, "noTarget", err.Error())
+	case errors.Is(err, service.ErrSCIMInvalidValue):
+		return h.errorResponse(c, fiber.StatusBadRequest, "invalidValue", err.Error())
+	case errors.Is(err, service.ErrSCIMInvalidPatch):
+		return h.errorResponse(c, fiber.StatusBadRequest, "invalidSyntax", err.Error())
+	}
+
+	log.Printf("SCIM request %s %s failed: %v", c.Method(), c.Path(), err)
+	return h.errorResponse(c, fiber.StatusInternalServerError, "", "internal server error")
+}
+
+func (h *SCIMHandler) errorResponse(c *fiber.Ctx, status int, scimType, detail string) error {
+	body := fiber.Map{
+		"schemas": []string{service.SCIMSchemaError},
+		"status":  strconv.Itoa(status),
+		"detail":  detail,
+	}
+	if scimType != "" {
+		body["scimType"] = scimType
+	}
+	return c.Status(status).JSON(body, scimContentType)
+}
+
+// audit records changes made by a SCIM client; there is no acting user
+func (h *SCIMHandler) audit(c *fiber.Ctx, action, details string) {
+	client := h.client(c)
+	recordAudit(c, h.auditRepo, action, "scim", fmt.Sprintf("scim_client_id=%s scim_client=%s %s", client.ID, client.Name, details))
+}
@@ -0,0 +1,167 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/sso-project/sso-server/internal/models"
+	"github.com/sso-project/sso-server/internal/repository"
+	"github.com/sso-project/sso-server/internal/service"
+)
+
+// ServiceAccountHandler handles service account management endpoints
+type ServiceAccountHandler struct {
+	accountService *service.ServiceAccountService
+	auditRepo      *repository.AuditLogRepository
+}
+
+// NewServiceAccountHandler creates a new ServiceAccountHandler
+func NewServiceAccountHandler(accountService *service.ServiceAccountService, auditRepo *repository.AuditLogRepository) *ServiceAccountHandler {
+	return &ServiceAccountHandler{
+		accountService: accountService,
+		auditRepo:      auditRepo,
+	}
+}
+
+// GetServiceAccounts handles GET /admin/api/service-accounts
+func (h *ServiceAccountHandler) GetServiceAccounts(c *fiber.Ctx) error {
+	page := c.QueryInt("page", 1)
+	limit := c.QueryInt("limit", 20)
+
+	accounts, total, err := h.accountService.ListServiceAccounts(c.Context(), page, limit)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "failed to fetch service accounts",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"service_accounts": accounts,
+		"total":            total,
+		"page":             page,
+		"limit":            limit,
+	})
+}
+
+// GetServiceAccount handles GET /admin/api/service-accounts/:id
+func (h *ServiceAccountHandler) GetServiceAccount(c *fiber.Ctx) error {
+	account, err := h.accountService.GetServiceAccount(c.Context(), c.Params("id"))
+	if err != nil {
+		return h.accountError(c, err)
+	}
+
+	return c.JSON(account)
+}
+
+// CreateServiceAccount handles POST /admin/api/service-accounts
+func (h *ServiceAccountHandler) CreateServiceAccount(c *fiber.Ctx) error {
+	var req struct {
+		ClientID    string `json:"client_id"`
+		Name        string `json:"name"`
+		Description string `json:"description"`
+	}
+
+	if err := c.BodyParser(&req); err != nil || req.ClientID == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "client_id is required",
+		})
+	}
+
+	account, err := h.accountService.CreateServiceAccount(c.Context(), req.ClientID, req.Name, req.Description)
+	if err != nil {
+		switch {
+		case errors.Is(err, repository.ErrClientNotFound):
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "client not found",
+			})
+		case errors.Is(err, service.ErrServiceAccountExists):
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		case errors.Is(err, service.ErrClientCredentialsMissing):
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "failed to create service account",
+		})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(account)
+}
+
+// UpdateServiceAccount handles PUT /admin/api/service-accounts/:id
+func (h *ServiceAccountHandler) UpdateServiceAccount(c *fiber.Ctx) error {
+	var req struct {
+		IsActive bool `json:"is_active"`
+	}
+
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "invalid request body",
+		})
+	}
+
+	account, err := h.accountService.SetActive(c.Context(), c.Params("id"), req.IsActive)
+	if err != nil {
+		return h.accountError(c, err)
+	}
+
+	return c.JSON(account)
+}
+
+// DeleteServiceAccount handles DELETE /admin/api/service-accounts/:id
+func (h *ServiceAccountHandler) DeleteServiceAccount(c *fiber.Ctx) error {
+	if err := h.accountService.DeleteServiceAccount(c.Context(), c.Params("id")); err != nil {
+		return h.accountError(c, err)
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Service account deleted successfully",
+	})
+}
+
+// AssignRole handles POST /admin/api/service-accounts/:id/roles/:role_id
+func (h *ServiceAccountHandler) AssignRole(c *fiber.Ctx) error {
+	account, err := h.accountService.AssignRole(c.Context(), c.Params("id"), c.Params("role_id"))
+	if err != nil {
+		return h.accountError(c, err)
+	}
+
+	h.audit(c, "service_account_role_assigned", account, c.Params("role_id"))
+	return c.JSON(account)
+}
+
+// RemoveRole handles DELETE /admin/api/service-accounts/:id/roles/:role_id
+func (h *ServiceAccountHandler) RemoveRole(c *fiber.Ctx) error {
+	account, err := h.accountService.RemoveRole(c.Context(), c.Params("id"), c.Params("role_id"))
+	if err != nil {
+		return h.accountError(c, err)
+	}
+
+	h.audit(c, "service_account_role_removed", account, c.Params("role_id"))
+	return c.JSON(account)
+}
+
+func (h *ServiceAccountHandler) accountError(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, repository.ErrServiceAccountNotFound):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "service account not found",
+		})
+	case errors.Is(err, repository.ErrNotFound):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "role not found",
+		})
+	}
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		"error": "failed to update service account",
+	})
+}
+
+// audit records role changes, since they change what a client's tokens may do
+func (h *ServiceAccountHandler) audit(c *fiber.Ctx, action string, account *models.ServiceAccount, roleID string) {
+	recordAudit(c, h.auditRepo, action, "service_account", fmt.Sprintf("service_account_id=%s client_id=%s role_id=%s", account.ID, account.ClientID, roleID))
+}
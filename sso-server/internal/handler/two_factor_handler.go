@@ -0,0 +1,412 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/sso-project/sso-server/internal/models"
+	"github.com/sso-project/sso-server/internal/repository"
+	"github.com/sso-project/sso-server/internal/service"
+)
+
+// TwoFactorHandler handles the 2FA settings of the signed-in user
+type TwoFactorHandler struct {
+	totpService      *service.TOTPService
+	otpService       *service.OTPService
+	twoFactorService *service.TwoFactorService
+	identityService  *service.IdentityService
+	auditRepo        *repository.AuditLogRepository
+}
+
+// NewTwoFactorHandler creates a new TwoFactorHandler
+func NewTwoFactorHandler(
+	totpService *service.TOTPService,
+	otpService *service.OTPService,
+	twoFactorService *service.TwoFactorService,
+	identityService *service.IdentityService,
+	auditRepo *repository.AuditLogRepository,
+) *TwoFactorHandler {
+	return &TwoFactorHandler{
+		totpService:      totpService,
+		otpService:       otpService,
+		twoFactorService: twoFactorService,
+		identityService:  identityService,
+		auditRepo:        auditRepo,
+	}
+}
+
+// GetFactors handles GET /user/2fa
+func (h *TwoFactorHandler) GetFactors(c *fiber.Ctx) error {
+	status, err := h.twoFactorService.Status(c.Context(), c.Locals("user_id").(string))
+	if err != nil {
+		return h.twoFactorError(c, err)
+	}
+
+	return c.JSON(status)
+}
+
+// BeginTOTP handles POST /user/2fa/totp, creating the secret to add to an
+// authenticator app. Starting again replaces a secret not yet confirmed.
+func (h *TwoFactorHandler) BeginTOTP(c *fiber.Ctx) error {
+	req, err := parseReauth(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "invalid request body",
+		})
+	}
+
+	session := c.Locals("session").(*models.Session)
+	if err := h.identityService.Reauthenticate(c.Context(), session, req.Password, req.Code); err != nil {
+		return h.twoFactorError(c, err)
+	}
+
+	key, backupCodes, err := h.totpService.SetupTOTP(c.Context(), session.UserID)
+	if err != nil {
+		return h.twoFactorError(c, err)
+	}
+
+	var qrCode bytes.Buffer
+	if err := h.totpService.GenerateQRCode(key, &qrCode); err != nil {
+		return h.twoFactorError(c, err)
+	}
+
+	h.audit(c, "2fa_totp_setup_started", "")
+	return c.JSON(fiber.Map{
+		"secret":       key.Secret(),
+		"otpauth_uri":  key.URL(),
+		"qr_code":      "data:image/png;base64," + base64.StdEncoding.EncodeToString(qrCode.Bytes()),
+		"backup_codes": backupCodes,
+		"message":      "Scan the QR code with your authenticator app and enter the code it shows to enable it. Store the backup codes somewhere safe.",
+	})
+}
+
+// ConfirmTOTP handles POST /user/2fa/totp/confirm
+func (h *TwoFactorHandler) ConfirmTOTP(c *fiber.Ctx) error {
+	var req confirmCodeRequest
+	if err := c.BodyParser(&req); err != nil || req.Code == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "code is required",
+		})
+	}
+
+	userID := c.Locals("user_id").(string)
+	if err := h.totpService.VerifyAndEnableTOTP(c.Context(), userID, req.Code); err != nil {
+		return h.twoFactorError(c, err)
+	}
+
+	h.audit(c, "2fa_totp_enabled", "")
+	return c.JSON(fiber.Map{
+		"message": "Authenticator app enabled",
+	})
+}
+
+// DisableTOTP handles DELETE /user/2fa/totp, removing the authenticator app
+// along with the backup codes
+func (h *TwoFactorHandler) DisableTOTP(c *fiber.Ctx) error {
+	req, err := parseReauth(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "invalid request body",
+		})
+	}
+
+	session := c.Locals("session").(*models.Session)
+	if err := h.identityService.Reauthenticate(c.Context(), session, req.Password, req.Code); err != nil {
+		return h.twoFactorError(c, err)
+	}
+
+	if _, err := h.totpService.Settings(c.Context(), session.UserID); err != nil {
+		return h.twoFactorError(c, err)
+	}
+	if err := h.totpService.DisableTOTP(c.Context(), session.UserID); err != nil {
+		return h.twoFactorError(c, err)
+	}
+
+	h.audit(c, "2fa_totp_disabled", "")
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// GetUserFactors handles GET /admin/api/users/:id/2fa
+func (h *TwoFactorHandler) GetUserFactors(c *fiber.Ctx) error {
+	status, err := h.twoFactorService.Status(c.Context(), c.Params("id"))
+	if err != nil {
+		return h.twoFactorError(c, err)
+	}
+
+	return c.JSON(status)
+}
+
+// ResetUserFactors handles DELETE /admin/api/users/:id/2fa, removing every
+// second factor of a user who lost access to theirs
+func (h *TwoFactorHandler) ResetUserFactors(c *fiber.Ctx) error {
+	userID := c.Params("id")
+	removed, err := h.twoFactorService.Reset(c.Context(), userID)
+	if err != nil {
+		return h.twoFactorError(c, err)
+	}
+
+	h.audit(c, "2fa_reset", fmt.Sprintf("user_id=%s removed=%d", userID, removed))
+	return c.JSON(fiber.Map{
+		"removed": removed,
+		"message": "Two-factor authentication reset successfully",
+	})
+}
+
+// GetBackupCodes handles GET /user/2fa/backup-codes
+func (h *TwoFactorHandler) GetBackupCodes(c *fiber.Ctx) error {
+	remaining, err := h.totpService.BackupCodesRemaining(c.Context(), c.Locals("user_id").(string))
+	if err != nil {
+		return h.twoFactorError(c, err)
+	}
+
+	return c.JSON(fiber.Map{
+		"remaining": remaining,
+	})
+}
+
+// RegenerateBackupCodes handles POST /user/2fa/backup-codes
+func (h *TwoFactorHandler) RegenerateBackupCodes(c *fiber.Ctx) error {
+	req, err := parseReauth(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "invalid request body",
+		})
+	}
+
+	session := c.Locals("session").(*models.Session)
+	if err := h.identityService.Reauthenticate(c.Context(), session, req.Password, req.Code); err != nil {
+		return h.twoFactorError(c, err)
+	}
+
+	codes, err := h.totpService.RegenerateBackupCodes(c.Context(), session.UserID)
+	if err != nil {
+		return h.twoFactorError(c, err)
+	}
+
+	h.audit(c, "2fa_backup_codes_regenerated", fmt.Sprintf("count=%d", len(codes)))
+	return c.JSON(fiber.Map{
+		"backup_codes": codes,
+		"message":      "Store these codes somewhere safe. Each one can be used once, and the previous codes no longer work.",
+	})
+}
+
+// confirmCodeRequest carries a code sent to confirm a factor
+type confirmCodeRequest struct {
+	Code string `json:"code"`
+}
+
+// BeginEmailFactor handles POST /user/2fa/email, emailing a code to confirm
+// the email factor with
+func (h *TwoFactorHandler) BeginEmailFactor(c *fiber.Ctx) error {
+	factor, err := h.otpService.BeginEmailEnrollment(c.Context(), c.Locals("user_id").(string))
+	if err != nil {
+		return h.twoFactorError(c, err)
+	}
+
+	h.audit(c, "2fa_email_setup_started", "factor_id="+factor.ID)
+	return c.JSON(fiber.Map{
+		"destination": service.MaskDestination(factor.Destination),
+		"expires_in":  int(h.otpService.CodeTTL().Seconds()),
+		"message":     "Enter the code we emailed you to enable email verification.",
+	})
+}
+
+// ConfirmEmailFactor handles POST /user/2fa/email/confirm
+func (h *TwoFactorHandler) ConfirmEmailFactor(c *fiber.Ctx) error {
+	var req confirmCodeRequest
+	if err := c.BodyParser(&req); err != nil || req.Code == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "code is required",
+		})
+	}
+
+	factor, err := h.otpService.ConfirmEnrollment(c.Context(), c.Locals("user_id").(string), service.TwoFactorMethodEmail, req.Code)
+	if err != nil {
+		return h.twoFactorError(c, err)
+	}
+
+	h.audit(c, "2fa_email_enabled", "factor_id="+factor.ID)
+	return c.JSON(factor)
+}
+
+// DisableEmailFactor handles DELETE /user/2fa/email
+func (h *TwoFactorHandler) DisableEmailFactor(c *fiber.Ctx) error {
+	req, err := parseReauth(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "invalid request body",
+		})
+	}
+
+	session := c.Locals("session").(*models.Session)
+	if err := h.identityService.Reauthenticate(c.Context(), session, req.Password, req.Code); err != nil {
+		return h.twoFactorError(c, err)
+	}
+
+	if err := h.otpService.Disable(c.Context(), session.UserID, service.TwoFactorMethodEmail); err != nil {
+		return h.twoFactorError(c, err)
+	}
+
+	h.audit(c, "2fa_email_disabled", "")
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// phoneFactorRequest sets up or confirms the SMS or voice factor
+type phoneFactorRequest struct {
+	Method      string `json:"method"`       // sms (default) or voice
+	PhoneNumber string `json:"phone_number"` // Defaults to the account's phone number
+	Code        string `json:"code"`
+}
+
+// parsePhoneFactorRequest reads the optional phone factor body
+func parsePhoneFactorRequest(c *fiber.Ctx) (*phoneFactorRequest, error) {
+	var req phoneFactorRequest
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return nil, err
+		}
+	}
+	if req.Method == "" {
+		req.Method = service.TwoFactorMethodSMS
+	}
+	return &req, nil
+}
+
+// BeginPhoneFactor handles POST /user/2fa/phone, texting or calling a code to
+// confirm the phone number and the factor with
+func (h *TwoFactorHandler) BeginPhoneFactor(c *fiber.Ctx) error {
+	req, err := parsePhoneFactorRequest(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "invalid request body",
+		})
+	}
+
+	factor, err := h.otpService.BeginPhoneEnrollment(c.Context(), c.Locals("user_id").(string), req.Method, req.PhoneNumber)
+	if err != nil {
+		return h.twoFactorError(c, err)
+	}
+
+	h.audit(c, "2fa_phone_setup_started", fmt.Sprintf("factor_id=%s method=%s", factor.ID, factor.Method))
+	return c.JSON(fiber.Map{
+		"method":      factor.Method,
+		"destination": service.MaskDestination(factor.Destination),
+		"expires_in":  int(h.otpService.CodeTTL().Seconds()),
+		"message":     "Enter the code we sent to your phone to enable phone verification.",
+	})
+}
+
+// ConfirmPhoneFactor handles POST /user/2fa/phone/confirm
+func (h *TwoFactorHandler) ConfirmPhoneFactor(c *fiber.Ctx) error {
+	req, err := parsePhoneFactorRequest(c)
+	if err != nil || req.Code == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "code is required",
+		})
+	}
+
+	factor, err := h.otpService.ConfirmEnrollment(c.Context(), c.Locals("user_id").(string), req.Method, req.Code)
+	if err != nil {
+		return h.twoFactorError(c, err)
+	}
+
+	h.audit(c, "2fa_phone_enabled", fmt.Sprintf("factor_id=%s method=%s", factor.ID, factor.Method))
+	return c.JSON(factor)
+}
+
+// DisablePhoneFactor handles DELETE /user/2fa/phone?method=sms
+func (h *TwoFactorHandler) DisablePhoneFactor(c *fiber.Ctx) error {
+	req, err := parseReauth(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "invalid request body",
+		})
+	}
+
+	method := c.Query("method", service.TwoFactorMethodSMS)
+	if method != service.TwoFactorMethodSMS && method != service.TwoFactorMethodVoice {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "method must be sms or voice",
+		})
+	}
+
+	session := c.Locals("session").(*models.Session)
+	if err := h.identityService.Reauthenticate(c.Context(), session, req.Password, req.Code); err != nil {
+		return h.twoFactorError(c, err)
+	}
+
+	if err := h.otpService.Disable(c.Context(), session.UserID, method); err != nil {
+		return h.twoFactorError(c, err)
+	}
+
+	h.audit(c, "2fa_phone_disabled", "method="+method)
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+func (h *TwoFactorHandler) twoFactorError(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, service.ErrTwoFactorNotSetup):
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "2FA is not enabled for this account",
+		})
+	case errors.Is(err, service.ErrTwoFactorAlreadyEnabled):
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": "an authenticator app is already enabled for this account",
+		})
+	case errors.Is(err, service.ErrReauthenticationRequired):
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	case errors.Is(err, service.ErrInvalidCredentials):
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "invalid password",
+		})
+	case errors.Is(err, service.ErrInvalidTOTPCode):
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "invalid verification code",
+		})
+	case errors.Is(err, service.ErrTwoFactorLocked):
+		return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	case errors.Is(err, service.ErrOTPMethodUnavailable):
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	case errors.Is(err, service.ErrOTPFactorNotEnabled):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	case errors.Is(err, service.ErrOTPFactorAlreadyEnabled):
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	case errors.Is(err, service.ErrOTPResendTooSoon), errors.Is(err, service.ErrSMSRateLimited):
+		return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	case errors.Is(err, service.ErrInvalidPhoneNumber), errors.Is(err, service.ErrPhoneCountryNotAllowed), errors.Is(err, service.ErrPhoneNumberRequired):
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	case errors.Is(err, service.ErrInvalidOTPCode), errors.Is(err, service.ErrOTPCodeExpired), errors.Is(err, service.ErrOTPTooManyAttempts):
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	case errors.Is(err, service.ErrDirectoryUnavailable):
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{
+			"error": "directory is unavailable",
+		})
+	}
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		"error": "failed to process 2FA request",
+	})
+}
+
+// audit records changes to the user's 2FA settings
+func (h *TwoFactorHandler) audit(c *fiber.Ctx, action, details string) {
+	recordAudit(c, h.auditRepo, action, "authentication", details)
+}
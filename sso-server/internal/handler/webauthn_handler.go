@@ -0,0 +1,376 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/sso-project/sso-server/internal/models"
+	"github.com/sso-project/sso-server/internal/repository"
+	"github.com/sso-project/sso-server/internal/service"
+)
+
+// WebAuthnHandler handles security key and passkey registration, sign-in with
+// a passkey, and security keys as second factor
+type WebAuthnHandler struct {
+	webauthnService *service.WebAuthnService
+	authService     *service.AuthService
+	identityService *service.IdentityService
+	jwtService      *service.JWTService
+	auditRepo       *repository.AuditLogRepository
+	secureCookies   bool
+}
+
+// NewWebAuthnHandler creates a new WebAuthnHandler
+func NewWebAuthnHandler(
+	webauthnService *service.WebAuthnService,
+	authService *service.AuthService,
+	identityService *service.IdentityService,
+	jwtService *service.JWTService,
+	auditRepo *repository.AuditLogRepository,
+	secureCookies bool,
+) *WebAuthnHandler {
+	return &WebAuthnHandler{
+		webauthnService: webauthnService,
+		authService:     authService,
+		identityService: identityService,
+		jwtService:      jwtService,
+		auditRepo:       auditRepo,
+		secureCookies:   secureCookies,
+	}
+}
+
+// webauthnRequest carries the authenticator's response back to a finish
+// endpoint, with the challenge it answers
+type webauthnRequest struct {
+	TempToken   string          `json:"temp_token"` // From /auth/login, for a second factor
+	ChallengeID string          `json:"challenge_id"`
+	Name        string          `json:"name"`       // Of a new credential
+	Credential  json.RawMessage `json:"credential"` // PublicKeyCredential, as JSON
+}
+
+// renameCredentialRequest represents a credential rename request
+type renameCredentialRequest struct {
+	Name string `json:"name"`
+}
+
+// BeginRegistration handles POST /auth/webauthn/register/begin
+func (h *WebAuthnHandler) BeginRegistration(c *fiber.Ctx) error {
+	req, err := parseReauth(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "invalid request body",
+		})
+	}
+
+	// A new key becomes a second factor, so the user proves who they are first
+	session := c.Locals("session").(*models.Session)
+	if err := h.identityService.Reauthenticate(c.Context(), session, req.Password, req.Code); err != nil {
+		return h.webauthnError(c, err)
+	}
+
+	options, challengeID, err := h.webauthnService.BeginRegistration(c.Context(), session.UserID)
+	if err != nil {
+		return h.webauthnError(c, err)
+	}
+
+	return c.JSON(fiber.Map{
+		"challenge_id": challengeID,
+		"options":      options,
+	})
+}
+
+// FinishRegistration handles POST /auth/webauthn/register/finish
+func (h *WebAuthnHandler) FinishRegistration(c *fiber.Ctx) error {
+	req, err := parseWebAuthnRequest(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	credential, err := h.webauthnService.FinishRegistration(c.Context(), c.Locals("user_id").(string), req.ChallengeID, req.Name, req.Credential)
+	if err != nil {
+		return h.webauthnError(c, err)
+	}
+
+	h.audit(c, "webauthn_registered", fmt.Sprintf("credential_id=%s name=%s", credential.ID, credential.Name))
+	return c.Status(fiber.StatusCreated).JSON(credential)
+}
+
+// BeginLogin handles POST /auth/webauthn/login/begin
+func (h *WebAuthnHandler) BeginLogin(c *fiber.Ctx) error {
+	options, challengeID, err := h.webauthnService.BeginLogin(c.Context())
+	if err != nil {
+		return h.webauthnError(c, err)
+	}
+
+	return c.JSON(fiber.Map{
+		"challenge_id": challengeID,
+		"options":      options,
+	})
+}
+
+// FinishLogin handles POST /auth/webauthn/login/finish
+func (h *WebAuthnHandler) FinishLogin(c *fiber.Ctx) error {
+	req, err := parseWebAuthnRequest(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	ipAddress := c.IP()
+	userAgent := c.Get("User-Agent")
+
+	user, credential, err := h.webauthnService.FinishLogin(c.Context(), req.ChallengeID, req.Credential)
+	if err != nil {
+		if errors.Is(err, service.ErrWebAuthnCredentialCloned) {
+			h.authService.LogAudit(c.Context(), &user.ID, "webauthn_clone_detected", "authentication", ipAddress, userAgent, "credential_id="+credential.ID)
+		}
+		return h.webauthnError(c, err)
+	}
+
+	session, err := h.authService.LoginWithPasskey(c.Context(), user, ipAddress, userAgent)
+	if err != nil {
+		return h.webauthnError(c, err)
+	}
+
+	return h.signedIn(c, user, session, credential)
+}
+
+// BeginSecondFactor handles POST /auth/webauthn/2fa/begin
+func (h *WebAuthnHandler) BeginSecondFactor(c *fiber.Ctx) error {
+	req, err := parseWebAuthnRequest(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	session, err := h.authService.GetSessionByToken(c.Context(), req.TempToken)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Invalid or expired temporary token",
+		})
+	}
+
+	options, challengeID, err := h.webauthnService.BeginSecondFactor(c.Context(), session.UserID)
+	if err != nil {
+		return h.webauthnError(c, err)
+	}
+
+	return c.JSON(fiber.Map{
+		"challenge_id": challengeID,
+		"options":      options,
+	})
+}
+
+// FinishSecondFactor handles POST /auth/webauthn/2fa/finish
+func (h *WebAuthnHandler) FinishSecondFactor(c *fiber.Ctx) error {
+	req, err := parseWebAuthnRequest(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	// The temp token is stored as a short-lived session
+	session, err := h.authService.GetSessionByToken(c.Context(), req.TempToken)
+	if err != nil || time.Now().After(session.ExpiresAt) {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Invalid or expired temporary token",
+		})
+	}
+	user := &session.User
+
+	ipAddress := c.IP()
+	userAgent := c.Get("User-Agent")
+
+	credential, err := h.webauthnService.FinishSecondFactor(c.Context(), user.ID, req.ChallengeID, req.Credential)
+	if err != nil {
+		if errors.Is(err, service.ErrWebAuthnCredentialCloned) {
+			h.authService.LogAudit(c.Context(), &user.ID, "webauthn_clone_detected", "authentication", ipAddress, userAgent, "credential_id="+credential.ID)
+		}
+		return h.webauthnError(c, err)
+	}
+
+	// Swap the temporary session for a real one
+	h.authService.DeleteSessionByToken(c.Context(), req.TempToken)
+
+	realSession, err := h.authService.CreateSessionAfter2FA(c.Context(), user.ID, ipAddress, userAgent)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to create session",
+		})
+	}
+
+	h.authService.LogAudit(c.Context(), &user.ID, "2fa_verified", "authentication", ipAddress, userAgent, "method=webauthn credential_id="+credential.ID)
+	return h.signedIn(c, user, realSession, credential)
+}
+
+// GetCredentials handles GET /auth/webauthn/credentials
+func (h *WebAuthnHandler) GetCredentials(c *fiber.Ctx) error {
+	credentials, err := h.webauthnService.ListCredentials(c.Context(), c.Locals("user_id").(string))
+	if err != nil {
+		return h.webauthnError(c, err)
+	}
+
+	return c.JSON(fiber.Map{
+		"credentials": credentials,
+	})
+}
+
+// RenameCredential handles PATCH /auth/webauthn/credentials/:id
+func (h *WebAuthnHandler) RenameCredential(c *fiber.Ctx) error {
+	var req renameCredentialRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "invalid request body",
+		})
+	}
+
+	credential, err := h.webauthnService.RenameCredential(c.Context(), c.Locals("user_id").(string), c.Params("id"), req.Name)
+	if err != nil {
+		return h.webauthnError(c, err)
+	}
+
+	h.audit(c, "webauthn_renamed", fmt.Sprintf("credential_id=%s name=%s", credential.ID, credential.Name))
+	return c.JSON(credential)
+}
+
+// DeleteCredential handles DELETE /auth/webauthn/credentials/:id
+func (h *WebAuthnHandler) DeleteCredential(c *fiber.Ctx) error {
+	req, err := parseReauth(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "invalid request body",
+		})
+	}
+
+	session := c.Locals("session").(*models.Session)
+	if err := h.identityService.Reauthenticate(c.Context(), session, req.Password, req.Code); err != nil {
+		return h.webauthnError(c, err)
+	}
+
+	if err := h.webauthnService.DeleteCredential(c.Context(), session.UserID, c.Params("id")); err != nil {
+		return h.webauthnError(c, err)
+	}
+
+	h.audit(c, "webauthn_removed", "credential_id="+c.Params("id"))
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// signedIn issues tokens and the session cookie, as /auth/login does
+func (h *WebAuthnHandler) signedIn(c *fiber.Ctx, user *models.User, session *models.Session, credential *models.WebAuthnCredential) error {
+	accessToken, err := h.jwtService.GenerateAccessToken(user)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to generate token",
+		})
+	}
+
+	refreshToken, err := h.jwtService.GenerateRefreshToken(user.ID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to generate refresh token",
+		})
+	}
+
+	c.Cookie(&fiber.Cookie{
+		Name:     "session_token",
+		Value:    session.SessionToken,
+		Expires:  session.ExpiresAt,
+		HTTPOnly: true,
+		Secure:   h.secureCookies,
+		SameSite: "Lax",
+	})
+
+	return c.JSON(fiber.Map{
+		"success":       true,
+		"access_token":  accessToken,
+		"refresh_token": refreshToken,
+		"session_token": session.SessionToken,
+		"credential_id": credential.ID,
+		"user": fiber.Map{
+			"id":    user.ID,
+			"email": user.Email,
+			"name":  user.Name,
+		},
+	})
+}
+
+// parseWebAuthnRequest reads the body of a finish endpoint
+func parseWebAuthnRequest(c *fiber.Ctx) (*webauthnRequest, error) {
+	var req webauthnRequest
+	if err := json.Unmarshal(c.Body(), &req); err != nil {
+		return nil, errors.New("invalid request body")
+	}
+	return &req, nil
+}
+
+func (h *WebAuthnHandler) webauthnError(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, service.ErrWebAuthnVerificationFailed):
+		log.Printf("WebAuthn verification failed: %v", err)
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": service.ErrWebAuthnVerificationFailed.Error(),
+		})
+	case errors.Is(err, service.ErrWebAuthnCredentialCloned):
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	case errors.Is(err, service.ErrWebAuthnChallengeExpired),
+		errors.Is(err, service.ErrNoWebAuthnCredentials):
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	case errors.Is(err, service.ErrWebAuthnCredentialExists):
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	case errors.Is(err, repository.ErrWebAuthnCredentialNotFound):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "security key not found",
+		})
+	case errors.Is(err, service.ErrAccountLocked):
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Account is locked. Please try again later.",
+		})
+	case errors.Is(err, service.ErrAccountInactive):
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Account is inactive",
+		})
+	case errors.Is(err, service.ErrReauthenticationRequired):
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	case errors.Is(err, service.ErrInvalidCredentials):
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "invalid password",
+		})
+	case errors.Is(err, service.ErrInvalidTOTPCode):
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "invalid verification code",
+		})
+	case errors.Is(err, service.ErrTwoFactorLocked):
+		return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	case errors.Is(err, service.ErrDirectoryUnavailable):
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{
+			"error": "directory is unavailable",
+		})
+	}
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		"error": "failed to process security key request",
+	})
+}
+
+// audit records changes to the user's security keys
+func (h *WebAuthnHandler) audit(c *fiber.Ctx, action, details string) {
+	recordAudit(c, h.auditRepo, action, "authentication", details)
+}
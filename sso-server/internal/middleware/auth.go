@@ -40,3 +40,22 @@ func AuthMiddleware(sessionService *service.SessionService) fiber.Handler {
 		return c.Next()
 	}
 }
+
+// OptionalAuthMiddleware loads the session when one is present but lets anonymous
+// requests through, for endpoints that redirect to login themselves
+func OptionalAuthMiddleware(sessionService *service.SessionService) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		sessionToken := c.Cookies("session_token")
+		if sessionToken == "" {
+			return c.Next()
+		}
+
+		session, err := sessionService.ValidateSession(c.Context(), sessionToken)
+		if err == nil {
+			c.Locals("session", session)
+			c.Locals("user_id", session.UserID)
+		}
+
+		return c.Next()
+	}
+}
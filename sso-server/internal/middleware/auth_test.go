@@ -0,0 +1,53 @@
+package middleware
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sso-project/sso-server/internal/repository"
+	"github.com/sso-project/sso-server/internal/service"
+	"github.com/sso-project/sso-server/internal/testutil"
+)
+
+func TestAuthMiddleware_RefusesPendingTwoFactorSession(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+	defer testutil.TeardownTestDB(t, db)
+
+	sessionService := service.NewSessionService(repository.NewDatabaseSessionStore(db), 24*time.Hour)
+	user := testutil.CreateTestUser(t, db, "pending@example.com")
+	ctx := context.Background()
+
+	app := fiber.New()
+	app.Get("/protected", AuthMiddleware(sessionService), func(c *fiber.Ctx) error {
+		return c.SendString(c.Locals("user_id").(string))
+	})
+
+	request := func(token string, cookie bool) int {
+		req := httptest.NewRequest("GET", "/protected", nil)
+		if cookie {
+			req.Header.Set("Cookie", "session_token="+token)
+		} else {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := app.Test(req)
+		require.NoError(t, err)
+		return resp.StatusCode
+	}
+
+	// A temp token from a login waiting for 2FA is not a session
+	pending, err := sessionService.CreatePendingSession(ctx, user.ID, "127.0.0.1", "Test Agent", 5*time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, fiber.StatusUnauthorized, request(pending.SessionToken, false))
+	assert.Equal(t, fiber.StatusUnauthorized, request(pending.SessionToken, true))
+
+	session, err := sessionService.CreateSession(ctx, user.ID, "127.0.0.1", "Test Agent")
+	require.NoError(t, err)
+	assert.Equal(t, fiber.StatusOK, request(session.SessionToken, false))
+	assert.Equal(t, fiber.StatusOK, request(session.SessionToken, true))
+}
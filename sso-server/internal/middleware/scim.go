@@ -0,0 +1,32 @@
+package middleware
+
+import (
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/sso-project/sso-server/internal/service"
+)
+
+// SCIMAuthMiddleware authenticates SCIM requests by the bearer token issued to a
+// SCIM client and stores the client in context
+func SCIMAuthMiddleware(scimService *service.SCIMService) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		token := ""
+		if authHeader := c.Get("Authorization"); strings.HasPrefix(authHeader, "Bearer ") {
+			token = strings.TrimPrefix(authHeader, "Bearer ")
+		}
+
+		client, err := scimService.Authenticate(c.Context(), token)
+		if err != nil {
+			c.Set("WWW-Authenticate", `Bearer realm="scim"`)
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"schemas": []string{service.SCIMSchemaError},
+				"status":  "401",
+				"detail":  "invalid or missing SCIM bearer token",
+			}, "application/scim+json")
+		}
+
+		c.Locals("scim_client", client)
+		return c.Next()
+	}
+}
@@ -0,0 +1,150 @@
+package models
+
+import "time"
+
+// ==================== Federation Models ====================
+
+// OIDCConnection is an upstream OpenID Connect provider users can sign in with
+type OIDCConnection struct {
+	ID              string      `gorm:"column:id;primaryKey;type:char(36)" json:"id"`
+	Name            string      `gorm:"column:name;not null" json:"name"`                            // Shown on the "Sign in with" button
+	Slug            string      `gorm:"column:slug;uniqueIndex;type:varchar(100)" json:"slug"`       // Used in login and callback URLs
+	DiscoveryURL    string      `gorm:"column:discovery_url;type:varchar(512)" json:"discovery_url"` // Issuer or its openid-configuration URL
+	ClientID        string      `gorm:"column:client_id;type:varchar(255)" json:"client_id"`
+	ClientSecret    string      `gorm:"column:client_secret;type:text;serializer:encrypted" json:"-"`
+	Scopes          StringSlice `gorm:"column:scopes;type:json" json:"scopes"`
+	EmailClaim      string      `gorm:"column:email_claim;type:varchar(100);default:email" json:"email_claim"`
+	NameClaim       string      `gorm:"column:name_claim;type:varchar(100);default:name" json:"name_claim"`
+	GroupsClaim     string      `gorm:"column:groups_claim;type:varchar(100)" json:"groups_claim,omitempty"`
+	RoleMappings    StringMap   `gorm:"column:role_mappings;type:json" json:"role_mappings"` // Upstream group -> local role name
+	JITProvisioning bool        `gorm:"column:jit_provisioning;default:true" json:"jit_provisioning"`
+	IsActive        bool        `gorm:"column:is_active;default:true" json:"is_active"`
+	CreatedAt       time.Time   `gorm:"column:created_at" json:"created_at"`
+	UpdatedAt       time.Time   `gorm:"column:updated_at" json:"updated_at"`
+
+	// RedirectURI is the callback to register with the provider; it is derived from the slug
+	RedirectURI string `gorm:"-" json:"redirect_uri,omitempty"`
+}
+
+func (OIDCConnection) TableName() string {
+	return "oidc_connections"
+}
+
+// UserIdentity links a local user to an account at an external identity provider
+type UserIdentity struct {
+	ID          string     `gorm:"column:id;primaryKey;type:char(36)" json:"id"`
+	UserID      string     `gorm:"column:user_id;not null;type:char(36);index" json:"user_id"`
+	Provider    string     `gorm:"column:provider;type:varchar(100);uniqueIndex:idx_provider_subject" json:"provider"`
+	Subject     string     `gorm:"column:subject;type:varchar(255);uniqueIndex:idx_provider_subject" json:"subject"`
+	Email       string     `gorm:"column:email;type:varchar(255)" json:"email,omitempty"`
+	LastLoginAt *time.Time `gorm:"column:last_login_at" json:"last_login_at,omitempty"`
+	CreatedAt   time.Time  `gorm:"column:created_at" json:"created_at"`
+
+	// Relationships
+	User User `gorm:"foreignKey:UserID" json:"-"`
+}
+
+func (UserIdentity) TableName() string {
+	return "user_identities"
+}
+
+// SAMLServiceProvider is an application that signs users in with SAML 2.0 through this server
+type SAMLServiceProvider struct {
+	ID                string    `gorm:"column:id;primaryKey;type:char(36)" json:"id"`
+	Name              string    `gorm:"column:name;not null" json:"name"`
+	EntityID          string    `gorm:"column:entity_id;uniqueIndex;type:varchar(512)" json:"entity_id"`
+	Metadata          string    `gorm:"column:metadata;type:text" json:"metadata"`                                  // SP EntityDescriptor XML
+	NameIDFormat      string    `gorm:"column:name_id_format;type:varchar(20);default:email" json:"name_id_format"` // email, persistent, transient or unspecified
+	AttributeMappings StringMap `gorm:"column:attribute_mappings;type:json" json:"attribute_mappings"`              // SAML attribute name -> user field
+	IsActive          bool      `gorm:"column:is_active;default:true" json:"is_active"`
+	CreatedAt         time.Time `gorm:"column:created_at" json:"created_at"`
+	UpdatedAt         time.Time `gorm:"column:updated_at" json:"updated_at"`
+}
+
+func (SAMLServiceProvider) TableName() string {
+	return "saml_service_providers"
+}
+
+// SAMLConnection is an upstream SAML 2.0 identity provider users can sign in with
+type SAMLConnection struct {
+	ID              string    `gorm:"column:id;primaryKey;type:char(36)" json:"id"`
+	Name            string    `gorm:"column:name;not null" json:"name"`                      // Shown on the "Sign in with" button
+	Slug            string    `gorm:"column:slug;uniqueIndex;type:varchar(100)" json:"slug"` // Used in login, metadata and ACS URLs
+	IdPEntityID     string    `gorm:"column:idp_entity_id;type:varchar(512)" json:"idp_entity_id"`
+	IdPMetadata     string    `gorm:"column:idp_metadata;type:text" json:"idp_metadata"`                             // IdP EntityDescriptor XML
+	IdPMetadataURL  string    `gorm:"column:idp_metadata_url;type:varchar(512)" json:"idp_metadata_url,omitempty"`   // Where IdPMetadata was imported from
+	EmailAttribute  string    `gorm:"column:email_attribute;type:varchar(255);default:email" json:"email_attribute"` // Falls back to an email NameID
+	NameAttribute   string    `gorm:"column:name_attribute;type:varchar(255);default:name" json:"name_attribute"`
+	GroupsAttribute string    `gorm:"column:groups_attribute;type:varchar(255)" json:"groups_attribute,omitempty"`
+	RoleMappings    StringMap `gorm:"column:role_mappings;type:json" json:"role_mappings"` // Upstream group -> local role name
+	TrustEmail      bool      `gorm:"column:trust_email;default:false" json:"trust_email"` // The IdP verifies email addresses, so they may link existing users
+	JITProvisioning bool      `gorm:"column:jit_provisioning;default:true" json:"jit_provisioning"`
+	IsActive        bool      `gorm:"column:is_active;default:true" json:"is_active"`
+	CreatedAt       time.Time `gorm:"column:created_at" json:"created_at"`
+	UpdatedAt       time.Time `gorm:"column:updated_at" json:"updated_at"`
+
+	// EntityID, MetadataURL and ACSURL describe this server as the service provider;
+	// they are derived from the slug
+	EntityID string `gorm:"-" json:"entity_id,omitempty"`
+	ACSURL   string `gorm:"-" json:"acs_url,omitempty"`
+}
+
+func (SAMLConnection) TableName() string {
+	return "saml_connections"
+}
+
+// SAMLAssertionID records an accepted assertion until it expires, so it cannot be replayed
+type SAMLAssertionID struct {
+	ID        string    `gorm:"column:id;primaryKey;type:varchar(255)" json:"id"`
+	ExpiresAt time.Time `gorm:"column:expires_at;index" json:"expires_at"`
+}
+
+func (SAMLAssertionID) TableName() string {
+	return "saml_assertion_ids"
+}
+
+// CASService is an application that signs users in with the CAS protocol through this server
+type CASService struct {
+	ID                string      `gorm:"column:id;primaryKey;type:char(36)" json:"id"`
+	Name              string      `gorm:"column:name;not null" json:"name"`
+	ServicePatterns   StringSlice `gorm:"column:service_patterns;type:json" json:"service_patterns"`                          // Service URL prefixes, or regular expressions starting with ^
+	UsernameAttribute string      `gorm:"column:username_attribute;type:varchar(20);default:email" json:"username_attribute"` // User field returned as cas:user: email or id
+	AttributeMappings StringMap   `gorm:"column:attribute_mappings;type:json" json:"attribute_mappings"`                      // CAS attribute name -> user field
+	AllowProxy        bool        `gorm:"column:allow_proxy;default:false" json:"allow_proxy"`                                // May obtain proxy-granting tickets
+	SingleLogout      bool        `gorm:"column:single_logout;default:false" json:"single_logout"`                            // Receives back-channel logout requests
+	IsActive          bool        `gorm:"column:is_active;default:true" json:"is_active"`
+	CreatedAt         time.Time   `gorm:"column:created_at" json:"created_at"`
+	UpdatedAt         time.Time   `gorm:"column:updated_at" json:"updated_at"`
+}
+
+func (CASService) TableName() string {
+	return "cas_services"
+}
+
+// CAS ticket types
+const (
+	CASServiceTicket       = "ST"
+	CASProxyTicket         = "PT"
+	CASProxyGrantingTicket = "PGT"
+)
+
+// CASTicket is a ticket issued to a CAS service for the user's SSO session.
+// Service and proxy tickets are consumed by their first validation; consumed
+// service tickets are kept to send single logout requests.
+type CASTicket struct {
+	ID         string      `gorm:"column:id;primaryKey;type:varchar(255)" json:"id"`
+	Type       string      `gorm:"column:type;type:varchar(3)" json:"type"`
+	ServiceID  string      `gorm:"column:service_id;type:char(36);index" json:"service_id"`
+	Service    string      `gorm:"column:service;type:text" json:"service"` // Service URL the ticket was issued for; the callback URL of a PGT
+	UserID     string      `gorm:"column:user_id;type:char(36)" json:"user_id"`
+	SessionID  string      `gorm:"column:session_id;type:char(36);index" json:"session_id"`
+	Renewed    bool        `gorm:"column:renewed;default:false" json:"renewed"` // Issued right after the user entered credentials
+	Proxies    StringSlice `gorm:"column:proxies;type:json" json:"proxies"`     // Proxy callback URLs the ticket passed through, most recent first
+	ConsumedAt *time.Time  `gorm:"column:consumed_at" json:"consumed_at,omitempty"`
+	ExpiresAt  time.Time   `gorm:"column:expires_at;index" json:"expires_at"`
+	CreatedAt  time.Time   `gorm:"column:created_at" json:"created_at"`
+}
+
+func (CASTicket) TableName() string {
+	return "cas_tickets"
+}
@@ -4,6 +4,8 @@ import (
 	"database/sql/driver"
 	"encoding/json"
 	"time"
+
+	_ "github.com/sso-project/sso-server/internal/encryption" // Registers the "encrypted" serializer
 )
 
 // StringSlice is a custom type for JSON array of strings
@@ -25,6 +27,25 @@ func (s *StringSlice) Scan(value interface{}) error {
 	return json.Unmarshal(bytes, s)
 }
 
+// StringMap is a custom type for a JSON object of strings
+type StringMap map[string]string
+
+func (m StringMap) Value() (driver.Value, error) {
+	return json.Marshal(m)
+}
+
+func (m *StringMap) Scan(value interface{}) error {
+	if value == nil {
+		*m = map[string]string{}
+		return nil
+	}
+	bytes, ok := value.([]byte)
+	if !ok {
+		return nil
+	}
+	return json.Unmarshal(bytes, m)
+}
+
 // User represents a user in the system
 type User struct {
 	ID                  string     `gorm:"column:id;primaryKey;type:char(36)" json:"id"`
@@ -32,8 +53,11 @@ type User struct {
 	PasswordHash        string     `gorm:"column:password_hash;not null" json:"-"`
 	Name                string     `gorm:"column:name;not null" json:"name"`
 	EmailVerified       bool       `gorm:"column:email_verified;default:false" json:"email_verified"`
+	PhoneNumber         string     `gorm:"column:phone_number;type:varchar(20)" json:"phone_number,omitempty"` // E.164, like +4915112345678
+	PhoneVerified       bool       `gorm:"column:phone_verified;default:false" json:"phone_verified"`
 	IsActive            bool       `gorm:"column:is_active;default:true" json:"is_active"`
 	IsLocked            bool       `gorm:"column:is_locked;default:false" json:"is_locked"`
+	DirectoryRemovedAt  *time.Time `gorm:"column:directory_removed_at" json:"directory_removed_at,omitempty"` // Set while inactive because directory sync found the account gone
 	FailedLoginAttempts int        `gorm:"column:failed_login_attempts;default:0" json:"-"`
 	LockedUntil         *time.Time `gorm:"column:locked_until" json:"locked_until,omitempty"`
 	PasswordChangedAt   time.Time  `gorm:"column:password_changed_at;not null" json:"password_changed_at"`
@@ -95,6 +119,10 @@ type Session struct {
 	LastActivityAt time.Time `gorm:"column:last_activity_at;not null" json:"last_activity_at"`
 	CreatedAt      time.Time `gorm:"column:created_at" json:"created_at"`
 
+	// TwoFactorPending marks the temporary session of a user who still has to
+	// verify a second factor; it only serves to verify one
+	TwoFactorPending bool `gorm:"column:two_factor_pending;not null;default:false" json:"two_factor_pending"`
+
 	// Relationships
 	User User `gorm:"foreignKey:UserID" json:"user,omitempty"`
 }
@@ -108,9 +136,15 @@ func (Session) TableName() string {
 type TwoFactorAuth struct {
 	ID                   string     `gorm:"column:id;primaryKey;type:char(36)" json:"id"`
 	UserID               string     `gorm:"column:user_id;unique;not null;type:char(36)" json:"user_id"`
-	SecretEncrypted      string     `gorm:"column:secret_encrypted;not null" json:"-"`
+	SecretEncrypted      string     `gorm:"column:secret_encrypted;not null;serializer:encrypted" json:"-"`
 	Enabled              bool       `gorm:"column:enabled;default:false" json:"enabled"`
 	BackupCodesEncrypted string     `gorm:"column:backup_codes_encrypted" json:"-"`
+	Algorithm            string     `gorm:"column:algorithm;type:varchar(10);default:SHA1" json:"algorithm"` // Kept as enrolled, so config changes do not break authenticator apps
+	Digits               int        `gorm:"column:digits;default:6" json:"digits"`
+	Period               int        `gorm:"column:period;default:30" json:"period"`            // Seconds
+	LastUsedStep         int64      `gorm:"column:last_used_step;default:0" json:"-"`          // Time step of the last code accepted; it and earlier ones are refused
+	FailedAttempts       int        `gorm:"column:failed_attempts;default:0" json:"-"`         // Wrong codes since the last accepted one
+	LockedUntil          *time.Time `gorm:"column:locked_until" json:"locked_until,omitempty"` // Set after too many wrong codes
 	EnabledAt            *time.Time `gorm:"column:enabled_at" json:"enabled_at,omitempty"`
 	CreatedAt            time.Time  `gorm:"column:created_at" json:"created_at"`
 	UpdatedAt            time.Time  `gorm:"column:updated_at" json:"updated_at"`
@@ -121,11 +155,104 @@ func (TwoFactorAuth) TableName() string {
 	return "two_factor_auth"
 }
 
+// WebAuthnCredential is a security key or passkey registered by a user, usable
+// as second factor and, when it verifies the user, for passwordless sign-in
+type WebAuthnCredential struct {
+	ID              string      `gorm:"column:id;primaryKey;type:char(36)" json:"id"`
+	UserID          string      `gorm:"column:user_id;not null;type:char(36);index" json:"user_id"`
+	Name            string      `gorm:"column:name;type:varchar(100)" json:"name"`
+	CredentialID    string      `gorm:"column:credential_id;uniqueIndex;not null;type:varchar(512)" json:"credential_id"` // base64url, as sent by the authenticator
+	PublicKey       []byte      `gorm:"column:public_key;not null" json:"-"`                                              // COSE encoded
+	AttestationType string      `gorm:"column:attestation_type;type:varchar(32)" json:"attestation_type"`
+	AAGUID          string      `gorm:"column:aaguid;type:char(36)" json:"aaguid"` // Authenticator model
+	SignCount       uint32      `gorm:"column:sign_count;default:0" json:"sign_count"`
+	CloneWarning    bool        `gorm:"column:clone_warning;default:false" json:"clone_warning"` // The counter went backwards: the key may have been cloned
+	Transports      StringSlice `gorm:"column:transports;type:json" json:"transports"`
+	BackupEligible  bool        `gorm:"column:backup_eligible;default:false" json:"backup_eligible"` // A synced passkey rather than a device-bound key
+	BackupState     bool        `gorm:"column:backup_state;default:false" json:"backup_state"`
+	LastUsedAt      *time.Time  `gorm:"column:last_used_at" json:"last_used_at,omitempty"`
+	CreatedAt       time.Time   `gorm:"column:created_at" json:"created_at"`
+	UpdatedAt       time.Time   `gorm:"column:updated_at" json:"updated_at"`
+}
+
+// TableName specifies the table name
+func (WebAuthnCredential) TableName() string {
+	return "webauthn_credentials"
+}
+
+// WebAuthnChallenge is the state of a registration or sign-in ceremony between
+// its begin and finish requests. It is used once.
+type WebAuthnChallenge struct {
+	ID        string    `gorm:"column:id;primaryKey;type:varchar(64)" json:"id"`
+	Ceremony  string    `gorm:"column:ceremony;type:varchar(20)" json:"ceremony"` // registration, login or second_factor
+	UserID    string    `gorm:"column:user_id;type:char(36)" json:"user_id"`      // Empty for passwordless sign-in
+	Data      string    `gorm:"column:data;type:text" json:"-"`                   // JSON webauthn.SessionData
+	ExpiresAt time.Time `gorm:"column:expires_at;index" json:"expires_at"`
+	CreatedAt time.Time `gorm:"column:created_at" json:"created_at"`
+}
+
+// TableName specifies the table name
+func (WebAuthnChallenge) TableName() string {
+	return "webauthn_challenges"
+}
+
+// OTPFactor is a second factor whose one-time codes are sent to the user over a
+// channel such as email. It is enabled once a code sent to Destination has been
+// entered; a user has at most one factor per method.
+type OTPFactor struct {
+	ID          string     `gorm:"column:id;primaryKey;type:char(36)" json:"id"`
+	UserID      string     `gorm:"column:user_id;not null;type:char(36);uniqueIndex:idx_otp_factor_user_method" json:"user_id"`
+	Method      string     `gorm:"column:method;not null;type:varchar(20);uniqueIndex:idx_otp_factor_user_method" json:"method"` // email, sms or voice
+	Destination string     `gorm:"column:destination;not null;type:varchar(255)" json:"destination"`                             // Where codes are sent
+	Enabled     bool       `gorm:"column:enabled;default:false" json:"enabled"`
+	EnabledAt   *time.Time `gorm:"column:enabled_at" json:"enabled_at,omitempty"`
+	LastUsedAt  *time.Time `gorm:"column:last_used_at" json:"last_used_at,omitempty"`
+	CreatedAt   time.Time  `gorm:"column:created_at" json:"created_at"`
+	UpdatedAt   time.Time  `gorm:"column:updated_at" json:"updated_at"`
+}
+
+// TableName specifies the table name
+func (OTPFactor) TableName() string {
+	return "otp_factors"
+}
+
+// OTPCode is a one-time code sent for a factor, either to sign in or to confirm
+// the factor. Only its hash is kept, and it is spent when entered correctly or
+// after too many wrong guesses.
+type OTPCode struct {
+	ID        string    `gorm:"column:id;primaryKey;type:char(36)" json:"id"`
+	FactorID  string    `gorm:"column:factor_id;not null;type:char(36);index" json:"factor_id"`
+	Purpose   string    `gorm:"column:purpose;not null;type:varchar(20)" json:"purpose"` // sign_in or enroll
+	CodeHash  string    `gorm:"column:code_hash;not null;type:varchar(255)" json:"-"`    // bcrypt
+	Attempts  int       `gorm:"column:attempts;default:0" json:"attempts"`
+	ExpiresAt time.Time `gorm:"column:expires_at;index" json:"expires_at"`
+	CreatedAt time.Time `gorm:"column:created_at" json:"created_at"`
+}
+
+// TableName specifies the table name
+func (OTPCode) TableName() string {
+	return "otp_codes"
+}
+
+// SMSDelivery records a text message or voice call sent to a phone number, to
+// limit how many each number gets
+type SMSDelivery struct {
+	ID          string    `gorm:"column:id;primaryKey;type:char(36)" json:"id"`
+	PhoneNumber string    `gorm:"column:phone_number;not null;type:varchar(20);index:idx_sms_deliveries_number_created" json:"phone_number"`
+	Channel     string    `gorm:"column:channel;not null;type:varchar(10)" json:"channel"` // sms or voice
+	CreatedAt   time.Time `gorm:"column:created_at;index:idx_sms_deliveries_number_created" json:"created_at"`
+}
+
+// TableName specifies the table name
+func (SMSDelivery) TableName() string {
+	return "sms_deliveries"
+}
+
 // OAuthClient represents an OAuth2 client application
 type OAuthClient struct {
 	ID            string    `gorm:"column:id;primaryKey;type:char(36)" json:"id"`
 	ClientID      string    `gorm:"column:client_id;unique;not null" json:"client_id"`
-	ClientSecret  string    `gorm:"column:client_secret;not null" json:"-"`
+	ClientSecret  string    `gorm:"column:client_secret;not null;type:text;serializer:encrypted" json:"-"`
 	Name          string    `gorm:"column:name;not null" json:"name"`
 	RedirectURIs  string    `gorm:"column:redirect_uris;not null" json:"redirect_uris"`
 	AllowedScopes string    `gorm:"column:allowed_scopes" json:"allowed_scopes"`
@@ -144,7 +271,7 @@ type PasswordResetToken struct {
 	ID        string     `gorm:"primaryKey" json:"id"`
 	UserID    string     `gorm:"not null;index;type:char(36)" json:"user_id"`
 	Email     string     `gorm:"not null;index;type:varchar(255)" json:"email"`
-	Token     string     `gorm:"not null;unique;index;type:varchar(255)" json:"token"`
+	Token     string     `gorm:"not null;unique;index;type:varchar(255)" json:"token"` // SHA-256 of the token sent by email
 	ExpiresAt time.Time  `gorm:"not null" json:"expires_at"`
 	Used      bool       `gorm:"default:false" json:"used"`
 	UsedAt    *time.Time `json:"used_at"`
@@ -6,19 +6,37 @@ import "time"
 
 // OAuth2Client represents a registered OAuth2 client application
 type OAuth2Client struct {
-	ID            string      `gorm:"column:id;primaryKey" json:"id"`
-	ClientID      string      `gorm:"column:client_id;uniqueIndex;type:varchar(255)" json:"client_id"`
-	ClientSecret  string      `gorm:"column:client_secret_hash" json:"-"` // Never expose in JSON
-	Name          string      `gorm:"column:name" json:"name"`
-	Description   string      `gorm:"column:description" json:"description"`
-	RedirectURIs  StringSlice `gorm:"column:redirect_uris;type:json" json:"redirect_uris"`
-	AllowedScopes StringSlice `gorm:"column:allowed_scopes;type:json" json:"allowed_scopes"`
-	GrantTypes    StringSlice `gorm:"column:grant_types;type:json" json:"grant_types"`
-	IsPublic      bool        `gorm:"column:is_public;default:false" json:"is_public"`
-	IsActive      bool        `gorm:"column:is_active;default:true" json:"is_active"`
-	OwnerUserID   *string     `gorm:"column:owner_user_id;type:char(36)" json:"owner_user_id,omitempty"`
-	CreatedAt     time.Time   `gorm:"column:created_at" json:"created_at"`
-	UpdatedAt     time.Time   `gorm:"column:updated_at" json:"updated_at"`
+	ID                 string      `gorm:"column:id;primaryKey" json:"id"`
+	ClientID           string      `gorm:"column:client_id;uniqueIndex;type:varchar(255)" json:"client_id"`
+	ClientSecret       string      `gorm:"column:client_secret_hash" json:"-"` // Never expose in JSON
+	Name               string      `gorm:"column:name" json:"name"`
+	Description        string      `gorm:"column:description" json:"description"`
+	RedirectURIs       StringSlice `gorm:"column:redirect_uris;type:json" json:"redirect_uris"`
+	AllowedScopes      StringSlice `gorm:"column:allowed_scopes;type:json" json:"allowed_scopes"`
+	GrantTypes         StringSlice `gorm:"column:grant_types;type:json" json:"grant_types"`
+	IsPublic           bool        `gorm:"column:is_public;default:false" json:"is_public"`
+	IsActive           bool        `gorm:"column:is_active;default:true" json:"is_active"`
+	OwnerUserID        *string     `gorm:"column:owner_user_id;type:char(36)" json:"owner_user_id,omitempty"`
+	SubjectType        string      `gorm:"column:subject_type;type:varchar(20);default:public" json:"subject_type"` // "public" or "pairwise"
+	SectorIdentifier   string      `gorm:"column:sector_identifier;type:varchar(255)" json:"sector_identifier,omitempty"`
+	LogoURI            string      `gorm:"column:logo_uri;type:varchar(512)" json:"logo_uri,omitempty"`
+	PolicyURI          string      `gorm:"column:policy_uri;type:varchar(512)" json:"policy_uri,omitempty"`
+	TosURI             string      `gorm:"column:tos_uri;type:varchar(512)" json:"tos_uri,omitempty"`
+	SupportURI         string      `gorm:"column:support_uri;type:varchar(512)" json:"support_uri,omitempty"`
+	Theme              string      `gorm:"column:theme;type:varchar(100)" json:"theme,omitempty"` // Hosted page theme name
+	IsFirstParty       bool        `gorm:"column:is_first_party;default:false" json:"is_first_party"`
+	ConsentPolicy      string      `gorm:"column:consent_policy;type:varchar(20);default:once" json:"consent_policy"`          // "always", "once" or "implied"
+	ConsentLifetime    int         `gorm:"column:consent_lifetime;default:0" json:"consent_lifetime"`                          // Seconds; 0 means consents never expire
+	PolicyVersion      string      `gorm:"column:policy_version;type:varchar(50)" json:"policy_version,omitempty"`             // Bumping it asks users for consent again
+	RequiredScopes     StringSlice `gorm:"column:required_scopes;type:json" json:"required_scopes"`                            // Scopes the user cannot untick
+	AccessTokenTTL     int         `gorm:"column:access_token_ttl;default:0" json:"access_token_ttl"`                          // Seconds; 0 uses the server default
+	IDTokenTTL         int         `gorm:"column:id_token_ttl;default:0" json:"id_token_ttl"`                                  // Seconds; 0 uses the access token lifetime
+	RefreshTokenTTL    int         `gorm:"column:refresh_token_ttl;default:0" json:"refresh_token_ttl"`                        // Seconds each refresh token lives; 0 uses the server default
+	RefreshAbsoluteTTL int         `gorm:"column:refresh_absolute_ttl;default:0" json:"refresh_absolute_ttl"`                  // Seconds after authorization when refreshing stops; 0 means never
+	RefreshIdleTTL     int         `gorm:"column:refresh_idle_ttl;default:0" json:"refresh_idle_ttl"`                          // Seconds a refresh token may go unused; 0 means no limit
+	AccessTokenFormat  string      `gorm:"column:access_token_format;type:varchar(20);default:jwt" json:"access_token_format"` // "jwt" or "opaque"
+	CreatedAt          time.Time   `gorm:"column:created_at" json:"created_at"`
+	UpdatedAt          time.Time   `gorm:"column:updated_at" json:"updated_at"`
 }
 
 func (OAuth2Client) TableName() string {
@@ -74,15 +92,16 @@ func (OAuth2AccessToken) TableName() string {
 
 // OAuth2RefreshToken represents an OAuth2 refresh token
 type OAuth2RefreshToken struct {
-	ID            string      `gorm:"column:id;primaryKey" json:"id"`
-	Token         string      `gorm:"column:token;uniqueIndex;type:varchar(255)" json:"-"` // Never expose in JSON
-	AccessTokenID *string     `gorm:"column:access_token_id;type:char(36)" json:"access_token_id,omitempty"`
-	ClientID      string      `gorm:"column:client_id;type:varchar(255)" json:"client_id"`
-	UserID        string      `gorm:"column:user_id;type:char(36)" json:"user_id"`
-	Scopes        StringSlice `gorm:"column:scopes;type:json" json:"scopes"`
-	ExpiresAt     time.Time   `gorm:"column:expires_at" json:"expires_at"`
-	Revoked       bool        `gorm:"column:revoked;default:false" json:"revoked"`
-	CreatedAt     time.Time   `gorm:"column:created_at" json:"created_at"`
+	ID                string      `gorm:"column:id;primaryKey" json:"id"`
+	Token             string      `gorm:"column:token;uniqueIndex;type:varchar(255)" json:"-"` // Never expose in JSON
+	AccessTokenID     *string     `gorm:"column:access_token_id;type:char(36)" json:"access_token_id,omitempty"`
+	ClientID          string      `gorm:"column:client_id;type:varchar(255)" json:"client_id"`
+	UserID            string      `gorm:"column:user_id;type:char(36)" json:"user_id"`
+	Scopes            StringSlice `gorm:"column:scopes;type:json" json:"scopes"`
+	ExpiresAt         time.Time   `gorm:"column:expires_at" json:"expires_at"`
+	AbsoluteExpiresAt *time.Time  `gorm:"column:absolute_expires_at" json:"absolute_expires_at,omitempty"` // Kept on rotation; no token of the grant outlives it
+	Revoked           bool        `gorm:"column:revoked;default:false" json:"revoked"`
+	CreatedAt         time.Time   `gorm:"column:created_at" json:"created_at"`
 }
 
 func (OAuth2RefreshToken) TableName() string {
@@ -91,14 +110,85 @@ func (OAuth2RefreshToken) TableName() string {
 
 // OAuth2Consent represents a user's consent to an OAuth2 client
 type OAuth2Consent struct {
-	ID        string      `gorm:"column:id;primaryKey" json:"id"`
-	UserID    string      `gorm:"column:user_id;type:char(36)" json:"user_id"`
-	ClientID  string      `gorm:"column:client_id;type:varchar(255)" json:"client_id"`
-	Scopes    StringSlice `gorm:"column:scopes;type:json" json:"scopes"`
-	GrantedAt time.Time   `gorm:"column:granted_at" json:"granted_at"`
-	UpdatedAt time.Time   `gorm:"column:updated_at" json:"updated_at"`
+	ID            string      `gorm:"column:id;primaryKey" json:"id"`
+	UserID        string      `gorm:"column:user_id;type:char(36)" json:"user_id"`
+	ClientID      string      `gorm:"column:client_id;type:varchar(255)" json:"client_id"`
+	Scopes        StringSlice `gorm:"column:scopes;type:json" json:"scopes"`
+	Implied       bool        `gorm:"column:implied;default:false" json:"implied"` // Granted by the client's consent policy, not the user
+	PolicyVersion string      `gorm:"column:policy_version;type:varchar(50)" json:"policy_version,omitempty"`
+	ExpiresAt     *time.Time  `gorm:"column:expires_at" json:"expires_at,omitempty"`
+	GrantedAt     time.Time   `gorm:"column:granted_at" json:"granted_at"`
+	UpdatedAt     time.Time   `gorm:"column:updated_at" json:"updated_at"`
 }
 
 func (OAuth2Consent) TableName() string {
 	return "oauth2_consents"
 }
+
+// OAuth2ConsentHistory records every change to a user's consent for compliance
+type OAuth2ConsentHistory struct {
+	ID            string      `gorm:"column:id;primaryKey" json:"id"`
+	UserID        string      `gorm:"column:user_id;type:char(36);index:idx_consent_history_user_client" json:"user_id"`
+	ClientID      string      `gorm:"column:client_id;type:varchar(255);index:idx_consent_history_user_client" json:"client_id"`
+	Action        string      `gorm:"column:action;type:varchar(20)" json:"action"` // "granted", "implied" or "revoked"
+	Scopes        StringSlice `gorm:"column:scopes;type:json" json:"scopes"`
+	PolicyVersion string      `gorm:"column:policy_version;type:varchar(50)" json:"policy_version,omitempty"`
+	ExpiresAt     *time.Time  `gorm:"column:expires_at" json:"expires_at,omitempty"`
+	CreatedAt     time.Time   `gorm:"column:created_at" json:"created_at"`
+}
+
+func (OAuth2ConsentHistory) TableName() string {
+	return "oauth2_consent_history"
+}
+
+// OAuth2PairwiseSubject maps a pairwise subject identifier back to the local user
+type OAuth2PairwiseSubject struct {
+	ID        string    `gorm:"column:id;primaryKey" json:"id"`
+	Sector    string    `gorm:"column:sector;type:varchar(255);uniqueIndex:idx_sector_subject" json:"sector"`
+	Subject   string    `gorm:"column:subject;type:varchar(255);uniqueIndex:idx_sector_subject" json:"subject"`
+	UserID    string    `gorm:"column:user_id;type:char(36);index" json:"user_id"`
+	CreatedAt time.Time `gorm:"column:created_at" json:"created_at"`
+}
+
+func (OAuth2PairwiseSubject) TableName() string {
+	return "oauth2_pairwise_subjects"
+}
+
+// ServiceAccount is the machine identity behind an OAuth2 client's client_credentials tokens
+type ServiceAccount struct {
+	ID          string    `gorm:"column:id;primaryKey;type:char(36)" json:"id"`
+	ClientID    string    `gorm:"column:client_id;uniqueIndex;type:varchar(255)" json:"client_id"`
+	Name        string    `gorm:"column:name;not null" json:"name"`
+	Description string    `gorm:"column:description" json:"description"`
+	IsActive    bool      `gorm:"column:is_active;default:true" json:"is_active"`
+	CreatedAt   time.Time `gorm:"column:created_at" json:"created_at"`
+	UpdatedAt   time.Time `gorm:"column:updated_at" json:"updated_at"`
+
+	// Relationships
+	Roles []Role `gorm:"many2many:service_account_roles" json:"roles,omitempty"`
+}
+
+func (ServiceAccount) TableName() string {
+	return "service_accounts"
+}
+
+// OAuth2TrustedIssuer is an external token issuer whose JWTs may be exchanged
+// for access tokens with the JWT bearer grant (RFC 7523)
+type OAuth2TrustedIssuer struct {
+	ID               string      `gorm:"column:id;primaryKey;type:char(36)" json:"id"`
+	Name             string      `gorm:"column:name;not null" json:"name"`
+	Issuer           string      `gorm:"column:issuer;uniqueIndex;type:varchar(255)" json:"issuer"`
+	JWKSURL          string      `gorm:"column:jwks_url;type:varchar(512)" json:"jwks_url,omitempty"`
+	JWKS             string      `gorm:"column:jwks;type:text" json:"jwks,omitempty"`               // Inline JWK set, used instead of jwks_url
+	AllowedSubjects  StringSlice `gorm:"column:allowed_subjects;type:json" json:"allowed_subjects"` // Patterns; * matches any characters
+	Audiences        StringSlice `gorm:"column:audiences;type:json" json:"audiences"`               // The assertion must name one of these
+	ClientID         string      `gorm:"column:client_id;type:varchar(255);index" json:"client_id"` // Client the access tokens are issued to
+	ServiceAccountID *string     `gorm:"column:service_account_id;type:char(36)" json:"service_account_id,omitempty"`
+	IsActive         bool        `gorm:"column:is_active;default:true" json:"is_active"`
+	CreatedAt        time.Time   `gorm:"column:created_at" json:"created_at"`
+	UpdatedAt        time.Time   `gorm:"column:updated_at" json:"updated_at"`
+}
+
+func (OAuth2TrustedIssuer) TableName() string {
+	return "oauth2_trusted_issuers"
+}
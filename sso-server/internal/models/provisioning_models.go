@@ -0,0 +1,95 @@
+package models
+
+import "time"
+
+// ==================== Provisioning Models ====================
+
+// SCIMClient is an HR or IGA system allowed to manage users and groups through
+// the SCIM 2.0 API with a bearer token
+type SCIMClient struct {
+	ID          string     `gorm:"column:id;primaryKey;type:char(36)" json:"id"`
+	Name        string     `gorm:"column:name;not null" json:"name"`
+	Description string     `gorm:"column:description" json:"description"`
+	TokenHash   string     `gorm:"column:token_hash;uniqueIndex;type:varchar(64)" json:"-"`  // SHA-256 of the bearer token
+	TokenPrefix string     `gorm:"column:token_prefix;type:varchar(16)" json:"token_prefix"` // Start of the token, to tell tokens apart
+	IsActive    bool       `gorm:"column:is_active;default:true" json:"is_active"`
+	ExpiresAt   *time.Time `gorm:"column:expires_at" json:"expires_at,omitempty"`
+	LastUsedAt  *time.Time `gorm:"column:last_used_at" json:"last_used_at,omitempty"`
+	CreatedAt   time.Time  `gorm:"column:created_at" json:"created_at"`
+	UpdatedAt   time.Time  `gorm:"column:updated_at" json:"updated_at"`
+}
+
+func (SCIMClient) TableName() string {
+	return "scim_clients"
+}
+
+// SCIMExternalID is the identifier a SCIM client keeps for one of our users or
+// groups (roles), returned to that client as externalId
+type SCIMExternalID struct {
+	ID           string    `gorm:"column:id;primaryKey;type:char(36)" json:"id"`
+	ClientID     string    `gorm:"column:client_id;not null;type:char(36);uniqueIndex:idx_scim_external;uniqueIndex:idx_scim_resource" json:"client_id"`
+	ResourceType string    `gorm:"column:resource_type;type:varchar(10);uniqueIndex:idx_scim_external;uniqueIndex:idx_scim_resource" json:"resource_type"` // User or Group
+	ResourceID   string    `gorm:"column:resource_id;type:char(36);uniqueIndex:idx_scim_resource" json:"resource_id"`
+	ExternalID   string    `gorm:"column:external_id;type:varchar(255);uniqueIndex:idx_scim_external" json:"external_id"`
+	CreatedAt    time.Time `gorm:"column:created_at" json:"created_at"`
+}
+
+func (SCIMExternalID) TableName() string {
+	return "scim_external_ids"
+}
+
+// ProvisioningConnector pushes users to a downstream application (an OAuth2
+// client) through the application's SCIM 2.0 API
+type ProvisioningConnector struct {
+	ID               string      `gorm:"column:id;primaryKey;type:char(36)" json:"id"`
+	ClientID         string      `gorm:"column:client_id;uniqueIndex;type:varchar(255)" json:"client_id"` // OAuth2 client the application signs in with
+	Name             string      `gorm:"column:name;not null" json:"name"`
+	EndpointURL      string      `gorm:"column:endpoint_url;type:varchar(512)" json:"endpoint_url"` // SCIM base URL, without /Users
+	AuthType         string      `gorm:"column:auth_type;type:varchar(20);default:bearer" json:"auth_type"`
+	Username         string      `gorm:"column:username;type:varchar(255)" json:"username,omitempty"` // Basic auth only
+	Secret           string      `gorm:"column:secret;type:text;serializer:encrypted" json:"-"`       // Bearer token or basic auth password
+	AttributeMapping StringMap   `gorm:"column:attribute_mapping;type:json" json:"attribute_mapping"` // SCIM attribute -> user field
+	RoleIDs          StringSlice `gorm:"column:role_ids;type:json" json:"role_ids"`                   // Only users holding one of these roles; empty = every user
+	Deprovision      string      `gorm:"column:deprovision;type:varchar(20);default:deactivate" json:"deprovision"`
+	IsActive         bool        `gorm:"column:is_active;default:true" json:"is_active"`
+	CreatedAt        time.Time   `gorm:"column:created_at" json:"created_at"`
+	UpdatedAt        time.Time   `gorm:"column:updated_at" json:"updated_at"`
+}
+
+func (ProvisioningConnector) TableName() string {
+	return "provisioning_connectors"
+}
+
+// ProvisioningJob is a queued request to bring one user up to date in one
+// application. The job carries no payload: the user is read when it runs.
+type ProvisioningJob struct {
+	ID            string    `gorm:"column:id;primaryKey;type:char(36)" json:"id"`
+	ConnectorID   string    `gorm:"column:connector_id;not null;type:char(36);index:idx_provisioning_job_user" json:"connector_id"`
+	UserID        string    `gorm:"column:user_id;not null;type:char(36);index:idx_provisioning_job_user" json:"user_id"`
+	Reason        string    `gorm:"column:reason;type:varchar(50)" json:"reason"` // What triggered the job, e.g. user_created
+	Status        string    `gorm:"column:status;type:varchar(20);index:idx_provisioning_job_due" json:"status"`
+	Attempts      int       `gorm:"column:attempts;default:0" json:"attempts"`
+	NextAttemptAt time.Time `gorm:"column:next_attempt_at;index:idx_provisioning_job_due" json:"next_attempt_at"`
+	LastError     string    `gorm:"column:last_error;type:text" json:"last_error,omitempty"`
+	CreatedAt     time.Time `gorm:"column:created_at" json:"created_at"`
+	UpdatedAt     time.Time `gorm:"column:updated_at" json:"updated_at"`
+}
+
+func (ProvisioningJob) TableName() string {
+	return "provisioning_jobs"
+}
+
+// ProvisioningState is the last known state of a user in an application
+type ProvisioningState struct {
+	ConnectorID  string     `gorm:"column:connector_id;primaryKey;type:char(36)" json:"connector_id"`
+	UserID       string     `gorm:"column:user_id;primaryKey;type:char(36);index" json:"user_id"`
+	RemoteID     string     `gorm:"column:remote_id;type:varchar(255)" json:"remote_id"` // The application's SCIM id for the user
+	Status       string     `gorm:"column:status;type:varchar(20)" json:"status"`        // provisioned, deactivated, deleted or error
+	LastError    string     `gorm:"column:last_error;type:text" json:"last_error,omitempty"`
+	LastSyncedAt *time.Time `gorm:"column:last_synced_at" json:"last_synced_at,omitempty"`
+	UpdatedAt    time.Time  `gorm:"column:updated_at" json:"updated_at"`
+}
+
+func (ProvisioningState) TableName() string {
+	return "provisioning_states"
+}
@@ -0,0 +1,146 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/sso-project/sso-server/internal/models"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrCASServiceNotFound = errors.New("cas service not found")
+	ErrCASTicketNotFound  = errors.New("cas ticket not found")
+)
+
+// CASServiceRepository handles CAS service and ticket persistence
+type CASServiceRepository struct {
+	db *gorm.DB
+}
+
+// NewCASServiceRepository creates a new CASServiceRepository
+func NewCASServiceRepository(db *gorm.DB) *CASServiceRepository {
+	return &CASServiceRepository{db: db}
+}
+
+// Create creates a new service
+func (r *CASServiceRepository) Create(ctx context.Context, svc *models.CASService) error {
+	return r.db.WithContext(ctx).Create(svc).Error
+}
+
+// GetByID retrieves a service by ID
+func (r *CASServiceRepository) GetByID(ctx context.Context, id string) (*models.CASService, error) {
+	var svc models.CASService
+	err := r.db.WithContext(ctx).Where("id = ?", id).First(&svc).Error
+
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrCASServiceNotFound
+		}
+		return nil, err
+	}
+
+	return &svc, nil
+}
+
+// Update updates a service
+func (r *CASServiceRepository) Update(ctx context.Context, svc *models.CASService) error {
+	return r.db.WithContext(ctx).Save(svc).Error
+}
+
+// Delete removes a service with its tickets
+func (r *CASServiceRepository) Delete(ctx context.Context, id string) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("service_id = ?", id).Delete(&models.CASTicket{}).Error; err != nil {
+			return err
+		}
+
+		result := tx.Delete(&models.CASService{}, "id = ?", id)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrCASServiceNotFound
+		}
+		return nil
+	})
+}
+
+// GetAll retrieves all services
+func (r *CASServiceRepository) GetAll(ctx context.Context) ([]*models.CASService, error) {
+	var services []*models.CASService
+	err := r.db.WithContext(ctx).Order("name ASC").Find(&services).Error
+	return services, err
+}
+
+// CreateTicket stores a new ticket, removing expired ones on the way
+func (r *CASServiceRepository) CreateTicket(ctx context.Context, ticket *models.CASTicket) error {
+	db := r.db.WithContext(ctx)
+
+	if err := db.Where("expires_at < ?", time.Now()).Delete(&models.CASTicket{}).Error; err != nil {
+		return err
+	}
+
+	return db.Create(ticket).Error
+}
+
+// ConsumeTicket marks a ticket of one of the given types used and returns it. It
+// returns ErrCASTicketNotFound if there is no such ticket, or it has expired or
+// was already consumed. A consumed ticket is kept until keepUntil.
+func (r *CASServiceRepository) ConsumeTicket(ctx context.Context, id string, types []string, keepUntil time.Time) (*models.CASTicket, error) {
+	var ticket models.CASTicket
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		result := tx.Model(&models.CASTicket{}).
+			Where("id = ? AND type IN ? AND consumed_at IS NULL AND expires_at > ?", id, types, now).
+			Updates(map[string]interface{}{"consumed_at": now, "expires_at": keepUntil})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrCASTicketNotFound
+		}
+		return tx.Where("id = ?", id).First(&ticket).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &ticket, nil
+}
+
+// GetTicket retrieves a ticket that has not expired
+func (r *CASServiceRepository) GetTicket(ctx context.Context, id string) (*models.CASTicket, error) {
+	var ticket models.CASTicket
+	err := r.db.WithContext(ctx).Where("id = ? AND expires_at > ?", id, time.Now()).First(&ticket).Error
+
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrCASTicketNotFound
+		}
+		return nil, err
+	}
+
+	return &ticket, nil
+}
+
+// DeleteTicket removes a ticket
+func (r *CASServiceRepository) DeleteTicket(ctx context.Context, id string) error {
+	return r.db.WithContext(ctx).Where("id = ?", id).Delete(&models.CASTicket{}).Error
+}
+
+// GetConsumedServiceTickets retrieves the service tickets validated during a session
+func (r *CASServiceRepository) GetConsumedServiceTickets(ctx context.Context, sessionID string) ([]*models.CASTicket, error) {
+	var tickets []*models.CASTicket
+	err := r.db.WithContext(ctx).
+		Where("session_id = ? AND type = ? AND consumed_at IS NOT NULL", sessionID, models.CASServiceTicket).
+		Order("created_at ASC").
+		Find(&tickets).Error
+	return tickets, err
+}
+
+// DeleteSessionTickets removes every ticket issued during a session
+func (r *CASServiceRepository) DeleteSessionTickets(ctx context.Context, sessionID string) error {
+	return r.db.WithContext(ctx).Where("session_id = ?", sessionID).Delete(&models.CASTicket{}).Error
+}
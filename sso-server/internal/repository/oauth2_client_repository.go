@@ -156,6 +156,38 @@ func (r *OAuth2ClientRepository) ValidateScopes(ctx context.Context, clientID st
 	return true, nil
 }
 
+// GetClientIDsUsingScope returns the client_ids of active clients that allow a scope
+func (r *OAuth2ClientRepository) GetClientIDsUsingScope(ctx context.Context, scope string) ([]string, error) {
+	var clients []*models.OAuth2Client
+	if err := r.db.WithContext(ctx).
+		Where("is_active = ?", true).
+		Find(&clients).Error; err != nil {
+		return nil, err
+	}
+
+	// allowed_scopes is a JSON column, so match in Go to stay portable across drivers
+	var clientIDs []string
+	for _, client := range clients {
+		for _, allowed := range client.AllowedScopes {
+			if allowed == scope {
+				clientIDs = append(clientIDs, client.ClientID)
+				break
+			}
+		}
+	}
+
+	return clientIDs, nil
+}
+
+// CountBySubjectType counts active clients with the given subject type
+func (r *OAuth2ClientRepository) CountBySubjectType(ctx context.Context, subjectType string) (int64, error) {
+	var count int64
+	err := r.db.WithContext(ctx).Model(&models.OAuth2Client{}).
+		Where("subject_type = ? AND is_active = ?", subjectType, true).
+		Count(&count).Error
+	return count, err
+}
+
 // GetDB returns the underlying GORM DB instance
 func (r *OAuth2ClientRepository) GetDB() *gorm.DB {
 	return r.db
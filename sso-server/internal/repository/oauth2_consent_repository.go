@@ -60,7 +60,15 @@ func (r *OAuth2ConsentRepository) Upsert(ctx context.Context, consent *models.OA
 	if existing != nil {
 		// Update existing
 		existing.Scopes = consent.Scopes
-		return r.Update(ctx, existing)
+		existing.Implied = consent.Implied
+		existing.PolicyVersion = consent.PolicyVersion
+		existing.ExpiresAt = consent.ExpiresAt
+		existing.GrantedAt = consent.GrantedAt
+		if err := r.Update(ctx, existing); err != nil {
+			return err
+		}
+		*consent = *existing
+		return nil
 	}
 
 	// Create new
@@ -122,3 +130,34 @@ func (r *OAuth2ConsentRepository) CheckConsent(ctx context.Context, userID, clie
 
 	return true, nil, nil
 }
+
+// CreateHistory records a consent change
+func (r *OAuth2ConsentRepository) CreateHistory(ctx context.Context, entry *models.OAuth2ConsentHistory) error {
+	return r.db.WithContext(ctx).Create(entry).Error
+}
+
+// ListHistory retrieves consent changes, newest first; empty user or client IDs match everything
+func (r *OAuth2ConsentRepository) ListHistory(ctx context.Context, userID, clientID string, limit, offset int) ([]*models.OAuth2ConsentHistory, int64, error) {
+	var entries []*models.OAuth2ConsentHistory
+	var total int64
+
+	query := r.db.WithContext(ctx).Model(&models.OAuth2ConsentHistory{})
+	if userID != "" {
+		query = query.Where("user_id = ?", userID)
+	}
+	if clientID != "" {
+		query = query.Where("client_id = ?", clientID)
+	}
+
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	query = query.Order("created_at DESC")
+	if limit > 0 {
+		query = query.Limit(limit).Offset(offset)
+	}
+
+	err := query.Find(&entries).Error
+	return entries, total, err
+}
@@ -27,6 +27,40 @@ func (r *OAuth2ScopeRepository) Create(ctx context.Context, scope *models.OAuth2
 	return r.db.WithContext(ctx).Create(scope).Error
 }
 
+// GetByID retrieves a scope by its internal ID
+func (r *OAuth2ScopeRepository) GetByID(ctx context.Context, id string) (*models.OAuth2Scope, error) {
+	var scope models.OAuth2Scope
+	err := r.db.WithContext(ctx).
+		Where("id = ?", id).
+		First(&scope).Error
+
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrScopeNotFound
+		}
+		return nil, err
+	}
+
+	return &scope, nil
+}
+
+// Update updates an existing scope
+func (r *OAuth2ScopeRepository) Update(ctx context.Context, scope *models.OAuth2Scope) error {
+	return r.db.WithContext(ctx).Save(scope).Error
+}
+
+// Delete removes a scope by its internal ID
+func (r *OAuth2ScopeRepository) Delete(ctx context.Context, id string) error {
+	result := r.db.WithContext(ctx).Delete(&models.OAuth2Scope{}, "id = ?", id)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrScopeNotFound
+	}
+	return nil
+}
+
 // GetAll retrieves all scopes
 func (r *OAuth2ScopeRepository) GetAll(ctx context.Context) ([]*models.OAuth2Scope, error) {
 	var scopes []*models.OAuth2Scope
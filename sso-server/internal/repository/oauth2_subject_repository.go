@@ -0,0 +1,48 @@
+package repository
+
+import (
+	"context"
+	"errors"
+
+	"github.com/sso-project/sso-server/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrSubjectNotFound = errors.New("pairwise subject not found")
+)
+
+// OAuth2SubjectRepository handles pairwise subject identifier persistence
+type OAuth2SubjectRepository struct {
+	db *gorm.DB
+}
+
+// NewOAuth2SubjectRepository creates a new OAuth2SubjectRepository
+func NewOAuth2SubjectRepository(db *gorm.DB) *OAuth2SubjectRepository {
+	return &OAuth2SubjectRepository{db: db}
+}
+
+// Save records a pairwise subject; existing sector/subject pairs are left untouched
+func (r *OAuth2SubjectRepository) Save(ctx context.Context, subject *models.OAuth2PairwiseSubject) error {
+	return r.db.WithContext(ctx).
+		Clauses(clause.OnConflict{DoNothing: true}).
+		Create(subject).Error
+}
+
+// GetBySubject retrieves the mapping for a pairwise subject within a sector
+func (r *OAuth2SubjectRepository) GetBySubject(ctx context.Context, sector, subject string) (*models.OAuth2PairwiseSubject, error) {
+	var pairwise models.OAuth2PairwiseSubject
+	err := r.db.WithContext(ctx).
+		Where("sector = ? AND subject = ?", sector, subject).
+		First(&pairwise).Error
+
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrSubjectNotFound
+		}
+		return nil, err
+	}
+
+	return &pairwise, nil
+}
@@ -17,6 +17,36 @@ var (
 	ErrTokenRevoked  = errors.New("token has been revoked")
 )
 
+// TokenFilter narrows token queries for admin listing and bulk revocation
+type TokenFilter struct {
+	UserID       string
+	ClientID     string
+	IssuedAfter  *time.Time
+	IssuedBefore *time.Time
+}
+
+// IsEmpty reports whether no filter criteria are set
+func (f TokenFilter) IsEmpty() bool {
+	return f.UserID == "" && f.ClientID == "" && f.IssuedAfter == nil && f.IssuedBefore == nil
+}
+
+// apply adds the filter criteria to a query
+func (f TokenFilter) apply(query *gorm.DB) *gorm.DB {
+	if f.UserID != "" {
+		query = query.Where("user_id = ?", f.UserID)
+	}
+	if f.ClientID != "" {
+		query = query.Where("client_id = ?", f.ClientID)
+	}
+	if f.IssuedAfter != nil {
+		query = query.Where("created_at >= ?", *f.IssuedAfter)
+	}
+	if f.IssuedBefore != nil {
+		query = query.Where("created_at <= ?", *f.IssuedBefore)
+	}
+	return query
+}
+
 // OAuth2TokenRepository handles OAuth2 token persistence
 type OAuth2TokenRepository struct {
 	db *gorm.DB
@@ -66,6 +96,35 @@ func (r *OAuth2TokenRepository) RevokeAccessToken(ctx context.Context, tokenID s
 		Delete(&models.OAuth2AccessToken{}, "id = ?", tokenID).Error
 }
 
+// ListActiveAccessTokens retrieves unexpired access tokens matching a filter
+func (r *OAuth2TokenRepository) ListActiveAccessTokens(ctx context.Context, filter TokenFilter, limit, offset int) ([]*models.OAuth2AccessToken, int64, error) {
+	var tokens []*models.OAuth2AccessToken
+	var total int64
+
+	query := filter.apply(r.db.WithContext(ctx).
+		Model(&models.OAuth2AccessToken{}).
+		Where("expires_at > ?", time.Now()))
+
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	query = query.Order("created_at DESC")
+	if limit > 0 {
+		query = query.Limit(limit).Offset(offset)
+	}
+
+	err := query.Find(&tokens).Error
+	return tokens, total, err
+}
+
+// RevokeAccessTokens invalidates all access tokens matching a filter
+func (r *OAuth2TokenRepository) RevokeAccessTokens(ctx context.Context, filter TokenFilter) (int64, error) {
+	result := filter.apply(r.db.WithContext(ctx)).
+		Delete(&models.OAuth2AccessToken{})
+	return result.RowsAffected, result.Error
+}
+
 // DeleteExpiredAccessTokens removes expired access tokens
 func (r *OAuth2TokenRepository) DeleteExpiredAccessTokens(ctx context.Context) error {
 	return r.db.WithContext(ctx).
@@ -115,6 +174,37 @@ func (r *OAuth2TokenRepository) RevokeRefreshToken(ctx context.Context, token st
 		Update("revoked", true).Error
 }
 
+// ListActiveRefreshTokens retrieves unrevoked, unexpired refresh tokens matching a filter
+func (r *OAuth2TokenRepository) ListActiveRefreshTokens(ctx context.Context, filter TokenFilter, limit, offset int) ([]*models.OAuth2RefreshToken, int64, error) {
+	var tokens []*models.OAuth2RefreshToken
+	var total int64
+
+	query := filter.apply(r.db.WithContext(ctx).
+		Model(&models.OAuth2RefreshToken{}).
+		Where("revoked = ? AND expires_at > ?", false, time.Now()))
+
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	query = query.Order("created_at DESC")
+	if limit > 0 {
+		query = query.Limit(limit).Offset(offset)
+	}
+
+	err := query.Find(&tokens).Error
+	return tokens, total, err
+}
+
+// RevokeRefreshTokens marks all refresh tokens matching a filter as revoked
+func (r *OAuth2TokenRepository) RevokeRefreshTokens(ctx context.Context, filter TokenFilter) (int64, error) {
+	result := filter.apply(r.db.WithContext(ctx).
+		Model(&models.OAuth2RefreshToken{}).
+		Where("revoked = ?", false)).
+		Update("revoked", true)
+	return result.RowsAffected, result.Error
+}
+
 // RevokeAllUserTokens revokes all refresh tokens for a user
 func (r *OAuth2TokenRepository) RevokeAllUserTokens(ctx context.Context, userID string) error {
 	return r.db.WithContext(ctx).
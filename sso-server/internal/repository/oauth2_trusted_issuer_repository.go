@@ -0,0 +1,76 @@
+package repository
+
+import (
+	"context"
+	"errors"
+
+	"github.com/sso-project/sso-server/internal/models"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrTrustedIssuerNotFound = errors.New("trusted issuer not found")
+)
+
+// OAuth2TrustedIssuerRepository handles trusted issuer persistence
+type OAuth2TrustedIssuerRepository struct {
+	db *gorm.DB
+}
+
+// NewOAuth2TrustedIssuerRepository creates a new OAuth2TrustedIssuerRepository
+func NewOAuth2TrustedIssuerRepository(db *gorm.DB) *OAuth2TrustedIssuerRepository {
+	return &OAuth2TrustedIssuerRepository{db: db}
+}
+
+// Create creates a new trusted issuer
+func (r *OAuth2TrustedIssuerRepository) Create(ctx context.Context, issuer *models.OAuth2TrustedIssuer) error {
+	return r.db.WithContext(ctx).Create(issuer).Error
+}
+
+// GetByID retrieves a trusted issuer by ID
+func (r *OAuth2TrustedIssuerRepository) GetByID(ctx context.Context, id string) (*models.OAuth2TrustedIssuer, error) {
+	return r.first(ctx, "id = ?", id)
+}
+
+// GetByIssuer retrieves a trusted issuer by its iss value
+func (r *OAuth2TrustedIssuerRepository) GetByIssuer(ctx context.Context, issuer string) (*models.OAuth2TrustedIssuer, error) {
+	return r.first(ctx, "issuer = ?", issuer)
+}
+
+func (r *OAuth2TrustedIssuerRepository) first(ctx context.Context, query string, args ...interface{}) (*models.OAuth2TrustedIssuer, error) {
+	var issuer models.OAuth2TrustedIssuer
+	err := r.db.WithContext(ctx).Where(query, args...).First(&issuer).Error
+
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrTrustedIssuerNotFound
+		}
+		return nil, err
+	}
+
+	return &issuer, nil
+}
+
+// Update updates a trusted issuer
+func (r *OAuth2TrustedIssuerRepository) Update(ctx context.Context, issuer *models.OAuth2TrustedIssuer) error {
+	return r.db.WithContext(ctx).Save(issuer).Error
+}
+
+// Delete removes a trusted issuer
+func (r *OAuth2TrustedIssuerRepository) Delete(ctx context.Context, id string) error {
+	result := r.db.WithContext(ctx).Delete(&models.OAuth2TrustedIssuer{}, "id = ?", id)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrTrustedIssuerNotFound
+	}
+	return nil
+}
+
+// GetAll retrieves all trusted issuers
+func (r *OAuth2TrustedIssuerRepository) GetAll(ctx context.Context) ([]*models.OAuth2TrustedIssuer, error) {
+	var issuers []*models.OAuth2TrustedIssuer
+	err := r.db.WithContext(ctx).Order("name ASC").Find(&issuers).Error
+	return issuers, err
+}
@@ -0,0 +1,83 @@
+package repository
+
+import (
+	"context"
+	"errors"
+
+	"github.com/sso-project/sso-server/internal/models"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrOIDCConnectionNotFound = errors.New("oidc connection not found")
+)
+
+// OIDCConnectionRepository handles upstream OIDC connection persistence
+type OIDCConnectionRepository struct {
+	db *gorm.DB
+}
+
+// NewOIDCConnectionRepository creates a new OIDCConnectionRepository
+func NewOIDCConnectionRepository(db *gorm.DB) *OIDCConnectionRepository {
+	return &OIDCConnectionRepository{db: db}
+}
+
+// Create creates a new connection
+func (r *OIDCConnectionRepository) Create(ctx context.Context, conn *models.OIDCConnection) error {
+	return r.db.WithContext(ctx).Create(conn).Error
+}
+
+// GetByID retrieves a connection by ID
+func (r *OIDCConnectionRepository) GetByID(ctx context.Context, id string) (*models.OIDCConnection, error) {
+	return r.first(ctx, "id = ?", id)
+}
+
+// GetBySlug retrieves a connection by the slug used in its login URLs
+func (r *OIDCConnectionRepository) GetBySlug(ctx context.Context, slug string) (*models.OIDCConnection, error) {
+	return r.first(ctx, "slug = ?", slug)
+}
+
+func (r *OIDCConnectionRepository) first(ctx context.Context, query string, args ...interface{}) (*models.OIDCConnection, error) {
+	var conn models.OIDCConnection
+	err := r.db.WithContext(ctx).Where(query, args...).First(&conn).Error
+
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrOIDCConnectionNotFound
+		}
+		return nil, err
+	}
+
+	return &conn, nil
+}
+
+// Update updates a connection
+func (r *OIDCConnectionRepository) Update(ctx context.Context, conn *models.OIDCConnection) error {
+	return r.db.WithContext(ctx).Save(conn).Error
+}
+
+// Delete removes a connection
+func (r *OIDCConnectionRepository) Delete(ctx context.Context, id string) error {
+	result := r.db.WithContext(ctx).Delete(&models.OIDCConnection{}, "id = ?", id)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrOIDCConnectionNotFound
+	}
+	return nil
+}
+
+// GetAll retrieves all connections
+func (r *OIDCConnectionRepository) GetAll(ctx context.Context) ([]*models.OIDCConnection, error) {
+	var conns []*models.OIDCConnection
+	err := r.db.WithContext(ctx).Order("name ASC").Find(&conns).Error
+	return conns, err
+}
+
+// GetActive retrieves the connections offered on the login page
+func (r *OIDCConnectionRepository) GetActive(ctx context.Context) ([]*models.OIDCConnection, error) {
+	var conns []*models.OIDCConnection
+	err := r.db.WithContext(ctx).Where("is_active = ?", true).Order("name ASC").Find(&conns).Error
+	return conns, err
+}
@@ -0,0 +1,150 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/sso-project/sso-server/internal/models"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrOTPFactorNotFound = errors.New("otp factor not found")
+	ErrOTPCodeNotFound   = errors.New("otp code not found")
+)
+
+// OTPRepository handles persistence of one-time code factors and their codes
+type OTPRepository struct {
+	db *gorm.DB
+}
+
+// NewOTPRepository creates a new OTPRepository
+func NewOTPRepository(db *gorm.DB) *OTPRepository {
+	return &OTPRepository{db: db}
+}
+
+// GetFactor retrieves the factor of a user for a method
+func (r *OTPRepository) GetFactor(ctx context.Context, userID, method string) (*models.OTPFactor, error) {
+	var factor models.OTPFactor
+	err := r.db.WithContext(ctx).Where("user_id = ? AND method = ?", userID, method).First(&factor).Error
+
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrOTPFactorNotFound
+		}
+		return nil, err
+	}
+
+	return &factor, nil
+}
+
+// GetFactorsByUserID retrieves the factors of a user, oldest first
+func (r *OTPRepository) GetFactorsByUserID(ctx context.Context, userID string) ([]*models.OTPFactor, error) {
+	var factors []*models.OTPFactor
+	err := r.db.WithContext(ctx).Where("user_id = ?", userID).Order("created_at ASC").Find(&factors).Error
+	return factors, err
+}
+
+// SaveFactor creates or updates a factor
+func (r *OTPRepository) SaveFactor(ctx context.Context, factor *models.OTPFactor) error {
+	return r.db.WithContext(ctx).Save(factor).Error
+}
+
+// DeleteFactor removes the factor of a user for a method, with its codes
+func (r *OTPRepository) DeleteFactor(ctx context.Context, userID, method string) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var factor models.OTPFactor
+		err := tx.Where("user_id = ? AND method = ?", userID, method).First(&factor).Error
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrOTPFactorNotFound
+			}
+			return err
+		}
+
+		if err := tx.Where("factor_id = ?", factor.ID).Delete(&models.OTPCode{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&factor).Error
+	})
+}
+
+// DeleteFactorsByUserID removes all factors of a user, with their codes, and
+// returns how many there were
+func (r *OTPRepository) DeleteFactorsByUserID(ctx context.Context, userID string) (int64, error) {
+	var deleted int64
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		factorIDs := tx.Model(&models.OTPFactor{}).Select("id").Where("user_id = ?", userID)
+		if err := tx.Where("factor_id IN (?)", factorIDs).Delete(&models.OTPCode{}).Error; err != nil {
+			return err
+		}
+
+		result := tx.Where("user_id = ?", userID).Delete(&models.OTPFactor{})
+		deleted = result.RowsAffected
+		return result.Error
+	})
+	return deleted, err
+}
+
+// CreateCode stores a new code. It replaces the earlier codes of the factor for
+// the same purpose, so only the latest code sent works, and removes expired
+// codes on the way.
+func (r *OTPRepository) CreateCode(ctx context.Context, code *models.OTPCode) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("expires_at < ?", time.Now()).Delete(&models.OTPCode{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("factor_id = ? AND purpose = ?", code.FactorID, code.Purpose).Delete(&models.OTPCode{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(code).Error
+	})
+}
+
+// GetLatestCode retrieves the latest code of a factor for a purpose, expired or not
+func (r *OTPRepository) GetLatestCode(ctx context.Context, factorID, purpose string) (*models.OTPCode, error) {
+	var code models.OTPCode
+	err := r.db.WithContext(ctx).
+		Where("factor_id = ? AND purpose = ?", factorID, purpose).
+		Order("created_at DESC").
+		First(&code).Error
+
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrOTPCodeNotFound
+		}
+		return nil, err
+	}
+
+	return &code, nil
+}
+
+// UseAttempt counts a guess at a code before it is checked, so that concurrent
+// guesses cannot exceed maxAttempts. It returns ErrOTPCodeNotFound if the code
+// does not exist, has expired or has no attempts left.
+func (r *OTPRepository) UseAttempt(ctx context.Context, id string, maxAttempts int) error {
+	result := r.db.WithContext(ctx).Model(&models.OTPCode{}).
+		Where("id = ? AND attempts < ? AND expires_at > ?", id, maxAttempts, time.Now()).
+		Update("attempts", gorm.Expr("attempts + 1"))
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrOTPCodeNotFound
+	}
+	return nil
+}
+
+// DeleteCode removes a code. It returns ErrOTPCodeNotFound if the code was
+// already removed, such as by a concurrent request using it.
+func (r *OTPRepository) DeleteCode(ctx context.Context, id string) error {
+	result := r.db.WithContext(ctx).Where("id = ?", id).Delete(&models.OTPCode{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrOTPCodeNotFound
+	}
+	return nil
+}
@@ -18,8 +18,10 @@ func NewPasswordResetTokenRepository(db *database.DB) *PasswordResetTokenReposit
 	return &PasswordResetTokenRepository{db: db}
 }
 
-// Create creates a new password reset token
+// Create creates a new password reset token. Only a hash of the token is
+// stored; token.Token is replaced by it.
 func (r *PasswordResetTokenRepository) Create(ctx context.Context, token *models.PasswordResetToken) error {
+	token.Token = HashToken(token.Token)
 	return r.db.WithContext(ctx).Create(token).Error
 }
 
@@ -27,7 +29,7 @@ func (r *PasswordResetTokenRepository) Create(ctx context.Context, token *models
 func (r *PasswordResetTokenRepository) FindByToken(ctx context.Context, token string) (*models.PasswordResetToken, error) {
 	var resetToken models.PasswordResetToken
 	err := r.db.WithContext(ctx).
-		Where("token = ? AND used = ? AND expires_at > ?", token, false, time.Now()).
+		Where("token = ? AND used = ? AND expires_at > ?", HashToken(token), false, time.Now()).
 		First(&resetToken).Error
 	if err != nil {
 		return nil, err
@@ -52,7 +54,7 @@ func (r *PasswordResetTokenRepository) FindByEmail(ctx context.Context, email st
 func (r *PasswordResetTokenRepository) MarkAsUsed(ctx context.Context, token string) error {
 	return r.db.WithContext(ctx).
 		Model(&models.PasswordResetToken{}).
-		Where("token = ?", token).
+		Where("token = ?", HashToken(token)).
 		Updates(map[string]interface{}{
 			"used":    true,
 			"used_at": time.Now(),
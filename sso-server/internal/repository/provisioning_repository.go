@@ -0,0 +1,311 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sso-project/sso-server/internal/models"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrProvisioningConnectorNotFound = errors.New("provisioning connector not found")
+	ErrProvisioningJobNotFound       = errors.New("provisioning job not found")
+	ErrProvisioningStateNotFound     = errors.New("provisioning state not found")
+)
+
+// Provisioning job statuses
+const (
+	ProvisioningJobPending = "pending"
+	ProvisioningJobRunning = "running"
+	ProvisioningJobFailed  = "failed"
+)
+
+// ProvisioningRepository handles outbound provisioning connectors, their job
+// queue and the last known state of each user in each application
+type ProvisioningRepository struct {
+	db *gorm.DB
+}
+
+// NewProvisioningRepository creates a new ProvisioningRepository
+func NewProvisioningRepository(db *gorm.DB) *ProvisioningRepository {
+	return &ProvisioningRepository{db: db}
+}
+
+// ==================== Connectors ====================
+
+// CreateConnector creates a new connector
+func (r *ProvisioningRepository) CreateConnector(ctx context.Context, connector *models.ProvisioningConnector) error {
+	return r.db.WithContext(ctx).Create(connector).Error
+}
+
+// GetConnector retrieves a connector by ID
+func (r *ProvisioningRepository) GetConnector(ctx context.Context, id string) (*models.ProvisioningConnector, error) {
+	return r.firstConnector(ctx, "id = ?", id)
+}
+
+// GetConnectorByClientID retrieves the connector of an OAuth2 client
+func (r *ProvisioningRepository) GetConnectorByClientID(ctx context.Context, clientID string) (*models.ProvisioningConnector, error) {
+	return r.firstConnector(ctx, "client_id = ?", clientID)
+}
+
+func (r *ProvisioningRepository) firstConnector(ctx context.Context, query string, args ...interface{}) (*models.ProvisioningConnector, error) {
+	var connector models.ProvisioningConnector
+	err := r.db.WithContext(ctx).Where(query, args...).First(&connector).Error
+
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrProvisioningConnectorNotFound
+		}
+		return nil, err
+	}
+
+	return &connector, nil
+}
+
+// GetConnectors retrieves every connector
+func (r *ProvisioningRepository) GetConnectors(ctx context.Context) ([]*models.ProvisioningConnector, error) {
+	var connectors []*models.ProvisioningConnector
+	err := r.db.WithContext(ctx).Order("name ASC").Find(&connectors).Error
+	return connectors, err
+}
+
+// GetActiveConnectors retrieves the connectors that push changes
+func (r *ProvisioningRepository) GetActiveConnectors(ctx context.Context) ([]*models.ProvisioningConnector, error) {
+	var connectors []*models.ProvisioningConnector
+	err := r.db.WithContext(ctx).Where("is_active = ?", true).Order("name ASC").Find(&connectors).Error
+	return connectors, err
+}
+
+// UpdateConnector updates a connector
+func (r *ProvisioningRepository) UpdateConnector(ctx context.Context, connector *models.ProvisioningConnector) error {
+	return r.db.WithContext(ctx).Save(connector).Error
+}
+
+// DeleteConnector deletes a connector with its jobs and states
+func (r *ProvisioningRepository) DeleteConnector(ctx context.Context, id string) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("connector_id = ?", id).Delete(&models.ProvisioningJob{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("connector_id = ?", id).Delete(&models.ProvisioningState{}).Error; err != nil {
+			return err
+		}
+		result := tx.Delete(&models.ProvisioningConnector{}, "id = ?", id)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrProvisioningConnectorNotFound
+		}
+		return nil
+	})
+}
+
+// ==================== Jobs ====================
+
+// Enqueue queues an update of a user in an application. A job that is still
+// waiting already covers the change, since jobs read the user when they run.
+func (r *ProvisioningRepository) Enqueue(ctx context.Context, connectorID, userID, reason string) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var waiting int64
+		err := tx.Model(&models.ProvisioningJob{}).
+			Where("connector_id = ? AND user_id = ? AND status = ?", connectorID, userID, ProvisioningJobPending).
+			Count(&waiting).Error
+		if err != nil || waiting > 0 {
+			return err
+		}
+
+		now := time.Now()
+		return tx.Create(&models.ProvisioningJob{
+			ID:            uuid.New().String(),
+			ConnectorID:   connectorID,
+			UserID:        userID,
+			Reason:        reason,
+			Status:        ProvisioningJobPending,
+			NextAttemptAt: now,
+			CreatedAt:     now,
+			UpdatedAt:     now,
+		}).Error
+	})
+}
+
+// ClaimDue marks up to limit due jobs as running and returns them. A job is
+// only returned to the caller whose update moved it out of pending, so
+// several workers can share the queue.
+func (r *ProvisioningRepository) ClaimDue(ctx context.Context, now time.Time, limit int) ([]*models.ProvisioningJob, error) {
+	var due []*models.ProvisioningJob
+	err := r.db.WithContext(ctx).
+		Where("status = ? AND next_attempt_at <= ?", ProvisioningJobPending, now).
+		Order("next_attempt_at ASC").
+		Limit(limit).
+		Find(&due).Error
+	if err != nil {
+		return nil, err
+	}
+
+	claimed := make([]*models.ProvisioningJob, 0, len(due))
+	for _, job := range due {
+		result := r.db.WithContext(ctx).Model(&models.ProvisioningJob{}).
+			Where("id = ? AND status = ?", job.ID, ProvisioningJobPending).
+			Updates(map[string]interface{}{"status": ProvisioningJobRunning, "updated_at": now})
+		if result.Error != nil {
+			return claimed, result.Error
+		}
+		if result.RowsAffected == 1 {
+			job.Status = ProvisioningJobRunning
+			job.UpdatedAt = now
+			claimed = append(claimed, job)
+		}
+	}
+	return claimed, nil
+}
+
+// ReleaseStale puts jobs left running since before the given time, by a worker
+// that stopped, back in the queue
+func (r *ProvisioningRepository) ReleaseStale(ctx context.Context, before time.Time) (int64, error) {
+	result := r.db.WithContext(ctx).Model(&models.ProvisioningJob{}).
+		Where("status = ? AND updated_at < ?", ProvisioningJobRunning, before).
+		Updates(map[string]interface{}{"status": ProvisioningJobPending, "updated_at": time.Now()})
+	return result.RowsAffected, result.Error
+}
+
+// GetJob retrieves a job by ID
+func (r *ProvisioningRepository) GetJob(ctx context.Context, id string) (*models.ProvisioningJob, error) {
+	var job models.ProvisioningJob
+	err := r.db.WithContext(ctx).Where("id = ?", id).First(&job).Error
+
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrProvisioningJobNotFound
+		}
+		return nil, err
+	}
+
+	return &job, nil
+}
+
+// GetJobs retrieves the queued jobs of a connector, optionally with one status
+func (r *ProvisioningRepository) GetJobs(ctx context.Context, connectorID, status string, offset, limit int) ([]*models.ProvisioningJob, int64, error) {
+	query := r.db.WithContext(ctx).Model(&models.ProvisioningJob{}).Where("connector_id = ?", connectorID)
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var jobs []*models.ProvisioningJob
+	err := query.Order("created_at DESC").Offset(offset).Limit(limit).Find(&jobs).Error
+	return jobs, total, err
+}
+
+// CountJobs counts the jobs of a connector by status
+func (r *ProvisioningRepository) CountJobs(ctx context.Context, connectorID string) (map[string]int64, error) {
+	var rows []struct {
+		Status string
+		Count  int64
+	}
+	err := r.db.WithContext(ctx).Model(&models.ProvisioningJob{}).
+		Select("status, COUNT(*) AS count").
+		Where("connector_id = ?", connectorID).
+		Group("status").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	counts := make(map[string]int64, len(rows))
+	for _, row := range rows {
+		counts[row.Status] = row.Count
+	}
+	return counts, nil
+}
+
+// UpdateJob updates a job
+func (r *ProvisioningRepository) UpdateJob(ctx context.Context, job *models.ProvisioningJob) error {
+	return r.db.WithContext(ctx).Save(job).Error
+}
+
+// DeleteJob removes a finished job from the queue
+func (r *ProvisioningRepository) DeleteJob(ctx context.Context, id string) error {
+	return r.db.WithContext(ctx).Delete(&models.ProvisioningJob{}, "id = ?", id).Error
+}
+
+// ==================== States ====================
+
+// GetState retrieves the state of a user in an application
+func (r *ProvisioningRepository) GetState(ctx context.Context, connectorID, userID string) (*models.ProvisioningState, error) {
+	var state models.ProvisioningState
+	err := r.db.WithContext(ctx).Where("connector_id = ? AND user_id = ?", connectorID, userID).First(&state).Error
+
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrProvisioningStateNotFound
+		}
+		return nil, err
+	}
+
+	return &state, nil
+}
+
+// SaveState creates or replaces the state of a user in an application
+func (r *ProvisioningRepository) SaveState(ctx context.Context, state *models.ProvisioningState) error {
+	return r.db.WithContext(ctx).Save(state).Error
+}
+
+// GetStateUserIDs returns the users that have a state in an application
+func (r *ProvisioningRepository) GetStateUserIDs(ctx context.Context, connectorID string) ([]string, error) {
+	var userIDs []string
+	err := r.db.WithContext(ctx).Model(&models.ProvisioningState{}).
+		Where("connector_id = ?", connectorID).
+		Pluck("user_id", &userIDs).Error
+	return userIDs, err
+}
+
+// GetStatesByUser retrieves the state of a user in every application
+func (r *ProvisioningRepository) GetStatesByUser(ctx context.Context, userID string) ([]*models.ProvisioningState, error) {
+	var states []*models.ProvisioningState
+	err := r.db.WithContext(ctx).Where("user_id = ?", userID).Find(&states).Error
+	return states, err
+}
+
+// CountStates counts the users of a connector by state
+func (r *ProvisioningRepository) CountStates(ctx context.Context, connectorID string) (map[string]int64, error) {
+	var rows []struct {
+		Status string
+		Count  int64
+	}
+	err := r.db.WithContext(ctx).Model(&models.ProvisioningState{}).
+		Select("status, COUNT(*) AS count").
+		Where("connector_id = ?", connectorID).
+		Group("status").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	counts := make(map[string]int64, len(rows))
+	for _, row := range rows {
+		counts[row.Status] = row.Count
+	}
+	return counts, nil
+}
+
+// GetLastError returns the most recent failed state of a connector, if any
+func (r *ProvisioningRepository) GetLastError(ctx context.Context, connectorID string) (*models.ProvisioningState, error) {
+	var states []*models.ProvisioningState
+	err := r.db.WithContext(ctx).
+		Where("connector_id = ? AND last_error <> ''", connectorID).
+		Order("updated_at DESC").
+		Limit(1).
+		Find(&states).Error
+	if err != nil || len(states) == 0 {
+		return nil, err
+	}
+	return states[0], nil
+}
@@ -135,3 +135,44 @@ func (r *RoleRepository) RemovePermission(ctx context.Context, roleID, permissio
 func (r *RoleRepository) GetDB() *gorm.DB {
 	return r.db.DB
 }
+
+// ListWhere retrieves roles matching a condition with pagination, ordered by name.
+// The condition is a SQL fragment with ? placeholders; an empty one matches all roles.
+func (r *RoleRepository) ListWhere(ctx context.Context, condition string, args []interface{}, offset, limit int) ([]*models.Role, int64, error) {
+	var roles []*models.Role
+	var total int64
+
+	query := r.db.WithContext(ctx).Model(&models.Role{})
+	if condition != "" {
+		query = query.Where(condition, args...)
+	}
+
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	if limit == 0 {
+		return roles, total, nil
+	}
+
+	result := query.
+		Order("name ASC").
+		Offset(offset).
+		Limit(limit).
+		Find(&roles)
+	if result.Error != nil {
+		return nil, 0, result.Error
+	}
+
+	return roles, total, nil
+}
+
+// GetMembers retrieves the users a role is assigned to
+func (r *RoleRepository) GetMembers(ctx context.Context, roleID string) ([]*models.User, error) {
+	var users []*models.User
+	err := r.db.WithContext(ctx).
+		Joins("JOIN user_roles ON user_roles.user_id = users.id").
+		Where("user_roles.role_id = ?", roleID).
+		Order("users.email ASC").
+		Find(&users).Error
+	return users, err
+}
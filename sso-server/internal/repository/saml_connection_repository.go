@@ -0,0 +1,105 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/sso-project/sso-server/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrSAMLConnectionNotFound = errors.New("saml connection not found")
+	ErrSAMLAssertionReplayed  = errors.New("saml assertion has already been used")
+)
+
+// SAMLConnectionRepository handles upstream SAML connection persistence
+type SAMLConnectionRepository struct {
+	db *gorm.DB
+}
+
+// NewSAMLConnectionRepository creates a new SAMLConnectionRepository
+func NewSAMLConnectionRepository(db *gorm.DB) *SAMLConnectionRepository {
+	return &SAMLConnectionRepository{db: db}
+}
+
+// Create creates a new connection
+func (r *SAMLConnectionRepository) Create(ctx context.Context, conn *models.SAMLConnection) error {
+	return r.db.WithContext(ctx).Create(conn).Error
+}
+
+// GetByID retrieves a connection by ID
+func (r *SAMLConnectionRepository) GetByID(ctx context.Context, id string) (*models.SAMLConnection, error) {
+	return r.first(ctx, "id = ?", id)
+}
+
+// GetBySlug retrieves a connection by the slug used in its login URLs
+func (r *SAMLConnectionRepository) GetBySlug(ctx context.Context, slug string) (*models.SAMLConnection, error) {
+	return r.first(ctx, "slug = ?", slug)
+}
+
+func (r *SAMLConnectionRepository) first(ctx context.Context, query string, args ...interface{}) (*models.SAMLConnection, error) {
+	var conn models.SAMLConnection
+	err := r.db.WithContext(ctx).Where(query, args...).First(&conn).Error
+
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrSAMLConnectionNotFound
+		}
+		return nil, err
+	}
+
+	return &conn, nil
+}
+
+// Update updates a connection
+func (r *SAMLConnectionRepository) Update(ctx context.Context, conn *models.SAMLConnection) error {
+	return r.db.WithContext(ctx).Save(conn).Error
+}
+
+// Delete removes a connection
+func (r *SAMLConnectionRepository) Delete(ctx context.Context, id string) error {
+	result := r.db.WithContext(ctx).Delete(&models.SAMLConnection{}, "id = ?", id)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrSAMLConnectionNotFound
+	}
+	return nil
+}
+
+// GetAll retrieves all connections
+func (r *SAMLConnectionRepository) GetAll(ctx context.Context) ([]*models.SAMLConnection, error) {
+	var conns []*models.SAMLConnection
+	err := r.db.WithContext(ctx).Order("name ASC").Find(&conns).Error
+	return conns, err
+}
+
+// GetActive retrieves the connections offered on the login page
+func (r *SAMLConnectionRepository) GetActive(ctx context.Context) ([]*models.SAMLConnection, error) {
+	var conns []*models.SAMLConnection
+	err := r.db.WithContext(ctx).Where("is_active = ?", true).Order("name ASC").Find(&conns).Error
+	return conns, err
+}
+
+// ConsumeAssertionID records an accepted assertion ID until expiresAt. It returns
+// ErrSAMLAssertionReplayed if the ID was already recorded and has not expired.
+func (r *SAMLConnectionRepository) ConsumeAssertionID(ctx context.Context, id string, expiresAt time.Time) error {
+	db := r.db.WithContext(ctx)
+
+	if err := db.Where("expires_at < ?", time.Now()).Delete(&models.SAMLAssertionID{}).Error; err != nil {
+		return err
+	}
+
+	result := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&models.SAMLAssertionID{ID: id, ExpiresAt: expiresAt})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrSAMLAssertionReplayed
+	}
+	return nil
+}
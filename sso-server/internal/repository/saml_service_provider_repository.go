@@ -0,0 +1,76 @@
+package repository
+
+import (
+	"context"
+	"errors"
+
+	"github.com/sso-project/sso-server/internal/models"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrSAMLServiceProviderNotFound = errors.New("saml service provider not found")
+)
+
+// SAMLServiceProviderRepository handles SAML service provider persistence
+type SAMLServiceProviderRepository struct {
+	db *gorm.DB
+}
+
+// NewSAMLServiceProviderRepository creates a new SAMLServiceProviderRepository
+func NewSAMLServiceProviderRepository(db *gorm.DB) *SAMLServiceProviderRepository {
+	return &SAMLServiceProviderRepository{db: db}
+}
+
+// Create creates a new service provider
+func (r *SAMLServiceProviderRepository) Create(ctx context.Context, sp *models.SAMLServiceProvider) error {
+	return r.db.WithContext(ctx).Create(sp).Error
+}
+
+// GetByID retrieves a service provider by ID
+func (r *SAMLServiceProviderRepository) GetByID(ctx context.Context, id string) (*models.SAMLServiceProvider, error) {
+	return r.first(ctx, "id = ?", id)
+}
+
+// GetByEntityID retrieves a service provider by the entity ID it sends as issuer
+func (r *SAMLServiceProviderRepository) GetByEntityID(ctx context.Context, entityID string) (*models.SAMLServiceProvider, error) {
+	return r.first(ctx, "entity_id = ?", entityID)
+}
+
+func (r *SAMLServiceProviderRepository) first(ctx context.Context, query string, args ...interface{}) (*models.SAMLServiceProvider, error) {
+	var sp models.SAMLServiceProvider
+	err := r.db.WithContext(ctx).Where(query, args...).First(&sp).Error
+
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrSAMLServiceProviderNotFound
+		}
+		return nil, err
+	}
+
+	return &sp, nil
+}
+
+// Update updates a service provider
+func (r *SAMLServiceProviderRepository) Update(ctx context.Context, sp *models.SAMLServiceProvider) error {
+	return r.db.WithContext(ctx).Save(sp).Error
+}
+
+// Delete removes a service provider
+func (r *SAMLServiceProviderRepository) Delete(ctx context.Context, id string) error {
+	result := r.db.WithContext(ctx).Delete(&models.SAMLServiceProvider{}, "id = ?", id)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrSAMLServiceProviderNotFound
+	}
+	return nil
+}
+
+// GetAll retrieves all service providers
+func (r *SAMLServiceProviderRepository) GetAll(ctx context.Context) ([]*models.SAMLServiceProvider, error) {
+	var sps []*models.SAMLServiceProvider
+	err := r.db.WithContext(ctx).Order("name ASC").Find(&sps).Error
+	return sps, err
+}
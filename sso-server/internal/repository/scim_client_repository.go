@@ -0,0 +1,155 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sso-project/sso-server/internal/models"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrSCIMClientNotFound  = errors.New("scim client not found")
+	ErrSCIMExternalIDTaken = errors.New("externalId is already used by another resource")
+)
+
+// SCIMClientRepository handles SCIM clients and the external IDs they assign
+type SCIMClientRepository struct {
+	db *gorm.DB
+}
+
+// NewSCIMClientRepository creates a new SCIMClientRepository
+func NewSCIMClientRepository(db *gorm.DB) *SCIMClientRepository {
+	return &SCIMClientRepository{db: db}
+}
+
+// Create creates a new SCIM client
+func (r *SCIMClientRepository) Create(ctx context.Context, client *models.SCIMClient) error {
+	return r.db.WithContext(ctx).Create(client).Error
+}
+
+// GetByID retrieves a SCIM client by ID
+func (r *SCIMClientRepository) GetByID(ctx context.Context, id string) (*models.SCIMClient, error) {
+	return r.first(ctx, "id = ?", id)
+}
+
+// GetByTokenHash retrieves the SCIM client a bearer token was issued to
+func (r *SCIMClientRepository) GetByTokenHash(ctx context.Context, tokenHash string) (*models.SCIMClient, error) {
+	return r.first(ctx, "token_hash = ?", tokenHash)
+}
+
+func (r *SCIMClientRepository) first(ctx context.Context, query string, args ...interface{}) (*models.SCIMClient, error) {
+	var client models.SCIMClient
+	err := r.db.WithContext(ctx).Where(query, args...).First(&client).Error
+
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrSCIMClientNotFound
+		}
+		return nil, err
+	}
+
+	return &client, nil
+}
+
+// GetAll retrieves every SCIM client
+func (r *SCIMClientRepository) GetAll(ctx context.Context) ([]*models.SCIMClient, error) {
+	var clients []*models.SCIMClient
+	err := r.db.WithContext(ctx).Order("name ASC").Find(&clients).Error
+	return clients, err
+}
+
+// Update updates a SCIM client
+func (r *SCIMClientRepository) Update(ctx context.Context, client *models.SCIMClient) error {
+	return r.db.WithContext(ctx).Save(client).Error
+}
+
+// Delete deletes a SCIM client and the external IDs it assigned
+func (r *SCIMClientRepository) Delete(ctx context.Context, id string) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("client_id = ?", id).Delete(&models.SCIMExternalID{}).Error; err != nil {
+			return err
+		}
+		result := tx.Delete(&models.SCIMClient{}, "id = ?", id)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrSCIMClientNotFound
+		}
+		return nil
+	})
+}
+
+// RecordUse stores when the client last called the API
+func (r *SCIMClientRepository) RecordUse(ctx context.Context, id string, at time.Time) error {
+	return r.db.WithContext(ctx).Model(&models.SCIMClient{}).
+		Where("id = ?", id).
+		UpdateColumn("last_used_at", at).
+		Error
+}
+
+// SetExternalID records the externalId a client uses for a resource; an empty
+// externalID removes it
+func (r *SCIMClientRepository) SetExternalID(ctx context.Context, clientID, resourceType, resourceID, externalID string) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if externalID != "" {
+			var taken int64
+			err := tx.Model(&models.SCIMExternalID{}).
+				Where("client_id = ? AND resource_type = ? AND external_id = ? AND resource_id <> ?", clientID, resourceType, externalID, resourceID).
+				Count(&taken).Error
+			if err != nil {
+				return err
+			}
+			if taken > 0 {
+				return ErrSCIMExternalIDTaken
+			}
+		}
+
+		err := tx.Where("client_id = ? AND resource_type = ? AND resource_id = ?", clientID, resourceType, resourceID).
+			Delete(&models.SCIMExternalID{}).Error
+		if err != nil || externalID == "" {
+			return err
+		}
+
+		return tx.Create(&models.SCIMExternalID{
+			ID:           uuid.New().String(),
+			ClientID:     clientID,
+			ResourceType: resourceType,
+			ResourceID:   resourceID,
+			ExternalID:   externalID,
+			CreatedAt:    time.Now(),
+		}).Error
+	})
+}
+
+// GetExternalIDs returns the externalIds a client uses for the given resources,
+// keyed by resource ID
+func (r *SCIMClientRepository) GetExternalIDs(ctx context.Context, clientID, resourceType string, resourceIDs []string) (map[string]string, error) {
+	externalIDs := make(map[string]string, len(resourceIDs))
+	if len(resourceIDs) == 0 {
+		return externalIDs, nil
+	}
+
+	var rows []models.SCIMExternalID
+	err := r.db.WithContext(ctx).
+		Where("client_id = ? AND resource_type = ? AND resource_id IN ?", clientID, resourceType, resourceIDs).
+		Find(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	for _, row := range rows {
+		externalIDs[row.ResourceID] = row.ExternalID
+	}
+	return externalIDs, nil
+}
+
+// DeleteExternalIDs forgets every client's externalId for a deleted resource
+func (r *SCIMClientRepository) DeleteExternalIDs(ctx context.Context, resourceType, resourceID string) error {
+	return r.db.WithContext(ctx).
+		Where("resource_type = ? AND resource_id = ?", resourceType, resourceID).
+		Delete(&models.SCIMExternalID{}).Error
+}
@@ -0,0 +1,114 @@
+package repository
+
+import (
+	"context"
+	"errors"
+
+	"github.com/sso-project/sso-server/internal/models"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrServiceAccountNotFound = errors.New("service account not found")
+)
+
+// ServiceAccountRepository handles service account persistence
+type ServiceAccountRepository struct {
+	db *gorm.DB
+}
+
+// NewServiceAccountRepository creates a new ServiceAccountRepository
+func NewServiceAccountRepository(db *gorm.DB) *ServiceAccountRepository {
+	return &ServiceAccountRepository{db: db}
+}
+
+// Create creates a new service account
+func (r *ServiceAccountRepository) Create(ctx context.Context, account *models.ServiceAccount) error {
+	return r.db.WithContext(ctx).Create(account).Error
+}
+
+// GetByID retrieves a service account with its roles and their permissions
+func (r *ServiceAccountRepository) GetByID(ctx context.Context, id string) (*models.ServiceAccount, error) {
+	return r.first(ctx, "id = ?", id)
+}
+
+// GetByClientID retrieves the service account of an OAuth2 client with its roles and their permissions
+func (r *ServiceAccountRepository) GetByClientID(ctx context.Context, clientID string) (*models.ServiceAccount, error) {
+	return r.first(ctx, "client_id = ?", clientID)
+}
+
+func (r *ServiceAccountRepository) first(ctx context.Context, query string, args ...interface{}) (*models.ServiceAccount, error) {
+	var account models.ServiceAccount
+	err := r.db.WithContext(ctx).
+		Preload("Roles.Permissions").
+		Where(query, args...).
+		First(&account).Error
+
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrServiceAccountNotFound
+		}
+		return nil, err
+	}
+
+	return &account, nil
+}
+
+// Update updates a service account's own fields (not its roles)
+func (r *ServiceAccountRepository) Update(ctx context.Context, account *models.ServiceAccount) error {
+	return r.db.WithContext(ctx).Omit("Roles").Save(account).Error
+}
+
+// Delete removes a service account and its role assignments
+func (r *ServiceAccountRepository) Delete(ctx context.Context, id string) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		account := &models.ServiceAccount{ID: id}
+		if err := tx.Model(account).Association("Roles").Clear(); err != nil {
+			return err
+		}
+
+		result := tx.Delete(account)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrServiceAccountNotFound
+		}
+		return nil
+	})
+}
+
+// List retrieves service accounts with pagination
+func (r *ServiceAccountRepository) List(ctx context.Context, offset, limit int) ([]*models.ServiceAccount, int64, error) {
+	var accounts []*models.ServiceAccount
+	var total int64
+
+	if err := r.db.WithContext(ctx).Model(&models.ServiceAccount{}).Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	err := r.db.WithContext(ctx).
+		Preload("Roles").
+		Order("created_at DESC").
+		Offset(offset).
+		Limit(limit).
+		Find(&accounts).Error
+
+	return accounts, total, err
+}
+
+// AssignRole grants a role to a service account; assigning it twice is a no-op
+func (r *ServiceAccountRepository) AssignRole(ctx context.Context, accountID, roleID string) error {
+	return r.db.WithContext(ctx).
+		Model(&models.ServiceAccount{ID: accountID}).
+		Association("Roles").
+		Append(&models.Role{ID: roleID})
+}
+
+// RemoveRole takes a role away from a service account
+func (r *ServiceAccountRepository) RemoveRole(ctx context.Context, accountID, roleID string) error {
+	return r.db.WithContext(ctx).
+		Model(&models.ServiceAccount{ID: accountID}).
+		Association("Roles").
+		Delete(&models.Role{ID: roleID})
+}
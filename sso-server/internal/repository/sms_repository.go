@@ -0,0 +1,39 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/sso-project/sso-server/internal/models"
+	"gorm.io/gorm"
+)
+
+// SMSRepository records text messages and calls sent to phone numbers
+type SMSRepository struct {
+	db *gorm.DB
+}
+
+// NewSMSRepository creates a new SMSRepository
+func NewSMSRepository(db *gorm.DB) *SMSRepository {
+	return &SMSRepository{db: db}
+}
+
+// CreateDelivery records a delivery, removing those made before keepSince on the way
+func (r *SMSRepository) CreateDelivery(ctx context.Context, delivery *models.SMSDelivery, keepSince time.Time) error {
+	db := r.db.WithContext(ctx)
+
+	if err := db.Where("created_at < ?", keepSince).Delete(&models.SMSDelivery{}).Error; err != nil {
+		return err
+	}
+
+	return db.Create(delivery).Error
+}
+
+// CountSince counts the deliveries to a phone number made after since
+func (r *SMSRepository) CountSince(ctx context.Context, phoneNumber string, since time.Time) (int64, error) {
+	var count int64
+	err := r.db.WithContext(ctx).Model(&models.SMSDelivery{}).
+		Where("phone_number = ? AND created_at > ?", phoneNumber, since).
+		Count(&count).Error
+	return count, err
+}
@@ -0,0 +1,136 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/sso-project/sso-server/internal/models"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrTwoFactorNotFound  = errors.New("two-factor authentication not found")
+	ErrBackupCodesChanged = errors.New("backup codes changed concurrently")
+	ErrTimeStepUsed       = errors.New("time step already used")
+)
+
+// TwoFactorRepository handles two-factor authentication persistence
+type TwoFactorRepository struct {
+	db *gorm.DB
+}
+
+// NewTwoFactorRepository creates a new TwoFactorRepository
+func NewTwoFactorRepository(db *gorm.DB) *TwoFactorRepository {
+	return &TwoFactorRepository{db: db}
+}
+
+// GetByUserID retrieves the 2FA settings of a user
+func (r *TwoFactorRepository) GetByUserID(ctx context.Context, userID string) (*models.TwoFactorAuth, error) {
+	var twoFA models.TwoFactorAuth
+	err := r.db.WithContext(ctx).Where("user_id = ?", userID).First(&twoFA).Error
+
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrTwoFactorNotFound
+		}
+		return nil, err
+	}
+
+	return &twoFA, nil
+}
+
+// Save creates or updates the 2FA settings of a user
+func (r *TwoFactorRepository) Save(ctx context.Context, twoFA *models.TwoFactorAuth) error {
+	return r.db.WithContext(ctx).Save(twoFA).Error
+}
+
+// DeleteByUserID removes the 2FA settings of a user
+func (r *TwoFactorRepository) DeleteByUserID(ctx context.Context, userID string) error {
+	result := r.db.WithContext(ctx).Where("user_id = ?", userID).Delete(&models.TwoFactorAuth{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrTwoFactorNotFound
+	}
+	return nil
+}
+
+// ReplaceBackupCodes swaps the stored backup codes, provided they are still
+// previous. It returns ErrBackupCodesChanged otherwise, so that two requests
+// cannot both spend the same code.
+func (r *TwoFactorRepository) ReplaceBackupCodes(ctx context.Context, id, previous, codes string) error {
+	result := r.db.WithContext(ctx).Model(&models.TwoFactorAuth{}).
+		Where("id = ? AND backup_codes_encrypted = ?", id, previous).
+		Update("backup_codes_encrypted", codes)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrBackupCodesChanged
+	}
+	return nil
+}
+
+// AcceptTimeStep records step as the time step of the last accepted code and
+// clears failed attempts. It returns ErrTimeStepUsed if a code of step or a
+// later one was accepted already, so that each code works only once even with
+// concurrent requests.
+func (r *TwoFactorRepository) AcceptTimeStep(ctx context.Context, id string, step int64) error {
+	result := r.db.WithContext(ctx).Model(&models.TwoFactorAuth{}).
+		Where("id = ? AND last_used_step < ?", id, step).
+		Updates(map[string]interface{}{
+			"last_used_step":  step,
+			"failed_attempts": 0,
+			"locked_until":    nil,
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrTimeStepUsed
+	}
+	return nil
+}
+
+// ResetFailedAttempts clears the failed attempts of 2FA settings
+func (r *TwoFactorRepository) ResetFailedAttempts(ctx context.Context, id string) error {
+	return r.db.WithContext(ctx).Model(&models.TwoFactorAuth{}).
+		Where("id = ?", id).
+		Updates(map[string]interface{}{
+			"failed_attempts": 0,
+			"locked_until":    nil,
+		}).Error
+}
+
+// RecordFailedAttempt counts a wrong code. Reaching maxAttempts locks the
+// settings until lockUntil, which is returned, and starts counting again.
+func (r *TwoFactorRepository) RecordFailedAttempt(ctx context.Context, id string, maxAttempts int, lockUntil time.Time) (*time.Time, error) {
+	var locked *time.Time
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&models.TwoFactorAuth{}).
+			Where("id = ?", id).
+			Update("failed_attempts", gorm.Expr("failed_attempts + 1")).Error
+		if err != nil {
+			return err
+		}
+
+		var twoFA models.TwoFactorAuth
+		if err := tx.Select("failed_attempts").Where("id = ?", id).First(&twoFA).Error; err != nil {
+			return err
+		}
+		if maxAttempts <= 0 || twoFA.FailedAttempts < maxAttempts {
+			return nil
+		}
+
+		locked = &lockUntil
+		return tx.Model(&models.TwoFactorAuth{}).
+			Where("id = ?", id).
+			Updates(map[string]interface{}{
+				"failed_attempts": 0,
+				"locked_until":    lockUntil,
+			}).Error
+	})
+	return locked, err
+}
@@ -0,0 +1,101 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/sso-project/sso-server/internal/models"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrIdentityNotFound = errors.New("identity not found")
+)
+
+// UserIdentityRepository handles links between users and external accounts
+type UserIdentityRepository struct {
+	db *gorm.DB
+}
+
+// NewUserIdentityRepository creates a new UserIdentityRepository
+func NewUserIdentityRepository(db *gorm.DB) *UserIdentityRepository {
+	return &UserIdentityRepository{db: db}
+}
+
+// Create links an external account to a user
+func (r *UserIdentityRepository) Create(ctx context.Context, identity *models.UserIdentity) error {
+	return r.db.WithContext(ctx).Create(identity).Error
+}
+
+// GetByID retrieves an identity by ID
+func (r *UserIdentityRepository) GetByID(ctx context.Context, id string) (*models.UserIdentity, error) {
+	var identity models.UserIdentity
+	err := r.db.WithContext(ctx).Where("id = ?", id).First(&identity).Error
+
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrIdentityNotFound
+		}
+		return nil, err
+	}
+
+	return &identity, nil
+}
+
+// GetByProviderSubject retrieves the identity for an account at a provider
+func (r *UserIdentityRepository) GetByProviderSubject(ctx context.Context, provider, subject string) (*models.UserIdentity, error) {
+	var identity models.UserIdentity
+	err := r.db.WithContext(ctx).
+		Where("provider = ? AND subject = ?", provider, subject).
+		First(&identity).Error
+
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrIdentityNotFound
+		}
+		return nil, err
+	}
+
+	return &identity, nil
+}
+
+// GetByUserID retrieves every external account linked to a user
+func (r *UserIdentityRepository) GetByUserID(ctx context.Context, userID string) ([]*models.UserIdentity, error) {
+	var identities []*models.UserIdentity
+	err := r.db.WithContext(ctx).
+		Where("user_id = ?", userID).
+		Order("created_at ASC").
+		Find(&identities).Error
+	return identities, err
+}
+
+// RecordLogin stores the time and email of a sign-in through the identity
+func (r *UserIdentityRepository) RecordLogin(ctx context.Context, id, email string, at time.Time) error {
+	return r.db.WithContext(ctx).Model(&models.UserIdentity{}).
+		Where("id = ?", id).
+		Updates(map[string]interface{}{"email": email, "last_login_at": at}).
+		Error
+}
+
+// GetByProvider retrieves every account linked through a provider
+func (r *UserIdentityRepository) GetByProvider(ctx context.Context, provider string) ([]*models.UserIdentity, error) {
+	var identities []*models.UserIdentity
+	err := r.db.WithContext(ctx).
+		Where("provider = ?", provider).
+		Order("created_at ASC").
+		Find(&identities).Error
+	return identities, err
+}
+
+// Delete unlinks an external account
+func (r *UserIdentityRepository) Delete(ctx context.Context, id string) error {
+	result := r.db.WithContext(ctx).Delete(&models.UserIdentity{}, "id = ?", id)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrIdentityNotFound
+	}
+	return nil
+}
@@ -3,6 +3,7 @@ package repository
 import (
 	"context"
 	"errors"
+	"slices"
 	"strings"
 	"time"
 
@@ -124,12 +125,12 @@ func (r *UserRepository) List(ctx context.Context, offset, limit int) ([]*models
 	return users, total, nil
 }
 
-// AssignRole assigns a role to a user
+// AssignRole assigns a role to a user; assigning it twice is a no-op
 func (r *UserRepository) AssignRole(ctx context.Context, userID, roleID string) error {
-	return r.db.DB.WithContext(ctx).Exec(
-		"INSERT IGNORE INTO user_roles (user_id, role_id) VALUES (?, ?)",
-		userID, roleID,
-	).Error
+	return r.db.DB.WithContext(ctx).
+		Model(&models.User{ID: userID}).
+		Association("Roles").
+		Append(&models.Role{ID: roleID})
 }
 
 // RemoveRole removes a role from a user
@@ -217,11 +218,27 @@ func (r *UserRepository) CountUsers(ctx context.Context, search string) (int64,
 	return count, nil
 }
 
-// UpdateUserStatus updates user active status
+// UpdateUserStatus updates user active status. The status is then the
+// administrator's, so a directory no longer reactivates the user.
 func (r *UserRepository) UpdateUserStatus(ctx context.Context, userID string, isActive bool) error {
 	return r.db.DB.WithContext(ctx).Model(&models.User{}).
 		Where("id = ?", userID).
-		Update("is_active", isActive).
+		Updates(map[string]interface{}{
+			"is_active":            isActive,
+			"directory_removed_at": nil,
+		}).
+		Error
+}
+
+// DeactivateByDirectory deactivates a user whose directory account is gone, and
+// records when, so that the directory may reactivate them
+func (r *UserRepository) DeactivateByDirectory(ctx context.Context, userID string, at time.Time) error {
+	return r.db.DB.WithContext(ctx).Model(&models.User{}).
+		Where("id = ?", userID).
+		Updates(map[string]interface{}{
+			"is_active":            false,
+			"directory_removed_at": at,
+		}).
 		Error
 }
 
@@ -243,3 +260,172 @@ func (r *UserRepository) UpdateLastLogin(ctx context.Context, id string, lastLog
 		UpdateColumn("last_login_at", lastLoginAt).
 		Error
 }
+
+// UpdatePhone sets the phone number of a user and whether it is verified
+func (r *UserRepository) UpdatePhone(ctx context.Context, id, phoneNumber string, verified bool) error {
+	return r.db.DB.WithContext(ctx).Model(&models.User{}).
+		Where("id = ?", id).
+		Updates(map[string]interface{}{
+			"phone_number":   phoneNumber,
+			"phone_verified": verified,
+		}).
+		Error
+}
+
+// ListWhere retrieves users matching a condition with pagination, oldest first.
+// The condition is a SQL fragment with ? placeholders; an empty one matches all users.
+func (r *UserRepository) ListWhere(ctx context.Context, condition string, args []interface{}, offset, limit int) ([]*models.User, int64, error) {
+	var users []*models.User
+	var total int64
+
+	query := r.db.DB.WithContext(ctx).Model(&models.User{})
+	if condition != "" {
+		query = query.Where(condition, args...)
+	}
+
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	if limit == 0 {
+		return users, total, nil
+	}
+
+	result := query.
+		Preload("Roles").
+		Order("created_at ASC, id ASC").
+		Offset(offset).
+		Limit(limit).
+		Find(&users)
+	if result.Error != nil {
+		return nil, 0, result.Error
+	}
+
+	return users, total, nil
+}
+
+// Merge moves everything that belongs to the source user onto the target user and
+// deletes the source: roles, linked identities, consents and their history, audit
+// references, pairwise subjects, SCIM identifiers and owned clients. The source's
+// tokens, 2FA settings and password history go with it.
+func (r *UserRepository) Merge(ctx context.Context, sourceID, targetID string) error {
+	return r.db.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var sourceRoles, targetRoles []string
+		if err := tx.Table("user_roles").Where("user_id = ?", sourceID).Pluck("role_id", &sourceRoles).Error; err != nil {
+			return err
+		}
+		if err := tx.Table("user_roles").Where("user_id = ?", targetID).Pluck("role_id", &targetRoles).Error; err != nil {
+			return err
+		}
+		for _, roleID := range sourceRoles {
+			if slices.Contains(targetRoles, roleID) {
+				continue
+			}
+			if err := tx.Exec("INSERT INTO user_roles (user_id, role_id) VALUES (?, ?)", targetID, roleID).Error; err != nil {
+				return err
+			}
+		}
+		if err := tx.Exec("DELETE FROM user_roles WHERE user_id = ?", sourceID).Error; err != nil {
+			return err
+		}
+
+		if err := mergeConsents(tx, sourceID, targetID); err != nil {
+			return err
+		}
+
+		// The target keeps its own SCIM identifier where a client knows both users
+		var known []string
+		if err := tx.Model(&models.SCIMExternalID{}).
+			Where("resource_type = ? AND resource_id = ?", "User", targetID).
+			Pluck("client_id", &known).Error; err != nil {
+			return err
+		}
+		if len(known) > 0 {
+			if err := tx.Where("resource_type = ? AND resource_id = ? AND client_id IN ?", "User", sourceID, known).
+				Delete(&models.SCIMExternalID{}).Error; err != nil {
+				return err
+			}
+		}
+		if err := tx.Model(&models.SCIMExternalID{}).
+			Where("resource_type = ? AND resource_id = ?", "User", sourceID).
+			Update("resource_id", targetID).Error; err != nil {
+			return err
+		}
+
+		// Likewise the target keeps its own pairwise subject per sector
+		var sectors []string
+		if err := tx.Model(&models.OAuth2PairwiseSubject{}).Where("user_id = ?", targetID).Pluck("sector", &sectors).Error; err != nil {
+			return err
+		}
+		if len(sectors) > 0 {
+			if err := tx.Where("user_id = ? AND sector IN ?", sourceID, sectors).Delete(&models.OAuth2PairwiseSubject{}).Error; err != nil {
+				return err
+			}
+		}
+
+		moves := []struct {
+			model  interface{}
+			column string
+		}{
+			{&models.UserIdentity{}, "user_id"},
+			{&models.OAuth2ConsentHistory{}, "user_id"},
+			{&models.AuditLog{}, "user_id"},
+			{&models.OAuth2PairwiseSubject{}, "user_id"},
+			{&models.OAuth2Client{}, "owner_user_id"},
+		}
+		for _, move := range moves {
+			if err := tx.Model(move.model).Where(move.column+" = ?", sourceID).Update(move.column, targetID).Error; err != nil {
+				return err
+			}
+		}
+
+		result := tx.Delete(&models.User{}, "id = ?", sourceID)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrNotFound
+		}
+		return nil
+	})
+}
+
+// mergeConsents moves the source user's consents to the target. Where both
+// consented to the same client, the target's consent gains the source's scopes.
+func mergeConsents(tx *gorm.DB, sourceID, targetID string) error {
+	var consents []*models.OAuth2Consent
+	if err := tx.Where("user_id = ?", sourceID).Find(&consents).Error; err != nil {
+		return err
+	}
+
+	for _, consent := range consents {
+		var existing models.OAuth2Consent
+		err := tx.Where("user_id = ? AND client_id = ?", targetID, consent.ClientID).First(&existing).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			if err := tx.Model(consent).Update("user_id", targetID).Error; err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		for _, scope := range consent.Scopes {
+			if !slices.Contains(existing.Scopes, scope) {
+				existing.Scopes = append(existing.Scopes, scope)
+			}
+		}
+		existing.Implied = existing.Implied && consent.Implied
+		if existing.ExpiresAt != nil && (consent.ExpiresAt == nil || consent.ExpiresAt.After(*existing.ExpiresAt)) {
+			existing.ExpiresAt = consent.ExpiresAt
+		}
+		existing.UpdatedAt = time.Now()
+		if err := tx.Save(&existing).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(consent).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
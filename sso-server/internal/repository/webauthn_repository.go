@@ -0,0 +1,139 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/sso-project/sso-server/internal/models"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrWebAuthnCredentialNotFound = errors.New("webauthn credential not found")
+	ErrWebAuthnChallengeNotFound  = errors.New("webauthn challenge not found")
+)
+
+// WebAuthnRepository handles WebAuthn credential and challenge persistence
+type WebAuthnRepository struct {
+	db *gorm.DB
+}
+
+// NewWebAuthnRepository creates a new WebAuthnRepository
+func NewWebAuthnRepository(db *gorm.DB) *WebAuthnRepository {
+	return &WebAuthnRepository{db: db}
+}
+
+// CreateCredential stores a new credential
+func (r *WebAuthnRepository) CreateCredential(ctx context.Context, credential *models.WebAuthnCredential) error {
+	return r.db.WithContext(ctx).Create(credential).Error
+}
+
+// GetCredentialsByUserID retrieves the credentials of a user, oldest first
+func (r *WebAuthnRepository) GetCredentialsByUserID(ctx context.Context, userID string) ([]*models.WebAuthnCredential, error) {
+	var credentials []*models.WebAuthnCredential
+	err := r.db.WithContext(ctx).Where("user_id = ?", userID).Order("created_at ASC").Find(&credentials).Error
+	return credentials, err
+}
+
+// GetCredential retrieves a credential of a user by ID
+func (r *WebAuthnRepository) GetCredential(ctx context.Context, userID, id string) (*models.WebAuthnCredential, error) {
+	var credential models.WebAuthnCredential
+	err := r.db.WithContext(ctx).Where("id = ? AND user_id = ?", id, userID).First(&credential).Error
+
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrWebAuthnCredentialNotFound
+		}
+		return nil, err
+	}
+
+	return &credential, nil
+}
+
+// GetByCredentialID retrieves a credential by the ID the authenticator gave it
+func (r *WebAuthnRepository) GetByCredentialID(ctx context.Context, credentialID string) (*models.WebAuthnCredential, error) {
+	var credential models.WebAuthnCredential
+	err := r.db.WithContext(ctx).Where("credential_id = ?", credentialID).First(&credential).Error
+
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrWebAuthnCredentialNotFound
+		}
+		return nil, err
+	}
+
+	return &credential, nil
+}
+
+// CountByUserID counts the credentials of a user
+func (r *WebAuthnRepository) CountByUserID(ctx context.Context, userID string) (int64, error) {
+	var count int64
+	err := r.db.WithContext(ctx).Model(&models.WebAuthnCredential{}).Where("user_id = ?", userID).Count(&count).Error
+	return count, err
+}
+
+// UpdateCredential updates a credential
+func (r *WebAuthnRepository) UpdateCredential(ctx context.Context, credential *models.WebAuthnCredential) error {
+	return r.db.WithContext(ctx).Save(credential).Error
+}
+
+// DeleteCredential removes a credential of a user
+func (r *WebAuthnRepository) DeleteCredential(ctx context.Context, userID, id string) error {
+	result := r.db.WithContext(ctx).Where("id = ? AND user_id = ?", id, userID).Delete(&models.WebAuthnCredential{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrWebAuthnCredentialNotFound
+	}
+	return nil
+}
+
+// DeleteCredentialsByUserID removes all credentials of a user and returns how
+// many there were
+func (r *WebAuthnRepository) DeleteCredentialsByUserID(ctx context.Context, userID string) (int64, error) {
+	result := r.db.WithContext(ctx).Where("user_id = ?", userID).Delete(&models.WebAuthnCredential{})
+	return result.RowsAffected, result.Error
+}
+
+// CreateChallenge stores a new challenge, removing expired ones on the way
+func (r *WebAuthnRepository) CreateChallenge(ctx context.Context, challenge *models.WebAuthnChallenge) error {
+	db := r.db.WithContext(ctx)
+
+	if err := db.Where("expires_at < ?", time.Now()).Delete(&models.WebAuthnChallenge{}).Error; err != nil {
+		return err
+	}
+
+	return db.Create(challenge).Error
+}
+
+// ConsumeChallenge removes a challenge of the given ceremony and returns it. It
+// returns ErrWebAuthnChallengeNotFound if there is no such challenge, or it has
+// expired or was already used.
+func (r *WebAuthnRepository) ConsumeChallenge(ctx context.Context, id, ceremony string) (*models.WebAuthnChallenge, error) {
+	var challenge models.WebAuthnChallenge
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("id = ? AND ceremony = ? AND expires_at > ?", id, ceremony, time.Now()).First(&challenge).Error
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrWebAuthnChallengeNotFound
+			}
+			return err
+		}
+
+		result := tx.Where("id = ?", id).Delete(&models.WebAuthnChallenge{})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrWebAuthnChallengeNotFound
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &challenge, nil
+}
@@ -27,6 +27,9 @@ type AuthService struct {
 	auditRepo       *repository.AuditLogRepository
 	maxAttempts     int
 	lockoutDuration time.Duration
+	directories     []PasswordDirectory
+	webauthn        *WebAuthnService
+	otp             *OTPService
 }
 
 // NewAuthService creates a new auth service
@@ -46,12 +49,44 @@ func NewAuthService(
 	}
 }
 
+// AddDirectory makes Login check the passwords of the directory's users with the
+// directory instead of users.password_hash. Unknown emails are looked up in
+// directories in the order they were added and get a local user on first sign-in.
+func (s *AuthService) AddDirectory(directory PasswordDirectory) {
+	s.directories = append(s.directories, directory)
+}
+
+// EnableWebAuthn makes registered security keys and passkeys a second factor:
+// users with one must verify it, or their TOTP code, after their password
+func (s *AuthService) EnableWebAuthn(webauthn *WebAuthnService) {
+	s.webauthn = webauthn
+}
+
+// EnableOTP makes the enabled factors of otp, such as email codes, second
+// factors users can choose from along with their other ones
+func (s *AuthService) EnableOTP(otp *OTPService) {
+	s.otp = otp
+}
+
+// Second factors offered to users, in LoginResult.TwoFactorMethods
+const (
+	TwoFactorMethodTOTP     = "totp"
+	TwoFactorMethodWebAuthn = "webauthn"
+	TwoFactorMethodEmail    = "email"
+	TwoFactorMethodSMS      = "sms"
+	TwoFactorMethodVoice    = "voice"
+)
+
+// twoFactorSessionTTL is how long users have to verify their second factor after their password
+const twoFactorSessionTTL = 5 * time.Minute
+
 // LoginResult contains the result of a login attempt
 type LoginResult struct {
 	User              *models.User
 	Session           *models.Session
 	RequiresTwoFactor bool
-	TempToken         string // Temporary token for 2FA verification
+	TwoFactorMethods  []string // Second factors the user can verify, when RequiresTwoFactor
+	TempToken         string   // Temporary token for 2FA verification
 }
 
 // Login authenticates a user with email and password
@@ -62,9 +97,20 @@ func (s *AuthService) Login(ctx context.Context, email, password, ipAddress, use
 	user, err := s.userRepo.GetByEmail(ctx, email)
 	if err != nil {
 		log.Printf("[AUTH_SERVICE_DEBUG] GetByEmail error: %v", err)
-		// Log failed attempt
-		s.logAudit(ctx, nil, "login_failed", "authentication", ipAddress, userAgent, "user not found")
-		return nil, ErrInvalidCredentials
+		// Directory users sign in before sync has created them
+		if errors.Is(err, repository.ErrNotFound) {
+			user = s.loginFromDirectories(ctx, email, password)
+		}
+		if user == nil {
+			// Log failed attempt
+			s.logAudit(ctx, nil, "login_failed", "authentication", ipAddress, userAgent, "user not found")
+			return nil, ErrInvalidCredentials
+		}
+		if !user.IsActive {
+			s.logAudit(ctx, &user.ID, "login_failed", "authentication", ipAddress, userAgent, "account inactive")
+			return nil, ErrAccountInactive
+		}
+		return s.completeLogin(ctx, user, ipAddress, userAgent)
 	}
 
 	log.Printf("[AUTH_SERVICE_DEBUG] User found: ID=%s, Email=%s, IsActive=%v, IsLocked=%v", user.ID, user.Email, user.IsActive, user.IsLocked)
@@ -93,8 +139,15 @@ func (s *AuthService) Login(ctx context.Context, email, password, ipAddress, use
 	log.Printf("[AUTH_DEBUG] Password hash from DB (first 30 chars): %s...", user.PasswordHash[:min(30, len(user.PasswordHash))])
 	log.Printf("[AUTH_DEBUG] Password length: %d", len(password))
 
-	if err := utils.ComparePassword(user.PasswordHash, password); err != nil {
+	verified, err := s.verifyPassword(ctx, user, email, password)
+	if err != nil {
 		log.Printf("[AUTH_DEBUG] Password comparison failed: %v", err)
+		// A directory that cannot be reached says nothing about the password
+		if errors.Is(err, ErrDirectoryUnavailable) {
+			s.logAudit(ctx, &user.ID, "login_failed", "authentication", ipAddress, userAgent, "directory unavailable")
+			return nil, err
+		}
+
 		// Increment failed attempts in database
 		s.userRepo.IncrementFailedAttempts(ctx, user.ID)
 
@@ -120,6 +173,7 @@ func (s *AuthService) Login(ctx context.Context, email, password, ipAddress, use
 	}
 
 	log.Printf("[AUTH_DEBUG] Password verified successfully!")
+	user = verified
 
 	// Reset failed attempts on successful password verification
 	if user.FailedLoginAttempts > 0 {
@@ -132,23 +186,33 @@ func (s *AuthService) Login(ctx context.Context, email, password, ipAddress, use
 		}
 	}
 
+	// The directory may have deactivated the user since the check above
+	if !user.IsActive {
+		s.logAudit(ctx, &user.ID, "login_failed", "authentication", ipAddress, userAgent, "account inactive")
+		return nil, ErrAccountInactive
+	}
+
+	return s.completeLogin(ctx, user, ipAddress, userAgent)
+}
+
+// completeLogin starts the session of a user whose password has been verified,
+// or a temporary one when a second factor is still needed
+func (s *AuthService) completeLogin(ctx context.Context, user *models.User, ipAddress, userAgent string) (*LoginResult, error) {
 	// Check if 2FA is enabled
-	if user.TwoFactorAuth != nil && user.TwoFactorAuth.Enabled {
-		// Create a temporary session with short expiry for 2FA verification (5 minutes)
-		tempSession, err := s.sessionService.CreateSession(ctx, user.ID, ipAddress, userAgent)
+	if methods := s.TwoFactorMethods(ctx, user); len(methods) > 0 {
+		// A temporary session that only serves to verify the second factor
+		tempSession, err := s.sessionService.CreatePendingSession(ctx, user.ID, ipAddress, userAgent, twoFactorSessionTTL)
 		if err != nil {
 			return nil, err
 		}
 
-		// Override expiry to make it short-lived
-		tempSession.ExpiresAt = time.Now().Add(5 * time.Minute)
-
 		s.logAudit(ctx, &user.ID, "2fa_required", "authentication", ipAddress, userAgent, "")
 
 		return &LoginResult{
 			User:              user,
 			Session:           tempSession,
 			RequiresTwoFactor: true,
+			TwoFactorMethods:  methods,
 			TempToken:         tempSession.SessionToken,
 		}, nil
 	}
@@ -175,6 +239,97 @@ func (s *AuthService) Login(ctx context.Context, email, password, ipAddress, use
 	}, nil
 }
 
+// TwoFactorMethods returns the second factors a user has set up. A user whose
+// security keys or code factors cannot be read is still asked for one, rather
+// than let in.
+func (s *AuthService) TwoFactorMethods(ctx context.Context, user *models.User) []string {
+	var methods []string
+	if user.TwoFactorAuth != nil && user.TwoFactorAuth.Enabled {
+		methods = append(methods, TwoFactorMethodTOTP)
+	}
+	if s.webauthn != nil {
+		has, err := s.webauthn.HasCredentials(ctx, user.ID)
+		if err != nil {
+			log.Printf("Failed to count security keys of %s: %v", user.ID, err)
+		}
+		if has || err != nil {
+			methods = append(methods, TwoFactorMethodWebAuthn)
+		}
+	}
+	if s.otp != nil {
+		otpMethods, err := s.otp.Methods(ctx, user.ID)
+		if err != nil {
+			log.Printf("Failed to read code factors of %s: %v", user.ID, err)
+			otpMethods = s.otp.AvailableMethods()
+		}
+		methods = append(methods, otpMethods...)
+	}
+	return methods
+}
+
+// LoginWithPasskey starts the session of a user who signed in with a passkey,
+// which verified the user and so needs no second factor
+func (s *AuthService) LoginWithPasskey(ctx context.Context, user *models.User, ipAddress, userAgent string) (*models.Session, error) {
+	if user.IsLocked && user.LockedUntil != nil && time.Now().Before(*user.LockedUntil) {
+		s.logAudit(ctx, &user.ID, "login_failed", "authentication", ipAddress, userAgent, "account locked")
+		return nil, ErrAccountLocked
+	}
+	if !user.IsActive {
+		s.logAudit(ctx, &user.ID, "login_failed", "authentication", ipAddress, userAgent, "account inactive")
+		return nil, ErrAccountInactive
+	}
+
+	session, err := s.sessionService.CreateSession(ctx, user.ID, ipAddress, userAgent)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if err := s.userRepo.UpdateLastLogin(ctx, user.ID, &now); err != nil {
+		log.Printf("[AUTH_DEBUG] Failed to update last login: %v", err)
+	}
+
+	s.logAudit(ctx, &user.ID, "login_success", "authentication", ipAddress, userAgent, "method=webauthn")
+	return session, nil
+}
+
+// verifyPassword checks the password of an existing user and returns the user,
+// refreshed from the directory for directory users. Users linked to a directory
+// are only checked there, and other users only against their local password: a
+// directory password never signs in, or links, a local account. Sync links
+// local users the directory knows.
+func (s *AuthService) verifyPassword(ctx context.Context, user *models.User, email, password string) (*models.User, error) {
+	for _, directory := range s.directories {
+		manages, err := directory.Manages(ctx, user.ID)
+		if err != nil {
+			return nil, err
+		}
+		if manages {
+			return directory.Authenticate(ctx, email, password)
+		}
+	}
+
+	if err := utils.ComparePassword(user.PasswordHash, password); err != nil {
+		return nil, ErrInvalidCredentials
+	}
+	return user, nil
+}
+
+// loginFromDirectories authenticates an email with no local user against the
+// directories, which create the user on success. It returns nil otherwise.
+func (s *AuthService) loginFromDirectories(ctx context.Context, email, password string) *models.User {
+	for _, directory := range s.directories {
+		user, err := directory.Authenticate(ctx, email, password)
+		if err == nil {
+			return user
+		}
+		if !errors.Is(err, ErrInvalidCredentials) {
+			log.Printf("Directory %s: %v", directory.Name(), err)
+		}
+	}
+	return nil
+}
+
 // Logout terminates a user session
 func (s *AuthService) Logout(ctx context.Context, sessionToken, ipAddress, userAgent string) error {
 	session, err := s.sessionService.ValidateSession(ctx, sessionToken)
@@ -192,10 +347,10 @@ func (s *AuthService) RefreshSession(ctx context.Context, sessionToken string) (
 	return s.sessionService.RenewSession(ctx, sessionToken)
 }
 
-// GetSessionByToken retrieves a session by token (for 2FA temp token validation)
+// GetSessionByToken retrieves the temporary session of a 2FA temp token. Tokens
+// of full sessions are refused.
 func (s *AuthService) GetSessionByToken(ctx context.Context, token string) (*models.Session, error) {
-	// Use ValidateSession which checks expiry
-	return s.sessionService.ValidateSession(ctx, token)
+	return s.sessionService.ValidatePendingSession(ctx, token)
 }
 
 // DeleteSessionByToken deletes a session by token (cleanup temp 2FA session)
@@ -225,6 +380,24 @@ func (s *AuthService) CreateSessionAfter2FA(ctx context.Context, userID, ipAddre
 	return session, nil
 }
 
+// CheckPassword confirms the password of a signed-in user before a sensitive
+// change. A wrong password counts as a failed login attempt.
+func (s *AuthService) CheckPassword(ctx context.Context, userID, password string) error {
+	user, err := s.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	if _, err := s.verifyPassword(ctx, user, user.Email, password); err != nil {
+		if errors.Is(err, ErrDirectoryUnavailable) {
+			return err
+		}
+		s.userRepo.IncrementFailedAttempts(ctx, user.ID)
+		return ErrInvalidCredentials
+	}
+	return nil
+}
+
 // LogAudit is a public wrapper for logging audit events
 func (s *AuthService) LogAudit(ctx context.Context, userID *string, action, resource, ipAddress, userAgent, details string) {
 	s.logAudit(ctx, userID, action, resource, ipAddress, userAgent, details)
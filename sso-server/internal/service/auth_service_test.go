@@ -251,6 +251,25 @@ func TestAuthService_Login_Requires2FA(t *testing.T) {
 	// Verify temp session has short expiry (5 minutes)
 	assert.True(t, result.Session.ExpiresAt.Before(time.Now().Add(6*time.Minute)))
 	assert.True(t, result.Session.ExpiresAt.After(time.Now().Add(4*time.Minute)))
+
+	// The short expiry is stored, and the temp token only serves to verify 2FA
+	stored, err := authService.GetSessionByToken(ctx, result.TempToken)
+	require.NoError(t, err)
+	assert.True(t, stored.TwoFactorPending)
+	assert.WithinDuration(t, result.Session.ExpiresAt, stored.ExpiresAt, time.Second)
+
+	_, err = sessionService.ValidateSession(ctx, result.TempToken)
+	assert.ErrorIs(t, err, ErrSessionPending)
+	_, err = authService.RefreshSession(ctx, result.TempToken)
+	assert.ErrorIs(t, err, ErrSessionPending)
+
+	// Full sessions are not temp tokens
+	realSession, err := authService.CreateSessionAfter2FA(ctx, userID, "127.0.0.1", "Test Agent")
+	require.NoError(t, err)
+	_, err = authService.GetSessionByToken(ctx, realSession.SessionToken)
+	assert.Error(t, err)
+	_, err = sessionService.ValidateSession(ctx, realSession.SessionToken)
+	require.NoError(t, err)
 }
 
 func TestAuthService_Login_ResetFailedAttemptsOnSuccess(t *testing.T) {
@@ -0,0 +1,576 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sso-project/sso-server/internal/models"
+	"github.com/sso-project/sso-server/internal/repository"
+)
+
+// User fields a CAS service can receive as the username
+const (
+	CASUsernameEmail = "email"
+	CASUsernameID    = "id"
+)
+
+const (
+	// casRenewWindow is how recent a session must be to count as a new login (renew=true)
+	casRenewWindow = time.Minute
+	// casProxyGrantingTicketTTL bounds proxy-granting tickets, which also end with the session
+	casProxyGrantingTicketTTL = 2 * time.Hour
+	// casLogoutRecordTTL is how long validated service tickets are kept for single logout
+	casLogoutRecordTTL = 7 * 24 * time.Hour
+)
+
+var (
+	ErrCASServicePatternRequired = errors.New("at least one service pattern is required")
+	ErrInvalidCASServicePattern  = errors.New("service patterns must be URLs or regular expressions starting with ^")
+	ErrInsecureCASServicePattern = errors.New("service URLs must use https")
+	ErrInvalidCASUsername        = errors.New("username_attribute must be email or id")
+	ErrCASServiceNotAuthorized   = errors.New("service is not registered with this sign-in service")
+	ErrCASInvalidRequest         = errors.New("service and ticket are required")
+	ErrCASInvalidTicket          = errors.New("ticket is invalid, expired or already used")
+	ErrCASInvalidTicketSpec      = errors.New("ticket does not satisfy the validation request")
+	ErrCASServiceMismatch        = errors.New("ticket was not issued for this service")
+	ErrCASProxyNotAllowed        = errors.New("service is not allowed to act as a proxy")
+	ErrCASInvalidProxyCallback   = errors.New("proxy callback must be an https URL of the service")
+)
+
+// casAttributeName keeps attribute names usable as XML element names
+var casAttributeName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.-]*$`)
+
+// defaultCASAttributes are released when a service has no mappings of its own
+var defaultCASAttributes = map[string]string{
+	"email": "email",
+	"name":  "name",
+	"roles": "roles",
+}
+
+// CASServiceRequest holds the admin-editable settings of a CAS service
+type CASServiceRequest struct {
+	Name              string            `json:"name"`
+	ServicePatterns   []string          `json:"service_patterns"`
+	UsernameAttribute string            `json:"username_attribute"`
+	AttributeMappings map[string]string `json:"attribute_mappings"`
+	AllowProxy        bool              `json:"allow_proxy"`
+	SingleLogout      bool              `json:"single_logout"`
+	IsActive          *bool             `json:"is_active"`
+}
+
+// CASValidation is a ticket validation request from a service
+type CASValidation struct {
+	Service           string
+	Ticket            string
+	PgtURL            string // Proxy callback asking for a proxy-granting ticket
+	Renew             bool   // Only accept tickets issued right after the user entered credentials
+	AllowProxyTickets bool   // proxyValidate accepts proxy tickets as well as service tickets
+}
+
+// CASAuthentication is the outcome of a successful ticket validation
+type CASAuthentication struct {
+	User                   string
+	Attributes             map[string][]string // Released by the CAS 3.0 endpoints
+	ProxyGrantingTicketIOU string
+	Proxies                []string // Most recent first
+}
+
+// CASServerService makes this server a CAS 2.0/3.0 server for registered services,
+// issuing single-use tickets for the user's SSO session
+type CASServerService struct {
+	repo           *repository.CASServiceRepository
+	userRepo       *repository.UserRepository
+	sessionService *SessionService
+	serverURL      string
+	ticketTTL      time.Duration
+	client         *http.Client
+}
+
+// NewCASServerService creates a new CASServerService. Service and proxy tickets
+// can be validated for ticketTTL after they are issued.
+func NewCASServerService(
+	repo *repository.CASServiceRepository,
+	userRepo *repository.UserRepository,
+	sessionService *SessionService,
+	publicBaseURL string,
+	ticketTTL time.Duration,
+) *CASServerService {
+	return &CASServerService{
+		repo:           repo,
+		userRepo:       userRepo,
+		sessionService: sessionService,
+		serverURL:      strings.TrimRight(publicBaseURL, "/") + "/cas",
+		ticketTTL:      ticketTTL,
+		client: &http.Client{
+			Timeout: 10 * time.Second,
+			// Proxy callbacks and logout requests go to the registered URL only
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+}
+
+// ServerURL returns the CAS server URL prefix services are configured with
+func (s *CASServerService) ServerURL() string {
+	return s.serverURL
+}
+
+// CreateService registers a new CAS service
+func (s *CASServerService) CreateService(ctx context.Context, req CASServiceRequest) (*models.CASService, error) {
+	svc := &models.CASService{
+		ID:       uuid.New().String(),
+		IsActive: true,
+	}
+	if err := s.apply(svc, req); err != nil {
+		return nil, err
+	}
+
+	if err := s.repo.Create(ctx, svc); err != nil {
+		return nil, err
+	}
+
+	return svc, nil
+}
+
+// UpdateService replaces the settings of a service
+func (s *CASServerService) UpdateService(ctx context.Context, id string, req CASServiceRequest) (*models.CASService, error) {
+	svc, err := s.repo.GetByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.apply(svc, req); err != nil {
+		return nil, err
+	}
+
+	if err := s.repo.Update(ctx, svc); err != nil {
+		return nil, err
+	}
+
+	return svc, nil
+}
+
+// GetService retrieves a service by ID
+func (s *CASServerService) GetService(ctx context.Context, id string) (*models.CASService, error) {
+	return s.repo.GetByID(ctx, id)
+}
+
+// ListServices retrieves all services
+func (s *CASServerService) ListServices(ctx context.Context) ([]*models.CASService, error) {
+	return s.repo.GetAll(ctx)
+}
+
+// DeleteService removes a service and its outstanding tickets
+func (s *CASServerService) DeleteService(ctx context.Context, id string) error {
+	return s.repo.Delete(ctx, id)
+}
+
+// apply validates req and copies it onto svc
+func (s *CASServerService) apply(svc *models.CASService, req CASServiceRequest) error {
+	patterns := models.StringSlice{}
+	for _, pattern := range req.ServicePatterns {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+		if strings.HasPrefix(pattern, "^") {
+			if _, err := regexp.Compile(pattern); err != nil {
+				return ErrInvalidCASServicePattern
+			}
+		} else if err := validateFetchURL(pattern, ErrInvalidCASServicePattern, ErrInsecureCASServicePattern); err != nil {
+			return err
+		}
+		patterns = append(patterns, pattern)
+	}
+	if len(patterns) == 0 {
+		return ErrCASServicePatternRequired
+	}
+
+	username := req.UsernameAttribute
+	if username == "" {
+		username = CASUsernameEmail
+	}
+	if username != CASUsernameEmail && username != CASUsernameID {
+		return ErrInvalidCASUsername
+	}
+
+	mappings := req.AttributeMappings
+	if len(mappings) == 0 {
+		mappings = defaultCASAttributes
+	}
+	attributes := models.StringMap{}
+	for name, field := range mappings {
+		if !casAttributeName.MatchString(name) || !slices.Contains(userAttributeFields, field) {
+			return ErrInvalidAttributeMapping
+		}
+		attributes[name] = field
+	}
+
+	svc.Name = req.Name
+	if svc.Name == "" {
+		svc.Name = patterns[0]
+	}
+	svc.ServicePatterns = patterns
+	svc.UsernameAttribute = username
+	svc.AttributeMappings = attributes
+	svc.AllowProxy = req.AllowProxy
+	svc.SingleLogout = req.SingleLogout
+	if req.IsActive != nil {
+		svc.IsActive = *req.IsActive
+	}
+
+	return nil
+}
+
+// MatchService finds the active service a service URL belongs to. Services are
+// tried by name; the first whose patterns match wins.
+func (s *CASServerService) MatchService(ctx context.Context, serviceURL string) (*models.CASService, error) {
+	u, err := url.Parse(serviceURL)
+	if err != nil || u.Host == "" || (u.Scheme != "https" && u.Scheme != "http") {
+		return nil, ErrCASServiceNotAuthorized
+	}
+
+	services, err := s.repo.GetAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, svc := range services {
+		if svc.IsActive && casServiceMatches(svc, serviceURL) {
+			return svc, nil
+		}
+	}
+	return nil, ErrCASServiceNotAuthorized
+}
+
+// casServiceMatches reports whether serviceURL is one of the service's URLs.
+// A prefix only matches up to a path, query or fragment boundary, so
+// https://app.example.com does not match https://app.example.com.evil.test.
+func casServiceMatches(svc *models.CASService, serviceURL string) bool {
+	for _, pattern := range svc.ServicePatterns {
+		if strings.HasPrefix(pattern, "^") {
+			if re, err := regexp.Compile(pattern); err == nil && re.MatchString(serviceURL) {
+				return true
+			}
+			continue
+		}
+		if !strings.HasPrefix(serviceURL, pattern) {
+			continue
+		}
+		if len(serviceURL) == len(pattern) || strings.HasSuffix(pattern, "/") ||
+			strings.ContainsRune("/?#", rune(serviceURL[len(pattern)])) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsNewLogin reports whether the session was created so recently that it
+// satisfies a request to renew the user's credentials
+func (s *CASServerService) IsNewLogin(session *models.Session) bool {
+	return time.Since(session.CreatedAt) <= casRenewWindow
+}
+
+// IssueServiceTicket issues a service ticket for the session's user to a service URL
+func (s *CASServerService) IssueServiceTicket(ctx context.Context, svc *models.CASService, serviceURL string, session *models.Session) (string, error) {
+	user, err := s.userRepo.GetByID(ctx, session.UserID)
+	if err != nil {
+		return "", err
+	}
+	if err := casCheckUser(user); err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	ticket := &models.CASTicket{
+		ID:        "ST-" + randomSAMLID(),
+		Type:      models.CASServiceTicket,
+		ServiceID: svc.ID,
+		Service:   serviceURL,
+		UserID:    user.ID,
+		SessionID: session.ID,
+		Renewed:   s.IsNewLogin(session),
+		Proxies:   models.StringSlice{},
+		ExpiresAt: now.Add(s.ticketTTL),
+		CreatedAt: now,
+	}
+	if err := s.repo.CreateTicket(ctx, ticket); err != nil {
+		return "", err
+	}
+
+	return ticket.ID, nil
+}
+
+// Validate consumes a service or proxy ticket and describes the user it was issued
+// for. A ticket can only be validated once, whether or not validation succeeds.
+func (s *CASServerService) Validate(ctx context.Context, v CASValidation) (*CASAuthentication, error) {
+	if v.Service == "" || v.Ticket == "" {
+		return nil, ErrCASInvalidRequest
+	}
+
+	ticket, err := s.repo.ConsumeTicket(ctx, v.Ticket, []string{models.CASServiceTicket, models.CASProxyTicket}, time.Now().Add(casLogoutRecordTTL))
+	if err != nil {
+		if errors.Is(err, repository.ErrCASTicketNotFound) {
+			return nil, ErrCASInvalidTicket
+		}
+		return nil, err
+	}
+
+	if ticket.Type == models.CASProxyTicket && !v.AllowProxyTickets {
+		return nil, ErrCASInvalidTicketSpec
+	}
+	if ticket.Service != v.Service {
+		return nil, ErrCASServiceMismatch
+	}
+	if v.Renew && !ticket.Renewed {
+		return nil, ErrCASInvalidTicketSpec
+	}
+
+	svc, err := s.repo.GetByID(ctx, ticket.ServiceID)
+	if err != nil {
+		if errors.Is(err, repository.ErrCASServiceNotFound) {
+			return nil, ErrCASServiceNotAuthorized
+		}
+		return nil, err
+	}
+	if !svc.IsActive {
+		return nil, ErrCASServiceNotAuthorized
+	}
+
+	session, err := s.liveSession(ctx, ticket)
+	if err != nil {
+		return nil, err
+	}
+	user, err := s.userRepo.GetByID(ctx, ticket.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if err := casCheckUser(user); err != nil {
+		return nil, err
+	}
+
+	auth := &CASAuthentication{
+		User:       user.Email,
+		Attributes: casAttributes(svc, user, session, ticket),
+		Proxies:    ticket.Proxies,
+	}
+	if svc.UsernameAttribute == CASUsernameID {
+		auth.User = user.ID
+	}
+
+	if v.PgtURL != "" {
+		iou, err := s.grantProxy(ctx, svc, ticket, v.PgtURL)
+		if err != nil {
+			return nil, err
+		}
+		auth.ProxyGrantingTicketIOU = iou
+	}
+
+	return auth, nil
+}
+
+// grantProxy issues a proxy-granting ticket to a proxy callback. The ticket is
+// handed to the callback, and only its IOU is returned to the caller. Without a
+// successful callback no ticket is granted, but validation still succeeds.
+func (s *CASServerService) grantProxy(ctx context.Context, svc *models.CASService, ticket *models.CASTicket, pgtURL string) (string, error) {
+	if !svc.AllowProxy {
+		return "", ErrCASProxyNotAllowed
+	}
+	callback, err := url.Parse(pgtURL)
+	if err != nil || callback.Scheme != "https" || callback.Host == "" || !casServiceMatches(svc, pgtURL) {
+		return "", ErrCASInvalidProxyCallback
+	}
+
+	now := time.Now()
+	pgt := &models.CASTicket{
+		ID:        "PGT-" + randomSAMLID(),
+		Type:      models.CASProxyGrantingTicket,
+		ServiceID: svc.ID,
+		Service:   pgtURL,
+		UserID:    ticket.UserID,
+		SessionID: ticket.SessionID,
+		Proxies:   append(models.StringSlice{pgtURL}, ticket.Proxies...),
+		ExpiresAt: now.Add(casProxyGrantingTicketTTL),
+		CreatedAt: now,
+	}
+	if err := s.repo.CreateTicket(ctx, pgt); err != nil {
+		return "", err
+	}
+
+	iou := "PGTIOU-" + randomSAMLID()
+	query := callback.Query()
+	query.Set("pgtId", pgt.ID)
+	query.Set("pgtIou", iou)
+	callback.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, callback.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := s.client.Do(req)
+	if err == nil {
+		resp.Body.Close()
+	}
+	if err != nil || resp.StatusCode != http.StatusOK {
+		log.Printf("CAS proxy callback %s failed: %v", pgtURL, casCallbackError(resp, err))
+		if err := s.repo.DeleteTicket(ctx, pgt.ID); err != nil {
+			return "", err
+		}
+		return "", nil
+	}
+
+	return iou, nil
+}
+
+func casCallbackError(resp *http.Response, err error) error {
+	if err != nil {
+		return err
+	}
+	return fmt.Errorf("HTTP %d", resp.StatusCode)
+}
+
+// Proxy issues a proxy ticket for targetService with a proxy-granting ticket
+func (s *CASServerService) Proxy(ctx context.Context, pgtID, targetService string) (string, error) {
+	if pgtID == "" || targetService == "" {
+		return "", ErrCASInvalidRequest
+	}
+
+	pgt, err := s.repo.GetTicket(ctx, pgtID)
+	if err != nil {
+		if errors.Is(err, repository.ErrCASTicketNotFound) {
+			return "", ErrCASInvalidTicket
+		}
+		return "", err
+	}
+	if pgt.Type != models.CASProxyGrantingTicket {
+		return "", ErrCASInvalidTicket
+	}
+	if _, err := s.liveSession(ctx, pgt); err != nil {
+		return "", err
+	}
+
+	target, err := s.MatchService(ctx, targetService)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	ticket := &models.CASTicket{
+		ID:        "PT-" + randomSAMLID(),
+		Type:      models.CASProxyTicket,
+		ServiceID: target.ID,
+		Service:   targetService,
+		UserID:    pgt.UserID,
+		SessionID: pgt.SessionID,
+		Proxies:   pgt.Proxies,
+		ExpiresAt: now.Add(s.ticketTTL),
+		CreatedAt: now,
+	}
+	if err := s.repo.CreateTicket(ctx, ticket); err != nil {
+		return "", err
+	}
+
+	return ticket.ID, nil
+}
+
+// liveSession returns the SSO session a ticket was issued in; tickets end with it
+func (s *CASServerService) liveSession(ctx context.Context, ticket *models.CASTicket) (*models.Session, error) {
+	sessions, err := s.sessionService.GetUserSessions(ctx, ticket.UserID)
+	if err != nil {
+		return nil, err
+	}
+	for _, session := range sessions {
+		if session.ID == ticket.SessionID {
+			return session, nil
+		}
+	}
+	return nil, ErrCASInvalidTicket
+}
+
+// casCheckUser refuses tickets for users who can no longer sign in
+func casCheckUser(user *models.User) error {
+	if !user.IsActive {
+		return ErrAccountInactive
+	}
+	if user.IsLocked && user.LockedUntil != nil && time.Now().Before(*user.LockedUntil) {
+		return ErrAccountLocked
+	}
+	return nil
+}
+
+// casAttributes builds the released attributes from the service's mappings and
+// the CAS 3.0 authentication attributes
+func casAttributes(svc *models.CASService, user *models.User, session *models.Session, ticket *models.CASTicket) map[string][]string {
+	attributes := map[string][]string{
+		"authenticationDate":                     {session.CreatedAt.UTC().Format(time.RFC3339)},
+		"isFromNewLogin":                         {strconv.FormatBool(ticket.Renewed)},
+		"longTermAuthenticationRequestTokenUsed": {"false"},
+	}
+	for name, field := range svc.AttributeMappings {
+		if values := userAttributeValues(user, field); len(values) > 0 {
+			attributes[name] = values
+		}
+	}
+	return attributes
+}
+
+// Logout ends the session's tickets and sends a back-channel logout request to
+// every service with single logout that validated a ticket during the session.
+// Requests are sent in the background; failures are only logged.
+func (s *CASServerService) Logout(ctx context.Context, session *models.Session) error {
+	tickets, err := s.repo.GetConsumedServiceTickets(ctx, session.ID)
+	if err != nil {
+		return err
+	}
+
+	services := map[string]*models.CASService{}
+	var notify []*models.CASTicket
+	for _, ticket := range tickets {
+		svc, ok := services[ticket.ServiceID]
+		if !ok {
+			if svc, err = s.repo.GetByID(ctx, ticket.ServiceID); err != nil {
+				if errors.Is(err, repository.ErrCASServiceNotFound) {
+					continue
+				}
+				return err
+			}
+			services[ticket.ServiceID] = svc
+		}
+		if svc.IsActive && svc.SingleLogout {
+			notify = append(notify, ticket)
+		}
+	}
+
+	if err := s.repo.DeleteSessionTickets(ctx, session.ID); err != nil {
+		return err
+	}
+
+	for _, ticket := range notify {
+		go s.sendLogoutRequest(ticket)
+	}
+	return nil
+}
+
+// sendLogoutRequest posts a SAML LogoutRequest naming the service ticket as the
+// session index, as CAS single logout does
+func (s *CASServerService) sendLogoutRequest(ticket *models.CASTicket) {
+	message := fmt.Sprintf(`<samlp:LogoutRequest xmlns:samlp="urn:oasis:names:tc:SAML:2.0:protocol" xmlns:saml="urn:oasis:names:tc:SAML:2.0:assertion" ID="_%s" Version="2.0" IssueInstant="%s"><saml:NameID>@NOT_USED@</saml:NameID><samlp:SessionIndex>%s</samlp:SessionIndex></samlp:LogoutRequest>`,
+		randomSAMLID(), time.Now().UTC().Format(time.RFC3339), ticket.ID)
+
+	resp, err := s.client.PostForm(ticket.Service, url.Values{"logoutRequest": {message}})
+	if err != nil {
+		log.Printf("CAS logout request to %s failed: %v", ticket.Service, err)
+		return
+	}
+	resp.Body.Close()
+}
@@ -0,0 +1,265 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sso-project/sso-server/internal/database"
+	"github.com/sso-project/sso-server/internal/models"
+	"github.com/sso-project/sso-server/internal/repository"
+	"github.com/sso-project/sso-server/internal/testutil"
+)
+
+func newTestCASServer(db *database.DB) (*CASServerService, *SessionService) {
+	sessionService := NewSessionService(repository.NewDatabaseSessionStore(db), time.Hour)
+	casService := NewCASServerService(
+		repository.NewCASServiceRepository(db.DB),
+		repository.NewUserRepository(db),
+		sessionService,
+		"https://sso.example.com",
+		10*time.Second,
+	)
+	return casService, sessionService
+}
+
+func TestCASServerService_Services(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+	defer testutil.TeardownTestDB(t, db)
+
+	casService, _ := newTestCASServer(db)
+	ctx := context.Background()
+	assert.Equal(t, "https://sso.example.com/cas", casService.ServerURL())
+
+	_, err := casService.CreateService(ctx, CASServiceRequest{Name: "Portal"})
+	assert.ErrorIs(t, err, ErrCASServicePatternRequired)
+	_, err = casService.CreateService(ctx, CASServiceRequest{ServicePatterns: []string{"http://portal.example.com"}})
+	assert.ErrorIs(t, err, ErrInsecureCASServicePattern)
+	_, err = casService.CreateService(ctx, CASServiceRequest{ServicePatterns: []string{"^https://(portal"}})
+	assert.ErrorIs(t, err, ErrInvalidCASServicePattern)
+	_, err = casService.CreateService(ctx, CASServiceRequest{ServicePatterns: []string{"https://portal.example.com"}, UsernameAttribute: "name"})
+	assert.ErrorIs(t, err, ErrInvalidCASUsername)
+	_, err = casService.CreateService(ctx, CASServiceRequest{
+		ServicePatterns:   []string{"https://portal.example.com"},
+		AttributeMappings: map[string]string{"first name": "name"},
+	})
+	assert.ErrorIs(t, err, ErrInvalidAttributeMapping)
+
+	portal, err := casService.CreateService(ctx, CASServiceRequest{ServicePatterns: []string{"https://portal.example.com"}})
+	require.NoError(t, err)
+	assert.Equal(t, "https://portal.example.com", portal.Name)
+	assert.Equal(t, CASUsernameEmail, portal.UsernameAttribute)
+	assert.Equal(t, "roles", portal.AttributeMappings["roles"])
+	assert.True(t, portal.IsActive)
+
+	_, err = casService.CreateService(ctx, CASServiceRequest{
+		Name:            "Intranet",
+		ServicePatterns: []string{`^https://[a-z]+\.intranet\.example\.com/`},
+	})
+	require.NoError(t, err)
+
+	match := func(serviceURL string) string {
+		svc, err := casService.MatchService(ctx, serviceURL)
+		if err != nil {
+			assert.ErrorIs(t, err, ErrCASServiceNotAuthorized)
+			return ""
+		}
+		return svc.Name
+	}
+	assert.Equal(t, "https://portal.example.com", match("https://portal.example.com/login?next=/home"))
+	assert.Equal(t, "https://portal.example.com", match("https://portal.example.com"))
+	assert.Empty(t, match("https://portal.example.com.evil.test/login"))
+	assert.Equal(t, "Intranet", match("https://hr.intranet.example.com/cas"))
+	assert.Empty(t, match("https://hr.intranet.example.com.evil.test/"))
+	assert.Empty(t, match("javascript:alert(1)"))
+
+	inactive := false
+	_, err = casService.UpdateService(ctx, portal.ID, CASServiceRequest{
+		ServicePatterns: []string{"https://portal.example.com"},
+		IsActive:        &inactive,
+	})
+	require.NoError(t, err)
+	assert.Empty(t, match("https://portal.example.com/login"))
+}
+
+func TestCASServerService_Tickets(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+	defer testutil.TeardownTestDB(t, db)
+
+	casService, sessionService := newTestCASServer(db)
+	ctx := context.Background()
+	user := testutil.CreateTestUser(t, db, "frank@example.com")
+	role := testutil.CreateTestRole(t, db, "engineer")
+	require.NoError(t, repository.NewUserRepository(db).AssignRole(ctx, user.ID, role.ID))
+
+	svc, err := casService.CreateService(ctx, CASServiceRequest{
+		Name:              "Portal",
+		ServicePatterns:   []string{"https://portal.example.com/"},
+		UsernameAttribute: CASUsernameID,
+		AttributeMappings: map[string]string{"mail": "email", "memberOf": "roles"},
+	})
+	require.NoError(t, err)
+
+	session, err := sessionService.CreateSession(ctx, user.ID, "127.0.0.1", "Test Agent")
+	require.NoError(t, err)
+	serviceURL := "https://portal.example.com/login?next=%2Fhome"
+
+	ticket, err := casService.IssueServiceTicket(ctx, svc, serviceURL, session)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(ticket, "ST-"))
+
+	_, err = casService.Validate(ctx, CASValidation{Service: serviceURL})
+	assert.ErrorIs(t, err, ErrCASInvalidRequest)
+
+	// A ticket is used up by its first validation, even a failed one
+	_, err = casService.Validate(ctx, CASValidation{Service: "https://portal.example.com/other", Ticket: ticket})
+	assert.ErrorIs(t, err, ErrCASServiceMismatch)
+	_, err = casService.Validate(ctx, CASValidation{Service: serviceURL, Ticket: ticket})
+	assert.ErrorIs(t, err, ErrCASInvalidTicket)
+
+	ticket, err = casService.IssueServiceTicket(ctx, svc, serviceURL, session)
+	require.NoError(t, err)
+	auth, err := casService.Validate(ctx, CASValidation{Service: serviceURL, Ticket: ticket, Renew: true})
+	require.NoError(t, err)
+	assert.Equal(t, user.ID, auth.User)
+	assert.Equal(t, []string{"frank@example.com"}, auth.Attributes["mail"])
+	assert.Equal(t, []string{"engineer"}, auth.Attributes["memberOf"])
+	assert.Equal(t, []string{"true"}, auth.Attributes["isFromNewLogin"])
+	assert.Empty(t, auth.Proxies)
+
+	// renew=true only accepts tickets issued right after the user signed in
+	session.CreatedAt = time.Now().Add(-time.Hour)
+	assert.False(t, casService.IsNewLogin(session))
+	ticket, err = casService.IssueServiceTicket(ctx, svc, serviceURL, session)
+	require.NoError(t, err)
+	_, err = casService.Validate(ctx, CASValidation{Service: serviceURL, Ticket: ticket, Renew: true})
+	assert.ErrorIs(t, err, ErrCASInvalidTicketSpec)
+
+	// Tickets end with the session
+	ticket, err = casService.IssueServiceTicket(ctx, svc, serviceURL, session)
+	require.NoError(t, err)
+	require.NoError(t, sessionService.TerminateSession(ctx, session.SessionToken))
+	_, err = casService.Validate(ctx, CASValidation{Service: serviceURL, Ticket: ticket})
+	assert.ErrorIs(t, err, ErrCASInvalidTicket)
+}
+
+func TestCASServerService_Proxy(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+	defer testutil.TeardownTestDB(t, db)
+
+	casService, sessionService := newTestCASServer(db)
+	ctx := context.Background()
+	user := testutil.CreateTestUser(t, db, "grace@example.com")
+	session, err := sessionService.CreateSession(ctx, user.ID, "127.0.0.1", "Test Agent")
+	require.NoError(t, err)
+
+	// The portal's proxy callback receives the PGT; the IOU comes back with validation
+	pgts := map[string]string{}
+	callback := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pgts[r.URL.Query().Get("pgtIou")] = r.URL.Query().Get("pgtId")
+	}))
+	defer callback.Close()
+	casService.client = callback.Client()
+
+	portal, err := casService.CreateService(ctx, CASServiceRequest{
+		Name:            "Portal",
+		ServicePatterns: []string{"https://portal.example.com/", callback.URL + "/"},
+		AllowProxy:      true,
+	})
+	require.NoError(t, err)
+	backend, err := casService.CreateService(ctx, CASServiceRequest{
+		Name:            "Backend",
+		ServicePatterns: []string{"https://backend.example.com/"},
+	})
+	require.NoError(t, err)
+
+	validateWithProxy := func(svc *models.CASService, serviceURL, pgtURL string) (*CASAuthentication, error) {
+		ticket, err := casService.IssueServiceTicket(ctx, svc, serviceURL, session)
+		require.NoError(t, err)
+		return casService.Validate(ctx, CASValidation{Service: serviceURL, Ticket: ticket, PgtURL: pgtURL})
+	}
+
+	_, err = validateWithProxy(backend, "https://backend.example.com/app", "https://backend.example.com/pgt")
+	assert.ErrorIs(t, err, ErrCASProxyNotAllowed)
+	_, err = validateWithProxy(portal, "https://portal.example.com/app", "https://elsewhere.example.com/pgt")
+	assert.ErrorIs(t, err, ErrCASInvalidProxyCallback)
+
+	auth, err := validateWithProxy(portal, "https://portal.example.com/app", callback.URL+"/pgt")
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(auth.ProxyGrantingTicketIOU, "PGTIOU-"))
+	pgt := pgts[auth.ProxyGrantingTicketIOU]
+	require.True(t, strings.HasPrefix(pgt, "PGT-"))
+
+	_, err = casService.Proxy(ctx, pgt, "https://unknown.example.com/")
+	assert.ErrorIs(t, err, ErrCASServiceNotAuthorized)
+	_, err = casService.Proxy(ctx, "PGT-unknown", "https://backend.example.com/api")
+	assert.ErrorIs(t, err, ErrCASInvalidTicket)
+
+	// A proxy ticket is only accepted by proxyValidate, which lists the proxies
+	pt, err := casService.Proxy(ctx, pgt, "https://backend.example.com/api")
+	require.NoError(t, err)
+	_, err = casService.Validate(ctx, CASValidation{Service: "https://backend.example.com/api", Ticket: pt})
+	assert.ErrorIs(t, err, ErrCASInvalidTicketSpec)
+
+	pt, err = casService.Proxy(ctx, pgt, "https://backend.example.com/api")
+	require.NoError(t, err)
+	auth, err = casService.Validate(ctx, CASValidation{Service: "https://backend.example.com/api", Ticket: pt, AllowProxyTickets: true})
+	require.NoError(t, err)
+	assert.Equal(t, "grace@example.com", auth.User)
+	assert.Equal(t, []string{callback.URL + "/pgt"}, auth.Proxies)
+
+	// A PGT cannot be validated as a ticket
+	_, err = casService.Validate(ctx, CASValidation{Service: callback.URL + "/pgt", Ticket: pgt, AllowProxyTickets: true})
+	assert.ErrorIs(t, err, ErrCASInvalidTicket)
+}
+
+func TestCASServerService_SingleLogout(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+	defer testutil.TeardownTestDB(t, db)
+
+	casService, sessionService := newTestCASServer(db)
+	ctx := context.Background()
+	user := testutil.CreateTestUser(t, db, "heidi@example.com")
+	session, err := sessionService.CreateSession(ctx, user.ID, "127.0.0.1", "Test Agent")
+	require.NoError(t, err)
+
+	requests := make(chan string, 1)
+	app := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests <- r.FormValue("logoutRequest")
+	}))
+	defer app.Close()
+
+	withLogout, err := casService.CreateService(ctx, CASServiceRequest{ServicePatterns: []string{app.URL + "/"}, SingleLogout: true})
+	require.NoError(t, err)
+	withoutLogout, err := casService.CreateService(ctx, CASServiceRequest{ServicePatterns: []string{"https://quiet.example.com/"}})
+	require.NoError(t, err)
+
+	ticket, err := casService.IssueServiceTicket(ctx, withLogout, app.URL+"/cas", session)
+	require.NoError(t, err)
+	_, err = casService.Validate(ctx, CASValidation{Service: app.URL + "/cas", Ticket: ticket})
+	require.NoError(t, err)
+	quiet, err := casService.IssueServiceTicket(ctx, withoutLogout, "https://quiet.example.com/", session)
+	require.NoError(t, err)
+	_, err = casService.Validate(ctx, CASValidation{Service: "https://quiet.example.com/", Ticket: quiet})
+	require.NoError(t, err)
+	pending, err := casService.IssueServiceTicket(ctx, withLogout, app.URL+"/cas", session)
+	require.NoError(t, err)
+
+	require.NoError(t, casService.Logout(ctx, session))
+	select {
+	case message := <-requests:
+		assert.Contains(t, message, "<samlp:SessionIndex>"+ticket+"</samlp:SessionIndex>")
+	case <-time.After(5 * time.Second):
+		t.Fatal("no logout request was sent")
+	}
+
+	// Tickets of the session are gone
+	_, err = casService.Validate(ctx, CASValidation{Service: app.URL + "/cas", Ticket: pending})
+	assert.ErrorIs(t, err, ErrCASInvalidTicket)
+}
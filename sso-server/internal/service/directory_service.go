@@ -0,0 +1,403 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sso-project/sso-server/internal/models"
+	"github.com/sso-project/sso-server/internal/repository"
+)
+
+var (
+	ErrDirectoryUnavailable     = errors.New("directory is unavailable")
+	ErrDirectoryEmpty           = errors.New("directory returned no accounts; refusing to deactivate every user")
+	ErrDirectorySyncUnsupported = errors.New("directory backend does not support sync")
+	ErrDirectorySyncRunning     = errors.New("directory sync is already running")
+)
+
+// AuthBackend checks passwords against a system of record other than
+// users.password_hash. Users it knows are linked to it in user_identities, with
+// the backend's name as provider, and sign in with their directory password only.
+type AuthBackend interface {
+	// Name is the provider key of the backend's links, e.g. "ldap"
+	Name() string
+	// Authenticate checks the password of the account with the given login (an
+	// email address). It returns ErrInvalidCredentials for unknown logins and wrong
+	// passwords, and wraps ErrDirectoryUnavailable when the check could not be made.
+	Authenticate(ctx context.Context, login, password string) (*DirectoryAccount, error)
+}
+
+// PasswordDirectory is what AuthService.Login checks directory passwords with.
+// DirectoryService implements it over any AuthBackend, turning the backend's
+// accounts into local users.
+type PasswordDirectory interface {
+	// Name is the provider key of the directory's links
+	Name() string
+	// Manages reports whether the user is linked to the directory
+	Manages(ctx context.Context, userID string) (bool, error)
+	// Authenticate checks the password and returns the account's local user
+	Authenticate(ctx context.Context, login, password string) (*models.User, error)
+}
+
+// DirectoryLister is an AuthBackend that can enumerate its accounts, which
+// scheduled sync needs
+type DirectoryLister interface {
+	AuthBackend
+	Accounts(ctx context.Context) ([]*DirectoryAccount, error)
+}
+
+// DirectoryAccount is a user entry in a backend's directory
+type DirectoryAccount struct {
+	Subject string // Stable identifier that survives renames (entryUUID, objectGUID)
+	Email   string
+	Name    string
+	Groups  []string
+}
+
+// DirectorySyncResult summarizes one sync run
+type DirectorySyncResult struct {
+	Directory   string    `json:"directory"`
+	StartedAt   time.Time `json:"started_at"`
+	FinishedAt  time.Time `json:"finished_at"`
+	Accounts    int       `json:"accounts"`
+	Created     int       `json:"created"`
+	Updated     int       `json:"updated"`
+	Deactivated int       `json:"deactivated"`
+	Failed      int       `json:"failed"`
+	Error       string    `json:"error,omitempty"`
+}
+
+// DirectoryService keeps local users in step with a directory: it authenticates
+// directory users for AuthService.Login and runs the scheduled sync. The directory
+// is the system of record for the users it knows, so their email, name and mapped
+// roles are overwritten from it. It reactivates only users it deactivated itself.
+type DirectoryService struct {
+	backend        AuthBackend
+	roleMappings   map[string]string // Directory group -> local role name
+	accounts       *upstreamAccounts
+	identityRepo   *repository.UserIdentityRepository
+	userRepo       *repository.UserRepository
+	roleRepo       *repository.RoleRepository
+	sessionService *SessionService
+	auditRepo      *repository.AuditLogRepository
+
+	syncMu   sync.Mutex
+	statusMu sync.RWMutex
+	lastSync *DirectorySyncResult
+}
+
+// NewDirectoryService creates a new DirectoryService
+func NewDirectoryService(
+	backend AuthBackend,
+	identityRepo *repository.UserIdentityRepository,
+	userRepo *repository.UserRepository,
+	roleRepo *repository.RoleRepository,
+	sessionService *SessionService,
+	auditRepo *repository.AuditLogRepository,
+	roleMappings map[string]string,
+) *DirectoryService {
+	return &DirectoryService{
+		backend:      backend,
+		roleMappings: roleMappings,
+		accounts: &upstreamAccounts{
+			identityRepo:   identityRepo,
+			userRepo:       userRepo,
+			roleRepo:       roleRepo,
+			sessionService: sessionService,
+			auditRepo:      auditRepo,
+		},
+		identityRepo:   identityRepo,
+		userRepo:       userRepo,
+		roleRepo:       roleRepo,
+		sessionService: sessionService,
+		auditRepo:      auditRepo,
+	}
+}
+
+// Name returns the name of the directory's backend
+func (s *DirectoryService) Name() string {
+	return s.backend.Name()
+}
+
+// Manages reports whether the user signs in through this directory
+func (s *DirectoryService) Manages(ctx context.Context, userID string) (bool, error) {
+	identities, err := s.identityRepo.GetByUserID(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+	return slices.ContainsFunc(identities, func(identity *models.UserIdentity) bool {
+		return identity.Provider == s.backend.Name()
+	}), nil
+}
+
+// Authenticate checks the password with the backend and returns the local user
+// of the account, linked or created and brought up to date with the directory
+func (s *DirectoryService) Authenticate(ctx context.Context, login, password string) (*models.User, error) {
+	account, err := s.backend.Authenticate(ctx, login, password)
+	if err != nil {
+		return nil, err
+	}
+
+	user, _, err := s.reconcile(ctx, account)
+	if err != nil {
+		return nil, err
+	}
+
+	identity, err := s.identityRepo.GetByProviderSubject(ctx, s.backend.Name(), account.Subject)
+	if err == nil {
+		s.identityRepo.RecordLogin(ctx, identity.ID, user.Email, time.Now())
+	}
+
+	return user, nil
+}
+
+// directoryChange is what reconcile did to a local user
+type directoryChange int
+
+const (
+	directoryUnchanged directoryChange = iota
+	directoryCreated
+	directoryUpdated
+)
+
+// reconcile finds, links or creates the local user of account and copies the
+// directory's attributes and mapped roles onto it
+func (s *DirectoryService) reconcile(ctx context.Context, account *DirectoryAccount) (*models.User, directoryChange, error) {
+	if account.Subject == "" {
+		return nil, directoryUnchanged, fmt.Errorf("directory account %q has no identifier", account.Email)
+	}
+
+	// Directory addresses are managed by the organization, so they may link existing users
+	user, _, provisioned, err := s.accounts.resolveUser(ctx, &upstreamAccount{
+		Provider:      s.backend.Name(),
+		Subject:       account.Subject,
+		Email:         account.Email,
+		Name:          account.Name,
+		EmailVerified: true,
+	}, upstreamPolicy{Connection: s.backend.Name(), JITProvisioning: true, LinkByEmail: true})
+	if err != nil {
+		return nil, directoryUnchanged, err
+	}
+
+	change := directoryUnchanged
+	if provisioned {
+		change = directoryCreated
+	}
+
+	updated := false
+	email := strings.ToLower(account.Email)
+	if email != "" && email != user.Email {
+		if other, err := s.userRepo.GetByEmail(ctx, email); err == nil && other.ID != user.ID {
+			log.Printf("Directory %s: cannot change email of user %s to %s, address is taken", s.backend.Name(), user.ID, email)
+		} else {
+			user.Email = email
+			updated = true
+		}
+	}
+	if account.Name != "" && account.Name != user.Name {
+		user.Name = account.Name
+		updated = true
+	}
+	if !user.EmailVerified {
+		user.EmailVerified = true
+		updated = true
+	}
+	// Users deactivated locally, such as by an administrator, stay inactive
+	if !user.IsActive && user.DirectoryRemovedAt != nil {
+		user.IsActive = true
+		user.DirectoryRemovedAt = nil
+		updated = true
+	}
+	if updated {
+		if err := s.userRepo.Update(ctx, user); err != nil {
+			return nil, directoryUnchanged, err
+		}
+	}
+
+	if s.syncRoles(ctx, user, account.Groups) {
+		updated = true
+	}
+
+	if updated && change == directoryUnchanged {
+		change = directoryUpdated
+	}
+	return user, change, nil
+}
+
+// syncRoles grants the roles mapped to the account's groups and revokes mapped
+// roles whose group the account left. Roles no group maps to are left alone.
+func (s *DirectoryService) syncRoles(ctx context.Context, user *models.User, groups []string) bool {
+	wanted := map[string]bool{}
+	managed := map[string]bool{}
+	for group, roleName := range s.roleMappings {
+		managed[roleName] = true
+		if slices.ContainsFunc(groups, func(g string) bool { return strings.EqualFold(g, group) }) {
+			wanted[roleName] = true
+		}
+	}
+
+	changed := false
+	kept := user.Roles[:0]
+	for _, role := range user.Roles {
+		if managed[role.Name] && !wanted[role.Name] {
+			if err := s.userRepo.RemoveRole(ctx, user.ID, role.ID); err != nil {
+				log.Printf("Failed to remove role %s from user %s: %v", role.Name, user.ID, err)
+				kept = append(kept, role)
+				continue
+			}
+			changed = true
+			continue
+		}
+		kept = append(kept, role)
+		delete(wanted, role.Name)
+	}
+	user.Roles = kept
+
+	for roleName := range wanted {
+		role, err := s.roleRepo.GetByName(ctx, roleName)
+		if err != nil {
+			log.Printf("Directory %s maps a group to unknown role %q", s.backend.Name(), roleName)
+			continue
+		}
+		if err := s.userRepo.AssignRole(ctx, user.ID, role.ID); err != nil {
+			log.Printf("Failed to assign role %s to user %s: %v", role.Name, user.ID, err)
+			continue
+		}
+		user.Roles = append(user.Roles, *role)
+		changed = true
+	}
+
+	return changed
+}
+
+// Sync creates and updates the local users of every directory account, and
+// deactivates linked users whose account is gone from the directory
+func (s *DirectoryService) Sync(ctx context.Context) (*DirectorySyncResult, error) {
+	lister, ok := s.backend.(DirectoryLister)
+	if !ok {
+		return nil, ErrDirectorySyncUnsupported
+	}
+	if !s.syncMu.TryLock() {
+		return nil, ErrDirectorySyncRunning
+	}
+	defer s.syncMu.Unlock()
+
+	result := &DirectorySyncResult{
+		Directory: s.backend.Name(),
+		StartedAt: time.Now(),
+	}
+	err := s.sync(ctx, lister, result)
+	result.FinishedAt = time.Now()
+	if err != nil {
+		result.Error = err.Error()
+	}
+
+	s.statusMu.Lock()
+	s.lastSync = result
+	s.statusMu.Unlock()
+
+	s.logAudit(ctx, result)
+	return result, err
+}
+
+func (s *DirectoryService) sync(ctx context.Context, lister DirectoryLister, result *DirectorySyncResult) error {
+	accounts, err := lister.Accounts(ctx)
+	if err != nil {
+		return err
+	}
+	// An empty answer is far more likely a broken filter than an empty company
+	if len(accounts) == 0 {
+		return ErrDirectoryEmpty
+	}
+	result.Accounts = len(accounts)
+
+	seen := make(map[string]bool, len(accounts))
+	for _, account := range accounts {
+		seen[account.Subject] = true
+		_, change, err := s.reconcile(ctx, account)
+		if err != nil {
+			log.Printf("Directory %s: failed to sync %s: %v", s.backend.Name(), account.Email, err)
+			result.Failed++
+			continue
+		}
+		switch change {
+		case directoryCreated:
+			result.Created++
+		case directoryUpdated:
+			result.Updated++
+		}
+	}
+
+	identities, err := s.identityRepo.GetByProvider(ctx, s.backend.Name())
+	if err != nil {
+		return err
+	}
+	for _, identity := range identities {
+		if seen[identity.Subject] {
+			continue
+		}
+		user, err := s.userRepo.GetByID(ctx, identity.UserID)
+		if err != nil || !user.IsActive {
+			continue
+		}
+		if err := s.userRepo.DeactivateByDirectory(ctx, user.ID, time.Now()); err != nil {
+			log.Printf("Directory %s: failed to deactivate user %s: %v", s.backend.Name(), user.ID, err)
+			result.Failed++
+			continue
+		}
+		s.sessionService.TerminateUserSessions(ctx, user.ID)
+		result.Deactivated++
+	}
+
+	return nil
+}
+
+// LastSync returns the result of the latest sync run, or nil before the first
+func (s *DirectoryService) LastSync() *DirectorySyncResult {
+	s.statusMu.RLock()
+	defer s.statusMu.RUnlock()
+	return s.lastSync
+}
+
+// Schedule syncs the directory now and then every interval until ctx is done
+func (s *DirectoryService) Schedule(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if result, err := s.Sync(ctx); err != nil {
+			log.Printf("Directory %s sync failed: %v", s.backend.Name(), err)
+		} else {
+			log.Printf("Directory %s synced: %d accounts, %d created, %d updated, %d deactivated, %d failed",
+				s.backend.Name(), result.Accounts, result.Created, result.Updated, result.Deactivated, result.Failed)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *DirectoryService) logAudit(ctx context.Context, result *DirectorySyncResult) {
+	auditLog := &models.AuditLog{
+		ID:       uuid.New().String(),
+		Action:   "directory_sync",
+		Resource: "directory",
+		Details: fmt.Sprintf("directory=%s accounts=%d created=%d updated=%d deactivated=%d failed=%d error=%q",
+			result.Directory, result.Accounts, result.Created, result.Updated, result.Deactivated, result.Failed, result.Error),
+		CreatedAt: time.Now(),
+	}
+
+	if err := s.auditRepo.Create(ctx, auditLog); err != nil {
+		log.Printf("Failed to create audit log: %v", err)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"html/template"
 	"net/smtp"
 	"path/filepath"
+	"time"
 )
 
 // EmailConfig holds email service configuration
@@ -46,6 +47,7 @@ func (s *EmailService) loadTemplates() error {
 	templateFiles := map[string]string{
 		"password_reset": filepath.Join(templateDir, "password_reset.html"),
 		"welcome":        filepath.Join(templateDir, "welcome.html"),
+		"one_time_code":  filepath.Join(templateDir, "one_time_code.html"),
 	}
 
 	for name, path := range templateFiles {
@@ -98,6 +100,28 @@ func (s *EmailService) SendWelcomeEmail(to, name string) error {
 	return s.sendEmail(to, subject, body)
 }
 
+// SendOneTimeCodeEmail sends a one-time code to confirm a sign-in or enable the
+// email second factor
+func (s *EmailService) SendOneTimeCodeEmail(to, name, code string, ttl time.Duration) error {
+	data := struct {
+		Name    string
+		Code    string
+		Minutes int
+	}{
+		Name:    name,
+		Code:    code,
+		Minutes: int(ttl.Round(time.Minute) / time.Minute),
+	}
+
+	body, err := s.renderTemplate("one_time_code", data)
+	if err != nil {
+		return fmt.Errorf("failed to render one-time code template: %w", err)
+	}
+
+	subject := fmt.Sprintf("Your verification code is %s - SSO Server", code)
+	return s.sendEmail(to, subject, body)
+}
+
 // renderTemplate renders an email template with data
 func (s *EmailService) renderTemplate(templateName string, data interface{}) (string, error) {
 	tmpl, exists := s.templates[templateName]
@@ -0,0 +1,241 @@
+package service
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sso-project/sso-server/internal/models"
+	"github.com/sso-project/sso-server/internal/repository"
+)
+
+var (
+	ErrReauthenticationRequired = errors.New("confirm your password or sign in again to continue")
+	ErrIdentityInUse            = errors.New("identity is linked to another user")
+	ErrIdentityLinkExpired      = errors.New("identity link request expired or is invalid")
+	ErrUpstreamAccountExists    = errors.New("an account with this email address already exists")
+	ErrMergeSameUser            = errors.New("a user cannot be merged into itself")
+)
+
+// identityLinkTTL is how long a confirmed link request can be completed at the provider
+const identityLinkTTL = 10 * time.Minute
+
+// IdentityOptions configures linking of external identities
+type IdentityOptions struct {
+	LinkByEmail  bool          // Sign-ins link to the user with the same verified email
+	ReauthMaxAge time.Duration // A session this recent counts as re-authenticated; 0 always asks for the password
+}
+
+// IdentityService manages the external identities linked to users: users link
+// and unlink them from their account, and admins merge duplicate users
+type IdentityService struct {
+	identityRepo   *repository.UserIdentityRepository
+	userRepo       *repository.UserRepository
+	authService    *AuthService
+	totpService    *TOTPService
+	sessionService *SessionService
+	secret         []byte
+	opts           IdentityOptions
+}
+
+// NewIdentityService creates a new IdentityService. Link requests are signed with secret.
+func NewIdentityService(
+	identityRepo *repository.UserIdentityRepository,
+	userRepo *repository.UserRepository,
+	authService *AuthService,
+	totpService *TOTPService,
+	sessionService *SessionService,
+	secret string,
+	opts IdentityOptions,
+) *IdentityService {
+	return &IdentityService{
+		identityRepo:   identityRepo,
+		userRepo:       userRepo,
+		authService:    authService,
+		totpService:    totpService,
+		sessionService: sessionService,
+		secret:         []byte(secret),
+		opts:           opts,
+	}
+}
+
+// ListIdentities retrieves the external identities linked to a user
+func (s *IdentityService) ListIdentities(ctx context.Context, userID string) ([]*models.UserIdentity, error) {
+	return s.identityRepo.GetByUserID(ctx, userID)
+}
+
+// Reauthenticate confirms that the person behind session is the user, before a
+// change to how they sign in. The password (and 2FA code when enabled) is checked,
+// or, without a password, the session must have been created recently.
+func (s *IdentityService) Reauthenticate(ctx context.Context, session *models.Session, password, code string) error {
+	if password == "" {
+		if s.opts.ReauthMaxAge > 0 && time.Since(session.CreatedAt) <= s.opts.ReauthMaxAge {
+			return nil
+		}
+		return ErrReauthenticationRequired
+	}
+
+	if err := s.authService.CheckPassword(ctx, session.UserID, password); err != nil {
+		return err
+	}
+
+	user, err := s.userRepo.GetByID(ctx, session.UserID)
+	if err != nil {
+		return err
+	}
+	if user.TwoFactorAuth != nil && user.TwoFactorAuth.Enabled {
+		verified, err := s.totpService.Verify(ctx, user.ID, code)
+		if err != nil {
+			return err
+		}
+		if verified.BackupCodeUsed {
+			s.authService.LogAudit(ctx, &user.ID, "2fa_backup_code_used", "authentication", "", "", fmt.Sprintf("remaining=%d", verified.BackupCodesRemaining))
+		}
+	}
+	return nil
+}
+
+// BeginLink re-authenticates the user and returns a link request, which the
+// browser carries through a sign-in at an upstream provider to link that account
+// to the user instead of signing in with it
+func (s *IdentityService) BeginLink(ctx context.Context, session *models.Session, password, code string) (string, time.Time, error) {
+	if err := s.Reauthenticate(ctx, session, password, code); err != nil {
+		return "", time.Time{}, err
+	}
+
+	expiresAt := time.Now().Add(identityLinkTTL)
+	payload := session.UserID + "." + strconv.FormatInt(expiresAt.Unix(), 10)
+	return payload + "." + s.sign(payload), expiresAt, nil
+}
+
+// linkUserID returns the user a link request was issued to
+func (s *IdentityService) linkUserID(request string) (string, error) {
+	i := strings.LastIndexByte(request, '.')
+	if i < 0 {
+		return "", ErrIdentityLinkExpired
+	}
+	payload, signature := request[:i], request[i+1:]
+	if subtle.ConstantTimeCompare([]byte(s.sign(payload)), []byte(signature)) != 1 {
+		return "", ErrIdentityLinkExpired
+	}
+
+	userID, expiry, _ := strings.Cut(payload, ".")
+	expiresAt, err := strconv.ParseInt(expiry, 10, 64)
+	if err != nil || time.Now().Unix() > expiresAt {
+		return "", ErrIdentityLinkExpired
+	}
+	return userID, nil
+}
+
+func (s *IdentityService) sign(payload string) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte("identity-link\x00"))
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// link attaches an upstream account to the user a link request was issued to
+func (s *IdentityService) link(ctx context.Context, request string, account *upstreamAccount) (*models.User, *models.UserIdentity, error) {
+	userID, err := s.linkUserID(request)
+	if err != nil {
+		return nil, nil, err
+	}
+	user, err := s.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	identity, err := s.identityRepo.GetByProviderSubject(ctx, account.Provider, account.Subject)
+	switch {
+	case err == nil:
+		if identity.UserID != user.ID {
+			return nil, nil, ErrIdentityInUse
+		}
+		return user, identity, nil
+	case !errors.Is(err, repository.ErrIdentityNotFound):
+		return nil, nil, err
+	}
+
+	identity = &models.UserIdentity{
+		ID:        uuid.New().String(),
+		UserID:    user.ID,
+		Provider:  account.Provider,
+		Subject:   account.Subject,
+		Email:     strings.ToLower(account.Email),
+		CreatedAt: time.Now(),
+	}
+	if err := s.identityRepo.Create(ctx, identity); err != nil {
+		return nil, nil, err
+	}
+	return user, identity, nil
+}
+
+// Unlink re-authenticates the user and removes one of their identities
+func (s *IdentityService) Unlink(ctx context.Context, session *models.Session, identityID, password, code string) (*models.UserIdentity, error) {
+	identity, err := s.identityRepo.GetByID(ctx, identityID)
+	if err != nil {
+		return nil, err
+	}
+	if identity.UserID != session.UserID {
+		return nil, repository.ErrIdentityNotFound
+	}
+
+	if err := s.Reauthenticate(ctx, session, password, code); err != nil {
+		return nil, err
+	}
+
+	if err := s.identityRepo.Delete(ctx, identity.ID); err != nil {
+		return nil, err
+	}
+	return identity, nil
+}
+
+// RemoveIdentity removes an identity of a user on an admin's behalf
+func (s *IdentityService) RemoveIdentity(ctx context.Context, userID, identityID string) (*models.UserIdentity, error) {
+	identity, err := s.identityRepo.GetByID(ctx, identityID)
+	if err != nil {
+		return nil, err
+	}
+	if identity.UserID != userID {
+		return nil, repository.ErrIdentityNotFound
+	}
+
+	if err := s.identityRepo.Delete(ctx, identity.ID); err != nil {
+		return nil, err
+	}
+	return identity, nil
+}
+
+// MergeUsers folds a duplicate user into the user that is kept. The target gains
+// the source's roles, identities, consents and audit history; the source's
+// sessions are terminated and the source is deleted.
+func (s *IdentityService) MergeUsers(ctx context.Context, targetID, sourceID string) (*models.User, error) {
+	if targetID == sourceID {
+		return nil, ErrMergeSameUser
+	}
+	if _, err := s.userRepo.GetByID(ctx, targetID); err != nil {
+		return nil, err
+	}
+	if _, err := s.userRepo.GetByID(ctx, sourceID); err != nil {
+		return nil, err
+	}
+
+	// Sessions may live outside the database, so they end before the source is deleted.
+	// Whoever is signed in as the source signs in again as the target.
+	if err := s.sessionService.TerminateUserSessions(ctx, sourceID); err != nil {
+		return nil, err
+	}
+	if err := s.userRepo.Merge(ctx, sourceID, targetID); err != nil {
+		return nil, err
+	}
+
+	return s.userRepo.GetByID(ctx, targetID)
+}
@@ -0,0 +1,200 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sso-project/sso-server/internal/database"
+	"github.com/sso-project/sso-server/internal/models"
+	"github.com/sso-project/sso-server/internal/repository"
+	"github.com/sso-project/sso-server/internal/testutil"
+)
+
+func newTestIdentityService(t *testing.T, db *database.DB, opts IdentityOptions) (*IdentityService, *SessionService) {
+	userRepo := repository.NewUserRepository(db)
+	sessionService := NewSessionService(repository.NewDatabaseSessionStore(db), time.Hour)
+	authService := NewAuthService(userRepo, sessionService, repository.NewAuditLogRepository(db), 5, 30*time.Minute)
+	identityService := NewIdentityService(
+		repository.NewUserIdentityRepository(db.DB),
+		userRepo,
+		authService,
+		newTestTOTPService(t, db),
+		sessionService,
+		"test-secret",
+		opts,
+	)
+	return identityService, sessionService
+}
+
+func TestIdentityService_LinkAndUnlink(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+	defer testutil.TeardownTestDB(t, db)
+
+	identityService, sessionService := newTestIdentityService(t, db, IdentityOptions{LinkByEmail: true, ReauthMaxAge: 5 * time.Minute})
+	ctx := context.Background()
+	alice := testutil.CreateTestUserWithPassword(t, db, "alice@example.com", "AlicePassword123!")
+	bob := testutil.CreateTestUser(t, db, "bob@example.com")
+
+	session, err := sessionService.CreateSession(ctx, alice.ID, "127.0.0.1", "Test Agent")
+	require.NoError(t, err)
+
+	// A fresh session is enough; an older one needs the password
+	_, _, err = identityService.BeginLink(ctx, session, "", "")
+	require.NoError(t, err)
+
+	session.CreatedAt = time.Now().Add(-time.Hour)
+	_, _, err = identityService.BeginLink(ctx, session, "", "")
+	assert.ErrorIs(t, err, ErrReauthenticationRequired)
+	_, _, err = identityService.BeginLink(ctx, session, "WrongPassword1!", "")
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+
+	request, expiresAt, err := identityService.BeginLink(ctx, session, "AlicePassword123!", "")
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(identityLinkTTL), expiresAt, time.Second)
+
+	userID, err := identityService.linkUserID(request)
+	require.NoError(t, err)
+	assert.Equal(t, alice.ID, userID)
+
+	// The request cannot be altered or reused after it expires
+	_, err = identityService.linkUserID(bob.ID + request[len(alice.ID):])
+	assert.ErrorIs(t, err, ErrIdentityLinkExpired)
+	stale := alice.ID + ".1000"
+	_, err = identityService.linkUserID(stale + "." + identityService.sign(stale))
+	assert.ErrorIs(t, err, ErrIdentityLinkExpired)
+
+	google := &upstreamAccount{Provider: "google", Subject: "g-1", Email: "Alice@Gmail.com"}
+	user, identity, err := identityService.link(ctx, request, google)
+	require.NoError(t, err)
+	assert.Equal(t, alice.ID, user.ID)
+	assert.Equal(t, "alice@gmail.com", identity.Email)
+
+	// Linking again is harmless; linking to someone else is not allowed
+	_, again, err := identityService.link(ctx, request, google)
+	require.NoError(t, err)
+	assert.Equal(t, identity.ID, again.ID)
+
+	bobSession := testutil.CreateTestSession(t, db, bob.ID)
+	bobSession.CreatedAt = time.Now()
+	bobRequest, _, err := identityService.BeginLink(ctx, bobSession, "", "")
+	require.NoError(t, err)
+	_, _, err = identityService.link(ctx, bobRequest, google)
+	assert.ErrorIs(t, err, ErrIdentityInUse)
+
+	// Only the owner can unlink, after re-authenticating
+	_, err = identityService.Unlink(ctx, bobSession, identity.ID, "", "")
+	assert.ErrorIs(t, err, repository.ErrIdentityNotFound)
+	_, err = identityService.Unlink(ctx, session, identity.ID, "", "")
+	assert.ErrorIs(t, err, ErrReauthenticationRequired)
+
+	_, err = identityService.Unlink(ctx, session, identity.ID, "AlicePassword123!", "")
+	require.NoError(t, err)
+	identities, err := identityService.ListIdentities(ctx, alice.ID)
+	require.NoError(t, err)
+	assert.Empty(t, identities)
+}
+
+func TestIdentityService_EmailLinkingDisabled(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+	defer testutil.TeardownTestDB(t, db)
+
+	identityService, sessionService := newTestIdentityService(t, db, IdentityOptions{LinkByEmail: false})
+	userRepo := repository.NewUserRepository(db)
+	accounts := &upstreamAccounts{
+		identityRepo:   repository.NewUserIdentityRepository(db.DB),
+		userRepo:       userRepo,
+		roleRepo:       repository.NewRoleRepository(db),
+		sessionService: sessionService,
+		auditRepo:      repository.NewAuditLogRepository(db),
+		identities:     identityService,
+	}
+	ctx := context.Background()
+	testutil.CreateTestUser(t, db, "carol@example.com")
+
+	account := &upstreamAccount{Provider: "corp", Subject: "c-1", Email: "carol@example.com", EmailVerified: true}
+	_, _, _, err := accounts.resolveUser(ctx, account, upstreamPolicy{JITProvisioning: true, LinkByEmail: accounts.linkByEmail()})
+	assert.ErrorIs(t, err, ErrUpstreamAccountExists)
+
+	// New people are still provisioned
+	account = &upstreamAccount{Provider: "corp", Subject: "d-1", Email: "dave@example.com", EmailVerified: true}
+	_, _, provisioned, err := accounts.resolveUser(ctx, account, upstreamPolicy{JITProvisioning: true, LinkByEmail: accounts.linkByEmail()})
+	require.NoError(t, err)
+	assert.True(t, provisioned)
+}
+
+func TestIdentityService_MergeUsers(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+	defer testutil.TeardownTestDB(t, db)
+
+	identityService, sessionService := newTestIdentityService(t, db, IdentityOptions{LinkByEmail: true})
+	userRepo := repository.NewUserRepository(db)
+	identityRepo := repository.NewUserIdentityRepository(db.DB)
+	ctx := context.Background()
+
+	target := testutil.CreateTestUser(t, db, "erin@example.com")
+	source := testutil.CreateTestUser(t, db, "erin.duplicate@example.com")
+	shared := testutil.CreateTestRole(t, db, "engineer")
+	extra := testutil.CreateTestRole(t, db, "auditor")
+	require.NoError(t, userRepo.AssignRole(ctx, target.ID, shared.ID))
+	require.NoError(t, userRepo.AssignRole(ctx, source.ID, shared.ID))
+	require.NoError(t, userRepo.AssignRole(ctx, source.ID, extra.ID))
+
+	sourceSession := testutil.CreateTestSession(t, db, source.ID)
+	require.NoError(t, identityRepo.Create(ctx, &models.UserIdentity{
+		ID: uuid.New().String(), UserID: source.ID, Provider: "saml:corp", Subject: "erin", CreatedAt: time.Now(),
+	}))
+
+	expires := time.Now().Add(24 * time.Hour)
+	consents := []*models.OAuth2Consent{
+		{ID: uuid.New().String(), UserID: target.ID, ClientID: "wiki", Scopes: models.StringSlice{"openid"}, GrantedAt: time.Now()},
+		{ID: uuid.New().String(), UserID: source.ID, ClientID: "wiki", Scopes: models.StringSlice{"openid", "email"}, ExpiresAt: &expires, GrantedAt: time.Now()},
+		{ID: uuid.New().String(), UserID: source.ID, ClientID: "crm", Scopes: models.StringSlice{"profile"}, GrantedAt: time.Now()},
+	}
+	for _, consent := range consents {
+		require.NoError(t, db.DB.Create(consent).Error)
+	}
+	require.NoError(t, repository.NewAuditLogRepository(db).Create(ctx, &models.AuditLog{
+		ID: uuid.New().String(), UserID: &source.ID, Action: "login_success", CreatedAt: time.Now(),
+	}))
+
+	_, err := identityService.MergeUsers(ctx, target.ID, target.ID)
+	assert.ErrorIs(t, err, ErrMergeSameUser)
+	_, err = identityService.MergeUsers(ctx, target.ID, uuid.New().String())
+	assert.ErrorIs(t, err, repository.ErrNotFound)
+
+	merged, err := identityService.MergeUsers(ctx, target.ID, source.ID)
+	require.NoError(t, err)
+	var roles []string
+	for _, role := range merged.Roles {
+		roles = append(roles, role.Name)
+	}
+	assert.ElementsMatch(t, []string{"engineer", "auditor"}, roles)
+
+	_, err = userRepo.GetByID(ctx, source.ID)
+	assert.ErrorIs(t, err, repository.ErrNotFound)
+
+	// The source's sign-ins end rather than carrying over to the target
+	_, err = sessionService.ValidateSession(ctx, sourceSession.SessionToken)
+	assert.Error(t, err)
+	identity, err := identityRepo.GetByProviderSubject(ctx, "saml:corp", "erin")
+	require.NoError(t, err)
+	assert.Equal(t, target.ID, identity.UserID)
+
+	// Consents to the same client are combined
+	var merges []*models.OAuth2Consent
+	require.NoError(t, db.DB.Where("user_id = ?", target.ID).Order("client_id").Find(&merges).Error)
+	require.Len(t, merges, 2)
+	assert.Equal(t, "crm", merges[0].ClientID)
+	assert.Equal(t, "wiki", merges[1].ClientID)
+	assert.ElementsMatch(t, []string{"openid", "email"}, []string(merges[1].Scopes))
+	assert.Nil(t, merges[1].ExpiresAt)
+
+	var audits int64
+	require.NoError(t, db.DB.Model(&models.AuditLog{}).Where("user_id = ?", target.ID).Count(&audits).Error)
+	assert.Equal(t, int64(1), audits)
+}
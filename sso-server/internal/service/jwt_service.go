@@ -58,15 +58,7 @@ func (s *JWTService) GenerateAccessToken(user *models.User) (string, error) {
 	now := time.Now()
 
 	// Extract roles and permissions
-	roles := make([]string, len(user.Roles))
-	permissions := make([]string, 0)
-
-	for i, role := range user.Roles {
-		roles[i] = role.Name
-		for _, perm := range role.Permissions {
-			permissions = append(permissions, perm.Name)
-		}
-	}
+	roles, permissions := RoleClaims(user.Roles)
 
 	claims := UserClaims{
 		UserID:      user.ID,
@@ -87,6 +79,21 @@ func (s *JWTService) GenerateAccessToken(user *models.User) (string, error) {
 	return token.SignedString(s.privateKey)
 }
 
+// RoleClaims flattens roles into the role and permission names carried in access tokens
+func RoleClaims(userRoles []models.Role) ([]string, []string) {
+	roles := make([]string, len(userRoles))
+	permissions := make([]string, 0)
+
+	for i, role := range userRoles {
+		roles[i] = role.Name
+		for _, perm := range role.Permissions {
+			permissions = append(permissions, perm.Name)
+		}
+	}
+
+	return roles, permissions
+}
+
 // GenerateRefreshToken creates a new refresh token (simpler claims)
 func (s *JWTService) GenerateRefreshToken(userID string) (string, error) {
 	now := time.Now()
@@ -121,6 +128,11 @@ func (s *JWTService) GenerateCustomToken(customClaims map[string]interface{}) (s
 	return tokenString, nil
 }
 
+// Issuer returns the issuer used in signed tokens
+func (s *JWTService) Issuer() string {
+	return s.issuer
+}
+
 // ValidateToken validates and parses a JWT token
 func (s *JWTService) ValidateToken(tokenString string) (*UserClaims, error) {
 	token, err := jwt.ParseWithClaims(tokenString, &UserClaims{}, func(token *jwt.Token) (interface{}, error) {
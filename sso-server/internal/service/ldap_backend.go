@@ -0,0 +1,244 @@
+package service
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+var (
+	ErrLDAPConfigRequired = errors.New("LDAP url and base DN are required")
+	ErrInvalidLDAPURL     = errors.New("invalid LDAP url")
+	ErrInsecureLDAPURL    = errors.New("LDAP url must use ldaps:// or StartTLS")
+	ErrInvalidLDAPFilter  = errors.New("LDAP user filter must contain {login}")
+)
+
+const ldapLoginPlaceholder = "{login}"
+
+// LDAPOptions configures the LDAP backend
+type LDAPOptions struct {
+	URL          string // ldaps://host:636, or ldap://host:389 with StartTLS
+	StartTLS     bool
+	CACertFile   string // PEM bundle trusted for the server certificate, in addition to the system pool
+	BindDN       string // Service account used to search; empty for anonymous search
+	BindPassword string
+	BaseDN       string
+	UserFilter   string // Finds the entry of a login, e.g. (&(objectClass=person)(mail={login}))
+	SyncFilter   string // Selects every entry sync keeps, e.g. (objectClass=person)
+	IDAttribute  string
+	EmailAttr    string
+	NameAttr     string
+	GroupAttr    string
+	Timeout      time.Duration
+}
+
+// LDAPBackend authenticates users against an LDAP directory: it binds as the
+// service account, searches for the user's entry and binds as that entry with
+// the password
+type LDAPBackend struct {
+	opts      LDAPOptions
+	tlsConfig *tls.Config
+}
+
+// NewLDAPBackend creates a new LDAPBackend. The user filter defaults to a person
+// whose mail is the login, and attribute names to entryUUID, mail, cn and memberOf.
+func NewLDAPBackend(opts LDAPOptions) (*LDAPBackend, error) {
+	if opts.URL == "" || opts.BaseDN == "" {
+		return nil, ErrLDAPConfigRequired
+	}
+	if opts.UserFilter == "" {
+		opts.UserFilter = "(&(objectClass=person)(mail={login}))"
+	}
+	if !strings.Contains(opts.UserFilter, ldapLoginPlaceholder) {
+		return nil, ErrInvalidLDAPFilter
+	}
+
+	u, err := url.Parse(opts.URL)
+	if err != nil || u.Host == "" {
+		return nil, ErrInvalidLDAPURL
+	}
+	switch u.Scheme {
+	case "ldaps":
+	case "ldap":
+		// Passwords cross the wire in the bind, so plain LDAP is for local directories only
+		if host := u.Hostname(); !opts.StartTLS && host != "localhost" && host != "127.0.0.1" {
+			return nil, ErrInsecureLDAPURL
+		}
+	default:
+		return nil, ErrInvalidLDAPURL
+	}
+
+	tlsConfig := &tls.Config{ServerName: u.Hostname(), MinVersion: tls.VersionTLS12}
+	if opts.CACertFile != "" {
+		pem, err := os.ReadFile(opts.CACertFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read LDAP CA file: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", opts.CACertFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if opts.SyncFilter == "" {
+		opts.SyncFilter = "(objectClass=person)"
+	}
+	if opts.IDAttribute == "" {
+		opts.IDAttribute = "entryUUID"
+	}
+	if opts.EmailAttr == "" {
+		opts.EmailAttr = "mail"
+	}
+	if opts.NameAttr == "" {
+		opts.NameAttr = "cn"
+	}
+	if opts.GroupAttr == "" {
+		opts.GroupAttr = "memberOf"
+	}
+	if opts.Timeout == 0 {
+		opts.Timeout = 10 * time.Second
+	}
+
+	return &LDAPBackend{opts: opts, tlsConfig: tlsConfig}, nil
+}
+
+// Name returns the provider key of LDAP links
+func (b *LDAPBackend) Name() string {
+	return "ldap"
+}
+
+// Authenticate finds the entry of login and binds as it with password
+func (b *LDAPBackend) Authenticate(ctx context.Context, login, password string) (*DirectoryAccount, error) {
+	// An empty password makes an unauthenticated bind, which most servers accept
+	if login == "" || password == "" {
+		return nil, ErrInvalidCredentials
+	}
+
+	conn, err := b.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	filter := strings.ReplaceAll(b.opts.UserFilter, ldapLoginPlaceholder, ldap.EscapeFilter(login))
+	result, err := conn.Search(b.searchRequest(filter, 2))
+	if err != nil && !ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+		return nil, fmt.Errorf("%w: search: %v", ErrDirectoryUnavailable, err)
+	}
+	// A login must name exactly one entry
+	if result == nil || len(result.Entries) != 1 {
+		return nil, ErrInvalidCredentials
+	}
+	entry := result.Entries[0]
+
+	if err := conn.Bind(entry.DN, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, fmt.Errorf("%w: bind: %v", ErrDirectoryUnavailable, err)
+	}
+
+	return b.account(entry), nil
+}
+
+// Accounts lists every entry matched by the sync filter
+func (b *LDAPBackend) Accounts(ctx context.Context) ([]*DirectoryAccount, error) {
+	conn, err := b.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	result, err := conn.SearchWithPaging(b.searchRequest(b.opts.SyncFilter, 0), 500)
+	if err != nil {
+		return nil, fmt.Errorf("%w: search: %v", ErrDirectoryUnavailable, err)
+	}
+
+	accounts := make([]*DirectoryAccount, 0, len(result.Entries))
+	for _, entry := range result.Entries {
+		account := b.account(entry)
+		if account.Subject == "" || account.Email == "" {
+			continue
+		}
+		accounts = append(accounts, account)
+	}
+	return accounts, nil
+}
+
+// connect dials the server and binds as the service account
+func (b *LDAPBackend) connect(ctx context.Context) (*ldap.Conn, error) {
+	dialer := &net.Dialer{Timeout: b.opts.Timeout}
+	if deadline, ok := ctx.Deadline(); ok {
+		dialer.Deadline = deadline
+	}
+
+	conn, err := ldap.DialURL(b.opts.URL, ldap.DialWithDialer(dialer), ldap.DialWithTLSConfig(b.tlsConfig))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrDirectoryUnavailable, err)
+	}
+	conn.SetTimeout(b.opts.Timeout)
+
+	if b.opts.StartTLS {
+		if err := conn.StartTLS(b.tlsConfig); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("%w: StartTLS: %v", ErrDirectoryUnavailable, err)
+		}
+	}
+
+	if b.opts.BindDN != "" {
+		if err := conn.Bind(b.opts.BindDN, b.opts.BindPassword); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("%w: service account bind: %v", ErrDirectoryUnavailable, err)
+		}
+	}
+
+	return conn, nil
+}
+
+func (b *LDAPBackend) searchRequest(filter string, sizeLimit int) *ldap.SearchRequest {
+	return ldap.NewSearchRequest(
+		b.opts.BaseDN,
+		ldap.ScopeWholeSubtree,
+		ldap.NeverDerefAliases,
+		sizeLimit,
+		int(b.opts.Timeout.Seconds()),
+		false,
+		filter,
+		[]string{b.opts.IDAttribute, b.opts.EmailAttr, b.opts.NameAttr, b.opts.GroupAttr},
+		nil,
+	)
+}
+
+// account maps an entry's attributes to a directory account
+func (b *LDAPBackend) account(entry *ldap.Entry) *DirectoryAccount {
+	return &DirectoryAccount{
+		Subject: ldapIdentifier(entry.GetRawAttributeValue(b.opts.IDAttribute)),
+		Email:   strings.ToLower(entry.GetAttributeValue(b.opts.EmailAttr)),
+		Name:    entry.GetAttributeValue(b.opts.NameAttr),
+		Groups:  entry.GetAttributeValues(b.opts.GroupAttr),
+	}
+}
+
+// ldapIdentifier returns a textual identifier as is and binary ones, such as
+// Active Directory's objectGUID, hex encoded
+func ldapIdentifier(raw []byte) string {
+	if utf8.Valid(raw) && !strings.ContainsFunc(string(raw), func(r rune) bool { return r < 0x20 }) {
+		return string(raw)
+	}
+	return hex.EncodeToString(raw)
+}
@@ -0,0 +1,514 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sso-project/sso-server/internal/repository"
+	"github.com/sso-project/sso-server/internal/testutil"
+)
+
+// testLDAPEntry is a directory entry with the password it binds with
+type testLDAPEntry struct {
+	DN         string
+	Password   string
+	Attributes map[string][]string
+}
+
+// testLDAPServer is a minimal LDAPv3 server: simple bind, and subtree search with
+// and/or/not/equality/presence filters
+type testLDAPServer struct {
+	URL      string
+	listener net.Listener
+
+	mu      sync.Mutex
+	entries []*testLDAPEntry
+}
+
+func newTestLDAPServer(t *testing.T, entries ...*testLDAPEntry) *testLDAPServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	server := &testLDAPServer{
+		URL:      "ldap://" + listener.Addr().String(),
+		listener: listener,
+		entries:  entries,
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.serve(conn)
+		}
+	}()
+	t.Cleanup(func() { listener.Close() })
+	return server
+}
+
+// set replaces the entry with the same DN, or adds entry
+func (s *testLDAPServer) set(entry *testLDAPEntry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, e := range s.entries {
+		if e.DN == entry.DN {
+			s.entries[i] = entry
+			return
+		}
+	}
+	s.entries = append(s.entries, entry)
+}
+
+func (s *testLDAPServer) remove(dn string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, e := range s.entries {
+		if e.DN == dn {
+			s.entries = append(s.entries[:i], s.entries[i+1:]...)
+			return
+		}
+	}
+}
+
+func (s *testLDAPServer) serve(conn net.Conn) {
+	defer conn.Close()
+	bound := false
+
+	for {
+		packet, err := ber.ReadPacket(conn)
+		if err != nil || len(packet.Children) < 2 {
+			return
+		}
+		id := packet.Children[0].Value.(int64)
+		op := packet.Children[1]
+
+		switch op.Tag {
+		case 0: // BindRequest
+			dn := op.Children[1].Value.(string)
+			password := op.Children[2].Data.String()
+			code := uint64(49) // invalidCredentials
+			if entry := s.lookup(dn); entry != nil && password != "" && entry.Password == password {
+				code = 0
+				bound = true
+			}
+			s.write(conn, id, s.result(1, code))
+		case 2: // UnbindRequest
+			return
+		case 3: // SearchRequest
+			if !bound {
+				s.write(conn, id, s.result(5, 50)) // insufficientAccessRights
+				continue
+			}
+			base := strings.ToLower(op.Children[0].Value.(string))
+			sizeLimit := int(op.Children[3].Value.(int64))
+			code := uint64(0)
+			sent := 0
+			for _, entry := range s.snapshot() {
+				if !strings.HasSuffix(strings.ToLower(entry.DN), base) || !matchesFilter(op.Children[6], entry) {
+					continue
+				}
+				if sizeLimit > 0 && sent == sizeLimit {
+					code = 4 // sizeLimitExceeded
+					break
+				}
+				s.write(conn, id, searchEntry(entry))
+				sent++
+			}
+			s.write(conn, id, s.result(5, code))
+		default:
+			s.write(conn, id, s.result(op.Tag+1, 2)) // protocolError
+		}
+	}
+}
+
+func (s *testLDAPServer) lookup(dn string) *testLDAPEntry {
+	for _, entry := range s.snapshot() {
+		if strings.EqualFold(entry.DN, dn) {
+			return entry
+		}
+	}
+	return nil
+}
+
+func (s *testLDAPServer) snapshot() []*testLDAPEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*testLDAPEntry(nil), s.entries...)
+}
+
+func (s *testLDAPServer) result(tag ber.Tag, code uint64) *ber.Packet {
+	op := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "Result")
+	op.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, code, "Result Code"))
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "Matched DN"))
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "Diagnostic Message"))
+	return op
+}
+
+func (s *testLDAPServer) write(conn net.Conn, id int64, op *ber.Packet) {
+	message := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Response")
+	message.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, id, "Message ID"))
+	message.AppendChild(op)
+	conn.Write(message.Bytes())
+}
+
+func searchEntry(entry *testLDAPEntry) *ber.Packet {
+	op := ber.Encode(ber.ClassApplication, ber.TypeConstructed, 4, nil, "Search Result Entry")
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, entry.DN, "DN"))
+	attributes := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attributes")
+	for name, values := range entry.Attributes {
+		attribute := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attribute")
+		attribute.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, name, "Type"))
+		set := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "Values")
+		for _, value := range values {
+			set.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, value, "Value"))
+		}
+		attribute.AppendChild(set)
+		attributes.AppendChild(attribute)
+	}
+	op.AppendChild(attributes)
+	return op
+}
+
+func attributeOf(entry *testLDAPEntry, name string) []string {
+	for attr, values := range entry.Attributes {
+		if strings.EqualFold(attr, name) {
+			return values
+		}
+	}
+	return nil
+}
+
+func matchesFilter(filter *ber.Packet, entry *testLDAPEntry) bool {
+	switch filter.Tag {
+	case 0: // and
+		for _, child := range filter.Children {
+			if !matchesFilter(child, entry) {
+				return false
+			}
+		}
+		return true
+	case 1: // or
+		for _, child := range filter.Children {
+			if matchesFilter(child, entry) {
+				return true
+			}
+		}
+		return false
+	case 2: // not
+		return !matchesFilter(filter.Children[0], entry)
+	case 3: // equalityMatch
+		want := filter.Children[1].Data.String()
+		for _, value := range attributeOf(entry, filter.Children[0].Data.String()) {
+			if strings.EqualFold(value, want) {
+				return true
+			}
+		}
+		return false
+	case 7: // present
+		return len(attributeOf(entry, filter.Data.String())) > 0
+	}
+	return false
+}
+
+func testLDAPPerson(uid, uuid, email, name, password string, groups ...string) *testLDAPEntry {
+	return &testLDAPEntry{
+		DN:       "uid=" + uid + ",ou=people,dc=example,dc=com",
+		Password: password,
+		Attributes: map[string][]string{
+			"objectClass": {"person", "inetOrgPerson"},
+			"uid":         {uid},
+			"entryUUID":   {uuid},
+			"mail":        {email},
+			"cn":          {name},
+			"memberOf":    groups,
+		},
+	}
+}
+
+func testLDAPOptions(url string) LDAPOptions {
+	return LDAPOptions{
+		URL:          url,
+		BindDN:       "cn=sso,ou=services,dc=example,dc=com",
+		BindPassword: "service-secret",
+		BaseDN:       "dc=example,dc=com",
+		UserFilter:   "(&(objectClass=person)(mail={login}))",
+		Timeout:      5 * time.Second,
+	}
+}
+
+const engineersGroup = "cn=engineers,ou=groups,dc=example,dc=com"
+
+func TestLDAPBackend_Authenticate(t *testing.T) {
+	server := newTestLDAPServer(t,
+		&testLDAPEntry{DN: "cn=sso,ou=services,dc=example,dc=com", Password: "service-secret"},
+		testLDAPPerson("alice", "6f1c", "Alice@example.com", "Alice Doe", "alice-pw", engineersGroup),
+		testLDAPPerson("twin1", "aa01", "twin@example.com", "Twin One", "twin-pw"),
+		testLDAPPerson("twin2", "aa02", "twin@example.com", "Twin Two", "twin-pw"),
+	)
+	ctx := context.Background()
+
+	_, err := NewLDAPBackend(testLDAPOptions("ldap://ldap.example.com"))
+	assert.ErrorIs(t, err, ErrInsecureLDAPURL)
+	opts := testLDAPOptions("ldap://ldap.example.com")
+	opts.StartTLS = true
+	_, err = NewLDAPBackend(opts)
+	assert.NoError(t, err)
+	opts = testLDAPOptions(server.URL)
+	opts.UserFilter = "(mail=*)"
+	_, err = NewLDAPBackend(opts)
+	assert.ErrorIs(t, err, ErrInvalidLDAPFilter)
+
+	backend, err := NewLDAPBackend(testLDAPOptions(server.URL))
+	require.NoError(t, err)
+
+	account, err := backend.Authenticate(ctx, "alice@example.com", "alice-pw")
+	require.NoError(t, err)
+	assert.Equal(t, "6f1c", account.Subject)
+	assert.Equal(t, "alice@example.com", account.Email)
+	assert.Equal(t, "Alice Doe", account.Name)
+	assert.Equal(t, []string{engineersGroup}, account.Groups)
+
+	_, err = backend.Authenticate(ctx, "alice@example.com", "wrong")
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+	_, err = backend.Authenticate(ctx, "alice@example.com", "")
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+	_, err = backend.Authenticate(ctx, "nobody@example.com", "alice-pw")
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+
+	// Filter metacharacters in the login cannot widen the search
+	_, err = backend.Authenticate(ctx, "*", "alice-pw")
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+
+	// A login matching several entries is refused
+	_, err = backend.Authenticate(ctx, "twin@example.com", "twin-pw")
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+
+	accounts, err := backend.Accounts(ctx)
+	require.NoError(t, err)
+	assert.Len(t, accounts, 3)
+
+	opts = testLDAPOptions(server.URL)
+	opts.BindPassword = "wrong"
+	misconfigured, err := NewLDAPBackend(opts)
+	require.NoError(t, err)
+	_, err = misconfigured.Authenticate(ctx, "alice@example.com", "alice-pw")
+	assert.ErrorIs(t, err, ErrDirectoryUnavailable)
+}
+
+func TestAuthService_LoginWithDirectory(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+	defer testutil.TeardownTestDB(t, db)
+
+	server := newTestLDAPServer(t,
+		&testLDAPEntry{DN: "cn=sso,ou=services,dc=example,dc=com", Password: "service-secret"},
+		testLDAPPerson("alice", "6f1c", "alice@example.com", "Alice Doe", "alice-pw", engineersGroup),
+		testLDAPPerson("bob", "7a2d", "bob@example.com", "Bob Roe", "bob-ldap-pw"),
+	)
+	opts := testLDAPOptions(server.URL)
+	opts.SyncFilter = "(&(objectClass=person)(mail=*))"
+	backend, err := NewLDAPBackend(opts)
+	require.NoError(t, err)
+
+	userRepo := repository.NewUserRepository(db)
+	identityRepo := repository.NewUserIdentityRepository(db.DB)
+	auditRepo := repository.NewAuditLogRepository(db)
+	sessionService := NewSessionService(repository.NewDatabaseSessionStore(db), time.Hour)
+	directory := NewDirectoryService(backend, identityRepo, userRepo, repository.NewRoleRepository(db), sessionService, auditRepo,
+		map[string]string{engineersGroup: "engineer"})
+	authService := NewAuthService(userRepo, sessionService, auditRepo, 3, 30*time.Minute)
+	authService.AddDirectory(directory)
+	ctx := context.Background()
+	testutil.CreateTestRole(t, db, "engineer")
+
+	// Local users keep signing in with their own password
+	testutil.CreateTestUserWithPassword(t, db, "carol@example.com", "CarolPassword1!")
+	_, err = authService.Login(ctx, "carol@example.com", "CarolPassword1!", "127.0.0.1", "test")
+	require.NoError(t, err)
+
+	// A directory user signing in for the first time gets a user with mapped roles
+	result, err := authService.Login(ctx, "alice@example.com", "alice-pw", "127.0.0.1", "test")
+	require.NoError(t, err)
+	assert.Equal(t, "Alice Doe", result.User.Name)
+	assert.NotEmpty(t, result.Session.SessionToken)
+	alice, err := userRepo.GetByEmail(ctx, "alice@example.com")
+	require.NoError(t, err)
+	require.Len(t, alice.Roles, 1)
+	assert.Equal(t, "engineer", alice.Roles[0].Name)
+
+	_, err = authService.Login(ctx, "alice@example.com", "wrong", "127.0.0.1", "test")
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+
+	// A directory password does not sign in a local user with the same email
+	testutil.CreateTestUserWithPassword(t, db, "bob@example.com", "BobLocalPassword1!")
+	_, err = authService.Login(ctx, "bob@example.com", "bob-ldap-pw", "127.0.0.1", "test")
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+	_, err = authService.Login(ctx, "bob@example.com", "BobLocalPassword1!", "127.0.0.1", "test")
+	require.NoError(t, err)
+
+	// Once sync links the user, only the directory password works
+	_, err = directory.Sync(ctx)
+	require.NoError(t, err)
+	result, err = authService.Login(ctx, "bob@example.com", "bob-ldap-pw", "127.0.0.1", "test")
+	require.NoError(t, err)
+	assert.Equal(t, "Bob Roe", result.User.Name)
+	_, err = authService.Login(ctx, "bob@example.com", "BobLocalPassword1!", "127.0.0.1", "test")
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+
+	// Directory failures count toward the lockout, an unreachable directory does not
+	_, err = authService.Login(ctx, "bob@example.com", "wrong", "127.0.0.1", "test")
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+	server.listener.Close()
+	for range 3 {
+		_, err = authService.Login(ctx, "bob@example.com", "bob-ldap-pw", "127.0.0.1", "test")
+		assert.True(t, errors.Is(err, ErrDirectoryUnavailable))
+	}
+	bob, err := userRepo.GetByEmail(ctx, "bob@example.com")
+	require.NoError(t, err)
+	assert.False(t, bob.IsLocked)
+	assert.Equal(t, 2, bob.FailedLoginAttempts)
+}
+
+func TestDirectoryService_Sync(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+	defer testutil.TeardownTestDB(t, db)
+
+	alice := testLDAPPerson("alice", "6f1c", "alice@example.com", "Alice Doe", "alice-pw", engineersGroup)
+	server := newTestLDAPServer(t,
+		&testLDAPEntry{DN: "cn=sso,ou=services,dc=example,dc=com", Password: "service-secret"},
+		alice,
+		testLDAPPerson("bob", "7a2d", "bob@example.com", "Bob Roe", "bob-pw"),
+	)
+	opts := testLDAPOptions(server.URL)
+	opts.SyncFilter = "(&(objectClass=person)(mail=*))"
+	backend, err := NewLDAPBackend(opts)
+	require.NoError(t, err)
+
+	userRepo := repository.NewUserRepository(db)
+	sessionService := NewSessionService(repository.NewDatabaseSessionStore(db), time.Hour)
+	directory := NewDirectoryService(backend, repository.NewUserIdentityRepository(db.DB), userRepo, repository.NewRoleRepository(db), sessionService,
+		repository.NewAuditLogRepository(db), map[string]string{engineersGroup: "engineer"})
+	ctx := context.Background()
+	testutil.CreateTestRole(t, db, "engineer")
+	admin := testutil.CreateTestRole(t, db, "admin")
+
+	// An existing local user with a directory address is linked rather than duplicated
+	local := testutil.CreateTestUser(t, db, "bob@example.com")
+	require.NoError(t, userRepo.AssignRole(ctx, local.ID, admin.ID))
+
+	result, err := directory.Sync(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 2, result.Accounts)
+	assert.Equal(t, 1, result.Created)
+	assert.Equal(t, 1, result.Updated)
+	assert.Equal(t, result, directory.LastSync())
+
+	user, err := userRepo.GetByEmail(ctx, "alice@example.com")
+	require.NoError(t, err)
+	assert.True(t, user.IsActive)
+	require.Len(t, user.Roles, 1)
+	assert.Equal(t, "engineer", user.Roles[0].Name)
+
+	// Renames and group changes are applied; roles no group maps to are kept
+	server.set(testLDAPPerson("alice", "6f1c", "alice.doe@example.com", "Alice Doe-Smith", "alice-pw"))
+	server.set(testLDAPPerson("bob", "7a2d", "bob@example.com", "Bob Roe", "bob-pw", engineersGroup))
+	result, err = directory.Sync(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 0, result.Created)
+	assert.Equal(t, 2, result.Updated)
+
+	user, err = userRepo.GetByID(ctx, user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "alice.doe@example.com", user.Email)
+	assert.Equal(t, "Alice Doe-Smith", user.Name)
+	assert.Empty(t, user.Roles)
+
+	bob, err := userRepo.GetByID(ctx, local.ID)
+	require.NoError(t, err)
+	assert.Len(t, bob.Roles, 2)
+
+	// Users gone from the directory are deactivated and signed out
+	session, err := sessionService.CreateSession(ctx, bob.ID, "127.0.0.1", "test")
+	require.NoError(t, err)
+	server.remove("uid=bob,ou=people,dc=example,dc=com")
+	result, err = directory.Sync(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, result.Deactivated)
+
+	bob, err = userRepo.GetByID(ctx, local.ID)
+	require.NoError(t, err)
+	assert.False(t, bob.IsActive)
+	_, err = sessionService.ValidateSession(ctx, session.SessionToken)
+	assert.Error(t, err)
+
+	// and reactivated when they come back
+	server.set(testLDAPPerson("bob", "7a2d", "bob@example.com", "Bob Roe", "bob-pw", engineersGroup))
+	_, err = directory.Sync(ctx)
+	require.NoError(t, err)
+	bob, err = userRepo.GetByID(ctx, local.ID)
+	require.NoError(t, err)
+	assert.True(t, bob.IsActive)
+	assert.Nil(t, bob.DirectoryRemovedAt)
+
+	// An empty directory answer is treated as a failure, not as everyone leaving
+	server.remove("uid=bob,ou=people,dc=example,dc=com")
+	server.remove(alice.DN)
+	_, err = directory.Sync(ctx)
+	assert.ErrorIs(t, err, ErrDirectoryEmpty)
+	user, err = userRepo.GetByID(ctx, user.ID)
+	require.NoError(t, err)
+	assert.True(t, user.IsActive)
+}
+
+func TestDirectoryService_KeepsLocallyDeactivatedUsers(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+	defer testutil.TeardownTestDB(t, db)
+
+	server := newTestLDAPServer(t,
+		&testLDAPEntry{DN: "cn=sso,ou=services,dc=example,dc=com", Password: "service-secret"},
+		testLDAPPerson("alice", "6f1c", "alice@example.com", "Alice Doe", "alice-pw"),
+	)
+	opts := testLDAPOptions(server.URL)
+	opts.SyncFilter = "(&(objectClass=person)(mail=*))"
+	backend, err := NewLDAPBackend(opts)
+	require.NoError(t, err)
+
+	userRepo := repository.NewUserRepository(db)
+	auditRepo := repository.NewAuditLogRepository(db)
+	sessionService := NewSessionService(repository.NewDatabaseSessionStore(db), time.Hour)
+	directory := NewDirectoryService(backend, repository.NewUserIdentityRepository(db.DB), userRepo, repository.NewRoleRepository(db), sessionService,
+		auditRepo, nil)
+	authService := NewAuthService(userRepo, sessionService, auditRepo, 3, 30*time.Minute)
+	authService.AddDirectory(directory)
+	ctx := context.Background()
+
+	_, err = directory.Sync(ctx)
+	require.NoError(t, err)
+	alice, err := userRepo.GetByEmail(ctx, "alice@example.com")
+	require.NoError(t, err)
+
+	// An administrator deactivates a compromised account that is still in the directory
+	require.NoError(t, userRepo.UpdateUserStatus(ctx, alice.ID, false))
+
+	result, err := directory.Sync(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 0, result.Updated)
+	alice, err = userRepo.GetByID(ctx, alice.ID)
+	require.NoError(t, err)
+	assert.False(t, alice.IsActive)
+
+	_, err = authService.Login(ctx, "alice@example.com", "alice-pw", "127.0.0.1", "test")
+	assert.ErrorIs(t, err, ErrAccountInactive)
+	alice, err = userRepo.GetByID(ctx, alice.ID)
+	require.NoError(t, err)
+	assert.False(t, alice.IsActive)
+}
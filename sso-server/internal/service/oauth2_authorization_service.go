@@ -25,6 +25,7 @@ type OAuth2AuthorizationService struct {
 	codeRepo     *repository.OAuth2CodeRepository
 	clientRepo   *repository.OAuth2ClientRepository
 	consentRepo  *repository.OAuth2ConsentRepository
+	accountRepo  *repository.ServiceAccountRepository
 	tokenService *OAuth2TokenService
 	codeExpiry   time.Duration
 	enforcePKCE  bool
@@ -35,6 +36,7 @@ func NewOAuth2AuthorizationService(
 	codeRepo *repository.OAuth2CodeRepository,
 	clientRepo *repository.OAuth2ClientRepository,
 	consentRepo *repository.OAuth2ConsentRepository,
+	accountRepo *repository.ServiceAccountRepository,
 	tokenService *OAuth2TokenService,
 	codeExpiry time.Duration,
 	enforcePKCE bool,
@@ -43,6 +45,7 @@ func NewOAuth2AuthorizationService(
 		codeRepo:     codeRepo,
 		clientRepo:   clientRepo,
 		consentRepo:  consentRepo,
+		accountRepo:  accountRepo,
 		tokenService: tokenService,
 		codeExpiry:   codeExpiry,
 		enforcePKCE:  enforcePKCE,
@@ -173,12 +176,19 @@ func (s *OAuth2AuthorizationService) ExchangeCodeForTokens(
 		return nil, err
 	}
 
+	// Generate ID token for OpenID Connect requests
+	idToken, err := s.tokenService.GenerateIDToken(ctx, clientID, authCode.UserID, authCode.Scopes)
+	if err != nil {
+		return nil, err
+	}
+
 	return &TokenResponse{
 		AccessToken:  accessToken,
 		TokenType:    "Bearer",
-		ExpiresIn:    int64(s.tokenService.accessTokenExpiry.Seconds()),
+		ExpiresIn:    ExpiresIn(tokenModel),
 		RefreshToken: refreshToken,
 		Scope:        scopesToString(authCode.Scopes),
+		IDToken:      idToken,
 	}, nil
 }
 
@@ -187,6 +197,17 @@ func (s *OAuth2AuthorizationService) ClientCredentialsGrant(
 	ctx context.Context,
 	clientID string,
 	scopes []string,
+) (*TokenResponse, error) {
+	return s.IssueClientToken(ctx, clientID, scopes, nil)
+}
+
+// IssueClientToken issues an access token that acts as the client itself, as for
+// the client_credentials and JWT bearer grants
+func (s *OAuth2AuthorizationService) IssueClientToken(
+	ctx context.Context,
+	clientID string,
+	scopes []string,
+	extraClaims map[string]interface{},
 ) (*TokenResponse, error) {
 	// Validate client
 	client, err := s.clientRepo.GetByClientID(ctx, clientID)
@@ -198,7 +219,7 @@ func (s *OAuth2AuthorizationService) ClientCredentialsGrant(
 		return nil, errors.New("client is not active")
 	}
 
-	// Client credentials flow doesn't have a user
+	// Client tokens don't have a user
 	// Validate scopes
 	if len(scopes) > 0 {
 		valid, err := s.clientRepo.ValidateScopes(ctx, clientID, scopes)
@@ -210,13 +231,17 @@ func (s *OAuth2AuthorizationService) ClientCredentialsGrant(
 		}
 	}
 
+	// Clients with an active service account act with its roles
+	account, err := s.accountRepo.GetByClientID(ctx, clientID)
+	if err != nil && !errors.Is(err, repository.ErrServiceAccountNotFound) {
+		return nil, err
+	}
+	if account != nil && !account.IsActive {
+		account = nil
+	}
+
 	// Generate access token (no user ID, no refresh token)
-	accessToken, _, err := s.tokenService.GenerateAccessToken(
-		ctx,
-		clientID,
-		nil, // No user for client credentials
-		scopes,
-	)
+	accessToken, tokenModel, err := s.tokenService.GenerateClientAccessToken(ctx, clientID, account, scopes, extraClaims)
 	if err != nil {
 		return nil, err
 	}
@@ -224,7 +249,7 @@ func (s *OAuth2AuthorizationService) ClientCredentialsGrant(
 	return &TokenResponse{
 		AccessToken: accessToken,
 		TokenType:   "Bearer",
-		ExpiresIn:   int64(s.tokenService.accessTokenExpiry.Seconds()),
+		ExpiresIn:   ExpiresIn(tokenModel),
 		Scope:       scopesToString(scopes),
 	}, nil
 }
@@ -5,6 +5,7 @@ import (
 	"crypto/rand"
 	"encoding/base64"
 	"errors"
+	"net/url"
 
 	"github.com/google/uuid"
 	"github.com/sso-project/sso-server/internal/models"
@@ -15,12 +16,15 @@ import (
 var (
 	ErrClientNotFound = errors.New("oauth2 client not found")
 	ErrInvalidClient  = errors.New("invalid client credentials")
+
+	ErrInvalidBrandingURI = errors.New("branding URIs must be absolute http(s) URLs")
 )
 
 // OAuth2ClientService handles OAuth2 client business logic
 type OAuth2ClientService struct {
-	clientRepo *repository.OAuth2ClientRepository
-	scopeRepo  *repository.OAuth2ScopeRepository
+	clientRepo       *repository.OAuth2ClientRepository
+	scopeRepo        *repository.OAuth2ScopeRepository
+	pairwiseSubjects bool
 }
 
 // NewOAuth2ClientService creates a new OAuth2ClientService
@@ -34,6 +38,12 @@ func NewOAuth2ClientService(
 	}
 }
 
+// EnablePairwiseSubjects lets clients register with subject_type=pairwise. Call
+// it only once a dedicated pairwise secret is configured.
+func (s *OAuth2ClientService) EnablePairwiseSubjects() {
+	s.pairwiseSubjects = true
+}
+
 // RegisterClientRequest represents a client registration request
 type RegisterClientRequest struct {
 	Name          string   `json:"name"`
@@ -43,6 +53,42 @@ type RegisterClientRequest struct {
 	GrantTypes    []string `json:"grant_types"`
 	IsPublic      bool     `json:"is_public"`
 	OwnerUserID   *string  `json:"owner_user_id,omitempty"`
+
+	SubjectType      string `json:"subject_type"`
+	SectorIdentifier string `json:"sector_identifier"`
+
+	ConsentSettings
+	TokenSettings
+	ClientBranding
+}
+
+// ConsentSettings controls when and for how long users are asked for consent
+type ConsentSettings struct {
+	IsFirstParty    bool     `json:"is_first_party"`
+	ConsentPolicy   string   `json:"consent_policy"`
+	ConsentLifetime int      `json:"consent_lifetime"` // Seconds; 0 means consents never expire
+	PolicyVersion   string   `json:"policy_version"`
+	RequiredScopes  []string `json:"required_scopes"`
+}
+
+// TokenSettings overrides the server-wide token lifetimes (in seconds) for a client
+// and chooses between JWT and opaque reference access tokens
+type TokenSettings struct {
+	AccessTokenTTL     int    `json:"access_token_ttl"`
+	IDTokenTTL         int    `json:"id_token_ttl"`
+	RefreshTokenTTL    int    `json:"refresh_token_ttl"`
+	RefreshAbsoluteTTL int    `json:"refresh_absolute_ttl"`
+	RefreshIdleTTL     int    `json:"refresh_idle_ttl"`
+	AccessTokenFormat  string `json:"access_token_format"`
+}
+
+// ClientBranding holds what the hosted login and consent pages show for a client
+type ClientBranding struct {
+	LogoURI    string `json:"logo_uri"`
+	PolicyURI  string `json:"policy_uri"`
+	TosURI     string `json:"tos_uri"`
+	SupportURI string `json:"support_uri"`
+	Theme      string `json:"theme"`
 }
 
 // RegisterClient creates a new OAuth2 client
@@ -60,6 +106,10 @@ func (s *OAuth2ClientService) RegisterClient(ctx context.Context, req RegisterCl
 		}
 	}
 
+	if err := validateBranding(req.ClientBranding); err != nil {
+		return nil, "", err
+	}
+
 	// Validate scopes exist
 	if len(req.AllowedScopes) > 0 {
 		valid, err := s.scopeRepo.ValidateScopes(ctx, req.AllowedScopes)
@@ -93,6 +143,44 @@ func (s *OAuth2ClientService) RegisterClient(ctx context.Context, req RegisterCl
 		IsPublic:      req.IsPublic,
 		IsActive:      true,
 		OwnerUserID:   req.OwnerUserID,
+
+		SubjectType:      req.SubjectType,
+		SectorIdentifier: req.SectorIdentifier,
+
+		LogoURI:    req.LogoURI,
+		PolicyURI:  req.PolicyURI,
+		TosURI:     req.TosURI,
+		SupportURI: req.SupportURI,
+		Theme:      req.Theme,
+
+		IsFirstParty:    req.IsFirstParty,
+		ConsentPolicy:   req.ConsentPolicy,
+		ConsentLifetime: req.ConsentLifetime,
+		PolicyVersion:   req.PolicyVersion,
+		RequiredScopes:  req.RequiredScopes,
+
+		AccessTokenTTL:     req.AccessTokenTTL,
+		IDTokenTTL:         req.IDTokenTTL,
+		RefreshTokenTTL:    req.RefreshTokenTTL,
+		RefreshAbsoluteTTL: req.RefreshAbsoluteTTL,
+		RefreshIdleTTL:     req.RefreshIdleTTL,
+		AccessTokenFormat:  req.AccessTokenFormat,
+	}
+
+	// Pairwise clients need a single sector to derive subjects from
+	if err := validateSubjectSettings(client); err != nil {
+		return nil, "", err
+	}
+	if client.SubjectType == SubjectTypePairwise && !s.pairwiseSubjects {
+		return nil, "", ErrPairwiseSecretMissing
+	}
+
+	if err := validateConsentSettings(client); err != nil {
+		return nil, "", err
+	}
+
+	if err := validateTokenSettings(client); err != nil {
+		return nil, "", err
 	}
 
 	if err := s.clientRepo.Create(ctx, client); err != nil {
@@ -160,6 +248,81 @@ func (s *OAuth2ClientService) RegenerateClientSecret(ctx context.Context, client
 	return newSecret, nil
 }
 
+// UpdateBranding replaces the branding shown for a client on hosted pages
+func (s *OAuth2ClientService) UpdateBranding(ctx context.Context, clientID string, branding ClientBranding) (*models.OAuth2Client, error) {
+	if err := validateBranding(branding); err != nil {
+		return nil, err
+	}
+
+	client, err := s.clientRepo.GetByClientID(ctx, clientID)
+	if err != nil {
+		return nil, err
+	}
+
+	client.LogoURI = branding.LogoURI
+	client.PolicyURI = branding.PolicyURI
+	client.TosURI = branding.TosURI
+	client.SupportURI = branding.SupportURI
+	client.Theme = branding.Theme
+
+	if err := s.clientRepo.Update(ctx, client); err != nil {
+		return nil, err
+	}
+
+	return client, nil
+}
+
+// UpdateConsentPolicy changes when a client's users are asked for consent.
+// Changing the policy version makes every existing consent for the client stale.
+func (s *OAuth2ClientService) UpdateConsentPolicy(ctx context.Context, clientID string, settings ConsentSettings) (*models.OAuth2Client, error) {
+	client, err := s.clientRepo.GetByClientID(ctx, clientID)
+	if err != nil {
+		return nil, err
+	}
+
+	client.IsFirstParty = settings.IsFirstParty
+	client.ConsentPolicy = settings.ConsentPolicy
+	client.ConsentLifetime = settings.ConsentLifetime
+	client.PolicyVersion = settings.PolicyVersion
+	client.RequiredScopes = settings.RequiredScopes
+
+	if err := validateConsentSettings(client); err != nil {
+		return nil, err
+	}
+
+	if err := s.clientRepo.Update(ctx, client); err != nil {
+		return nil, err
+	}
+
+	return client, nil
+}
+
+// UpdateTokenSettings changes a client's token lifetimes and access token format.
+// Tokens already issued keep their expiry.
+func (s *OAuth2ClientService) UpdateTokenSettings(ctx context.Context, clientID string, settings TokenSettings) (*models.OAuth2Client, error) {
+	client, err := s.clientRepo.GetByClientID(ctx, clientID)
+	if err != nil {
+		return nil, err
+	}
+
+	client.AccessTokenTTL = settings.AccessTokenTTL
+	client.IDTokenTTL = settings.IDTokenTTL
+	client.RefreshTokenTTL = settings.RefreshTokenTTL
+	client.RefreshAbsoluteTTL = settings.RefreshAbsoluteTTL
+	client.RefreshIdleTTL = settings.RefreshIdleTTL
+	client.AccessTokenFormat = settings.AccessTokenFormat
+
+	if err := validateTokenSettings(client); err != nil {
+		return nil, err
+	}
+
+	if err := s.clientRepo.Update(ctx, client); err != nil {
+		return nil, err
+	}
+
+	return client, nil
+}
+
 // RevokeClient deactivates a client
 func (s *OAuth2ClientService) RevokeClient(ctx context.Context, clientID string) error {
 	return s.clientRepo.Delete(ctx, clientID)
@@ -175,6 +338,52 @@ func (s *OAuth2ClientService) ValidateScopes(ctx context.Context, clientID strin
 	return s.clientRepo.ValidateScopes(ctx, clientID, requestedScopes)
 }
 
+// ResolveScopes returns the requested scopes, or the default scopes the client is
+// allowed to use when the request omits scope
+func (s *OAuth2ClientService) ResolveScopes(ctx context.Context, clientID string, requestedScopes []string) ([]string, error) {
+	if len(requestedScopes) > 0 {
+		return requestedScopes, nil
+	}
+
+	client, err := s.clientRepo.GetByClientID(ctx, clientID)
+	if err != nil {
+		return nil, err
+	}
+
+	defaults, err := s.scopeRepo.GetDefaultScopes(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	allowedMap := make(map[string]bool)
+	for _, scope := range client.AllowedScopes {
+		allowedMap[scope] = true
+	}
+
+	scopes := []string{}
+	for _, scope := range defaults {
+		if allowedMap[scope.Name] {
+			scopes = append(scopes, scope.Name)
+		}
+	}
+
+	return scopes, nil
+}
+
+// validateBranding ensures branding links are absolute http(s) URLs
+func validateBranding(branding ClientBranding) error {
+	for _, raw := range []string{branding.LogoURI, branding.PolicyURI, branding.TosURI, branding.SupportURI} {
+		if raw == "" {
+			continue
+		}
+		u, err := url.Parse(raw)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return ErrInvalidBrandingURI
+		}
+	}
+	return nil
+}
+
 // generateClientID generates a random client ID
 func (s *OAuth2ClientService) generateClientID() string {
 	b := make([]byte, 16)
@@ -0,0 +1,62 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sso-project/sso-server/internal/repository"
+	"github.com/sso-project/sso-server/internal/testutil"
+)
+
+func TestOAuth2ClientService_Branding(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+	defer testutil.TeardownTestDB(t, db)
+
+	clientService := NewOAuth2ClientService(repository.NewOAuth2ClientRepository(db.DB), repository.NewOAuth2ScopeRepository(db.DB))
+	ctx := context.Background()
+
+	// Branding links must be absolute http(s) URLs
+	_, _, err := clientService.RegisterClient(ctx, RegisterClientRequest{
+		Name:           "Acme",
+		RedirectURIs:   []string{"https://acme.example.com/cb"},
+		GrantTypes:     []string{"authorization_code"},
+		ClientBranding: ClientBranding{LogoURI: "javascript:alert(1)"},
+	})
+	assert.ErrorIs(t, err, ErrInvalidBrandingURI)
+
+	client, _, err := clientService.RegisterClient(ctx, RegisterClientRequest{
+		Name:         "Acme",
+		RedirectURIs: []string{"https://acme.example.com/cb"},
+		GrantTypes:   []string{"authorization_code"},
+		ClientBranding: ClientBranding{
+			LogoURI:   "https://acme.example.com/logo.png",
+			PolicyURI: "https://acme.example.com/privacy",
+			Theme:     "midnight",
+		},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "https://acme.example.com/logo.png", client.LogoURI)
+	assert.Equal(t, "midnight", client.Theme)
+
+	_, err = clientService.UpdateBranding(ctx, client.ClientID, ClientBranding{SupportURI: "/support"})
+	assert.ErrorIs(t, err, ErrInvalidBrandingURI)
+
+	// Updating replaces every branding field
+	updated, err := clientService.UpdateBranding(ctx, client.ClientID, ClientBranding{
+		TosURI: "https://acme.example.com/terms",
+	})
+	require.NoError(t, err)
+	assert.Empty(t, updated.LogoURI)
+	assert.Empty(t, updated.Theme)
+
+	stored, err := clientService.GetClient(ctx, client.ClientID)
+	require.NoError(t, err)
+	assert.Equal(t, "https://acme.example.com/terms", stored.TosURI)
+	assert.Empty(t, stored.PolicyURI)
+
+	_, err = clientService.UpdateBranding(ctx, "missing", ClientBranding{})
+	assert.ErrorIs(t, err, repository.ErrClientNotFound)
+}
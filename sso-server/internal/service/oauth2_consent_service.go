@@ -3,11 +3,33 @@ package service
 import (
 	"context"
 	"errors"
+	"time"
 
+	"github.com/google/uuid"
 	"github.com/sso-project/sso-server/internal/models"
 	"github.com/sso-project/sso-server/internal/repository"
 )
 
+const (
+	ConsentPolicyAlways  = "always"  // Prompt on every authorization
+	ConsentPolicyOnce    = "once"    // Prompt until the requested scopes are granted
+	ConsentPolicyImplied = "implied" // Never prompt; first-party clients only
+)
+
+const (
+	ConsentActionGranted = "granted"
+	ConsentActionImplied = "implied"
+	ConsentActionRevoked = "revoked"
+)
+
+var (
+	ErrInvalidConsentPolicy   = errors.New("consent_policy must be always, once or implied")
+	ErrImpliedConsentPolicy   = errors.New("implied consent is only allowed for first-party clients")
+	ErrInvalidConsentLifetime = errors.New("consent_lifetime cannot be negative")
+	ErrInvalidRequiredScopes  = errors.New("required_scopes must be within the client's allowed scopes")
+	ErrInvalidConsentScopes   = errors.New("invalid scopes for this client")
+)
+
 // OAuth2ConsentService handles user consent management
 type OAuth2ConsentService struct {
 	consentRepo *repository.OAuth2ConsentRepository
@@ -31,7 +53,7 @@ func NewOAuth2ConsentService(
 // GrantConsent stores or updates user consent for a client
 func (s *OAuth2ConsentService) GrantConsent(ctx context.Context, userID, clientID string, scopes []string) error {
 	// Validate client exists
-	_, err := s.clientRepo.GetByClientID(ctx, clientID)
+	client, err := s.clientRepo.GetByClientID(ctx, clientID)
 	if err != nil {
 		return err
 	}
@@ -47,14 +69,163 @@ func (s *OAuth2ConsentService) GrantConsent(ctx context.Context, userID, clientI
 		}
 	}
 
-	// Upsert consent
+	return s.saveConsent(ctx, client, userID, scopes, false)
+}
+
+// RequiresConsent applies the client's consent policy and reports whether the user
+// must be shown the consent screen. Stored consent only counts while it has not
+// expired and was given for the client's current privacy policy version. Implied
+// consent is recorded like a user grant, so it shows up in the user's consents
+// and can be revoked. Scopes the client is not allowed return ErrInvalidConsentScopes.
+func (s *OAuth2ConsentService) RequiresConsent(ctx context.Context, client *models.OAuth2Client, userID string, scopes []string) (bool, error) {
+	if len(scopes) > 0 {
+		valid, err := s.clientRepo.ValidateScopes(ctx, client.ClientID, scopes)
+		if err != nil {
+			return false, err
+		}
+		if !valid {
+			return false, ErrInvalidConsentScopes
+		}
+	}
+
+	if client.ConsentPolicy == ConsentPolicyAlways {
+		return true, nil
+	}
+
+	consent, err := s.consentRepo.GetByUserAndClient(ctx, userID, client.ClientID)
+	if err != nil && !errors.Is(err, repository.ErrConsentNotFound) {
+		return false, err
+	}
+
+	var granted []string
+	if consent != nil && consentIsCurrent(consent, client) {
+		granted = consent.Scopes
+	}
+	covered := granted != nil && len(missingScopes(granted, scopes)) == 0
+
+	if client.ConsentPolicy == ConsentPolicyImplied && client.IsFirstParty {
+		if covered {
+			return false, nil
+		}
+		// Widen what was granted rather than narrowing it
+		scopes = append(append([]string{}, granted...), missingScopes(granted, scopes)...)
+		return false, s.saveConsent(ctx, client, userID, scopes, true)
+	}
+
+	return !covered, nil
+}
+
+// ApproveScopes returns the requested scopes the user kept ticked on the consent
+// screen; scopes the client marks as required cannot be unticked.
+func ApproveScopes(client *models.OAuth2Client, requested, selected []string) []string {
+	keep := make(map[string]bool, len(selected)+len(client.RequiredScopes))
+	for _, scope := range selected {
+		keep[scope] = true
+	}
+	for _, scope := range client.RequiredScopes {
+		keep[scope] = true
+	}
+
+	approved := make([]string, 0, len(requested))
+	for _, scope := range requested {
+		if keep[scope] {
+			approved = append(approved, scope)
+		}
+	}
+	return approved
+}
+
+// saveConsent stores consent for the client's current policy version and records it in the history
+func (s *OAuth2ConsentService) saveConsent(ctx context.Context, client *models.OAuth2Client, userID string, scopes []string, implied bool) error {
+	now := time.Now()
+
 	consent := &models.OAuth2Consent{
-		UserID:   userID,
-		ClientID: clientID,
-		Scopes:   scopes,
+		ID:            uuid.New().String(),
+		UserID:        userID,
+		ClientID:      client.ClientID,
+		Scopes:        scopes,
+		Implied:       implied,
+		PolicyVersion: client.PolicyVersion,
+		GrantedAt:     now,
+	}
+	if client.ConsentLifetime > 0 {
+		expiresAt := now.Add(time.Duration(client.ConsentLifetime) * time.Second)
+		consent.ExpiresAt = &expiresAt
+	}
+
+	if err := s.consentRepo.Upsert(ctx, consent); err != nil {
+		return err
+	}
+
+	action := ConsentActionGranted
+	if implied {
+		action = ConsentActionImplied
 	}
+	return s.recordHistory(ctx, consent, action)
+}
+
+// recordHistory appends a consent change to the compliance history
+func (s *OAuth2ConsentService) recordHistory(ctx context.Context, consent *models.OAuth2Consent, action string) error {
+	return s.consentRepo.CreateHistory(ctx, &models.OAuth2ConsentHistory{
+		ID:            uuid.New().String(),
+		UserID:        consent.UserID,
+		ClientID:      consent.ClientID,
+		Action:        action,
+		Scopes:        consent.Scopes,
+		PolicyVersion: consent.PolicyVersion,
+		ExpiresAt:     consent.ExpiresAt,
+		CreatedAt:     time.Now(),
+	})
+}
 
-	return s.consentRepo.Upsert(ctx, consent)
+// consentIsCurrent reports whether stored consent is unexpired and matches the client's policy version
+func consentIsCurrent(consent *models.OAuth2Consent, client *models.OAuth2Client) bool {
+	if consent.ExpiresAt != nil && time.Now().After(*consent.ExpiresAt) {
+		return false
+	}
+	return consent.PolicyVersion == client.PolicyVersion
+}
+
+// missingScopes returns the requested scopes that were not granted
+func missingScopes(granted, requested []string) []string {
+	grantedMap := make(map[string]bool, len(granted))
+	for _, scope := range granted {
+		grantedMap[scope] = true
+	}
+
+	var missing []string
+	for _, scope := range requested {
+		if !grantedMap[scope] {
+			missing = append(missing, scope)
+		}
+	}
+	return missing
+}
+
+// validateConsentSettings checks a client's consent settings, defaulting the policy to once
+func validateConsentSettings(client *models.OAuth2Client) error {
+	switch client.ConsentPolicy {
+	case "":
+		client.ConsentPolicy = ConsentPolicyOnce
+	case ConsentPolicyAlways, ConsentPolicyOnce:
+	case ConsentPolicyImplied:
+		if !client.IsFirstParty {
+			return ErrImpliedConsentPolicy
+		}
+	default:
+		return ErrInvalidConsentPolicy
+	}
+
+	if client.ConsentLifetime < 0 {
+		return ErrInvalidConsentLifetime
+	}
+
+	// Required scopes must be scopes the client may request
+	if len(client.AllowedScopes) > 0 && len(missingScopes(client.AllowedScopes, client.RequiredScopes)) > 0 {
+		return ErrInvalidRequiredScopes
+	}
+
+	return nil
 }
 
 // CheckConsent validates if user has granted consent for requested scopes
@@ -67,14 +238,46 @@ func (s *OAuth2ConsentService) GetUserConsents(ctx context.Context, userID strin
 	return s.consentRepo.GetByUserID(ctx, userID)
 }
 
+// GetConsentHistory retrieves recorded consent changes; empty user or client IDs match everything
+func (s *OAuth2ConsentService) GetConsentHistory(ctx context.Context, userID, clientID string, page, limit int) ([]*models.OAuth2ConsentHistory, int64, error) {
+	offset := (page - 1) * limit
+	return s.consentRepo.ListHistory(ctx, userID, clientID, limit, offset)
+}
+
 // RevokeConsent removes a user's consent for a client
 func (s *OAuth2ConsentService) RevokeConsent(ctx context.Context, userID, clientID string) error {
-	return s.consentRepo.Revoke(ctx, userID, clientID)
+	consent, err := s.consentRepo.GetByUserAndClient(ctx, userID, clientID)
+	if err != nil {
+		if errors.Is(err, repository.ErrConsentNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	if err := s.consentRepo.Revoke(ctx, userID, clientID); err != nil {
+		return err
+	}
+
+	return s.recordHistory(ctx, consent, ConsentActionRevoked)
 }
 
 // RevokeAllConsents removes all consents for a user
 func (s *OAuth2ConsentService) RevokeAllConsents(ctx context.Context, userID string) error {
-	return s.consentRepo.RevokeAll(ctx, userID)
+	consents, err := s.consentRepo.GetByUserID(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	if err := s.consentRepo.RevokeAll(ctx, userID); err != nil {
+		return err
+	}
+
+	for _, consent := range consents {
+		if err := s.recordHistory(ctx, consent, ConsentActionRevoked); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // GetConsentWithClientDetails retrieves consent with enriched client information
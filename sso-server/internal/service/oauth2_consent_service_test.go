@@ -0,0 +1,173 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sso-project/sso-server/internal/models"
+	"github.com/sso-project/sso-server/internal/repository"
+	"github.com/sso-project/sso-server/internal/testutil"
+)
+
+func TestOAuth2ConsentService_ConsentPolicies(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+	defer testutil.TeardownTestDB(t, db)
+
+	consentRepo := repository.NewOAuth2ConsentRepository(db.DB)
+	consentService := NewOAuth2ConsentService(consentRepo, repository.NewOAuth2ClientRepository(db.DB), repository.NewOAuth2ScopeRepository(db.DB))
+	ctx := context.Background()
+
+	testutil.CreateTestOAuth2Scope(t, db, "openid", false)
+	testutil.CreateTestOAuth2Scope(t, db, "profile", false)
+	user := testutil.CreateTestUser(t, db, "consent@example.com")
+	client := testutil.CreateTestOAuth2ClientWithScopes(t, db, "internal-app", []string{"openid", "profile"})
+
+	// once (default): prompt until the scopes are granted
+	required, err := consentService.RequiresConsent(ctx, client, user.ID, []string{"openid"})
+	require.NoError(t, err)
+	assert.True(t, required)
+
+	require.NoError(t, consentService.GrantConsent(ctx, user.ID, client.ClientID, []string{"openid"}))
+	required, err = consentService.RequiresConsent(ctx, client, user.ID, []string{"openid"})
+	require.NoError(t, err)
+	assert.False(t, required)
+
+	// always: prompt even with stored consent
+	client.ConsentPolicy = ConsentPolicyAlways
+	required, err = consentService.RequiresConsent(ctx, client, user.ID, []string{"openid"})
+	require.NoError(t, err)
+	assert.True(t, required)
+
+	// implied is ignored unless the client is first-party
+	client.ConsentPolicy = ConsentPolicyImplied
+	required, err = consentService.RequiresConsent(ctx, client, user.ID, []string{"openid", "profile"})
+	require.NoError(t, err)
+	assert.True(t, required)
+
+	// implied: no prompt, but the grant is recorded and widened
+	client.IsFirstParty = true
+	required, err = consentService.RequiresConsent(ctx, client, user.ID, []string{"profile"})
+	require.NoError(t, err)
+	assert.False(t, required)
+
+	consent, err := consentRepo.GetByUserAndClient(ctx, user.ID, client.ClientID)
+	require.NoError(t, err)
+	assert.True(t, consent.Implied)
+	assert.ElementsMatch(t, []string{"openid", "profile"}, consent.Scopes)
+
+	// Scopes the client may not request are never recorded
+	_, err = consentService.RequiresConsent(ctx, client, user.ID, []string{"openid", "admin"})
+	assert.ErrorIs(t, err, ErrInvalidConsentScopes)
+	consent, err = consentRepo.GetByUserAndClient(ctx, user.ID, client.ClientID)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"openid", "profile"}, consent.Scopes)
+
+	// Revoking implied consent works like any other consent
+	require.NoError(t, consentService.RevokeConsent(ctx, user.ID, client.ClientID))
+	consents, err := consentService.GetUserConsents(ctx, user.ID)
+	require.NoError(t, err)
+	assert.Empty(t, consents)
+}
+
+func TestOAuth2ClientService_ConsentPolicyValidation(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+	defer testutil.TeardownTestDB(t, db)
+
+	clientService := NewOAuth2ClientService(repository.NewOAuth2ClientRepository(db.DB), repository.NewOAuth2ScopeRepository(db.DB))
+	ctx := context.Background()
+
+	req := RegisterClientRequest{
+		Name:            "Intranet",
+		RedirectURIs:    []string{"https://intranet.example.com/cb"},
+		GrantTypes:      []string{"authorization_code"},
+		ConsentSettings: ConsentSettings{ConsentPolicy: ConsentPolicyImplied},
+	}
+	_, _, err := clientService.RegisterClient(ctx, req)
+	assert.ErrorIs(t, err, ErrImpliedConsentPolicy)
+
+	req.ConsentPolicy = "never"
+	_, _, err = clientService.RegisterClient(ctx, req)
+	assert.ErrorIs(t, err, ErrInvalidConsentPolicy)
+
+	req.ConsentPolicy = ""
+	client, _, err := clientService.RegisterClient(ctx, req)
+	require.NoError(t, err)
+	assert.Equal(t, ConsentPolicyOnce, client.ConsentPolicy)
+
+	updated, err := clientService.UpdateConsentPolicy(ctx, client.ClientID, ConsentSettings{ConsentPolicy: ConsentPolicyImplied, IsFirstParty: true})
+	require.NoError(t, err)
+	assert.Equal(t, ConsentPolicyImplied, updated.ConsentPolicy)
+	assert.True(t, updated.IsFirstParty)
+
+	_, err = clientService.UpdateConsentPolicy(ctx, client.ClientID, ConsentSettings{ConsentPolicy: ConsentPolicyImplied})
+	assert.ErrorIs(t, err, ErrImpliedConsentPolicy)
+
+	_, err = clientService.UpdateConsentPolicy(ctx, client.ClientID, ConsentSettings{ConsentLifetime: -1})
+	assert.ErrorIs(t, err, ErrInvalidConsentLifetime)
+}
+
+func TestOAuth2ConsentService_ExpiryAndPolicyVersion(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+	defer testutil.TeardownTestDB(t, db)
+
+	consentRepo := repository.NewOAuth2ConsentRepository(db.DB)
+	consentService := NewOAuth2ConsentService(consentRepo, repository.NewOAuth2ClientRepository(db.DB), repository.NewOAuth2ScopeRepository(db.DB))
+	ctx := context.Background()
+
+	testutil.CreateTestOAuth2Scope(t, db, "openid", false)
+	user := testutil.CreateTestUser(t, db, "versioned@example.com")
+	client := testutil.CreateTestOAuth2ClientWithScopes(t, db, "versioned-app", []string{"openid"})
+	client.PolicyVersion = "2026-01"
+	client.ConsentLifetime = 3600
+	require.NoError(t, db.DB.Save(client).Error)
+
+	require.NoError(t, consentService.GrantConsent(ctx, user.ID, client.ClientID, []string{"openid"}))
+	consent, err := consentRepo.GetByUserAndClient(ctx, user.ID, client.ClientID)
+	require.NoError(t, err)
+	assert.Equal(t, "2026-01", consent.PolicyVersion)
+	require.NotNil(t, consent.ExpiresAt)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), *consent.ExpiresAt, time.Minute)
+
+	required, err := consentService.RequiresConsent(ctx, client, user.ID, []string{"openid"})
+	require.NoError(t, err)
+	assert.False(t, required)
+
+	// A new privacy policy version asks again
+	client.PolicyVersion = "2026-06"
+	required, err = consentService.RequiresConsent(ctx, client, user.ID, []string{"openid"})
+	require.NoError(t, err)
+	assert.True(t, required)
+
+	// So does expired consent
+	client.PolicyVersion = "2026-01"
+	expired := time.Now().Add(-time.Minute)
+	consent.ExpiresAt = &expired
+	require.NoError(t, consentRepo.Update(ctx, consent))
+	required, err = consentService.RequiresConsent(ctx, client, user.ID, []string{"openid"})
+	require.NoError(t, err)
+	assert.True(t, required)
+
+	// Every change lands in the history, newest first
+	require.NoError(t, consentService.RevokeConsent(ctx, user.ID, client.ClientID))
+	history, total, err := consentService.GetConsentHistory(ctx, user.ID, "", 1, 20)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), total)
+	require.Len(t, history, 2)
+	actions := []string{history[0].Action, history[1].Action}
+	assert.ElementsMatch(t, []string{ConsentActionGranted, ConsentActionRevoked}, actions)
+}
+
+func TestApproveScopes(t *testing.T) {
+	client := &models.OAuth2Client{RequiredScopes: []string{"openid"}}
+
+	// Required scopes survive being unticked; unrequested scopes are never added
+	approved := ApproveScopes(client, []string{"openid", "profile", "email"}, []string{"email", "admin"})
+	assert.Equal(t, []string{"openid", "email"}, approved)
+
+	approved = ApproveScopes(client, []string{"profile"}, nil)
+	assert.Empty(t, approved)
+}
@@ -0,0 +1,254 @@
+package service
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var ErrInvalidJWKS = errors.New("invalid JWK set")
+
+// jwksRefetchInterval limits how often an unknown kid can trigger a fetch
+const jwksRefetchInterval = 30 * time.Second
+
+// jsonWebKey is the subset of RFC 7517 fields needed for signature keys
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// parseJWKS decodes a JWK set into public keys by kid. Keys that are not
+// signature keys are skipped; a set with no usable key is an error.
+func parseJWKS(data []byte) (map[string]crypto.PublicKey, error) {
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidJWKS, err)
+	}
+
+	keys := make(map[string]crypto.PublicKey)
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			return nil, err
+		}
+		keys[jwk.Kid] = key
+	}
+
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%w: no signature keys", ErrInvalidJWKS)
+	}
+	return keys, nil
+}
+
+func (k jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() || e.Int64() < 3 {
+			return nil, fmt.Errorf("%w: bad RSA exponent", ErrInvalidJWKS)
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("%w: unsupported curve %q", ErrInvalidJWKS, k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, fmt.Errorf("%w: point is not on curve", ErrInvalidJWKS)
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("%w: unsupported curve %q", ErrInvalidJWKS, k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("%w: bad Ed25519 key", ErrInvalidJWKS)
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, fmt.Errorf("%w: unsupported key type %q", ErrInvalidJWKS, k.Kty)
+}
+
+func decodeBigInt(value string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil || len(b) == 0 {
+		return nil, fmt.Errorf("%w: bad key parameter", ErrInvalidJWKS)
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+// jwksCache keeps fetched JWK sets per URL for a TTL
+type jwksCache struct {
+	client *http.Client
+	ttl    time.Duration
+
+	mu       sync.Mutex
+	entries  map[string]*jwksEntry
+	fetching map[string]*sync.Mutex // One fetch per URL at a time, without blocking other URLs
+}
+
+type jwksEntry struct {
+	keys      map[string]crypto.PublicKey
+	fetchedAt time.Time
+}
+
+func newJWKSCache(ttl time.Duration) *jwksCache {
+	return &jwksCache{
+		client:   &http.Client{Timeout: 10 * time.Second},
+		ttl:      ttl,
+		entries:  make(map[string]*jwksEntry),
+		fetching: make(map[string]*sync.Mutex),
+	}
+}
+
+// key returns the key for kid from the set at url, fetching the set when it is
+// missing, stale, or (at most every jwksRefetchInterval) when the kid is unknown
+// because the issuer has rotated its keys.
+func (c *jwksCache) key(ctx context.Context, url, kid string) (crypto.PublicKey, error) {
+	entry := c.entry(url)
+	if c.needsFetch(entry, kid) {
+		var err error
+		if entry, err = c.refresh(ctx, url, kid); err != nil {
+			return nil, err
+		}
+	}
+
+	return selectKey(entry.keys, kid)
+}
+
+// needsFetch reports whether entry is missing or stale, or lacks kid and is old
+// enough to fetch again
+func (c *jwksCache) needsFetch(entry *jwksEntry, kid string) bool {
+	if entry == nil || time.Since(entry.fetchedAt) > c.ttl {
+		return true
+	}
+	_, ok := entry.keys[kid]
+	return !ok && kid != "" && time.Since(entry.fetchedAt) > jwksRefetchInterval
+}
+
+func (c *jwksCache) entry(url string) *jwksEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.entries[url]
+}
+
+// refresh fetches the set at url unless a concurrent caller already has. The
+// cache itself is only locked to swap the entry, so a slow issuer does not hold
+// up assertions from other issuers.
+func (c *jwksCache) refresh(ctx context.Context, url, kid string) (*jwksEntry, error) {
+	c.mu.Lock()
+	lock, ok := c.fetching[url]
+	if !ok {
+		lock = &sync.Mutex{}
+		c.fetching[url] = lock
+	}
+	c.mu.Unlock()
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	if entry := c.entry(url); !c.needsFetch(entry, kid) {
+		return entry, nil
+	}
+
+	keys, err := c.fetch(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+
+	entry := &jwksEntry{keys: keys, fetchedAt: time.Now()}
+	c.mu.Lock()
+	c.entries[url] = entry
+	c.mu.Unlock()
+	return entry, nil
+}
+
+func (c *jwksCache) fetch(ctx context.Context, url string) (map[string]crypto.PublicKey, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetching JWKS: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching JWKS: unexpected status %d", resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("fetching JWKS: %w", err)
+	}
+
+	return parseJWKS(body)
+}
+
+// forget drops a cached set so the next assertion fetches it again
+func (c *jwksCache) forget(url string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, url)
+}
+
+// selectKey picks the key named by kid, or the only key when the token has no kid
+func selectKey(keys map[string]crypto.PublicKey, kid string) (crypto.PublicKey, error) {
+	if key, ok := keys[kid]; ok {
+		return key, nil
+	}
+	if kid == "" && len(keys) == 1 {
+		for _, key := range keys {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("no key found for kid %q", kid)
+}
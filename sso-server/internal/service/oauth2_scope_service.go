@@ -0,0 +1,175 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/sso-project/sso-server/internal/models"
+	"github.com/sso-project/sso-server/internal/repository"
+)
+
+var (
+	ErrScopeNameRequired = errors.New("scope name is required")
+	ErrScopeNameInvalid  = errors.New("scope name must not contain whitespace")
+	ErrScopeExists       = errors.New("scope already exists")
+	ErrScopeInUse        = errors.New("scope is still referenced by one or more clients")
+)
+
+// ScopeInUseError reports which clients still reference a scope
+type ScopeInUseError struct {
+	ClientIDs []string
+}
+
+func (e *ScopeInUseError) Error() string {
+	return ErrScopeInUse.Error()
+}
+
+func (e *ScopeInUseError) Unwrap() error {
+	return ErrScopeInUse
+}
+
+// OAuth2ScopeService handles OAuth2 scope management
+type OAuth2ScopeService struct {
+	scopeRepo  *repository.OAuth2ScopeRepository
+	clientRepo *repository.OAuth2ClientRepository
+}
+
+// NewOAuth2ScopeService creates a new OAuth2ScopeService
+func NewOAuth2ScopeService(
+	scopeRepo *repository.OAuth2ScopeRepository,
+	clientRepo *repository.OAuth2ClientRepository,
+) *OAuth2ScopeService {
+	return &OAuth2ScopeService{
+		scopeRepo:  scopeRepo,
+		clientRepo: clientRepo,
+	}
+}
+
+// ScopeRequest represents a scope create/update request
+type ScopeRequest struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	IsDefault   *bool  `json:"is_default,omitempty"`
+}
+
+// ListScopes retrieves all scopes
+func (s *OAuth2ScopeService) ListScopes(ctx context.Context) ([]*models.OAuth2Scope, error) {
+	return s.scopeRepo.GetAll(ctx)
+}
+
+// GetScope retrieves a scope by ID
+func (s *OAuth2ScopeService) GetScope(ctx context.Context, id string) (*models.OAuth2Scope, error) {
+	return s.scopeRepo.GetByID(ctx, id)
+}
+
+// GetScopesByNames retrieves scope details (e.g. descriptions for the consent screen)
+func (s *OAuth2ScopeService) GetScopesByNames(ctx context.Context, names []string) ([]*models.OAuth2Scope, error) {
+	if len(names) == 0 {
+		return []*models.OAuth2Scope{}, nil
+	}
+	return s.scopeRepo.GetByNames(ctx, names)
+}
+
+// CreateScope creates a new scope
+func (s *OAuth2ScopeService) CreateScope(ctx context.Context, req ScopeRequest) (*models.OAuth2Scope, error) {
+	name, err := validateScopeName(req.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := s.scopeRepo.GetByName(ctx, name); err == nil {
+		return nil, ErrScopeExists
+	} else if !errors.Is(err, repository.ErrScopeNotFound) {
+		return nil, err
+	}
+
+	scope := &models.OAuth2Scope{
+		ID:          uuid.New().String(),
+		Name:        name,
+		Description: req.Description,
+	}
+	if req.IsDefault != nil {
+		scope.IsDefault = *req.IsDefault
+	}
+
+	if err := s.scopeRepo.Create(ctx, scope); err != nil {
+		return nil, err
+	}
+
+	return scope, nil
+}
+
+// UpdateScope updates a scope's description, default flag and (if unused) name
+func (s *OAuth2ScopeService) UpdateScope(ctx context.Context, id string, req ScopeRequest) (*models.OAuth2Scope, error) {
+	scope, err := s.scopeRepo.GetByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.Name != "" && req.Name != scope.Name {
+		name, err := validateScopeName(req.Name)
+		if err != nil {
+			return nil, err
+		}
+
+		// Renaming a referenced scope would silently break those clients
+		clientIDs, err := s.clientRepo.GetClientIDsUsingScope(ctx, scope.Name)
+		if err != nil {
+			return nil, err
+		}
+		if len(clientIDs) > 0 {
+			return nil, &ScopeInUseError{ClientIDs: clientIDs}
+		}
+
+		if _, err := s.scopeRepo.GetByName(ctx, name); err == nil {
+			return nil, ErrScopeExists
+		} else if !errors.Is(err, repository.ErrScopeNotFound) {
+			return nil, err
+		}
+
+		scope.Name = name
+	}
+
+	scope.Description = req.Description
+	if req.IsDefault != nil {
+		scope.IsDefault = *req.IsDefault
+	}
+
+	if err := s.scopeRepo.Update(ctx, scope); err != nil {
+		return nil, err
+	}
+
+	return scope, nil
+}
+
+// DeleteScope deletes a scope that is not referenced by any client
+func (s *OAuth2ScopeService) DeleteScope(ctx context.Context, id string) error {
+	scope, err := s.scopeRepo.GetByID(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	clientIDs, err := s.clientRepo.GetClientIDsUsingScope(ctx, scope.Name)
+	if err != nil {
+		return err
+	}
+	if len(clientIDs) > 0 {
+		return &ScopeInUseError{ClientIDs: clientIDs}
+	}
+
+	return s.scopeRepo.Delete(ctx, id)
+}
+
+// validateScopeName normalizes and validates a scope name
+func validateScopeName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", ErrScopeNameRequired
+	}
+	if strings.ContainsAny(name, " \t\r\n") {
+		return "", ErrScopeNameInvalid
+	}
+	return name, nil
+}
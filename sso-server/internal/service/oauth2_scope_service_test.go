@@ -0,0 +1,105 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sso-project/sso-server/internal/repository"
+	"github.com/sso-project/sso-server/internal/testutil"
+)
+
+func TestOAuth2ScopeService_CreateAndUpdate(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+	defer testutil.TeardownTestDB(t, db)
+
+	scopeRepo := repository.NewOAuth2ScopeRepository(db.DB)
+	clientRepo := repository.NewOAuth2ClientRepository(db.DB)
+	scopeService := NewOAuth2ScopeService(scopeRepo, clientRepo)
+	ctx := context.Background()
+
+	isDefault := true
+	scope, err := scopeService.CreateScope(ctx, ScopeRequest{
+		Name:        "orders:read",
+		Description: "Read your orders",
+		IsDefault:   &isDefault,
+	})
+	require.NoError(t, err)
+	assert.True(t, scope.IsDefault)
+
+	// Duplicate names are rejected
+	_, err = scopeService.CreateScope(ctx, ScopeRequest{Name: "orders:read"})
+	assert.ErrorIs(t, err, ErrScopeExists)
+
+	// Whitespace would break space-delimited scope strings
+	_, err = scopeService.CreateScope(ctx, ScopeRequest{Name: "orders read"})
+	assert.ErrorIs(t, err, ErrScopeNameInvalid)
+
+	notDefault := false
+	updated, err := scopeService.UpdateScope(ctx, scope.ID, ScopeRequest{
+		Description: "Read your order history",
+		IsDefault:   &notDefault,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "orders:read", updated.Name)
+	assert.Equal(t, "Read your order history", updated.Description)
+	assert.False(t, updated.IsDefault)
+}
+
+func TestOAuth2ScopeService_DeleteProtection(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+	defer testutil.TeardownTestDB(t, db)
+
+	scopeRepo := repository.NewOAuth2ScopeRepository(db.DB)
+	clientRepo := repository.NewOAuth2ClientRepository(db.DB)
+	scopeService := NewOAuth2ScopeService(scopeRepo, clientRepo)
+	ctx := context.Background()
+
+	used := testutil.CreateTestOAuth2Scope(t, db, "billing", false)
+	unused := testutil.CreateTestOAuth2Scope(t, db, "reports", false)
+	testutil.CreateTestOAuth2ClientWithScopes(t, db, "billing-app", []string{"billing"})
+
+	err := scopeService.DeleteScope(ctx, used.ID)
+	require.Error(t, err)
+	assert.ErrorIs(t, err, ErrScopeInUse)
+
+	var inUse *ScopeInUseError
+	require.True(t, errors.As(err, &inUse))
+	assert.Equal(t, []string{"billing-app"}, inUse.ClientIDs)
+
+	// Renaming a referenced scope is also blocked
+	_, err = scopeService.UpdateScope(ctx, used.ID, ScopeRequest{Name: "payments"})
+	assert.ErrorIs(t, err, ErrScopeInUse)
+
+	require.NoError(t, scopeService.DeleteScope(ctx, unused.ID))
+	_, err = scopeService.GetScope(ctx, unused.ID)
+	assert.ErrorIs(t, err, repository.ErrScopeNotFound)
+}
+
+func TestOAuth2ClientService_ResolveScopes_Defaults(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+	defer testutil.TeardownTestDB(t, db)
+
+	scopeRepo := repository.NewOAuth2ScopeRepository(db.DB)
+	clientRepo := repository.NewOAuth2ClientRepository(db.DB)
+	clientService := NewOAuth2ClientService(clientRepo, scopeRepo)
+	ctx := context.Background()
+
+	testutil.CreateTestOAuth2Scope(t, db, "openid", true)
+	testutil.CreateTestOAuth2Scope(t, db, "profile", false)
+	testutil.CreateTestOAuth2Scope(t, db, "audit", true)
+	testutil.CreateTestOAuth2ClientWithScopes(t, db, "web-app", []string{"openid", "profile"})
+
+	// Omitted scope falls back to defaults the client is allowed to request
+	scopes, err := clientService.ResolveScopes(ctx, "web-app", nil)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"openid"}, scopes)
+
+	// Explicit scopes are passed through untouched
+	scopes, err = clientService.ResolveScopes(ctx, "web-app", []string{"profile"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"profile"}, scopes)
+}
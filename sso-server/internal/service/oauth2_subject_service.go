@@ -0,0 +1,189 @@
+package service
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/sso-project/sso-server/internal/models"
+	"github.com/sso-project/sso-server/internal/repository"
+)
+
+const (
+	SubjectTypePublic   = "public"
+	SubjectTypePairwise = "pairwise"
+)
+
+var (
+	ErrInvalidSubjectType       = errors.New("subject_type must be public or pairwise")
+	ErrSectorIdentifierRequired = errors.New("sector_identifier is required when redirect URIs span multiple hosts")
+	ErrPairwiseSecretMissing    = errors.New("pairwise subjects need OAUTH2_PAIRWISE_SECRET to be set")
+)
+
+// OAuth2SubjectService derives the "sub" value each client sees for a user
+type OAuth2SubjectService struct {
+	subjectRepo *repository.OAuth2SubjectRepository
+	clientRepo  *repository.OAuth2ClientRepository
+	secret      []byte
+}
+
+// NewOAuth2SubjectService creates a new OAuth2SubjectService. Pairwise subjects
+// are derived from secret; without one, pairwise clients get no subject.
+func NewOAuth2SubjectService(
+	subjectRepo *repository.OAuth2SubjectRepository,
+	clientRepo *repository.OAuth2ClientRepository,
+	secret string,
+) *OAuth2SubjectService {
+	return &OAuth2SubjectService{
+		subjectRepo: subjectRepo,
+		clientRepo:  clientRepo,
+		secret:      []byte(secret),
+	}
+}
+
+// SubjectLookup is the result of resolving a subject identifier back to a user
+type SubjectLookup struct {
+	UserID      string `json:"user_id"`
+	ClientID    string `json:"client_id"`
+	SubjectType string `json:"subject_type"`
+	Sector      string `json:"sector,omitempty"`
+}
+
+// SubjectFor returns the subject identifier a client should see for a user
+func (s *OAuth2SubjectService) SubjectFor(ctx context.Context, clientID, userID string) (string, error) {
+	client, err := s.clientRepo.GetByClientID(ctx, clientID)
+	if err != nil {
+		return "", err
+	}
+
+	if client.SubjectType != SubjectTypePairwise {
+		return userID, nil
+	}
+
+	if len(s.secret) == 0 {
+		return "", ErrPairwiseSecretMissing
+	}
+
+	sector, err := sectorForClient(client)
+	if err != nil {
+		return "", err
+	}
+
+	subject := s.pairwiseSubject(sector, userID)
+
+	// Remember the mapping so support staff can resolve it later; tokens for a
+	// known user only need the lookup
+	_, err = s.subjectRepo.GetBySubject(ctx, sector, subject)
+	if err == nil {
+		return subject, nil
+	}
+	if !errors.Is(err, repository.ErrSubjectNotFound) {
+		return "", err
+	}
+	if err := s.subjectRepo.Save(ctx, &models.OAuth2PairwiseSubject{
+		ID:      uuid.New().String(),
+		Sector:  sector,
+		Subject: subject,
+		UserID:  userID,
+	}); err != nil {
+		return "", err
+	}
+
+	return subject, nil
+}
+
+// ResolveSubject maps a subject identifier seen by a client back to the local user
+func (s *OAuth2SubjectService) ResolveSubject(ctx context.Context, clientID, subject string) (*SubjectLookup, error) {
+	client, err := s.clientRepo.GetByClientID(ctx, clientID)
+	if err != nil {
+		return nil, err
+	}
+
+	if client.SubjectType != SubjectTypePairwise {
+		return &SubjectLookup{
+			UserID:      subject,
+			ClientID:    client.ClientID,
+			SubjectType: SubjectTypePublic,
+		}, nil
+	}
+
+	sector, err := sectorForClient(client)
+	if err != nil {
+		return nil, err
+	}
+
+	pairwise, err := s.subjectRepo.GetBySubject(ctx, sector, subject)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SubjectLookup{
+		UserID:      pairwise.UserID,
+		ClientID:    client.ClientID,
+		SubjectType: SubjectTypePairwise,
+		Sector:      sector,
+	}, nil
+}
+
+// pairwiseSubject derives a stable, non-reversible identifier for a user within a sector
+func (s *OAuth2SubjectService) pairwiseSubject(sector, userID string) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(sector))
+	mac.Write([]byte{0})
+	mac.Write([]byte(userID))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// sectorForClient returns the sector host shared by all clients of the same owner.
+// Clients without an explicit sector identifier fall back to their redirect URI host.
+func sectorForClient(client *models.OAuth2Client) (string, error) {
+	if client.SectorIdentifier != "" {
+		return sectorHost(client.SectorIdentifier), nil
+	}
+
+	sector := ""
+	for _, uri := range client.RedirectURIs {
+		host := sectorHost(uri)
+		if sector != "" && host != sector {
+			return "", ErrSectorIdentifierRequired
+		}
+		sector = host
+	}
+
+	if sector == "" {
+		return "", ErrSectorIdentifierRequired
+	}
+
+	return sector, nil
+}
+
+// sectorHost extracts the host from a URI, or returns a bare host unchanged
+func sectorHost(value string) string {
+	if u, err := url.Parse(value); err == nil && u.Host != "" {
+		return strings.ToLower(u.Hostname())
+	}
+	return strings.ToLower(strings.TrimSpace(value))
+}
+
+// validateSubjectSettings checks a client's subject type and sector configuration
+func validateSubjectSettings(client *models.OAuth2Client) error {
+	switch client.SubjectType {
+	case "":
+		client.SubjectType = SubjectTypePublic
+	case SubjectTypePublic, SubjectTypePairwise:
+	default:
+		return ErrInvalidSubjectType
+	}
+
+	if client.SubjectType == SubjectTypePairwise {
+		_, err := sectorForClient(client)
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,172 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sso-project/sso-server/internal/models"
+	"github.com/sso-project/sso-server/internal/repository"
+	"github.com/sso-project/sso-server/internal/testutil"
+)
+
+func TestOAuth2SubjectService_PairwiseSubjects(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+	defer testutil.TeardownTestDB(t, db)
+
+	clientRepo := repository.NewOAuth2ClientRepository(db.DB)
+	subjectService := NewOAuth2SubjectService(repository.NewOAuth2SubjectRepository(db.DB), clientRepo, "test-pairwise-secret")
+	ctx := context.Background()
+
+	user := testutil.CreateTestUser(t, db, "alice@example.com")
+	testutil.CreateTestOAuth2ClientWithScopes(t, db, "public-app", []string{"openid"})
+
+	// Two clients of the same partner share a sector; a third partner does not
+	pairwise := func(clientID, sector string) {
+		client := testutil.CreateTestOAuth2ClientWithScopes(t, db, clientID, []string{"openid"})
+		require.NoError(t, db.DB.Model(&models.OAuth2Client{}).Where("id = ?", client.ID).
+			Updates(map[string]interface{}{"subject_type": SubjectTypePairwise, "sector_identifier": sector}).Error)
+	}
+	pairwise("partner-web", "https://partner.example.com")
+	pairwise("partner-mobile", "partner.example.com")
+	pairwise("other-partner", "https://other.example.org")
+
+	publicSub, err := subjectService.SubjectFor(ctx, "public-app", user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, user.ID, publicSub)
+
+	webSub, err := subjectService.SubjectFor(ctx, "partner-web", user.ID)
+	require.NoError(t, err)
+	assert.NotEqual(t, user.ID, webSub)
+
+	// Deterministic across calls and across clients in the same sector
+	again, err := subjectService.SubjectFor(ctx, "partner-web", user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, webSub, again)
+
+	mobileSub, err := subjectService.SubjectFor(ctx, "partner-mobile", user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, webSub, mobileSub)
+
+	otherSub, err := subjectService.SubjectFor(ctx, "other-partner", user.ID)
+	require.NoError(t, err)
+	assert.NotEqual(t, webSub, otherSub)
+
+	// Support staff can resolve a pairwise subject back to the user
+	lookup, err := subjectService.ResolveSubject(ctx, "partner-mobile", webSub)
+	require.NoError(t, err)
+	assert.Equal(t, user.ID, lookup.UserID)
+	assert.Equal(t, "partner.example.com", lookup.Sector)
+
+	_, err = subjectService.ResolveSubject(ctx, "other-partner", webSub)
+	assert.ErrorIs(t, err, repository.ErrSubjectNotFound)
+
+	// Without a pairwise secret there is no pairwise subject, rather than one from another key
+	unkeyed := NewOAuth2SubjectService(repository.NewOAuth2SubjectRepository(db.DB), clientRepo, "")
+	_, err = unkeyed.SubjectFor(ctx, "partner-web", user.ID)
+	assert.ErrorIs(t, err, ErrPairwiseSecretMissing)
+	publicSub, err = unkeyed.SubjectFor(ctx, "public-app", user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, user.ID, publicSub)
+}
+
+func TestOAuth2TokenService_PairwiseSubjectInTokens(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+	defer testutil.TeardownTestDB(t, db)
+
+	jwtService, err := NewJWTService("test-issuer", 15*time.Minute, time.Hour)
+	require.NoError(t, err)
+
+	clientRepo := repository.NewOAuth2ClientRepository(db.DB)
+	subjectService := NewOAuth2SubjectService(repository.NewOAuth2SubjectRepository(db.DB), clientRepo, "test-pairwise-secret")
+	tokenService := NewOAuth2TokenService(repository.NewOAuth2TokenRepository(db.DB), clientRepo, jwtService, subjectService, 15*time.Minute, time.Hour)
+	ctx := context.Background()
+
+	user := testutil.CreateTestUser(t, db, "alice@example.com")
+	client := testutil.CreateTestOAuth2ClientWithScopes(t, db, "partner-web", []string{"openid"})
+	require.NoError(t, db.DB.Model(client).Update("subject_type", SubjectTypePairwise).Error)
+
+	expected, err := subjectService.SubjectFor(ctx, "partner-web", user.ID)
+	require.NoError(t, err)
+
+	accessToken, _, err := tokenService.GenerateAccessToken(ctx, "partner-web", &user.ID, []string{"openid"})
+	require.NoError(t, err)
+	claims, err := jwtService.ValidateToken(accessToken)
+	require.NoError(t, err)
+	assert.Equal(t, expected, claims.Subject)
+
+	idToken, err := tokenService.GenerateIDToken(ctx, "partner-web", user.ID, []string{"openid"})
+	require.NoError(t, err)
+	claims, err = jwtService.ValidateToken(idToken)
+	require.NoError(t, err)
+	assert.Equal(t, expected, claims.Subject)
+	assert.Equal(t, "test-issuer", claims.Issuer)
+
+	introspection, err := tokenService.IntrospectToken(ctx, accessToken, "", "partner-web")
+	require.NoError(t, err)
+	assert.True(t, introspection.Active)
+	assert.Equal(t, expected, introspection.Sub)
+
+	// No ID token without the openid scope
+	idToken, err = tokenService.GenerateIDToken(ctx, "partner-web", user.ID, []string{"profile"})
+	require.NoError(t, err)
+	assert.Empty(t, idToken)
+}
+
+func TestOAuth2ClientService_RegisterPairwiseClient(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+	defer testutil.TeardownTestDB(t, db)
+
+	clientRepo := repository.NewOAuth2ClientRepository(db.DB)
+	clientService := NewOAuth2ClientService(clientRepo, repository.NewOAuth2ScopeRepository(db.DB))
+	ctx := context.Background()
+
+	// Pairwise clients are refused until a pairwise secret is configured
+	_, _, err := clientService.RegisterClient(ctx, RegisterClientRequest{
+		Name:         "Partner",
+		RedirectURIs: []string{"https://a.example.com/cb"},
+		GrantTypes:   []string{"authorization_code"},
+		SubjectType:  SubjectTypePairwise,
+	})
+	assert.ErrorIs(t, err, ErrPairwiseSecretMissing)
+	clientService.EnablePairwiseSubjects()
+
+	// Redirect URIs on different hosts need an explicit sector
+	_, _, err = clientService.RegisterClient(ctx, RegisterClientRequest{
+		Name:         "Partner",
+		RedirectURIs: []string{"https://a.example.com/cb", "https://b.example.com/cb"},
+		GrantTypes:   []string{"authorization_code"},
+		SubjectType:  SubjectTypePairwise,
+	})
+	assert.ErrorIs(t, err, ErrSectorIdentifierRequired)
+
+	_, _, err = clientService.RegisterClient(ctx, RegisterClientRequest{
+		Name:         "Partner",
+		RedirectURIs: []string{"https://a.example.com/cb"},
+		GrantTypes:   []string{"authorization_code"},
+		SubjectType:  "anonymous",
+	})
+	assert.ErrorIs(t, err, ErrInvalidSubjectType)
+
+	client, _, err := clientService.RegisterClient(ctx, RegisterClientRequest{
+		Name:         "Partner",
+		RedirectURIs: []string{"https://a.example.com/cb"},
+		GrantTypes:   []string{"authorization_code"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, SubjectTypePublic, client.SubjectType)
+
+	_, _, err = clientService.RegisterClient(ctx, RegisterClientRequest{
+		Name:         "Partner",
+		RedirectURIs: []string{"https://a.example.com/cb"},
+		GrantTypes:   []string{"authorization_code"},
+		SubjectType:  SubjectTypePairwise,
+	})
+	require.NoError(t, err)
+	count, err := clientRepo.CountBySubjectType(ctx, SubjectTypePairwise)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+}
@@ -15,30 +15,65 @@ import (
 	"github.com/sso-project/sso-server/internal/repository"
 )
 
+var (
+	// ErrTokenFilterRequired is returned when a bulk revocation has no filter
+	ErrTokenFilterRequired = errors.New("at least one filter is required for bulk revocation")
+
+	ErrInvalidTokenLifetime = errors.New("token lifetimes must not be negative")
+	ErrInvalidTokenFormat   = errors.New("access_token_format must be jwt or opaque")
+)
+
+// Access token formats a client can choose
+const (
+	AccessTokenFormatJWT    = "jwt"
+	AccessTokenFormatOpaque = "opaque"
+)
+
 // OAuth2TokenService handles OAuth2 token generation and validation
 type OAuth2TokenService struct {
-	tokenRepo  *repository.OAuth2TokenRepository
-	jwtService *JWTService
+	tokenRepo      *repository.OAuth2TokenRepository
+	clientRepo     *repository.OAuth2ClientRepository
+	jwtService     *JWTService
+	subjectService *OAuth2SubjectService
 
 	accessTokenExpiry  time.Duration
 	refreshTokenExpiry time.Duration
+
+	// resourceServers may introspect tokens issued to any client
+	resourceServers map[string]bool
 }
 
 // NewOAuth2TokenService creates a new OAuth2TokenService
 func NewOAuth2TokenService(
 	tokenRepo *repository.OAuth2TokenRepository,
+	clientRepo *repository.OAuth2ClientRepository,
 	jwtService *JWTService,
+	subjectService *OAuth2SubjectService,
 	accessTokenExpiry time.Duration,
 	refreshTokenExpiry time.Duration,
 ) *OAuth2TokenService {
 	return &OAuth2TokenService{
 		tokenRepo:          tokenRepo,
+		clientRepo:         clientRepo,
 		jwtService:         jwtService,
+		subjectService:     subjectService,
 		accessTokenExpiry:  accessTokenExpiry,
 		refreshTokenExpiry: refreshTokenExpiry,
 	}
 }
 
+// AllowIntrospection lets the given resource servers introspect tokens issued to other clients
+func (s *OAuth2TokenService) AllowIntrospection(clientIDs ...string) {
+	if s.resourceServers == nil {
+		s.resourceServers = make(map[string]bool)
+	}
+	for _, clientID := range clientIDs {
+		if clientID = strings.TrimSpace(clientID); clientID != "" {
+			s.resourceServers[clientID] = true
+		}
+	}
+}
+
 // TokenResponse represents an OAuth2 token response
 type TokenResponse struct {
 	AccessToken  string `json:"access_token"`
@@ -46,28 +81,123 @@ type TokenResponse struct {
 	ExpiresIn    int64  `json:"expires_in"`
 	RefreshToken string `json:"refresh_token,omitempty"`
 	Scope        string `json:"scope,omitempty"`
+	IDToken      string `json:"id_token,omitempty"`
+}
+
+// IntrospectionResponse represents an RFC 7662 token introspection response
+type IntrospectionResponse struct {
+	Active    bool   `json:"active"`
+	Scope     string `json:"scope,omitempty"`
+	ClientID  string `json:"client_id,omitempty"`
+	Sub       string `json:"sub,omitempty"`
+	TokenType string `json:"token_type,omitempty"`
+	Exp       int64  `json:"exp,omitempty"`
+	Iat       int64  `json:"iat,omitempty"`
 }
 
 // GenerateAccessToken creates a new JWT access token for OAuth2
 func (s *OAuth2TokenService) GenerateAccessToken(ctx context.Context, clientID string, userID *string, scopes []string) (string, *models.OAuth2AccessToken, error) {
-	// Create custom claims for OAuth2
-	claims := map[string]interface{}{
-		"client_id": clientID,
-		"scope":     strings.Join(scopes, " "),
-		"iat":       time.Now().Unix(),
-		"exp":       time.Now().Add(s.accessTokenExpiry).Unix(),
+	return s.generateAccessToken(ctx, clientID, userID, scopes, nil)
+}
+
+// GenerateClientAccessToken creates an access token without a user. When the client
+// has a service account, the token carries its roles and permissions in the same
+// claims user tokens use; extraClaims are added as given.
+func (s *OAuth2TokenService) GenerateClientAccessToken(ctx context.Context, clientID string, account *models.ServiceAccount, scopes []string, extraClaims map[string]interface{}) (string, *models.OAuth2AccessToken, error) {
+	claims := make(map[string]interface{}, len(extraClaims)+3)
+	for name, value := range extraClaims {
+		claims[name] = value
 	}
 
-	if userID != nil {
-		claims["sub"] = *userID
+	if account != nil {
+		roles, permissions := RoleClaims(account.Roles)
+		claims["service_account_id"] = account.ID
+		claims["roles"] = roles
+		claims["permissions"] = permissions
 	}
 
-	// Generate JWT token
-	token, err := s.jwtService.GenerateCustomToken(claims)
+	return s.generateAccessToken(ctx, clientID, nil, scopes, claims)
+}
+
+// tokenSettings are the lifetimes and format in effect for one client
+type tokenSettings struct {
+	accessTTL       time.Duration
+	idTokenTTL      time.Duration
+	refreshTTL      time.Duration
+	refreshIdle     time.Duration // 0 means no idle limit
+	refreshAbsolute time.Duration // 0 means no absolute limit
+	opaque          bool
+}
+
+// settingsFor applies a client's overrides to the server-wide token lifetimes
+func (s *OAuth2TokenService) settingsFor(ctx context.Context, clientID string) (*tokenSettings, error) {
+	client, err := s.clientRepo.GetByClientID(ctx, clientID)
+	if err != nil {
+		return nil, err
+	}
+
+	settings := &tokenSettings{
+		accessTTL:       s.accessTokenExpiry,
+		refreshTTL:      s.refreshTokenExpiry,
+		refreshIdle:     time.Duration(client.RefreshIdleTTL) * time.Second,
+		refreshAbsolute: time.Duration(client.RefreshAbsoluteTTL) * time.Second,
+		opaque:          client.AccessTokenFormat == AccessTokenFormatOpaque,
+	}
+	if client.AccessTokenTTL > 0 {
+		settings.accessTTL = time.Duration(client.AccessTokenTTL) * time.Second
+	}
+	if client.RefreshTokenTTL > 0 {
+		settings.refreshTTL = time.Duration(client.RefreshTokenTTL) * time.Second
+	}
+	settings.idTokenTTL = settings.accessTTL
+	if client.IDTokenTTL > 0 {
+		settings.idTokenTTL = time.Duration(client.IDTokenTTL) * time.Second
+	}
+
+	return settings, nil
+}
+
+func (s *OAuth2TokenService) generateAccessToken(ctx context.Context, clientID string, userID *string, scopes []string, extraClaims map[string]interface{}) (string, *models.OAuth2AccessToken, error) {
+	settings, err := s.settingsFor(ctx, clientID)
 	if err != nil {
 		return "", nil, err
 	}
 
+	now := time.Now()
+	expiresAt := now.Add(settings.accessTTL)
+
+	var token string
+	if settings.opaque {
+		// Reference token: carries nothing and is only resolved through introspection
+		token = generateRandomToken(32)
+	} else {
+		// Create custom claims for OAuth2; jti keeps tokens issued in the same second distinct
+		claims := map[string]interface{}{
+			"jti":       uuid.New().String(),
+			"client_id": clientID,
+			"scope":     strings.Join(scopes, " "),
+			"iat":       now.Unix(),
+			"exp":       expiresAt.Unix(),
+		}
+		for name, value := range extraClaims {
+			claims[name] = value
+		}
+
+		if userID != nil {
+			sub, err := s.subjectService.SubjectFor(ctx, clientID, *userID)
+			if err != nil {
+				return "", nil, err
+			}
+			claims["sub"] = sub
+		}
+
+		// Generate JWT token
+		token, err = s.jwtService.GenerateCustomToken(claims)
+		if err != nil {
+			return "", nil, err
+		}
+	}
+
 	// Hash token for storage
 	tokenHash := hashToken(token)
 
@@ -78,7 +208,7 @@ func (s *OAuth2TokenService) GenerateAccessToken(ctx context.Context, clientID s
 		ClientID:  clientID,
 		UserID:    userID,
 		Scopes:    scopes,
-		ExpiresAt: time.Now().Add(s.accessTokenExpiry),
+		ExpiresAt: expiresAt,
 	}
 
 	if err := s.tokenRepo.CreateAccessToken(ctx, accessToken); err != nil {
@@ -88,20 +218,47 @@ func (s *OAuth2TokenService) GenerateAccessToken(ctx context.Context, clientID s
 	return token, accessToken, nil
 }
 
-// GenerateRefreshToken creates a new refresh token
+// GenerateRefreshToken creates a new refresh token, starting a new grant
 func (s *OAuth2TokenService) GenerateRefreshToken(ctx context.Context, clientID, userID string, scopes []string, accessTokenID string) (string, error) {
+	return s.generateRefreshToken(ctx, clientID, userID, scopes, accessTokenID, nil)
+}
+
+// generateRefreshToken creates a refresh token; absoluteExpiresAt is the limit of the
+// grant being rotated, or nil to start a grant with the client's absolute lifetime
+func (s *OAuth2TokenService) generateRefreshToken(ctx context.Context, clientID, userID string, scopes []string, accessTokenID string, absoluteExpiresAt *time.Time) (string, error) {
+	settings, err := s.settingsFor(ctx, clientID)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	if absoluteExpiresAt == nil && settings.refreshAbsolute > 0 {
+		limit := now.Add(settings.refreshAbsolute)
+		absoluteExpiresAt = &limit
+	}
+
+	// Every use rotates the token, so a short lifetime per token is the idle limit
+	expiresAt := now.Add(settings.refreshTTL)
+	if settings.refreshIdle > 0 && now.Add(settings.refreshIdle).Before(expiresAt) {
+		expiresAt = now.Add(settings.refreshIdle)
+	}
+	if absoluteExpiresAt != nil && absoluteExpiresAt.Before(expiresAt) {
+		expiresAt = *absoluteExpiresAt
+	}
+
 	// Generate random refresh token
 	token := generateRandomToken(32)
 
 	refreshToken := &models.OAuth2RefreshToken{
-		ID:            uuid.New().String(),
-		Token:         token,
-		AccessTokenID: &accessTokenID,
-		ClientID:      clientID,
-		UserID:        userID,
-		Scopes:        scopes,
-		ExpiresAt:     time.Now().Add(s.refreshTokenExpiry),
-		Revoked:       false,
+		ID:                uuid.New().String(),
+		Token:             token,
+		AccessTokenID:     &accessTokenID,
+		ClientID:          clientID,
+		UserID:            userID,
+		Scopes:            scopes,
+		ExpiresAt:         expiresAt,
+		AbsoluteExpiresAt: absoluteExpiresAt,
+		Revoked:           false,
 	}
 
 	if err := s.tokenRepo.CreateRefreshToken(ctx, refreshToken); err != nil {
@@ -111,6 +268,32 @@ func (s *OAuth2TokenService) GenerateRefreshToken(ctx context.Context, clientID,
 	return token, nil
 }
 
+// GenerateIDToken creates an OpenID Connect ID token when the openid scope was granted
+func (s *OAuth2TokenService) GenerateIDToken(ctx context.Context, clientID, userID string, scopes []string) (string, error) {
+	if !containsScope(scopes, "openid") {
+		return "", nil
+	}
+
+	settings, err := s.settingsFor(ctx, clientID)
+	if err != nil {
+		return "", err
+	}
+
+	sub, err := s.subjectService.SubjectFor(ctx, clientID, userID)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	return s.jwtService.GenerateCustomToken(map[string]interface{}{
+		"iss": s.jwtService.Issuer(),
+		"sub": sub,
+		"aud": clientID,
+		"iat": now.Unix(),
+		"exp": now.Add(settings.idTokenTTL).Unix(),
+	})
+}
+
 // RefreshAccessToken issues a new access token using a refresh token
 func (s *OAuth2TokenService) RefreshAccessToken(ctx context.Context, refreshTokenString, clientID string) (*TokenResponse, error) {
 	// Validate refresh token
@@ -141,49 +324,93 @@ func (s *OAuth2TokenService) RefreshAccessToken(ctx context.Context, refreshToke
 	// 	return nil, err
 	// }
 
-	// Generate new refresh token (rotation)
-	newRefreshToken, err := s.GenerateRefreshToken(
+	// Generate new refresh token (rotation), keeping the grant's absolute expiry
+	newRefreshToken, err := s.generateRefreshToken(
 		ctx,
 		refreshToken.ClientID,
 		refreshToken.UserID,
 		refreshToken.Scopes,
 		accessToken.ID,
+		refreshToken.AbsoluteExpiresAt,
 	)
 	if err != nil {
 		return nil, err
 	}
 
+	idToken, err := s.GenerateIDToken(ctx, refreshToken.ClientID, refreshToken.UserID, refreshToken.Scopes)
+	if err != nil {
+		return nil, err
+	}
+
 	// Revoke old refresh token after new one is created
 	s.tokenRepo.RevokeRefreshToken(ctx, refreshTokenString)
 
 	return &TokenResponse{
 		AccessToken:  accessTokenString,
 		TokenType:    "Bearer",
-		ExpiresIn:    int64(s.accessTokenExpiry.Seconds()),
+		ExpiresIn:    ExpiresIn(accessToken),
 		RefreshToken: newRefreshToken,
 		Scope:        strings.Join(refreshToken.Scopes, " "),
+		IDToken:      idToken,
 	}, nil
 }
 
 // ValidateAccessToken validates an OAuth2 access token
 func (s *OAuth2TokenService) ValidateAccessToken(ctx context.Context, tokenString string) (*models.OAuth2AccessToken, error) {
-	// Verify JWT signature and expiry
-	claims, err := s.jwtService.ValidateToken(tokenString)
-	if err != nil {
-		return nil, err
+	// Verify JWT signature and expiry; opaque reference tokens are only known by their hash
+	if isJWT(tokenString) {
+		if _, err := s.jwtService.ValidateToken(tokenString); err != nil {
+			return nil, err
+		}
 	}
 
 	// Hash token and check database
 	tokenHash := hashToken(tokenString)
-	token, err := s.tokenRepo.GetAccessTokenByHash(ctx, tokenHash)
-	if err != nil {
-		return nil, err
+	return s.tokenRepo.GetAccessTokenByHash(ctx, tokenHash)
+}
+
+// IntrospectToken reports whether a token is active, following RFC 7662.
+// Unknown, expired and revoked tokens all come back as inactive, as do tokens of
+// other clients unless callerID is an allowed resource server.
+func (s *OAuth2TokenService) IntrospectToken(ctx context.Context, token, tokenTypeHint, callerID string) (*IntrospectionResponse, error) {
+	if tokenTypeHint != "refresh_token" {
+		if accessToken, err := s.ValidateAccessToken(ctx, token); err == nil {
+			return s.introspection(ctx, callerID, accessToken.ClientID, accessToken.UserID, accessToken.Scopes, "Bearer", accessToken.CreatedAt, accessToken.ExpiresAt)
+		}
 	}
 
-	// Additional validation can be done with claims
-	_ = claims
+	if refreshToken, err := s.tokenRepo.GetRefreshToken(ctx, token); err == nil {
+		return s.introspection(ctx, callerID, refreshToken.ClientID, &refreshToken.UserID, refreshToken.Scopes, "refresh_token", refreshToken.CreatedAt, refreshToken.ExpiresAt)
+	}
 
-	return token, nil
+	return &IntrospectionResponse{Active: false}, nil
+}
+
+// introspection builds an active introspection response with the client's view of
+// the subject, or an inactive one when the caller may not see the token
+func (s *OAuth2TokenService) introspection(ctx context.Context, callerID, clientID string, userID *string, scopes []string, tokenType string, issuedAt, expiresAt time.Time) (*IntrospectionResponse, error) {
+	if callerID != clientID && !s.resourceServers[callerID] {
+		return &IntrospectionResponse{Active: false}, nil
+	}
+
+	resp := &IntrospectionResponse{
+		Active:    true,
+		Scope:     strings.Join(scopes, " "),
+		ClientID:  clientID,
+		TokenType: tokenType,
+		Exp:       expiresAt.Unix(),
+		Iat:       issuedAt.Unix(),
+	}
+
+	if userID != nil {
+		sub, err := s.subjectService.SubjectFor(ctx, clientID, *userID)
+		if err != nil {
+			return nil, err
+		}
+		resp.Sub = sub
+	}
+
+	return resp, nil
 }
 
 // RevokeToken revokes an access or refresh token
@@ -207,6 +434,65 @@ func (s *OAuth2TokenService) RevokeAllUserTokens(ctx context.Context, userID str
 	return s.tokenRepo.RevokeAllUserTokens(ctx, userID)
 }
 
+// ActiveTokens holds a page of live access and refresh tokens (metadata only)
+type ActiveTokens struct {
+	AccessTokens       []*models.OAuth2AccessToken  `json:"access_tokens"`
+	RefreshTokens      []*models.OAuth2RefreshToken `json:"refresh_tokens"`
+	TotalAccessTokens  int64                        `json:"total_access_tokens"`
+	TotalRefreshTokens int64                        `json:"total_refresh_tokens"`
+}
+
+// RevokedTokens reports how many tokens a bulk revocation affected
+type RevokedTokens struct {
+	AccessTokens  int64 `json:"access_tokens"`
+	RefreshTokens int64 `json:"refresh_tokens"`
+}
+
+// ListActiveTokens retrieves live tokens matching a filter; token values are never returned
+func (s *OAuth2TokenService) ListActiveTokens(ctx context.Context, filter repository.TokenFilter, page, limit int) (*ActiveTokens, error) {
+	offset := (page - 1) * limit
+
+	accessTokens, totalAccess, err := s.tokenRepo.ListActiveAccessTokens(ctx, filter, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+
+	refreshTokens, totalRefresh, err := s.tokenRepo.ListActiveRefreshTokens(ctx, filter, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ActiveTokens{
+		AccessTokens:       accessTokens,
+		RefreshTokens:      refreshTokens,
+		TotalAccessTokens:  totalAccess,
+		TotalRefreshTokens: totalRefresh,
+	}, nil
+}
+
+// BulkRevokeTokens revokes every access and refresh token matching a filter
+func (s *OAuth2TokenService) BulkRevokeTokens(ctx context.Context, filter repository.TokenFilter) (*RevokedTokens, error) {
+	// Refuse to wipe every token in the system by accident
+	if filter.IsEmpty() {
+		return nil, ErrTokenFilterRequired
+	}
+
+	refreshCount, err := s.tokenRepo.RevokeRefreshTokens(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	accessCount, err := s.tokenRepo.RevokeAccessTokens(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	return &RevokedTokens{
+		AccessTokens:  accessCount,
+		RefreshTokens: refreshCount,
+	}, nil
+}
+
 // CleanupExpiredTokens removes expired tokens from database
 func (s *OAuth2TokenService) CleanupExpiredTokens(ctx context.Context) error {
 	if err := s.tokenRepo.DeleteExpiredAccessTokens(ctx); err != nil {
@@ -222,6 +508,44 @@ func hashToken(token string) string {
 	return hex.EncodeToString(hash[:])
 }
 
+// validateTokenSettings checks a client's token lifetimes and access token format
+func validateTokenSettings(client *models.OAuth2Client) error {
+	switch client.AccessTokenFormat {
+	case "":
+		client.AccessTokenFormat = AccessTokenFormatJWT
+	case AccessTokenFormatJWT, AccessTokenFormatOpaque:
+	default:
+		return ErrInvalidTokenFormat
+	}
+
+	for _, ttl := range []int{client.AccessTokenTTL, client.IDTokenTTL, client.RefreshTokenTTL, client.RefreshAbsoluteTTL, client.RefreshIdleTTL} {
+		if ttl < 0 {
+			return ErrInvalidTokenLifetime
+		}
+	}
+
+	return nil
+}
+
+// ExpiresIn is the expires_in value of a token response for an access token
+func ExpiresIn(token *models.OAuth2AccessToken) int64 {
+	return int64(time.Until(token.ExpiresAt).Round(time.Second).Seconds())
+}
+
+// isJWT reports whether a token has the three segments of a compact JWS
+func isJWT(token string) bool {
+	return strings.Count(token, ".") == 2
+}
+
+func containsScope(scopes []string, scope string) bool {
+	for _, granted := range scopes {
+		if granted == scope {
+			return true
+		}
+	}
+	return false
+}
+
 func generateRandomToken(length int) string {
 	b := make([]byte, length)
 	rand.Read(b)
@@ -0,0 +1,175 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sso-project/sso-server/internal/repository"
+	"github.com/sso-project/sso-server/internal/testutil"
+)
+
+func TestOAuth2TokenService_ListAndBulkRevoke(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+	defer testutil.TeardownTestDB(t, db)
+
+	jwtService, err := NewJWTService("test-issuer", 15*time.Minute, time.Hour)
+	require.NoError(t, err)
+
+	clientRepo := repository.NewOAuth2ClientRepository(db.DB)
+	subjectService := NewOAuth2SubjectService(
+		repository.NewOAuth2SubjectRepository(db.DB),
+		clientRepo,
+		"test-pairwise-secret",
+	)
+	tokenRepo := repository.NewOAuth2TokenRepository(db.DB)
+	tokenService := NewOAuth2TokenService(tokenRepo, clientRepo, jwtService, subjectService, 15*time.Minute, time.Hour)
+	ctx := context.Background()
+
+	alice := testutil.CreateTestUser(t, db, "alice@example.com")
+	bob := testutil.CreateTestUser(t, db, "bob@example.com")
+	testutil.CreateTestOAuth2ClientWithScopes(t, db, "web-app", []string{"openid"})
+	testutil.CreateTestOAuth2ClientWithScopes(t, db, "cli-app", []string{"openid"})
+
+	issue := func(clientID, userID string) {
+		_, access, err := tokenService.GenerateAccessToken(ctx, clientID, &userID, []string{"openid"})
+		require.NoError(t, err)
+		_, err = tokenService.GenerateRefreshToken(ctx, clientID, userID, []string{"openid"}, access.ID)
+		require.NoError(t, err)
+	}
+	issue("web-app", alice.ID)
+	issue("cli-app", alice.ID)
+	issue("web-app", bob.ID)
+
+	active, err := tokenService.ListActiveTokens(ctx, repository.TokenFilter{UserID: alice.ID}, 1, 20)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), active.TotalAccessTokens)
+	assert.Equal(t, int64(2), active.TotalRefreshTokens)
+
+	// An unfiltered bulk revoke is refused
+	_, err = tokenService.BulkRevokeTokens(ctx, repository.TokenFilter{})
+	assert.ErrorIs(t, err, ErrTokenFilterRequired)
+
+	revoked, err := tokenService.BulkRevokeTokens(ctx, repository.TokenFilter{ClientID: "web-app"})
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), revoked.AccessTokens)
+	assert.Equal(t, int64(2), revoked.RefreshTokens)
+
+	// Only the cli-app tokens survive
+	active, err = tokenService.ListActiveTokens(ctx, repository.TokenFilter{UserID: alice.ID}, 1, 20)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), active.TotalAccessTokens)
+	assert.Equal(t, int64(1), active.TotalRefreshTokens)
+	assert.Equal(t, "cli-app", active.AccessTokens[0].ClientID)
+
+	// A future issued_after window matches nothing
+	future := time.Now().Add(time.Hour)
+	active, err = tokenService.ListActiveTokens(ctx, repository.TokenFilter{IssuedAfter: &future}, 1, 20)
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), active.TotalAccessTokens)
+}
+
+func TestOAuth2TokenService_ClientTokenSettings(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+	defer testutil.TeardownTestDB(t, db)
+
+	jwtService, err := NewJWTService("test-issuer", 15*time.Minute, time.Hour)
+	require.NoError(t, err)
+
+	clientRepo := repository.NewOAuth2ClientRepository(db.DB)
+	subjectService := NewOAuth2SubjectService(repository.NewOAuth2SubjectRepository(db.DB), clientRepo, "test-pairwise-secret")
+	tokenRepo := repository.NewOAuth2TokenRepository(db.DB)
+	tokenService := NewOAuth2TokenService(tokenRepo, clientRepo, jwtService, subjectService, 15*time.Minute, time.Hour)
+	clientService := NewOAuth2ClientService(clientRepo, repository.NewOAuth2ScopeRepository(db.DB))
+	ctx := context.Background()
+
+	alice := testutil.CreateTestUser(t, db, "alice@example.com")
+	testutil.CreateTestOAuth2ClientWithScopes(t, db, "partner-app", []string{"openid"})
+	scopes := []string{"openid"}
+
+	_, err = clientService.UpdateTokenSettings(ctx, "partner-app", TokenSettings{AccessTokenTTL: -1})
+	assert.ErrorIs(t, err, ErrInvalidTokenLifetime)
+	_, err = clientService.UpdateTokenSettings(ctx, "partner-app", TokenSettings{AccessTokenFormat: "paseto"})
+	assert.ErrorIs(t, err, ErrInvalidTokenFormat)
+
+	// Server defaults apply until the client overrides them
+	_, access, err := tokenService.GenerateAccessToken(ctx, "partner-app", &alice.ID, scopes)
+	require.NoError(t, err)
+	assert.InDelta(t, 15*60, ExpiresIn(access), 2)
+
+	client, err := clientService.UpdateTokenSettings(ctx, "partner-app", TokenSettings{
+		AccessTokenTTL:     60,
+		IDTokenTTL:         300,
+		RefreshTokenTTL:    7200,
+		RefreshIdleTTL:     1800,
+		RefreshAbsoluteTTL: 3600,
+		AccessTokenFormat:  AccessTokenFormatOpaque,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, AccessTokenFormatOpaque, client.AccessTokenFormat)
+
+	// Opaque tokens carry no claims but validate and introspect through their hash
+	token, access, err := tokenService.GenerateAccessToken(ctx, "partner-app", &alice.ID, scopes)
+	require.NoError(t, err)
+	assert.False(t, isJWT(token))
+	assert.InDelta(t, 60, ExpiresIn(access), 2)
+
+	validated, err := tokenService.ValidateAccessToken(ctx, token)
+	require.NoError(t, err)
+	assert.Equal(t, access.ID, validated.ID)
+
+	introspection, err := tokenService.IntrospectToken(ctx, token, "", "partner-app")
+	require.NoError(t, err)
+	assert.True(t, introspection.Active)
+	assert.Equal(t, "partner-app", introspection.ClientID)
+
+	// Other clients only see the token once allowed as a resource server
+	introspection, err = tokenService.IntrospectToken(ctx, token, "", "other-app")
+	require.NoError(t, err)
+	assert.False(t, introspection.Active)
+	assert.Empty(t, introspection.Sub)
+	tokenService.AllowIntrospection("other-app")
+	introspection, err = tokenService.IntrospectToken(ctx, token, "", "other-app")
+	require.NoError(t, err)
+	assert.True(t, introspection.Active)
+
+	_, err = tokenService.ValidateAccessToken(ctx, "not-a-token")
+	assert.Error(t, err)
+
+	// The idle limit is shorter than the per-token lifetime, so it wins
+	refresh, err := tokenService.GenerateRefreshToken(ctx, "partner-app", alice.ID, scopes, access.ID)
+	require.NoError(t, err)
+	stored, err := tokenRepo.GetRefreshToken(ctx, refresh)
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(30*time.Minute), stored.ExpiresAt, 5*time.Second)
+	require.NotNil(t, stored.AbsoluteExpiresAt)
+	absolute := *stored.AbsoluteExpiresAt
+
+	// Rotation keeps the absolute limit of the original grant
+	resp, err := tokenService.RefreshAccessToken(ctx, refresh, "partner-app")
+	require.NoError(t, err)
+	rotated, err := tokenRepo.GetRefreshToken(ctx, resp.RefreshToken)
+	require.NoError(t, err)
+	require.NotNil(t, rotated.AbsoluteExpiresAt)
+	assert.WithinDuration(t, absolute, *rotated.AbsoluteExpiresAt, time.Second)
+
+	idToken, err := tokenService.GenerateIDToken(ctx, "partner-app", alice.ID, scopes)
+	require.NoError(t, err)
+	idClaims := jwt.RegisteredClaims{}
+	_, _, err = jwt.NewParser().ParseUnverified(idToken, &idClaims)
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(5*time.Minute), idClaims.ExpiresAt.Time, 5*time.Second)
+
+	// Close to the absolute limit, a rotated token expires with the grant
+	nearLimit := time.Now().Add(time.Minute)
+	require.NoError(t, db.DB.Model(rotated).Update("absolute_expires_at", nearLimit).Error)
+	resp, err = tokenService.RefreshAccessToken(ctx, resp.RefreshToken, "partner-app")
+	require.NoError(t, err)
+	last, err := tokenRepo.GetRefreshToken(ctx, resp.RefreshToken)
+	require.NoError(t, err)
+	assert.WithinDuration(t, nearLimit, last.ExpiresAt, time.Second)
+}
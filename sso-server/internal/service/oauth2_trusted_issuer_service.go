@@ -0,0 +1,333 @@
+package service
+
+import (
+	"context"
+	"crypto"
+	"errors"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/sso-project/sso-server/internal/models"
+	"github.com/sso-project/sso-server/internal/repository"
+)
+
+// GrantTypeJWTBearer is the RFC 7523 grant for exchanging an external JWT
+const GrantTypeJWTBearer = "urn:ietf:params:oauth:grant-type:jwt-bearer"
+
+var (
+	ErrTrustedIssuerExists    = errors.New("issuer is already trusted")
+	ErrIssuerRequired         = errors.New("issuer is required")
+	ErrInvalidJWKSURL         = errors.New("invalid jwks_url")
+	ErrIssuerKeysRequired     = errors.New("either jwks_url or jwks is required")
+	ErrInsecureJWKSURL        = errors.New("jwks_url must use https")
+	ErrIssuerRulesRequired    = errors.New("allowed_subjects and audiences are required")
+	ErrIssuerTargetRequired   = errors.New("client_id or service_account_id is required")
+	ErrInvalidAssertion       = errors.New("invalid assertion")
+	ErrUntrustedIssuer        = errors.New("assertion issuer is not trusted")
+	ErrAssertionSubject       = errors.New("assertion subject is not allowed")
+	ErrAssertionAudience      = errors.New("assertion audience is not accepted")
+	ErrServiceAccountInactive = errors.New("service account is disabled")
+)
+
+// assertionMethods are the signature algorithms accepted in assertions
+var assertionMethods = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"}
+
+// TrustedIssuerRequest holds the admin-editable settings of a trusted issuer
+type TrustedIssuerRequest struct {
+	Name             string   `json:"name"`
+	Issuer           string   `json:"issuer"`
+	JWKSURL          string   `json:"jwks_url"`
+	JWKS             string   `json:"jwks"`
+	AllowedSubjects  []string `json:"allowed_subjects"`
+	Audiences        []string `json:"audiences"`
+	ClientID         string   `json:"client_id"`
+	ServiceAccountID *string  `json:"service_account_id"`
+	IsActive         *bool    `json:"is_active"`
+}
+
+// VerifiedAssertion is an accepted JWT bearer assertion and the client it maps to
+type VerifiedAssertion struct {
+	Issuer   *models.OAuth2TrustedIssuer
+	Subject  string
+	ClientID string
+}
+
+// Claims returns the claims recorded in access tokens issued for the assertion
+func (a *VerifiedAssertion) Claims() map[string]interface{} {
+	return map[string]interface{}{
+		"federated_issuer":  a.Issuer.Issuer,
+		"federated_subject": a.Subject,
+	}
+}
+
+// OAuth2TrustedIssuerService manages trusted external issuers and verifies
+// their JWTs for the JWT bearer grant
+type OAuth2TrustedIssuerService struct {
+	issuerRepo  *repository.OAuth2TrustedIssuerRepository
+	clientRepo  *repository.OAuth2ClientRepository
+	accountRepo *repository.ServiceAccountRepository
+	jwks        *jwksCache
+}
+
+// NewOAuth2TrustedIssuerService creates a new OAuth2TrustedIssuerService
+func NewOAuth2TrustedIssuerService(
+	issuerRepo *repository.OAuth2TrustedIssuerRepository,
+	clientRepo *repository.OAuth2ClientRepository,
+	accountRepo *repository.ServiceAccountRepository,
+	jwksCacheTTL time.Duration,
+) *OAuth2TrustedIssuerService {
+	return &OAuth2TrustedIssuerService{
+		issuerRepo:  issuerRepo,
+		clientRepo:  clientRepo,
+		accountRepo: accountRepo,
+		jwks:        newJWKSCache(jwksCacheTTL),
+	}
+}
+
+// CreateIssuer trusts a new external issuer
+func (s *OAuth2TrustedIssuerService) CreateIssuer(ctx context.Context, req TrustedIssuerRequest) (*models.OAuth2TrustedIssuer, error) {
+	if _, err := s.issuerRepo.GetByIssuer(ctx, req.Issuer); err == nil {
+		return nil, ErrTrustedIssuerExists
+	} else if !errors.Is(err, repository.ErrTrustedIssuerNotFound) {
+		return nil, err
+	}
+
+	issuer := &models.OAuth2TrustedIssuer{
+		ID:       uuid.New().String(),
+		IsActive: true,
+	}
+	if err := s.apply(ctx, issuer, req); err != nil {
+		return nil, err
+	}
+
+	if err := s.issuerRepo.Create(ctx, issuer); err != nil {
+		return nil, err
+	}
+
+	return issuer, nil
+}
+
+// UpdateIssuer replaces the settings of a trusted issuer
+func (s *OAuth2TrustedIssuerService) UpdateIssuer(ctx context.Context, id string, req TrustedIssuerRequest) (*models.OAuth2TrustedIssuer, error) {
+	issuer, err := s.issuerRepo.GetByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.Issuer != issuer.Issuer {
+		if _, err := s.issuerRepo.GetByIssuer(ctx, req.Issuer); err == nil {
+			return nil, ErrTrustedIssuerExists
+		} else if !errors.Is(err, repository.ErrTrustedIssuerNotFound) {
+			return nil, err
+		}
+	}
+
+	previousURL := issuer.JWKSURL
+	if err := s.apply(ctx, issuer, req); err != nil {
+		return nil, err
+	}
+
+	if err := s.issuerRepo.Update(ctx, issuer); err != nil {
+		return nil, err
+	}
+
+	s.jwks.forget(previousURL)
+	return issuer, nil
+}
+
+// GetIssuer retrieves a trusted issuer by ID
+func (s *OAuth2TrustedIssuerService) GetIssuer(ctx context.Context, id string) (*models.OAuth2TrustedIssuer, error) {
+	return s.issuerRepo.GetByID(ctx, id)
+}
+
+// ListIssuers retrieves all trusted issuers
+func (s *OAuth2TrustedIssuerService) ListIssuers(ctx context.Context) ([]*models.OAuth2TrustedIssuer, error) {
+	return s.issuerRepo.GetAll(ctx)
+}
+
+// DeleteIssuer stops trusting an issuer
+func (s *OAuth2TrustedIssuerService) DeleteIssuer(ctx context.Context, id string) error {
+	issuer, err := s.issuerRepo.GetByID(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	if err := s.issuerRepo.Delete(ctx, id); err != nil {
+		return err
+	}
+
+	s.jwks.forget(issuer.JWKSURL)
+	return nil
+}
+
+// apply validates req and copies it onto issuer
+func (s *OAuth2TrustedIssuerService) apply(ctx context.Context, issuer *models.OAuth2TrustedIssuer, req TrustedIssuerRequest) error {
+	if req.Issuer == "" {
+		return ErrIssuerRequired
+	}
+
+	switch {
+	case req.JWKS != "":
+		if _, err := parseJWKS([]byte(req.JWKS)); err != nil {
+			return err
+		}
+	case req.JWKSURL != "":
+		if err := validateJWKSURL(req.JWKSURL); err != nil {
+			return err
+		}
+	default:
+		return ErrIssuerKeysRequired
+	}
+
+	if len(req.AllowedSubjects) == 0 || len(req.Audiences) == 0 {
+		return ErrIssuerRulesRequired
+	}
+
+	// A service account stands for its client; tokens are issued to that client
+	clientID := req.ClientID
+	if req.ServiceAccountID != nil && *req.ServiceAccountID != "" {
+		account, err := s.accountRepo.GetByID(ctx, *req.ServiceAccountID)
+		if err != nil {
+			return err
+		}
+		clientID = account.ClientID
+	} else {
+		req.ServiceAccountID = nil
+	}
+
+	if clientID == "" {
+		return ErrIssuerTargetRequired
+	}
+	if _, err := s.clientRepo.GetByClientID(ctx, clientID); err != nil {
+		return err
+	}
+
+	issuer.Name = req.Name
+	if issuer.Name == "" {
+		issuer.Name = req.Issuer
+	}
+	issuer.Issuer = req.Issuer
+	issuer.JWKSURL = req.JWKSURL
+	issuer.JWKS = req.JWKS
+	issuer.AllowedSubjects = req.AllowedSubjects
+	issuer.Audiences = req.Audiences
+	issuer.ClientID = clientID
+	issuer.ServiceAccountID = req.ServiceAccountID
+	if req.IsActive != nil {
+		issuer.IsActive = *req.IsActive
+	}
+
+	return nil
+}
+
+// validateJWKSURL requires https, except for local development endpoints
+func validateJWKSURL(raw string) error {
+	return validateFetchURL(raw, ErrInvalidJWKSURL, ErrInsecureJWKSURL)
+}
+
+// validateFetchURL checks a URL the server fetches keys or metadata from: it must
+// be absolute and use https, except for local development endpoints
+func validateFetchURL(raw string, invalid, insecure error) error {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return invalid
+	}
+
+	host := u.Hostname()
+	if u.Scheme == "https" || (u.Scheme == "http" && (host == "localhost" || host == "127.0.0.1")) {
+		return nil
+	}
+	return insecure
+}
+
+// VerifyAssertion checks a JWT bearer assertion (RFC 7523 section 3) against the
+// trusted issuer named in its iss claim: signature, expiry, audience and subject.
+func (s *OAuth2TrustedIssuerService) VerifyAssertion(ctx context.Context, assertion string) (*VerifiedAssertion, error) {
+	// The issuer decides which keys to verify with, so read it before verifying
+	unverified, _, err := jwt.NewParser().ParseUnverified(assertion, &jwt.RegisteredClaims{})
+	if err != nil {
+		return nil, ErrInvalidAssertion
+	}
+	iss, err := unverified.Claims.GetIssuer()
+	if err != nil || iss == "" {
+		return nil, ErrInvalidAssertion
+	}
+
+	issuer, err := s.issuerRepo.GetByIssuer(ctx, iss)
+	if err != nil {
+		if errors.Is(err, repository.ErrTrustedIssuerNotFound) {
+			return nil, ErrUntrustedIssuer
+		}
+		return nil, err
+	}
+	if !issuer.IsActive {
+		return nil, ErrUntrustedIssuer
+	}
+
+	parser := jwt.NewParser(
+		jwt.WithValidMethods(assertionMethods),
+		jwt.WithIssuer(issuer.Issuer),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(time.Minute),
+	)
+
+	claims := &jwt.RegisteredClaims{}
+	if _, err := parser.ParseWithClaims(assertion, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return s.keyFor(ctx, issuer, kid)
+	}); err != nil {
+		return nil, ErrInvalidAssertion
+	}
+
+	if !slices.ContainsFunc(claims.Audience, func(aud string) bool {
+		return slices.Contains(issuer.Audiences, aud)
+	}) {
+		return nil, ErrAssertionAudience
+	}
+
+	if claims.Subject == "" || !slices.ContainsFunc(issuer.AllowedSubjects, func(pattern string) bool {
+		return matchSubject(pattern, claims.Subject)
+	}) {
+		return nil, ErrAssertionSubject
+	}
+
+	if issuer.ServiceAccountID != nil {
+		account, err := s.accountRepo.GetByID(ctx, *issuer.ServiceAccountID)
+		if err != nil {
+			return nil, err
+		}
+		if !account.IsActive {
+			return nil, ErrServiceAccountInactive
+		}
+	}
+
+	return &VerifiedAssertion{
+		Issuer:   issuer,
+		Subject:  claims.Subject,
+		ClientID: issuer.ClientID,
+	}, nil
+}
+
+func (s *OAuth2TrustedIssuerService) keyFor(ctx context.Context, issuer *models.OAuth2TrustedIssuer, kid string) (crypto.PublicKey, error) {
+	if issuer.JWKS != "" {
+		keys, err := parseJWKS([]byte(issuer.JWKS))
+		if err != nil {
+			return nil, err
+		}
+		return selectKey(keys, kid)
+	}
+	return s.jwks.key(ctx, issuer.JWKSURL, kid)
+}
+
+// matchSubject matches a subject against a pattern in which * stands for any
+// run of characters (including / and :, which workload subjects are full of)
+func matchSubject(pattern, subject string) bool {
+	expr := "^" + strings.ReplaceAll(regexp.QuoteMeta(pattern), `\*`, ".*") + "$"
+	matched, err := regexp.MatchString(expr, subject)
+	return err == nil && matched
+}
@@ -0,0 +1,185 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sso-project/sso-server/internal/repository"
+	"github.com/sso-project/sso-server/internal/testutil"
+)
+
+// testJWKS serves an RSA public key the way a workload platform's issuer would
+func testJWKS(t *testing.T, key *rsa.PrivateKey, kid string) (*httptest.Server, *int32) {
+	var fetches int32
+	set := map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": kid,
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}},
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(set)
+	}))
+	t.Cleanup(server.Close)
+	return server, &fetches
+}
+
+func signAssertion(t *testing.T, key *rsa.PrivateKey, kid string, claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(key)
+	require.NoError(t, err)
+	return signed
+}
+
+func TestOAuth2TrustedIssuerService_JWTBearerGrant(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+	defer testutil.TeardownTestDB(t, db)
+
+	jwtService, err := NewJWTService("test-issuer", 15*time.Minute, time.Hour)
+	require.NoError(t, err)
+
+	clientRepo := repository.NewOAuth2ClientRepository(db.DB)
+	accountRepo := repository.NewServiceAccountRepository(db.DB)
+	subjectService := NewOAuth2SubjectService(repository.NewOAuth2SubjectRepository(db.DB), clientRepo, "test-pairwise-secret")
+	tokenService := NewOAuth2TokenService(repository.NewOAuth2TokenRepository(db.DB), clientRepo, jwtService, subjectService, 15*time.Minute, time.Hour)
+	authzService := NewOAuth2AuthorizationService(
+		repository.NewOAuth2CodeRepository(db.DB),
+		clientRepo,
+		repository.NewOAuth2ConsentRepository(db.DB),
+		accountRepo,
+		tokenService,
+		10*time.Minute,
+		true,
+	)
+	accountService := NewServiceAccountService(accountRepo, clientRepo, repository.NewRoleRepository(db))
+	issuerService := NewOAuth2TrustedIssuerService(repository.NewOAuth2TrustedIssuerRepository(db.DB), clientRepo, accountRepo, time.Hour)
+	ctx := context.Background()
+
+	signingKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	jwks, fetches := testJWKS(t, signingKey, "ci-key-1")
+
+	client := testutil.CreateTestOAuth2ClientWithScopes(t, db, "deployer", []string{})
+	role := testutil.CreateTestRole(t, db, "deployer")
+	account, err := accountService.CreateServiceAccount(ctx, client.ClientID, "", "")
+	require.NoError(t, err)
+	_, err = accountService.AssignRole(ctx, account.ID, role.ID)
+	require.NoError(t, err)
+
+	// Plain http is only accepted for local JWKS endpoints
+	_, err = issuerService.CreateIssuer(ctx, TrustedIssuerRequest{
+		Issuer:          "https://ci.example.com",
+		JWKSURL:         "http://ci.example.com/jwks",
+		AllowedSubjects: []string{"*"},
+		Audiences:       []string{"sso"},
+		ClientID:        client.ClientID,
+	})
+	assert.ErrorIs(t, err, ErrInsecureJWKSURL)
+
+	_, err = issuerService.CreateIssuer(ctx, TrustedIssuerRequest{
+		Issuer:   "https://ci.example.com",
+		JWKSURL:  jwks.URL,
+		ClientID: client.ClientID,
+	})
+	assert.ErrorIs(t, err, ErrIssuerRulesRequired)
+
+	issuer, err := issuerService.CreateIssuer(ctx, TrustedIssuerRequest{
+		Name:             "CI",
+		Issuer:           "https://ci.example.com",
+		JWKSURL:          jwks.URL,
+		AllowedSubjects:  []string{"repo:acme/*:ref:refs/heads/main"},
+		Audiences:        []string{"https://sso.example.com"},
+		ServiceAccountID: &account.ID,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, client.ClientID, issuer.ClientID, "service account maps to its client")
+
+	_, err = issuerService.CreateIssuer(ctx, TrustedIssuerRequest{
+		Issuer:          "https://ci.example.com",
+		JWKSURL:         jwks.URL,
+		AllowedSubjects: []string{"*"},
+		Audiences:       []string{"sso"},
+		ClientID:        client.ClientID,
+	})
+	assert.ErrorIs(t, err, ErrTrustedIssuerExists)
+
+	now := time.Now()
+	claims := func(sub, aud string) jwt.MapClaims {
+		return jwt.MapClaims{
+			"iss": "https://ci.example.com",
+			"sub": sub,
+			"aud": aud,
+			"iat": now.Unix(),
+			"exp": now.Add(5 * time.Minute).Unix(),
+		}
+	}
+
+	assertion := signAssertion(t, signingKey, "ci-key-1", claims("repo:acme/api:ref:refs/heads/main", "https://sso.example.com"))
+	verified, err := issuerService.VerifyAssertion(ctx, assertion)
+	require.NoError(t, err)
+	assert.Equal(t, client.ClientID, verified.ClientID)
+	assert.Equal(t, "repo:acme/api:ref:refs/heads/main", verified.Subject)
+
+	// The exchanged token acts as the service account and names the workload
+	resp, err := authzService.IssueClientToken(ctx, verified.ClientID, nil, verified.Claims())
+	require.NoError(t, err)
+	token, err := jwtService.ValidateToken(resp.AccessToken)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"deployer"}, token.Roles)
+	raw, _, err := jwt.NewParser().ParseUnverified(resp.AccessToken, jwt.MapClaims{})
+	require.NoError(t, err)
+	assert.Equal(t, "https://ci.example.com", raw.Claims.(jwt.MapClaims)["federated_issuer"])
+	assert.Equal(t, "repo:acme/api:ref:refs/heads/main", raw.Claims.(jwt.MapClaims)["federated_subject"])
+
+	// Keys are cached between assertions
+	_, err = issuerService.VerifyAssertion(ctx, assertion)
+	require.NoError(t, err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(fetches))
+
+	_, err = issuerService.VerifyAssertion(ctx, signAssertion(t, signingKey, "ci-key-1", claims("repo:acme/api:ref:refs/heads/dev", "https://sso.example.com")))
+	assert.ErrorIs(t, err, ErrAssertionSubject)
+
+	_, err = issuerService.VerifyAssertion(ctx, signAssertion(t, signingKey, "ci-key-1", claims("repo:acme/api:ref:refs/heads/main", "https://other.example.com")))
+	assert.ErrorIs(t, err, ErrAssertionAudience)
+
+	expired := claims("repo:acme/api:ref:refs/heads/main", "https://sso.example.com")
+	expired["exp"] = now.Add(-10 * time.Minute).Unix()
+	_, err = issuerService.VerifyAssertion(ctx, signAssertion(t, signingKey, "ci-key-1", expired))
+	assert.ErrorIs(t, err, ErrInvalidAssertion)
+
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	_, err = issuerService.VerifyAssertion(ctx, signAssertion(t, otherKey, "ci-key-1", claims("repo:acme/api:ref:refs/heads/main", "https://sso.example.com")))
+	assert.ErrorIs(t, err, ErrInvalidAssertion)
+
+	untrusted := claims("repo:acme/api:ref:refs/heads/main", "https://sso.example.com")
+	untrusted["iss"] = "https://attacker.example.com"
+	_, err = issuerService.VerifyAssertion(ctx, signAssertion(t, signingKey, "ci-key-1", untrusted))
+	assert.ErrorIs(t, err, ErrUntrustedIssuer)
+
+	// Disabling the service account stops the exchange
+	_, err = accountService.SetActive(ctx, account.ID, false)
+	require.NoError(t, err)
+	_, err = issuerService.VerifyAssertion(ctx, assertion)
+	assert.ErrorIs(t, err, ErrServiceAccountInactive)
+}
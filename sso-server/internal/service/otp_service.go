@@ -0,0 +1,407 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sso-project/sso-server/internal/models"
+	"github.com/sso-project/sso-server/internal/repository"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	ErrOTPMethodUnavailable    = errors.New("this verification method is not available")
+	ErrOTPFactorNotEnabled     = errors.New("this verification method is not set up for this account")
+	ErrOTPFactorAlreadyEnabled = errors.New("this verification method is already enabled")
+	ErrInvalidOTPCode          = errors.New("invalid verification code")
+	ErrOTPCodeExpired          = errors.New("the code has expired or was already used, please request a new one")
+	ErrOTPTooManyAttempts      = errors.New("too many wrong codes, please request a new one")
+	ErrOTPResendTooSoon        = errors.New("a code was sent recently, please wait before requesting another")
+)
+
+// What a code is sent for; each has its own latest code
+const (
+	otpPurposeSignIn = "sign_in"
+	otpPurposeEnroll = "enroll"
+)
+
+// otpCodeDigits is the length of the codes sent
+const otpCodeDigits = 6
+
+// OTPSender delivers one-time codes over a channel, such as email
+type OTPSender interface {
+	SendOTP(ctx context.Context, user *models.User, destination, code string, ttl time.Duration) error
+}
+
+// otpDestinationChecker is implemented by senders that only deliver to some
+// destinations, or want them in a canonical form
+type otpDestinationChecker interface {
+	CheckDestination(destination string) (string, error)
+}
+
+// OTPOptions configures one-time codes
+type OTPOptions struct {
+	CodeTTL        time.Duration // How long a code can be used
+	MaxAttempts    int           // Wrong guesses allowed per code
+	ResendInterval time.Duration // Least time between two codes for the same factor
+}
+
+// OTPService manages second factors whose one-time codes are sent to the user.
+// Each method, like email, works once a sender is added for it.
+type OTPService struct {
+	repo           *repository.OTPRepository
+	userRepo       *repository.UserRepository
+	senders        map[string]OTPSender
+	methods        []string // Methods with a sender, in the order they were added
+	codeTTL        time.Duration
+	maxAttempts    int
+	resendInterval time.Duration
+}
+
+// NewOTPService creates a new OTP service
+func NewOTPService(repo *repository.OTPRepository, userRepo *repository.UserRepository, opts OTPOptions) *OTPService {
+	return &OTPService{
+		repo:           repo,
+		userRepo:       userRepo,
+		senders:        make(map[string]OTPSender),
+		codeTTL:        opts.CodeTTL,
+		maxAttempts:    opts.MaxAttempts,
+		resendInterval: opts.ResendInterval,
+	}
+}
+
+// AddSender makes method available, delivering its codes with sender
+func (s *OTPService) AddSender(method string, sender OTPSender) {
+	if _, exists := s.senders[method]; !exists {
+		s.methods = append(s.methods, method)
+	}
+	s.senders[method] = sender
+}
+
+// AvailableMethods returns the methods that can be used
+func (s *OTPService) AvailableMethods() []string {
+	return s.methods
+}
+
+// CodeTTL returns how long a code sent can be used
+func (s *OTPService) CodeTTL() time.Duration {
+	return s.codeTTL
+}
+
+// Methods returns the enabled methods of a user that can be used
+func (s *OTPService) Methods(ctx context.Context, userID string) ([]string, error) {
+	factors, err := s.repo.GetFactorsByUserID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	var methods []string
+	for _, factor := range factors {
+		if _, ok := s.senders[factor.Method]; ok && factor.Enabled {
+			methods = append(methods, factor.Method)
+		}
+	}
+	return methods, nil
+}
+
+// ListFactors returns the factors of a user, including ones not yet confirmed
+func (s *OTPService) ListFactors(ctx context.Context, userID string) ([]*models.OTPFactor, error) {
+	return s.repo.GetFactorsByUserID(ctx, userID)
+}
+
+// BeginEnrollment sets up a factor of a user sending codes to destination, and
+// sends it a code to confirm it with. Starting again replaces the destination
+// of a factor that is not confirmed yet.
+func (s *OTPService) BeginEnrollment(ctx context.Context, userID, method, destination string) (*models.OTPFactor, error) {
+	if _, ok := s.senders[method]; !ok {
+		return nil, ErrOTPMethodUnavailable
+	}
+
+	factor, err := s.repo.GetFactor(ctx, userID, method)
+	switch {
+	case errors.Is(err, repository.ErrOTPFactorNotFound):
+		factor = &models.OTPFactor{
+			ID:     uuid.New().String(),
+			UserID: userID,
+			Method: method,
+		}
+	case err != nil:
+		return nil, err
+	case factor.Enabled:
+		return nil, ErrOTPFactorAlreadyEnabled
+	}
+
+	if checker, ok := s.senders[method].(otpDestinationChecker); ok {
+		if destination, err = checker.CheckDestination(destination); err != nil {
+			return nil, err
+		}
+	}
+
+	factor.Destination = destination
+	if err := s.repo.SaveFactor(ctx, factor); err != nil {
+		return nil, err
+	}
+
+	if err := s.send(ctx, factor, otpPurposeEnroll); err != nil {
+		return nil, err
+	}
+	return factor, nil
+}
+
+// BeginEmailEnrollment sets up the email factor of a user, which sends codes to
+// the email address of their account
+func (s *OTPService) BeginEmailEnrollment(ctx context.Context, userID string) (*models.OTPFactor, error) {
+	user, err := s.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return s.BeginEnrollment(ctx, userID, TwoFactorMethodEmail, user.Email)
+}
+
+// BeginPhoneEnrollment sets up the SMS or voice factor of a user, which sends
+// codes to number, or the phone number of their account if number is empty
+func (s *OTPService) BeginPhoneEnrollment(ctx context.Context, userID, method, number string) (*models.OTPFactor, error) {
+	if method != TwoFactorMethodSMS && method != TwoFactorMethodVoice {
+		return nil, ErrOTPMethodUnavailable
+	}
+
+	if number == "" {
+		user, err := s.userRepo.GetByID(ctx, userID)
+		if err != nil {
+			return nil, err
+		}
+		if number = user.PhoneNumber; number == "" {
+			return nil, ErrPhoneNumberRequired
+		}
+	}
+	return s.BeginEnrollment(ctx, userID, method, number)
+}
+
+// ConfirmEnrollment enables a factor with the code sent by BeginEnrollment
+func (s *OTPService) ConfirmEnrollment(ctx context.Context, userID, method, code string) (*models.OTPFactor, error) {
+	factor, err := s.repo.GetFactor(ctx, userID, method)
+	if err != nil {
+		if errors.Is(err, repository.ErrOTPFactorNotFound) {
+			return nil, ErrOTPFactorNotEnabled
+		}
+		return nil, err
+	}
+	if factor.Enabled {
+		return nil, ErrOTPFactorAlreadyEnabled
+	}
+
+	if err := s.checkCode(ctx, factor, otpPurposeEnroll, code); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	factor.Enabled = true
+	factor.EnabledAt = &now
+	if err := s.repo.SaveFactor(ctx, factor); err != nil {
+		return nil, err
+	}
+
+	// The code proves the user has the phone it was sent to
+	if method == TwoFactorMethodSMS || method == TwoFactorMethodVoice {
+		if err := s.userRepo.UpdatePhone(ctx, userID, factor.Destination, true); err != nil {
+			return nil, err
+		}
+	}
+	return factor, nil
+}
+
+// SendCode sends a sign-in code over an enabled factor of a user, and returns
+// the factor it was sent for
+func (s *OTPService) SendCode(ctx context.Context, userID, method string) (*models.OTPFactor, error) {
+	factor, err := s.enabledFactor(ctx, userID, method)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.send(ctx, factor, otpPurposeSignIn); err != nil {
+		return nil, err
+	}
+	return factor, nil
+}
+
+// Verify checks a sign-in code sent over an enabled factor of a user. Each code
+// is accepted once.
+func (s *OTPService) Verify(ctx context.Context, userID, method, code string) error {
+	factor, err := s.enabledFactor(ctx, userID, method)
+	if err != nil {
+		return err
+	}
+
+	if err := s.checkCode(ctx, factor, otpPurposeSignIn, code); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	factor.LastUsedAt = &now
+	return s.repo.SaveFactor(ctx, factor)
+}
+
+// Disable removes the factor of a user for a method
+func (s *OTPService) Disable(ctx context.Context, userID, method string) error {
+	err := s.repo.DeleteFactor(ctx, userID, method)
+	if errors.Is(err, repository.ErrOTPFactorNotFound) {
+		return ErrOTPFactorNotEnabled
+	}
+	return err
+}
+
+// DisableAll removes every factor of a user and returns how many there were
+func (s *OTPService) DisableAll(ctx context.Context, userID string) (int, error) {
+	deleted, err := s.repo.DeleteFactorsByUserID(ctx, userID)
+	return int(deleted), err
+}
+
+func (s *OTPService) enabledFactor(ctx context.Context, userID, method string) (*models.OTPFactor, error) {
+	if _, ok := s.senders[method]; !ok {
+		return nil, ErrOTPMethodUnavailable
+	}
+
+	factor, err := s.repo.GetFactor(ctx, userID, method)
+	if err != nil {
+		if errors.Is(err, repository.ErrOTPFactorNotFound) {
+			return nil, ErrOTPFactorNotEnabled
+		}
+		return nil, err
+	}
+	if !factor.Enabled {
+		return nil, ErrOTPFactorNotEnabled
+	}
+	return factor, nil
+}
+
+// send generates a code for a factor, replacing the previous one for purpose,
+// and delivers it. Codes are not sent more often than the resend interval.
+func (s *OTPService) send(ctx context.Context, factor *models.OTPFactor, purpose string) error {
+	latest, err := s.repo.GetLatestCode(ctx, factor.ID, purpose)
+	if err != nil && !errors.Is(err, repository.ErrOTPCodeNotFound) {
+		return err
+	}
+	if latest != nil && time.Since(latest.CreatedAt) < s.resendInterval {
+		return ErrOTPResendTooSoon
+	}
+
+	user, err := s.userRepo.GetByID(ctx, factor.UserID)
+	if err != nil {
+		return err
+	}
+
+	code, err := generateOTPCode()
+	if err != nil {
+		return err
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(code), 10)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	stored := &models.OTPCode{
+		ID:        uuid.New().String(),
+		FactorID:  factor.ID,
+		Purpose:   purpose,
+		CodeHash:  string(hash),
+		ExpiresAt: now.Add(s.codeTTL),
+		CreatedAt: now,
+	}
+	if err := s.repo.CreateCode(ctx, stored); err != nil {
+		return err
+	}
+
+	if err := s.senders[factor.Method].SendOTP(ctx, user, factor.Destination, code, s.codeTTL); err != nil {
+		// A code that never arrived should not hold back the next one
+		s.repo.DeleteCode(ctx, stored.ID)
+		return fmt.Errorf("failed to send %s code: %w", factor.Method, err)
+	}
+	return nil
+}
+
+// checkCode spends the latest code of a factor for purpose if code matches it.
+// Each guess counts towards the code's attempts, whether right or wrong.
+func (s *OTPService) checkCode(ctx context.Context, factor *models.OTPFactor, purpose, code string) error {
+	stored, err := s.repo.GetLatestCode(ctx, factor.ID, purpose)
+	if err != nil {
+		if errors.Is(err, repository.ErrOTPCodeNotFound) {
+			return ErrOTPCodeExpired
+		}
+		return err
+	}
+	if time.Now().After(stored.ExpiresAt) {
+		return ErrOTPCodeExpired
+	}
+
+	if err := s.repo.UseAttempt(ctx, stored.ID, s.maxAttempts); err != nil {
+		if errors.Is(err, repository.ErrOTPCodeNotFound) {
+			return ErrOTPTooManyAttempts
+		}
+		return err
+	}
+
+	code = strings.ReplaceAll(strings.TrimSpace(code), " ", "")
+	if bcrypt.CompareHashAndPassword([]byte(stored.CodeHash), []byte(code)) != nil {
+		return ErrInvalidOTPCode
+	}
+
+	if err := s.repo.DeleteCode(ctx, stored.ID); err != nil {
+		if errors.Is(err, repository.ErrOTPCodeNotFound) {
+			return ErrOTPCodeExpired
+		}
+		return err
+	}
+	return nil
+}
+
+// generateOTPCode returns a random numeric code of otpCodeDigits digits
+func generateOTPCode() (string, error) {
+	limit := big.NewInt(1)
+	for i := 0; i < otpCodeDigits; i++ {
+		limit.Mul(limit, big.NewInt(10))
+	}
+
+	n, err := rand.Int(rand.Reader, limit)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%0*d", otpCodeDigits, n), nil
+}
+
+// MaskDestination hides most of an email address or phone number, to show
+// users where a code was sent
+func MaskDestination(destination string) string {
+	if local, domain, ok := strings.Cut(destination, "@"); ok {
+		if len(local) <= 1 {
+			return "***@" + domain
+		}
+		return local[:1] + "***@" + domain
+	}
+
+	if len(destination) <= 2 {
+		return "***"
+	}
+	return "***" + destination[len(destination)-2:]
+}
+
+// EmailOTPSender sends one-time codes by email
+type EmailOTPSender struct {
+	emailService *EmailService
+}
+
+// NewEmailOTPSender creates a sender of one-time codes through emailService
+func NewEmailOTPSender(emailService *EmailService) *EmailOTPSender {
+	return &EmailOTPSender{emailService: emailService}
+}
+
+// SendOTP emails code to destination
+func (s *EmailOTPSender) SendOTP(ctx context.Context, user *models.User, destination, code string, ttl time.Duration) error {
+	return s.emailService.SendOneTimeCodeEmail(destination, user.Name, code, ttl)
+}
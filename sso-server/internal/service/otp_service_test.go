@@ -0,0 +1,207 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sso-project/sso-server/internal/database"
+	"github.com/sso-project/sso-server/internal/models"
+	"github.com/sso-project/sso-server/internal/repository"
+	"github.com/sso-project/sso-server/internal/testutil"
+)
+
+// recordingSender keeps the codes it is asked to send
+type recordingSender struct {
+	destinations []string
+	codes        []string
+	err          error
+}
+
+func (s *recordingSender) SendOTP(ctx context.Context, user *models.User, destination, code string, ttl time.Duration) error {
+	if s.err != nil {
+		return s.err
+	}
+	s.destinations = append(s.destinations, destination)
+	s.codes = append(s.codes, code)
+	return nil
+}
+
+func (s *recordingSender) last() string {
+	return s.codes[len(s.codes)-1]
+}
+
+func newTestOTPService(t *testing.T, db *database.DB) (*OTPService, *recordingSender, *repository.OTPRepository) {
+	repo := repository.NewOTPRepository(db.DB)
+	otpService := NewOTPService(repo, repository.NewUserRepository(db), OTPOptions{
+		CodeTTL:     10 * time.Minute,
+		MaxAttempts: 3,
+	})
+	sender := &recordingSender{}
+	otpService.AddSender(TwoFactorMethodEmail, sender)
+	return otpService, sender, repo
+}
+
+func TestOTPService_EmailEnrollment(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+	defer testutil.TeardownTestDB(t, db)
+
+	otpService, sender, _ := newTestOTPService(t, db)
+	ctx := context.Background()
+	user := testutil.CreateTestUser(t, db, "pia@example.com")
+
+	factor, err := otpService.BeginEmailEnrollment(ctx, user.ID)
+	require.NoError(t, err)
+	assert.False(t, factor.Enabled)
+	assert.Equal(t, []string{user.Email}, sender.destinations)
+	assert.Len(t, sender.last(), otpCodeDigits)
+
+	methods, err := otpService.Methods(ctx, user.ID)
+	require.NoError(t, err)
+	assert.Empty(t, methods, "a factor is not used before it is confirmed")
+
+	_, err = otpService.ConfirmEnrollment(ctx, user.ID, TwoFactorMethodEmail, "not-it")
+	assert.ErrorIs(t, err, ErrInvalidOTPCode)
+
+	factor, err = otpService.ConfirmEnrollment(ctx, user.ID, TwoFactorMethodEmail, sender.last())
+	require.NoError(t, err)
+	assert.True(t, factor.Enabled)
+
+	methods, err = otpService.Methods(ctx, user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, []string{TwoFactorMethodEmail}, methods)
+
+	_, err = otpService.BeginEmailEnrollment(ctx, user.ID)
+	assert.ErrorIs(t, err, ErrOTPFactorAlreadyEnabled)
+
+	require.NoError(t, otpService.Disable(ctx, user.ID, TwoFactorMethodEmail))
+	assert.ErrorIs(t, otpService.Disable(ctx, user.ID, TwoFactorMethodEmail), ErrOTPFactorNotEnabled)
+}
+
+func TestOTPService_Verify(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+	defer testutil.TeardownTestDB(t, db)
+
+	otpService, sender, repo := newTestOTPService(t, db)
+	ctx := context.Background()
+	user := testutil.CreateTestUser(t, db, "quinn@example.com")
+
+	_, err := otpService.SendCode(ctx, user.ID, TwoFactorMethodEmail)
+	assert.ErrorIs(t, err, ErrOTPFactorNotEnabled)
+
+	_, err = otpService.BeginEmailEnrollment(ctx, user.ID)
+	require.NoError(t, err)
+	_, err = otpService.ConfirmEnrollment(ctx, user.ID, TwoFactorMethodEmail, sender.last())
+	require.NoError(t, err)
+
+	t.Run("code is accepted once", func(t *testing.T) {
+		_, err := otpService.SendCode(ctx, user.ID, TwoFactorMethodEmail)
+		require.NoError(t, err)
+		code := sender.last()
+
+		require.NoError(t, otpService.Verify(ctx, user.ID, TwoFactorMethodEmail, code))
+		assert.ErrorIs(t, otpService.Verify(ctx, user.ID, TwoFactorMethodEmail, code), ErrOTPCodeExpired)
+	})
+
+	t.Run("a new code replaces the previous one", func(t *testing.T) {
+		_, err := otpService.SendCode(ctx, user.ID, TwoFactorMethodEmail)
+		require.NoError(t, err)
+		previous := sender.last()
+		_, err = otpService.SendCode(ctx, user.ID, TwoFactorMethodEmail)
+		require.NoError(t, err)
+
+		if previous != sender.last() {
+			assert.ErrorIs(t, otpService.Verify(ctx, user.ID, TwoFactorMethodEmail, previous), ErrInvalidOTPCode)
+		}
+		require.NoError(t, otpService.Verify(ctx, user.ID, TwoFactorMethodEmail, sender.last()))
+	})
+
+	t.Run("wrong guesses use up the code", func(t *testing.T) {
+		_, err := otpService.SendCode(ctx, user.ID, TwoFactorMethodEmail)
+		require.NoError(t, err)
+
+		for i := 0; i < 3; i++ {
+			assert.ErrorIs(t, otpService.Verify(ctx, user.ID, TwoFactorMethodEmail, "abcdef"), ErrInvalidOTPCode)
+		}
+		assert.ErrorIs(t, otpService.Verify(ctx, user.ID, TwoFactorMethodEmail, sender.last()), ErrOTPTooManyAttempts)
+	})
+
+	t.Run("expired code is refused", func(t *testing.T) {
+		factor, err := otpService.SendCode(ctx, user.ID, TwoFactorMethodEmail)
+		require.NoError(t, err)
+
+		stored, err := repo.GetLatestCode(ctx, factor.ID, otpPurposeSignIn)
+		require.NoError(t, err)
+		require.NoError(t, db.DB.Model(stored).Update("expires_at", time.Now().Add(-time.Minute)).Error)
+
+		assert.ErrorIs(t, otpService.Verify(ctx, user.ID, TwoFactorMethodEmail, sender.last()), ErrOTPCodeExpired)
+	})
+
+	t.Run("codes are stored hashed", func(t *testing.T) {
+		factor, err := otpService.SendCode(ctx, user.ID, TwoFactorMethodEmail)
+		require.NoError(t, err)
+
+		stored, err := repo.GetLatestCode(ctx, factor.ID, otpPurposeSignIn)
+		require.NoError(t, err)
+		assert.NotContains(t, stored.CodeHash, sender.last())
+	})
+}
+
+func TestOTPService_SendLimits(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+	defer testutil.TeardownTestDB(t, db)
+
+	otpService, sender, _ := newTestOTPService(t, db)
+	otpService.resendInterval = time.Hour
+	ctx := context.Background()
+	user := testutil.CreateTestUser(t, db, "rosa@example.com")
+
+	sender.err = errors.New("smtp unavailable")
+	_, err := otpService.BeginEmailEnrollment(ctx, user.ID)
+	assert.Error(t, err)
+
+	// A code that failed to send does not hold back the next one
+	sender.err = nil
+	_, err = otpService.BeginEmailEnrollment(ctx, user.ID)
+	require.NoError(t, err)
+
+	_, err = otpService.BeginEmailEnrollment(ctx, user.ID)
+	assert.ErrorIs(t, err, ErrOTPResendTooSoon)
+
+	unavailable := NewOTPService(repository.NewOTPRepository(db.DB), repository.NewUserRepository(db), OTPOptions{})
+	_, err = unavailable.BeginEmailEnrollment(ctx, user.ID)
+	assert.ErrorIs(t, err, ErrOTPMethodUnavailable)
+}
+
+func TestAuthService_Login_EmailSecondFactor(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+	defer testutil.TeardownTestDB(t, db)
+
+	userRepo := repository.NewUserRepository(db)
+	sessionService := NewSessionService(repository.NewDatabaseSessionStore(db), 24*time.Hour)
+	authService := NewAuthService(userRepo, sessionService, repository.NewAuditLogRepository(db), 5, 30*time.Minute)
+	otpService, sender, _ := newTestOTPService(t, db)
+	authService.EnableOTP(otpService)
+	ctx := context.Background()
+
+	user := testutil.CreateTestUserWithPassword(t, db, "sami@example.com", "TestPassword123!")
+	_, err := otpService.BeginEmailEnrollment(ctx, user.ID)
+	require.NoError(t, err)
+	_, err = otpService.ConfirmEnrollment(ctx, user.ID, TwoFactorMethodEmail, sender.last())
+	require.NoError(t, err)
+
+	result, err := authService.Login(ctx, user.Email, "TestPassword123!", "127.0.0.1", "test")
+	require.NoError(t, err)
+	assert.True(t, result.RequiresTwoFactor)
+	assert.Equal(t, []string{TwoFactorMethodEmail}, result.TwoFactorMethods)
+}
+
+func TestMaskDestination(t *testing.T) {
+	assert.Equal(t, "p***@example.com", MaskDestination("pia@example.com"))
+	assert.Equal(t, "***@example.com", MaskDestination("p@example.com"))
+	assert.Equal(t, "***67", MaskDestination("+4915112345667"))
+}
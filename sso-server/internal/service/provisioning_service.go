@@ -0,0 +1,739 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sso-project/sso-server/internal/models"
+	"github.com/sso-project/sso-server/internal/repository"
+)
+
+var (
+	ErrProvisioningConnectorRequired = errors.New("name, client_id and endpoint_url are required")
+	ErrProvisioningConnectorExists   = errors.New("this client already has a provisioning connector")
+	ErrInvalidProvisioningURL        = errors.New("invalid endpoint_url")
+	ErrInsecureProvisioningURL       = errors.New("endpoint_url must use https")
+	ErrInvalidProvisioningAuth       = errors.New("auth_type must be bearer or basic, with a secret (and a username for basic)")
+	ErrInvalidProvisioningMapping    = errors.New("attribute_mapping values must be one of: id, email, name, given_name, family_name, is_active, email_verified, roles")
+	ErrInvalidDeprovision            = errors.New("deprovision must be deactivate or delete")
+	ErrProvisioningUnavailable       = errors.New("application is unreachable")
+	ErrProvisioningRejected          = errors.New("application rejected the request")
+)
+
+const (
+	provisioningAuthBearer = "bearer"
+	provisioningAuthBasic  = "basic"
+
+	provisioningDeactivate = "deactivate"
+	provisioningDelete     = "delete"
+)
+
+// Provisioning states of a user in an application
+const (
+	ProvisioningStateProvisioned = "provisioned"
+	ProvisioningStateDeactivated = "deactivated"
+	ProvisioningStateDeleted     = "deleted"
+	ProvisioningStateError       = "error"
+)
+
+// defaultProvisioningMapping maps SCIM attributes to user fields; a connector's
+// attribute_mapping is applied on top, and an empty field drops an attribute
+var defaultProvisioningMapping = map[string]string{
+	"userName":        "email",
+	"externalId":      "id",
+	"displayName":     "name",
+	"name.formatted":  "name",
+	"name.givenName":  "given_name",
+	"name.familyName": "family_name",
+	"emails":          "email",
+	"active":          "is_active",
+}
+
+var provisioningUserFields = []string{"id", "email", "name", "given_name", "family_name", "is_active", "email_verified", "roles"}
+
+// ProvisioningConnectorRequest holds the admin-editable settings of a connector
+type ProvisioningConnectorRequest struct {
+	Name             string            `json:"name"`
+	ClientID         string            `json:"client_id"`
+	EndpointURL      string            `json:"endpoint_url"`
+	AuthType         string            `json:"auth_type"`
+	Username         string            `json:"username"`
+	Secret           string            `json:"secret"` // Left empty on update to keep the current secret
+	AttributeMapping map[string]string `json:"attribute_mapping"`
+	RoleIDs          []string          `json:"role_ids"`
+	Deprovision      string            `json:"deprovision"`
+	IsActive         *bool             `json:"is_active"`
+}
+
+// ProvisioningOptions configures the job queue worker
+type ProvisioningOptions struct {
+	PollInterval time.Duration // How often the queue is checked for due jobs
+	BatchSize    int
+	MaxAttempts  int           // A job fails for good after this many attempts
+	RetryBackoff time.Duration // Delay before the first retry; doubled on each attempt
+	MaxBackoff   time.Duration
+	Timeout      time.Duration // Per request to an application
+	StaleAfter   time.Duration // Running jobs older than this are put back in the queue
+}
+
+// ProvisioningStatus summarizes the sync status of one application
+type ProvisioningStatus struct {
+	Connector *models.ProvisioningConnector `json:"connector"`
+	Users     map[string]int64              `json:"users"` // By provisioning state
+	Jobs      map[string]int64              `json:"jobs"`  // Queued jobs by status
+	LastError *models.ProvisioningState     `json:"last_error,omitempty"`
+}
+
+// ProvisioningService pushes user lifecycle and role changes to downstream
+// applications through their SCIM APIs. Changes are queued as durable jobs and
+// pushed by a worker with retries and exponential backoff.
+type ProvisioningService struct {
+	repo       *repository.ProvisioningRepository
+	clientRepo *repository.OAuth2ClientRepository
+	userRepo   *repository.UserRepository
+	roleRepo   *repository.RoleRepository
+	opts       ProvisioningOptions
+	client     *http.Client
+}
+
+// NewProvisioningService creates a new ProvisioningService. The queue is checked
+// every 10s by default; failed pushes are retried 8 times, 30s after the first
+// failure and at most 1h apart.
+func NewProvisioningService(
+	repo *repository.ProvisioningRepository,
+	clientRepo *repository.OAuth2ClientRepository,
+	userRepo *repository.UserRepository,
+	roleRepo *repository.RoleRepository,
+	opts ProvisioningOptions,
+) *ProvisioningService {
+	if opts.PollInterval == 0 {
+		opts.PollInterval = 10 * time.Second
+	}
+	if opts.BatchSize == 0 {
+		opts.BatchSize = 50
+	}
+	if opts.MaxAttempts == 0 {
+		opts.MaxAttempts = 8
+	}
+	if opts.RetryBackoff == 0 {
+		opts.RetryBackoff = 30 * time.Second
+	}
+	if opts.MaxBackoff == 0 {
+		opts.MaxBackoff = time.Hour
+	}
+	if opts.Timeout == 0 {
+		opts.Timeout = 15 * time.Second
+	}
+	if opts.StaleAfter == 0 {
+		opts.StaleAfter = 10 * time.Minute
+	}
+
+	return &ProvisioningService{
+		repo:       repo,
+		clientRepo: clientRepo,
+		userRepo:   userRepo,
+		roleRepo:   roleRepo,
+		opts:       opts,
+		client:     &http.Client{Timeout: opts.Timeout},
+	}
+}
+
+// ==================== Connectors ====================
+
+// CreateConnector adds a connector to an OAuth2 client and, when it is active,
+// queues every user in scope
+func (s *ProvisioningService) CreateConnector(ctx context.Context, req ProvisioningConnectorRequest) (*models.ProvisioningConnector, error) {
+	if _, err := s.repo.GetConnectorByClientID(ctx, req.ClientID); err == nil {
+		return nil, ErrProvisioningConnectorExists
+	} else if !errors.Is(err, repository.ErrProvisioningConnectorNotFound) {
+		return nil, err
+	}
+
+	connector := &models.ProvisioningConnector{
+		ID:       uuid.New().String(),
+		IsActive: true,
+	}
+	if err := s.apply(ctx, connector, req); err != nil {
+		return nil, err
+	}
+
+	if err := s.repo.CreateConnector(ctx, connector); err != nil {
+		return nil, err
+	}
+
+	if connector.IsActive {
+		if _, err := s.SyncAll(ctx, connector.ID); err != nil {
+			return nil, err
+		}
+	}
+	return connector, nil
+}
+
+// UpdateConnector replaces the settings of a connector and, when it is active,
+// queues every user again so that mapping and scope changes reach the application
+func (s *ProvisioningService) UpdateConnector(ctx context.Context, id string, req ProvisioningConnectorRequest) (*models.ProvisioningConnector, error) {
+	connector, err := s.repo.GetConnector(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.ClientID == "" {
+		req.ClientID = connector.ClientID
+	} else if req.ClientID != connector.ClientID {
+		if _, err := s.repo.GetConnectorByClientID(ctx, req.ClientID); err == nil {
+			return nil, ErrProvisioningConnectorExists
+		} else if !errors.Is(err, repository.ErrProvisioningConnectorNotFound) {
+			return nil, err
+		}
+	}
+	if req.Secret == "" {
+		req.Secret = connector.Secret
+	}
+
+	if err := s.apply(ctx, connector, req); err != nil {
+		return nil, err
+	}
+
+	if err := s.repo.UpdateConnector(ctx, connector); err != nil {
+		return nil, err
+	}
+
+	if connector.IsActive {
+		if _, err := s.SyncAll(ctx, connector.ID); err != nil {
+			return nil, err
+		}
+	}
+	return connector, nil
+}
+
+// GetConnector retrieves a connector by ID
+func (s *ProvisioningService) GetConnector(ctx context.Context, id string) (*models.ProvisioningConnector, error) {
+	return s.repo.GetConnector(ctx, id)
+}
+
+// ListConnectors retrieves every connector
+func (s *ProvisioningService) ListConnectors(ctx context.Context) ([]*models.ProvisioningConnector, error) {
+	return s.repo.GetConnectors(ctx)
+}
+
+// DeleteConnector deletes a connector and its queue. Users are left as they
+// are in the application.
+func (s *ProvisioningService) DeleteConnector(ctx context.Context, id string) error {
+	return s.repo.DeleteConnector(ctx, id)
+}
+
+func (s *ProvisioningService) apply(ctx context.Context, connector *models.ProvisioningConnector, req ProvisioningConnectorRequest) error {
+	if req.Name == "" || req.ClientID == "" || req.EndpointURL == "" {
+		return ErrProvisioningConnectorRequired
+	}
+	if _, err := s.clientRepo.GetByClientID(ctx, req.ClientID); err != nil {
+		return err
+	}
+	if err := validateFetchURL(req.EndpointURL, ErrInvalidProvisioningURL, ErrInsecureProvisioningURL); err != nil {
+		return err
+	}
+
+	if req.AuthType == "" {
+		req.AuthType = provisioningAuthBearer
+	}
+	switch req.AuthType {
+	case provisioningAuthBearer:
+		req.Username = ""
+	case provisioningAuthBasic:
+		if req.Username == "" {
+			return ErrInvalidProvisioningAuth
+		}
+	default:
+		return ErrInvalidProvisioningAuth
+	}
+	if req.Secret == "" {
+		return ErrInvalidProvisioningAuth
+	}
+
+	for attr, field := range req.AttributeMapping {
+		if attr == "" || (field != "" && !slices.Contains(provisioningUserFields, field)) {
+			return ErrInvalidProvisioningMapping
+		}
+	}
+	for _, roleID := range req.RoleIDs {
+		if _, err := s.roleRepo.GetByID(ctx, roleID); err != nil {
+			return err
+		}
+	}
+
+	if req.Deprovision == "" {
+		req.Deprovision = provisioningDeactivate
+	}
+	if req.Deprovision != provisioningDeactivate && req.Deprovision != provisioningDelete {
+		return ErrInvalidDeprovision
+	}
+
+	connector.Name = req.Name
+	connector.ClientID = req.ClientID
+	connector.EndpointURL = strings.TrimRight(req.EndpointURL, "/")
+	connector.AuthType = req.AuthType
+	connector.Username = req.Username
+	connector.Secret = req.Secret
+	connector.AttributeMapping = req.AttributeMapping
+	if connector.AttributeMapping == nil {
+		connector.AttributeMapping = map[string]string{}
+	}
+	connector.RoleIDs = req.RoleIDs
+	if connector.RoleIDs == nil {
+		connector.RoleIDs = []string{}
+	}
+	connector.Deprovision = req.Deprovision
+	if req.IsActive != nil {
+		connector.IsActive = *req.IsActive
+	}
+
+	return nil
+}
+
+// ==================== Queue ====================
+
+// UserChanged queues an update of a user in every active application. It is
+// called after the user is created, updated, deactivated, deleted or has a role
+// added or removed; reason says which.
+func (s *ProvisioningService) UserChanged(ctx context.Context, userID, reason string) {
+	connectors, err := s.repo.GetActiveConnectors(ctx)
+	if err != nil {
+		log.Printf("Failed to queue provisioning of user %s: %v", userID, err)
+		return
+	}
+	for _, connector := range connectors {
+		if err := s.repo.Enqueue(ctx, connector.ID, userID, reason); err != nil {
+			log.Printf("Failed to queue provisioning of user %s to %s: %v", userID, connector.Name, err)
+		}
+	}
+}
+
+// SyncAll queues every user in the connector's scope, and every user it has
+// provisioned before, and returns how many were queued
+func (s *ProvisioningService) SyncAll(ctx context.Context, connectorID string) (int, error) {
+	connector, err := s.repo.GetConnector(ctx, connectorID)
+	if err != nil {
+		return 0, err
+	}
+
+	condition, args := "", []interface{}(nil)
+	if len(connector.RoleIDs) > 0 {
+		condition = "id IN (SELECT user_id FROM user_roles WHERE role_id IN ?)"
+		args = []interface{}{[]string(connector.RoleIDs)}
+	}
+
+	queued := make(map[string]bool)
+	const pageSize = 500
+	for offset := 0; ; offset += pageSize {
+		users, _, err := s.userRepo.ListWhere(ctx, condition, args, offset, pageSize)
+		if err != nil {
+			return len(queued), err
+		}
+		for _, user := range users {
+			if err := s.repo.Enqueue(ctx, connector.ID, user.ID, "full_sync"); err != nil {
+				return len(queued), err
+			}
+			queued[user.ID] = true
+		}
+		if len(users) < pageSize {
+			break
+		}
+	}
+
+	// Users that left the scope or were deleted since they were provisioned
+	userIDs, err := s.repo.GetStateUserIDs(ctx, connector.ID)
+	if err != nil {
+		return len(queued), err
+	}
+	for _, userID := range userIDs {
+		if queued[userID] {
+			continue
+		}
+		if err := s.repo.Enqueue(ctx, connector.ID, userID, "full_sync"); err != nil {
+			return len(queued), err
+		}
+		queued[userID] = true
+	}
+
+	return len(queued), nil
+}
+
+// Run processes due jobs until ctx is cancelled
+func (s *ProvisioningService) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.opts.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := s.ProcessDue(ctx); err != nil {
+			log.Printf("Provisioning worker: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ProcessDue runs every job that is due and returns how many ran
+func (s *ProvisioningService) ProcessDue(ctx context.Context) (int, error) {
+	if _, err := s.repo.ReleaseStale(ctx, time.Now().Add(-s.opts.StaleAfter)); err != nil {
+		return 0, err
+	}
+
+	processed := 0
+	for {
+		jobs, err := s.repo.ClaimDue(ctx, time.Now(), s.opts.BatchSize)
+		if err != nil {
+			return processed, err
+		}
+		if len(jobs) == 0 {
+			return processed, nil
+		}
+
+		for _, job := range jobs {
+			if err := s.runJob(ctx, job); err != nil {
+				return processed, err
+			}
+			processed++
+		}
+	}
+}
+
+// runJob pushes one user to one application. Errors are recorded on the job;
+// only database errors are returned.
+func (s *ProvisioningService) runJob(ctx context.Context, job *models.ProvisioningJob) error {
+	connector, err := s.repo.GetConnector(ctx, job.ConnectorID)
+	if errors.Is(err, repository.ErrProvisioningConnectorNotFound) {
+		return s.repo.DeleteJob(ctx, job.ID)
+	}
+	if err != nil {
+		return err
+	}
+	// Disabled connectors drop their queue; a full sync catches up when they are enabled again
+	if !connector.IsActive {
+		return s.repo.DeleteJob(ctx, job.ID)
+	}
+
+	syncErr := s.syncUser(ctx, connector, job.UserID)
+	if syncErr == nil {
+		return s.repo.DeleteJob(ctx, job.ID)
+	}
+
+	job.Attempts++
+	job.LastError = syncErr.Error()
+	job.UpdatedAt = time.Now()
+	if retryableProvisioningError(syncErr) && job.Attempts < s.opts.MaxAttempts {
+		job.Status = repository.ProvisioningJobPending
+		job.NextAttemptAt = time.Now().Add(s.backoff(job.Attempts))
+	} else {
+		job.Status = repository.ProvisioningJobFailed
+	}
+	return s.repo.UpdateJob(ctx, job)
+}
+
+// backoff is the delay before the next attempt after the given number of failures
+func (s *ProvisioningService) backoff(attempts int) time.Duration {
+	delay := s.opts.RetryBackoff
+	for i := 1; i < attempts && delay < s.opts.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > s.opts.MaxBackoff {
+		return s.opts.MaxBackoff
+	}
+	return delay
+}
+
+// RetryJob makes a failed or waiting job due now, with its attempts reset
+func (s *ProvisioningService) RetryJob(ctx context.Context, id string) (*models.ProvisioningJob, error) {
+	job, err := s.repo.GetJob(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if job.Status == repository.ProvisioningJobRunning {
+		return job, nil
+	}
+
+	job.Status = repository.ProvisioningJobPending
+	job.Attempts = 0
+	job.NextAttemptAt = time.Now()
+	job.UpdatedAt = time.Now()
+	if err := s.repo.UpdateJob(ctx, job); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// ListJobs retrieves the queued jobs of a connector, optionally with one status
+func (s *ProvisioningService) ListJobs(ctx context.Context, connectorID, status string, page, limit int) ([]*models.ProvisioningJob, int64, error) {
+	if _, err := s.repo.GetConnector(ctx, connectorID); err != nil {
+		return nil, 0, err
+	}
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+	return s.repo.GetJobs(ctx, connectorID, status, (page-1)*limit, limit)
+}
+
+// ==================== Status ====================
+
+// Status summarizes how the users of an application are provisioned
+func (s *ProvisioningService) Status(ctx context.Context, connectorID string) (*ProvisioningStatus, error) {
+	connector, err := s.repo.GetConnector(ctx, connectorID)
+	if err != nil {
+		return nil, err
+	}
+
+	users, err := s.repo.CountStates(ctx, connector.ID)
+	if err != nil {
+		return nil, err
+	}
+	jobs, err := s.repo.CountJobs(ctx, connector.ID)
+	if err != nil {
+		return nil, err
+	}
+	lastError, err := s.repo.GetLastError(ctx, connector.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ProvisioningStatus{
+		Connector: connector,
+		Users:     users,
+		Jobs:      jobs,
+		LastError: lastError,
+	}, nil
+}
+
+// UserStatus retrieves the state of a user in every application
+func (s *ProvisioningService) UserStatus(ctx context.Context, userID string) ([]*models.ProvisioningState, error) {
+	return s.repo.GetStatesByUser(ctx, userID)
+}
+
+// ==================== Sync ====================
+
+// syncUser brings the application's copy of a user in line with the user:
+// users in scope are created or replaced, and users that left the scope or
+// were deleted are deactivated or deleted, as the connector says
+func (s *ProvisioningService) syncUser(ctx context.Context, connector *models.ProvisioningConnector, userID string) error {
+	state, err := s.repo.GetState(ctx, connector.ID, userID)
+	if errors.Is(err, repository.ErrProvisioningStateNotFound) {
+		state = &models.ProvisioningState{ConnectorID: connector.ID, UserID: userID}
+	} else if err != nil {
+		return err
+	}
+
+	user, err := s.userRepo.GetByID(ctx, userID)
+	if errors.Is(err, repository.ErrNotFound) {
+		user = nil
+	} else if err != nil {
+		return err
+	}
+
+	remote := &scimRemote{client: s.client, connector: connector}
+	if user != nil && provisioningInScope(connector, user) {
+		// Inactive users are updated, but not created
+		if state.RemoteID == "" && !user.IsActive {
+			return nil
+		}
+		err = s.upsert(ctx, remote, connector, user, state)
+	} else {
+		if state.RemoteID == "" || state.Status == ProvisioningStateDeleted ||
+			(state.Status == ProvisioningStateDeactivated && connector.Deprovision == provisioningDeactivate) {
+			return nil
+		}
+		err = s.deprovision(ctx, remote, connector, state)
+	}
+
+	now := time.Now()
+	state.UpdatedAt = now
+	if err != nil {
+		state.Status = ProvisioningStateError
+		state.LastError = err.Error()
+	} else {
+		state.LastError = ""
+		state.LastSyncedAt = &now
+	}
+	if saveErr := s.repo.SaveState(ctx, state); saveErr != nil {
+		return saveErr
+	}
+	return err
+}
+
+func (s *ProvisioningService) upsert(ctx context.Context, remote *scimRemote, connector *models.ProvisioningConnector, user *models.User, state *models.ProvisioningState) error {
+	doc := provisioningDocument(connector, user)
+
+	if state.RemoteID != "" {
+		err := remote.replaceUser(ctx, state.RemoteID, doc)
+		if remoteStatus(err) == http.StatusNotFound {
+			// Removed in the application; create it again
+			state.RemoteID = ""
+		} else if err != nil {
+			return err
+		}
+	}
+
+	if state.RemoteID == "" {
+		remoteID, err := remote.createUser(ctx, doc)
+		if remoteStatus(err) == http.StatusConflict {
+			// The application already has the user, e.g. created by hand: adopt it
+			userName, _ := doc["userName"].(string)
+			if existing, findErr := remote.findUser(ctx, userName); findErr == nil && existing != "" {
+				remoteID = existing
+				err = remote.replaceUser(ctx, remoteID, doc)
+			}
+		}
+		if err != nil {
+			return err
+		}
+		state.RemoteID = remoteID
+	}
+
+	state.Status = ProvisioningStateProvisioned
+	if !user.IsActive {
+		state.Status = ProvisioningStateDeactivated
+	}
+	return nil
+}
+
+func (s *ProvisioningService) deprovision(ctx context.Context, remote *scimRemote, connector *models.ProvisioningConnector, state *models.ProvisioningState) error {
+	if connector.Deprovision == provisioningDelete {
+		if err := remote.deleteUser(ctx, state.RemoteID); err != nil {
+			return err
+		}
+		state.Status = ProvisioningStateDeleted
+		return nil
+	}
+
+	err := remote.deactivateUser(ctx, state.RemoteID)
+	if remoteStatus(err) == http.StatusNotFound {
+		state.Status = ProvisioningStateDeleted
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	state.Status = ProvisioningStateDeactivated
+	return nil
+}
+
+// provisioningInScope tells whether a user belongs in the connector's application
+func provisioningInScope(connector *models.ProvisioningConnector, user *models.User) bool {
+	if len(connector.RoleIDs) == 0 {
+		return true
+	}
+	for _, role := range user.Roles {
+		if slices.Contains(connector.RoleIDs, role.ID) {
+			return true
+		}
+	}
+	return false
+}
+
+// provisioningDocument builds the SCIM user sent to an application from the
+// connector's attribute mapping. Attributes may be sub-attributes (name.givenName)
+// or carry an extension schema URN prefix.
+func provisioningDocument(connector *models.ProvisioningConnector, user *models.User) map[string]interface{} {
+	mapping := make(map[string]string, len(defaultProvisioningMapping)+len(connector.AttributeMapping))
+	for attr, field := range defaultProvisioningMapping {
+		mapping[attr] = field
+	}
+	for attr, field := range connector.AttributeMapping {
+		mapping[attr] = field
+	}
+
+	doc := map[string]interface{}{}
+	schemas := []string{SCIMSchemaUser}
+
+	attrs := make([]string, 0, len(mapping))
+	for attr := range mapping {
+		attrs = append(attrs, attr)
+	}
+	sort.Strings(attrs)
+
+	for _, attr := range attrs {
+		field := mapping[attr]
+		if field == "" {
+			continue
+		}
+
+		target, path := doc, attr
+		if strings.HasPrefix(strings.ToLower(attr), "urn:") {
+			i := strings.LastIndex(attr, ":")
+			schema := attr[:i]
+			path = attr[i+1:]
+			if !strings.EqualFold(schema, SCIMSchemaUser) {
+				if !slices.Contains(schemas, schema) {
+					schemas = append(schemas, schema)
+				}
+				extension, _ := doc[schema].(map[string]interface{})
+				if extension == nil {
+					extension = map[string]interface{}{}
+					doc[schema] = extension
+				}
+				target = extension
+			}
+		}
+
+		value := provisioningFieldValue(user, field)
+		if strings.EqualFold(path, "emails") {
+			value = []map[string]interface{}{{"value": value, "type": "work", "primary": true}}
+		}
+
+		if i := strings.Index(path, "."); i > 0 {
+			parent, _ := target[path[:i]].(map[string]interface{})
+			if parent == nil {
+				parent = map[string]interface{}{}
+				target[path[:i]] = parent
+			}
+			parent[path[i+1:]] = value
+		} else {
+			target[path] = value
+		}
+	}
+
+	doc["schemas"] = schemas
+	return doc
+}
+
+func provisioningFieldValue(user *models.User, field string) interface{} {
+	switch field {
+	case "id":
+		return user.ID
+	case "email":
+		return user.Email
+	case "name":
+		return user.Name
+	case "given_name", "family_name":
+		given, family := user.Name, ""
+		if i := strings.LastIndex(user.Name, " "); i > 0 {
+			given, family = user.Name[:i], user.Name[i+1:]
+		}
+		if field == "given_name" {
+			return given
+		}
+		return family
+	case "is_active":
+		return user.IsActive
+	case "email_verified":
+		return user.EmailVerified
+	case "roles":
+		roles := make([]map[string]interface{}, len(user.Roles))
+		for i, role := range user.Roles {
+			roles[i] = map[string]interface{}{"value": role.Name}
+		}
+		return roles
+	}
+	return nil
+}
@@ -0,0 +1,404 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sso-project/sso-server/internal/database"
+	"github.com/sso-project/sso-server/internal/models"
+	"github.com/sso-project/sso-server/internal/repository"
+	"github.com/sso-project/sso-server/internal/testutil"
+)
+
+// testSCIMApp is a downstream application with an in-memory SCIM Users API
+type testSCIMApp struct {
+	*httptest.Server
+	token string
+
+	mu       sync.Mutex
+	users    map[string]map[string]interface{}
+	failWith []int // Statuses answered, in order, before requests are served again
+	requests []string
+}
+
+func newTestSCIMApp(t *testing.T) *testSCIMApp {
+	t.Helper()
+	app := &testSCIMApp{token: "downstream-token", users: map[string]map[string]interface{}{}}
+	app.Server = httptest.NewServer(http.HandlerFunc(app.serve))
+	t.Cleanup(app.Close)
+	return app
+}
+
+func (a *testSCIMApp) serve(w http.ResponseWriter, r *http.Request) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.requests = append(a.requests, r.Method+" "+r.URL.Path)
+	if r.Header.Get("Authorization") != "Bearer "+a.token {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if len(a.failWith) > 0 {
+		status := a.failWith[0]
+		a.failWith = a.failWith[1:]
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]string{"detail": "try again"})
+		return
+	}
+
+	id := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/scim/v2/Users"), "/")
+	var body map[string]interface{}
+	json.NewDecoder(r.Body).Decode(&body)
+
+	switch {
+	case r.Method == http.MethodGet && id == "":
+		filter, err := parseSCIMFilter(r.URL.Query().Get("filter"))
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		resources := []map[string]interface{}{}
+		for _, user := range a.users {
+			if filter.matches(user) {
+				resources = append(resources, user)
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"Resources": resources})
+	case r.Method == http.MethodPost && id == "":
+		for _, user := range a.users {
+			if strings.EqualFold(user["userName"].(string), body["userName"].(string)) {
+				w.WriteHeader(http.StatusConflict)
+				return
+			}
+		}
+		body["id"] = uuid.New().String()
+		a.users[body["id"].(string)] = body
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(body)
+	case a.users[id] == nil:
+		w.WriteHeader(http.StatusNotFound)
+	case r.Method == http.MethodPut:
+		body["id"] = id
+		a.users[id] = body
+		json.NewEncoder(w).Encode(body)
+	case r.Method == http.MethodPatch:
+		raw, _ := json.Marshal(body)
+		var patch SCIMPatchRequest
+		json.Unmarshal(raw, &patch)
+		var patched map[string]interface{}
+		if err := applySCIMPatch(a.users[id], &patch, &patched); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		a.users[id] = patched
+		json.NewEncoder(w).Encode(patched)
+	case r.Method == http.MethodDelete:
+		delete(a.users, id)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (a *testSCIMApp) user(id string) map[string]interface{} {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.users[id]
+}
+
+func newTestProvisioningService(t *testing.T, db *database.DB) *ProvisioningService {
+	t.Helper()
+	return NewProvisioningService(
+		repository.NewProvisioningRepository(db.DB),
+		repository.NewOAuth2ClientRepository(db.DB),
+		repository.NewUserRepository(db),
+		repository.NewRoleRepository(db),
+		ProvisioningOptions{MaxAttempts: 3},
+	)
+}
+
+// makeJobsDue moves every waiting job's next attempt to the past
+func makeJobsDue(t *testing.T, db *database.DB) {
+	t.Helper()
+	require.NoError(t, db.DB.Model(&models.ProvisioningJob{}).
+		Where("status = ?", repository.ProvisioningJobPending).
+		Update("next_attempt_at", time.Now().Add(-time.Second)).Error)
+}
+
+func TestProvisioningService_Lifecycle(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+	defer testutil.TeardownTestDB(t, db)
+
+	app := newTestSCIMApp(t)
+	provisioningService := newTestProvisioningService(t, db)
+	provisioningRepo := repository.NewProvisioningRepository(db.DB)
+	userRepo := repository.NewUserRepository(db)
+	ctx := context.Background()
+
+	user := testutil.CreateTestUser(t, db, "jane@example.com")
+	client := testutil.CreateTestOAuth2ClientWithScopes(t, db, "crm", []string{})
+
+	// A new connector provisions the existing users
+	connector, err := provisioningService.CreateConnector(ctx, ProvisioningConnectorRequest{
+		Name:        "CRM",
+		ClientID:    client.ClientID,
+		EndpointURL: app.URL + "/scim/v2",
+		Secret:      app.token,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "bearer", connector.AuthType)
+	assert.Equal(t, "deactivate", connector.Deprovision)
+
+	processed, err := provisioningService.ProcessDue(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, processed)
+
+	state, err := provisioningRepo.GetState(ctx, connector.ID, user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, ProvisioningStateProvisioned, state.Status)
+	remote := app.user(state.RemoteID)
+	require.NotNil(t, remote)
+	assert.Equal(t, "jane@example.com", remote["userName"])
+	assert.Equal(t, user.ID, remote["externalId"])
+	assert.Equal(t, true, remote["active"])
+
+	// Changes made through the admin API are pushed
+	user.Name = "Jane Smith"
+	require.NoError(t, userRepo.Update(ctx, user))
+	provisioningService.UserChanged(ctx, user.ID, "user_updated")
+	provisioningService.UserChanged(ctx, user.ID, "user_updated") // Coalesced with the waiting job
+	processed, err = provisioningService.ProcessDue(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, processed)
+	assert.Equal(t, "Jane Smith", app.user(state.RemoteID)["displayName"])
+	assert.Equal(t, "Smith", app.user(state.RemoteID)["name"].(map[string]interface{})["familyName"])
+
+	require.NoError(t, userRepo.UpdateUserStatus(ctx, user.ID, false))
+	provisioningService.UserChanged(ctx, user.ID, "user_deactivated")
+	_, err = provisioningService.ProcessDue(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, false, app.user(state.RemoteID)["active"])
+	state, err = provisioningRepo.GetState(ctx, connector.ID, user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, ProvisioningStateDeactivated, state.Status)
+
+	// Users outside the role scope are deleted when the connector says so
+	role := testutil.CreateTestRole(t, db, "sales")
+	_, err = provisioningService.UpdateConnector(ctx, connector.ID, ProvisioningConnectorRequest{
+		Name:        "CRM",
+		EndpointURL: app.URL + "/scim/v2",
+		RoleIDs:     []string{role.ID},
+		Deprovision: "delete",
+	})
+	require.NoError(t, err)
+	_, err = provisioningService.ProcessDue(ctx)
+	require.NoError(t, err)
+	assert.Nil(t, app.user(state.RemoteID))
+	state, err = provisioningRepo.GetState(ctx, connector.ID, user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, ProvisioningStateDeleted, state.Status)
+
+	// Joining the role provisions the user again
+	require.NoError(t, userRepo.UpdateUserStatus(ctx, user.ID, true))
+	require.NoError(t, userRepo.AssignRole(ctx, user.ID, role.ID))
+	provisioningService.UserChanged(ctx, user.ID, "role_assigned")
+	_, err = provisioningService.ProcessDue(ctx)
+	require.NoError(t, err)
+	state, err = provisioningRepo.GetState(ctx, connector.ID, user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, ProvisioningStateProvisioned, state.Status)
+	assert.NotNil(t, app.user(state.RemoteID))
+
+	status, err := provisioningService.Status(ctx, connector.ID)
+	require.NoError(t, err)
+	assert.EqualValues(t, 1, status.Users[ProvisioningStateProvisioned])
+	assert.Empty(t, status.Jobs)
+}
+
+func TestProvisioningService_Retries(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+	defer testutil.TeardownTestDB(t, db)
+
+	app := newTestSCIMApp(t)
+	provisioningService := newTestProvisioningService(t, db)
+	provisioningRepo := repository.NewProvisioningRepository(db.DB)
+	ctx := context.Background()
+
+	client := testutil.CreateTestOAuth2ClientWithScopes(t, db, "wiki", []string{})
+	connector, err := provisioningService.CreateConnector(ctx, ProvisioningConnectorRequest{
+		Name:        "Wiki",
+		ClientID:    client.ClientID,
+		EndpointURL: app.URL + "/scim/v2",
+		Secret:      app.token,
+	})
+	require.NoError(t, err)
+
+	// Unavailable: retried later with backoff
+	user := testutil.CreateTestUser(t, db, "retry@example.com")
+	app.failWith = []int{http.StatusServiceUnavailable}
+	provisioningService.UserChanged(ctx, user.ID, "user_created")
+	_, err = provisioningService.ProcessDue(ctx)
+	require.NoError(t, err)
+
+	jobs, _, err := provisioningService.ListJobs(ctx, connector.ID, "", 1, 20)
+	require.NoError(t, err)
+	require.Len(t, jobs, 1)
+	assert.Equal(t, repository.ProvisioningJobPending, jobs[0].Status)
+	assert.Equal(t, 1, jobs[0].Attempts)
+	assert.Contains(t, jobs[0].LastError, "503")
+	assert.WithinDuration(t, time.Now().Add(30*time.Second), jobs[0].NextAttemptAt, 5*time.Second)
+
+	state, err := provisioningRepo.GetState(ctx, connector.ID, user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, ProvisioningStateError, state.Status)
+
+	// Not due yet
+	processed, err := provisioningService.ProcessDue(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, processed)
+
+	makeJobsDue(t, db)
+	_, err = provisioningService.ProcessDue(ctx)
+	require.NoError(t, err)
+	state, err = provisioningRepo.GetState(ctx, connector.ID, user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, ProvisioningStateProvisioned, state.Status)
+	assert.Empty(t, state.LastError)
+
+	// Rejected requests fail at once; a retry after fixing the cause succeeds
+	other := testutil.CreateTestUser(t, db, "rejected@example.com")
+	app.failWith = []int{http.StatusBadRequest}
+	provisioningService.UserChanged(ctx, other.ID, "user_created")
+	_, err = provisioningService.ProcessDue(ctx)
+	require.NoError(t, err)
+	jobs, _, err = provisioningService.ListJobs(ctx, connector.ID, repository.ProvisioningJobFailed, 1, 20)
+	require.NoError(t, err)
+	require.Len(t, jobs, 1)
+	assert.Equal(t, 1, jobs[0].Attempts)
+
+	_, err = provisioningService.RetryJob(ctx, jobs[0].ID)
+	require.NoError(t, err)
+	_, err = provisioningService.ProcessDue(ctx)
+	require.NoError(t, err)
+	_, err = provisioningRepo.GetJob(ctx, jobs[0].ID)
+	assert.ErrorIs(t, err, repository.ErrProvisioningJobNotFound)
+
+	// Retryable failures give up after MaxAttempts
+	third := testutil.CreateTestUser(t, db, "down@example.com")
+	app.failWith = []int{http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway}
+	provisioningService.UserChanged(ctx, third.ID, "user_created")
+	for i := 0; i < 3; i++ {
+		makeJobsDue(t, db)
+		_, err = provisioningService.ProcessDue(ctx)
+		require.NoError(t, err)
+	}
+	jobs, _, err = provisioningService.ListJobs(ctx, connector.ID, repository.ProvisioningJobFailed, 1, 20)
+	require.NoError(t, err)
+	require.Len(t, jobs, 1)
+	assert.Equal(t, 3, jobs[0].Attempts)
+
+	assert.Equal(t, 30*time.Second, provisioningService.backoff(1))
+	assert.Equal(t, 2*time.Minute, provisioningService.backoff(3))
+	assert.Equal(t, time.Hour, provisioningService.backoff(20))
+}
+
+func TestProvisioningService_AdoptsExistingUser(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+	defer testutil.TeardownTestDB(t, db)
+
+	app := newTestSCIMApp(t)
+	app.users["remote-1"] = map[string]interface{}{"id": "remote-1", "userName": "Jane@Example.com", "active": false}
+	provisioningService := newTestProvisioningService(t, db)
+	ctx := context.Background()
+
+	user := testutil.CreateTestUser(t, db, "jane@example.com")
+	client := testutil.CreateTestOAuth2ClientWithScopes(t, db, "tickets", []string{})
+	connector, err := provisioningService.CreateConnector(ctx, ProvisioningConnectorRequest{
+		Name:        "Tickets",
+		ClientID:    client.ClientID,
+		EndpointURL: app.URL + "/scim/v2",
+		Secret:      app.token,
+	})
+	require.NoError(t, err)
+
+	_, err = provisioningService.ProcessDue(ctx)
+	require.NoError(t, err)
+
+	states, err := provisioningService.UserStatus(ctx, user.ID)
+	require.NoError(t, err)
+	require.Len(t, states, 1)
+	assert.Equal(t, connector.ID, states[0].ConnectorID)
+	assert.Equal(t, "remote-1", states[0].RemoteID)
+	assert.Equal(t, true, app.user("remote-1")["active"])
+}
+
+func TestProvisioningService_ConnectorValidation(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+	defer testutil.TeardownTestDB(t, db)
+
+	provisioningService := newTestProvisioningService(t, db)
+	ctx := context.Background()
+	client := testutil.CreateTestOAuth2ClientWithScopes(t, db, "hr", []string{})
+
+	valid := ProvisioningConnectorRequest{Name: "HR", ClientID: client.ClientID, EndpointURL: "https://hr.example.com/scim/v2", Secret: "s"}
+	tests := []struct {
+		name   string
+		modify func(req *ProvisioningConnectorRequest)
+		err    error
+	}{
+		{"missing endpoint", func(req *ProvisioningConnectorRequest) { req.EndpointURL = "" }, ErrProvisioningConnectorRequired},
+		{"plain http", func(req *ProvisioningConnectorRequest) { req.EndpointURL = "http://hr.example.com/scim/v2" }, ErrInsecureProvisioningURL},
+		{"unknown client", func(req *ProvisioningConnectorRequest) { req.ClientID = "nope" }, repository.ErrClientNotFound},
+		{"basic without username", func(req *ProvisioningConnectorRequest) { req.AuthType = "basic" }, ErrInvalidProvisioningAuth},
+		{"no secret", func(req *ProvisioningConnectorRequest) { req.Secret = "" }, ErrInvalidProvisioningAuth},
+		{"unknown field", func(req *ProvisioningConnectorRequest) { req.AttributeMapping = map[string]string{"title": "job"} }, ErrInvalidProvisioningMapping},
+		{"unknown role", func(req *ProvisioningConnectorRequest) { req.RoleIDs = []string{"missing"} }, repository.ErrNotFound},
+		{"bad deprovision", func(req *ProvisioningConnectorRequest) { req.Deprovision = "archive" }, ErrInvalidDeprovision},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := valid
+			tt.modify(&req)
+			_, err := provisioningService.CreateConnector(ctx, req)
+			assert.ErrorIs(t, err, tt.err)
+		})
+	}
+
+	_, err := provisioningService.CreateConnector(ctx, valid)
+	require.NoError(t, err)
+	_, err = provisioningService.CreateConnector(ctx, valid)
+	assert.ErrorIs(t, err, ErrProvisioningConnectorExists)
+}
+
+func TestProvisioningDocument(t *testing.T) {
+	const enterprise = "urn:ietf:params:scim:schemas:extension:enterprise:2.0:User"
+	user := &models.User{
+		ID:       "u1",
+		Email:    "jane@example.com",
+		Name:     "Jane van Doe",
+		IsActive: true,
+		Roles:    []models.Role{{ID: "r1", Name: "sales"}},
+	}
+	connector := &models.ProvisioningConnector{AttributeMapping: models.StringMap{
+		"externalId":                   "",
+		"roles":                        "roles",
+		enterprise + ":employeeNumber": "id",
+	}}
+
+	doc := provisioningDocument(connector, user)
+	assert.Equal(t, []string{SCIMSchemaUser, enterprise}, doc["schemas"])
+	assert.Equal(t, "jane@example.com", doc["userName"])
+	assert.NotContains(t, doc, "externalId")
+	assert.Equal(t, map[string]interface{}{"formatted": "Jane van Doe", "givenName": "Jane van", "familyName": "Doe"}, doc["name"])
+	assert.Equal(t, []map[string]interface{}{{"value": "jane@example.com", "type": "work", "primary": true}}, doc["emails"])
+	assert.Equal(t, []map[string]interface{}{{"value": "sales"}}, doc["roles"])
+	assert.Equal(t, map[string]interface{}{"employeeNumber": "u1"}, doc[enterprise])
+}
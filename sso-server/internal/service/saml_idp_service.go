@@ -0,0 +1,992 @@
+package service
+
+import (
+	"bytes"
+	"compress/flate"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/beevik/etree"
+	"github.com/crewjam/saml"
+	"github.com/google/uuid"
+	xrv "github.com/mattermost/xml-roundtrip-validator"
+	dsig "github.com/russellhaering/goxmldsig"
+	"github.com/sso-project/sso-server/internal/models"
+	"github.com/sso-project/sso-server/internal/repository"
+)
+
+// Name ID formats a service provider can be registered with
+const (
+	SAMLNameIDEmail       = "email"
+	SAMLNameIDPersistent  = "persistent"
+	SAMLNameIDTransient   = "transient"
+	SAMLNameIDUnspecified = "unspecified"
+)
+
+// samlLoginWindow is how long an AuthnRequest stays usable while the user signs in
+const samlLoginWindow = 10 * time.Minute
+
+var (
+	ErrSAMLServiceProviderExists   = errors.New("service provider is already registered")
+	ErrSAMLMetadataRequired        = errors.New("metadata, or entity_id and acs_url, are required")
+	ErrInvalidSAMLMetadata         = errors.New("invalid service provider metadata")
+	ErrSAMLPostACSRequired         = errors.New("service provider needs an HTTP-POST assertion consumer service")
+	ErrInvalidSAMLEndpoint         = errors.New("invalid service provider endpoint url")
+	ErrInsecureSAMLEndpoint        = errors.New("service provider endpoints must use https")
+	ErrInvalidSAMLCertificate      = errors.New("invalid service provider certificate")
+	ErrInvalidNameIDFormat         = errors.New("name_id_format must be email, persistent, transient or unspecified")
+	ErrInvalidAttributeMapping     = errors.New("attribute mappings must map to email, name, id, email_verified or roles")
+	ErrSAMLServiceProviderInactive = errors.New("service provider is disabled")
+	ErrInvalidSAMLRequest          = errors.New("invalid SAML request")
+	ErrSAMLSignature               = errors.New("SAML request signature is missing or invalid")
+	ErrSAMLLogoutUnsupported       = errors.New("service provider has no single logout service")
+)
+
+var samlNameIDFormats = map[string]saml.NameIDFormat{
+	SAMLNameIDEmail:       saml.EmailAddressNameIDFormat,
+	SAMLNameIDPersistent:  saml.PersistentNameIDFormat,
+	SAMLNameIDTransient:   saml.TransientNameIDFormat,
+	SAMLNameIDUnspecified: saml.UnspecifiedNameIDFormat,
+}
+
+// userAttributeFields are the user fields SAML and CAS attributes can be mapped to
+var userAttributeFields = []string{"email", "name", "id", "email_verified", "roles"}
+
+// defaultSAMLAttributes are sent when a service provider has no mappings of its own
+var defaultSAMLAttributes = map[string]string{
+	"email": "email",
+	"name":  "name",
+	"roles": "roles",
+}
+
+// SAMLServiceProviderRequest holds the admin-editable settings of a SAML service provider.
+// Either the SP's metadata XML is given, or its entity ID and endpoints from which
+// metadata is generated.
+type SAMLServiceProviderRequest struct {
+	Name              string            `json:"name"`
+	Metadata          string            `json:"metadata"`
+	EntityID          string            `json:"entity_id"`
+	ACSURL            string            `json:"acs_url"`
+	SLOURL            string            `json:"slo_url"`
+	Certificate       string            `json:"certificate"`
+	NameIDFormat      string            `json:"name_id_format"`
+	AttributeMappings map[string]string `json:"attribute_mappings"`
+	IsActive          *bool             `json:"is_active"`
+}
+
+// SAMLMessage is a SAML message on its way to a service provider through the browser:
+// a URL to redirect to, or a form to auto-submit
+type SAMLMessage struct {
+	Binding         string // saml.HTTPRedirectBinding or saml.HTTPPostBinding
+	URL             string
+	Field           string // SAMLRequest or SAMLResponse, for the POST binding
+	Value           string
+	RelayState      string
+	ServiceProvider *models.SAMLServiceProvider
+}
+
+// SAMLAuthnRequest is a validated sign-in request from a service provider
+type SAMLAuthnRequest struct {
+	ServiceProvider *models.SAMLServiceProvider
+	req             *saml.IdpAuthnRequest
+}
+
+// SAMLLogoutRequest is a verified logout request from a service provider
+type SAMLLogoutRequest struct {
+	ServiceProvider *models.SAMLServiceProvider
+	Request         *saml.LogoutRequest
+	RelayState      string
+	metadata        *saml.EntityDescriptor
+}
+
+// SessionIndex returns the session the service provider is logging out of, if it named one
+func (r *SAMLLogoutRequest) SessionIndex() string {
+	if r.Request.SessionIndex == nil {
+		return ""
+	}
+	return strings.TrimSpace(r.Request.SessionIndex.Value)
+}
+
+// SAMLIdPService makes this server a SAML 2.0 identity provider for registered
+// service providers, issuing signed assertions for the user's SSO session
+type SAMLIdPService struct {
+	spRepo   *repository.SAMLServiceProviderRepository
+	userRepo *repository.UserRepository
+	key      *rsa.PrivateKey
+	idp      *saml.IdentityProvider
+}
+
+// NewSAMLIdPService creates a new SAMLIdPService that signs with key and publishes cert
+func NewSAMLIdPService(
+	spRepo *repository.SAMLServiceProviderRepository,
+	userRepo *repository.UserRepository,
+	publicBaseURL string,
+	key *rsa.PrivateKey,
+	cert *x509.Certificate,
+) *SAMLIdPService {
+	base := strings.TrimRight(publicBaseURL, "/")
+	return &SAMLIdPService{
+		spRepo:   spRepo,
+		userRepo: userRepo,
+		key:      key,
+		idp: &saml.IdentityProvider{
+			Key:                     key,
+			Certificate:             cert,
+			MetadataURL:             samlURL(base + "/saml/metadata"),
+			SSOURL:                  samlURL(base + "/saml/sso"),
+			LogoutURL:               samlURL(base + "/saml/slo"),
+			ServiceProviderProvider: &samlMetadataProvider{spRepo: spRepo},
+			SignatureMethod:         dsig.RSASHA256SignatureMethod,
+		},
+	}
+}
+
+func samlURL(raw string) url.URL {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return url.URL{}
+	}
+	return *u
+}
+
+// LoadSAMLKeyPair reads the identity provider's signing key and certificate from PEM
+// files. Without files, a self-signed pair is generated that only lasts until restart,
+// so service providers must re-import the metadata after every restart.
+func LoadSAMLKeyPair(certFile, keyFile string) (*rsa.PrivateKey, *x509.Certificate, error) {
+	if certFile == "" && keyFile == "" {
+		return generateSAMLKeyPair()
+	}
+
+	pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	key, ok := pair.PrivateKey.(*rsa.PrivateKey)
+	if !ok {
+		return nil, nil, errors.New("SAML signing key must be an RSA key")
+	}
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return nil, nil, err
+	}
+	return key, cert, nil
+}
+
+func generateSAMLKeyPair() (*rsa.PrivateKey, *x509.Certificate, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(now.UnixNano()),
+		Subject:      pkix.Name{CommonName: "SSO Server SAML IdP"},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.AddDate(10, 0, 0),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, err
+	}
+	return key, cert, nil
+}
+
+// EntityID returns the identity provider's entity ID, which is its metadata URL
+func (s *SAMLIdPService) EntityID() string {
+	return s.idp.MetadataURL.String()
+}
+
+// Metadata returns the identity provider's EntityDescriptor for service providers to import
+func (s *SAMLIdPService) Metadata() ([]byte, error) {
+	metadata := s.idp.Metadata()
+	descriptor := &metadata.IDPSSODescriptors[0]
+	descriptor.NameIDFormats = []saml.NameIDFormat{
+		saml.EmailAddressNameIDFormat,
+		saml.PersistentNameIDFormat,
+		saml.TransientNameIDFormat,
+		saml.UnspecifiedNameIDFormat,
+	}
+	descriptor.SingleLogoutServices = append(descriptor.SingleLogoutServices, saml.Endpoint{
+		Binding:  saml.HTTPPostBinding,
+		Location: s.idp.LogoutURL.String(),
+	})
+
+	return xml.MarshalIndent(metadata, "", "  ")
+}
+
+// CreateServiceProvider registers a new SAML service provider
+func (s *SAMLIdPService) CreateServiceProvider(ctx context.Context, req SAMLServiceProviderRequest) (*models.SAMLServiceProvider, error) {
+	sp := &models.SAMLServiceProvider{
+		ID:       uuid.New().String(),
+		IsActive: true,
+	}
+	if err := s.apply(sp, req); err != nil {
+		return nil, err
+	}
+
+	if _, err := s.spRepo.GetByEntityID(ctx, sp.EntityID); err == nil {
+		return nil, ErrSAMLServiceProviderExists
+	} else if !errors.Is(err, repository.ErrSAMLServiceProviderNotFound) {
+		return nil, err
+	}
+
+	if err := s.spRepo.Create(ctx, sp); err != nil {
+		return nil, err
+	}
+
+	return sp, nil
+}
+
+// UpdateServiceProvider replaces the settings of a service provider
+func (s *SAMLIdPService) UpdateServiceProvider(ctx context.Context, id string, req SAMLServiceProviderRequest) (*models.SAMLServiceProvider, error) {
+	sp, err := s.spRepo.GetByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	previousEntityID := sp.EntityID
+	if err := s.apply(sp, req); err != nil {
+		return nil, err
+	}
+
+	if sp.EntityID != previousEntityID {
+		if _, err := s.spRepo.GetByEntityID(ctx, sp.EntityID); err == nil {
+			return nil, ErrSAMLServiceProviderExists
+		} else if !errors.Is(err, repository.ErrSAMLServiceProviderNotFound) {
+			return nil, err
+		}
+	}
+
+	if err := s.spRepo.Update(ctx, sp); err != nil {
+		return nil, err
+	}
+
+	return sp, nil
+}
+
+// GetServiceProvider retrieves a service provider by ID
+func (s *SAMLIdPService) GetServiceProvider(ctx context.Context, id string) (*models.SAMLServiceProvider, error) {
+	return s.spRepo.GetByID(ctx, id)
+}
+
+// ListServiceProviders retrieves all service providers
+func (s *SAMLIdPService) ListServiceProviders(ctx context.Context) ([]*models.SAMLServiceProvider, error) {
+	return s.spRepo.GetAll(ctx)
+}
+
+// DeleteServiceProvider removes a service provider
+func (s *SAMLIdPService) DeleteServiceProvider(ctx context.Context, id string) error {
+	return s.spRepo.Delete(ctx, id)
+}
+
+// apply validates req and copies it onto sp
+func (s *SAMLIdPService) apply(sp *models.SAMLServiceProvider, req SAMLServiceProviderRequest) error {
+	metadata := strings.TrimSpace(req.Metadata)
+	if metadata == "" {
+		if req.EntityID == "" || req.ACSURL == "" {
+			return ErrSAMLMetadataRequired
+		}
+		built, err := buildSPMetadata(req)
+		if err != nil {
+			return err
+		}
+		metadata = built
+	}
+
+	descriptor, err := parseSPMetadata(metadata)
+	if err != nil {
+		return err
+	}
+	if _, acs := postACS(descriptor); acs == nil {
+		return ErrSAMLPostACSRequired
+	}
+
+	// Assertions are bearer credentials, so they are only posted over https
+	for _, sso := range descriptor.SPSSODescriptors {
+		for _, acs := range sso.AssertionConsumerServices {
+			if err := validateFetchURL(acs.Location, ErrInvalidSAMLEndpoint, ErrInsecureSAMLEndpoint); err != nil {
+				return err
+			}
+		}
+		for _, slo := range sso.SingleLogoutServices {
+			if err := validateFetchURL(slo.Location, ErrInvalidSAMLEndpoint, ErrInsecureSAMLEndpoint); err != nil {
+				return err
+			}
+		}
+	}
+
+	format := req.NameIDFormat
+	if format == "" {
+		format = SAMLNameIDEmail
+	}
+	if _, ok := samlNameIDFormats[format]; !ok {
+		return ErrInvalidNameIDFormat
+	}
+
+	mappings := req.AttributeMappings
+	if len(mappings) == 0 {
+		mappings = defaultSAMLAttributes
+	}
+	attributes := models.StringMap{}
+	for name, field := range mappings {
+		if strings.TrimSpace(name) == "" || !slices.Contains(userAttributeFields, field) {
+			return ErrInvalidAttributeMapping
+		}
+		attributes[name] = field
+	}
+
+	sp.Name = req.Name
+	if sp.Name == "" {
+		sp.Name = descriptor.EntityID
+	}
+	sp.EntityID = descriptor.EntityID
+	sp.Metadata = metadata
+	sp.NameIDFormat = format
+	sp.AttributeMappings = attributes
+	if req.IsActive != nil {
+		sp.IsActive = *req.IsActive
+	}
+
+	return nil
+}
+
+// ParseAuthnRequest reads and validates an AuthnRequest sent with the HTTP-Redirect
+// or HTTP-POST binding
+func (s *SAMLIdPService) ParseAuthnRequest(ctx context.Context, r *http.Request) (*SAMLAuthnRequest, error) {
+	req, err := saml.NewIdpAuthnRequest(s.idp, r)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSAMLRequest, err)
+	}
+
+	var authn saml.AuthnRequest
+	if err := xml.Unmarshal(req.RequestBuffer, &authn); err != nil || authn.Issuer == nil {
+		return nil, ErrInvalidSAMLRequest
+	}
+
+	// Users may take a while to sign in, so requests are held to the login window
+	// rather than the library's 90 seconds: validate as of the issue instant, then
+	// stamp the response with the current time
+	now := time.Now()
+	if authn.IssueInstant.Before(now.Add(-samlLoginWindow)) || authn.IssueInstant.After(now.Add(saml.MaxClockSkew)) {
+		return nil, fmt.Errorf("%w: request has expired", ErrInvalidSAMLRequest)
+	}
+	req.Now = authn.IssueInstant
+	if err := req.Validate(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSAMLRequest, err)
+	}
+	req.Now = now
+
+	if req.ACSEndpoint.Binding != saml.HTTPPostBinding {
+		return nil, ErrSAMLPostACSRequired
+	}
+
+	sp, err := s.spRepo.GetByEntityID(ctx, authn.Issuer.Value)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SAMLAuthnRequest{ServiceProvider: sp, req: req}, nil
+}
+
+// Respond issues a signed response with an assertion about the session's user
+func (s *SAMLIdPService) Respond(ctx context.Context, authn *SAMLAuthnRequest, session *models.Session) (*SAMLMessage, error) {
+	return s.issue(ctx, authn.req, authn.ServiceProvider, session)
+}
+
+// InitiateLogin issues an unsolicited response (IdP-initiated SSO) to a service
+// provider's default HTTP-POST assertion consumer service
+func (s *SAMLIdPService) InitiateLogin(ctx context.Context, r *http.Request, spID, relayState string, session *models.Session) (*SAMLMessage, error) {
+	sp, err := s.spRepo.GetByID(ctx, spID)
+	if err != nil {
+		return nil, err
+	}
+	if !sp.IsActive {
+		return nil, ErrSAMLServiceProviderInactive
+	}
+
+	descriptor, err := parseSPMetadata(sp.Metadata)
+	if err != nil {
+		return nil, err
+	}
+	sso, acs := postACS(descriptor)
+	if acs == nil {
+		return nil, ErrSAMLPostACSRequired
+	}
+
+	req := &saml.IdpAuthnRequest{
+		IDP:                     s.idp,
+		HTTPRequest:             r,
+		RelayState:              relayState,
+		ServiceProviderMetadata: descriptor,
+		SPSSODescriptor:         sso,
+		ACSEndpoint:             acs,
+		Now:                     time.Now(),
+	}
+	return s.issue(ctx, req, sp, session)
+}
+
+func (s *SAMLIdPService) issue(ctx context.Context, req *saml.IdpAuthnRequest, sp *models.SAMLServiceProvider, session *models.Session) (*SAMLMessage, error) {
+	user, err := s.userRepo.GetByID(ctx, session.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if !user.IsActive {
+		return nil, ErrAccountInactive
+	}
+	if user.IsLocked && user.LockedUntil != nil && time.Now().Before(*user.LockedUntil) {
+		return nil, ErrAccountLocked
+	}
+
+	nameID := user.Email
+	switch sp.NameIDFormat {
+	case SAMLNameIDPersistent:
+		nameID = user.ID
+	case SAMLNameIDTransient:
+		nameID = "_" + randomSAMLID()
+	}
+
+	// The session ID is the SessionIndex, so a logout request names the session to end
+	assertionSession := &saml.Session{
+		ID:               session.ID,
+		CreateTime:       session.CreatedAt,
+		ExpireTime:       session.ExpiresAt,
+		Index:            session.ID,
+		NameID:           nameID,
+		NameIDFormat:     string(samlNameIDFormats[sp.NameIDFormat]),
+		UserName:         user.Email,
+		UserEmail:        user.Email,
+		UserCommonName:   user.Name,
+		CustomAttributes: samlAttributes(sp, user),
+	}
+	if err := (saml.DefaultAssertionMaker{}).MakeAssertion(req, assertionSession); err != nil {
+		return nil, err
+	}
+
+	form, err := req.PostBinding()
+	if err != nil {
+		return nil, err
+	}
+
+	return &SAMLMessage{
+		Binding:         saml.HTTPPostBinding,
+		URL:             form.URL,
+		Field:           "SAMLResponse",
+		Value:           form.SAMLResponse,
+		RelayState:      form.RelayState,
+		ServiceProvider: sp,
+	}, nil
+}
+
+// samlAttributes builds the attribute statement from the service provider's mappings
+func samlAttributes(sp *models.SAMLServiceProvider, user *models.User) []saml.Attribute {
+	names := make([]string, 0, len(sp.AttributeMappings))
+	for name := range sp.AttributeMappings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	attributes := make([]saml.Attribute, 0, len(names))
+	for _, name := range names {
+		values := userAttributeValues(user, sp.AttributeMappings[name])
+
+		attribute := saml.Attribute{
+			Name:       name,
+			NameFormat: "urn:oasis:names:tc:SAML:2.0:attrname-format:basic",
+		}
+		for _, value := range values {
+			attribute.Values = append(attribute.Values, saml.AttributeValue{Type: "xs:string", Value: value})
+		}
+		if len(attribute.Values) > 0 {
+			attributes = append(attributes, attribute)
+		}
+	}
+
+	return attributes
+}
+
+// userAttributeValues returns the values of a user field attributes can be mapped to
+func userAttributeValues(user *models.User, field string) []string {
+	switch field {
+	case "email":
+		return []string{user.Email}
+	case "name":
+		return []string{user.Name}
+	case "id":
+		return []string{user.ID}
+	case "email_verified":
+		return []string{strconv.FormatBool(user.EmailVerified)}
+	case "roles":
+		roles, _ := RoleClaims(user.Roles)
+		return roles
+	}
+	return nil
+}
+
+// ParseLogoutRequest reads a LogoutRequest sent with the HTTP-Redirect binding and
+// verifies its signature when the service provider has a signing certificate
+func (s *SAMLIdPService) ParseLogoutRequest(ctx context.Context, r *http.Request) (*SAMLLogoutRequest, error) {
+	raw, err := inflateSAMLMessage(r.URL.Query().Get("SAMLRequest"))
+	if err != nil {
+		return nil, err
+	}
+	if err := xrv.Validate(bytes.NewReader(raw)); err != nil {
+		return nil, ErrInvalidSAMLRequest
+	}
+
+	doc := etree.NewDocument()
+	if err := doc.ReadFromBytes(raw); err != nil || doc.Root() == nil {
+		return nil, ErrInvalidSAMLRequest
+	}
+	var logout saml.LogoutRequest
+	if err := xml.Unmarshal(raw, &logout); err != nil || logout.Issuer == nil {
+		return nil, ErrInvalidSAMLRequest
+	}
+
+	if logout.Destination != "" && logout.Destination != s.idp.LogoutURL.String() {
+		return nil, fmt.Errorf("%w: unexpected destination", ErrInvalidSAMLRequest)
+	}
+	now := time.Now()
+	if logout.IssueInstant.Add(saml.MaxIssueDelay).Before(now) || logout.IssueInstant.After(now.Add(saml.MaxClockSkew)) {
+		return nil, fmt.Errorf("%w: request has expired", ErrInvalidSAMLRequest)
+	}
+
+	sp, err := s.spRepo.GetByEntityID(ctx, logout.Issuer.Value)
+	if err != nil {
+		return nil, err
+	}
+	if !sp.IsActive {
+		return nil, ErrSAMLServiceProviderInactive
+	}
+	descriptor, err := parseSPMetadata(sp.Metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	// The redirect binding signs the query string; requests rebound from the POST
+	// binding (and some SPs on redirect) carry an enveloped XML signature instead
+	if certs := spSigningCerts(descriptor); len(certs) > 0 {
+		if r.URL.Query().Get("Signature") != "" {
+			err = verifyQuerySignature(r.URL.RawQuery, "SAMLRequest", certs)
+		} else {
+			err = verifyEnvelopedSignature(doc.Root(), certs)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &SAMLLogoutRequest{
+		ServiceProvider: sp,
+		Request:         &logout,
+		RelayState:      r.URL.Query().Get("RelayState"),
+		metadata:        descriptor,
+	}, nil
+}
+
+// LogoutResponse builds the signed LogoutResponse to a logout request, sent to the
+// service provider's single logout service
+func (s *SAMLIdPService) LogoutResponse(logout *SAMLLogoutRequest) (*SAMLMessage, error) {
+	endpoint := sloEndpoint(logout.metadata)
+	if endpoint == nil {
+		return nil, ErrSAMLLogoutUnsupported
+	}
+	destination := endpoint.Location
+	if endpoint.ResponseLocation != "" {
+		destination = endpoint.ResponseLocation
+	}
+
+	response := &saml.LogoutResponse{
+		ID:           "id-" + randomSAMLID(),
+		InResponseTo: logout.Request.ID,
+		Version:      "2.0",
+		IssueInstant: time.Now(),
+		Destination:  destination,
+		Issuer: &saml.Issuer{
+			Format: "urn:oasis:names:tc:SAML:2.0:nameid-format:entity",
+			Value:  s.EntityID(),
+		},
+		Status: saml.Status{
+			StatusCode: saml.StatusCode{Value: saml.StatusSuccess},
+		},
+	}
+
+	message := &SAMLMessage{
+		Binding:         endpoint.Binding,
+		URL:             destination,
+		Field:           "SAMLResponse",
+		RelayState:      logout.RelayState,
+		ServiceProvider: logout.ServiceProvider,
+	}
+
+	if endpoint.Binding == saml.HTTPRedirectBinding {
+		raw, err := samlXML(response.Element())
+		if err != nil {
+			return nil, err
+		}
+		if message.URL, err = s.signedRedirect(destination, "SAMLResponse", raw, logout.RelayState); err != nil {
+			return nil, err
+		}
+		return message, nil
+	}
+
+	signed, err := s.signEnveloped(response.Element())
+	if err != nil {
+		return nil, err
+	}
+	raw, err := samlXML(signed)
+	if err != nil {
+		return nil, err
+	}
+	message.Value = base64.StdEncoding.EncodeToString(raw)
+	return message, nil
+}
+
+// RebindPost turns a message received with the HTTP-POST binding into an HTTP-Redirect
+// URL for path on this server. Browsers only send the Lax session cookie on top-level
+// GET navigations, so cross-site POSTs from service providers are bounced through a
+// redirect before the session is looked at.
+func RebindPost(path, field, encoded, relayState string) (string, error) {
+	raw, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(raw) == 0 {
+		return "", ErrInvalidSAMLRequest
+	}
+	deflated, err := deflateSAMLMessage(raw)
+	if err != nil {
+		return "", err
+	}
+
+	query := url.Values{field: {deflated}}
+	if relayState != "" {
+		query.Set("RelayState", relayState)
+	}
+	return path + "?" + query.Encode(), nil
+}
+
+// signedRedirect builds an HTTP-Redirect binding URL signed over the query string
+func (s *SAMLIdPService) signedRedirect(destination, field string, raw []byte, relayState string) (string, error) {
+	deflated, err := deflateSAMLMessage(raw)
+	if err != nil {
+		return "", err
+	}
+
+	query := field + "=" + url.QueryEscape(deflated)
+	if relayState != "" {
+		query += "&RelayState=" + url.QueryEscape(relayState)
+	}
+	query += "&SigAlg=" + url.QueryEscape(dsig.RSASHA256SignatureMethod)
+
+	digest := sha256.Sum256([]byte(query))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, s.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+	query += "&Signature=" + url.QueryEscape(base64.StdEncoding.EncodeToString(signature))
+
+	separator := "?"
+	if strings.Contains(destination, "?") {
+		separator = "&"
+	}
+	return destination + separator + query, nil
+}
+
+// signEnveloped adds an enveloped XML signature by the identity provider to el
+func (s *SAMLIdPService) signEnveloped(el *etree.Element) (*etree.Element, error) {
+	signingContext := dsig.NewDefaultSigningContext(dsig.TLSCertKeyStore(tls.Certificate{
+		Certificate: [][]byte{s.idp.Certificate.Raw},
+		PrivateKey:  s.key,
+		Leaf:        s.idp.Certificate,
+	}))
+	signingContext.Canonicalizer = dsig.MakeC14N10ExclusiveCanonicalizerWithPrefixList("")
+	if err := signingContext.SetSignatureMethod(dsig.RSASHA256SignatureMethod); err != nil {
+		return nil, err
+	}
+	return signingContext.SignEnveloped(el)
+}
+
+// verifyQuerySignature checks an HTTP-Redirect binding signature, which covers the
+// query parameters exactly as they were URL-encoded by the sender
+func verifyQuerySignature(rawQuery, field string, certs []*x509.Certificate) error {
+	params := map[string]string{}
+	for _, part := range strings.Split(rawQuery, "&") {
+		key, value, _ := strings.Cut(part, "=")
+		params[key] = value
+	}
+
+	signed := field + "=" + params[field]
+	if relayState, ok := params["RelayState"]; ok {
+		signed += "&RelayState=" + relayState
+	}
+	signed += "&SigAlg=" + params["SigAlg"]
+
+	sigAlg, err := url.QueryUnescape(params["SigAlg"])
+	if err != nil {
+		return ErrSAMLSignature
+	}
+	encoded, err := url.QueryUnescape(params["Signature"])
+	if err != nil {
+		return ErrSAMLSignature
+	}
+	signature, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return ErrSAMLSignature
+	}
+
+	var h hash.Hash
+	var hashID crypto.Hash
+	switch sigAlg {
+	case dsig.RSASHA1SignatureMethod:
+		h, hashID = sha1.New(), crypto.SHA1
+	case dsig.RSASHA256SignatureMethod:
+		h, hashID = sha256.New(), crypto.SHA256
+	case dsig.RSASHA512SignatureMethod:
+		h, hashID = sha512.New(), crypto.SHA512
+	default:
+		return ErrSAMLSignature
+	}
+	h.Write([]byte(signed))
+	digest := h.Sum(nil)
+
+	for _, cert := range certs {
+		if key, ok := cert.PublicKey.(*rsa.PublicKey); ok && rsa.VerifyPKCS1v15(key, hashID, digest, signature) == nil {
+			return nil
+		}
+	}
+	return ErrSAMLSignature
+}
+
+// verifyEnvelopedSignature checks an XML signature over el made with one of certs
+func verifyEnvelopedSignature(el *etree.Element, certs []*x509.Certificate) error {
+	validationContext := dsig.NewDefaultValidationContext(&dsig.MemoryX509CertificateStore{Roots: certs})
+	validationContext.IdAttribute = "ID"
+	if _, err := validationContext.Validate(el); err != nil {
+		return ErrSAMLSignature
+	}
+	return nil
+}
+
+// samlMetadataProvider looks up service provider metadata for the SAML library
+type samlMetadataProvider struct {
+	spRepo *repository.SAMLServiceProviderRepository
+}
+
+// GetServiceProvider returns the metadata of an active service provider; the library
+// expects os.ErrNotExist for unknown ones
+func (p *samlMetadataProvider) GetServiceProvider(r *http.Request, entityID string) (*saml.EntityDescriptor, error) {
+	sp, err := p.spRepo.GetByEntityID(r.Context(), entityID)
+	if errors.Is(err, repository.ErrSAMLServiceProviderNotFound) || (err == nil && !sp.IsActive) {
+		return nil, os.ErrNotExist
+	}
+	if err != nil {
+		return nil, err
+	}
+	return parseSPMetadata(sp.Metadata)
+}
+
+// parseSPMetadata parses a service provider's EntityDescriptor
+func parseSPMetadata(raw string) (*saml.EntityDescriptor, error) {
+	if err := xrv.Validate(strings.NewReader(raw)); err != nil {
+		return nil, ErrInvalidSAMLMetadata
+	}
+
+	var descriptor saml.EntityDescriptor
+	if err := xml.Unmarshal([]byte(raw), &descriptor); err != nil {
+		return nil, ErrInvalidSAMLMetadata
+	}
+	if descriptor.EntityID == "" || len(descriptor.SPSSODescriptors) == 0 {
+		return nil, ErrInvalidSAMLMetadata
+	}
+	return &descriptor, nil
+}
+
+// buildSPMetadata generates an EntityDescriptor for a service provider registered by
+// its entity ID and endpoints rather than its metadata
+func buildSPMetadata(req SAMLServiceProviderRequest) (string, error) {
+	sso := saml.SPSSODescriptor{
+		SSODescriptor: saml.SSODescriptor{
+			RoleDescriptor: saml.RoleDescriptor{
+				ProtocolSupportEnumeration: "urn:oasis:names:tc:SAML:2.0:protocol",
+			},
+		},
+		AssertionConsumerServices: []saml.IndexedEndpoint{{
+			Binding:  saml.HTTPPostBinding,
+			Location: req.ACSURL,
+			Index:    1,
+		}},
+	}
+	if req.SLOURL != "" {
+		sso.SingleLogoutServices = []saml.Endpoint{{
+			Binding:  saml.HTTPRedirectBinding,
+			Location: req.SLOURL,
+		}}
+	}
+	if req.Certificate != "" {
+		cert, err := parseCertificate(req.Certificate)
+		if err != nil {
+			return "", err
+		}
+		sso.KeyDescriptors = []saml.KeyDescriptor{{
+			Use: "signing",
+			KeyInfo: saml.KeyInfo{
+				X509Data: saml.X509Data{
+					X509Certificates: []saml.X509Certificate{{Data: base64.StdEncoding.EncodeToString(cert.Raw)}},
+				},
+			},
+		}}
+	}
+
+	buf, err := xml.MarshalIndent(&saml.EntityDescriptor{
+		EntityID:         req.EntityID,
+		SPSSODescriptors: []saml.SPSSODescriptor{sso},
+	}, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(buf), nil
+}
+
+// parseCertificate accepts a PEM certificate or the bare base64 DER found in metadata
+func parseCertificate(raw string) (*x509.Certificate, error) {
+	der := []byte(nil)
+	if block, _ := pem.Decode([]byte(raw)); block != nil {
+		der = block.Bytes
+	} else {
+		decoded, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(raw), ""))
+		if err != nil {
+			return nil, ErrInvalidSAMLCertificate
+		}
+		der = decoded
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, ErrInvalidSAMLCertificate
+	}
+	return cert, nil
+}
+
+// spSigningCerts returns the certificates a service provider signs its messages with
+func spSigningCerts(descriptor *saml.EntityDescriptor) []*x509.Certificate {
+	var certs []*x509.Certificate
+	for _, sso := range descriptor.SPSSODescriptors {
+		for _, key := range sso.KeyDescriptors {
+			if key.Use != "" && key.Use != "signing" {
+				continue
+			}
+			for _, data := range key.KeyInfo.X509Data.X509Certificates {
+				if cert, err := parseCertificate(data.Data); err == nil {
+					certs = append(certs, cert)
+				}
+			}
+		}
+	}
+	return certs
+}
+
+// postACS returns the service provider's default HTTP-POST assertion consumer service
+func postACS(descriptor *saml.EntityDescriptor) (*saml.SPSSODescriptor, *saml.IndexedEndpoint) {
+	var sso *saml.SPSSODescriptor
+	var acs *saml.IndexedEndpoint
+	for i := range descriptor.SPSSODescriptors {
+		for j, endpoint := range descriptor.SPSSODescriptors[i].AssertionConsumerServices {
+			if endpoint.Binding != saml.HTTPPostBinding {
+				continue
+			}
+			if acs == nil || (endpoint.IsDefault != nil && *endpoint.IsDefault) {
+				sso = &descriptor.SPSSODescriptors[i]
+				acs = &descriptor.SPSSODescriptors[i].AssertionConsumerServices[j]
+			}
+		}
+	}
+	return sso, acs
+}
+
+// sloEndpoint returns the service provider's single logout service, preferring the
+// redirect binding
+func sloEndpoint(descriptor *saml.EntityDescriptor) *saml.Endpoint {
+	var post *saml.Endpoint
+	for i := range descriptor.SPSSODescriptors {
+		for j, endpoint := range descriptor.SPSSODescriptors[i].SingleLogoutServices {
+			switch endpoint.Binding {
+			case saml.HTTPRedirectBinding:
+				return &descriptor.SPSSODescriptors[i].SingleLogoutServices[j]
+			case saml.HTTPPostBinding:
+				if post == nil {
+					post = &descriptor.SPSSODescriptors[i].SingleLogoutServices[j]
+				}
+			}
+		}
+	}
+	return post
+}
+
+func samlXML(el *etree.Element) ([]byte, error) {
+	doc := etree.NewDocument()
+	doc.SetRoot(el)
+	return doc.WriteToBytes()
+}
+
+func deflateSAMLMessage(raw []byte) (string, error) {
+	var buf bytes.Buffer
+	writer, err := flate.NewWriter(&buf, flate.BestCompression)
+	if err != nil {
+		return "", err
+	}
+	if _, err := writer.Write(raw); err != nil {
+		return "", err
+	}
+	if err := writer.Close(); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+// inflateSAMLMessage decodes an HTTP-Redirect binding message, refusing to inflate
+// more than a megabyte
+func inflateSAMLMessage(encoded string) ([]byte, error) {
+	compressed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(compressed) == 0 {
+		return nil, ErrInvalidSAMLRequest
+	}
+	raw, err := io.ReadAll(io.LimitReader(flate.NewReader(bytes.NewReader(compressed)), 1<<20))
+	if err != nil {
+		return nil, ErrInvalidSAMLRequest
+	}
+	return raw, nil
+}
+
+func randomSAMLID() string {
+	b := make([]byte, 20)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,227 @@
+package service
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/xml"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/beevik/etree"
+	"github.com/crewjam/saml"
+	dsig "github.com/russellhaering/goxmldsig"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sso-project/sso-server/internal/repository"
+	"github.com/sso-project/sso-server/internal/testutil"
+)
+
+// testSAMLServiceProvider is an application that trusts the IdP's metadata
+func testSAMLServiceProvider(t *testing.T, idpMetadata []byte, entityID string) *saml.ServiceProvider {
+	key, cert, err := generateSAMLKeyPair()
+	require.NoError(t, err)
+
+	var descriptor saml.EntityDescriptor
+	require.NoError(t, xml.Unmarshal(idpMetadata, &descriptor))
+
+	parse := func(raw string) url.URL {
+		u, err := url.Parse(raw)
+		require.NoError(t, err)
+		return *u
+	}
+	return &saml.ServiceProvider{
+		EntityID:          entityID,
+		Key:               key,
+		Certificate:       cert,
+		MetadataURL:       parse(entityID),
+		AcsURL:            parse("https://app.example.com/saml/acs"),
+		SloURL:            parse("https://app.example.com/saml/slo"),
+		IDPMetadata:       &descriptor,
+		AuthnNameIDFormat: saml.PersistentNameIDFormat,
+		SignatureMethod:   dsig.RSASHA256SignatureMethod,
+		LogoutBindings:    []string{saml.HTTPRedirectBinding},
+		AllowIDPInitiated: true,
+	}
+}
+
+func attributeValues(assertion *saml.Assertion, name string) []string {
+	var values []string
+	for _, statement := range assertion.AttributeStatements {
+		for _, attribute := range statement.Attributes {
+			if attribute.Name == name {
+				for _, value := range attribute.Values {
+					values = append(values, value.Value)
+				}
+			}
+		}
+	}
+	return values
+}
+
+func TestSAMLIdPService_SSOAndLogout(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+	defer testutil.TeardownTestDB(t, db)
+
+	userRepo := repository.NewUserRepository(db)
+	sessionService := NewSessionService(repository.NewDatabaseSessionStore(db), time.Hour)
+	key, cert, err := generateSAMLKeyPair()
+	require.NoError(t, err)
+	samlService := NewSAMLIdPService(repository.NewSAMLServiceProviderRepository(db.DB), userRepo, "https://sso.example.com", key, cert)
+	ctx := context.Background()
+
+	metadata, err := samlService.Metadata()
+	require.NoError(t, err)
+	assert.Contains(t, string(metadata), `entityID="https://sso.example.com/saml/metadata"`)
+
+	app := testSAMLServiceProvider(t, metadata, "https://app.example.com/saml/metadata")
+	appMetadata, err := xml.MarshalIndent(app.Metadata(), "", "  ")
+	require.NoError(t, err)
+
+	// Service providers registered by endpoint must receive assertions over https
+	_, err = samlService.CreateServiceProvider(ctx, SAMLServiceProviderRequest{
+		EntityID: "https://app.example.com/saml/metadata",
+		ACSURL:   "http://app.example.com/saml/acs",
+	})
+	assert.ErrorIs(t, err, ErrInsecureSAMLEndpoint)
+
+	_, err = samlService.CreateServiceProvider(ctx, SAMLServiceProviderRequest{Name: "App"})
+	assert.ErrorIs(t, err, ErrSAMLMetadataRequired)
+
+	sp, err := samlService.CreateServiceProvider(ctx, SAMLServiceProviderRequest{
+		Name:              "App",
+		Metadata:          string(appMetadata),
+		NameIDFormat:      SAMLNameIDPersistent,
+		AttributeMappings: map[string]string{"mail": "email", "groups": "roles"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "https://app.example.com/saml/metadata", sp.EntityID)
+
+	_, err = samlService.CreateServiceProvider(ctx, SAMLServiceProviderRequest{
+		EntityID: "https://app.example.com/saml/metadata",
+		ACSURL:   "https://app.example.com/saml/acs",
+	})
+	assert.ErrorIs(t, err, ErrSAMLServiceProviderExists)
+
+	user := testutil.CreateTestUser(t, db, "alice@example.com")
+	role := testutil.CreateTestRole(t, db, "engineer")
+	require.NoError(t, userRepo.AssignRole(ctx, user.ID, role.ID))
+	session, err := sessionService.CreateSession(ctx, user.ID, "127.0.0.1", "test")
+	require.NoError(t, err)
+
+	// verify checks a response the way the service provider would
+	verify := func(message *SAMLMessage, requestIDs []string) *saml.Assertion {
+		require.Equal(t, saml.HTTPPostBinding, message.Binding)
+		assert.Equal(t, "https://app.example.com/saml/acs", message.URL)
+		raw, err := base64.StdEncoding.DecodeString(message.Value)
+		require.NoError(t, err)
+		assertion, err := app.ParseXMLResponse(raw, requestIDs)
+		require.NoError(t, err)
+		return assertion
+	}
+
+	// SP-initiated sign-in with the HTTP-Redirect binding
+	authn, err := app.MakeAuthenticationRequest("https://sso.example.com/saml/sso", saml.HTTPRedirectBinding, saml.HTTPPostBinding)
+	require.NoError(t, err)
+	redirect, err := authn.Redirect("relay-1", app)
+	require.NoError(t, err)
+
+	request, err := samlService.ParseAuthnRequest(ctx, httptest.NewRequest("GET", redirect.String(), nil))
+	require.NoError(t, err)
+	assert.Equal(t, sp.ID, request.ServiceProvider.ID)
+
+	message, err := samlService.Respond(ctx, request, session)
+	require.NoError(t, err)
+	assert.Equal(t, "relay-1", message.RelayState)
+
+	assertion := verify(message, []string{authn.ID})
+	assert.Equal(t, user.ID, assertion.Subject.NameID.Value)
+	assert.Equal(t, string(saml.PersistentNameIDFormat), assertion.Subject.NameID.Format)
+	assert.Equal(t, session.ID, assertion.AuthnStatements[0].SessionIndex)
+	assert.Equal(t, []string{"alice@example.com"}, attributeValues(assertion, "mail"))
+	assert.Equal(t, []string{"engineer"}, attributeValues(assertion, "groups"))
+
+	// SP-initiated sign-in with the HTTP-POST binding, rebound to a redirect
+	authn, err = app.MakeAuthenticationRequest("https://sso.example.com/saml/sso", saml.HTTPPostBinding, saml.HTTPPostBinding)
+	require.NoError(t, err)
+	doc := etree.NewDocument()
+	doc.SetRoot(authn.Element())
+	raw, err := doc.WriteToBytes()
+	require.NoError(t, err)
+
+	rebound, err := RebindPost("/saml/sso", "SAMLRequest", base64.StdEncoding.EncodeToString(raw), "relay-2")
+	require.NoError(t, err)
+	request, err = samlService.ParseAuthnRequest(ctx, httptest.NewRequest("GET", "https://sso.example.com"+rebound, nil))
+	require.NoError(t, err)
+	message, err = samlService.Respond(ctx, request, session)
+	require.NoError(t, err)
+	assert.Equal(t, "relay-2", message.RelayState)
+	verify(message, []string{authn.ID})
+
+	post := httptest.NewRequest("POST", "https://sso.example.com/saml/sso", strings.NewReader(url.Values{
+		"SAMLRequest": {base64.StdEncoding.EncodeToString(raw)},
+	}.Encode()))
+	post.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	_, err = samlService.ParseAuthnRequest(ctx, post)
+	require.NoError(t, err)
+
+	// IdP-initiated sign-in posts an unsolicited response
+	message, err = samlService.InitiateLogin(ctx, httptest.NewRequest("GET", "https://sso.example.com/saml/init/"+sp.ID, nil), sp.ID, "/dashboard", session)
+	require.NoError(t, err)
+	assertion = verify(message, nil)
+	assert.Equal(t, user.ID, assertion.Subject.NameID.Value)
+
+	// Requests from unregistered or disabled service providers are refused
+	stranger := testSAMLServiceProvider(t, metadata, "https://stranger.example.com/saml/metadata")
+	redirect, err = stranger.MakeRedirectAuthenticationRequest("")
+	require.NoError(t, err)
+	_, err = samlService.ParseAuthnRequest(ctx, httptest.NewRequest("GET", redirect.String(), nil))
+	assert.ErrorIs(t, err, ErrInvalidSAMLRequest)
+
+	// Single Logout: a signed request gets a signed response at the SP's SLO service
+	logoutURL, err := app.MakeRedirectLogoutRequest(user.ID, "relay-3")
+	require.NoError(t, err)
+	logout, err := samlService.ParseLogoutRequest(ctx, httptest.NewRequest("GET", logoutURL.String(), nil))
+	require.NoError(t, err)
+	assert.Equal(t, sp.ID, logout.ServiceProvider.ID)
+
+	response, err := samlService.LogoutResponse(logout)
+	require.NoError(t, err)
+	require.Equal(t, saml.HTTPRedirectBinding, response.Binding)
+	responseURL, err := url.Parse(response.URL)
+	require.NoError(t, err)
+	assert.Equal(t, "https://app.example.com/saml/slo", responseURL.Scheme+"://"+responseURL.Host+responseURL.Path)
+	assert.Equal(t, "relay-3", responseURL.Query().Get("RelayState"))
+	assert.NoError(t, verifyQuerySignature(responseURL.RawQuery, "SAMLResponse", []*x509.Certificate{cert}))
+
+	logoutXML, err := inflateSAMLMessage(responseURL.Query().Get("SAMLResponse"))
+	require.NoError(t, err)
+	var logoutResponse saml.LogoutResponse
+	require.NoError(t, xml.Unmarshal(logoutXML, &logoutResponse))
+	assert.Equal(t, logout.Request.ID, logoutResponse.InResponseTo)
+	assert.Equal(t, saml.StatusSuccess, logoutResponse.Status.StatusCode.Value)
+
+	// A logout request signed with a key the SP did not register is rejected
+	impostor := testSAMLServiceProvider(t, metadata, "https://app.example.com/saml/metadata")
+	logoutURL, err = impostor.MakeRedirectLogoutRequest(user.ID, "")
+	require.NoError(t, err)
+	_, err = samlService.ParseLogoutRequest(ctx, httptest.NewRequest("GET", logoutURL.String(), nil))
+	assert.ErrorIs(t, err, ErrSAMLSignature)
+
+	inactive := false
+	_, err = samlService.UpdateServiceProvider(ctx, sp.ID, SAMLServiceProviderRequest{
+		Metadata: string(appMetadata),
+		IsActive: &inactive,
+	})
+	require.NoError(t, err)
+	redirect, err = app.MakeRedirectAuthenticationRequest("")
+	require.NoError(t, err)
+	_, err = samlService.ParseAuthnRequest(ctx, httptest.NewRequest("GET", redirect.String(), nil))
+	assert.ErrorIs(t, err, ErrInvalidSAMLRequest)
+	_, err = samlService.InitiateLogin(ctx, httptest.NewRequest("GET", "https://sso.example.com/saml/init/"+sp.ID, nil), sp.ID, "", session)
+	assert.ErrorIs(t, err, ErrSAMLServiceProviderInactive)
+}
@@ -0,0 +1,641 @@
+package service
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/crewjam/saml"
+	"github.com/google/uuid"
+	xrv "github.com/mattermost/xml-roundtrip-validator"
+	dsig "github.com/russellhaering/goxmldsig"
+	"github.com/sso-project/sso-server/internal/models"
+	"github.com/sso-project/sso-server/internal/repository"
+)
+
+// samlIssueDelay is how long after issue a response is accepted, before clock skew
+const samlIssueDelay = 90 * time.Second
+
+var (
+	ErrSAMLConnectionExists   = errors.New("connection slug is already in use")
+	ErrSAMLConnectionRequired = errors.New("name, slug and idp_metadata or idp_metadata_url are required")
+	ErrInvalidIdPMetadata     = errors.New("invalid identity provider metadata")
+	ErrInvalidIdPMetadataURL  = errors.New("invalid idp_metadata_url")
+	ErrInsecureIdPMetadataURL = errors.New("idp_metadata_url must use https")
+	ErrIdPMetadataFetch       = errors.New("identity provider metadata could not be fetched")
+	ErrSAMLConnectionInactive = errors.New("connection is disabled")
+	ErrSAMLResponse           = errors.New("invalid SAML response")
+	ErrSAMLTransientNameID    = errors.New("identity provider sent a transient NameID, which cannot identify an account")
+	ErrIdPSigningCertRequired = errors.New("identity provider metadata has no signing certificate")
+)
+
+// SAMLConnectionRequest holds the admin-editable settings of an upstream SAML connection
+type SAMLConnectionRequest struct {
+	Name            string            `json:"name"`
+	Slug            string            `json:"slug"`
+	IdPMetadata     string            `json:"idp_metadata"`     // EntityDescriptor XML
+	IdPMetadataURL  string            `json:"idp_metadata_url"` // Fetched when idp_metadata is empty
+	EmailAttribute  string            `json:"email_attribute"`
+	NameAttribute   string            `json:"name_attribute"`
+	GroupsAttribute string            `json:"groups_attribute"`
+	RoleMappings    map[string]string `json:"role_mappings"`
+	TrustEmail      *bool             `json:"trust_email"`
+	JITProvisioning *bool             `json:"jit_provisioning"`
+	IsActive        *bool             `json:"is_active"`
+}
+
+// SAMLLoginState is what the browser carries between the AuthnRequest and the
+// response posted back to the assertion consumer service
+type SAMLLoginState struct {
+	RequestID string `json:"request_id"`
+	ReturnTo  string `json:"return_to"`
+	Link      string `json:"link,omitempty"` // Link request from IdentityService.BeginLink
+}
+
+// SAMLSPService signs users in through upstream SAML 2.0 identity providers, acting
+// as a service provider toward them
+type SAMLSPService struct {
+	connRepo      *repository.SAMLConnectionRepository
+	accounts      *upstreamAccounts
+	publicBaseURL string
+	key           *rsa.PrivateKey
+	cert          *x509.Certificate
+	clockSkew     time.Duration
+	client        *http.Client
+}
+
+// NewSAMLSPService creates a new SAMLSPService. AuthnRequests are signed with key,
+// and cert is published so identity providers can encrypt assertions. Responses
+// are accepted from identity providers whose clocks differ by up to clockSkew,
+// which crewjam/saml's own checks cap at saml.MaxClockSkew.
+func NewSAMLSPService(
+	connRepo *repository.SAMLConnectionRepository,
+	identityRepo *repository.UserIdentityRepository,
+	userRepo *repository.UserRepository,
+	roleRepo *repository.RoleRepository,
+	sessionService *SessionService,
+	auditRepo *repository.AuditLogRepository,
+	publicBaseURL string,
+	key *rsa.PrivateKey,
+	cert *x509.Certificate,
+	clockSkew time.Duration,
+) *SAMLSPService {
+	// The library's allowances are package variables shared with the IdP, so
+	// they are left alone and the skew is applied by checkAssertionTimes
+	if clockSkew > saml.MaxClockSkew {
+		log.Printf("SAML clock skew %s exceeds the %s the SAML library allows; using %s", clockSkew, saml.MaxClockSkew, saml.MaxClockSkew)
+		clockSkew = saml.MaxClockSkew
+	}
+
+	return &SAMLSPService{
+		connRepo: connRepo,
+		accounts: &upstreamAccounts{
+			identityRepo:   identityRepo,
+			userRepo:       userRepo,
+			roleRepo:       roleRepo,
+			sessionService: sessionService,
+			auditRepo:      auditRepo,
+		},
+		publicBaseURL: strings.TrimRight(publicBaseURL, "/"),
+		key:           key,
+		cert:          cert,
+		clockSkew:     clockSkew,
+		client:        &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// EnableLinking lets users link accounts at these identity providers to their
+// account, and applies the identity service's policy on linking by email
+func (s *SAMLSPService) EnableLinking(identities *IdentityService) {
+	s.accounts.identities = identities
+}
+
+// EntityID is this server's entity ID toward the identity provider behind slug,
+// which is also where its metadata is published
+func (s *SAMLSPService) EntityID(slug string) string {
+	return s.publicBaseURL + "/saml/sp/" + slug + "/metadata"
+}
+
+// ACSURL is the assertion consumer service the identity provider posts responses to
+func (s *SAMLSPService) ACSURL(slug string) string {
+	return s.publicBaseURL + "/saml/sp/" + slug + "/acs"
+}
+
+// CreateConnection adds an upstream SAML identity provider
+func (s *SAMLSPService) CreateConnection(ctx context.Context, req SAMLConnectionRequest) (*models.SAMLConnection, error) {
+	if _, err := s.connRepo.GetBySlug(ctx, req.Slug); err == nil {
+		return nil, ErrSAMLConnectionExists
+	} else if !errors.Is(err, repository.ErrSAMLConnectionNotFound) {
+		return nil, err
+	}
+
+	conn := &models.SAMLConnection{
+		ID:              uuid.New().String(),
+		JITProvisioning: true,
+		IsActive:        true,
+	}
+	if err := s.apply(ctx, conn, req); err != nil {
+		return nil, err
+	}
+
+	if err := s.connRepo.Create(ctx, conn); err != nil {
+		return nil, err
+	}
+
+	return s.withEndpoints(conn), nil
+}
+
+// UpdateConnection replaces the settings of an upstream identity provider. The slug
+// keys linked identities and the entity ID the provider knows us by, so it cannot change.
+func (s *SAMLSPService) UpdateConnection(ctx context.Context, id string, req SAMLConnectionRequest) (*models.SAMLConnection, error) {
+	conn, err := s.connRepo.GetByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.Slug == "" {
+		req.Slug = conn.Slug
+	} else if req.Slug != conn.Slug {
+		return nil, ErrConnectionSlugFixed
+	}
+
+	if err := s.apply(ctx, conn, req); err != nil {
+		return nil, err
+	}
+
+	if err := s.connRepo.Update(ctx, conn); err != nil {
+		return nil, err
+	}
+
+	return s.withEndpoints(conn), nil
+}
+
+// GetConnection retrieves an upstream identity provider by ID
+func (s *SAMLSPService) GetConnection(ctx context.Context, id string) (*models.SAMLConnection, error) {
+	conn, err := s.connRepo.GetByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return s.withEndpoints(conn), nil
+}
+
+// ListConnections retrieves all upstream identity providers
+func (s *SAMLSPService) ListConnections(ctx context.Context) ([]*models.SAMLConnection, error) {
+	conns, err := s.connRepo.GetAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, conn := range conns {
+		s.withEndpoints(conn)
+	}
+	return conns, nil
+}
+
+// ListActiveConnections retrieves the providers offered on the login page
+func (s *SAMLSPService) ListActiveConnections(ctx context.Context) ([]*models.SAMLConnection, error) {
+	return s.connRepo.GetActive(ctx)
+}
+
+// DeleteConnection removes an upstream identity provider. Identities linked through
+// it are kept, so re-adding the same slug restores them.
+func (s *SAMLSPService) DeleteConnection(ctx context.Context, id string) error {
+	return s.connRepo.Delete(ctx, id)
+}
+
+// apply validates req, importing the identity provider metadata from its URL when
+// it was not given inline, and copies it onto conn
+func (s *SAMLSPService) apply(ctx context.Context, conn *models.SAMLConnection, req SAMLConnectionRequest) error {
+	metadata := strings.TrimSpace(req.IdPMetadata)
+	if req.Name == "" || req.Slug == "" || (metadata == "" && req.IdPMetadataURL == "") {
+		return ErrSAMLConnectionRequired
+	}
+	if !connectionSlugPattern.MatchString(req.Slug) {
+		return ErrInvalidConnectionSlug
+	}
+
+	if metadata == "" {
+		if err := validateFetchURL(req.IdPMetadataURL, ErrInvalidIdPMetadataURL, ErrInsecureIdPMetadataURL); err != nil {
+			return err
+		}
+		fetched, err := s.fetchMetadata(ctx, req.IdPMetadataURL)
+		if err != nil {
+			return err
+		}
+		metadata = fetched
+	}
+
+	descriptor, err := parseIdPMetadata(metadata)
+	if err != nil {
+		return err
+	}
+	if len(idpSigningCerts(descriptor)) == 0 {
+		return ErrIdPSigningCertRequired
+	}
+
+	conn.Name = req.Name
+	conn.Slug = req.Slug
+	conn.IdPEntityID = descriptor.EntityID
+	conn.IdPMetadata = metadata
+	conn.IdPMetadataURL = req.IdPMetadataURL
+	conn.EmailAttribute = req.EmailAttribute
+	if conn.EmailAttribute == "" {
+		conn.EmailAttribute = "email"
+	}
+	conn.NameAttribute = req.NameAttribute
+	if conn.NameAttribute == "" {
+		conn.NameAttribute = "name"
+	}
+	conn.GroupsAttribute = req.GroupsAttribute
+	conn.RoleMappings = req.RoleMappings
+	if conn.RoleMappings == nil {
+		conn.RoleMappings = map[string]string{}
+	}
+	if req.TrustEmail != nil {
+		conn.TrustEmail = *req.TrustEmail
+	}
+	if req.JITProvisioning != nil {
+		conn.JITProvisioning = *req.JITProvisioning
+	}
+	if req.IsActive != nil {
+		conn.IsActive = *req.IsActive
+	}
+
+	return nil
+}
+
+func (s *SAMLSPService) withEndpoints(conn *models.SAMLConnection) *models.SAMLConnection {
+	conn.EntityID = s.EntityID(conn.Slug)
+	conn.ACSURL = s.ACSURL(conn.Slug)
+	return conn
+}
+
+// fetchMetadata downloads identity provider metadata
+func (s *SAMLSPService) fetchMetadata(ctx context.Context, metadataURL string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, metadataURL, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrIdPMetadataFetch, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%w: unexpected status %d", ErrIdPMetadataFetch, resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrIdPMetadataFetch, err)
+	}
+	return string(body), nil
+}
+
+// Metadata returns this server's service provider EntityDescriptor for the identity
+// provider behind slug to import
+func (s *SAMLSPService) Metadata(ctx context.Context, slug string) ([]byte, error) {
+	conn, err := s.connRepo.GetBySlug(ctx, slug)
+	if err != nil {
+		return nil, err
+	}
+	sp, err := s.serviceProvider(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	metadata := sp.Metadata()
+	descriptor := &metadata.SPSSODescriptors[0]
+	descriptor.NameIDFormats = []saml.NameIDFormat{saml.PersistentNameIDFormat, saml.EmailAddressNameIDFormat}
+	// Responses are only accepted through the HTTP-POST binding
+	descriptor.AssertionConsumerServices = descriptor.AssertionConsumerServices[:1]
+
+	return xml.MarshalIndent(metadata, "", "  ")
+}
+
+// BeginLogin starts a sign-in with the identity provider behind slug. It returns
+// the AuthnRequest to deliver to the provider and the state the browser must bring
+// back with the response.
+func (s *SAMLSPService) BeginLogin(ctx context.Context, slug, returnTo string) (*SAMLMessage, *SAMLLoginState, error) {
+	conn, err := s.activeConnection(ctx, slug)
+	if err != nil {
+		return nil, nil, err
+	}
+	sp, err := s.serviceProvider(conn)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Identity providers are asked through the redirect binding when they offer it
+	binding := saml.HTTPRedirectBinding
+	ssoURL := sp.GetSSOBindingLocation(binding)
+	if ssoURL == "" {
+		binding = saml.HTTPPostBinding
+		ssoURL = sp.GetSSOBindingLocation(binding)
+	}
+	if ssoURL == "" {
+		return nil, nil, fmt.Errorf("%w: no HTTP-Redirect or HTTP-POST sign-in service", ErrInvalidIdPMetadata)
+	}
+
+	req, err := sp.MakeAuthenticationRequest(ssoURL, binding, saml.HTTPPostBinding)
+	if err != nil {
+		return nil, nil, err
+	}
+	pending := &SAMLLoginState{
+		RequestID: req.ID,
+		ReturnTo:  returnTo,
+	}
+
+	message := &SAMLMessage{Binding: binding, URL: ssoURL, Field: "SAMLRequest"}
+	if binding == saml.HTTPRedirectBinding {
+		redirect, err := req.Redirect("", sp)
+		if err != nil {
+			return nil, nil, err
+		}
+		message.URL = redirect.String()
+		return message, pending, nil
+	}
+
+	raw, err := samlXML(req.Element())
+	if err != nil {
+		return nil, nil, err
+	}
+	message.Value = base64.StdEncoding.EncodeToString(raw)
+	return message, pending, nil
+}
+
+// CompleteLogin consumes the response the identity provider posted for a sign-in
+// started by BeginLogin: it checks the signatures, issuer, audience, destination,
+// validity window and the request it answers, rejects replayed assertions, then
+// finds, links or provisions the local user and creates a session for them.
+func (s *SAMLSPService) CompleteLogin(
+	ctx context.Context,
+	slug string,
+	pending *SAMLLoginState,
+	samlResponse, ipAddress, userAgent string,
+) (*UpstreamLoginResult, error) {
+	result, err := s.completeLogin(ctx, slug, pending, samlResponse, ipAddress, userAgent)
+	if err != nil {
+		s.accounts.logAudit(ctx, nil, "login_failed", ipAddress, userAgent, fmt.Sprintf("saml_connection=%s error=%v", slug, err))
+		return nil, err
+	}
+
+	details := "saml_connection=" + slug
+	if result.Linked {
+		s.accounts.logAudit(ctx, &result.User.ID, "identity_linked", ipAddress, userAgent, details)
+		return result, nil
+	}
+	if result.Provisioned {
+		s.accounts.logAudit(ctx, &result.User.ID, "user_provisioned", ipAddress, userAgent, details)
+	}
+	s.accounts.logAudit(ctx, &result.User.ID, "login_success", ipAddress, userAgent, details)
+
+	return result, nil
+}
+
+func (s *SAMLSPService) completeLogin(
+	ctx context.Context,
+	slug string,
+	pending *SAMLLoginState,
+	samlResponse, ipAddress, userAgent string,
+) (*UpstreamLoginResult, error) {
+	if pending == nil || pending.RequestID == "" {
+		return nil, ErrUpstreamState
+	}
+
+	conn, err := s.activeConnection(ctx, slug)
+	if err != nil {
+		return nil, err
+	}
+	sp, err := s.serviceProvider(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(samlResponse)
+	if err != nil || len(raw) == 0 {
+		return nil, fmt.Errorf("%w: not base64", ErrSAMLResponse)
+	}
+
+	assertion, err := sp.ParseXMLResponse(raw, []string{pending.RequestID})
+	if err != nil {
+		// The detailed reason is kept out of user-facing messages
+		var invalid *saml.InvalidResponseError
+		if errors.As(err, &invalid) {
+			err = invalid.PrivateErr
+		}
+		log.Printf("SAML connection %s rejected a response: %v", slug, err)
+		return nil, fmt.Errorf("%w: %v", ErrSAMLResponse, err)
+	}
+
+	if err := s.checkAssertionTimes(assertion, saml.TimeNow()); err != nil {
+		log.Printf("SAML connection %s rejected a response: %v", slug, err)
+		return nil, fmt.Errorf("%w: %v", ErrSAMLResponse, err)
+	}
+
+	if err := s.consumeAssertion(ctx, conn, assertion); err != nil {
+		return nil, err
+	}
+
+	account, err := samlAccount(conn, assertion)
+	if err != nil {
+		return nil, err
+	}
+
+	if pending.Link != "" {
+		return s.accounts.link(ctx, account, pending.Link)
+	}
+
+	return s.accounts.signIn(ctx, account, upstreamPolicy{
+		Connection:      conn.Slug,
+		JITProvisioning: conn.JITProvisioning,
+		LinkByEmail:     s.accounts.linkByEmail(),
+		RoleMappings:    conn.RoleMappings,
+	}, ipAddress, userAgent)
+}
+
+// checkAssertionTimes checks the validity windows of an assertion the library
+// accepted against this service's clock skew
+func (s *SAMLSPService) checkAssertionTimes(assertion *saml.Assertion, now time.Time) error {
+	if assertion.IssueInstant.After(now.Add(s.clockSkew)) {
+		return errors.New("assertion is issued in the future")
+	}
+	if assertion.IssueInstant.Add(samlIssueDelay + s.clockSkew).Before(now) {
+		return errors.New("assertion is expired")
+	}
+	if assertion.Conditions != nil {
+		if !assertion.Conditions.NotBefore.IsZero() && assertion.Conditions.NotBefore.After(now.Add(s.clockSkew)) {
+			return errors.New("assertion conditions are not yet valid")
+		}
+		if !assertion.Conditions.NotOnOrAfter.IsZero() && !assertion.Conditions.NotOnOrAfter.Add(s.clockSkew).After(now) {
+			return errors.New("assertion conditions are expired")
+		}
+	}
+	if assertion.Subject != nil {
+		for _, confirmation := range assertion.Subject.SubjectConfirmations {
+			data := confirmation.SubjectConfirmationData
+			if data != nil && !data.NotOnOrAfter.IsZero() && !data.NotOnOrAfter.Add(s.clockSkew).After(now) {
+				return errors.New("assertion subject confirmation is expired")
+			}
+		}
+	}
+	return nil
+}
+
+// consumeAssertion records the assertion until it can no longer be accepted, so
+// the same response cannot sign anyone in twice
+func (s *SAMLSPService) consumeAssertion(ctx context.Context, conn *models.SAMLConnection, assertion *saml.Assertion) error {
+	expiresAt := assertion.IssueInstant.Add(samlIssueDelay)
+	if assertion.Conditions != nil && assertion.Conditions.NotOnOrAfter.After(expiresAt) {
+		expiresAt = assertion.Conditions.NotOnOrAfter
+	}
+	expiresAt = expiresAt.Add(s.clockSkew)
+
+	// Assertion IDs are only unique per issuer
+	sum := sha256.Sum256([]byte(conn.IdPEntityID + "\x00" + assertion.ID))
+	if err := s.connRepo.ConsumeAssertionID(ctx, hex.EncodeToString(sum[:]), expiresAt); err != nil {
+		if errors.Is(err, repository.ErrSAMLAssertionReplayed) {
+			return fmt.Errorf("%w: %w", ErrSAMLResponse, err)
+		}
+		return err
+	}
+	return nil
+}
+
+// samlAccount maps a validated assertion to the upstream account it describes
+func samlAccount(conn *models.SAMLConnection, assertion *saml.Assertion) (*upstreamAccount, error) {
+	if assertion.Subject == nil || assertion.Subject.NameID == nil || assertion.Subject.NameID.Value == "" {
+		return nil, fmt.Errorf("%w: assertion has no NameID", ErrSAMLResponse)
+	}
+	nameID := assertion.Subject.NameID
+	if nameID.Format == string(saml.TransientNameIDFormat) {
+		return nil, ErrSAMLTransientNameID
+	}
+
+	account := &upstreamAccount{
+		Provider:      "saml:" + conn.Slug,
+		Subject:       nameID.Value,
+		EmailVerified: conn.TrustEmail,
+	}
+	if values := samlAttributeValues(assertion, conn.EmailAttribute); len(values) > 0 {
+		account.Email = values[0]
+	} else if nameID.Format == string(saml.EmailAddressNameIDFormat) {
+		account.Email = nameID.Value
+	}
+	if values := samlAttributeValues(assertion, conn.NameAttribute); len(values) > 0 {
+		account.Name = values[0]
+	}
+	if conn.GroupsAttribute != "" {
+		account.Groups = samlAttributeValues(assertion, conn.GroupsAttribute)
+	}
+
+	return account, nil
+}
+
+// samlAttributeValues returns the values of the attribute with the given name or
+// friendly name
+func samlAttributeValues(assertion *saml.Assertion, name string) []string {
+	var values []string
+	for _, statement := range assertion.AttributeStatements {
+		for _, attribute := range statement.Attributes {
+			if attribute.Name != name && attribute.FriendlyName != name {
+				continue
+			}
+			for _, value := range attribute.Values {
+				if value.Value != "" {
+					values = append(values, value.Value)
+				}
+			}
+		}
+	}
+	return values
+}
+
+func (s *SAMLSPService) activeConnection(ctx context.Context, slug string) (*models.SAMLConnection, error) {
+	conn, err := s.connRepo.GetBySlug(ctx, slug)
+	if err != nil {
+		return nil, err
+	}
+	if !conn.IsActive {
+		return nil, ErrSAMLConnectionInactive
+	}
+	return conn, nil
+}
+
+// serviceProvider describes this server as the service provider toward conn
+func (s *SAMLSPService) serviceProvider(conn *models.SAMLConnection) (*saml.ServiceProvider, error) {
+	descriptor, err := parseIdPMetadata(conn.IdPMetadata)
+	if err != nil {
+		return nil, err
+	}
+
+	return &saml.ServiceProvider{
+		EntityID:          s.EntityID(conn.Slug),
+		Key:               s.key,
+		Certificate:       s.cert,
+		MetadataURL:       samlURL(s.EntityID(conn.Slug)),
+		AcsURL:            samlURL(s.ACSURL(conn.Slug)),
+		IDPMetadata:       descriptor,
+		AuthnNameIDFormat: saml.UnspecifiedNameIDFormat,
+		SignatureMethod:   dsig.RSASHA256SignatureMethod,
+	}, nil
+}
+
+// parseIdPMetadata parses an identity provider's EntityDescriptor. Federation
+// metadata wrapped in an EntitiesDescriptor yields its first identity provider.
+func parseIdPMetadata(raw string) (*saml.EntityDescriptor, error) {
+	if err := xrv.Validate(strings.NewReader(raw)); err != nil {
+		return nil, ErrInvalidIdPMetadata
+	}
+
+	var descriptor saml.EntityDescriptor
+	if err := xml.Unmarshal([]byte(raw), &descriptor); err != nil || len(descriptor.IDPSSODescriptors) == 0 {
+		var entities saml.EntitiesDescriptor
+		if xml.Unmarshal([]byte(raw), &entities) != nil {
+			return nil, ErrInvalidIdPMetadata
+		}
+		found := false
+		for _, entity := range entities.EntityDescriptors {
+			if len(entity.IDPSSODescriptors) > 0 {
+				descriptor, found = entity, true
+				break
+			}
+		}
+		if !found {
+			return nil, ErrInvalidIdPMetadata
+		}
+	}
+	if descriptor.EntityID == "" {
+		return nil, ErrInvalidIdPMetadata
+	}
+	return &descriptor, nil
+}
+
+// idpSigningCerts returns the certificates an identity provider signs responses with
+func idpSigningCerts(descriptor *saml.EntityDescriptor) []*x509.Certificate {
+	var certs []*x509.Certificate
+	for _, sso := range descriptor.IDPSSODescriptors {
+		for _, key := range sso.KeyDescriptors {
+			if key.Use != "" && key.Use != "signing" {
+				continue
+			}
+			for _, data := range key.KeyInfo.X509Data.X509Certificates {
+				if cert, err := parseCertificate(data.Data); err == nil {
+					certs = append(certs, cert)
+				}
+			}
+		}
+	}
+	return certs
+}
@@ -0,0 +1,200 @@
+package service
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/crewjam/saml"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sso-project/sso-server/internal/repository"
+	"github.com/sso-project/sso-server/internal/testutil"
+)
+
+func TestSAMLSPService_SignIn(t *testing.T) {
+	// The partner IdP is this server's own SAML IdP running on a separate database
+	idpDB := testutil.SetupTestDB(t)
+	defer testutil.TeardownTestDB(t, idpDB)
+	db := testutil.SetupTestDB(t)
+	defer testutil.TeardownTestDB(t, db)
+
+	idpUsers := repository.NewUserRepository(idpDB)
+	idpKey, idpCert, err := generateSAMLKeyPair()
+	require.NoError(t, err)
+	idp := NewSAMLIdPService(repository.NewSAMLServiceProviderRepository(idpDB.DB), idpUsers, "https://idp.partner.example.com", idpKey, idpCert)
+	idpSessions := NewSessionService(repository.NewDatabaseSessionStore(idpDB), time.Hour)
+	idpMetadata, err := idp.Metadata()
+	require.NoError(t, err)
+
+	userRepo := repository.NewUserRepository(db)
+	sessionService := NewSessionService(repository.NewDatabaseSessionStore(db), time.Hour)
+	key, cert, err := generateSAMLKeyPair()
+	require.NoError(t, err)
+	spService := NewSAMLSPService(
+		repository.NewSAMLConnectionRepository(db.DB),
+		repository.NewUserIdentityRepository(db.DB),
+		userRepo,
+		repository.NewRoleRepository(db),
+		sessionService,
+		repository.NewAuditLogRepository(db),
+		"https://sso.example.com",
+		key,
+		cert,
+		3*time.Minute,
+	)
+	ctx := context.Background()
+	testutil.CreateTestRole(t, db, "engineer")
+
+	_, err = spService.CreateConnection(ctx, SAMLConnectionRequest{
+		Name:           "Partner",
+		Slug:           "partner",
+		IdPMetadataURL: "http://idp.partner.example.com/saml/metadata",
+	})
+	assert.ErrorIs(t, err, ErrInsecureIdPMetadataURL)
+
+	_, err = spService.CreateConnection(ctx, SAMLConnectionRequest{
+		Name:        "Partner",
+		Slug:        "partner",
+		IdPMetadata: "<EntityDescriptor",
+	})
+	assert.ErrorIs(t, err, ErrInvalidIdPMetadata)
+
+	conn, err := spService.CreateConnection(ctx, SAMLConnectionRequest{
+		Name:            "Partner",
+		Slug:            "partner",
+		IdPMetadata:     string(idpMetadata),
+		GroupsAttribute: "groups",
+		RoleMappings:    map[string]string{"eng": "engineer"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "https://idp.partner.example.com/saml/metadata", conn.IdPEntityID)
+	assert.Equal(t, "https://sso.example.com/saml/sp/partner/acs", conn.ACSURL)
+
+	// The partner registers this server from its SP metadata
+	spMetadata, err := spService.Metadata(ctx, "partner")
+	require.NoError(t, err)
+	_, err = idp.CreateServiceProvider(ctx, SAMLServiceProviderRequest{
+		Name:              "Our SSO",
+		Metadata:          string(spMetadata),
+		NameIDFormat:      SAMLNameIDPersistent,
+		AttributeMappings: map[string]string{"email": "email", "name": "name", "groups": "roles"},
+	})
+	require.NoError(t, err)
+
+	alice := testutil.CreateTestUser(t, idpDB, "alice@partner.example.com")
+	role := testutil.CreateTestRole(t, idpDB, "eng")
+	require.NoError(t, idpUsers.AssignRole(ctx, alice.ID, role.ID))
+	idpSession, err := idpSessions.CreateSession(ctx, alice.ID, "127.0.0.1", "test")
+	require.NoError(t, err)
+
+	// login sends an AuthnRequest to the partner and returns its signed response
+	login := func() (*SAMLLoginState, string) {
+		message, pending, err := spService.BeginLogin(ctx, "partner", "/dashboard")
+		require.NoError(t, err)
+		require.Equal(t, saml.HTTPRedirectBinding, message.Binding)
+
+		authn, err := idp.ParseAuthnRequest(ctx, httptest.NewRequest("GET", message.URL, nil))
+		require.NoError(t, err)
+		response, err := idp.Respond(ctx, authn, idpSession)
+		require.NoError(t, err)
+		assert.Equal(t, conn.ACSURL, response.URL)
+		return pending, response.Value
+	}
+
+	// First sign-in provisions the user, maps groups to roles and creates a session
+	pending, response := login()
+	result, err := spService.CompleteLogin(ctx, "partner", pending, response, "127.0.0.1", "test")
+	require.NoError(t, err)
+	assert.True(t, result.Provisioned)
+	assert.Equal(t, "alice@partner.example.com", result.User.Email)
+
+	session, err := sessionService.ValidateSession(ctx, result.Session.SessionToken)
+	require.NoError(t, err)
+	assert.Equal(t, result.User.ID, session.UserID)
+
+	user, err := userRepo.GetByID(ctx, result.User.ID)
+	require.NoError(t, err)
+	require.Len(t, user.Roles, 1)
+	assert.Equal(t, "engineer", user.Roles[0].Name)
+
+	// The same response cannot be used twice
+	_, err = spService.CompleteLogin(ctx, "partner", pending, response, "127.0.0.1", "test")
+	assert.ErrorIs(t, err, ErrSAMLResponse)
+	assert.ErrorIs(t, err, repository.ErrSAMLAssertionReplayed)
+
+	// The same partner account signs in as the same user
+	pending, response = login()
+	again, err := spService.CompleteLogin(ctx, "partner", pending, response, "127.0.0.1", "test")
+	require.NoError(t, err)
+	assert.False(t, again.Provisioned)
+	assert.Equal(t, result.User.ID, again.User.ID)
+
+	// A response must answer the request this browser started
+	_, response = login()
+	other, _ := login()
+	_, err = spService.CompleteLogin(ctx, "partner", other, response, "127.0.0.1", "test")
+	assert.ErrorIs(t, err, ErrSAMLResponse)
+	_, err = spService.CompleteLogin(ctx, "partner", nil, response, "127.0.0.1", "test")
+	assert.ErrorIs(t, err, ErrUpstreamState)
+
+	// The SP's clock skew does not change the library settings the IdP relies on
+	assert.Equal(t, 180*time.Second, saml.MaxClockSkew)
+	assert.Equal(t, 90*time.Second, saml.MaxIssueDelay)
+
+	// Responses are accepted while still valid, and refused once expired
+	pending, response = login()
+	saml.TimeNow = func() time.Time { return time.Now().Add(time.Minute) }
+	_, err = spService.CompleteLogin(ctx, "partner", pending, response, "127.0.0.1", "test")
+	assert.NoError(t, err)
+
+	pending, response = login()
+	saml.TimeNow = func() time.Time { return time.Now().Add(10 * time.Minute) }
+	_, err = spService.CompleteLogin(ctx, "partner", pending, response, "127.0.0.1", "test")
+	assert.ErrorIs(t, err, ErrSAMLResponse)
+	saml.TimeNow = time.Now
+
+	// A response signed by another IdP claiming the partner's entity ID is rejected
+	impostorKey, impostorCert, err := generateSAMLKeyPair()
+	require.NoError(t, err)
+	impostor := NewSAMLIdPService(repository.NewSAMLServiceProviderRepository(idpDB.DB), idpUsers, "https://idp.partner.example.com", impostorKey, impostorCert)
+	message, pending, err := spService.BeginLogin(ctx, "partner", "/")
+	require.NoError(t, err)
+	authn, err := impostor.ParseAuthnRequest(ctx, httptest.NewRequest("GET", message.URL, nil))
+	require.NoError(t, err)
+	forged, err := impostor.Respond(ctx, authn, idpSession)
+	require.NoError(t, err)
+	_, err = spService.CompleteLogin(ctx, "partner", pending, forged.Value, "127.0.0.1", "test")
+	assert.ErrorIs(t, err, ErrSAMLResponse)
+
+	// An existing local account is only linked when the connection trusts the IdP's emails
+	bob := testutil.CreateTestUser(t, idpDB, "bob@partner.example.com")
+	local := testutil.CreateTestUser(t, db, "bob@partner.example.com")
+	idpSession, err = idpSessions.CreateSession(ctx, bob.ID, "127.0.0.1", "test")
+	require.NoError(t, err)
+
+	pending, response = login()
+	_, err = spService.CompleteLogin(ctx, "partner", pending, response, "127.0.0.1", "test")
+	assert.ErrorIs(t, err, ErrUpstreamEmailUnverified)
+
+	trust := true
+	_, err = spService.UpdateConnection(ctx, conn.ID, SAMLConnectionRequest{
+		Name:        "Partner",
+		IdPMetadata: string(idpMetadata),
+		TrustEmail:  &trust,
+	})
+	require.NoError(t, err)
+	pending, response = login()
+	linked, err := spService.CompleteLogin(ctx, "partner", pending, response, "127.0.0.1", "test")
+	require.NoError(t, err)
+	assert.Equal(t, local.ID, linked.User.ID)
+
+	_, err = spService.UpdateConnection(ctx, conn.ID, SAMLConnectionRequest{
+		Name:        "Partner",
+		Slug:        "renamed",
+		IdPMetadata: string(idpMetadata),
+	})
+	assert.ErrorIs(t, err, ErrConnectionSlugFixed)
+}
@@ -0,0 +1,541 @@
+package service
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// scimFilter is a parsed SCIM filter expression (RFC 7644 section 3.4.2.2)
+type scimFilter struct {
+	Op    string      // and, or, not, pr, [] (value path) or a comparison: eq, ne, co, sw, ew, gt, ge, lt, le
+	Attr  string      // Lowercase attribute path, schema URN removed
+	Value interface{} // string, float64, bool or nil
+	Left  *scimFilter // Operand of not, inner filter of a value path
+	Right *scimFilter
+}
+
+// scimPath is a parsed PATCH path: attr, attr.sub, attr[filter] or attr[filter].sub
+type scimPath struct {
+	Attr   string
+	Filter *scimFilter
+	Sub    string
+}
+
+var scimCompareOps = map[string]bool{
+	"eq": true, "ne": true, "co": true, "sw": true, "ew": true,
+	"gt": true, "ge": true, "lt": true, "le": true,
+}
+
+type scimTokenKind int
+
+const (
+	scimTokenEOF scimTokenKind = iota
+	scimTokenWord
+	scimTokenString
+	scimTokenLParen
+	scimTokenRParen
+	scimTokenLBracket
+	scimTokenRBracket
+)
+
+type scimToken struct {
+	kind scimTokenKind
+	text string
+}
+
+func lexSCIMFilter(input string) ([]scimToken, error) {
+	var tokens []scimToken
+	for i := 0; i < len(input); {
+		switch ch := input[i]; {
+		case ch == ' ' || ch == '\t' || ch == '\n' || ch == '\r':
+			i++
+		case ch == '(':
+			tokens = append(tokens, scimToken{kind: scimTokenLParen})
+			i++
+		case ch == ')':
+			tokens = append(tokens, scimToken{kind: scimTokenRParen})
+			i++
+		case ch == '[':
+			tokens = append(tokens, scimToken{kind: scimTokenLBracket})
+			i++
+		case ch == ']':
+			tokens = append(tokens, scimToken{kind: scimTokenRBracket})
+			i++
+		case ch == '"':
+			// JSON string, escapes included
+			end := i + 1
+			for end < len(input) && input[end] != '"' {
+				if input[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(input) {
+				return nil, fmt.Errorf("%w: unterminated string", ErrSCIMInvalidFilter)
+			}
+			value, err := strconv.Unquote(input[i : end+1])
+			if err != nil {
+				return nil, fmt.Errorf("%w: invalid string %s", ErrSCIMInvalidFilter, input[i:end+1])
+			}
+			tokens = append(tokens, scimToken{kind: scimTokenString, text: value})
+			i = end + 1
+		default:
+			end := i
+			for end < len(input) && !strings.ContainsRune(" \t\r\n()[]\"", rune(input[end])) {
+				end++
+			}
+			tokens = append(tokens, scimToken{kind: scimTokenWord, text: input[i:end]})
+			i = end
+		}
+	}
+	return append(tokens, scimToken{kind: scimTokenEOF}), nil
+}
+
+type scimParser struct {
+	tokens []scimToken
+	pos    int
+}
+
+func (p *scimParser) peek() scimToken {
+	return p.tokens[p.pos]
+}
+
+func (p *scimParser) next() scimToken {
+	token := p.tokens[p.pos]
+	if token.kind != scimTokenEOF {
+		p.pos++
+	}
+	return token
+}
+
+func (p *scimParser) peekKeyword(keyword string) bool {
+	token := p.peek()
+	return token.kind == scimTokenWord && strings.EqualFold(token.text, keyword)
+}
+
+func (p *scimParser) expect(kind scimTokenKind, what string) error {
+	if p.next().kind != kind {
+		return fmt.Errorf("%w: expected %s", ErrSCIMInvalidFilter, what)
+	}
+	return nil
+}
+
+// parseSCIMFilter parses a filter query parameter
+func parseSCIMFilter(input string) (*scimFilter, error) {
+	tokens, err := lexSCIMFilter(input)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &scimParser{tokens: tokens}
+	filter, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek().kind != scimTokenEOF {
+		return nil, fmt.Errorf("%w: unexpected %q", ErrSCIMInvalidFilter, p.peek().text)
+	}
+	return filter, nil
+}
+
+func (p *scimParser) parseOr() (*scimFilter, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peekKeyword("or") {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &scimFilter{Op: "or", Left: left, Right: right}
+	}
+	return left, nil
+}
+
+func (p *scimParser) parseAnd() (*scimFilter, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peekKeyword("and") {
+		p.next()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &scimFilter{Op: "and", Left: left, Right: right}
+	}
+	return left, nil
+}
+
+func (p *scimParser) parseUnary() (*scimFilter, error) {
+	if p.peekKeyword("not") {
+		p.next()
+		if err := p.expect(scimTokenLParen, "( after not"); err != nil {
+			return nil, err
+		}
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(scimTokenRParen, ")"); err != nil {
+			return nil, err
+		}
+		return &scimFilter{Op: "not", Left: inner}, nil
+	}
+
+	if p.peek().kind == scimTokenLParen {
+		p.next()
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(scimTokenRParen, ")"); err != nil {
+			return nil, err
+		}
+		return inner, nil
+	}
+
+	return p.parseAttrExpr()
+}
+
+func (p *scimParser) parseAttrExpr() (*scimFilter, error) {
+	token := p.next()
+	if token.kind != scimTokenWord {
+		return nil, fmt.Errorf("%w: expected an attribute", ErrSCIMInvalidFilter)
+	}
+	attr := normalizeSCIMAttr(token.text)
+
+	if p.peek().kind == scimTokenLBracket {
+		p.next()
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(scimTokenRBracket, "]"); err != nil {
+			return nil, err
+		}
+		return &scimFilter{Op: "[]", Attr: attr, Left: inner}, nil
+	}
+
+	opToken := p.next()
+	op := strings.ToLower(opToken.text)
+	if opToken.kind != scimTokenWord || (op != "pr" && !scimCompareOps[op]) {
+		return nil, fmt.Errorf("%w: expected an operator after %s", ErrSCIMInvalidFilter, attr)
+	}
+	if op == "pr" {
+		return &scimFilter{Op: op, Attr: attr}, nil
+	}
+
+	valueToken := p.next()
+	var value interface{}
+	switch {
+	case valueToken.kind == scimTokenString:
+		value = valueToken.text
+	case valueToken.kind != scimTokenWord:
+		return nil, fmt.Errorf("%w: expected a value after %s %s", ErrSCIMInvalidFilter, attr, op)
+	case strings.EqualFold(valueToken.text, "true"):
+		value = true
+	case strings.EqualFold(valueToken.text, "false"):
+		value = false
+	case strings.EqualFold(valueToken.text, "null"):
+		value = nil
+	default:
+		number, err := strconv.ParseFloat(valueToken.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid value %s", ErrSCIMInvalidFilter, valueToken.text)
+		}
+		value = number
+	}
+
+	return &scimFilter{Op: op, Attr: attr, Value: value}, nil
+}
+
+// parseSCIMPath parses the path of a PATCH operation
+func parseSCIMPath(input string) (*scimPath, error) {
+	tokens, err := lexSCIMFilter(input)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrSCIMInvalidPath, err)
+	}
+	p := &scimParser{tokens: tokens}
+
+	token := p.next()
+	if token.kind != scimTokenWord {
+		return nil, fmt.Errorf("%w: %q", ErrSCIMInvalidPath, input)
+	}
+	path := &scimPath{Attr: normalizeSCIMAttr(token.text)}
+	if attr, sub, ok := strings.Cut(path.Attr, "."); ok {
+		path.Attr, path.Sub = attr, sub
+	}
+
+	if p.peek().kind == scimTokenLBracket {
+		if path.Sub != "" {
+			return nil, fmt.Errorf("%w: %q", ErrSCIMInvalidPath, input)
+		}
+		p.next()
+		path.Filter, err = p.parseOr()
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrSCIMInvalidPath, err)
+		}
+		if err := p.expect(scimTokenRBracket, "]"); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrSCIMInvalidPath, err)
+		}
+		if token := p.peek(); token.kind == scimTokenWord && strings.HasPrefix(token.text, ".") {
+			p.next()
+			path.Sub = strings.ToLower(token.text[1:])
+		}
+	}
+
+	if p.peek().kind != scimTokenEOF || path.Attr == "" {
+		return nil, fmt.Errorf("%w: %q", ErrSCIMInvalidPath, input)
+	}
+	return path, nil
+}
+
+// normalizeSCIMAttr lowercases an attribute path and removes its schema URN,
+// e.g. urn:ietf:params:scim:schemas:core:2.0:User:name.givenName -> name.givenname
+func normalizeSCIMAttr(attr string) string {
+	attr = strings.ToLower(attr)
+	if strings.HasPrefix(attr, "urn:") {
+		if i := strings.LastIndex(attr, ":"); i >= 0 {
+			attr = attr[i+1:]
+		}
+	}
+	return attr
+}
+
+// scimColumnKind is the SQL type an attribute is stored as
+type scimColumnKind int
+
+const (
+	scimString scimColumnKind = iota
+	scimBoolean
+	scimDateTime
+)
+
+// scimColumn maps a filterable attribute to SQL
+type scimColumn struct {
+	Expr string
+	Kind scimColumnKind
+	// In, when set, is a subquery condition on the resource with %s standing for
+	// the comparison on Expr, for attributes stored in another table
+	In     string
+	InArgs []interface{}
+}
+
+// sql compiles the filter to a WHERE condition over columns, keyed by lowercase
+// attribute path. String comparisons ignore case, as SCIM's caseExact=false does.
+func (f *scimFilter) sql(columns map[string]scimColumn) (string, []interface{}, error) {
+	switch f.Op {
+	case "and", "or":
+		left, leftArgs, err := f.Left.sql(columns)
+		if err != nil {
+			return "", nil, err
+		}
+		right, rightArgs, err := f.Right.sql(columns)
+		if err != nil {
+			return "", nil, err
+		}
+		return fmt.Sprintf("(%s %s %s)", left, strings.ToUpper(f.Op), right), append(leftArgs, rightArgs...), nil
+	case "not":
+		inner, args, err := f.Left.sql(columns)
+		if err != nil {
+			return "", nil, err
+		}
+		return "NOT (" + inner + ")", args, nil
+	case "[]":
+		// Our multi-valued attributes hold a single value, so attr[sub op x] is sub op x
+		return f.Left.scoped(f.Attr).sql(columns)
+	}
+
+	column, ok := columns[f.Attr]
+	if !ok {
+		return "", nil, fmt.Errorf("%w: attribute %s cannot be filtered on", ErrSCIMInvalidFilter, f.Attr)
+	}
+
+	op := f.Op
+	negate := column.In != "" && op == "ne"
+	if negate {
+		op = "eq"
+	}
+
+	condition, args, err := column.compare(op, f.Value)
+	if err != nil {
+		return "", nil, fmt.Errorf("%w: %s %s: %v", ErrSCIMInvalidFilter, f.Attr, f.Op, err)
+	}
+
+	if column.In != "" {
+		condition = fmt.Sprintf(column.In, condition)
+		args = append(append([]interface{}{}, column.InArgs...), args...)
+	}
+	if negate {
+		condition = "NOT (" + condition + ")"
+	}
+	return condition, args, nil
+}
+
+// scoped prefixes the attributes of a value path filter with the path's attribute
+func (f *scimFilter) scoped(attr string) *scimFilter {
+	if f == nil {
+		return nil
+	}
+	scoped := *f
+	if scoped.Attr != "" {
+		scoped.Attr = attr + "." + scoped.Attr
+	}
+	scoped.Left = f.Left.scoped(attr)
+	scoped.Right = f.Right.scoped(attr)
+	return &scoped
+}
+
+func (c scimColumn) compare(op string, value interface{}) (string, []interface{}, error) {
+	if op == "pr" {
+		if c.Kind == scimString {
+			return fmt.Sprintf("(%s IS NOT NULL AND %s <> '')", c.Expr, c.Expr), nil, nil
+		}
+		return c.Expr + " IS NOT NULL", nil, nil
+	}
+
+	switch c.Kind {
+	case scimBoolean:
+		b, ok := value.(bool)
+		if !ok || (op != "eq" && op != "ne") {
+			return "", nil, fmt.Errorf("expected eq or ne with true or false")
+		}
+		return fmt.Sprintf("%s %s ?", c.Expr, scimSQLOperators[op]), []interface{}{b}, nil
+
+	case scimDateTime:
+		s, ok := value.(string)
+		if !ok || op == "co" || op == "sw" || op == "ew" {
+			return "", nil, fmt.Errorf("expected a comparison with a date-time string")
+		}
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			return "", nil, fmt.Errorf("invalid date-time %q", s)
+		}
+		return fmt.Sprintf("%s %s ?", c.Expr, scimSQLOperators[op]), []interface{}{t}, nil
+	}
+
+	s, ok := value.(string)
+	if !ok {
+		return "", nil, fmt.Errorf("expected a string")
+	}
+	s = strings.ToLower(s)
+	lower := "LOWER(" + c.Expr + ")"
+
+	switch op {
+	case "co":
+		return lower + " LIKE ? ESCAPE '!'", []interface{}{"%" + escapeLike(s) + "%"}, nil
+	case "sw":
+		return lower + " LIKE ? ESCAPE '!'", []interface{}{escapeLike(s) + "%"}, nil
+	case "ew":
+		return lower + " LIKE ? ESCAPE '!'", []interface{}{"%" + escapeLike(s)}, nil
+	}
+	return fmt.Sprintf("%s %s ?", lower, scimSQLOperators[op]), []interface{}{s}, nil
+}
+
+var scimSQLOperators = map[string]string{
+	"eq": "=", "ne": "<>", "gt": ">", "ge": ">=", "lt": "<", "le": "<=",
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer("!", "!!", "%", "!%", "_", "!_").Replace(s)
+}
+
+// matches evaluates the filter against one value of a multi-valued attribute,
+// for PATCH paths such as members[value eq "..."]
+func (f *scimFilter) matches(element map[string]interface{}) bool {
+	switch f.Op {
+	case "and":
+		return f.Left.matches(element) && f.Right.matches(element)
+	case "or":
+		return f.Left.matches(element) || f.Right.matches(element)
+	case "not":
+		return !f.Left.matches(element)
+	case "[]":
+		return false
+	}
+
+	actual, present := lookupSCIMAttr(element, f.Attr)
+	if f.Op == "pr" {
+		return present && actual != nil && actual != ""
+	}
+
+	switch want := f.Value.(type) {
+	case string:
+		got, ok := actual.(string)
+		if !ok {
+			return f.Op == "ne"
+		}
+		got, want = strings.ToLower(got), strings.ToLower(want)
+		switch f.Op {
+		case "eq":
+			return got == want
+		case "ne":
+			return got != want
+		case "co":
+			return strings.Contains(got, want)
+		case "sw":
+			return strings.HasPrefix(got, want)
+		case "ew":
+			return strings.HasSuffix(got, want)
+		case "gt":
+			return got > want
+		case "ge":
+			return got >= want
+		case "lt":
+			return got < want
+		case "le":
+			return got <= want
+		}
+	case bool:
+		got, ok := actual.(bool)
+		return ok && (got == want) == (f.Op == "eq")
+	case float64:
+		got, ok := actual.(float64)
+		if !ok {
+			return false
+		}
+		switch f.Op {
+		case "eq":
+			return got == want
+		case "ne":
+			return got != want
+		case "gt":
+			return got > want
+		case "ge":
+			return got >= want
+		case "lt":
+			return got < want
+		case "le":
+			return got <= want
+		}
+	case nil:
+		return (actual == nil) == (f.Op == "eq")
+	}
+	return false
+}
+
+// lookupSCIMAttr finds an attribute (or attr.sub) in a JSON object, ignoring case
+// as SCIM attribute names do
+func lookupSCIMAttr(object map[string]interface{}, attr string) (interface{}, bool) {
+	name, sub, nested := strings.Cut(attr, ".")
+	for key, value := range object {
+		if !strings.EqualFold(key, name) {
+			continue
+		}
+		if !nested {
+			return value, true
+		}
+		child, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		return lookupSCIMAttr(child, sub)
+	}
+	return nil, false
+}
@@ -0,0 +1,162 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/sso-project/sso-server/internal/models"
+)
+
+// scimRemoteError is an error response from a downstream SCIM API
+type scimRemoteError struct {
+	Status int
+	Detail string
+}
+
+func (e *scimRemoteError) Error() string {
+	if e.Detail == "" {
+		return fmt.Sprintf("%s: HTTP %d", ErrProvisioningRejected, e.Status)
+	}
+	return fmt.Sprintf("%s: HTTP %d: %s", ErrProvisioningRejected, e.Status, e.Detail)
+}
+
+func (e *scimRemoteError) Unwrap() error {
+	return ErrProvisioningRejected
+}
+
+// remoteStatus returns the HTTP status of a downstream error, or 0
+func remoteStatus(err error) int {
+	var remoteErr *scimRemoteError
+	if errors.As(err, &remoteErr) {
+		return remoteErr.Status
+	}
+	return 0
+}
+
+// retryableProvisioningError tells whether a failed push may succeed later:
+// the application was unreachable, overloaded or failing
+func retryableProvisioningError(err error) bool {
+	if errors.Is(err, ErrProvisioningUnavailable) {
+		return true
+	}
+	status := remoteStatus(err)
+	return status == http.StatusRequestTimeout || status == http.StatusTooManyRequests || status >= 500
+}
+
+// scimRemote calls the SCIM 2.0 API of a downstream application
+type scimRemote struct {
+	client    *http.Client
+	connector *models.ProvisioningConnector
+}
+
+// createUser creates a user and returns the application's id for it
+func (r *scimRemote) createUser(ctx context.Context, doc map[string]interface{}) (string, error) {
+	var created struct {
+		ID string `json:"id"`
+	}
+	if err := r.do(ctx, http.MethodPost, "/Users", doc, &created); err != nil {
+		return "", err
+	}
+	if created.ID == "" {
+		return "", fmt.Errorf("%w: created user has no id", ErrProvisioningRejected)
+	}
+	return created.ID, nil
+}
+
+// replaceUser replaces the user with the given id
+func (r *scimRemote) replaceUser(ctx context.Context, id string, doc map[string]interface{}) error {
+	return r.do(ctx, http.MethodPut, "/Users/"+url.PathEscape(id), doc, nil)
+}
+
+// deactivateUser sets active to false on the user with the given id
+func (r *scimRemote) deactivateUser(ctx context.Context, id string) error {
+	patch := map[string]interface{}{
+		"schemas":    []string{SCIMSchemaPatchOp},
+		"Operations": []map[string]interface{}{{"op": "replace", "path": "active", "value": false}},
+	}
+	return r.do(ctx, http.MethodPatch, "/Users/"+url.PathEscape(id), patch, nil)
+}
+
+// deleteUser deletes the user with the given id; a user that is already gone is not an error
+func (r *scimRemote) deleteUser(ctx context.Context, id string) error {
+	err := r.do(ctx, http.MethodDelete, "/Users/"+url.PathEscape(id), nil, nil)
+	if remoteStatus(err) == http.StatusNotFound {
+		return nil
+	}
+	return err
+}
+
+// findUser returns the id of the user with the given userName, or "" when there is none
+func (r *scimRemote) findUser(ctx context.Context, userName string) (string, error) {
+	filter := `userName eq "` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(userName) + `"`
+	var list struct {
+		Resources []struct {
+			ID string `json:"id"`
+		} `json:"Resources"`
+	}
+	if err := r.do(ctx, http.MethodGet, "/Users?filter="+url.QueryEscape(filter), nil, &list); err != nil {
+		return "", err
+	}
+	if len(list.Resources) == 0 {
+		return "", nil
+	}
+	return list.Resources[0].ID, nil
+}
+
+func (r *scimRemote) do(ctx context.Context, method, path string, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimRight(r.connector.EndpointURL, "/")+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/scim+json, application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/scim+json")
+	}
+	switch r.connector.AuthType {
+	case provisioningAuthBasic:
+		req.SetBasicAuth(r.connector.Username, r.connector.Secret)
+	default:
+		req.Header.Set("Authorization", "Bearer "+r.connector.Secret)
+	}
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrProvisioningUnavailable, err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrProvisioningUnavailable, err)
+	}
+	if resp.StatusCode >= 300 {
+		var scimErr struct {
+			Detail string `json:"detail"`
+		}
+		json.Unmarshal(data, &scimErr)
+		return &scimRemoteError{Status: resp.StatusCode, Detail: scimErr.Detail}
+	}
+
+	if out != nil && len(data) > 0 {
+		if err := json.Unmarshal(data, out); err != nil {
+			return fmt.Errorf("%w: invalid response: %v", ErrProvisioningRejected, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,266 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// applySCIMPatch applies PATCH operations (RFC 7644 section 3.5.2) to the JSON
+// form of current and decodes the result into out, which the caller then saves
+// as a replacement. Attributes this server does not store are accepted and dropped.
+func applySCIMPatch(current interface{}, patch *SCIMPatchRequest, out interface{}) error {
+	if !slices.Contains(patch.Schemas, SCIMSchemaPatchOp) || len(patch.Operations) == 0 {
+		return ErrSCIMInvalidPatch
+	}
+
+	data, err := json.Marshal(current)
+	if err != nil {
+		return err
+	}
+	var doc map[string]interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return err
+	}
+	delete(doc, "meta")
+
+	for _, op := range patch.Operations {
+		if err := applySCIMOperation(doc, op); err != nil {
+			return err
+		}
+	}
+
+	// Some clients send booleans as "True"/"False"
+	if key, ok := findSCIMKey(doc, "active"); ok {
+		if s, isString := doc[key].(string); isString {
+			active, err := strconv.ParseBool(s)
+			if err != nil {
+				return fmt.Errorf("%w: active must be a boolean", ErrSCIMInvalidValue)
+			}
+			doc[key] = active
+		}
+	}
+
+	if data, err = json.Marshal(doc); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("%w: %v", ErrSCIMInvalidValue, err)
+	}
+	return nil
+}
+
+func applySCIMOperation(doc map[string]interface{}, op SCIMPatchOperation) error {
+	var value interface{}
+	if len(op.Value) > 0 {
+		if err := json.Unmarshal(op.Value, &value); err != nil {
+			return fmt.Errorf("%w: %v", ErrSCIMInvalidValue, err)
+		}
+	}
+
+	switch strings.ToLower(op.Op) {
+	case "add", "replace":
+		add := strings.EqualFold(op.Op, "add")
+		if op.Path == "" {
+			// Without a path the value is an object of attributes to set
+			object, ok := value.(map[string]interface{})
+			if !ok {
+				return fmt.Errorf("%w: %s without a path needs an object value", ErrSCIMInvalidValue, op.Op)
+			}
+			for attr, attrValue := range object {
+				path, err := parseSCIMPath(attr)
+				if err != nil {
+					return err
+				}
+				if err := setSCIMValue(doc, path, attrValue, add); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+
+		path, err := parseSCIMPath(op.Path)
+		if err != nil {
+			return err
+		}
+		return setSCIMValue(doc, path, value, add)
+
+	case "remove":
+		if op.Path == "" {
+			return ErrSCIMNoTarget
+		}
+		path, err := parseSCIMPath(op.Path)
+		if err != nil {
+			return err
+		}
+		return removeSCIMValue(doc, path, value)
+	}
+
+	return fmt.Errorf("%w: unknown op %q", ErrSCIMInvalidPatch, op.Op)
+}
+
+func setSCIMValue(doc map[string]interface{}, path *scimPath, value interface{}, add bool) error {
+	key, _ := findSCIMKey(doc, path.Attr)
+	existing := doc[key]
+
+	if path.Filter != nil {
+		list, _ := existing.([]interface{})
+		matched := false
+		for _, element := range list {
+			object, ok := element.(map[string]interface{})
+			if !ok || !path.Filter.matches(object) {
+				continue
+			}
+			matched = true
+			if path.Sub != "" {
+				setSCIMKey(object, path.Sub, value)
+				continue
+			}
+			fields, ok := value.(map[string]interface{})
+			if !ok {
+				return fmt.Errorf("%w: %s needs an object value", ErrSCIMInvalidValue, path.Attr)
+			}
+			for field, fieldValue := range fields {
+				setSCIMKey(object, field, fieldValue)
+			}
+		}
+		if !matched {
+			return ErrSCIMNoTarget
+		}
+		return nil
+	}
+
+	if path.Sub != "" {
+		// attr.sub sets the sub-attribute of a complex attribute, or of every value
+		switch current := existing.(type) {
+		case []interface{}:
+			for _, element := range current {
+				if object, ok := element.(map[string]interface{}); ok {
+					setSCIMKey(object, path.Sub, value)
+				}
+			}
+		case map[string]interface{}:
+			setSCIMKey(current, path.Sub, value)
+		default:
+			doc[key] = map[string]interface{}{path.Sub: value}
+		}
+		return nil
+	}
+
+	switch current := existing.(type) {
+	case []interface{}:
+		values, ok := value.([]interface{})
+		if !ok {
+			values = []interface{}{value}
+		}
+		if !add {
+			doc[key] = values
+			return nil
+		}
+		// Adding a value that is already there changes nothing
+		for _, v := range values {
+			if !slices.ContainsFunc(current, func(e interface{}) bool { return sameSCIMValue(e, v) }) {
+				current = append(current, v)
+			}
+		}
+		doc[key] = current
+		return nil
+	case map[string]interface{}:
+		if fields, ok := value.(map[string]interface{}); ok && add {
+			for field, fieldValue := range fields {
+				setSCIMKey(current, field, fieldValue)
+			}
+			return nil
+		}
+	}
+
+	doc[key] = value
+	return nil
+}
+
+func removeSCIMValue(doc map[string]interface{}, path *scimPath, value interface{}) error {
+	key, found := findSCIMKey(doc, path.Attr)
+	if !found {
+		return nil
+	}
+
+	if list, ok := doc[key].([]interface{}); ok {
+		var kept []interface{}
+		for _, element := range list {
+			object, _ := element.(map[string]interface{})
+			var remove bool
+			switch {
+			case path.Filter != nil:
+				remove = object != nil && path.Filter.matches(object)
+			case value != nil && path.Sub == "":
+				// {"op": "remove", "path": "members", "value": [{"value": "..."}]}
+				values, ok := value.([]interface{})
+				if !ok {
+					values = []interface{}{value}
+				}
+				remove = slices.ContainsFunc(values, func(v interface{}) bool { return sameSCIMValue(element, v) })
+			default:
+				remove = path.Sub == ""
+			}
+
+			if remove && path.Sub != "" {
+				deleteSCIMKey(object, path.Sub)
+				remove = false
+			}
+			if !remove {
+				kept = append(kept, element)
+			}
+		}
+		if kept == nil {
+			kept = []interface{}{}
+		}
+		doc[key] = kept
+		return nil
+	}
+
+	if path.Sub != "" {
+		if object, ok := doc[key].(map[string]interface{}); ok {
+			deleteSCIMKey(object, path.Sub)
+		}
+		return nil
+	}
+
+	delete(doc, key)
+	return nil
+}
+
+// sameSCIMValue compares two values of a multi-valued attribute by their value
+func sameSCIMValue(a, b interface{}) bool {
+	valueOf := func(v interface{}) interface{} {
+		if object, ok := v.(map[string]interface{}); ok {
+			value, _ := lookupSCIMAttr(object, "value")
+			return value
+		}
+		return v
+	}
+	return valueOf(a) == valueOf(b)
+}
+
+// findSCIMKey returns the key of an attribute in object, ignoring case, or the
+// lowercase name when the attribute is not set
+func findSCIMKey(object map[string]interface{}, attr string) (string, bool) {
+	for key := range object {
+		if strings.EqualFold(key, attr) {
+			return key, true
+		}
+	}
+	return strings.ToLower(attr), false
+}
+
+func setSCIMKey(object map[string]interface{}, attr string, value interface{}) {
+	key, _ := findSCIMKey(object, attr)
+	object[key] = value
+}
+
+func deleteSCIMKey(object map[string]interface{}, attr string) {
+	if key, found := findSCIMKey(object, attr); found {
+		delete(object, key)
+	}
+}
@@ -0,0 +1,948 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/mail"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sso-project/sso-server/internal/models"
+	"github.com/sso-project/sso-server/internal/repository"
+	"github.com/sso-project/sso-server/internal/utils"
+)
+
+var (
+	ErrSCIMClientRequired     = errors.New("name is required")
+	ErrSCIMInvalidToken       = errors.New("invalid or expired SCIM token")
+	ErrSCIMNotFound           = errors.New("resource not found")
+	ErrSCIMUniqueness         = errors.New("a resource with this userName or displayName already exists")
+	ErrSCIMInvalidValue       = errors.New("invalid value")
+	ErrSCIMInvalidFilter      = errors.New("invalid filter")
+	ErrSCIMInvalidPath        = errors.New("invalid path")
+	ErrSCIMNoTarget           = errors.New("path is missing or matches no value")
+	ErrSCIMInvalidPatch       = errors.New("invalid PATCH request")
+	ErrSCIMPreconditionFailed = errors.New("resource has changed since the given version")
+)
+
+// SCIM schema and message URNs (RFC 7643, RFC 7644)
+const (
+	SCIMSchemaUser                  = "urn:ietf:params:scim:schemas:core:2.0:User"
+	SCIMSchemaGroup                 = "urn:ietf:params:scim:schemas:core:2.0:Group"
+	SCIMSchemaListResponse          = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	SCIMSchemaPatchOp               = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+	SCIMSchemaError                 = "urn:ietf:params:scim:api:messages:2.0:Error"
+	SCIMSchemaServiceProviderConfig = "urn:ietf:params:scim:schemas:core:2.0:ServiceProviderConfig"
+	SCIMSchemaResourceType          = "urn:ietf:params:scim:schemas:core:2.0:ResourceType"
+	SCIMSchemaSchema                = "urn:ietf:params:scim:schemas:core:2.0:Schema"
+)
+
+// SCIMMaxResults is the largest page a list request returns
+const SCIMMaxResults = 200
+
+const (
+	scimResourceUser  = "User"
+	scimResourceGroup = "Group"
+	scimTokenPrefix   = "scim_"
+)
+
+// SCIMClientRequest holds the admin-editable settings of a SCIM client
+type SCIMClientRequest struct {
+	Name        string     `json:"name"`
+	Description string     `json:"description"`
+	ExpiresAt   *time.Time `json:"expires_at"`
+	IsActive    *bool      `json:"is_active"`
+}
+
+// SCIMMeta is the meta attribute of a resource
+type SCIMMeta struct {
+	ResourceType string    `json:"resourceType"`
+	Created      time.Time `json:"created"`
+	LastModified time.Time `json:"lastModified"`
+	Location     string    `json:"location"`
+	Version      string    `json:"version"`
+}
+
+// SCIMName is the name attribute of a user. Users have a single display name
+// here, so givenName and familyName are derived from it.
+type SCIMName struct {
+	Formatted  string `json:"formatted,omitempty"`
+	GivenName  string `json:"givenName,omitempty"`
+	FamilyName string `json:"familyName,omitempty"`
+}
+
+// SCIMValue is one value of a multi-valued attribute (emails, groups, members)
+type SCIMValue struct {
+	Value   string `json:"value"`
+	Display string `json:"display,omitempty"`
+	Type    string `json:"type,omitempty"`
+	Primary bool   `json:"primary,omitempty"`
+	Ref     string `json:"$ref,omitempty"`
+}
+
+// SCIMUser is a user in the core User schema. userName is the email address.
+type SCIMUser struct {
+	Schemas     []string    `json:"schemas"`
+	ID          string      `json:"id,omitempty"`
+	ExternalID  string      `json:"externalId,omitempty"`
+	UserName    string      `json:"userName"`
+	Name        *SCIMName   `json:"name,omitempty"`
+	DisplayName string      `json:"displayName,omitempty"`
+	Emails      []SCIMValue `json:"emails,omitempty"`
+	Active      *bool       `json:"active,omitempty"`
+	Password    string      `json:"password,omitempty"` // Write-only
+	Groups      []SCIMValue `json:"groups,omitempty"`   // Read-only; set through Group members
+	Meta        *SCIMMeta   `json:"meta,omitempty"`
+}
+
+// SCIMGroup is a role in the core Group schema
+type SCIMGroup struct {
+	Schemas     []string    `json:"schemas"`
+	ID          string      `json:"id,omitempty"`
+	ExternalID  string      `json:"externalId,omitempty"`
+	DisplayName string      `json:"displayName"`
+	Members     []SCIMValue `json:"members"`
+	Meta        *SCIMMeta   `json:"meta,omitempty"`
+}
+
+// SCIMListResponse is a page of query results
+type SCIMListResponse struct {
+	Schemas      []string    `json:"schemas"`
+	TotalResults int64       `json:"totalResults"`
+	StartIndex   int         `json:"startIndex"`
+	ItemsPerPage int         `json:"itemsPerPage"`
+	Resources    interface{} `json:"Resources"`
+}
+
+// SCIMQuery holds the query parameters of a list request
+type SCIMQuery struct {
+	Filter             string
+	StartIndex         int // 1-based
+	Count              int // -1 when not given
+	ExcludedAttributes []string
+}
+
+// SCIMPatchOperation is one operation of a PATCH request
+type SCIMPatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value"`
+}
+
+// SCIMPatchRequest is the body of a PATCH request
+type SCIMPatchRequest struct {
+	Schemas    []string             `json:"schemas"`
+	Operations []SCIMPatchOperation `json:"Operations"`
+}
+
+// SCIMService implements the SCIM 2.0 provisioning API on top of users and roles,
+// and manages the clients allowed to call it
+type SCIMService struct {
+	clientRepo     *repository.SCIMClientRepository
+	userRepo       *repository.UserRepository
+	roleRepo       *repository.RoleRepository
+	sessionService *SessionService
+	baseURL        string
+}
+
+// NewSCIMService creates a new SCIMService
+func NewSCIMService(
+	clientRepo *repository.SCIMClientRepository,
+	userRepo *repository.UserRepository,
+	roleRepo *repository.RoleRepository,
+	sessionService *SessionService,
+	publicBaseURL string,
+) *SCIMService {
+	return &SCIMService{
+		clientRepo:     clientRepo,
+		userRepo:       userRepo,
+		roleRepo:       roleRepo,
+		sessionService: sessionService,
+		baseURL:        strings.TrimSuffix(publicBaseURL, "/") + "/scim/v2",
+	}
+}
+
+// BaseURL returns the SCIM base URL clients are configured with
+func (s *SCIMService) BaseURL() string {
+	return s.baseURL
+}
+
+// ==================== Clients ====================
+
+// CreateClient registers a SCIM client and returns its bearer token, which is
+// only stored hashed and cannot be shown again
+func (s *SCIMService) CreateClient(ctx context.Context, req SCIMClientRequest) (*models.SCIMClient, string, error) {
+	client := &models.SCIMClient{
+		ID:       uuid.New().String(),
+		IsActive: true,
+	}
+	if err := s.applyClient(client, req); err != nil {
+		return nil, "", err
+	}
+
+	token, err := s.issueToken(client)
+	if err != nil {
+		return nil, "", err
+	}
+	if err := s.clientRepo.Create(ctx, client); err != nil {
+		return nil, "", err
+	}
+
+	return client, token, nil
+}
+
+// GetClient retrieves a SCIM client by ID
+func (s *SCIMService) GetClient(ctx context.Context, id string) (*models.SCIMClient, error) {
+	return s.clientRepo.GetByID(ctx, id)
+}
+
+// ListClients retrieves every SCIM client
+func (s *SCIMService) ListClients(ctx context.Context) ([]*models.SCIMClient, error) {
+	return s.clientRepo.GetAll(ctx)
+}
+
+// UpdateClient replaces the settings of a SCIM client; the token is kept
+func (s *SCIMService) UpdateClient(ctx context.Context, id string, req SCIMClientRequest) (*models.SCIMClient, error) {
+	client, err := s.clientRepo.GetByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.applyClient(client, req); err != nil {
+		return nil, err
+	}
+	if err := s.clientRepo.Update(ctx, client); err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+// RotateToken replaces the bearer token of a SCIM client; the old one stops working
+func (s *SCIMService) RotateToken(ctx context.Context, id string) (*models.SCIMClient, string, error) {
+	client, err := s.clientRepo.GetByID(ctx, id)
+	if err != nil {
+		return nil, "", err
+	}
+	token, err := s.issueToken(client)
+	if err != nil {
+		return nil, "", err
+	}
+	if err := s.clientRepo.Update(ctx, client); err != nil {
+		return nil, "", err
+	}
+	return client, token, nil
+}
+
+// DeleteClient deletes a SCIM client. Users and groups it created are kept.
+func (s *SCIMService) DeleteClient(ctx context.Context, id string) error {
+	return s.clientRepo.Delete(ctx, id)
+}
+
+// Authenticate returns the active SCIM client a bearer token was issued to
+func (s *SCIMService) Authenticate(ctx context.Context, token string) (*models.SCIMClient, error) {
+	if !strings.HasPrefix(token, scimTokenPrefix) {
+		return nil, ErrSCIMInvalidToken
+	}
+
+	client, err := s.clientRepo.GetByTokenHash(ctx, repository.HashToken(token))
+	if err != nil {
+		if errors.Is(err, repository.ErrSCIMClientNotFound) {
+			return nil, ErrSCIMInvalidToken
+		}
+		return nil, err
+	}
+	if !client.IsActive || (client.ExpiresAt != nil && time.Now().After(*client.ExpiresAt)) {
+		return nil, ErrSCIMInvalidToken
+	}
+
+	s.clientRepo.RecordUse(ctx, client.ID, time.Now())
+	return client, nil
+}
+
+func (s *SCIMService) applyClient(client *models.SCIMClient, req SCIMClientRequest) error {
+	if strings.TrimSpace(req.Name) == "" {
+		return ErrSCIMClientRequired
+	}
+	client.Name = strings.TrimSpace(req.Name)
+	client.Description = req.Description
+	client.ExpiresAt = req.ExpiresAt
+	if req.IsActive != nil {
+		client.IsActive = *req.IsActive
+	}
+	return nil
+}
+
+func (s *SCIMService) issueToken(client *models.SCIMClient) (string, error) {
+	secret, err := utils.GenerateRandomToken(40)
+	if err != nil {
+		return "", err
+	}
+	token := scimTokenPrefix + secret
+	client.TokenHash = repository.HashToken(token)
+	client.TokenPrefix = token[:len(scimTokenPrefix)+6]
+	return token, nil
+}
+
+// ==================== Users ====================
+
+// scimUserColumns are the User attributes filters can use
+func scimUserColumns(clientID string) map[string]scimColumn {
+	externalID := scimColumn{
+		Expr:   "external_id",
+		In:     "id IN (SELECT resource_id FROM scim_external_ids WHERE client_id = ? AND resource_type = ? AND %s)",
+		InArgs: []interface{}{clientID, scimResourceUser},
+	}
+	groups := scimColumn{
+		Expr: "role_id",
+		In:   "id IN (SELECT user_id FROM user_roles WHERE %s)",
+	}
+	return map[string]scimColumn{
+		"id":                {Expr: "id"},
+		"username":          {Expr: "email"},
+		"emails":            {Expr: "email"},
+		"emails.value":      {Expr: "email"},
+		"displayname":       {Expr: "name"},
+		"name.formatted":    {Expr: "name"},
+		"active":            {Expr: "is_active", Kind: scimBoolean},
+		"externalid":        externalID,
+		"groups":            groups,
+		"groups.value":      groups,
+		"meta.created":      {Expr: "created_at", Kind: scimDateTime},
+		"meta.lastmodified": {Expr: "updated_at", Kind: scimDateTime},
+	}
+}
+
+// ListUsers returns a page of the users matching the query's filter
+func (s *SCIMService) ListUsers(ctx context.Context, client *models.SCIMClient, query SCIMQuery) (*SCIMListResponse, error) {
+	condition, args, err := s.compileFilter(query.Filter, scimUserColumns(client.ID))
+	if err != nil {
+		return nil, err
+	}
+
+	offset, limit := query.page()
+	users, total, err := s.userRepo.ListWhere(ctx, condition, args, offset, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, len(users))
+	for i, user := range users {
+		ids[i] = user.ID
+	}
+	externalIDs, err := s.clientRepo.GetExternalIDs(ctx, client.ID, scimResourceUser, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	resources := make([]*SCIMUser, len(users))
+	for i, user := range users {
+		resources[i] = s.userResource(user, externalIDs[user.ID])
+		if query.excludes("groups") {
+			resources[i].Groups = nil
+		}
+	}
+
+	return &SCIMListResponse{
+		Schemas:      []string{SCIMSchemaListResponse},
+		TotalResults: total,
+		StartIndex:   offset + 1,
+		ItemsPerPage: len(resources),
+		Resources:    resources,
+	}, nil
+}
+
+// GetUser returns a user
+func (s *SCIMService) GetUser(ctx context.Context, client *models.SCIMClient, id string) (*SCIMUser, error) {
+	user, err := s.getUser(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return s.userResourceFor(ctx, client, user)
+}
+
+// CreateUser creates a user
+func (s *SCIMService) CreateUser(ctx context.Context, client *models.SCIMClient, in *SCIMUser) (*SCIMUser, error) {
+	user := &models.User{
+		ID:                uuid.New().String(),
+		EmailVerified:     true, // The provisioning system is authoritative for its users' addresses
+		IsActive:          true,
+		PasswordChangedAt: time.Now(),
+	}
+	if err := s.applyUser(ctx, user, in); err != nil {
+		return nil, err
+	}
+	if user.PasswordHash == "" {
+		// Provisioned users sign in through SSO or set a password through reset
+		password, err := utils.GenerateRandomToken(32)
+		if err != nil {
+			return nil, err
+		}
+		if user.PasswordHash, err = utils.HashPassword(password); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := s.userRepo.Create(ctx, user); err != nil {
+		if errors.Is(err, repository.ErrAlreadyExists) {
+			return nil, ErrSCIMUniqueness
+		}
+		return nil, err
+	}
+	if err := s.setExternalID(ctx, client, scimResourceUser, user.ID, in.ExternalID); err != nil {
+		return nil, err
+	}
+
+	return s.userResourceFor(ctx, client, user)
+}
+
+// ReplaceUser replaces a user's attributes (PUT). ifMatch, when given, must be the
+// current version.
+func (s *SCIMService) ReplaceUser(ctx context.Context, client *models.SCIMClient, id string, in *SCIMUser, ifMatch string) (*SCIMUser, error) {
+	user, err := s.getUser(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	current, err := s.userResourceFor(ctx, client, user)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkSCIMVersion(current.Meta, ifMatch); err != nil {
+		return nil, err
+	}
+
+	return s.replaceUser(ctx, client, user, in)
+}
+
+// PatchUser applies PATCH operations to a user
+func (s *SCIMService) PatchUser(ctx context.Context, client *models.SCIMClient, id string, patch *SCIMPatchRequest, ifMatch string) (*SCIMUser, error) {
+	user, err := s.getUser(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	current, err := s.userResourceFor(ctx, client, user)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkSCIMVersion(current.Meta, ifMatch); err != nil {
+		return nil, err
+	}
+
+	var patched SCIMUser
+	if err := applySCIMPatch(current, patch, &patched); err != nil {
+		return nil, err
+	}
+	return s.replaceUser(ctx, client, user, &patched)
+}
+
+// DeleteUser deletes a user
+func (s *SCIMService) DeleteUser(ctx context.Context, client *models.SCIMClient, id, ifMatch string) error {
+	user, err := s.getUser(ctx, id)
+	if err != nil {
+		return err
+	}
+	current, err := s.userResourceFor(ctx, client, user)
+	if err != nil {
+		return err
+	}
+	if err := checkSCIMVersion(current.Meta, ifMatch); err != nil {
+		return err
+	}
+
+	s.sessionService.TerminateUserSessions(ctx, user.ID)
+	if err := s.userRepo.Delete(ctx, user.ID); err != nil {
+		return err
+	}
+	return s.clientRepo.DeleteExternalIDs(ctx, scimResourceUser, user.ID)
+}
+
+func (s *SCIMService) getUser(ctx context.Context, id string) (*models.User, error) {
+	user, err := s.userRepo.GetByID(ctx, id)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, ErrSCIMNotFound
+	}
+	return user, err
+}
+
+func (s *SCIMService) replaceUser(ctx context.Context, client *models.SCIMClient, user *models.User, in *SCIMUser) (*SCIMUser, error) {
+	wasActive := user.IsActive
+	if err := s.applyUser(ctx, user, in); err != nil {
+		return nil, err
+	}
+	if err := s.userRepo.Update(ctx, user); err != nil {
+		return nil, err
+	}
+	if err := s.setExternalID(ctx, client, scimResourceUser, user.ID, in.ExternalID); err != nil {
+		return nil, err
+	}
+
+	// Deprovisioned users are signed out everywhere
+	if wasActive && !user.IsActive {
+		s.sessionService.TerminateUserSessions(ctx, user.ID)
+	}
+
+	return s.userResourceFor(ctx, client, user)
+}
+
+// applyUser validates a SCIM user and copies it onto user
+func (s *SCIMService) applyUser(ctx context.Context, user *models.User, in *SCIMUser) error {
+	email := strings.ToLower(strings.TrimSpace(in.UserName))
+	if address, err := mail.ParseAddress(email); err != nil || address.Address != email {
+		return fmt.Errorf("%w: userName must be an email address", ErrSCIMInvalidValue)
+	}
+	if email != user.Email {
+		if _, err := s.userRepo.GetByEmail(ctx, email); err == nil {
+			return ErrSCIMUniqueness
+		} else if !errors.Is(err, repository.ErrNotFound) {
+			return err
+		}
+		user.Email = email
+	}
+
+	user.Name = scimDisplayName(in)
+	if user.Name == "" {
+		user.Name = strings.SplitN(email, "@", 2)[0]
+	}
+
+	if in.Active != nil {
+		user.IsActive = *in.Active
+		user.DirectoryRemovedAt = nil
+	}
+
+	if in.Password != "" {
+		passwordHash, err := utils.HashPassword(in.Password)
+		if err != nil {
+			return err
+		}
+		user.PasswordHash = passwordHash
+		user.PasswordChangedAt = time.Now()
+	}
+
+	return nil
+}
+
+// scimDisplayName picks the single name users have here from a SCIM user
+func scimDisplayName(in *SCIMUser) string {
+	if name := strings.TrimSpace(in.DisplayName); name != "" {
+		return name
+	}
+	if in.Name == nil {
+		return ""
+	}
+	if name := strings.TrimSpace(in.Name.Formatted); name != "" {
+		return name
+	}
+	return strings.TrimSpace(in.Name.GivenName + " " + in.Name.FamilyName)
+}
+
+func (s *SCIMService) userResourceFor(ctx context.Context, client *models.SCIMClient, user *models.User) (*SCIMUser, error) {
+	externalIDs, err := s.clientRepo.GetExternalIDs(ctx, client.ID, scimResourceUser, []string{user.ID})
+	if err != nil {
+		return nil, err
+	}
+	return s.userResource(user, externalIDs[user.ID]), nil
+}
+
+// userResource maps a user (with roles loaded) to a SCIM user
+func (s *SCIMService) userResource(user *models.User, externalID string) *SCIMUser {
+	active := user.IsActive
+	name := &SCIMName{Formatted: user.Name}
+	if i := strings.LastIndex(user.Name, " "); i > 0 {
+		name.GivenName, name.FamilyName = user.Name[:i], user.Name[i+1:]
+	}
+
+	resource := &SCIMUser{
+		Schemas:     []string{SCIMSchemaUser},
+		ID:          user.ID,
+		ExternalID:  externalID,
+		UserName:    user.Email,
+		Name:        name,
+		DisplayName: user.Name,
+		Emails:      []SCIMValue{{Value: user.Email, Type: "work", Primary: true}},
+		Active:      &active,
+	}
+	for _, role := range user.Roles {
+		resource.Groups = append(resource.Groups, SCIMValue{
+			Value:   role.ID,
+			Display: role.Name,
+			Type:    "direct",
+			Ref:     s.baseURL + "/Groups/" + role.ID,
+		})
+	}
+
+	resource.Meta = &SCIMMeta{
+		ResourceType: scimResourceUser,
+		Created:      user.CreatedAt,
+		LastModified: user.UpdatedAt,
+		Location:     s.baseURL + "/Users/" + user.ID,
+		Version:      scimVersion(resource),
+	}
+	return resource
+}
+
+// ==================== Groups ====================
+
+// scimGroupColumns are the Group attributes filters can use
+func scimGroupColumns(clientID string) map[string]scimColumn {
+	externalID := scimColumn{
+		Expr:   "external_id",
+		In:     "id IN (SELECT resource_id FROM scim_external_ids WHERE client_id = ? AND resource_type = ? AND %s)",
+		InArgs: []interface{}{clientID, scimResourceGroup},
+	}
+	members := scimColumn{
+		Expr: "user_id",
+		In:   "id IN (SELECT role_id FROM user_roles WHERE %s)",
+	}
+	return map[string]scimColumn{
+		"id":                {Expr: "id"},
+		"displayname":       {Expr: "name"},
+		"externalid":        externalID,
+		"members":           members,
+		"members.value":     members,
+		"meta.created":      {Expr: "created_at", Kind: scimDateTime},
+		"meta.lastmodified": {Expr: "updated_at", Kind: scimDateTime},
+	}
+}
+
+// ListGroups returns a page of the groups matching the query's filter
+func (s *SCIMService) ListGroups(ctx context.Context, client *models.SCIMClient, query SCIMQuery) (*SCIMListResponse, error) {
+	condition, args, err := s.compileFilter(query.Filter, scimGroupColumns(client.ID))
+	if err != nil {
+		return nil, err
+	}
+
+	offset, limit := query.page()
+	roles, total, err := s.roleRepo.ListWhere(ctx, condition, args, offset, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, len(roles))
+	for i, role := range roles {
+		ids[i] = role.ID
+	}
+	externalIDs, err := s.clientRepo.GetExternalIDs(ctx, client.ID, scimResourceGroup, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	resources := make([]*SCIMGroup, len(roles))
+	for i, role := range roles {
+		members, err := s.roleRepo.GetMembers(ctx, role.ID)
+		if err != nil {
+			return nil, err
+		}
+		resources[i] = s.groupResource(role, members, externalIDs[role.ID])
+		if query.excludes("members") {
+			resources[i].Members = nil
+		}
+	}
+
+	return &SCIMListResponse{
+		Schemas:      []string{SCIMSchemaListResponse},
+		TotalResults: total,
+		StartIndex:   offset + 1,
+		ItemsPerPage: len(resources),
+		Resources:    resources,
+	}, nil
+}
+
+// GetGroup returns a group
+func (s *SCIMService) GetGroup(ctx context.Context, client *models.SCIMClient, id string) (*SCIMGroup, error) {
+	role, err := s.getRole(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return s.groupResourceFor(ctx, client, role)
+}
+
+// CreateGroup creates a role with the group's members
+func (s *SCIMService) CreateGroup(ctx context.Context, client *models.SCIMClient, in *SCIMGroup) (*SCIMGroup, error) {
+	name := strings.TrimSpace(in.DisplayName)
+	if name == "" {
+		return nil, fmt.Errorf("%w: displayName is required", ErrSCIMInvalidValue)
+	}
+	if _, err := s.roleRepo.GetByName(ctx, name); err == nil {
+		return nil, ErrSCIMUniqueness
+	} else if !errors.Is(err, repository.ErrNotFound) {
+		return nil, err
+	}
+	memberIDs, err := s.memberIDs(ctx, in.Members)
+	if err != nil {
+		return nil, err
+	}
+
+	role := &models.Role{
+		ID:          uuid.New().String(),
+		Name:        name,
+		Description: "Provisioned by " + client.Name,
+	}
+	if err := s.roleRepo.Create(ctx, role); err != nil {
+		return nil, err
+	}
+	if err := s.setExternalID(ctx, client, scimResourceGroup, role.ID, in.ExternalID); err != nil {
+		return nil, err
+	}
+	if err := s.syncMembers(ctx, role, nil, memberIDs); err != nil {
+		return nil, err
+	}
+
+	return s.groupResourceFor(ctx, client, role)
+}
+
+// ReplaceGroup replaces a group's name and members (PUT)
+func (s *SCIMService) ReplaceGroup(ctx context.Context, client *models.SCIMClient, id string, in *SCIMGroup, ifMatch string) (*SCIMGroup, error) {
+	role, err := s.getRole(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	current, err := s.groupResourceFor(ctx, client, role)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkSCIMVersion(current.Meta, ifMatch); err != nil {
+		return nil, err
+	}
+
+	return s.replaceGroup(ctx, client, role, current, in)
+}
+
+// PatchGroup applies PATCH operations to a group
+func (s *SCIMService) PatchGroup(ctx context.Context, client *models.SCIMClient, id string, patch *SCIMPatchRequest, ifMatch string) (*SCIMGroup, error) {
+	role, err := s.getRole(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	current, err := s.groupResourceFor(ctx, client, role)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkSCIMVersion(current.Meta, ifMatch); err != nil {
+		return nil, err
+	}
+
+	var patched SCIMGroup
+	if err := applySCIMPatch(current, patch, &patched); err != nil {
+		return nil, err
+	}
+	return s.replaceGroup(ctx, client, role, current, &patched)
+}
+
+// DeleteGroup deletes a role; its members lose it
+func (s *SCIMService) DeleteGroup(ctx context.Context, client *models.SCIMClient, id, ifMatch string) error {
+	role, err := s.getRole(ctx, id)
+	if err != nil {
+		return err
+	}
+	current, err := s.groupResourceFor(ctx, client, role)
+	if err != nil {
+		return err
+	}
+	if err := checkSCIMVersion(current.Meta, ifMatch); err != nil {
+		return err
+	}
+
+	for _, member := range current.Members {
+		if err := s.userRepo.RemoveRole(ctx, member.Value, role.ID); err != nil {
+			return err
+		}
+	}
+	if err := s.roleRepo.Delete(ctx, role.ID); err != nil {
+		return err
+	}
+	return s.clientRepo.DeleteExternalIDs(ctx, scimResourceGroup, role.ID)
+}
+
+func (s *SCIMService) getRole(ctx context.Context, id string) (*models.Role, error) {
+	role, err := s.roleRepo.GetByID(ctx, id)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, ErrSCIMNotFound
+	}
+	return role, err
+}
+
+func (s *SCIMService) replaceGroup(ctx context.Context, client *models.SCIMClient, role *models.Role, current, in *SCIMGroup) (*SCIMGroup, error) {
+	name := strings.TrimSpace(in.DisplayName)
+	if name == "" {
+		return nil, fmt.Errorf("%w: displayName is required", ErrSCIMInvalidValue)
+	}
+	memberIDs, err := s.memberIDs(ctx, in.Members)
+	if err != nil {
+		return nil, err
+	}
+
+	if name != role.Name {
+		if _, err := s.roleRepo.GetByName(ctx, name); err == nil {
+			return nil, ErrSCIMUniqueness
+		} else if !errors.Is(err, repository.ErrNotFound) {
+			return nil, err
+		}
+		role.Name = name
+		role.Permissions = nil
+		if err := s.roleRepo.Update(ctx, role); err != nil {
+			return nil, err
+		}
+	}
+	if err := s.setExternalID(ctx, client, scimResourceGroup, role.ID, in.ExternalID); err != nil {
+		return nil, err
+	}
+
+	currentIDs := make([]string, len(current.Members))
+	for i, member := range current.Members {
+		currentIDs[i] = member.Value
+	}
+	if err := s.syncMembers(ctx, role, currentIDs, memberIDs); err != nil {
+		return nil, err
+	}
+
+	return s.groupResourceFor(ctx, client, role)
+}
+
+// memberIDs checks that every member is an existing user
+func (s *SCIMService) memberIDs(ctx context.Context, members []SCIMValue) ([]string, error) {
+	ids := make([]string, 0, len(members))
+	for _, member := range members {
+		if slices.Contains(ids, member.Value) {
+			continue
+		}
+		if member.Type != "" && member.Type != scimResourceUser {
+			return nil, fmt.Errorf("%w: only users can be group members", ErrSCIMInvalidValue)
+		}
+		if _, err := s.userRepo.GetByID(ctx, member.Value); err != nil {
+			if errors.Is(err, repository.ErrNotFound) {
+				return nil, fmt.Errorf("%w: unknown member %q", ErrSCIMInvalidValue, member.Value)
+			}
+			return nil, err
+		}
+		ids = append(ids, member.Value)
+	}
+	return ids, nil
+}
+
+// syncMembers assigns the role to users who joined and removes it from those who left
+func (s *SCIMService) syncMembers(ctx context.Context, role *models.Role, current, wanted []string) error {
+	for _, userID := range wanted {
+		if !slices.Contains(current, userID) {
+			if err := s.userRepo.AssignRole(ctx, userID, role.ID); err != nil {
+				return err
+			}
+		}
+	}
+	for _, userID := range current {
+		if !slices.Contains(wanted, userID) {
+			if err := s.userRepo.RemoveRole(ctx, userID, role.ID); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *SCIMService) groupResourceFor(ctx context.Context, client *models.SCIMClient, role *models.Role) (*SCIMGroup, error) {
+	members, err := s.roleRepo.GetMembers(ctx, role.ID)
+	if err != nil {
+		return nil, err
+	}
+	externalIDs, err := s.clientRepo.GetExternalIDs(ctx, client.ID, scimResourceGroup, []string{role.ID})
+	if err != nil {
+		return nil, err
+	}
+	return s.groupResource(role, members, externalIDs[role.ID]), nil
+}
+
+// groupResource maps a role and its members to a SCIM group
+func (s *SCIMService) groupResource(role *models.Role, members []*models.User, externalID string) *SCIMGroup {
+	resource := &SCIMGroup{
+		Schemas:     []string{SCIMSchemaGroup},
+		ID:          role.ID,
+		ExternalID:  externalID,
+		DisplayName: role.Name,
+		Members:     make([]SCIMValue, len(members)),
+	}
+	for i, member := range members {
+		resource.Members[i] = SCIMValue{
+			Value:   member.ID,
+			Display: member.Email,
+			Type:    scimResourceUser,
+			Ref:     s.baseURL + "/Users/" + member.ID,
+		}
+	}
+
+	resource.Meta = &SCIMMeta{
+		ResourceType: scimResourceGroup,
+		Created:      role.CreatedAt,
+		LastModified: role.UpdatedAt,
+		Location:     s.baseURL + "/Groups/" + role.ID,
+		Version:      scimVersion(resource),
+	}
+	return resource
+}
+
+// ==================== Helpers ====================
+
+func (s *SCIMService) compileFilter(filter string, columns map[string]scimColumn) (string, []interface{}, error) {
+	if strings.TrimSpace(filter) == "" {
+		return "", nil, nil
+	}
+	parsed, err := parseSCIMFilter(filter)
+	if err != nil {
+		return "", nil, err
+	}
+	return parsed.sql(columns)
+}
+
+func (s *SCIMService) setExternalID(ctx context.Context, client *models.SCIMClient, resourceType, resourceID, externalID string) error {
+	err := s.clientRepo.SetExternalID(ctx, client.ID, resourceType, resourceID, strings.TrimSpace(externalID))
+	if errors.Is(err, repository.ErrSCIMExternalIDTaken) {
+		return fmt.Errorf("%w: %v", ErrSCIMUniqueness, err)
+	}
+	return err
+}
+
+// page converts startIndex and count to an offset and limit
+func (q SCIMQuery) page() (int, int) {
+	offset := q.StartIndex - 1
+	if offset < 0 {
+		offset = 0
+	}
+	limit := q.Count
+	if limit < 0 || limit > SCIMMaxResults {
+		limit = SCIMMaxResults
+	}
+	return offset, limit
+}
+
+func (q SCIMQuery) excludes(attr string) bool {
+	return slices.ContainsFunc(q.ExcludedAttributes, func(excluded string) bool {
+		return normalizeSCIMAttr(strings.TrimSpace(excluded)) == attr
+	})
+}
+
+// scimVersion is a weak ETag derived from the resource's content
+func scimVersion(resource interface{}) string {
+	data, err := json.Marshal(resource)
+	if err != nil {
+		log.Printf("Failed to compute SCIM version: %v", err)
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return `W/"` + hex.EncodeToString(sum[:8]) + `"`
+}
+
+// checkSCIMVersion compares an If-Match header with the resource version
+func checkSCIMVersion(meta *SCIMMeta, ifMatch string) error {
+	if ifMatch == "" || ifMatch == "*" {
+		return nil
+	}
+	for _, tag := range strings.Split(ifMatch, ",") {
+		if strings.TrimSpace(tag) == meta.Version {
+			return nil
+		}
+	}
+	return ErrSCIMPreconditionFailed
+}
@@ -0,0 +1,347 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sso-project/sso-server/internal/database"
+	"github.com/sso-project/sso-server/internal/models"
+	"github.com/sso-project/sso-server/internal/repository"
+	"github.com/sso-project/sso-server/internal/testutil"
+)
+
+func newTestSCIMService(t *testing.T, db *database.DB) (*SCIMService, *models.SCIMClient) {
+	t.Helper()
+	scimService := NewSCIMService(
+		repository.NewSCIMClientRepository(db.DB),
+		repository.NewUserRepository(db),
+		repository.NewRoleRepository(db),
+		NewSessionService(repository.NewDatabaseSessionStore(db), 24*time.Hour),
+		"https://sso.example.com",
+	)
+	client, _, err := scimService.CreateClient(context.Background(), SCIMClientRequest{Name: "HR"})
+	require.NoError(t, err)
+	return scimService, client
+}
+
+func scimPatch(t *testing.T, operations string) *SCIMPatchRequest {
+	t.Helper()
+	var patch SCIMPatchRequest
+	require.NoError(t, json.Unmarshal([]byte(`{"schemas":["`+SCIMSchemaPatchOp+`"],"Operations":`+operations+`}`), &patch))
+	return &patch
+}
+
+func TestSCIMService_Authenticate(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+	defer testutil.TeardownTestDB(t, db)
+
+	scimService, _ := newTestSCIMService(t, db)
+	ctx := context.Background()
+
+	client, token, err := scimService.CreateClient(ctx, SCIMClientRequest{Name: "Okta"})
+	require.NoError(t, err)
+	assert.NotEqual(t, token, client.TokenHash)
+	assert.Equal(t, token[:len(client.TokenPrefix)], client.TokenPrefix)
+
+	authenticated, err := scimService.Authenticate(ctx, token)
+	require.NoError(t, err)
+	assert.Equal(t, client.ID, authenticated.ID)
+
+	_, err = scimService.Authenticate(ctx, "scim_unknown")
+	assert.ErrorIs(t, err, ErrSCIMInvalidToken)
+
+	// Rotating replaces the token
+	_, rotated, err := scimService.RotateToken(ctx, client.ID)
+	require.NoError(t, err)
+	_, err = scimService.Authenticate(ctx, token)
+	assert.ErrorIs(t, err, ErrSCIMInvalidToken)
+	_, err = scimService.Authenticate(ctx, rotated)
+	assert.NoError(t, err)
+
+	// Disabled and expired clients are rejected
+	inactive := false
+	_, err = scimService.UpdateClient(ctx, client.ID, SCIMClientRequest{Name: "Okta", IsActive: &inactive})
+	require.NoError(t, err)
+	_, err = scimService.Authenticate(ctx, rotated)
+	assert.ErrorIs(t, err, ErrSCIMInvalidToken)
+
+	expired := time.Now().Add(-time.Minute)
+	_, err = scimService.UpdateClient(ctx, client.ID, SCIMClientRequest{Name: "Okta", ExpiresAt: &expired})
+	require.NoError(t, err)
+	_, err = scimService.Authenticate(ctx, rotated)
+	assert.ErrorIs(t, err, ErrSCIMInvalidToken)
+}
+
+func TestSCIMService_Users(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+	defer testutil.TeardownTestDB(t, db)
+
+	scimService, client := newTestSCIMService(t, db)
+	ctx := context.Background()
+
+	active := true
+	user, err := scimService.CreateUser(ctx, client, &SCIMUser{
+		Schemas:    []string{SCIMSchemaUser},
+		ExternalID: "hr-1001",
+		UserName:   "Jane.Doe@Example.com",
+		Name:       &SCIMName{GivenName: "Jane", FamilyName: "Doe"},
+		Active:     &active,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "jane.doe@example.com", user.UserName)
+	assert.Equal(t, "Jane Doe", user.DisplayName)
+	assert.Equal(t, "hr-1001", user.ExternalID)
+	assert.Equal(t, "https://sso.example.com/scim/v2/Users/"+user.ID, user.Meta.Location)
+	assert.NotEmpty(t, user.Meta.Version)
+
+	_, err = scimService.CreateUser(ctx, client, &SCIMUser{UserName: "jane.doe@example.com"})
+	assert.ErrorIs(t, err, ErrSCIMUniqueness)
+	_, err = scimService.CreateUser(ctx, client, &SCIMUser{UserName: "not-an-email"})
+	assert.ErrorIs(t, err, ErrSCIMInvalidValue)
+	_, err = scimService.CreateUser(ctx, client, &SCIMUser{UserName: "other@example.com", ExternalID: "hr-1001"})
+	assert.ErrorIs(t, err, ErrSCIMUniqueness)
+
+	// externalId belongs to the client that set it
+	other, _, err := scimService.CreateClient(ctx, SCIMClientRequest{Name: "IGA"})
+	require.NoError(t, err)
+	seen, err := scimService.GetUser(ctx, other, user.ID)
+	require.NoError(t, err)
+	assert.Empty(t, seen.ExternalID)
+
+	// Replace with a stale version fails
+	stale := user.Meta.Version
+	user.DisplayName = "Jane Q. Doe"
+	user.Name = nil
+	replaced, err := scimService.ReplaceUser(ctx, client, user.ID, user, stale)
+	require.NoError(t, err)
+	assert.Equal(t, "Jane Q. Doe", replaced.DisplayName)
+	assert.NotEqual(t, stale, replaced.Meta.Version)
+	_, err = scimService.ReplaceUser(ctx, client, user.ID, replaced, stale)
+	assert.ErrorIs(t, err, ErrSCIMPreconditionFailed)
+
+	// Deactivating ends the user's sessions
+	session := testutil.CreateTestSession(t, db, user.ID)
+	patched, err := scimService.PatchUser(ctx, client, user.ID, scimPatch(t, `[{"op":"Replace","path":"active","value":"False"}]`), "")
+	require.NoError(t, err)
+	assert.False(t, *patched.Active)
+	sessions, err := repository.NewDatabaseSessionStore(db).GetByUserID(ctx, session.UserID)
+	require.NoError(t, err)
+	assert.Empty(t, sessions)
+
+	require.NoError(t, scimService.DeleteUser(ctx, client, user.ID, ""))
+	_, err = scimService.GetUser(ctx, client, user.ID)
+	assert.ErrorIs(t, err, ErrSCIMNotFound)
+}
+
+func TestSCIMService_ListUsers(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+	defer testutil.TeardownTestDB(t, db)
+
+	scimService, client := newTestSCIMService(t, db)
+	ctx := context.Background()
+
+	for i, email := range []string{"alice@example.com", "bob@example.com", "carol@corp.example"} {
+		_, err := scimService.CreateUser(ctx, client, &SCIMUser{UserName: email, ExternalID: string(rune('a' + i))})
+		require.NoError(t, err)
+	}
+	role := testutil.CreateTestRole(t, db, "engineering")
+	bob, err := repository.NewUserRepository(db).GetByEmail(ctx, "bob@example.com")
+	require.NoError(t, err)
+	require.NoError(t, db.DB.Model(bob).Association("Roles").Append(role))
+
+	tests := []struct {
+		filter string
+		want   []string
+	}{
+		{`userName eq "ALICE@example.com"`, []string{"alice@example.com"}},
+		{`userName ew "example.com" and not (userName sw "a")`, []string{"bob@example.com"}},
+		{`emails[value co "corp"]`, []string{"carol@corp.example"}},
+		{`externalId eq "c" or externalId eq "a"`, []string{"alice@example.com", "carol@corp.example"}},
+		{`groups.value eq "` + role.ID + `"`, []string{"bob@example.com"}},
+		{`active eq true and meta.created gt "2000-01-01T00:00:00Z"`, []string{"alice@example.com", "bob@example.com", "carol@corp.example"}},
+		{`externalId pr`, []string{"alice@example.com", "bob@example.com", "carol@corp.example"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.filter, func(t *testing.T) {
+			list, err := scimService.ListUsers(ctx, client, SCIMQuery{Filter: tt.filter, StartIndex: 1, Count: -1})
+			require.NoError(t, err)
+			var got []string
+			for _, user := range list.Resources.([]*SCIMUser) {
+				got = append(got, user.UserName)
+			}
+			assert.ElementsMatch(t, tt.want, got)
+			assert.EqualValues(t, len(tt.want), list.TotalResults)
+		})
+	}
+
+	_, err = scimService.ListUsers(ctx, client, SCIMQuery{Filter: `userName eq`, StartIndex: 1, Count: -1})
+	assert.ErrorIs(t, err, ErrSCIMInvalidFilter)
+	_, err = scimService.ListUsers(ctx, client, SCIMQuery{Filter: `nickName eq "x"`, StartIndex: 1, Count: -1})
+	assert.ErrorIs(t, err, ErrSCIMInvalidFilter)
+
+	// Pages are 1-based; count=0 only returns the total
+	list, err := scimService.ListUsers(ctx, client, SCIMQuery{StartIndex: 2, Count: 1})
+	require.NoError(t, err)
+	assert.EqualValues(t, 3, list.TotalResults)
+	assert.Equal(t, 2, list.StartIndex)
+	assert.Equal(t, 1, list.ItemsPerPage)
+	assert.Equal(t, "bob@example.com", list.Resources.([]*SCIMUser)[0].UserName)
+
+	list, err = scimService.ListUsers(ctx, client, SCIMQuery{StartIndex: 1, Count: 0})
+	require.NoError(t, err)
+	assert.EqualValues(t, 3, list.TotalResults)
+	assert.Empty(t, list.Resources)
+}
+
+func TestSCIMService_Groups(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+	defer testutil.TeardownTestDB(t, db)
+
+	scimService, client := newTestSCIMService(t, db)
+	ctx := context.Background()
+
+	alice, err := scimService.CreateUser(ctx, client, &SCIMUser{UserName: "alice@example.com"})
+	require.NoError(t, err)
+	bob, err := scimService.CreateUser(ctx, client, &SCIMUser{UserName: "bob@example.com"})
+	require.NoError(t, err)
+
+	group, err := scimService.CreateGroup(ctx, client, &SCIMGroup{
+		DisplayName: "Engineering",
+		ExternalID:  "eng",
+		Members:     []SCIMValue{{Value: alice.ID}},
+	})
+	require.NoError(t, err)
+	require.Len(t, group.Members, 1)
+	assert.Equal(t, "alice@example.com", group.Members[0].Display)
+
+	_, err = scimService.CreateGroup(ctx, client, &SCIMGroup{DisplayName: "Engineering"})
+	assert.ErrorIs(t, err, ErrSCIMUniqueness)
+	_, err = scimService.CreateGroup(ctx, client, &SCIMGroup{DisplayName: "Ghosts", Members: []SCIMValue{{Value: "missing"}}})
+	assert.ErrorIs(t, err, ErrSCIMInvalidValue)
+
+	// Users show the groups they are in
+	user, err := scimService.GetUser(ctx, client, alice.ID)
+	require.NoError(t, err)
+	require.Len(t, user.Groups, 1)
+	assert.Equal(t, group.ID, user.Groups[0].Value)
+
+	// Okta style: add members by path
+	group, err = scimService.PatchGroup(ctx, client, group.ID, scimPatch(t, `[{"op":"add","path":"members","value":[{"value":"`+bob.ID+`"},{"value":"`+alice.ID+`"}]}]`), group.Meta.Version)
+	require.NoError(t, err)
+	assert.Len(t, group.Members, 2)
+
+	// Filtered remove
+	group, err = scimService.PatchGroup(ctx, client, group.ID, scimPatch(t, `[{"op":"remove","path":"members[value eq \"`+alice.ID+`\"]"}]`), "")
+	require.NoError(t, err)
+	require.Len(t, group.Members, 1)
+	assert.Equal(t, bob.ID, group.Members[0].Value)
+
+	// Azure style: remove with a value list, and rename without a path
+	group, err = scimService.PatchGroup(ctx, client, group.ID, scimPatch(t, `[
+		{"op":"Remove","path":"members","value":[{"value":"`+bob.ID+`"}]},
+		{"op":"Replace","value":{"displayName":"Platform","externalId":"platform"}}
+	]`), "")
+	require.NoError(t, err)
+	assert.Empty(t, group.Members)
+	assert.Equal(t, "Platform", group.DisplayName)
+	assert.Equal(t, "platform", group.ExternalID)
+
+	list, err := scimService.ListGroups(ctx, client, SCIMQuery{Filter: `displayName eq "platform"`, StartIndex: 1, Count: -1})
+	require.NoError(t, err)
+	assert.EqualValues(t, 1, list.TotalResults)
+
+	_, err = scimService.PatchGroup(ctx, client, group.ID, scimPatch(t, `[{"op":"remove"}]`), "")
+	assert.ErrorIs(t, err, ErrSCIMNoTarget)
+	_, err = scimService.PatchGroup(ctx, client, group.ID, &SCIMPatchRequest{}, "")
+	assert.ErrorIs(t, err, ErrSCIMInvalidPatch)
+
+	require.NoError(t, scimService.DeleteGroup(ctx, client, group.ID, ""))
+	_, err = scimService.GetGroup(ctx, client, group.ID)
+	assert.ErrorIs(t, err, ErrSCIMNotFound)
+}
+
+func TestApplySCIMPatch(t *testing.T) {
+	current := &SCIMUser{
+		Schemas:  []string{SCIMSchemaUser},
+		ID:       "1",
+		UserName: "jane@example.com",
+		Name:     &SCIMName{Formatted: "Jane Doe", GivenName: "Jane", FamilyName: "Doe"},
+		Emails:   []SCIMValue{{Value: "jane@example.com", Type: "work", Primary: true}},
+		Meta:     &SCIMMeta{Version: `W/"1"`},
+	}
+
+	tests := []struct {
+		name       string
+		operations string
+		check      func(t *testing.T, user *SCIMUser)
+		err        error
+	}{
+		{
+			name:       "sub-attribute",
+			operations: `[{"op":"replace","path":"name.familyName","value":"Smith"}]`,
+			check: func(t *testing.T, user *SCIMUser) {
+				assert.Equal(t, "Smith", user.Name.FamilyName)
+				assert.Equal(t, "Jane", user.Name.GivenName)
+			},
+		},
+		{
+			name:       "filtered sub-attribute",
+			operations: `[{"op":"replace","path":"emails[type eq \"work\"].value","value":"jane.smith@example.com"}]`,
+			check: func(t *testing.T, user *SCIMUser) {
+				require.Len(t, user.Emails, 1)
+				assert.Equal(t, "jane.smith@example.com", user.Emails[0].Value)
+			},
+		},
+		{
+			name:       "no path with schema-qualified keys",
+			operations: `[{"op":"add","value":{"urn:ietf:params:scim:schemas:core:2.0:User:displayName":"JD","name.givenName":"J","urn:ietf:params:scim:schemas:extension:enterprise:2.0:User:department":"R&D"}}]`,
+			check: func(t *testing.T, user *SCIMUser) {
+				assert.Equal(t, "JD", user.DisplayName)
+				assert.Equal(t, "J", user.Name.GivenName)
+			},
+		},
+		{
+			name:       "remove attribute",
+			operations: `[{"op":"remove","path":"name"}]`,
+			check: func(t *testing.T, user *SCIMUser) {
+				assert.Nil(t, user.Name)
+				assert.Nil(t, user.Meta)
+			},
+		},
+		{
+			name:       "filter matches nothing",
+			operations: `[{"op":"replace","path":"emails[type eq \"home\"].value","value":"x@example.com"}]`,
+			err:        ErrSCIMNoTarget,
+		},
+		{
+			name:       "bad path",
+			operations: `[{"op":"replace","path":"emails[type eq","value":"x"}]`,
+			err:        ErrSCIMInvalidPath,
+		},
+		{
+			name:       "unknown op",
+			operations: `[{"op":"move","path":"name"}]`,
+			err:        ErrSCIMInvalidPatch,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var patched SCIMUser
+			err := applySCIMPatch(current, scimPatch(t, tt.operations), &patched)
+			if tt.err != nil {
+				assert.ErrorIs(t, err, tt.err)
+				return
+			}
+			require.NoError(t, err)
+			tt.check(t, &patched)
+		})
+	}
+
+	// The current resource is left untouched
+	assert.Equal(t, "Doe", current.Name.FamilyName)
+}
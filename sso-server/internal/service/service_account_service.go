@@ -0,0 +1,132 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"slices"
+
+	"github.com/google/uuid"
+	"github.com/sso-project/sso-server/internal/models"
+	"github.com/sso-project/sso-server/internal/repository"
+)
+
+var (
+	ErrServiceAccountExists     = errors.New("client already has a service account")
+	ErrClientCredentialsMissing = errors.New("client must allow the client_credentials grant")
+)
+
+// ServiceAccountService manages service accounts and their roles
+type ServiceAccountService struct {
+	accountRepo *repository.ServiceAccountRepository
+	clientRepo  *repository.OAuth2ClientRepository
+	roleRepo    *repository.RoleRepository
+}
+
+// NewServiceAccountService creates a new ServiceAccountService
+func NewServiceAccountService(
+	accountRepo *repository.ServiceAccountRepository,
+	clientRepo *repository.OAuth2ClientRepository,
+	roleRepo *repository.RoleRepository,
+) *ServiceAccountService {
+	return &ServiceAccountService{
+		accountRepo: accountRepo,
+		clientRepo:  clientRepo,
+		roleRepo:    roleRepo,
+	}
+}
+
+// CreateServiceAccount creates the service account for a confidential client
+func (s *ServiceAccountService) CreateServiceAccount(ctx context.Context, clientID, name, description string) (*models.ServiceAccount, error) {
+	client, err := s.clientRepo.GetByClientID(ctx, clientID)
+	if err != nil {
+		return nil, err
+	}
+
+	if client.IsPublic || !slices.Contains(client.GrantTypes, "client_credentials") {
+		return nil, ErrClientCredentialsMissing
+	}
+
+	if _, err := s.accountRepo.GetByClientID(ctx, clientID); err == nil {
+		return nil, ErrServiceAccountExists
+	} else if !errors.Is(err, repository.ErrServiceAccountNotFound) {
+		return nil, err
+	}
+
+	if name == "" {
+		name = client.Name
+	}
+
+	account := &models.ServiceAccount{
+		ID:          uuid.New().String(),
+		ClientID:    clientID,
+		Name:        name,
+		Description: description,
+		IsActive:    true,
+	}
+
+	if err := s.accountRepo.Create(ctx, account); err != nil {
+		return nil, err
+	}
+
+	return account, nil
+}
+
+// GetServiceAccount retrieves a service account by ID
+func (s *ServiceAccountService) GetServiceAccount(ctx context.Context, id string) (*models.ServiceAccount, error) {
+	return s.accountRepo.GetByID(ctx, id)
+}
+
+// ListServiceAccounts retrieves service accounts with pagination
+func (s *ServiceAccountService) ListServiceAccounts(ctx context.Context, page, limit int) ([]*models.ServiceAccount, int64, error) {
+	offset := (page - 1) * limit
+	return s.accountRepo.List(ctx, offset, limit)
+}
+
+// SetActive enables or disables a service account; disabled accounts get tokens without roles
+func (s *ServiceAccountService) SetActive(ctx context.Context, id string, active bool) (*models.ServiceAccount, error) {
+	account, err := s.accountRepo.GetByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	account.IsActive = active
+	if err := s.accountRepo.Update(ctx, account); err != nil {
+		return nil, err
+	}
+
+	return account, nil
+}
+
+// DeleteServiceAccount removes a service account
+func (s *ServiceAccountService) DeleteServiceAccount(ctx context.Context, id string) error {
+	return s.accountRepo.Delete(ctx, id)
+}
+
+// AssignRole grants a role to a service account
+func (s *ServiceAccountService) AssignRole(ctx context.Context, id, roleID string) (*models.ServiceAccount, error) {
+	if _, err := s.accountRepo.GetByID(ctx, id); err != nil {
+		return nil, err
+	}
+	if _, err := s.roleRepo.GetByID(ctx, roleID); err != nil {
+		return nil, err
+	}
+
+	if err := s.accountRepo.AssignRole(ctx, id, roleID); err != nil {
+		return nil, err
+	}
+
+	return s.accountRepo.GetByID(ctx, id)
+}
+
+// RemoveRole takes a role away from a service account
+func (s *ServiceAccountService) RemoveRole(ctx context.Context, id, roleID string) (*models.ServiceAccount, error) {
+	if _, err := s.accountRepo.GetByID(ctx, id); err != nil {
+		return nil, err
+	}
+
+	if err := s.accountRepo.RemoveRole(ctx, id, roleID); err != nil {
+		return nil, err
+	}
+
+	return s.accountRepo.GetByID(ctx, id)
+}
@@ -0,0 +1,109 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sso-project/sso-server/internal/repository"
+	"github.com/sso-project/sso-server/internal/testutil"
+)
+
+func TestServiceAccountService_ClientCredentialsCarryRoles(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+	defer testutil.TeardownTestDB(t, db)
+
+	jwtService, err := NewJWTService("test-issuer", 15*time.Minute, time.Hour)
+	require.NoError(t, err)
+
+	clientRepo := repository.NewOAuth2ClientRepository(db.DB)
+	accountRepo := repository.NewServiceAccountRepository(db.DB)
+	subjectService := NewOAuth2SubjectService(repository.NewOAuth2SubjectRepository(db.DB), clientRepo, "test-pairwise-secret")
+	tokenService := NewOAuth2TokenService(repository.NewOAuth2TokenRepository(db.DB), clientRepo, jwtService, subjectService, 15*time.Minute, time.Hour)
+	authzService := NewOAuth2AuthorizationService(
+		repository.NewOAuth2CodeRepository(db.DB),
+		clientRepo,
+		repository.NewOAuth2ConsentRepository(db.DB),
+		accountRepo,
+		tokenService,
+		10*time.Minute,
+		true,
+	)
+	accountService := NewServiceAccountService(accountRepo, clientRepo, repository.NewRoleRepository(db))
+	ctx := context.Background()
+
+	client := testutil.CreateTestOAuth2ClientWithScopes(t, db, "billing-worker", []string{})
+	role := testutil.CreateTestRole(t, db, "billing")
+	permission := testutil.CreateTestPermission(t, db, "invoices:write")
+	require.NoError(t, db.DB.Model(role).Association("Permissions").Append(permission))
+
+	// Without a service account the token carries no roles
+	resp, err := authzService.ClientCredentialsGrant(ctx, client.ClientID, nil)
+	require.NoError(t, err)
+	claims, err := jwtService.ValidateToken(resp.AccessToken)
+	require.NoError(t, err)
+	assert.Empty(t, claims.Roles)
+
+	account, err := accountService.CreateServiceAccount(ctx, client.ClientID, "", "Nightly billing run")
+	require.NoError(t, err)
+	assert.Equal(t, client.Name, account.Name)
+
+	_, err = accountService.CreateServiceAccount(ctx, client.ClientID, "Again", "")
+	assert.ErrorIs(t, err, ErrServiceAccountExists)
+
+	account, err = accountService.AssignRole(ctx, account.ID, role.ID)
+	require.NoError(t, err)
+	require.Len(t, account.Roles, 1)
+
+	// Assigning twice is harmless
+	_, err = accountService.AssignRole(ctx, account.ID, role.ID)
+	require.NoError(t, err)
+
+	_, err = accountService.AssignRole(ctx, account.ID, "missing-role")
+	assert.ErrorIs(t, err, repository.ErrNotFound)
+
+	resp, err = authzService.ClientCredentialsGrant(ctx, client.ClientID, nil)
+	require.NoError(t, err)
+	claims, err = jwtService.ValidateToken(resp.AccessToken)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"billing"}, claims.Roles)
+	assert.Equal(t, []string{"invoices:write"}, claims.Permissions)
+
+	// Disabled accounts fall back to plain client tokens
+	_, err = accountService.SetActive(ctx, account.ID, false)
+	require.NoError(t, err)
+	resp, err = authzService.ClientCredentialsGrant(ctx, client.ClientID, nil)
+	require.NoError(t, err)
+	claims, err = jwtService.ValidateToken(resp.AccessToken)
+	require.NoError(t, err)
+	assert.Empty(t, claims.Roles)
+
+	account, err = accountService.RemoveRole(ctx, account.ID, role.ID)
+	require.NoError(t, err)
+	assert.Empty(t, account.Roles)
+
+	require.NoError(t, accountService.DeleteServiceAccount(ctx, account.ID))
+	_, err = accountService.GetServiceAccount(ctx, account.ID)
+	assert.ErrorIs(t, err, repository.ErrServiceAccountNotFound)
+}
+
+func TestServiceAccountService_RequiresClientCredentials(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+	defer testutil.TeardownTestDB(t, db)
+
+	clientRepo := repository.NewOAuth2ClientRepository(db.DB)
+	accountService := NewServiceAccountService(repository.NewServiceAccountRepository(db.DB), clientRepo, repository.NewRoleRepository(db))
+	ctx := context.Background()
+
+	client := testutil.CreateTestOAuth2ClientWithScopes(t, db, "browser-app", []string{})
+	require.NoError(t, db.DB.Model(client).Update("is_public", true).Error)
+
+	_, err := accountService.CreateServiceAccount(ctx, client.ClientID, "", "")
+	assert.ErrorIs(t, err, ErrClientCredentialsMissing)
+
+	_, err = accountService.CreateServiceAccount(ctx, "missing", "", "")
+	assert.ErrorIs(t, err, repository.ErrClientNotFound)
+}
@@ -2,6 +2,7 @@ package service
 
 import (
 	"context"
+	"errors"
 	"time"
 
 	"github.com/google/uuid"
@@ -10,6 +11,9 @@ import (
 	"github.com/sso-project/sso-server/internal/utils"
 )
 
+// ErrSessionPending is returned for a session whose second factor is not verified yet
+var ErrSessionPending = errors.New("session is waiting for a second factor")
+
 // SessionService handles session operations
 type SessionService struct {
 	sessionRepo repository.SessionStore
@@ -26,6 +30,16 @@ func NewSessionService(sessionRepo repository.SessionStore, timeout time.Duratio
 
 // CreateSession creates a new session for a user
 func (s *SessionService) CreateSession(ctx context.Context, userID, ipAddress, userAgent string) (*models.Session, error) {
+	return s.createSession(ctx, userID, ipAddress, userAgent, s.timeout, false)
+}
+
+// CreatePendingSession creates the temporary session of a user who still has to
+// verify a second factor. It expires after ttl and is refused by ValidateSession.
+func (s *SessionService) CreatePendingSession(ctx context.Context, userID, ipAddress, userAgent string, ttl time.Duration) (*models.Session, error) {
+	return s.createSession(ctx, userID, ipAddress, userAgent, ttl, true)
+}
+
+func (s *SessionService) createSession(ctx context.Context, userID, ipAddress, userAgent string, ttl time.Duration, pending bool) (*models.Session, error) {
 	// Generate secure session token
 	sessionToken, err := utils.GenerateRandomToken(64)
 	if err != nil {
@@ -33,13 +47,15 @@ func (s *SessionService) CreateSession(ctx context.Context, userID, ipAddress, u
 	}
 
 	session := &models.Session{
-		ID:             uuid.New().String(),
-		UserID:         userID,
-		SessionToken:   sessionToken,
-		IPAddress:      ipAddress,
-		UserAgent:      userAgent,
-		ExpiresAt:      time.Now().Add(s.timeout),
-		LastActivityAt: time.Now(),
+		ID:               uuid.New().String(),
+		UserID:           userID,
+		SessionToken:     sessionToken,
+		IPAddress:        ipAddress,
+		UserAgent:        userAgent,
+		ExpiresAt:        time.Now().Add(ttl),
+		LastActivityAt:   time.Now(),
+		CreatedAt:        time.Now(),
+		TwoFactorPending: pending,
 	}
 
 	if err := s.sessionRepo.Create(ctx, session); err != nil {
@@ -49,8 +65,34 @@ func (s *SessionService) CreateSession(ctx context.Context, userID, ipAddress, u
 	return session, nil
 }
 
-// ValidateSession validates a session token and returns the session
+// ValidateSession validates a session token and returns the session. Sessions
+// still waiting for a second factor are refused with ErrSessionPending.
 func (s *SessionService) ValidateSession(ctx context.Context, token string) (*models.Session, error) {
+	session, err := s.getSession(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	if session.TwoFactorPending {
+		return nil, ErrSessionPending
+	}
+	return session, nil
+}
+
+// ValidatePendingSession validates the token of a session waiting for a second
+// factor, and refuses any other session
+func (s *SessionService) ValidatePendingSession(ctx context.Context, token string) (*models.Session, error) {
+	session, err := s.getSession(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	if !session.TwoFactorPending {
+		return nil, ErrInvalidToken
+	}
+	return session, nil
+}
+
+// getSession returns the unexpired session of a token
+func (s *SessionService) getSession(ctx context.Context, token string) (*models.Session, error) {
 	session, err := s.sessionRepo.GetByToken(ctx, token)
 	if err != nil {
 		return nil, err
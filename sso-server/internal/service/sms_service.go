@@ -0,0 +1,283 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sso-project/sso-server/internal/models"
+	"github.com/sso-project/sso-server/internal/repository"
+)
+
+var (
+	ErrInvalidPhoneNumber     = errors.New("phone number must be in international format, like +4915112345678")
+	ErrPhoneCountryNotAllowed = errors.New("phone numbers from this country are not supported")
+	ErrPhoneNumberRequired    = errors.New("a phone number is required")
+	ErrSMSRateLimited         = errors.New("too many messages were sent to this phone number, please try again later")
+	ErrSMSGatewayRejected     = errors.New("sms gateway rejected the message")
+)
+
+// Channels messages are sent over
+const (
+	smsChannelText  = "sms"
+	smsChannelVoice = "voice"
+)
+
+// SMSMessage is a text message to a phone number, or a call reading it out
+type SMSMessage struct {
+	To    string // E.164
+	Body  string
+	Voice bool
+}
+
+// SMSProvider delivers messages through an SMS or voice gateway
+type SMSProvider interface {
+	Send(ctx context.Context, message *SMSMessage) error
+}
+
+// SMSOptions configures where messages may be sent
+type SMSOptions struct {
+	AllowedCountryCodes []string      // Calling codes like "+49" numbers must start with; none allows all
+	RateLimit           int           // Messages per number in RateWindow; 0 for no limit
+	RateWindow          time.Duration // Period RateLimit applies to
+}
+
+// SMSService sends text messages and calls to phone numbers of allowed
+// countries, limiting how many each number gets
+type SMSService struct {
+	provider     SMSProvider
+	repo         *repository.SMSRepository
+	countryCodes []string
+	rateLimit    int
+	rateWindow   time.Duration
+}
+
+// NewSMSService creates a new SMS service
+func NewSMSService(provider SMSProvider, repo *repository.SMSRepository, opts SMSOptions) *SMSService {
+	var countryCodes []string
+	for _, code := range opts.AllowedCountryCodes {
+		if code = strings.TrimPrefix(strings.TrimSpace(code), "+"); code != "" {
+			countryCodes = append(countryCodes, "+"+code)
+		}
+	}
+
+	return &SMSService{
+		provider:     provider,
+		repo:         repo,
+		countryCodes: countryCodes,
+		rateLimit:    opts.RateLimit,
+		rateWindow:   opts.RateWindow,
+	}
+}
+
+// CheckNumber normalizes a phone number and checks that messages may be sent to it
+func (s *SMSService) CheckNumber(number string) (string, error) {
+	number, err := NormalizePhoneNumber(number)
+	if err != nil {
+		return "", err
+	}
+
+	if len(s.countryCodes) == 0 {
+		return number, nil
+	}
+	for _, code := range s.countryCodes {
+		if strings.HasPrefix(number, code) {
+			return number, nil
+		}
+	}
+	return "", ErrPhoneCountryNotAllowed
+}
+
+// Send sends a message, as text or as a call, unless the number has had its
+// share of messages. Every attempt counts, so a failing gateway is not retried
+// endlessly.
+func (s *SMSService) Send(ctx context.Context, to, body string, voice bool) error {
+	to, err := s.CheckNumber(to)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	if s.rateLimit > 0 {
+		count, err := s.repo.CountSince(ctx, to, now.Add(-s.rateWindow))
+		if err != nil {
+			return err
+		}
+		if count >= int64(s.rateLimit) {
+			return ErrSMSRateLimited
+		}
+	}
+
+	channel := smsChannelText
+	if voice {
+		channel = smsChannelVoice
+	}
+	delivery := &models.SMSDelivery{
+		ID:          uuid.New().String(),
+		PhoneNumber: to,
+		Channel:     channel,
+		CreatedAt:   now,
+	}
+	if err := s.repo.CreateDelivery(ctx, delivery, now.Add(-s.rateWindow)); err != nil {
+		return err
+	}
+
+	return s.provider.Send(ctx, &SMSMessage{To: to, Body: body, Voice: voice})
+}
+
+// NormalizePhoneNumber returns a phone number in E.164 format. Spaces, dashes,
+// dots and parentheses are ignored, and a leading 00 is taken for +.
+func NormalizePhoneNumber(number string) (string, error) {
+	number = strings.NewReplacer(" ", "", "-", "", ".", "", "(", "", ")", "").Replace(strings.TrimSpace(number))
+	if strings.HasPrefix(number, "00") {
+		number = "+" + number[2:]
+	}
+
+	digits, ok := strings.CutPrefix(number, "+")
+	if !ok || len(digits) < 8 || len(digits) > 15 || digits[0] == '0' {
+		return "", ErrInvalidPhoneNumber
+	}
+	for _, c := range digits {
+		if c < '0' || c > '9' {
+			return "", ErrInvalidPhoneNumber
+		}
+	}
+	return number, nil
+}
+
+// LogSMSProvider writes messages to a file, or the log, instead of sending
+// them. It is meant for development.
+type LogSMSProvider struct {
+	path string
+	mu   sync.Mutex
+}
+
+// NewLogSMSProvider creates a provider appending messages to the file at path,
+// one JSON object per line. Without a path messages go to the log.
+func NewLogSMSProvider(path string) *LogSMSProvider {
+	return &LogSMSProvider{path: path}
+}
+
+// Send records message
+func (p *LogSMSProvider) Send(ctx context.Context, message *SMSMessage) error {
+	if p.path == "" {
+		log.Printf("[SMS] to=%s voice=%t body=%q", message.To, message.Voice, message.Body)
+		return nil
+	}
+
+	line, err := json.Marshal(map[string]interface{}{
+		"to":    message.To,
+		"body":  message.Body,
+		"voice": message.Voice,
+		"time":  time.Now().UTC(),
+	})
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	file, err := os.OpenFile(p.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = file.Write(append(line, '\n'))
+	return err
+}
+
+// WebhookSMSProvider hands messages to a gateway over HTTP: it POSTs
+// {"to", "body", "channel"} as JSON, with the secret as bearer token, and
+// expects a 2xx response
+type WebhookSMSProvider struct {
+	url    string
+	secret string
+	client *http.Client
+}
+
+// NewWebhookSMSProvider creates a provider posting messages to url
+func NewWebhookSMSProvider(url, secret string, timeout time.Duration) *WebhookSMSProvider {
+	return &WebhookSMSProvider{
+		url:    url,
+		secret: secret,
+		client: &http.Client{Timeout: timeout},
+	}
+}
+
+// Send posts message to the gateway
+func (p *WebhookSMSProvider) Send(ctx context.Context, message *SMSMessage) error {
+	channel := smsChannelText
+	if message.Voice {
+		channel = smsChannelVoice
+	}
+	data, err := json.Marshal(map[string]string{
+		"to":      message.To,
+		"body":    message.Body,
+		"channel": channel,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if p.secret != "" {
+		req.Header.Set("Authorization", "Bearer "+p.secret)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%w: HTTP %d: %s", ErrSMSGatewayRejected, resp.StatusCode, strings.TrimSpace(string(detail)))
+	}
+	return nil
+}
+
+// SMSOTPSender sends one-time codes as text messages, or reads them out in calls
+type SMSOTPSender struct {
+	smsService *SMSService
+	voice      bool
+}
+
+// NewSMSOTPSender creates a sender of one-time codes through smsService, by
+// call when voice is set
+func NewSMSOTPSender(smsService *SMSService, voice bool) *SMSOTPSender {
+	return &SMSOTPSender{smsService: smsService, voice: voice}
+}
+
+// CheckDestination normalizes a phone number codes are to be sent to
+func (s *SMSOTPSender) CheckDestination(destination string) (string, error) {
+	return s.smsService.CheckNumber(destination)
+}
+
+// SendOTP texts or calls code to destination
+func (s *SMSOTPSender) SendOTP(ctx context.Context, user *models.User, destination, code string, ttl time.Duration) error {
+	minutes := int(ttl.Round(time.Minute) / time.Minute)
+	body := fmt.Sprintf("Your SSO Server verification code is %s. It expires in %d minutes. Do not share it with anyone.", code, minutes)
+	if s.voice {
+		// Read digit by digit, twice
+		spoken := strings.Join(strings.Split(code, ""), ", ")
+		body = fmt.Sprintf("Your SSO Server verification code is %s. Again, your code is %s.", spoken, spoken)
+	}
+	return s.smsService.Send(ctx, destination, body, s.voice)
+}
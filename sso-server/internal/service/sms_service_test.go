@@ -0,0 +1,163 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sso-project/sso-server/internal/repository"
+	"github.com/sso-project/sso-server/internal/testutil"
+)
+
+// recordingSMSProvider keeps the messages it is asked to send
+type recordingSMSProvider struct {
+	messages []*SMSMessage
+}
+
+func (p *recordingSMSProvider) Send(ctx context.Context, message *SMSMessage) error {
+	p.messages = append(p.messages, message)
+	return nil
+}
+
+func TestNormalizePhoneNumber(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"+49 151 1234-5678", "+4915112345678"},
+		{"0049 (151) 12345678", "+4915112345678"},
+		{"+1.415.555.0100", "+14155550100"},
+	}
+	for _, tt := range tests {
+		got, err := NormalizePhoneNumber(tt.input)
+		require.NoError(t, err, tt.input)
+		assert.Equal(t, tt.want, got)
+	}
+
+	for _, input := range []string{"", "015112345678", "+49151", "+0151123456789", "+49151abc45678", "+1234567890123456"} {
+		_, err := NormalizePhoneNumber(input)
+		assert.ErrorIs(t, err, ErrInvalidPhoneNumber, input)
+	}
+}
+
+func TestSMSService_Send(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+	defer testutil.TeardownTestDB(t, db)
+
+	provider := &recordingSMSProvider{}
+	smsService := NewSMSService(provider, repository.NewSMSRepository(db.DB), SMSOptions{
+		AllowedCountryCodes: []string{"+49", " 43", ""},
+		RateLimit:           2,
+		RateWindow:          time.Hour,
+	})
+	ctx := context.Background()
+
+	t.Run("country allow-list", func(t *testing.T) {
+		number, err := smsService.CheckNumber("+43 660 1234567")
+		require.NoError(t, err)
+		assert.Equal(t, "+436601234567", number)
+
+		_, err = smsService.CheckNumber("+1 415 555 0100")
+		assert.ErrorIs(t, err, ErrPhoneCountryNotAllowed)
+		assert.ErrorIs(t, smsService.Send(ctx, "+14155550100", "hi", false), ErrPhoneCountryNotAllowed)
+	})
+
+	t.Run("per-number rate limit", func(t *testing.T) {
+		require.NoError(t, smsService.Send(ctx, "+4915112345678", "one", false))
+		require.NoError(t, smsService.Send(ctx, "+49 151 12345678", "two", true))
+		assert.ErrorIs(t, smsService.Send(ctx, "+4915112345678", "three", false), ErrSMSRateLimited)
+
+		// Other numbers have their own limit
+		require.NoError(t, smsService.Send(ctx, "+4915187654321", "one", false))
+
+		require.Len(t, provider.messages, 3)
+		assert.Equal(t, "+4915112345678", provider.messages[1].To)
+		assert.True(t, provider.messages[1].Voice)
+	})
+}
+
+func TestLogSMSProvider(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sms.log")
+	provider := NewLogSMSProvider(path)
+
+	require.NoError(t, provider.Send(context.Background(), &SMSMessage{To: "+4915112345678", Body: "code 123456"}))
+	require.NoError(t, provider.Send(context.Background(), &SMSMessage{To: "+4915112345678", Body: "code 654321", Voice: true}))
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	require.Len(t, lines, 2)
+
+	var logged map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(lines[1]), &logged))
+	assert.Equal(t, "code 654321", logged["body"])
+	assert.Equal(t, true, logged["voice"])
+}
+
+func TestWebhookSMSProvider(t *testing.T) {
+	var received map[string]string
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer gateway-secret" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&received)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer gateway.Close()
+
+	provider := NewWebhookSMSProvider(gateway.URL, "gateway-secret", 5*time.Second)
+	require.NoError(t, provider.Send(context.Background(), &SMSMessage{To: "+4915112345678", Body: "code 123456", Voice: true}))
+	assert.Equal(t, map[string]string{"to": "+4915112345678", "body": "code 123456", "channel": "voice"}, received)
+
+	rejected := NewWebhookSMSProvider(gateway.URL, "wrong", 5*time.Second)
+	err := rejected.Send(context.Background(), &SMSMessage{To: "+4915112345678", Body: "code"})
+	assert.ErrorIs(t, err, ErrSMSGatewayRejected)
+}
+
+func TestOTPService_PhoneEnrollment(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+	defer testutil.TeardownTestDB(t, db)
+
+	userRepo := repository.NewUserRepository(db)
+	provider := &recordingSMSProvider{}
+	smsService := NewSMSService(provider, repository.NewSMSRepository(db.DB), SMSOptions{AllowedCountryCodes: []string{"+49"}})
+	otpService := NewOTPService(repository.NewOTPRepository(db.DB), userRepo, OTPOptions{CodeTTL: 10 * time.Minute, MaxAttempts: 3})
+	otpService.AddSender(TwoFactorMethodSMS, NewSMSOTPSender(smsService, false))
+	ctx := context.Background()
+	user := testutil.CreateTestUser(t, db, "tomas@example.com")
+
+	_, err := otpService.BeginPhoneEnrollment(ctx, user.ID, TwoFactorMethodSMS, "")
+	assert.ErrorIs(t, err, ErrPhoneNumberRequired)
+	_, err = otpService.BeginPhoneEnrollment(ctx, user.ID, TwoFactorMethodSMS, "+14155550100")
+	assert.ErrorIs(t, err, ErrPhoneCountryNotAllowed)
+	_, err = otpService.BeginPhoneEnrollment(ctx, user.ID, TwoFactorMethodVoice, "+4915112345678")
+	assert.ErrorIs(t, err, ErrOTPMethodUnavailable)
+
+	factor, err := otpService.BeginPhoneEnrollment(ctx, user.ID, TwoFactorMethodSMS, "+49 151 12345678")
+	require.NoError(t, err)
+	assert.Equal(t, "+4915112345678", factor.Destination)
+	require.Len(t, provider.messages, 1)
+
+	code := provider.messages[0].Body[len("Your SSO Server verification code is ") : len("Your SSO Server verification code is ")+otpCodeDigits]
+	_, err = otpService.ConfirmEnrollment(ctx, user.ID, TwoFactorMethodSMS, code)
+	require.NoError(t, err)
+
+	verified, err := userRepo.GetByID(ctx, user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "+4915112345678", verified.PhoneNumber)
+	assert.True(t, verified.PhoneVerified)
+
+	methods, err := otpService.Methods(ctx, user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, []string{TwoFactorMethodSMS}, methods)
+}
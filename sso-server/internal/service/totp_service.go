@@ -3,14 +3,18 @@ package service
 import (
 	"context"
 	"crypto/rand"
-	"encoding/base64"
+	"encoding/base32"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"image/png"
 	"io"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/hotp"
 	"github.com/pquerna/otp/totp"
 	"github.com/sso-project/sso-server/internal/models"
 	"github.com/sso-project/sso-server/internal/repository"
@@ -18,22 +22,69 @@ import (
 )
 
 var (
-	ErrInvalidTOTPCode   = errors.New("invalid TOTP code")
-	ErrTwoFactorNotSetup = errors.New("two-factor authentication not setup")
+	ErrInvalidTOTPCode         = errors.New("invalid TOTP code")
+	ErrTwoFactorNotSetup       = errors.New("two-factor authentication not setup")
+	ErrTwoFactorAlreadyEnabled = errors.New("two-factor authentication is already enabled")
+	ErrTwoFactorLocked         = errors.New("too many wrong verification codes, please try again later")
 )
 
+// backupCodeCount is how many backup codes a user gets at a time
+const backupCodeCount = 10
+
+// totpAlgorithms are the HMAC algorithms codes can be generated with
+var totpAlgorithms = map[string]otp.Algorithm{
+	"SHA1":   otp.AlgorithmSHA1,
+	"SHA256": otp.AlgorithmSHA256,
+	"SHA512": otp.AlgorithmSHA512,
+}
+
+// TwoFactorResult tells how a 2FA code was accepted
+type TwoFactorResult struct {
+	Method               string // TwoFactorMethodTOTP, or the method of a code sent to the user
+	BackupCodeUsed       bool   // The code was a backup code, now spent
+	BackupCodesRemaining int
+}
+
+// TOTPOptions configures authenticator app codes. Period, Digits and Algorithm
+// apply to new setups; users keep the ones their app was set up with.
+type TOTPOptions struct {
+	Issuer          string        // Shown in authenticator apps
+	Period          int           // Seconds each code is valid for
+	Digits          int           // 6 or 8
+	Algorithm       string        // SHA1, SHA256 or SHA512
+	Skew            int           // Periods before and after the current one whose codes are accepted too, for clock drift
+	MaxAttempts     int           // Wrong codes in a row before 2FA is locked; 0 for no limit
+	LockoutDuration time.Duration // How long 2FA stays locked
+}
+
 // TOTPService handles TOTP operations
 type TOTPService struct {
-	userRepo *repository.UserRepository
-	issuer   string
+	userRepo      *repository.UserRepository
+	twoFactorRepo *repository.TwoFactorRepository
+	opts          TOTPOptions
 }
 
 // NewTOTPService creates a new TOTP service
-func NewTOTPService(userRepo *repository.UserRepository, issuer string) *TOTPService {
-	return &TOTPService{
-		userRepo: userRepo,
-		issuer:   issuer,
+func NewTOTPService(userRepo *repository.UserRepository, twoFactorRepo *repository.TwoFactorRepository, opts TOTPOptions) (*TOTPService, error) {
+	opts.Algorithm = strings.ToUpper(opts.Algorithm)
+	if _, ok := totpAlgorithms[opts.Algorithm]; !ok {
+		return nil, fmt.Errorf("unsupported TOTP algorithm %q, use SHA1, SHA256 or SHA512", opts.Algorithm)
+	}
+	if opts.Digits != 6 && opts.Digits != 8 {
+		return nil, fmt.Errorf("TOTP codes must have 6 or 8 digits, not %d", opts.Digits)
+	}
+	if opts.Period <= 0 {
+		return nil, fmt.Errorf("TOTP period must be positive, not %d", opts.Period)
+	}
+	if opts.Skew < 0 {
+		return nil, fmt.Errorf("TOTP skew must not be negative, not %d", opts.Skew)
 	}
+
+	return &TOTPService{
+		userRepo:      userRepo,
+		twoFactorRepo: twoFactorRepo,
+		opts:          opts,
+	}, nil
 }
 
 // SetupTOTP generates a new TOTP secret for a user
@@ -45,38 +96,45 @@ func (s *TOTPService) SetupTOTP(ctx context.Context, userID string) (*otp.Key, [
 
 	// Generate TOTP secret
 	key, err := totp.Generate(totp.GenerateOpts{
-		Issuer:      s.issuer,
+		Issuer:      s.opts.Issuer,
 		AccountName: user.Email,
-		Period:      30,
-		Digits:      otp.DigitsSix,
-		Algorithm:   otp.AlgorithmSHA1,
+		Period:      uint(s.opts.Period),
+		Digits:      otp.Digits(s.opts.Digits),
+		Algorithm:   totpAlgorithms[s.opts.Algorithm],
 	})
 	if err != nil {
 		return nil, nil, err
 	}
 
 	// Generate backup codes
-	backupCodes := s.generateBackupCodes(10)
-
-	// Hash backup codes for storage
-	hashedCodes := make([]string, len(backupCodes))
-	for i, code := range backupCodes {
-		hash, _ := bcrypt.GenerateFromPassword([]byte(code), 10)
-		hashedCodes[i] = string(hash)
+	backupCodes, hashedCodes, err := s.generateBackupCodes()
+	if err != nil {
+		return nil, nil, err
 	}
 
-	// Store in database (will be marked as enabled after verification)
+	// Stored disabled until the user proves the authenticator works
 	twoFA := &models.TwoFactorAuth{
 		ID:                   uuid.New().String(),
 		UserID:               userID,
 		SecretEncrypted:      key.Secret(),
 		Enabled:              false,
-		BackupCodesEncrypted: string(hashedCodes[0]), // Store as JSON in production
+		BackupCodesEncrypted: hashedCodes,
+		Algorithm:            s.opts.Algorithm,
+		Digits:               s.opts.Digits,
+		Period:               s.opts.Period,
 	}
 
 	// Check if 2FA record exists
 	if user.TwoFactorAuth != nil {
+		if user.TwoFactorAuth.Enabled {
+			return nil, nil, ErrTwoFactorAlreadyEnabled
+		}
 		twoFA.ID = user.TwoFactorAuth.ID
+		twoFA.CreatedAt = user.TwoFactorAuth.CreatedAt
+	}
+
+	if err := s.twoFactorRepo.Save(ctx, twoFA); err != nil {
+		return nil, nil, err
 	}
 
 	return key, backupCodes, nil
@@ -84,65 +142,228 @@ func (s *TOTPService) SetupTOTP(ctx context.Context, userID string) (*otp.Key, [
 
 // VerifyAndEnableTOTP verifies the TOTP code and enables 2FA
 func (s *TOTPService) VerifyAndEnableTOTP(ctx context.Context, userID, code string) error {
-	user, err := s.userRepo.GetByID(ctx, userID)
+	twoFA, err := s.twoFactorRepo.GetByUserID(ctx, userID)
 	if err != nil {
+		if errors.Is(err, repository.ErrTwoFactorNotFound) {
+			return ErrTwoFactorNotSetup
+		}
 		return err
 	}
 
-	if user.TwoFactorAuth == nil {
-		return ErrTwoFactorNotSetup
-	}
-
 	// Verify code
-	valid := totp.Validate(code, user.TwoFactorAuth.SecretEncrypted)
-	if !valid {
+	step, ok := s.matchTimeStep(twoFA, code, time.Now())
+	if !ok {
 		return ErrInvalidTOTPCode
 	}
 
-	// Enable 2FA
-	user.TwoFactorAuth.Enabled = true
+	// Enable 2FA; the code just entered cannot be used to sign in
+	twoFA.Enabled = true
 	now := time.Now()
-	user.TwoFactorAuth.EnabledAt = &now
+	twoFA.EnabledAt = &now
+	twoFA.LastUsedStep = step
 
-	return s.userRepo.Update(ctx, user)
+	return s.twoFactorRepo.Save(ctx, twoFA)
 }
 
-// ValidateTOTP validates a TOTP code
+// ValidateTOTP validates a TOTP code or a backup code
 func (s *TOTPService) ValidateTOTP(ctx context.Context, userID, code string) error {
-	user, err := s.userRepo.GetByID(ctx, userID)
+	_, err := s.Verify(ctx, userID, code)
+	return err
+}
+
+// Verify checks a 2FA code during login or re-authentication. Besides the
+// current TOTP code, each backup code is accepted once. A TOTP code is not
+// accepted again, nor are codes of earlier periods once it is used. After
+// MaxAttempts wrong codes in a row, 2FA is locked and Verify returns
+// ErrTwoFactorLocked until LockoutDuration has passed.
+func (s *TOTPService) Verify(ctx context.Context, userID, code string) (*TwoFactorResult, error) {
+	twoFA, err := s.enabledTwoFactor(ctx, userID)
 	if err != nil {
-		return err
+		return nil, err
 	}
-
-	if user.TwoFactorAuth == nil || !user.TwoFactorAuth.Enabled {
-		return ErrTwoFactorNotSetup
+	if twoFA.LockedUntil != nil && time.Now().Before(*twoFA.LockedUntil) {
+		return nil, ErrTwoFactorLocked
 	}
 
 	// Try TOTP code
-	valid := totp.Validate(code, user.TwoFactorAuth.SecretEncrypted)
-	if valid {
-		return nil
+	code = strings.TrimSpace(code)
+	if step, ok := s.matchTimeStep(twoFA, code, time.Now()); ok {
+		err := s.twoFactorRepo.AcceptTimeStep(ctx, twoFA.ID, step)
+		if errors.Is(err, repository.ErrTimeStepUsed) {
+			return nil, s.recordFailedAttempt(ctx, twoFA)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return &TwoFactorResult{Method: TwoFactorMethodTOTP, BackupCodesRemaining: countBackupCodes(twoFA.BackupCodesEncrypted)}, nil
 	}
 
-	// TODO: Try backup codes validation here
+	remaining, err := s.useBackupCode(ctx, twoFA, code)
+	if errors.Is(err, ErrInvalidTOTPCode) {
+		return nil, s.recordFailedAttempt(ctx, twoFA)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if twoFA.FailedAttempts > 0 {
+		if err := s.twoFactorRepo.ResetFailedAttempts(ctx, twoFA.ID); err != nil {
+			return nil, err
+		}
+	}
+	return &TwoFactorResult{Method: TwoFactorMethodTOTP, BackupCodeUsed: true, BackupCodesRemaining: remaining}, nil
+}
+
+// matchTimeStep returns the time step, within the allowed skew around now,
+// whose code is code. Steps up to the last one used do not count.
+func (s *TOTPService) matchTimeStep(twoFA *models.TwoFactorAuth, code string, now time.Time) (int64, bool) {
+	algorithm, ok := totpAlgorithms[twoFA.Algorithm]
+	if !ok {
+		return 0, false
+	}
+	opts := hotp.ValidateOpts{
+		Digits:    otp.Digits(twoFA.Digits),
+		Algorithm: algorithm,
+	}
+
+	current := now.Unix() / int64(twoFA.Period)
+	for step := current - int64(s.opts.Skew); step <= current+int64(s.opts.Skew); step++ {
+		if step <= twoFA.LastUsedStep {
+			continue
+		}
+		if valid, _ := hotp.ValidateCustom(code, uint64(step), twoFA.SecretEncrypted, opts); valid {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// recordFailedAttempt counts a wrong code, and returns the error to report
+// for it
+func (s *TOTPService) recordFailedAttempt(ctx context.Context, twoFA *models.TwoFactorAuth) error {
+	if s.opts.MaxAttempts <= 0 {
+		return ErrInvalidTOTPCode
+	}
 
+	locked, err := s.twoFactorRepo.RecordFailedAttempt(ctx, twoFA.ID, s.opts.MaxAttempts, time.Now().Add(s.opts.LockoutDuration))
+	if err != nil {
+		return err
+	}
+	if locked != nil {
+		return ErrTwoFactorLocked
+	}
 	return ErrInvalidTOTPCode
 }
 
-// DisableTOTP disables 2FA for a user
+// BackupCodesRemaining returns how many unused backup codes a user has
+func (s *TOTPService) BackupCodesRemaining(ctx context.Context, userID string) (int, error) {
+	twoFA, err := s.enabledTwoFactor(ctx, userID)
+	if err != nil {
+		return 0, err
+	}
+	return countBackupCodes(twoFA.BackupCodesEncrypted), nil
+}
+
+// RegenerateBackupCodes replaces all backup codes of a user with new ones. Only
+// the backup codes are written, so the last used time step and failed attempts
+// recorded meanwhile by sign-ins are kept.
+func (s *TOTPService) RegenerateBackupCodes(ctx context.Context, userID string) ([]string, error) {
+	twoFA, err := s.enabledTwoFactor(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	backupCodes, hashedCodes, err := s.generateBackupCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	// A backup code spent meanwhile changes the stored codes; read them again
+	for attempt := 0; ; attempt++ {
+		err := s.twoFactorRepo.ReplaceBackupCodes(ctx, twoFA.ID, twoFA.BackupCodesEncrypted, hashedCodes)
+		if err == nil {
+			return backupCodes, nil
+		}
+		if !errors.Is(err, repository.ErrBackupCodesChanged) || attempt == 2 {
+			return nil, err
+		}
+		if twoFA, err = s.enabledTwoFactor(ctx, userID); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Settings returns the TOTP settings of a user, whether enabled or still
+// waiting to be confirmed
+func (s *TOTPService) Settings(ctx context.Context, userID string) (*models.TwoFactorAuth, error) {
+	twoFA, err := s.twoFactorRepo.GetByUserID(ctx, userID)
+	if err != nil {
+		if errors.Is(err, repository.ErrTwoFactorNotFound) {
+			return nil, ErrTwoFactorNotSetup
+		}
+		return nil, err
+	}
+	return twoFA, nil
+}
+
+// DisableTOTP disables 2FA for a user, removing the secret and backup codes
 func (s *TOTPService) DisableTOTP(ctx context.Context, userID string) error {
-	user, err := s.userRepo.GetByID(ctx, userID)
+	err := s.twoFactorRepo.DeleteByUserID(ctx, userID)
+	if errors.Is(err, repository.ErrTwoFactorNotFound) {
+		return nil
+	}
+	return err
+}
+
+// enabledTwoFactor returns the 2FA settings of a user who has 2FA enabled
+func (s *TOTPService) enabledTwoFactor(ctx context.Context, userID string) (*models.TwoFactorAuth, error) {
+	twoFA, err := s.twoFactorRepo.GetByUserID(ctx, userID)
 	if err != nil {
-		return err
+		if errors.Is(err, repository.ErrTwoFactorNotFound) {
+			return nil, ErrTwoFactorNotSetup
+		}
+		return nil, err
+	}
+	if !twoFA.Enabled {
+		return nil, ErrTwoFactorNotSetup
 	}
+	return twoFA, nil
+}
 
-	if user.TwoFactorAuth != nil {
-		user.TwoFactorAuth.Enabled = false
-		user.TwoFactorAuth.EnabledAt = nil
-		return s.userRepo.Update(ctx, user)
+// useBackupCode spends the backup code matching code and returns how many are left
+func (s *TOTPService) useBackupCode(ctx context.Context, twoFA *models.TwoFactorAuth, code string) (int, error) {
+	code = normalizeBackupCode(code)
+	if code == "" {
+		return 0, ErrInvalidTOTPCode
+	}
+
+	var hashes []string
+	if twoFA.BackupCodesEncrypted != "" {
+		if err := json.Unmarshal([]byte(twoFA.BackupCodesEncrypted), &hashes); err != nil {
+			return 0, err
+		}
 	}
 
-	return nil
+	for i, hash := range hashes {
+		if bcrypt.CompareHashAndPassword([]byte(hash), []byte(code)) != nil {
+			continue
+		}
+
+		remaining := append(hashes[:i:i], hashes[i+1:]...)
+		stored, err := json.Marshal(remaining)
+		if err != nil {
+			return 0, err
+		}
+		// Only one of two concurrent requests with the same code gets through
+		if err := s.twoFactorRepo.ReplaceBackupCodes(ctx, twoFA.ID, twoFA.BackupCodesEncrypted, string(stored)); err != nil {
+			if errors.Is(err, repository.ErrBackupCodesChanged) {
+				return 0, ErrInvalidTOTPCode
+			}
+			return 0, err
+		}
+		return len(remaining), nil
+	}
+
+	return 0, ErrInvalidTOTPCode
 }
 
 // GenerateQRCode generates a QR code image for the TOTP secret
@@ -155,29 +376,48 @@ func (s *TOTPService) GenerateQRCode(key *otp.Key, writer io.Writer) error {
 	return png.Encode(writer, img)
 }
 
-// generateBackupCodes generates random backup codes
-func (s *TOTPService) generateBackupCodes(count int) []string {
-	codes := make([]string, count)
-	for i := 0; i < count; i++ {
-		bytes := make([]byte, 6)
-		rand.Read(bytes)
-		codes[i] = base64.StdEncoding.EncodeToString(bytes)[:8]
+// generateBackupCodes generates random backup codes, returned as shown to the
+// user and as the JSON list of their bcrypt hashes to store
+func (s *TOTPService) generateBackupCodes() ([]string, string, error) {
+	codes := make([]string, backupCodeCount)
+	hashes := make([]string, backupCodeCount)
+	for i := range codes {
+		bytes := make([]byte, 7)
+		if _, err := rand.Read(bytes); err != nil {
+			return nil, "", err
+		}
+		code := strings.ToLower(base32.StdEncoding.EncodeToString(bytes))[:10]
+
+		hash, err := bcrypt.GenerateFromPassword([]byte(code), 10)
+		if err != nil {
+			return nil, "", err
+		}
+		codes[i] = code[:5] + "-" + code[5:]
+		hashes[i] = string(hash)
 	}
-	return codes
-}
 
-// VerifyCode verifies a TOTP code for an already-enabled 2FA user (used during login)
-func (s *TOTPService) VerifyCode(ctx context.Context, userID, code string) (bool, error) {
-	user, err := s.userRepo.GetByID(ctx, userID)
+	stored, err := json.Marshal(hashes)
 	if err != nil {
-		return false, err
+		return nil, "", err
 	}
+	return codes, string(stored), nil
+}
 
-	if user.TwoFactorAuth == nil || !user.TwoFactorAuth.Enabled {
-		return false, ErrTwoFactorNotSetup
+// normalizeBackupCode accepts backup codes typed in any case, with or without the dash
+func normalizeBackupCode(code string) string {
+	code = strings.ToLower(code)
+	code = strings.NewReplacer("-", "", " ", "").Replace(code)
+	if len(code) != 10 {
+		return ""
 	}
+	return code
+}
 
-	// Verify code using TOTP library
-	valid := totp.Validate(code, user.TwoFactorAuth.SecretEncrypted)
-	return valid, nil
+// countBackupCodes returns how many hashes a stored list holds
+func countBackupCodes(stored string) int {
+	var hashes []string
+	if stored == "" || json.Unmarshal([]byte(stored), &hashes) != nil {
+		return 0
+	}
+	return len(hashes)
 }
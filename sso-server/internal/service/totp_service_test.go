@@ -0,0 +1,290 @@
+package service
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sso-project/sso-server/internal/database"
+	"github.com/sso-project/sso-server/internal/repository"
+	"github.com/sso-project/sso-server/internal/testutil"
+)
+
+func newTestTOTPService(t *testing.T, db *database.DB) *TOTPService {
+	totpService, err := NewTOTPService(repository.NewUserRepository(db), repository.NewTwoFactorRepository(db.DB), TOTPOptions{
+		Issuer:          "SSO",
+		Period:          30,
+		Digits:          6,
+		Algorithm:       "SHA1",
+		Skew:            1,
+		MaxAttempts:     3,
+		LockoutDuration: 15 * time.Minute,
+	})
+	require.NoError(t, err)
+	return totpService
+}
+
+func TestTOTPService_BackupCodes(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+	defer testutil.TeardownTestDB(t, db)
+
+	totpService := newTestTOTPService(t, db)
+	ctx := context.Background()
+	user := testutil.CreateTestUser(t, db, "ivan@example.com")
+
+	key, codes, err := totpService.SetupTOTP(ctx, user.ID)
+	require.NoError(t, err)
+	require.Len(t, codes, 10)
+	assert.Regexp(t, `^[a-z2-7]{5}-[a-z2-7]{5}$`, codes[0])
+
+	// Nothing is accepted until setup is confirmed
+	_, err = totpService.Verify(ctx, user.ID, codes[0])
+	assert.ErrorIs(t, err, ErrTwoFactorNotSetup)
+
+	code, err := totp.GenerateCode(key.Secret(), time.Now())
+	require.NoError(t, err)
+	require.NoError(t, totpService.VerifyAndEnableTOTP(ctx, user.ID, code))
+
+	_, _, err = totpService.SetupTOTP(ctx, user.ID)
+	assert.ErrorIs(t, err, ErrTwoFactorAlreadyEnabled)
+
+	// The code that confirmed setup is spent; the next period's one works
+	code, err = totp.GenerateCode(key.Secret(), time.Now().Add(30*time.Second))
+	require.NoError(t, err)
+	result, err := totpService.Verify(ctx, user.ID, code)
+	require.NoError(t, err)
+	assert.False(t, result.BackupCodeUsed)
+	assert.Equal(t, 10, result.BackupCodesRemaining)
+
+	// Backup codes are accepted once, in any case and without the dash
+	result, err = totpService.Verify(ctx, user.ID, strings.ToUpper(strings.ReplaceAll(codes[3], "-", "")))
+	require.NoError(t, err)
+	assert.True(t, result.BackupCodeUsed)
+	assert.Equal(t, 9, result.BackupCodesRemaining)
+
+	_, err = totpService.Verify(ctx, user.ID, codes[3])
+	assert.ErrorIs(t, err, ErrInvalidTOTPCode)
+	assert.ErrorIs(t, totpService.ValidateTOTP(ctx, user.ID, "abcde-fghij"), ErrInvalidTOTPCode)
+
+	require.NoError(t, totpService.ValidateTOTP(ctx, user.ID, codes[4]))
+	remaining, err := totpService.BackupCodesRemaining(ctx, user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 8, remaining)
+
+	// Regenerating replaces every code
+	newCodes, err := totpService.RegenerateBackupCodes(ctx, user.ID)
+	require.NoError(t, err)
+	require.Len(t, newCodes, 10)
+	_, err = totpService.Verify(ctx, user.ID, codes[5])
+	assert.ErrorIs(t, err, ErrInvalidTOTPCode)
+	result, err = totpService.Verify(ctx, user.ID, newCodes[0])
+	require.NoError(t, err)
+	assert.Equal(t, 9, result.BackupCodesRemaining)
+
+	require.NoError(t, totpService.DisableTOTP(ctx, user.ID))
+	_, err = totpService.BackupCodesRemaining(ctx, user.ID)
+	assert.ErrorIs(t, err, ErrTwoFactorNotSetup)
+}
+
+// enableTestTOTP sets up and confirms TOTP for a user, with a code of the
+// period before the current one, and returns the secret
+func enableTestTOTP(t *testing.T, totpService *TOTPService, userID string) string {
+	key, _, err := totpService.SetupTOTP(context.Background(), userID)
+	require.NoError(t, err)
+	code, err := totp.GenerateCode(key.Secret(), time.Now().Add(-30*time.Second))
+	require.NoError(t, err)
+	require.NoError(t, totpService.VerifyAndEnableTOTP(context.Background(), userID, code))
+	return key.Secret()
+}
+
+func TestTOTPService_Replay(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+	defer testutil.TeardownTestDB(t, db)
+
+	totpService := newTestTOTPService(t, db)
+	ctx := context.Background()
+	user := testutil.CreateTestUser(t, db, "wes@example.com")
+	secret := enableTestTOTP(t, totpService, user.ID)
+
+	code, err := totp.GenerateCode(secret, time.Now())
+	require.NoError(t, err)
+	_, err = totpService.Verify(ctx, user.ID, code)
+	require.NoError(t, err)
+
+	// The same code does not work twice
+	_, err = totpService.Verify(ctx, user.ID, code)
+	assert.ErrorIs(t, err, ErrInvalidTOTPCode)
+
+	// Nor does the code of an earlier period once a later one was used
+	earlier, err := totp.GenerateCode(secret, time.Now().Add(-30*time.Second))
+	require.NoError(t, err)
+	_, err = totpService.Verify(ctx, user.ID, earlier)
+	assert.ErrorIs(t, err, ErrInvalidTOTPCode)
+
+	// The next period's code is within the skew
+	next, err := totp.GenerateCode(secret, time.Now().Add(30*time.Second))
+	require.NoError(t, err)
+	_, err = totpService.Verify(ctx, user.ID, next)
+	require.NoError(t, err)
+}
+
+func TestTOTPService_Lockout(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+	defer testutil.TeardownTestDB(t, db)
+
+	totpService := newTestTOTPService(t, db)
+	twoFactorRepo := repository.NewTwoFactorRepository(db.DB)
+	ctx := context.Background()
+	user := testutil.CreateTestUser(t, db, "xena@example.com")
+	secret := enableTestTOTP(t, totpService, user.ID)
+
+	// A good code clears earlier failures
+	_, err := totpService.Verify(ctx, user.ID, "000000")
+	assert.ErrorIs(t, err, ErrInvalidTOTPCode)
+	code, err := totp.GenerateCode(secret, time.Now())
+	require.NoError(t, err)
+	_, err = totpService.Verify(ctx, user.ID, code)
+	require.NoError(t, err)
+
+	_, err = totpService.Verify(ctx, user.ID, "000000")
+	assert.ErrorIs(t, err, ErrInvalidTOTPCode)
+	_, err = totpService.Verify(ctx, user.ID, "abcde-fghij")
+	assert.ErrorIs(t, err, ErrInvalidTOTPCode)
+	_, err = totpService.Verify(ctx, user.ID, "111111")
+	assert.ErrorIs(t, err, ErrTwoFactorLocked)
+
+	// Locked, even for the right code
+	next, err := totp.GenerateCode(secret, time.Now().Add(30*time.Second))
+	require.NoError(t, err)
+	_, err = totpService.Verify(ctx, user.ID, next)
+	assert.ErrorIs(t, err, ErrTwoFactorLocked)
+
+	twoFA, err := twoFactorRepo.GetByUserID(ctx, user.ID)
+	require.NoError(t, err)
+	require.NotNil(t, twoFA.LockedUntil)
+	require.NoError(t, db.DB.Model(twoFA).Update("locked_until", time.Now().Add(-time.Minute)).Error)
+
+	_, err = totpService.Verify(ctx, user.ID, next)
+	require.NoError(t, err)
+	twoFA, err = twoFactorRepo.GetByUserID(ctx, user.ID)
+	require.NoError(t, err)
+	assert.Nil(t, twoFA.LockedUntil)
+	assert.Zero(t, twoFA.FailedAttempts)
+}
+
+func TestTOTPService_RegenerateBackupCodesKeepsState(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+	defer testutil.TeardownTestDB(t, db)
+
+	totpService := newTestTOTPService(t, db)
+	twoFactorRepo := repository.NewTwoFactorRepository(db.DB)
+	ctx := context.Background()
+	user := testutil.CreateTestUser(t, db, "yves@example.com")
+	secret := enableTestTOTP(t, totpService, user.ID)
+
+	code, err := totp.GenerateCode(secret, time.Now())
+	require.NoError(t, err)
+	_, err = totpService.Verify(ctx, user.ID, code)
+	require.NoError(t, err)
+	_, err = totpService.Verify(ctx, user.ID, "000000")
+	assert.ErrorIs(t, err, ErrInvalidTOTPCode)
+	before, err := twoFactorRepo.GetByUserID(ctx, user.ID)
+	require.NoError(t, err)
+
+	_, err = totpService.RegenerateBackupCodes(ctx, user.ID)
+	require.NoError(t, err)
+
+	// Only the backup codes changed
+	after, err := twoFactorRepo.GetByUserID(ctx, user.ID)
+	require.NoError(t, err)
+	assert.NotEqual(t, before.BackupCodesEncrypted, after.BackupCodesEncrypted)
+	assert.Equal(t, before.LastUsedStep, after.LastUsedStep)
+	assert.Equal(t, 1, after.FailedAttempts)
+
+	// The used code is still refused, and counts towards the lock
+	_, err = totpService.Verify(ctx, user.ID, code)
+	assert.ErrorIs(t, err, ErrInvalidTOTPCode)
+	_, err = totpService.Verify(ctx, user.ID, "000000")
+	assert.ErrorIs(t, err, ErrTwoFactorLocked)
+}
+
+func TestTOTPService_NoLockout(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+	defer testutil.TeardownTestDB(t, db)
+
+	totpService, err := NewTOTPService(repository.NewUserRepository(db), repository.NewTwoFactorRepository(db.DB), TOTPOptions{
+		Issuer:    "SSO",
+		Period:    30,
+		Digits:    6,
+		Algorithm: "SHA1",
+		Skew:      1,
+	})
+	require.NoError(t, err)
+	twoFactorRepo := repository.NewTwoFactorRepository(db.DB)
+	ctx := context.Background()
+	user := testutil.CreateTestUser(t, db, "zane@example.com")
+	secret := enableTestTOTP(t, totpService, user.ID)
+
+	// MaxAttempts 0 never locks 2FA
+	for i := 0; i < 10; i++ {
+		_, err := totpService.Verify(ctx, user.ID, "000000")
+		assert.ErrorIs(t, err, ErrInvalidTOTPCode)
+	}
+
+	twoFA, err := twoFactorRepo.GetByUserID(ctx, user.ID)
+	require.NoError(t, err)
+	assert.Nil(t, twoFA.LockedUntil)
+
+	code, err := totp.GenerateCode(secret, time.Now())
+	require.NoError(t, err)
+	_, err = totpService.Verify(ctx, user.ID, code)
+	require.NoError(t, err)
+}
+
+func TestTOTPService_Options(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+	defer testutil.TeardownTestDB(t, db)
+
+	userRepo := repository.NewUserRepository(db)
+	twoFactorRepo := repository.NewTwoFactorRepository(db.DB)
+	ctx := context.Background()
+
+	for _, opts := range []TOTPOptions{
+		{Period: 30, Digits: 6, Algorithm: "MD5"},
+		{Period: 30, Digits: 7, Algorithm: "SHA1"},
+		{Period: 0, Digits: 6, Algorithm: "SHA1"},
+		{Period: 30, Digits: 6, Algorithm: "SHA1", Skew: -1},
+	} {
+		_, err := NewTOTPService(userRepo, twoFactorRepo, opts)
+		assert.Error(t, err, "%+v", opts)
+	}
+
+	sha512, err := NewTOTPService(userRepo, twoFactorRepo, TOTPOptions{Issuer: "Example", Period: 60, Digits: 8, Algorithm: "sha512", Skew: 1})
+	require.NoError(t, err)
+	user := testutil.CreateTestUser(t, db, "yara@example.com")
+
+	key, _, err := sha512.SetupTOTP(ctx, user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "Example", key.Issuer())
+	assert.Equal(t, otp.AlgorithmSHA512, key.Algorithm())
+	assert.Equal(t, otp.DigitsEight, key.Digits())
+	assert.Equal(t, uint64(60), key.Period())
+
+	opts := totp.ValidateOpts{Period: 60, Digits: otp.DigitsEight, Algorithm: otp.AlgorithmSHA512}
+	code, err := totp.GenerateCodeCustom(key.Secret(), time.Now().Add(-time.Minute), opts)
+	require.NoError(t, err)
+	require.NoError(t, sha512.VerifyAndEnableTOTP(ctx, user.ID, code))
+
+	// Users keep the settings they enrolled with when the configuration changes
+	sha1 := newTestTOTPService(t, db)
+	code, err = totp.GenerateCodeCustom(key.Secret(), time.Now(), opts)
+	require.NoError(t, err)
+	_, err = sha1.Verify(ctx, user.ID, code)
+	require.NoError(t, err)
+}
@@ -0,0 +1,147 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// TwoFactorFactor is a second factor of a user, as listed to them or to admins
+type TwoFactorFactor struct {
+	ID          string     `json:"id"`
+	Method      string     `json:"method"`                // totp, webauthn, email, sms or voice
+	Name        string     `json:"name,omitempty"`        // Name of a security key or passkey
+	Destination string     `json:"destination,omitempty"` // Masked address or number codes are sent to
+	Enabled     bool       `json:"enabled"`               // False until enrollment is confirmed
+	EnabledAt   *time.Time `json:"enabled_at,omitempty"`
+	LastUsedAt  *time.Time `json:"last_used_at,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+// TwoFactorStatus sums up the second factors of a user
+type TwoFactorStatus struct {
+	Enabled              bool               `json:"enabled"` // At least one factor is enabled
+	Factors              []*TwoFactorFactor `json:"factors"`
+	BackupCodesRemaining int                `json:"backup_codes_remaining"`
+}
+
+// TwoFactorService gives one view over the second factors kept by the TOTP,
+// one-time code and WebAuthn services
+type TwoFactorService struct {
+	totp     *TOTPService
+	otp      *OTPService
+	webauthn *WebAuthnService
+}
+
+// NewTwoFactorService creates a new 2FA service. otp and webauthn may be nil
+// when those factors are not offered.
+func NewTwoFactorService(totp *TOTPService, otp *OTPService, webauthn *WebAuthnService) *TwoFactorService {
+	return &TwoFactorService{
+		totp:     totp,
+		otp:      otp,
+		webauthn: webauthn,
+	}
+}
+
+// Status lists the second factors of a user, including ones not yet confirmed
+func (s *TwoFactorService) Status(ctx context.Context, userID string) (*TwoFactorStatus, error) {
+	status := &TwoFactorStatus{Factors: []*TwoFactorFactor{}}
+
+	twoFA, err := s.totp.Settings(ctx, userID)
+	if err != nil && !errors.Is(err, ErrTwoFactorNotSetup) {
+		return nil, err
+	}
+	if twoFA != nil {
+		status.Factors = append(status.Factors, &TwoFactorFactor{
+			ID:        twoFA.ID,
+			Method:    TwoFactorMethodTOTP,
+			Enabled:   twoFA.Enabled,
+			EnabledAt: twoFA.EnabledAt,
+			CreatedAt: twoFA.CreatedAt,
+		})
+		if twoFA.Enabled {
+			status.BackupCodesRemaining = countBackupCodes(twoFA.BackupCodesEncrypted)
+		}
+	}
+
+	if s.webauthn != nil {
+		credentials, err := s.webauthn.ListCredentials(ctx, userID)
+		if err != nil {
+			return nil, err
+		}
+		for _, credential := range credentials {
+			createdAt := credential.CreatedAt
+			status.Factors = append(status.Factors, &TwoFactorFactor{
+				ID:         credential.ID,
+				Method:     TwoFactorMethodWebAuthn,
+				Name:       credential.Name,
+				Enabled:    true,
+				EnabledAt:  &createdAt,
+				LastUsedAt: credential.LastUsedAt,
+				CreatedAt:  credential.CreatedAt,
+			})
+		}
+	}
+
+	if s.otp != nil {
+		factors, err := s.otp.ListFactors(ctx, userID)
+		if err != nil {
+			return nil, err
+		}
+		for _, factor := range factors {
+			status.Factors = append(status.Factors, &TwoFactorFactor{
+				ID:          factor.ID,
+				Method:      factor.Method,
+				Destination: MaskDestination(factor.Destination),
+				Enabled:     factor.Enabled,
+				EnabledAt:   factor.EnabledAt,
+				LastUsedAt:  factor.LastUsedAt,
+				CreatedAt:   factor.CreatedAt,
+			})
+		}
+	}
+
+	for _, factor := range status.Factors {
+		if factor.Enabled {
+			status.Enabled = true
+			break
+		}
+	}
+	return status, nil
+}
+
+// Reset removes every second factor of a user, for when they lost access to
+// all of them, and returns how many were removed. The user signs in with their
+// password alone afterwards.
+func (s *TwoFactorService) Reset(ctx context.Context, userID string) (int, error) {
+	removed := 0
+
+	twoFA, err := s.totp.Settings(ctx, userID)
+	if err != nil && !errors.Is(err, ErrTwoFactorNotSetup) {
+		return 0, err
+	}
+	if twoFA != nil {
+		if err := s.totp.DisableTOTP(ctx, userID); err != nil {
+			return removed, err
+		}
+		removed++
+	}
+
+	if s.webauthn != nil {
+		deleted, err := s.webauthn.DeleteAllCredentials(ctx, userID)
+		removed += deleted
+		if err != nil {
+			return removed, err
+		}
+	}
+
+	if s.otp != nil {
+		deleted, err := s.otp.DisableAll(ctx, userID)
+		removed += deleted
+		if err != nil {
+			return removed, err
+		}
+	}
+
+	return removed, nil
+}
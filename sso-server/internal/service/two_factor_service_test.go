@@ -0,0 +1,92 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pquerna/otp/totp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sso-project/sso-server/internal/models"
+	"github.com/sso-project/sso-server/internal/testutil"
+)
+
+func TestTwoFactorService_StatusAndReset(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+	defer testutil.TeardownTestDB(t, db)
+
+	totpService := newTestTOTPService(t, db)
+	otpService, sender, _ := newTestOTPService(t, db)
+	webauthnService, webauthnRepo := newTestWebAuthnService(t, db)
+	twoFactorService := NewTwoFactorService(totpService, otpService, webauthnService)
+	ctx := context.Background()
+
+	user := testutil.CreateTestUser(t, db, "uma@example.com")
+	other := testutil.CreateTestUser(t, db, "vic@example.com")
+
+	status, err := twoFactorService.Status(ctx, user.ID)
+	require.NoError(t, err)
+	assert.False(t, status.Enabled)
+	assert.Empty(t, status.Factors)
+
+	// An authenticator app counts once it is confirmed
+	key, _, err := totpService.SetupTOTP(ctx, user.ID)
+	require.NoError(t, err)
+	status, err = twoFactorService.Status(ctx, user.ID)
+	require.NoError(t, err)
+	assert.False(t, status.Enabled)
+	require.Len(t, status.Factors, 1)
+	assert.Equal(t, TwoFactorMethodTOTP, status.Factors[0].Method)
+
+	code, err := totp.GenerateCode(key.Secret(), time.Now())
+	require.NoError(t, err)
+	require.NoError(t, totpService.VerifyAndEnableTOTP(ctx, user.ID, code))
+
+	_, err = otpService.BeginEmailEnrollment(ctx, user.ID)
+	require.NoError(t, err)
+	_, err = otpService.ConfirmEnrollment(ctx, user.ID, TwoFactorMethodEmail, sender.last())
+	require.NoError(t, err)
+
+	for _, owner := range []string{user.ID, other.ID} {
+		require.NoError(t, webauthnRepo.CreateCredential(ctx, &models.WebAuthnCredential{
+			ID:           uuid.New().String(),
+			UserID:       owner,
+			Name:         "YubiKey",
+			CredentialID: uuid.New().String(),
+			PublicKey:    []byte{1},
+		}))
+	}
+
+	status, err = twoFactorService.Status(ctx, user.ID)
+	require.NoError(t, err)
+	assert.True(t, status.Enabled)
+	assert.Equal(t, 10, status.BackupCodesRemaining)
+	require.Len(t, status.Factors, 3)
+	assert.Equal(t, TwoFactorMethodWebAuthn, status.Factors[1].Method)
+	assert.Equal(t, "YubiKey", status.Factors[1].Name)
+	assert.Equal(t, TwoFactorMethodEmail, status.Factors[2].Method)
+	assert.Equal(t, "u***@example.com", status.Factors[2].Destination)
+
+	removed, err := twoFactorService.Reset(ctx, user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 3, removed)
+
+	status, err = twoFactorService.Status(ctx, user.ID)
+	require.NoError(t, err)
+	assert.False(t, status.Enabled)
+	assert.Empty(t, status.Factors)
+	_, err = totpService.Verify(ctx, user.ID, code)
+	assert.ErrorIs(t, err, ErrTwoFactorNotSetup)
+
+	// Other users keep their factors
+	status, err = twoFactorService.Status(ctx, other.ID)
+	require.NoError(t, err)
+	assert.Len(t, status.Factors, 1)
+
+	removed, err = twoFactorService.Reset(ctx, user.ID)
+	require.NoError(t, err)
+	assert.Zero(t, removed)
+}
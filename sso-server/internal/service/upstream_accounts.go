@@ -0,0 +1,232 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sso-project/sso-server/internal/models"
+	"github.com/sso-project/sso-server/internal/repository"
+	"github.com/sso-project/sso-server/internal/utils"
+)
+
+// upstreamAccount is an account at an upstream identity provider as the provider
+// asserted it, whichever protocol carried the assertion
+type upstreamAccount struct {
+	Provider      string // Key of the link in user_identities
+	Subject       string
+	Email         string
+	Name          string
+	EmailVerified bool // The provider vouches for Email, so it may link an existing user
+	Groups        []string
+}
+
+// upstreamPolicy is what a connection decides about the accounts it signs in
+type upstreamPolicy struct {
+	Connection      string            // Slug, for logs and audit details
+	JITProvisioning bool              // Create users for unknown accounts
+	LinkByEmail     bool              // Link unknown accounts to the user with the same verified email
+	RoleMappings    map[string]string // Upstream group -> local role name
+}
+
+// upstreamAccounts turns verified upstream accounts into local users and sessions.
+// It is shared by the OIDC and SAML sign-in flows.
+type upstreamAccounts struct {
+	identityRepo   *repository.UserIdentityRepository
+	userRepo       *repository.UserRepository
+	roleRepo       *repository.RoleRepository
+	sessionService *SessionService
+	auditRepo      *repository.AuditLogRepository
+	identities     *IdentityService // Set when users can link accounts themselves
+}
+
+// linkByEmail tells whether sign-ins may link to existing users by email
+func (a *upstreamAccounts) linkByEmail() bool {
+	return a.identities == nil || a.identities.opts.LinkByEmail
+}
+
+// link attaches account to the user who started a link request from their
+// account, instead of signing in with it. No session is created.
+func (a *upstreamAccounts) link(ctx context.Context, account *upstreamAccount, request string) (*UpstreamLoginResult, error) {
+	if a.identities == nil {
+		return nil, ErrIdentityLinkExpired
+	}
+
+	user, identity, err := a.identities.link(ctx, request, account)
+	if err != nil {
+		return nil, err
+	}
+	a.identityRepo.RecordLogin(ctx, identity.ID, account.Email, time.Now())
+
+	return &UpstreamLoginResult{
+		User:   user,
+		Linked: true,
+	}, nil
+}
+
+// signIn finds, links or provisions the local user for account and creates a
+// session for them, as AuthService.Login does after a password check
+func (a *upstreamAccounts) signIn(ctx context.Context, account *upstreamAccount, policy upstreamPolicy, ipAddress, userAgent string) (*UpstreamLoginResult, error) {
+	user, identity, provisioned, err := a.resolveUser(ctx, account, policy)
+	if err != nil {
+		return nil, err
+	}
+
+	if !user.IsActive {
+		return nil, ErrAccountInactive
+	}
+	if user.IsLocked && user.LockedUntil != nil && time.Now().Before(*user.LockedUntil) {
+		return nil, ErrAccountLocked
+	}
+
+	a.applyRoleMappings(ctx, policy, user, account.Groups)
+
+	now := time.Now()
+	a.identityRepo.RecordLogin(ctx, identity.ID, account.Email, now)
+	a.userRepo.UpdateLastLogin(ctx, user.ID, &now)
+
+	session, err := a.sessionService.CreateSession(ctx, user.ID, ipAddress, userAgent)
+	if err != nil {
+		return nil, err
+	}
+
+	return &UpstreamLoginResult{
+		User:        user,
+		Session:     session,
+		Provisioned: provisioned,
+	}, nil
+}
+
+// resolveUser finds the local user for the upstream account: through an existing
+// link, by linking the user with the same verified email, or by provisioning one
+func (a *upstreamAccounts) resolveUser(ctx context.Context, account *upstreamAccount, policy upstreamPolicy) (*models.User, *models.UserIdentity, bool, error) {
+	identity, err := a.identityRepo.GetByProviderSubject(ctx, account.Provider, account.Subject)
+	if err == nil {
+		user, err := a.userRepo.GetByID(ctx, identity.UserID)
+		if err != nil {
+			return nil, nil, false, err
+		}
+		return user, identity, false, nil
+	}
+	if !errors.Is(err, repository.ErrIdentityNotFound) {
+		return nil, nil, false, err
+	}
+
+	email := strings.ToLower(account.Email)
+	if email == "" {
+		return nil, nil, false, ErrUpstreamEmailMissing
+	}
+
+	provisioned := false
+	user, err := a.userRepo.GetByEmail(ctx, email)
+	switch {
+	case err == nil:
+		// Linking to an existing account relies on the provider vouching for the address
+		if !policy.LinkByEmail {
+			return nil, nil, false, ErrUpstreamAccountExists
+		}
+		if !account.EmailVerified {
+			return nil, nil, false, ErrUpstreamEmailUnverified
+		}
+	case errors.Is(err, repository.ErrNotFound):
+		if !policy.JITProvisioning {
+			return nil, nil, false, ErrUpstreamNotProvisioned
+		}
+		user, err = a.provisionUser(ctx, email, account.Name, account.EmailVerified)
+		if err != nil {
+			return nil, nil, false, err
+		}
+		provisioned = true
+	default:
+		return nil, nil, false, err
+	}
+
+	identity = &models.UserIdentity{
+		ID:        uuid.New().String(),
+		UserID:    user.ID,
+		Provider:  account.Provider,
+		Subject:   account.Subject,
+		Email:     email,
+		CreatedAt: time.Now(),
+	}
+	if err := a.identityRepo.Create(ctx, identity); err != nil {
+		return nil, nil, false, err
+	}
+
+	return user, identity, provisioned, nil
+}
+
+// provisionUser creates a user for an upstream account. The password is random
+// and never disclosed; the user can set one through password reset.
+func (a *upstreamAccounts) provisionUser(ctx context.Context, email, name string, emailVerified bool) (*models.User, error) {
+	password, err := utils.GenerateRandomToken(32)
+	if err != nil {
+		return nil, err
+	}
+	passwordHash, err := utils.HashPassword(password)
+	if err != nil {
+		return nil, err
+	}
+
+	if name == "" {
+		name = strings.SplitN(email, "@", 2)[0]
+	}
+
+	user := &models.User{
+		ID:                uuid.New().String(),
+		Email:             email,
+		PasswordHash:      passwordHash,
+		Name:              name,
+		EmailVerified:     emailVerified,
+		IsActive:          true,
+		PasswordChangedAt: time.Now(),
+	}
+	if err := a.userRepo.Create(ctx, user); err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// applyRoleMappings grants the local roles mapped to the user's upstream groups.
+// Roles are only added; removing a group upstream does not revoke the role.
+func (a *upstreamAccounts) applyRoleMappings(ctx context.Context, policy upstreamPolicy, user *models.User, groups []string) {
+	for _, group := range groups {
+		roleName, ok := policy.RoleMappings[group]
+		if !ok || slices.ContainsFunc(user.Roles, func(role models.Role) bool { return role.Name == roleName }) {
+			continue
+		}
+
+		role, err := a.roleRepo.GetByName(ctx, roleName)
+		if err != nil {
+			log.Printf("Connection %s maps group %q to unknown role %q", policy.Connection, group, roleName)
+			continue
+		}
+		if err := a.userRepo.AssignRole(ctx, user.ID, role.ID); err != nil {
+			log.Printf("Failed to assign role %s to user %s: %v", role.Name, user.ID, err)
+			continue
+		}
+		user.Roles = append(user.Roles, *role)
+	}
+}
+
+func (a *upstreamAccounts) logAudit(ctx context.Context, userID *string, action, ipAddress, userAgent, details string) {
+	auditLog := &models.AuditLog{
+		ID:        uuid.New().String(),
+		UserID:    userID,
+		Action:    action,
+		Resource:  "authentication",
+		IPAddress: ipAddress,
+		UserAgent: userAgent,
+		Details:   details,
+		CreatedAt: time.Now(),
+	}
+
+	if err := a.auditRepo.Create(ctx, auditLog); err != nil {
+		log.Printf("Failed to create audit log: %v", err)
+	}
+}
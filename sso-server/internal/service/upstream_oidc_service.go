@@ -0,0 +1,597 @@
+package service
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/sso-project/sso-server/internal/models"
+	"github.com/sso-project/sso-server/internal/repository"
+	"github.com/sso-project/sso-server/internal/utils"
+)
+
+var (
+	ErrOIDCConnectionExists    = errors.New("connection slug is already in use")
+	ErrOIDCConnectionRequired  = errors.New("name, slug, discovery_url and client_id are required")
+	ErrInvalidConnectionSlug   = errors.New("slug may only contain lowercase letters, digits and hyphens")
+	ErrConnectionSlugFixed     = errors.New("slug cannot be changed")
+	ErrInvalidDiscoveryURL     = errors.New("invalid discovery_url")
+	ErrInsecureDiscoveryURL    = errors.New("discovery_url must use https")
+	ErrOIDCConnectionInactive  = errors.New("connection is disabled")
+	ErrUpstreamDiscovery       = errors.New("upstream discovery failed")
+	ErrUpstreamState           = errors.New("sign-in request expired or does not match")
+	ErrUpstreamTokenExchange   = errors.New("upstream token exchange failed")
+	ErrUpstreamIDToken         = errors.New("invalid upstream ID token")
+	ErrUpstreamEmailMissing    = errors.New("upstream account has no email address")
+	ErrUpstreamEmailUnverified = errors.New("upstream email address is not verified")
+	ErrUpstreamNotProvisioned  = errors.New("no local account for this upstream identity")
+)
+
+const oidcDiscoveryPath = "/.well-known/openid-configuration"
+
+var connectionSlugPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,99}$`)
+
+// OIDCConnectionRequest holds the admin-editable settings of an upstream connection
+type OIDCConnectionRequest struct {
+	Name            string            `json:"name"`
+	Slug            string            `json:"slug"`
+	DiscoveryURL    string            `json:"discovery_url"`
+	ClientID        string            `json:"client_id"`
+	ClientSecret    string            `json:"client_secret"` // Left empty on update to keep the current secret
+	Scopes          []string          `json:"scopes"`
+	EmailClaim      string            `json:"email_claim"`
+	NameClaim       string            `json:"name_claim"`
+	GroupsClaim     string            `json:"groups_claim"`
+	RoleMappings    map[string]string `json:"role_mappings"`
+	JITProvisioning *bool             `json:"jit_provisioning"`
+	IsActive        *bool             `json:"is_active"`
+}
+
+// UpstreamLoginState is what the browser carries between the redirect to the
+// upstream provider and the callback
+type UpstreamLoginState struct {
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+	ReturnTo string `json:"return_to"`
+	Link     string `json:"link,omitempty"` // Link request from IdentityService.BeginLink
+}
+
+// UpstreamLoginResult is a completed sign-in through an upstream provider
+type UpstreamLoginResult struct {
+	User        *models.User
+	Session     *models.Session
+	Provisioned bool // The user was created by this sign-in
+	Linked      bool // The account was linked to the user who asked for it; there is no Session
+}
+
+// oidcProviderMetadata is the subset of OpenID Provider Metadata the login flow uses
+type oidcProviderMetadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+type discoveryEntry struct {
+	metadata  *oidcProviderMetadata
+	fetchedAt time.Time
+}
+
+// UpstreamOIDCService signs users in through upstream OpenID Connect providers,
+// linking or provisioning local users and creating normal sessions for them
+type UpstreamOIDCService struct {
+	connRepo      *repository.OIDCConnectionRepository
+	accounts      *upstreamAccounts
+	publicBaseURL string
+	client        *http.Client
+	jwks          *jwksCache
+	cacheTTL      time.Duration
+
+	mu        sync.Mutex
+	discovery map[string]*discoveryEntry
+}
+
+// NewUpstreamOIDCService creates a new UpstreamOIDCService. Provider metadata and
+// signing keys are cached for cacheTTL.
+func NewUpstreamOIDCService(
+	connRepo *repository.OIDCConnectionRepository,
+	identityRepo *repository.UserIdentityRepository,
+	userRepo *repository.UserRepository,
+	roleRepo *repository.RoleRepository,
+	sessionService *SessionService,
+	auditRepo *repository.AuditLogRepository,
+	publicBaseURL string,
+	cacheTTL time.Duration,
+) *UpstreamOIDCService {
+	return &UpstreamOIDCService{
+		connRepo: connRepo,
+		accounts: &upstreamAccounts{
+			identityRepo:   identityRepo,
+			userRepo:       userRepo,
+			roleRepo:       roleRepo,
+			sessionService: sessionService,
+			auditRepo:      auditRepo,
+		},
+		publicBaseURL: strings.TrimRight(publicBaseURL, "/"),
+		client:        &http.Client{Timeout: 10 * time.Second},
+		jwks:          newJWKSCache(cacheTTL),
+		cacheTTL:      cacheTTL,
+		discovery:     make(map[string]*discoveryEntry),
+	}
+}
+
+// EnableLinking lets users link accounts at these providers to their account,
+// and applies the identity service's policy on linking by email
+func (s *UpstreamOIDCService) EnableLinking(identities *IdentityService) {
+	s.accounts.identities = identities
+}
+
+// RedirectURI is the callback URL to register with the upstream provider
+func (s *UpstreamOIDCService) RedirectURI(slug string) string {
+	return s.publicBaseURL + "/oauth2/login/oidc/" + slug + "/callback"
+}
+
+// CreateConnection adds an upstream provider
+func (s *UpstreamOIDCService) CreateConnection(ctx context.Context, req OIDCConnectionRequest) (*models.OIDCConnection, error) {
+	if _, err := s.connRepo.GetBySlug(ctx, req.Slug); err == nil {
+		return nil, ErrOIDCConnectionExists
+	} else if !errors.Is(err, repository.ErrOIDCConnectionNotFound) {
+		return nil, err
+	}
+
+	conn := &models.OIDCConnection{
+		ID:              uuid.New().String(),
+		JITProvisioning: true,
+		IsActive:        true,
+	}
+	if err := s.apply(conn, req); err != nil {
+		return nil, err
+	}
+
+	if err := s.connRepo.Create(ctx, conn); err != nil {
+		return nil, err
+	}
+
+	return s.withRedirectURI(conn), nil
+}
+
+// UpdateConnection replaces the settings of an upstream provider. The slug keys
+// linked identities, so it cannot change.
+func (s *UpstreamOIDCService) UpdateConnection(ctx context.Context, id string, req OIDCConnectionRequest) (*models.OIDCConnection, error) {
+	conn, err := s.connRepo.GetByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.Slug == "" {
+		req.Slug = conn.Slug
+	} else if req.Slug != conn.Slug {
+		return nil, ErrConnectionSlugFixed
+	}
+	if req.ClientSecret == "" {
+		req.ClientSecret = conn.ClientSecret
+	}
+
+	previousURL := conn.DiscoveryURL
+	if err := s.apply(conn, req); err != nil {
+		return nil, err
+	}
+
+	if err := s.connRepo.Update(ctx, conn); err != nil {
+		return nil, err
+	}
+
+	s.forgetDiscovery(previousURL)
+	return s.withRedirectURI(conn), nil
+}
+
+// GetConnection retrieves an upstream provider by ID
+func (s *UpstreamOIDCService) GetConnection(ctx context.Context, id string) (*models.OIDCConnection, error) {
+	conn, err := s.connRepo.GetByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return s.withRedirectURI(conn), nil
+}
+
+// ListConnections retrieves all upstream providers
+func (s *UpstreamOIDCService) ListConnections(ctx context.Context) ([]*models.OIDCConnection, error) {
+	conns, err := s.connRepo.GetAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, conn := range conns {
+		s.withRedirectURI(conn)
+	}
+	return conns, nil
+}
+
+// ListActiveConnections retrieves the providers offered on the login page
+func (s *UpstreamOIDCService) ListActiveConnections(ctx context.Context) ([]*models.OIDCConnection, error) {
+	return s.connRepo.GetActive(ctx)
+}
+
+// DeleteConnection removes an upstream provider. Identities linked through it
+// are kept, so re-adding the same slug restores them.
+func (s *UpstreamOIDCService) DeleteConnection(ctx context.Context, id string) error {
+	conn, err := s.connRepo.GetByID(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	if err := s.connRepo.Delete(ctx, id); err != nil {
+		return err
+	}
+
+	s.forgetDiscovery(conn.DiscoveryURL)
+	return nil
+}
+
+// apply validates req and copies it onto conn
+func (s *UpstreamOIDCService) apply(conn *models.OIDCConnection, req OIDCConnectionRequest) error {
+	if req.Name == "" || req.Slug == "" || req.DiscoveryURL == "" || req.ClientID == "" {
+		return ErrOIDCConnectionRequired
+	}
+	if !connectionSlugPattern.MatchString(req.Slug) {
+		return ErrInvalidConnectionSlug
+	}
+	if err := validateFetchURL(req.DiscoveryURL, ErrInvalidDiscoveryURL, ErrInsecureDiscoveryURL); err != nil {
+		return err
+	}
+
+	conn.Name = req.Name
+	conn.Slug = req.Slug
+	conn.DiscoveryURL = req.DiscoveryURL
+	conn.ClientID = req.ClientID
+	conn.ClientSecret = req.ClientSecret
+	conn.Scopes = req.Scopes
+	if len(conn.Scopes) == 0 {
+		conn.Scopes = []string{"openid", "email", "profile"}
+	}
+	conn.EmailClaim = req.EmailClaim
+	if conn.EmailClaim == "" {
+		conn.EmailClaim = "email"
+	}
+	conn.NameClaim = req.NameClaim
+	if conn.NameClaim == "" {
+		conn.NameClaim = "name"
+	}
+	conn.GroupsClaim = req.GroupsClaim
+	conn.RoleMappings = req.RoleMappings
+	if conn.RoleMappings == nil {
+		conn.RoleMappings = map[string]string{}
+	}
+	if req.JITProvisioning != nil {
+		conn.JITProvisioning = *req.JITProvisioning
+	}
+	if req.IsActive != nil {
+		conn.IsActive = *req.IsActive
+	}
+
+	return nil
+}
+
+func (s *UpstreamOIDCService) withRedirectURI(conn *models.OIDCConnection) *models.OIDCConnection {
+	conn.RedirectURI = s.RedirectURI(conn.Slug)
+	return conn
+}
+
+// BeginLogin starts a sign-in with the provider behind slug. It returns the URL
+// to send the browser to and the state the browser must bring back to the callback.
+func (s *UpstreamOIDCService) BeginLogin(ctx context.Context, slug, returnTo string) (string, *UpstreamLoginState, error) {
+	conn, err := s.activeConnection(ctx, slug)
+	if err != nil {
+		return "", nil, err
+	}
+
+	metadata, err := s.discover(ctx, conn.DiscoveryURL)
+	if err != nil {
+		return "", nil, err
+	}
+
+	verifier, err := utils.GenerateCodeVerifier()
+	if err != nil {
+		return "", nil, err
+	}
+
+	pending := &UpstreamLoginState{
+		State:    generateRandomToken(32),
+		Nonce:    generateRandomToken(32),
+		Verifier: verifier,
+		ReturnTo: returnTo,
+	}
+
+	scopes := []string(conn.Scopes)
+	if !slices.Contains(scopes, "openid") {
+		scopes = append([]string{"openid"}, scopes...)
+	}
+
+	authURL, err := url.Parse(metadata.AuthorizationEndpoint)
+	if err != nil {
+		return "", nil, fmt.Errorf("%w: bad authorization_endpoint", ErrUpstreamDiscovery)
+	}
+	query := authURL.Query()
+	query.Set("response_type", "code")
+	query.Set("client_id", conn.ClientID)
+	query.Set("redirect_uri", s.RedirectURI(conn.Slug))
+	query.Set("scope", strings.Join(scopes, " "))
+	query.Set("state", pending.State)
+	query.Set("nonce", pending.Nonce)
+	query.Set("code_challenge", utils.GenerateCodeChallenge(verifier))
+	query.Set("code_challenge_method", "S256")
+	authURL.RawQuery = query.Encode()
+
+	return authURL.String(), pending, nil
+}
+
+// CompleteLogin finishes a sign-in started by BeginLogin: it checks the returned
+// state, exchanges the code, validates the upstream ID token, finds, links or
+// provisions the local user and creates a session for them.
+func (s *UpstreamOIDCService) CompleteLogin(
+	ctx context.Context,
+	slug string,
+	pending *UpstreamLoginState,
+	state, code, ipAddress, userAgent string,
+) (*UpstreamLoginResult, error) {
+	result, err := s.completeLogin(ctx, slug, pending, state, code, ipAddress, userAgent)
+	if err != nil {
+		s.accounts.logAudit(ctx, nil, "login_failed", ipAddress, userAgent, fmt.Sprintf("connection=%s error=%v", slug, err))
+		return nil, err
+	}
+
+	details := "connection=" + slug
+	if result.Linked {
+		s.accounts.logAudit(ctx, &result.User.ID, "identity_linked", ipAddress, userAgent, details)
+		return result, nil
+	}
+	if result.Provisioned {
+		s.accounts.logAudit(ctx, &result.User.ID, "user_provisioned", ipAddress, userAgent, details)
+	}
+	s.accounts.logAudit(ctx, &result.User.ID, "login_success", ipAddress, userAgent, details)
+
+	return result, nil
+}
+
+func (s *UpstreamOIDCService) completeLogin(
+	ctx context.Context,
+	slug string,
+	pending *UpstreamLoginState,
+	state, code, ipAddress, userAgent string,
+) (*UpstreamLoginResult, error) {
+	if pending == nil || pending.State == "" || subtle.ConstantTimeCompare([]byte(pending.State), []byte(state)) != 1 {
+		return nil, ErrUpstreamState
+	}
+
+	conn, err := s.activeConnection(ctx, slug)
+	if err != nil {
+		return nil, err
+	}
+
+	metadata, err := s.discover(ctx, conn.DiscoveryURL)
+	if err != nil {
+		return nil, err
+	}
+
+	idToken, err := s.exchangeCode(ctx, conn, metadata, code, pending.Verifier)
+	if err != nil {
+		return nil, err
+	}
+
+	claims, err := s.verifyIDToken(ctx, conn, metadata, idToken, pending.Nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	subject, _ := claims.GetSubject()
+	account := &upstreamAccount{
+		Provider:      conn.Slug,
+		Subject:       subject,
+		Email:         stringClaim(claims, conn.EmailClaim),
+		Name:          stringClaim(claims, conn.NameClaim),
+		EmailVerified: boolClaim(claims, "email_verified"),
+	}
+	if conn.GroupsClaim != "" {
+		account.Groups = listClaim(claims, conn.GroupsClaim)
+	}
+
+	if pending.Link != "" {
+		return s.accounts.link(ctx, account, pending.Link)
+	}
+
+	return s.accounts.signIn(ctx, account, upstreamPolicy{
+		Connection:      conn.Slug,
+		JITProvisioning: conn.JITProvisioning,
+		LinkByEmail:     s.accounts.linkByEmail(),
+		RoleMappings:    conn.RoleMappings,
+	}, ipAddress, userAgent)
+}
+
+func (s *UpstreamOIDCService) activeConnection(ctx context.Context, slug string) (*models.OIDCConnection, error) {
+	conn, err := s.connRepo.GetBySlug(ctx, slug)
+	if err != nil {
+		return nil, err
+	}
+	if !conn.IsActive {
+		return nil, ErrOIDCConnectionInactive
+	}
+	return conn, nil
+}
+
+// exchangeCode redeems the authorization code at the provider's token endpoint
+// and returns the ID token
+func (s *UpstreamOIDCService) exchangeCode(ctx context.Context, conn *models.OIDCConnection, metadata *oidcProviderMetadata, code, verifier string) (string, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {s.RedirectURI(conn.Slug)},
+		"code_verifier": {verifier},
+		"client_id":     {conn.ClientID},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, metadata.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if conn.ClientSecret != "" {
+		// client_secret_basic form-encodes both values (RFC 6749 section 2.3.1)
+		req.SetBasicAuth(url.QueryEscape(conn.ClientID), url.QueryEscape(conn.ClientSecret))
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrUpstreamTokenExchange, err)
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		IDToken string `json:"id_token"`
+		Error   string `json:"error"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&body); err != nil {
+		return "", fmt.Errorf("%w: unreadable response (status %d)", ErrUpstreamTokenExchange, resp.StatusCode)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%w: %s", ErrUpstreamTokenExchange, body.Error)
+	}
+	if body.IDToken == "" {
+		return "", fmt.Errorf("%w: no id_token in response", ErrUpstreamTokenExchange)
+	}
+
+	return body.IDToken, nil
+}
+
+// verifyIDToken validates an upstream ID token as OpenID Connect Core section
+// 3.1.3.7 requires: signature, issuer, audience, authorized party, expiry and nonce
+func (s *UpstreamOIDCService) verifyIDToken(ctx context.Context, conn *models.OIDCConnection, metadata *oidcProviderMetadata, idToken, nonce string) (jwt.MapClaims, error) {
+	parser := jwt.NewParser(
+		jwt.WithValidMethods(assertionMethods),
+		jwt.WithIssuer(metadata.Issuer),
+		jwt.WithAudience(conn.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(time.Minute),
+	)
+
+	claims := jwt.MapClaims{}
+	if _, err := parser.ParseWithClaims(idToken, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return s.jwks.key(ctx, metadata.JWKSURI, kid)
+	}); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUpstreamIDToken, err)
+	}
+
+	if subtle.ConstantTimeCompare([]byte(stringClaim(claims, "nonce")), []byte(nonce)) != 1 {
+		return nil, fmt.Errorf("%w: nonce mismatch", ErrUpstreamIDToken)
+	}
+
+	if audience, _ := claims.GetAudience(); len(audience) > 1 && stringClaim(claims, "azp") != conn.ClientID {
+		return nil, fmt.Errorf("%w: azp mismatch", ErrUpstreamIDToken)
+	}
+
+	if subject, _ := claims.GetSubject(); subject == "" {
+		return nil, fmt.Errorf("%w: missing sub", ErrUpstreamIDToken)
+	}
+
+	return claims, nil
+}
+
+// discover returns the provider metadata behind a discovery URL, which may name
+// either the issuer or its openid-configuration document
+func (s *UpstreamOIDCService) discover(ctx context.Context, discoveryURL string) (*oidcProviderMetadata, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if entry := s.discovery[discoveryURL]; entry != nil && time.Since(entry.fetchedAt) <= s.cacheTTL {
+		return entry.metadata, nil
+	}
+
+	documentURL := discoveryURL
+	if !strings.HasSuffix(documentURL, oidcDiscoveryPath) {
+		documentURL = strings.TrimRight(documentURL, "/") + oidcDiscoveryPath
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, documentURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUpstreamDiscovery, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: unexpected status %d", ErrUpstreamDiscovery, resp.StatusCode)
+	}
+
+	var metadata oidcProviderMetadata
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&metadata); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUpstreamDiscovery, err)
+	}
+	if metadata.AuthorizationEndpoint == "" || metadata.TokenEndpoint == "" || metadata.JWKSURI == "" {
+		return nil, fmt.Errorf("%w: incomplete provider metadata", ErrUpstreamDiscovery)
+	}
+
+	// The issuer must be the URL the document was retrieved from (OpenID Connect
+	// Discovery section 4.3), otherwise another provider could be impersonated
+	expected := strings.TrimSuffix(documentURL, oidcDiscoveryPath)
+	if strings.TrimRight(metadata.Issuer, "/") != strings.TrimRight(expected, "/") {
+		return nil, fmt.Errorf("%w: issuer %q does not match %q", ErrUpstreamDiscovery, metadata.Issuer, expected)
+	}
+
+	s.discovery[discoveryURL] = &discoveryEntry{metadata: &metadata, fetchedAt: time.Now()}
+	return &metadata, nil
+}
+
+func (s *UpstreamOIDCService) forgetDiscovery(discoveryURL string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.discovery, discoveryURL)
+}
+
+func stringClaim(claims jwt.MapClaims, name string) string {
+	value, _ := claims[name].(string)
+	return value
+}
+
+// boolClaim reads a boolean claim; some providers send email_verified as a string
+func boolClaim(claims jwt.MapClaims, name string) bool {
+	switch value := claims[name].(type) {
+	case bool:
+		return value
+	case string:
+		return value == "true"
+	}
+	return false
+}
+
+// listClaim reads a claim holding either a list of strings or a single string
+func listClaim(claims jwt.MapClaims, name string) []string {
+	switch value := claims[name].(type) {
+	case string:
+		return []string{value}
+	case []interface{}:
+		values := make([]string, 0, len(value))
+		for _, item := range value {
+			if str, ok := item.(string); ok {
+				values = append(values, str)
+			}
+		}
+		return values
+	}
+	return nil
+}
@@ -0,0 +1,231 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sso-project/sso-server/internal/repository"
+	"github.com/sso-project/sso-server/internal/testutil"
+	"github.com/sso-project/sso-server/internal/utils"
+)
+
+// mockIdP is a minimal OpenID Provider: discovery, JWKS and a token endpoint that
+// returns whatever ID token claims the test sets for the next code
+type mockIdP struct {
+	server    *httptest.Server
+	key       *rsa.PrivateKey
+	claims    jwt.MapClaims
+	challenge string
+}
+
+func newMockIdP(t *testing.T) *mockIdP {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	jwks, _ := testJWKS(t, key, "idp-key-1")
+
+	idp := &mockIdP{key: key}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 idp.server.URL,
+			"authorization_endpoint": idp.server.URL + "/authorize",
+			"token_endpoint":         idp.server.URL + "/token",
+			"jwks_uri":               jwks.URL,
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		clientID, secret, _ := r.BasicAuth()
+		verifier := r.FormValue("code_verifier")
+		if clientID != "sso" || secret != "upstream-secret" || r.FormValue("code") != "good-code" ||
+			utils.GenerateCodeChallenge(verifier) != idp.challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{
+			"access_token": "upstream-access-token",
+			"token_type":   "Bearer",
+			"id_token":     signAssertion(t, idp.key, "idp-key-1", idp.claims),
+		})
+	})
+	idp.server = httptest.NewServer(mux)
+	t.Cleanup(idp.server.Close)
+	return idp
+}
+
+func TestUpstreamOIDCService_SignIn(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+	defer testutil.TeardownTestDB(t, db)
+
+	userRepo := repository.NewUserRepository(db)
+	sessionService := NewSessionService(repository.NewDatabaseSessionStore(db), time.Hour)
+	upstreamService := NewUpstreamOIDCService(
+		repository.NewOIDCConnectionRepository(db.DB),
+		repository.NewUserIdentityRepository(db.DB),
+		userRepo,
+		repository.NewRoleRepository(db),
+		sessionService,
+		repository.NewAuditLogRepository(db),
+		"https://sso.example.com",
+		time.Hour,
+	)
+	ctx := context.Background()
+	idp := newMockIdP(t)
+	testutil.CreateTestRole(t, db, "engineer")
+
+	_, err := upstreamService.CreateConnection(ctx, OIDCConnectionRequest{
+		Name:         "Corp",
+		Slug:         "corp",
+		DiscoveryURL: "http://idp.example.com",
+		ClientID:     "sso",
+	})
+	assert.ErrorIs(t, err, ErrInsecureDiscoveryURL)
+
+	conn, err := upstreamService.CreateConnection(ctx, OIDCConnectionRequest{
+		Name:         "Corp",
+		Slug:         "corp",
+		DiscoveryURL: idp.server.URL,
+		ClientID:     "sso",
+		ClientSecret: "upstream-secret",
+		GroupsClaim:  "groups",
+		RoleMappings: map[string]string{"eng": "engineer"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "https://sso.example.com/oauth2/login/oidc/corp/callback", conn.RedirectURI)
+	assert.Equal(t, []string{"openid", "email", "profile"}, []string(conn.Scopes))
+
+	_, err = upstreamService.CreateConnection(ctx, OIDCConnectionRequest{
+		Name:         "Corp again",
+		Slug:         "corp",
+		DiscoveryURL: idp.server.URL,
+		ClientID:     "sso",
+	})
+	assert.ErrorIs(t, err, ErrOIDCConnectionExists)
+
+	// begin sends the browser to the provider and returns what it must bring back
+	begin := func() *UpstreamLoginState {
+		authURL, pending, err := upstreamService.BeginLogin(ctx, "corp", "/oauth2/authorize?client_id=app")
+		require.NoError(t, err)
+		u, err := url.Parse(authURL)
+		require.NoError(t, err)
+		assert.Equal(t, idp.server.URL+"/authorize", u.Scheme+"://"+u.Host+u.Path)
+		assert.Equal(t, conn.RedirectURI, u.Query().Get("redirect_uri"))
+		assert.Equal(t, pending.State, u.Query().Get("state"))
+		assert.Equal(t, "S256", u.Query().Get("code_challenge_method"))
+		idp.challenge = u.Query().Get("code_challenge")
+		return pending
+	}
+	idTokenClaims := func(pending *UpstreamLoginState, sub, email string, verified bool) jwt.MapClaims {
+		return jwt.MapClaims{
+			"iss":            idp.server.URL,
+			"sub":            sub,
+			"aud":            "sso",
+			"exp":            time.Now().Add(5 * time.Minute).Unix(),
+			"iat":            time.Now().Unix(),
+			"nonce":          pending.Nonce,
+			"email":          email,
+			"email_verified": verified,
+			"name":           "Alice Upstream",
+			"groups":         []string{"eng", "unmapped"},
+		}
+	}
+
+	// A mismatched state never reaches the provider
+	pending := begin()
+	_, err = upstreamService.CompleteLogin(ctx, "corp", pending, "forged-state", "good-code", "127.0.0.1", "test")
+	assert.ErrorIs(t, err, ErrUpstreamState)
+
+	// First sign-in provisions the user, maps groups to roles and creates a session
+	idp.claims = idTokenClaims(pending, "alice-sub", "alice@corp.example.com", true)
+	result, err := upstreamService.CompleteLogin(ctx, "corp", pending, pending.State, "good-code", "127.0.0.1", "test")
+	require.NoError(t, err)
+	assert.True(t, result.Provisioned)
+	assert.Equal(t, "alice@corp.example.com", result.User.Email)
+	assert.Equal(t, "Alice Upstream", result.User.Name)
+	assert.True(t, result.User.EmailVerified)
+
+	session, err := sessionService.ValidateSession(ctx, result.Session.SessionToken)
+	require.NoError(t, err)
+	assert.Equal(t, result.User.ID, session.UserID)
+
+	user, err := userRepo.GetByID(ctx, result.User.ID)
+	require.NoError(t, err)
+	require.Len(t, user.Roles, 1)
+	assert.Equal(t, "engineer", user.Roles[0].Name)
+
+	// The same upstream account signs in as the same user
+	pending = begin()
+	idp.claims = idTokenClaims(pending, "alice-sub", "alice@corp.example.com", true)
+	again, err := upstreamService.CompleteLogin(ctx, "corp", pending, pending.State, "good-code", "127.0.0.1", "test")
+	require.NoError(t, err)
+	assert.False(t, again.Provisioned)
+	assert.Equal(t, result.User.ID, again.User.ID)
+
+	// An existing local account is only linked when the provider verified the email
+	local := testutil.CreateTestUser(t, db, "bob@corp.example.com")
+	pending = begin()
+	idp.claims = idTokenClaims(pending, "bob-sub", "bob@corp.example.com", false)
+	_, err = upstreamService.CompleteLogin(ctx, "corp", pending, pending.State, "good-code", "127.0.0.1", "test")
+	assert.ErrorIs(t, err, ErrUpstreamEmailUnverified)
+
+	pending = begin()
+	idp.claims = idTokenClaims(pending, "bob-sub", "bob@corp.example.com", true)
+	linked, err := upstreamService.CompleteLogin(ctx, "corp", pending, pending.State, "good-code", "127.0.0.1", "test")
+	require.NoError(t, err)
+	assert.False(t, linked.Provisioned)
+	assert.Equal(t, local.ID, linked.User.ID)
+
+	// ID tokens minted for another sign-in or another client are rejected
+	pending = begin()
+	idp.claims = idTokenClaims(pending, "alice-sub", "alice@corp.example.com", true)
+	idp.claims["nonce"] = "replayed-nonce"
+	_, err = upstreamService.CompleteLogin(ctx, "corp", pending, pending.State, "good-code", "127.0.0.1", "test")
+	assert.ErrorIs(t, err, ErrUpstreamIDToken)
+
+	pending = begin()
+	idp.claims = idTokenClaims(pending, "alice-sub", "alice@corp.example.com", true)
+	idp.claims["aud"] = "another-client"
+	_, err = upstreamService.CompleteLogin(ctx, "corp", pending, pending.State, "good-code", "127.0.0.1", "test")
+	assert.ErrorIs(t, err, ErrUpstreamIDToken)
+
+	pending = begin()
+	idp.claims = idTokenClaims(pending, "alice-sub", "alice@corp.example.com", true)
+	_, err = upstreamService.CompleteLogin(ctx, "corp", pending, pending.State, "stolen-code", "127.0.0.1", "test")
+	assert.ErrorIs(t, err, ErrUpstreamTokenExchange)
+
+	// Without just-in-time provisioning unknown accounts are turned away
+	jit := false
+	_, err = upstreamService.UpdateConnection(ctx, conn.ID, OIDCConnectionRequest{
+		Name:            "Corp",
+		DiscoveryURL:    idp.server.URL,
+		ClientID:        "sso",
+		JITProvisioning: &jit,
+	})
+	require.NoError(t, err)
+
+	pending = begin()
+	idp.claims = idTokenClaims(pending, "carol-sub", "carol@corp.example.com", true)
+	_, err = upstreamService.CompleteLogin(ctx, "corp", pending, pending.State, "good-code", "127.0.0.1", "test")
+	assert.ErrorIs(t, err, ErrUpstreamNotProvisioned)
+
+	_, err = upstreamService.UpdateConnection(ctx, conn.ID, OIDCConnectionRequest{
+		Name:         "Corp",
+		Slug:         "corp-renamed",
+		DiscoveryURL: idp.server.URL,
+		ClientID:     "sso",
+	})
+	assert.ErrorIs(t, err, ErrConnectionSlugFixed)
+}
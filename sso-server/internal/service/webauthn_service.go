@@ -0,0 +1,500 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/google/uuid"
+	"github.com/sso-project/sso-server/internal/models"
+	"github.com/sso-project/sso-server/internal/repository"
+)
+
+var (
+	ErrWebAuthnChallengeExpired   = errors.New("security key request expired or was already used, please try again")
+	ErrWebAuthnVerificationFailed = errors.New("security key could not be verified")
+	ErrWebAuthnCredentialExists   = errors.New("this security key is already registered")
+	ErrWebAuthnCredentialCloned   = errors.New("security key may have been cloned and was refused")
+	ErrNoWebAuthnCredentials      = errors.New("no security keys are registered for this account")
+)
+
+// WebAuthn ceremonies, each with its own challenges
+const (
+	webauthnRegistration = "registration"
+	webauthnLogin        = "login"
+	webauthnSecondFactor = "second_factor"
+)
+
+// webauthnMaxNameLength is the longest credential name kept
+const webauthnMaxNameLength = 100
+
+// WebAuthnOptions configures the relying party
+type WebAuthnOptions struct {
+	RPID         string        // Domain credentials are scoped to; defaults to the host of the first origin
+	RPName       string        // Shown by the browser while registering
+	Origins      []string      // Origins, or URLs, of the pages that run ceremonies
+	ChallengeTTL time.Duration // How long a ceremony can take
+}
+
+// WebAuthnService registers security keys and passkeys and verifies them, as
+// second factor or for passwordless sign-in
+type WebAuthnService struct {
+	webauthn     *webauthn.WebAuthn
+	repo         *repository.WebAuthnRepository
+	userRepo     *repository.UserRepository
+	challengeTTL time.Duration
+}
+
+// NewWebAuthnService creates a new WebAuthn service
+func NewWebAuthnService(repo *repository.WebAuthnRepository, userRepo *repository.UserRepository, opts WebAuthnOptions) (*WebAuthnService, error) {
+	// Pages may be given by URL; only their origin matters
+	var origins []string
+	for _, origin := range opts.Origins {
+		if origin = strings.TrimSpace(origin); origin == "" {
+			continue
+		}
+		u, err := url.Parse(origin)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return nil, fmt.Errorf("invalid webauthn origin %q", origin)
+		}
+		if origin = u.Scheme + "://" + u.Host; !slices.Contains(origins, origin) {
+			origins = append(origins, origin)
+		}
+	}
+	if len(origins) == 0 {
+		return nil, errors.New("webauthn requires at least one origin")
+	}
+
+	rpID := opts.RPID
+	if rpID == "" {
+		u, _ := url.Parse(origins[0])
+		rpID = u.Hostname()
+	}
+
+	w, err := webauthn.New(&webauthn.Config{
+		RPID:          rpID,
+		RPDisplayName: opts.RPName,
+		RPOrigins:     origins,
+		Timeouts: webauthn.TimeoutsConfig{
+			Login:        webauthn.TimeoutConfig{Timeout: opts.ChallengeTTL, TimeoutUVD: opts.ChallengeTTL},
+			Registration: webauthn.TimeoutConfig{Timeout: opts.ChallengeTTL, TimeoutUVD: opts.ChallengeTTL},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid webauthn configuration: %w", err)
+	}
+
+	return &WebAuthnService{
+		webauthn:     w,
+		repo:         repo,
+		userRepo:     userRepo,
+		challengeTTL: opts.ChallengeTTL,
+	}, nil
+}
+
+// webauthnUser presents a user and their credentials to the webauthn library.
+// The user handle is the user ID.
+type webauthnUser struct {
+	user        *models.User
+	credentials []webauthn.Credential
+}
+
+func (u *webauthnUser) WebAuthnID() []byte {
+	return []byte(u.user.ID)
+}
+
+func (u *webauthnUser) WebAuthnName() string {
+	return u.user.Email
+}
+
+func (u *webauthnUser) WebAuthnDisplayName() string {
+	if u.user.Name != "" {
+		return u.user.Name
+	}
+	return u.user.Email
+}
+
+func (u *webauthnUser) WebAuthnCredentials() []webauthn.Credential {
+	return u.credentials
+}
+
+// loadUser returns a user with their credentials
+func (s *WebAuthnService) loadUser(ctx context.Context, userID string) (*webauthnUser, []*models.WebAuthnCredential, error) {
+	user, err := s.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	stored, err := s.repo.GetCredentialsByUserID(ctx, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	u := &webauthnUser{user: user}
+	for _, credential := range stored {
+		c, err := toLibraryCredential(credential)
+		if err != nil {
+			return nil, nil, err
+		}
+		u.credentials = append(u.credentials, c)
+	}
+	return u, stored, nil
+}
+
+// toLibraryCredential converts a stored credential for the webauthn library
+func toLibraryCredential(credential *models.WebAuthnCredential) (webauthn.Credential, error) {
+	id, err := base64.RawURLEncoding.DecodeString(credential.CredentialID)
+	if err != nil {
+		return webauthn.Credential{}, fmt.Errorf("credential %s: %w", credential.ID, err)
+	}
+
+	var aaguid []byte
+	if parsed, err := uuid.Parse(credential.AAGUID); err == nil {
+		aaguid = parsed[:]
+	}
+
+	transports := make([]protocol.AuthenticatorTransport, 0, len(credential.Transports))
+	for _, transport := range credential.Transports {
+		transports = append(transports, protocol.AuthenticatorTransport(transport))
+	}
+
+	return webauthn.Credential{
+		ID:              id,
+		PublicKey:       credential.PublicKey,
+		AttestationType: credential.AttestationType,
+		Transport:       transports,
+		Flags: webauthn.CredentialFlags{
+			BackupEligible: credential.BackupEligible,
+			BackupState:    credential.BackupState,
+		},
+		Authenticator: webauthn.Authenticator{
+			AAGUID:       aaguid,
+			SignCount:    credential.SignCount,
+			CloneWarning: credential.CloneWarning,
+		},
+	}, nil
+}
+
+// BeginRegistration starts registering a new credential for a user. It returns
+// the options for navigator.credentials.create() and the ID of the challenge to
+// finish with.
+func (s *WebAuthnService) BeginRegistration(ctx context.Context, userID string) (*protocol.CredentialCreation, string, error) {
+	user, _, err := s.loadUser(ctx, userID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	descriptors := webauthn.Credentials(user.credentials).CredentialDescriptors()
+	creation, session, err := s.webauthn.BeginRegistration(user,
+		webauthn.WithExclusions(descriptors),
+		webauthn.WithAuthenticatorSelection(protocol.AuthenticatorSelection{
+			ResidentKey:      protocol.ResidentKeyRequirementPreferred,
+			UserVerification: protocol.VerificationPreferred,
+		}),
+		webauthn.WithConveyancePreference(protocol.PreferNoAttestation),
+	)
+	if err != nil {
+		return nil, "", err
+	}
+
+	challengeID, err := s.saveChallenge(ctx, webauthnRegistration, userID, session)
+	if err != nil {
+		return nil, "", err
+	}
+	return creation, challengeID, nil
+}
+
+// FinishRegistration verifies the authenticator's response to a registration
+// challenge and stores the new credential under name
+func (s *WebAuthnService) FinishRegistration(ctx context.Context, userID, challengeID, name string, response []byte) (*models.WebAuthnCredential, error) {
+	session, err := s.takeChallenge(ctx, webauthnRegistration, challengeID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	parsed, err := protocol.ParseCredentialCreationResponseBytes(response)
+	if err != nil {
+		return nil, verificationFailed(err)
+	}
+	user, _, err := s.loadUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	created, err := s.webauthn.CreateCredential(user, *session, parsed)
+	if err != nil {
+		return nil, verificationFailed(err)
+	}
+
+	credentialID := base64.RawURLEncoding.EncodeToString(created.ID)
+	if _, err := s.repo.GetByCredentialID(ctx, credentialID); err == nil {
+		return nil, ErrWebAuthnCredentialExists
+	} else if !errors.Is(err, repository.ErrWebAuthnCredentialNotFound) {
+		return nil, err
+	}
+
+	credential := &models.WebAuthnCredential{
+		ID:              uuid.New().String(),
+		UserID:          userID,
+		Name:            credentialName(name, created.Flags.BackupEligible),
+		CredentialID:    credentialID,
+		PublicKey:       created.PublicKey,
+		AttestationType: created.AttestationType,
+		SignCount:       created.Authenticator.SignCount,
+		Transports:      models.StringSlice{},
+		BackupEligible:  created.Flags.BackupEligible,
+		BackupState:     created.Flags.BackupState,
+	}
+	if aaguid, err := uuid.FromBytes(created.Authenticator.AAGUID); err == nil {
+		credential.AAGUID = aaguid.String()
+	}
+	for _, transport := range created.Transport {
+		credential.Transports = append(credential.Transports, string(transport))
+	}
+
+	if err := s.repo.CreateCredential(ctx, credential); err != nil {
+		return nil, err
+	}
+	return credential, nil
+}
+
+// BeginLogin starts a passwordless sign-in with a passkey. The authenticator
+// picks the account and must verify the user, with a PIN or biometrics, so the
+// passkey stands in for both factors.
+func (s *WebAuthnService) BeginLogin(ctx context.Context) (*protocol.CredentialAssertion, string, error) {
+	assertion, session, err := s.webauthn.BeginDiscoverableLogin(webauthn.WithUserVerification(protocol.VerificationRequired))
+	if err != nil {
+		return nil, "", err
+	}
+
+	challengeID, err := s.saveChallenge(ctx, webauthnLogin, "", session)
+	if err != nil {
+		return nil, "", err
+	}
+	return assertion, challengeID, nil
+}
+
+// FinishLogin verifies the response to a passwordless sign-in challenge and
+// returns the user the passkey belongs to, also along ErrWebAuthnCredentialCloned.
+// It does not check whether the user may sign in.
+func (s *WebAuthnService) FinishLogin(ctx context.Context, challengeID string, response []byte) (*models.User, *models.WebAuthnCredential, error) {
+	session, err := s.takeChallenge(ctx, webauthnLogin, challengeID, "")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	parsed, err := protocol.ParseCredentialRequestResponseBytes(response)
+	if err != nil {
+		return nil, nil, verificationFailed(err)
+	}
+
+	var stored []*models.WebAuthnCredential
+	found, validated, err := s.webauthn.ValidatePasskeyLogin(func(rawID, userHandle []byte) (webauthn.User, error) {
+		user, credentials, err := s.loadUser(ctx, string(userHandle))
+		stored = credentials
+		return user, err
+	}, *session, parsed)
+	if err != nil {
+		return nil, nil, verificationFailed(err)
+	}
+
+	user := found.(*webauthnUser).user
+	credential, err := s.recordUse(ctx, stored, validated)
+	return user, credential, err
+}
+
+// BeginSecondFactor starts verifying one of a user's credentials as second
+// factor after their password was checked
+func (s *WebAuthnService) BeginSecondFactor(ctx context.Context, userID string) (*protocol.CredentialAssertion, string, error) {
+	user, _, err := s.loadUser(ctx, userID)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(user.credentials) == 0 {
+		return nil, "", ErrNoWebAuthnCredentials
+	}
+
+	assertion, session, err := s.webauthn.BeginLogin(user, webauthn.WithUserVerification(protocol.VerificationDiscouraged))
+	if err != nil {
+		return nil, "", err
+	}
+
+	challengeID, err := s.saveChallenge(ctx, webauthnSecondFactor, userID, session)
+	if err != nil {
+		return nil, "", err
+	}
+	return assertion, challengeID, nil
+}
+
+// FinishSecondFactor verifies the response to a second factor challenge
+func (s *WebAuthnService) FinishSecondFactor(ctx context.Context, userID, challengeID string, response []byte) (*models.WebAuthnCredential, error) {
+	session, err := s.takeChallenge(ctx, webauthnSecondFactor, challengeID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	parsed, err := protocol.ParseCredentialRequestResponseBytes(response)
+	if err != nil {
+		return nil, verificationFailed(err)
+	}
+	user, stored, err := s.loadUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	validated, err := s.webauthn.ValidateLogin(user, *session, parsed)
+	if err != nil {
+		return nil, verificationFailed(err)
+	}
+
+	return s.recordUse(ctx, stored, validated)
+}
+
+// recordUse saves the sign counter and backup state of a credential after it
+// was verified. A counter that did not increase means the private key may
+// exist twice; the credential is flagged and refused from then on.
+func (s *WebAuthnService) recordUse(ctx context.Context, stored []*models.WebAuthnCredential, validated *webauthn.Credential) (*models.WebAuthnCredential, error) {
+	credentialID := base64.RawURLEncoding.EncodeToString(validated.ID)
+	var credential *models.WebAuthnCredential
+	for _, c := range stored {
+		if c.CredentialID == credentialID {
+			credential = c
+		}
+	}
+	if credential == nil {
+		return nil, ErrWebAuthnVerificationFailed
+	}
+
+	if credential.CloneWarning || validated.Authenticator.CloneWarning {
+		if !credential.CloneWarning {
+			credential.CloneWarning = true
+			if err := s.repo.UpdateCredential(ctx, credential); err != nil {
+				return nil, err
+			}
+		}
+		return credential, ErrWebAuthnCredentialCloned
+	}
+
+	now := time.Now()
+	credential.SignCount = validated.Authenticator.SignCount
+	credential.BackupState = validated.Flags.BackupState
+	credential.LastUsedAt = &now
+	if err := s.repo.UpdateCredential(ctx, credential); err != nil {
+		return nil, err
+	}
+	return credential, nil
+}
+
+// HasCredentials reports whether a user registered any credential
+func (s *WebAuthnService) HasCredentials(ctx context.Context, userID string) (bool, error) {
+	count, err := s.repo.CountByUserID(ctx, userID)
+	return count > 0, err
+}
+
+// ListCredentials returns the credentials of a user
+func (s *WebAuthnService) ListCredentials(ctx context.Context, userID string) ([]*models.WebAuthnCredential, error) {
+	return s.repo.GetCredentialsByUserID(ctx, userID)
+}
+
+// RenameCredential changes the name of a user's credential
+func (s *WebAuthnService) RenameCredential(ctx context.Context, userID, id, name string) (*models.WebAuthnCredential, error) {
+	credential, err := s.repo.GetCredential(ctx, userID, id)
+	if err != nil {
+		return nil, err
+	}
+
+	credential.Name = credentialName(name, credential.BackupEligible)
+	if err := s.repo.UpdateCredential(ctx, credential); err != nil {
+		return nil, err
+	}
+	return credential, nil
+}
+
+// DeleteCredential removes a user's credential
+func (s *WebAuthnService) DeleteCredential(ctx context.Context, userID, id string) error {
+	return s.repo.DeleteCredential(ctx, userID, id)
+}
+
+// DeleteAllCredentials removes every credential of a user and returns how many
+// there were
+func (s *WebAuthnService) DeleteAllCredentials(ctx context.Context, userID string) (int, error) {
+	deleted, err := s.repo.DeleteCredentialsByUserID(ctx, userID)
+	return int(deleted), err
+}
+
+// saveChallenge stores the state of a ceremony and returns its ID
+func (s *WebAuthnService) saveChallenge(ctx context.Context, ceremony, userID string, session *webauthn.SessionData) (string, error) {
+	data, err := json.Marshal(session)
+	if err != nil {
+		return "", err
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	id := base64.RawURLEncoding.EncodeToString(b)
+
+	err = s.repo.CreateChallenge(ctx, &models.WebAuthnChallenge{
+		ID:        id,
+		Ceremony:  ceremony,
+		UserID:    userID,
+		Data:      string(data),
+		ExpiresAt: time.Now().Add(s.challengeTTL),
+	})
+	if err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// takeChallenge consumes the challenge of a ceremony started for userID
+func (s *WebAuthnService) takeChallenge(ctx context.Context, ceremony, id, userID string) (*webauthn.SessionData, error) {
+	challenge, err := s.repo.ConsumeChallenge(ctx, id, ceremony)
+	if err != nil {
+		if errors.Is(err, repository.ErrWebAuthnChallengeNotFound) {
+			return nil, ErrWebAuthnChallengeExpired
+		}
+		return nil, err
+	}
+	if challenge.UserID != userID {
+		return nil, ErrWebAuthnChallengeExpired
+	}
+
+	var session webauthn.SessionData
+	if err := json.Unmarshal([]byte(challenge.Data), &session); err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// credentialName trims a name given to a credential, or picks one
+func credentialName(name string, synced bool) string {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		if synced {
+			return "Passkey"
+		}
+		return "Security key"
+	}
+	if r := []rune(name); len(r) > webauthnMaxNameLength {
+		name = string(r[:webauthnMaxNameLength])
+	}
+	return name
+}
+
+// verificationFailed hides the details of a rejected response from callers
+// while keeping them for logs
+func verificationFailed(err error) error {
+	var protocolErr *protocol.Error
+	if errors.As(err, &protocolErr) && protocolErr.DevInfo != "" {
+		return fmt.Errorf("%w: %s: %s", ErrWebAuthnVerificationFailed, protocolErr.Details, protocolErr.DevInfo)
+	}
+	return fmt.Errorf("%w: %v", ErrWebAuthnVerificationFailed, err)
+}
@@ -0,0 +1,333 @@
+package service
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/protocol/webauthncbor"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sso-project/sso-server/internal/database"
+	"github.com/sso-project/sso-server/internal/repository"
+	"github.com/sso-project/sso-server/internal/testutil"
+)
+
+const testOrigin = "https://sso.example.com"
+
+// softAuthenticator is a security key in software: an ES256 key pair that
+// answers registration and sign-in challenges with "none" attestation
+type softAuthenticator struct {
+	t            *testing.T
+	key          *ecdsa.PrivateKey
+	credentialID []byte
+	userHandle   []byte
+	origin       string
+	counter      uint32
+	verifyUser   bool
+	synced       bool
+}
+
+func newSoftAuthenticator(t *testing.T) *softAuthenticator {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	credentialID := make([]byte, 16)
+	_, err = rand.Read(credentialID)
+	require.NoError(t, err)
+
+	return &softAuthenticator{t: t, key: key, credentialID: credentialID, origin: testOrigin, verifyUser: true}
+}
+
+func (a *softAuthenticator) clientData(ceremony string, challenge []byte) []byte {
+	data, err := json.Marshal(map[string]string{
+		"type":      ceremony,
+		"challenge": base64.RawURLEncoding.EncodeToString(challenge),
+		"origin":    a.origin,
+	})
+	require.NoError(a.t, err)
+	return data
+}
+
+func (a *softAuthenticator) authData(rpID string, attested bool) []byte {
+	rpIDHash := sha256.Sum256([]byte(rpID))
+	flags := byte(0x01) // User present
+	if a.verifyUser {
+		flags |= 0x04
+	}
+	if a.synced {
+		flags |= 0x08 | 0x10
+	}
+	if attested {
+		flags |= 0x40
+	}
+
+	data := append(rpIDHash[:], flags)
+	data = binary.BigEndian.AppendUint32(data, a.counter)
+	if !attested {
+		return data
+	}
+
+	publicKey, err := webauthncbor.Marshal(map[int]interface{}{
+		1:  2,  // EC2
+		3:  -7, // ES256
+		-1: 1,  // P-256
+		-2: a.key.X.FillBytes(make([]byte, 32)),
+		-3: a.key.Y.FillBytes(make([]byte, 32)),
+	})
+	require.NoError(a.t, err)
+
+	data = append(data, make([]byte, 16)...) // AAGUID
+	data = binary.BigEndian.AppendUint16(data, uint16(len(a.credentialID)))
+	data = append(data, a.credentialID...)
+	return append(data, publicKey...)
+}
+
+// create answers navigator.credentials.create()
+func (a *softAuthenticator) create(options *protocol.CredentialCreation) []byte {
+	switch id := options.Response.User.ID.(type) {
+	case protocol.URLEncodedBase64:
+		a.userHandle = id
+	case []byte:
+		a.userHandle = id
+	}
+
+	attestation, err := webauthncbor.Marshal(map[string]interface{}{
+		"fmt":      "none",
+		"attStmt":  map[string]interface{}{},
+		"authData": a.authData(options.Response.RelyingParty.ID, true),
+	})
+	require.NoError(a.t, err)
+
+	return a.response(map[string]interface{}{
+		"clientDataJSON":    a.encode(a.clientData("webauthn.create", options.Response.Challenge)),
+		"attestationObject": a.encode(attestation),
+		"transports":        []string{"usb"},
+	})
+}
+
+// get answers navigator.credentials.get()
+func (a *softAuthenticator) get(options *protocol.CredentialAssertion) []byte {
+	a.counter++
+	authData := a.authData(options.Response.RelyingPartyID, false)
+	clientData := a.clientData("webauthn.get", options.Response.Challenge)
+
+	clientDataHash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(append([]byte{}, authData...), clientDataHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	require.NoError(a.t, err)
+
+	return a.response(map[string]interface{}{
+		"clientDataJSON":    a.encode(clientData),
+		"authenticatorData": a.encode(authData),
+		"signature":         a.encode(signature),
+		"userHandle":        a.encode(a.userHandle),
+	})
+}
+
+func (a *softAuthenticator) response(response map[string]interface{}) []byte {
+	data, err := json.Marshal(map[string]interface{}{
+		"id":       a.encode(a.credentialID),
+		"rawId":    a.encode(a.credentialID),
+		"type":     "public-key",
+		"response": response,
+	})
+	require.NoError(a.t, err)
+	return data
+}
+
+func (a *softAuthenticator) encode(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func newTestWebAuthnService(t *testing.T, db *database.DB) (*WebAuthnService, *repository.WebAuthnRepository) {
+	repo := repository.NewWebAuthnRepository(db.DB)
+	webauthnService, err := NewWebAuthnService(repo, repository.NewUserRepository(db), WebAuthnOptions{
+		RPName:       "SSO",
+		Origins:      []string{testOrigin + "/oauth2/login", ""},
+		ChallengeTTL: time.Minute,
+	})
+	require.NoError(t, err)
+	return webauthnService, repo
+}
+
+func TestWebAuthnService_Registration(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+	defer testutil.TeardownTestDB(t, db)
+
+	webauthnService, _ := newTestWebAuthnService(t, db)
+	ctx := context.Background()
+	user := testutil.CreateTestUser(t, db, "kate@example.com")
+	authenticator := newSoftAuthenticator(t)
+
+	options, challengeID, err := webauthnService.BeginRegistration(ctx, user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "sso.example.com", options.Response.RelyingParty.ID)
+	response := authenticator.create(options)
+
+	// The challenge belongs to the user who started
+	other := testutil.CreateTestUser(t, db, "leo@example.com")
+	_, err = webauthnService.FinishRegistration(ctx, other.ID, challengeID, "", response)
+	assert.ErrorIs(t, err, ErrWebAuthnChallengeExpired)
+
+	options, challengeID, err = webauthnService.BeginRegistration(ctx, user.ID)
+	require.NoError(t, err)
+	response = authenticator.create(options)
+	credential, err := webauthnService.FinishRegistration(ctx, user.ID, challengeID, "  YubiKey  ", response)
+	require.NoError(t, err)
+	assert.Equal(t, "YubiKey", credential.Name)
+	assert.Equal(t, base64.RawURLEncoding.EncodeToString(authenticator.credentialID), credential.CredentialID)
+	assert.Equal(t, []string{"usb"}, []string(credential.Transports))
+	assert.Equal(t, "none", credential.AttestationType)
+	assert.False(t, credential.BackupEligible)
+
+	// Challenges are single-use
+	_, err = webauthnService.FinishRegistration(ctx, user.ID, challengeID, "", response)
+	assert.ErrorIs(t, err, ErrWebAuthnChallengeExpired)
+
+	// The key is excluded from further registrations, and refused if it answers anyway
+	options, challengeID, err = webauthnService.BeginRegistration(ctx, user.ID)
+	require.NoError(t, err)
+	require.Len(t, options.Response.CredentialExcludeList, 1)
+	_, err = webauthnService.FinishRegistration(ctx, user.ID, challengeID, "", authenticator.create(options))
+	assert.ErrorIs(t, err, ErrWebAuthnCredentialExists)
+
+	// Responses from another origin are rejected
+	phished := newSoftAuthenticator(t)
+	phished.origin = "https://sso.example.com.evil.test"
+	options, challengeID, err = webauthnService.BeginRegistration(ctx, user.ID)
+	require.NoError(t, err)
+	_, err = webauthnService.FinishRegistration(ctx, user.ID, challengeID, "", phished.create(options))
+	assert.ErrorIs(t, err, ErrWebAuthnVerificationFailed)
+
+	// Synced passkeys are named as such by default
+	passkey := newSoftAuthenticator(t)
+	passkey.synced = true
+	options, challengeID, err = webauthnService.BeginRegistration(ctx, user.ID)
+	require.NoError(t, err)
+	credential, err = webauthnService.FinishRegistration(ctx, user.ID, challengeID, "", passkey.create(options))
+	require.NoError(t, err)
+	assert.Equal(t, "Passkey", credential.Name)
+	assert.True(t, credential.BackupEligible)
+
+	credentials, err := webauthnService.ListCredentials(ctx, user.ID)
+	require.NoError(t, err)
+	assert.Len(t, credentials, 2)
+
+	renamed, err := webauthnService.RenameCredential(ctx, user.ID, credential.ID, "Phone")
+	require.NoError(t, err)
+	assert.Equal(t, "Phone", renamed.Name)
+
+	assert.ErrorIs(t, webauthnService.DeleteCredential(ctx, other.ID, credential.ID), repository.ErrWebAuthnCredentialNotFound)
+	require.NoError(t, webauthnService.DeleteCredential(ctx, user.ID, credential.ID))
+	credentials, err = webauthnService.ListCredentials(ctx, user.ID)
+	require.NoError(t, err)
+	assert.Len(t, credentials, 1)
+}
+
+func TestWebAuthnService_Login(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+	defer testutil.TeardownTestDB(t, db)
+
+	webauthnService, repo := newTestWebAuthnService(t, db)
+	ctx := context.Background()
+	user := testutil.CreateTestUser(t, db, "mia@example.com")
+	authenticator := newSoftAuthenticator(t)
+
+	options, challengeID, err := webauthnService.BeginRegistration(ctx, user.ID)
+	require.NoError(t, err)
+	registered, err := webauthnService.FinishRegistration(ctx, user.ID, challengeID, "", authenticator.create(options))
+	require.NoError(t, err)
+
+	// Passwordless: the authenticator picks the account
+	assertion, challengeID, err := webauthnService.BeginLogin(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, assertion.Response.AllowedCredentials)
+	assert.Equal(t, protocol.VerificationRequired, assertion.Response.UserVerification)
+
+	signedIn, credential, err := webauthnService.FinishLogin(ctx, challengeID, authenticator.get(assertion))
+	require.NoError(t, err)
+	assert.Equal(t, user.ID, signedIn.ID)
+	assert.Equal(t, registered.ID, credential.ID)
+
+	stored, err := repo.GetCredential(ctx, user.ID, registered.ID)
+	require.NoError(t, err)
+	assert.Equal(t, uint32(1), stored.SignCount)
+	assert.NotNil(t, stored.LastUsedAt)
+
+	// Without user verification a passkey is only one factor
+	authenticator.verifyUser = false
+	assertion, challengeID, err = webauthnService.BeginLogin(ctx)
+	require.NoError(t, err)
+	_, _, err = webauthnService.FinishLogin(ctx, challengeID, authenticator.get(assertion))
+	assert.ErrorIs(t, err, ErrWebAuthnVerificationFailed)
+
+	// ...which is enough as second factor
+	assertion, challengeID, err = webauthnService.BeginSecondFactor(ctx, user.ID)
+	require.NoError(t, err)
+	require.Len(t, assertion.Response.AllowedCredentials, 1)
+	_, err = webauthnService.FinishSecondFactor(ctx, user.ID, challengeID, authenticator.get(assertion))
+	require.NoError(t, err)
+
+	other := testutil.CreateTestUser(t, db, "noah@example.com")
+	_, _, err = webauthnService.BeginSecondFactor(ctx, other.ID)
+	assert.ErrorIs(t, err, ErrNoWebAuthnCredentials)
+
+	// A counter that goes backwards marks the key as cloned, for good
+	authenticator.counter = 0
+	assertion, challengeID, err = webauthnService.BeginSecondFactor(ctx, user.ID)
+	require.NoError(t, err)
+	_, err = webauthnService.FinishSecondFactor(ctx, user.ID, challengeID, authenticator.get(assertion))
+	assert.ErrorIs(t, err, ErrWebAuthnCredentialCloned)
+
+	authenticator.counter = 10
+	assertion, challengeID, err = webauthnService.BeginSecondFactor(ctx, user.ID)
+	require.NoError(t, err)
+	_, err = webauthnService.FinishSecondFactor(ctx, user.ID, challengeID, authenticator.get(assertion))
+	assert.ErrorIs(t, err, ErrWebAuthnCredentialCloned)
+}
+
+func TestAuthService_Login_WebAuthnSecondFactor(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+	defer testutil.TeardownTestDB(t, db)
+
+	userRepo := repository.NewUserRepository(db)
+	sessionService := NewSessionService(repository.NewDatabaseSessionStore(db), 24*time.Hour)
+	authService := NewAuthService(userRepo, sessionService, repository.NewAuditLogRepository(db), 5, 30*time.Minute)
+	webauthnService, _ := newTestWebAuthnService(t, db)
+	authService.EnableWebAuthn(webauthnService)
+	ctx := context.Background()
+
+	user := testutil.CreateTestUserWithPassword(t, db, "olga@example.com", "TestPassword123!")
+	result, err := authService.Login(ctx, user.Email, "TestPassword123!", "127.0.0.1", "test")
+	require.NoError(t, err)
+	assert.False(t, result.RequiresTwoFactor)
+
+	authenticator := newSoftAuthenticator(t)
+	options, challengeID, err := webauthnService.BeginRegistration(ctx, user.ID)
+	require.NoError(t, err)
+	_, err = webauthnService.FinishRegistration(ctx, user.ID, challengeID, "", authenticator.create(options))
+	require.NoError(t, err)
+
+	result, err = authService.Login(ctx, user.Email, "TestPassword123!", "127.0.0.1", "test")
+	require.NoError(t, err)
+	assert.True(t, result.RequiresTwoFactor)
+	assert.Equal(t, []string{TwoFactorMethodWebAuthn}, result.TwoFactorMethods)
+
+	// A passkey sign-in needs no password, but the account must be usable
+	session, err := authService.LoginWithPasskey(ctx, user, "127.0.0.1", "test")
+	require.NoError(t, err)
+	assert.Equal(t, user.ID, session.UserID)
+
+	user.IsActive = false
+	_, err = authService.LoginWithPasskey(ctx, user, "127.0.0.1", "test")
+	assert.ErrorIs(t, err, ErrAccountInactive)
+}
@@ -9,6 +9,7 @@ import (
 	"gorm.io/gorm/logger"
 
 	"github.com/sso-project/sso-server/internal/database"
+	"github.com/sso-project/sso-server/internal/encryption"
 	"github.com/sso-project/sso-server/internal/models"
 )
 
@@ -16,6 +17,11 @@ import (
 func SetupTestDB(t *testing.T) *database.DB {
 	t.Helper()
 
+	// Encrypted columns need a keyring
+	keyring, err := encryption.NewKeyring(encryption.DerivedKeyProvider{Secret: "test-secret"})
+	require.NoError(t, err, "Failed to create test keyring")
+	encryption.SetKeyring(keyring)
+
 	// Use in-memory SQLite for fast, isolated tests
 	gormDB, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{
 		Logger: logger.Default.LogMode(logger.Silent), // Quiet during tests
@@ -32,8 +38,35 @@ func SetupTestDB(t *testing.T) *database.DB {
 		&models.Permission{},
 		&models.OAuthClient{},
 		&models.TwoFactorAuth{},
+		&models.WebAuthnCredential{},
+		&models.WebAuthnChallenge{},
+		&models.OTPFactor{},
+		&models.OTPCode{},
+		&models.SMSDelivery{},
 		&models.AuditLog{},
 		&models.SystemConfig{},
+		&models.OAuth2Client{},
+		&models.OAuth2Scope{},
+		&models.OAuth2AuthorizationCode{},
+		&models.OAuth2AccessToken{},
+		&models.OAuth2RefreshToken{},
+		&models.OAuth2Consent{},
+		&models.OAuth2ConsentHistory{},
+		&models.OAuth2PairwiseSubject{},
+		&models.ServiceAccount{},
+		&models.OAuth2TrustedIssuer{},
+		&models.OIDCConnection{},
+		&models.UserIdentity{},
+		&models.SAMLServiceProvider{},
+		&models.SAMLConnection{},
+		&models.SAMLAssertionID{},
+		&models.CASService{},
+		&models.CASTicket{},
+		&models.SCIMClient{},
+		&models.SCIMExternalID{},
+		&models.ProvisioningConnector{},
+		&models.ProvisioningJob{},
+		&models.ProvisioningState{},
 	)
 	require.NoError(t, err, "Failed to migrate test database")
 
@@ -65,15 +98,40 @@ func CleanupDB(t *testing.T, db *database.DB) {
 	// Delete all records from tables (order matters for foreign keys)
 	tables := []interface{}{
 		&models.AuditLog{},
+		&models.SCIMExternalID{},
+		&models.SCIMClient{},
+		&models.ProvisioningState{},
+		&models.ProvisioningJob{},
+		&models.ProvisioningConnector{},
 		&models.Session{},
 		&models.PasswordResetToken{},
 		&models.PasswordHistory{},
 		&models.TwoFactorAuth{},
+		&models.WebAuthnCredential{},
+		&models.WebAuthnChallenge{},
+		&models.OTPCode{},
+		&models.OTPFactor{},
+		&models.SMSDelivery{},
+		&models.UserIdentity{},
 		&models.User{},
 		&models.OAuthClient{},
 		&models.Role{},
 		&models.Permission{},
 		&models.SystemConfig{},
+		&models.SAMLServiceProvider{},
+		&models.SAMLConnection{},
+		&models.SAMLAssertionID{},
+		&models.OIDCConnection{},
+		&models.OAuth2ConsentHistory{},
+		&models.OAuth2Consent{},
+		&models.OAuth2PairwiseSubject{},
+		&models.OAuth2RefreshToken{},
+		&models.OAuth2AccessToken{},
+		&models.OAuth2AuthorizationCode{},
+		&models.OAuth2TrustedIssuer{},
+		&models.ServiceAccount{},
+		&models.OAuth2Client{},
+		&models.OAuth2Scope{},
 	}
 
 	for _, table := range tables {
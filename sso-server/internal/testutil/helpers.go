@@ -9,6 +9,7 @@ import (
 
 	"github.com/sso-project/sso-server/internal/database"
 	"github.com/sso-project/sso-server/internal/models"
+	"github.com/sso-project/sso-server/internal/repository"
 	"github.com/sso-project/sso-server/internal/utils"
 )
 
@@ -106,7 +107,8 @@ func CreateTestOAuth2Client(t *testing.T, db *database.DB) *models.OAuthClient {
 	return client
 }
 
-// CreateTestPasswordReset creates a test password reset token
+// CreateTestPasswordReset creates a test password reset token. The returned
+// Token is the one sent by email; its hash is stored.
 func CreateTestPasswordReset(t *testing.T, db *database.DB, userID, email string) *models.PasswordResetToken {
 	t.Helper()
 
@@ -117,7 +119,7 @@ func CreateTestPasswordReset(t *testing.T, db *database.DB, userID, email string
 		ID:        uuid.New().String(),
 		UserID:    userID,
 		Email:     email,
-		Token:     token,
+		Token:     repository.HashToken(token),
 		ExpiresAt: time.Now().Add(1 * time.Hour),
 		Used:      false,
 	}
@@ -125,6 +127,7 @@ func CreateTestPasswordReset(t *testing.T, db *database.DB, userID, email string
 	err = db.DB.Create(reset).Error
 	require.NoError(t, err, "Failed to create test password reset")
 
+	reset.Token = token
 	return reset
 }
 
@@ -211,3 +214,44 @@ func CreateLockedUser(t *testing.T, db *database.DB, email string) *models.User
 
 	return user
 }
+
+// CreateTestOAuth2Scope creates a test OAuth2 scope
+func CreateTestOAuth2Scope(t *testing.T, db *database.DB, name string, isDefault bool) *models.OAuth2Scope {
+	t.Helper()
+
+	scope := &models.OAuth2Scope{
+		ID:          uuid.New().String(),
+		Name:        name,
+		Description: "Test scope: " + name,
+		IsDefault:   isDefault,
+	}
+
+	err := db.DB.Create(scope).Error
+	require.NoError(t, err, "Failed to create test OAuth2 scope")
+
+	return scope
+}
+
+// CreateTestOAuth2ClientWithScopes creates an active confidential OAuth2 client (oauth2_clients table)
+func CreateTestOAuth2ClientWithScopes(t *testing.T, db *database.DB, clientID string, scopes []string) *models.OAuth2Client {
+	t.Helper()
+
+	hashedSecret, err := utils.HashPassword("test-client-secret")
+	require.NoError(t, err)
+
+	client := &models.OAuth2Client{
+		ID:            uuid.New().String(),
+		ClientID:      clientID,
+		ClientSecret:  hashedSecret,
+		Name:          "Test Client " + clientID,
+		RedirectURIs:  models.StringSlice{"http://localhost:3000/callback"},
+		AllowedScopes: scopes,
+		GrantTypes:    models.StringSlice{"authorization_code", "refresh_token", "client_credentials"},
+		IsActive:      true,
+	}
+
+	err = db.DB.Create(client).Error
+	require.NoError(t, err, "Failed to create test OAuth2 client")
+
+	return client
+}